@@ -1,80 +1,34 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
 	"strings"
-	"time"
 
-	"github.com/golang-jwt/jwt/v5"
+	"backend/common/oidc"
 )
 
-// validateAppleToken validates the Apple JWT token (simplified version)
-// In production, you should fetch Apple's public keys and validate properly
-func validateAppleToken(tokenString string) (*AppleClaims, error) {
-	log.Printf("🔍 Starting Apple token validation")
-	log.Printf("🎫 Token length: %d characters", len(tokenString))
-	log.Printf("🎫 Token preview: %s...", tokenString[:min(50, len(tokenString))])
-
-	// Parse token without verification for now (development only)
-	// In production, implement proper JWT verification with Apple's public keys
-	token, _, err := new(jwt.Parser).ParseUnverified(tokenString, &AppleClaims{})
+// validateAppleToken verifies the Apple identity token: its signature
+// against Apple's published keys, its issuer, its audience (our bundle and
+// service ids) and its expiry. Nothing else in the request identifies the
+// user.
+func validateAppleToken(ctx context.Context, tokenString string) (*oidc.Claims, error) {
+	claims, err := appleVerifier.Verify(ctx, tokenString)
 	if err != nil {
-		log.Printf("❌ Failed to parse token: %v", err)
-		return nil, fmt.Errorf("failed to parse token: %v", err)
-	}
-	log.Printf("✅ Token parsed successfully")
-
-	claims, ok := token.Claims.(*AppleClaims)
-	if !ok {
-		log.Printf("❌ Invalid token claims - type assertion failed")
-		return nil, fmt.Errorf("invalid token claims")
-	}
-	log.Printf("✅ Claims extracted successfully")
-
-	// Log claims details
-	log.Printf("🔍 Claims details:")
-	log.Printf("   - Subject: '%s'", claims.Subject)
-	log.Printf("   - Email: '%s'", claims.Email)
-	log.Printf("   - EmailVerified: %v", claims.EmailVerified)
-	log.Printf("   - Issuer: '%s'", claims.Issuer)
-	log.Printf("   - Audience: '%s'", claims.Audience)
-	log.Printf("   - Expiration: %d", claims.Expiration)
-	log.Printf("   - IssuedAt: %d", claims.IssuedAt)
-	log.Printf("   - Current time: %d", time.Now().Unix())
-
-	// Basic validation
-	if claims.Subject == "" {
-		log.Printf("❌ Missing subject in token")
-		return nil, fmt.Errorf("missing subject in token")
+		return nil, err
 	}
-	log.Printf("✅ Subject validation passed")
-
-	if claims.Expiration < time.Now().Unix() {
-		log.Printf("❌ Token expired - exp: %d, now: %d", claims.Expiration, time.Now().Unix())
-		return nil, fmt.Errorf("token expired")
-	}
-	log.Printf("✅ Expiration validation passed")
-
-	log.Printf("🎉 Apple token validation completed successfully")
+	log.Printf("✅ Apple token verified for subject %s", claims.Subject)
 	return claims, nil
 }
 
-// Helper function for min
-func min(a, b int) int {
-	if a < b {
-		return a
-	}
-	return b
-}
-
 // extractUserFromRequest extracts user information from Apple Sign-In request
-func extractUserFromRequest(req AppleSignInRequest, claims *AppleClaims) User {
+func extractUserFromRequest(req AppleSignInRequest, claims *oidc.Claims) User {
 	user := User{
 		AppleID:       sql.NullString{String: claims.Subject, Valid: claims.Subject != ""},
 		Email:         claims.Email,
-		VerifiedEmail: claims.EmailVerified,
+		VerifiedEmail: bool(claims.EmailVerified),
 		// Apple doesn't provide profile images, so initialize as empty
 		ProfileImageBlob: sql.NullString{String: "", Valid: false},
 	}
@@ -109,8 +63,8 @@ func extractUserFromRequest(req AppleSignInRequest, claims *AppleClaims) User {
 		}
 	}
 
-	// Use provided email if available (overrides token email)
-	if req.UserData.Email != "" {
+	// The email of the request is only used when the token has none
+	if user.Email == "" && req.UserData.Email != "" {
 		user.Email = req.UserData.Email
 		log.Printf("   - Email from user data: %s", req.UserData.Email)
 	}
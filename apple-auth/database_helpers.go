@@ -1,17 +1,28 @@
 package main
 
 import (
+	"database/sql"
 	"log"
 	"time"
+
+	"backend/common/identity"
 )
 
-// getUserByAppleID retrieves a user by their Apple ID
-func getUserByAppleID(appleID string) (*User, error) {
+// getUserByIdentity retrieves the user linked to an Apple ID through
+// user_identities
+func getUserByIdentity(appleID string) (*User, error) {
+	userID, err := identities.Find(identity.ProviderApple, appleID)
+	if err == identity.ErrNotFound {
+		return nil, sql.ErrNoRows
+	} else if err != nil {
+		return nil, err
+	}
+
 	var user User
-	err := db.QueryRow(`
+	err = db.QueryRow(`
 		SELECT id, apple_id, google_id, email, name, given_name, family_name, 
 		picture, profile_image_blob, locale, verified_email, created_at, updated_at 
-		FROM users WHERE apple_id = ?`, appleID).Scan(
+		FROM users WHERE id = ?`, userID).Scan(
 		&user.ID,
 		&user.AppleID,
 		&user.GoogleID,
@@ -53,9 +64,16 @@ func getUserByEmail(email string) (*User, error) {
 	return &user, err
 }
 
-// createAppleUser creates a new user with Apple Sign-In data
+// createAppleUser creates a new user with Apple Sign-In data and its Apple
+// identity in one transaction
 func createAppleUser(user User) (*User, error) {
-	result, err := db.Exec(`
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
 		INSERT INTO users (
 			apple_id, email, name, given_name, family_name, 
 			picture, profile_image_blob, locale, verified_email
@@ -73,17 +91,18 @@ func createAppleUser(user User) (*User, error) {
 	}
 
 	user.ID = int(id)
+	if err := identities.LinkTx(tx, user.ID, identity.ProviderApple, user.AppleID.String, user.Email); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
 	user.CreatedAt = time.Now()
 	user.UpdatedAt = time.Now()
 	return &user, nil
 }
 
-// linkAppleIDToUser links an Apple ID to an existing user account
-func linkAppleIDToUser(userID int, appleID string) error {
-	_, err := db.Exec("UPDATE users SET apple_id = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", appleID, userID)
-	return err
-}
-
 // updateUserLastLogin updates the last login timestamp for a user
 func updateUserLastLogin(userID int) {
 	_, err := db.Exec("UPDATE users SET updated_at = CURRENT_TIMESTAMP WHERE id = ?", userID)
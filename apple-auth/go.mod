@@ -3,6 +3,8 @@ module apple-auth
 go 1.21
 
 require (
-	github.com/golang-jwt/jwt/v5 v5.2.0
-	github.com/mattn/go-sqlite3 v1.14.22
+	backend/common v0.0.0
+	github.com/mattn/go-sqlite3 v1.14.27
 )
+
+replace backend/common => ../common
@@ -9,11 +9,19 @@ import (
 	"path/filepath"
 	"time"
 
+	"backend/common/auth"
+	"backend/common/identity"
+	"backend/common/oidc"
+
 	_ "github.com/mattn/go-sqlite3"
 )
 
 var (
-	db *sql.DB
+	db         *sql.DB
+	sessions   *auth.Manager
+	identities *identity.Store
+	// appleVerifier checks identity tokens for the ids in APPLE_CLIENT_IDS
+	appleVerifier *oidc.Verifier
 )
 
 func init() {
@@ -44,6 +52,23 @@ func init() {
 }
 
 func main() {
+	// Sessions are shared with signin and google_auth through auth_sessions
+	var err error
+	sessions, err = auth.NewManagerFromEnv(db)
+	if err != nil {
+		log.Fatalf("Failed to initialize sessions: %v", err)
+	}
+
+	identities, err = identity.NewStore(db)
+	if err != nil {
+		log.Fatalf("Failed to initialize identities: %v", err)
+	}
+
+	appleVerifier = oidc.AppleVerifierFromEnv()
+	if !appleVerifier.Configured() {
+		log.Printf("APPLE_CLIENT_IDS is not set: Apple sign-in requests will be rejected")
+	}
+
 	// Set up CORS middleware
 	http.HandleFunc("/auth/apple", corsMiddleware(handleAppleAuth))
 	http.HandleFunc("/health", corsMiddleware(handleHealth))
@@ -99,8 +124,13 @@ func handleAppleAuth(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Parse and validate the Apple JWT token
-	claims, err := validateAppleToken(req.IdentityToken)
+	if !appleVerifier.Configured() {
+		sendErrorResponse(w, "Apple Sign-In is not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	// Verify the Apple identity token before trusting any of its claims
+	claims, err := validateAppleToken(r.Context(), req.IdentityToken)
 	if err != nil {
 		log.Printf("Failed to validate Apple token: %v", err)
 		sendErrorResponse(w, "Invalid Apple token", http.StatusUnauthorized)
@@ -112,8 +142,8 @@ func handleAppleAuth(w http.ResponseWriter, r *http.Request) {
 
 	log.Printf("Processing Apple Sign-In for user: %s (Apple ID: %s)", user.Email, user.AppleID.String)
 
-	// Check if user exists in database
-	existingUser, err := getUserByAppleID(user.AppleID.String)
+	// Only the verified subject identifies the Apple account, as in profile_management
+	existingUser, err := getUserByIdentity(claims.Subject)
 	if err != nil && err != sql.ErrNoRows {
 		log.Printf("Database error checking user: %v", err)
 		sendErrorResponse(w, "Database error", http.StatusInternalServerError)
@@ -121,28 +151,20 @@ func handleAppleAuth(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err == sql.ErrNoRows {
-		// Check if user exists with same email (different auth method)
-		existingEmailUser, emailErr := getUserByEmail(user.Email)
-		if emailErr != nil && emailErr != sql.ErrNoRows {
-			log.Printf("Database error checking email: %v", emailErr)
-			sendErrorResponse(w, "Database error", http.StatusInternalServerError)
-			return
-		}
-
-		if emailErr == nil {
-			// User exists with same email, link Apple ID to existing account
-			err = linkAppleIDToUser(existingEmailUser.ID, user.AppleID.String)
-			if err != nil {
-				log.Printf("Failed to link Apple ID to existing user: %v", err)
-				sendErrorResponse(w, "Failed to link account", http.StatusInternalServerError)
+		// An existing account with the same email is not linked silently:
+		// its owner has to sign in and link Apple from their profile.
+		if user.Email != "" {
+			_, emailErr := getUserByEmail(user.Email)
+			if emailErr != nil && emailErr != sql.ErrNoRows {
+				log.Printf("Database error checking email: %v", emailErr)
+				sendErrorResponse(w, "Database error", http.StatusInternalServerError)
+				return
+			}
+			if emailErr == nil {
+				log.Printf("Apple sign-in for %s conflicts with an existing account", user.Email)
+				sendErrorResponse(w, "An account with this email already exists. Sign in and link Apple from your profile.", http.StatusConflict)
 				return
 			}
-
-			// Update user info and return
-			existingEmailUser.AppleID = user.AppleID
-			updateUserLastLogin(existingEmailUser.ID)
-			sendSuccessResponse(w, "Account linked successfully", existingEmailUser)
-			return
 		}
 
 		// Create new user
@@ -154,14 +176,17 @@ func handleAppleAuth(w http.ResponseWriter, r *http.Request) {
 		}
 
 		log.Printf("Created new Apple user: %s", newUser.Email)
-		sendSuccessResponse(w, "User created successfully", newUser)
+		sendSessionResponse(w, r, "User created successfully", newUser)
 		return
 	}
 
 	// User exists, update last login and return user data
 	updateUserLastLogin(existingUser.ID)
+	if err := identities.Touch(identity.ProviderApple, claims.Subject); err != nil {
+		log.Printf("Failed to update identity: %v", err)
+	}
 	log.Printf("Apple user logged in: %s", existingUser.Email)
-	sendSuccessResponse(w, "Login successful", existingUser)
+	sendSessionResponse(w, r, "Login successful", existingUser)
 }
 
 // userToJSON converts a User with sql.NullString fields to a JSON-friendly format
@@ -202,12 +227,21 @@ func userToJSON(user *User) map[string]interface{} {
 	return result
 }
 
-func sendSuccessResponse(w http.ResponseWriter, message string, user *User) {
+// sendSessionResponse opens a session for user and returns it with the user data
+func sendSessionResponse(w http.ResponseWriter, r *http.Request, message string, user *User) {
+	tokens, err := sessions.IssueTokens(user.ID, r.UserAgent(), auth.ClientIP(r))
+	if err != nil {
+		log.Printf("Failed to issue session tokens: %v", err)
+		sendErrorResponse(w, "Could not start session", http.StatusInternalServerError)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(AppleSignInResponse{
 		Success: true,
 		Message: message,
 		User:    userToJSON(user),
+		Session: tokens,
 	})
 }
 
@@ -4,7 +4,7 @@ import (
 	"database/sql"
 	"time"
 
-	"github.com/golang-jwt/jwt/v5"
+	"backend/common/auth"
 )
 
 // User represents a user in the database
@@ -24,21 +24,6 @@ type User struct {
 	UpdatedAt        time.Time      `json:"updated_at"`
 }
 
-// AppleClaims represents the claims in Apple's JWT token
-type AppleClaims struct {
-	Issuer         string `json:"iss"`
-	Audience       string `json:"aud"`
-	Expiration     int64  `json:"exp"`
-	IssuedAt       int64  `json:"iat"`
-	Subject        string `json:"sub"`
-	Email          string `json:"email"`
-	EmailVerified  bool   `json:"email_verified"`
-	IsPrivateEmail bool   `json:"is_private_email"`
-	RealUserStatus int    `json:"real_user_status"`
-	TransferSub    string `json:"transfer_sub"`
-	jwt.RegisteredClaims
-}
-
 // AppleSignInRequest represents the request payload for Apple Sign-In
 type AppleSignInRequest struct {
 	IdentityToken string `json:"identityToken"`
@@ -55,7 +40,8 @@ type AppleSignInRequest struct {
 
 // AppleSignInResponse represents the response for Apple Sign-In
 type AppleSignInResponse struct {
-	Success bool        `json:"success"`
-	Message string      `json:"message,omitempty"`
-	User    interface{} `json:"user,omitempty"`
+	Success bool            `json:"success"`
+	Message string          `json:"message,omitempty"`
+	User    interface{}     `json:"user,omitempty"`
+	Session *auth.TokenPair `json:"session,omitempty"`
 }
@@ -32,6 +32,10 @@ func fetchBillsForPeriod(userID, period, date string) ([]BillWithPeriodStatus, e
 			b.id, b.user_id, b.name, b.amount, b.start_date, b.payment_day, 
 			b.duration_months, b.regularity, b.recurring, b.category, b.icon, 
 			COALESCE(b.payment_method, 'cash') as payment_method,
+			COALESCE(b.account_id, 0) as account_id,
+			COALESCE(b.currency, '') as currency,
+			COALESCE(b.base_amount, b.amount) as base_amount,
+			COALESCE(b.statement_account_id, 0) as statement_account_id,
 			COALESCE(b.created_at, '') as created_at, 
 			COALESCE(b.updated_at, '') as updated_at,
 			COALESCE(bp.paid, 0) as period_paid,
@@ -83,7 +87,8 @@ func fetchBillsForPeriod(userID, period, date string) ([]BillWithPeriodStatus, e
 			&billData.ID, &billData.UserID, &billData.Name, &billData.Amount,
 			&billData.StartDate, &billData.PaymentDay, &billData.DurationMonths,
 			&billData.Regularity, &billData.Recurring, &billData.Category,
-			&billData.Icon, &billData.PaymentMethod, &billData.CreatedAt,
+			&billData.Icon, &billData.PaymentMethod, &billData.AccountID, &billData.Currency,
+			&billData.BaseAmount, &billData.StatementAccountID, &billData.CreatedAt,
 			&billData.UpdatedAt, &periodPaid, &billData.SpecificDate,
 		)
 		if err != nil {
@@ -184,7 +189,12 @@ func convertBillWithPeriodStatusToBill(billWithStatus BillWithPeriodStatus) Bill
 		Category:       billWithStatus.Category,
 		Icon:           billWithStatus.Icon,
 		PaymentMethod:  billWithStatus.PaymentMethod,
+		AccountID:      billWithStatus.AccountID,
+		Currency:       billWithStatus.Currency,
+		BaseAmount:     billWithStatus.BaseAmount,
 		CreatedAt:      billWithStatus.CreatedAt,
 		UpdatedAt:      billWithStatus.UpdatedAt,
+
+		StatementAccountID: billWithStatus.StatementAccountID,
 	}
 }
@@ -5,6 +5,10 @@ import (
 	"fmt"
 	"log"
 	"time"
+
+	"backend/common/journal"
+	"backend/common/ledger"
+	"backend/common/money"
 )
 
 // PayBillRequest represents the request structure for paying a bill
@@ -17,19 +21,30 @@ type PayBillRequest struct {
 
 // PayBillResponse represents the response structure for bill payment
 type PayBillResponse struct {
-	BillID            int     `json:"bill_id"`
-	UserID            string  `json:"user_id"`
-	YearMonth         string  `json:"year_month"`
-	PaymentDate       string  `json:"payment_date"`
-	Amount            float64 `json:"amount"`
-	PaymentMethod     string  `json:"payment_method"`
-	BillFullyPaid     bool    `json:"bill_fully_paid"`
-	RemainingPayments int     `json:"remaining_payments"`
+	BillID            int         `json:"bill_id"`
+	UserID            string      `json:"user_id"`
+	YearMonth         string      `json:"year_month"`
+	PaymentDate       string      `json:"payment_date"`
+	Amount            money.Money `json:"amount"`
+	Currency          string      `json:"currency"`
+	BaseAmount        money.Money `json:"base_amount"`
+	PaymentMethod     string      `json:"payment_method"`
+	AccountID         int64       `json:"account_id"`
+	BillFullyPaid     bool        `json:"bill_fully_paid"`
+	RemainingPayments int         `json:"remaining_payments"`
+}
+
+// BillPayment es el estado de un mes de una factura en el journal
+type BillPayment struct {
+	BillID      int    `json:"bill_id"`
+	YearMonth   string `json:"year_month"`
+	Paid        bool   `json:"paid"`
+	PaymentDate string `json:"payment_date,omitempty"`
 }
 
-// markBillPaid marca una factura como pagada para un mes específico
-// y actualiza la cascada de balances
-func markBillPaid(db *sql.DB, billID int, userID, yearMonth, paymentDate string) (*PayBillResponse, error) {
+// markBillPaid marca una factura como pagada para un mes específico en
+// nombre de actor y actualiza la cascada de balances
+func markBillPaid(db *sql.DB, billID int, userID, yearMonth, paymentDate, actor string) (*PayBillResponse, error) {
 	// Si no se proporciona fecha de pago, usar la fecha actual
 	if paymentDate == "" {
 		paymentDate = time.Now().Format("2006-01-02")
@@ -42,26 +57,23 @@ func markBillPaid(db *sql.DB, billID int, userID, yearMonth, paymentDate string)
 	defer tx.Rollback()
 
 	// 1. Obtener datos de la factura y el locale del usuario
-	var amount float64
-	var paymentMethod, category, locale string
+	var amount, baseAmount money.Money
+	var paymentMethod, category, locale, billCurrency string
+	var accountID, statementAccountID int64
 	err = tx.QueryRow(`
-		SELECT b.amount, b.payment_method, b.category, COALESCE(u.locale, 'en') as locale
+		SELECT b.amount, COALESCE(b.base_amount, b.amount), COALESCE(b.currency, ''), b.payment_method, COALESCE(b.account_id, 0),
+		       COALESCE(b.statement_account_id, 0), b.category, COALESCE(u.locale, 'en') as locale
 		FROM bills b
 		JOIN users u ON b.user_id = CAST(u.id AS TEXT)
 		WHERE b.id = ? AND b.user_id = ?
-	`, billID, userID).Scan(&amount, &paymentMethod, &category, &locale)
+	`, billID, userID).Scan(&amount, &baseAmount, &billCurrency, &paymentMethod, &accountID, &statementAccountID, &category, &locale)
 	if err != nil {
 		return nil, fmt.Errorf("bill not found: %v", err)
 	}
 
-	// Commit the current transaction to check for bill_payments
-	if err = tx.Commit(); err != nil {
-		return nil, fmt.Errorf("error committing initial transaction: %v", err)
-	}
-
 	// 2. Verificar si existen registros de bill_payments, crear si no existen
 	var paymentCount int
-	err = db.QueryRow(`
+	err = tx.QueryRow(`
 		SELECT COUNT(*) FROM bill_payments WHERE bill_id = ?
 	`, billID).Scan(&paymentCount)
 	if err != nil {
@@ -70,19 +82,12 @@ func markBillPaid(db *sql.DB, billID int, userID, yearMonth, paymentDate string)
 
 	if paymentCount == 0 {
 		log.Printf("No payment records found for bill %d, creating retroactive records", billID)
-		err = createBillPaymentRecordsRetroactive(db, billID)
+		err = createBillPaymentRecordsRetroactive(tx, billID)
 		if err != nil {
 			return nil, fmt.Errorf("error creating retroactive payment records: %v", err)
 		}
 	}
 
-	// Start new transaction for the actual payment
-	tx, err = db.Begin()
-	if err != nil {
-		return nil, fmt.Errorf("error starting payment transaction: %v", err)
-	}
-	defer tx.Rollback()
-
 	// 3. Verificar que el pago existe y no está pagado
 	var alreadyPaid bool
 	err = tx.QueryRow(`
@@ -106,16 +111,36 @@ func markBillPaid(db *sql.DB, billID int, userID, yearMonth, paymentDate string)
 		return nil, fmt.Errorf("error marking payment as paid: %v", err)
 	}
 
-	// 4. Restar el bill_amount para este mes específico en monthly_cash_bank_balance
-	err = removeBillAmountFromMonth(tx, userID, yearMonth, amount, paymentMethod)
-	if err != nil {
-		return nil, fmt.Errorf("error removing bill amount: %v", err)
-	}
+	change := journal.Change{Actor: actor, Entity: "bill_payment", EntityID: int64(billID),
+		Before: BillPayment{BillID: billID, YearMonth: yearMonth},
+		After:  BillPayment{BillID: billID, YearMonth: yearMonth, Paid: true, PaymentDate: paymentDate}}
+	if statementAccountID != 0 {
+		// El extracto de una tarjeta ya está gastado: pagarlo mueve el dinero
+		// del banco a la tarjeta, sin expense
+		entries := statementPaymentEntries(userID, paymentMethod, accountID, statementAccountID, baseAmount, paymentDate)
+		if err := balances.PostTx(tx, entries...); err != nil {
+			return nil, fmt.Errorf("error moving statement payment to the card in period balances: %v", err)
+		}
+		change.Posted = entries
+	} else {
+		// 5. Crear registro en expenses para el pago de la factura
+		err = createExpenseRecord(tx, userID, category, paymentDate, paymentMethod, accountID, locale, billID, amount, billCurrency, baseAmount)
+		if err != nil {
+			return nil, fmt.Errorf("error creating expense record: %v", err)
+		}
 
-	// 5. Crear registro en expenses para el pago de la factura
-	err = createExpenseRecord(tx, userID, category, paymentDate, paymentMethod, locale, billID, amount)
-	if err != nil {
-		return nil, fmt.Errorf("error creating expense record: %v", err)
+		// El mes deja de ser un bill pendiente y pasa a ser un expense en la fecha
+		// de pago, por el mismo importe en la moneda base
+		bill := ledger.Entry{UserID: userID, Kind: ledger.Bill, Method: paymentMethod, Account: accountID, Amount: baseAmount, Date: ledger.BillDate(yearMonth)}
+		payment := bill
+		payment.Kind, payment.Date = ledger.Expense, paymentDate
+		if err := balances.AmendTx(tx, bill, payment); err != nil {
+			return nil, fmt.Errorf("error moving paid bill to expenses in period balances: %v", err)
+		}
+		change.Reversed, change.Posted = []ledger.Entry{bill}, []ledger.Entry{payment}
+	}
+	if err := audit.RecordTx(tx, userID, change); err != nil {
+		return nil, err
 	}
 
 	// 6. Verificar si todos los pagos están completados
@@ -146,19 +171,17 @@ func markBillPaid(db *sql.DB, billID int, userID, yearMonth, paymentDate string)
 		return nil, fmt.Errorf("error committing transaction: %v", err)
 	}
 
-	// 9. NO recalcular cascada para el mes del pago
-	// Solo se modifican bill_*_amount y expense_*_amount
-	// Las columnas principales de balance (bank_amount, cash_amount, etc.) no se tocan
-	log.Printf("Payment processed for month %s - only bill_*_amount and expense_*_amount updated", yearMonth)
-
-	// 10. Prepare response
+	// 9. Prepare response
 	response := &PayBillResponse{
 		BillID:            billID,
 		UserID:            userID,
 		YearMonth:         yearMonth,
 		PaymentDate:       paymentDate,
 		Amount:            amount,
+		Currency:          billCurrency,
+		BaseAmount:        baseAmount,
 		PaymentMethod:     paymentMethod,
+		AccountID:         accountID,
 		BillFullyPaid:     billFullyPaid,
 		RemainingPayments: totalPayments - paidPayments,
 	}
@@ -166,36 +189,6 @@ func markBillPaid(db *sql.DB, billID int, userID, yearMonth, paymentDate string)
 	return response, nil
 }
 
-// removeBillAmountFromMonth resta el importe del bill de las columnas bill_*
-// y suma el importe a las columnas expense_* en monthly_cash_bank_balance
-func removeBillAmountFromMonth(tx *sql.Tx, userID, yearMonth string, amount float64, paymentMethod string) error {
-	if paymentMethod == "cash" {
-		_, err := tx.Exec(`
-			UPDATE monthly_cash_bank_balance
-			SET bill_cash_amount = bill_cash_amount - ?,
-			    expense_cash_amount = expense_cash_amount + ?
-			WHERE user_id = ? AND year_month = ?
-		`, amount, amount, userID, yearMonth)
-		if err != nil {
-			return fmt.Errorf("error updating bill_cash_amount and expense_cash_amount: %v", err)
-		}
-	} else {
-		_, err := tx.Exec(`
-			UPDATE monthly_cash_bank_balance
-			SET bill_bank_amount = bill_bank_amount - ?,
-			    expense_bank_amount = expense_bank_amount + ?
-			WHERE user_id = ? AND year_month = ?
-		`, amount, amount, userID, yearMonth)
-		if err != nil {
-			return fmt.Errorf("error updating bill_bank_amount and expense_bank_amount: %v", err)
-		}
-	}
-
-	log.Printf("Moved bill amount %.2f from bill_%s to expense_%s for month %s (user %s)",
-		amount, paymentMethod, paymentMethod, yearMonth, userID)
-	return nil
-}
-
 // validatePayBillRequest valida los datos de la request de pago
 func validatePayBillRequest(req PayBillRequest) error {
 	if req.UserID == "" {
@@ -228,7 +221,7 @@ func validatePayBillRequest(req PayBillRequest) error {
 // getBillPaymentStatus obtiene el estado de pagos de un bill específico
 func getBillPaymentStatus(db *sql.DB, billID int, userID string) (map[string]interface{}, error) {
 	// Obtener información básica del bill
-	var billAmount float64
+	var billAmount money.Money
 	var billName string
 	var durationMonths int
 	err := db.QueryRow(`
@@ -297,18 +290,14 @@ func getBillPaymentStatus(db *sql.DB, billID int, userID string) (map[string]int
 
 // createBillPaymentRecords crea registros en bill_payments para un bill nuevo
 // Esta función se debe llamar cuando se crea un bill
-func createBillPaymentRecords(db *sql.DB, billID int, userID string, startDate string, durationMonths int, paymentMethod string) error {
+func createBillPaymentRecords(tx *sql.Tx, billID int, userID string, startDate string, durationMonths int, paymentMethod string) error {
 	// Parse start date
 	currentDate, err := time.Parse("2006-01-02", startDate)
 	if err != nil {
 		return fmt.Errorf("invalid start date format: %v", err)
 	}
-
-	tx, err := db.Begin()
-	if err != nil {
-		return fmt.Errorf("error starting transaction: %v", err)
-	}
-	defer tx.Rollback()
+	// Contar desde el día 1 para que un inicio el 31 no se salte febrero
+	currentDate = time.Date(currentDate.Year(), currentDate.Month(), 1, 0, 0, 0, 0, time.UTC)
 
 	// Crear un registro de bill_payments para cada mes de duración
 	for i := 0; i < durationMonths; i++ {
@@ -324,11 +313,6 @@ func createBillPaymentRecords(db *sql.DB, billID int, userID string, startDate s
 		}
 	}
 
-	err = tx.Commit()
-	if err != nil {
-		return fmt.Errorf("error committing bill payment records: %v", err)
-	}
-
 	log.Printf("Created %d bill payment records for bill %d starting from %s",
 		durationMonths, billID, startDate)
 	return nil
@@ -336,11 +320,11 @@ func createBillPaymentRecords(db *sql.DB, billID int, userID string, startDate s
 
 // createBillPaymentRecordsRetroactive crea registros de bill_payments retroactivos
 // para bills existentes que no los tienen
-func createBillPaymentRecordsRetroactive(db *sql.DB, billID int) error {
+func createBillPaymentRecordsRetroactive(tx *sql.Tx, billID int) error {
 	// Obtener información del bill
 	var userID, startDate, paymentMethod string
 	var durationMonths int
-	err := db.QueryRow(`
+	err := tx.QueryRow(`
 		SELECT user_id, start_date, duration_months, payment_method
 		FROM bills WHERE id = ?
 	`, billID).Scan(&userID, &startDate, &durationMonths, &paymentMethod)
@@ -350,7 +334,7 @@ func createBillPaymentRecordsRetroactive(db *sql.DB, billID int) error {
 
 	// Verificar si ya tiene registros de bill_payments
 	var count int
-	err = db.QueryRow(`
+	err = tx.QueryRow(`
 		SELECT COUNT(*) FROM bill_payments WHERE bill_id = ?
 	`, billID).Scan(&count)
 	if err != nil {
@@ -363,7 +347,7 @@ func createBillPaymentRecordsRetroactive(db *sql.DB, billID int) error {
 	}
 
 	// Crear registros retroactivos
-	err = createBillPaymentRecords(db, billID, userID, startDate, durationMonths, paymentMethod)
+	err = createBillPaymentRecords(tx, billID, userID, startDate, durationMonths, paymentMethod)
 	if err != nil {
 		return fmt.Errorf("error creating retroactive payment records: %v", err)
 	}
@@ -402,21 +386,21 @@ func getPaymentDescription(locale, category, date string) string {
 }
 
 // createExpenseRecord crea un registro en la tabla expenses para el pago de la factura
-func createExpenseRecord(tx *sql.Tx, userID, category, paymentDate, paymentMethod, locale string, billID int, amount float64) error {
+func createExpenseRecord(tx *sql.Tx, userID, category, paymentDate, paymentMethod string, accountID int64, locale string, billID int, amount money.Money, billCurrency string, baseAmount money.Money) error {
 	// Crear la descripción del pago
 	description := getPaymentDescription(locale, category, paymentDate)
 
 	// Insertar el registro en expenses
 	_, err := tx.Exec(`
-		INSERT INTO expenses (user_id, amount, date, category, payment_method, description, bill_id)
-		VALUES (?, ?, ?, ?, ?, ?, ?)
-	`, userID, amount, paymentDate, category, paymentMethod, description, billID)
+		INSERT INTO expenses (user_id, amount, date, category, payment_method, account_id, description, bill_id, currency, base_amount)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, userID, amount, paymentDate, category, paymentMethod, accountID, description, billID, billCurrency, baseAmount)
 
 	if err != nil {
 		return fmt.Errorf("error creating expense record: %v", err)
 	}
 
-	log.Printf("Created expense record for bill payment: %s (amount: %.2f, bill_id: %d)",
+	log.Printf("Created expense record for bill payment: %s (amount: %s, bill_id: %d)",
 		description, amount, billID)
 	return nil
 }
@@ -5,6 +5,11 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+
+	"backend/common/journal"
+	"backend/common/money"
+	"backend/common/tag"
+	"backend/common/trash"
 )
 
 // DeleteBillRequest represents the request structure for deleting a bill
@@ -17,16 +22,13 @@ type DeleteBillRequest struct {
 type BillData struct {
 	ID            int
 	UserID        string
-	Amount        float64
+	Amount        money.Money // en la moneda base
 	PaymentMethod string
+	AccountID     int64
 	StartDate     string
 	Duration      int
-}
-
-// ExpenseMonth represents a month where the bill has associated expenses
-type ExpenseMonth struct {
-	YearMonth string
-	Date      string
+	// StatementAccountID es la tarjeta si la factura paga un extracto
+	StatementAccountID int64
 }
 
 // handleDeleteBill handles the HTTP request to delete a bill
@@ -50,11 +52,15 @@ func handleDeleteBill(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Execute deletion
-	if err := deleteBill(deleteRequest); err != nil {
+	if err := deleteBill(deleteRequest, journal.Actor(r)); err != nil {
 		if err.Error() == "bill not found" {
 			sendErrorResponse(w, "Bill not found or you don't have permission to delete it", http.StatusNotFound)
 			return
 		}
+		if err == errStatementBill {
+			sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		log.Printf("Error deleting bill: %v", err)
 		sendErrorResponse(w, "Error deleting bill", http.StatusInternalServerError)
 		return
@@ -78,8 +84,9 @@ func validateDeleteBillRequest(request DeleteBillRequest) error {
 	return nil
 }
 
-// deleteBill performs the actual deletion of a bill and related data
-func deleteBill(request DeleteBillRequest) error {
+// deleteBill moves a bill and its payments to the trash on behalf of
+// actor
+func deleteBill(request DeleteBillRequest, actor string) error {
 	// Check if bill exists and belongs to the user
 	if err := verifyBillOwnership(request.BillID, request.UserID); err != nil {
 		return err
@@ -90,20 +97,52 @@ func deleteBill(request DeleteBillRequest) error {
 	if err != nil {
 		return err
 	}
+	if billData.StatementAccountID != 0 {
+		return errStatementBill
+	}
 
-	// Update monthly cash bank balance before deleting the bill
-	if err := updateMonthlyBalanceForDeletedBill(billData); err != nil {
+	// The balances, the payments and the bill go together or not at all
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	bill, err := getBillOldData(tx, request.BillID, request.UserID)
+	if err != nil {
+		return err
+	}
+
+	// Take the unpaid months out of the period balances before deleting the bill
+	reversed, err := updateMonthlyBalanceForDeletedBill(tx, billData)
+	if err != nil {
 		log.Printf("Error updating monthly balance for deleted bill: %v", err)
 		return err
 	}
 
-	// Delete related bill_payments first
-	if err := deleteBillPayments(request.BillID); err != nil {
+	// Delete the bill, then its bill_payments
+	rows, err := deleteBillRecord(tx, request.BillID, request.UserID)
+	if err != nil {
+		return err
+	}
+	payments, err := deleteBillPayments(tx, request.BillID)
+	if err != nil {
+		return err
+	}
+
+	err = audit.RecordTx(tx, request.UserID, journal.Change{Actor: actor, Entity: "bill", EntityID: int64(request.BillID),
+		Before: bill, Reversed: reversed})
+	if err != nil {
 		return err
 	}
 
-	// Delete the bill
-	return deleteBillRecord(request.BillID, request.UserID)
+	_, err = bin.PutTx(tx, trash.Item{UserID: request.UserID, Entity: "bill", EntityID: int64(request.BillID),
+		Rows: append(rows, payments...), Reversed: reversed})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 // verifyBillOwnership checks if the bill exists and belongs to the user
@@ -123,36 +162,37 @@ func verifyBillOwnership(billID int, userID string) error {
 }
 
 // deleteBillPayments removes all payment records associated with a bill
-func deleteBillPayments(billID int) error {
-	deletePaymentsQuery := "DELETE FROM bill_payments WHERE bill_id = ?"
-	_, err := db.Exec(deletePaymentsQuery, billID)
+// and returns them for the trash
+func deleteBillPayments(tx *sql.Tx, billID int) ([]trash.Row, error) {
+	rows, err := trash.TakeTx(tx, "bill_payments", "bill_id = ?", billID)
 	if err != nil {
 		log.Printf("Error deleting bill payments: %v", err)
-		return err
+		return nil, err
 	}
-	return nil
+	return rows, nil
 }
 
-// deleteBillRecord removes the bill record from the database
-func deleteBillRecord(billID int, userID string) error {
-	deleteBillQuery := "DELETE FROM bills WHERE id = ? AND user_id = ?"
-	result, err := db.Exec(deleteBillQuery, billID, userID)
+// deleteBillRecord removes the bill record from the database and returns
+// it for the trash
+func deleteBillRecord(tx *sql.Tx, billID int, userID string) ([]trash.Row, error) {
+	// Its tags go to the trash with it, before the delete drops them
+	tags, err := tag.TakeTx(tx, "bill", int64(billID))
 	if err != nil {
-		log.Printf("Error deleting bill: %v", err)
-		return err
+		log.Printf("Error deleting bill tags: %v", err)
+		return nil, err
 	}
 
-	rowsAffected, err := result.RowsAffected()
+	rows, err := trash.TakeTx(tx, "bills", "id = ? AND user_id = ?", billID, userID)
 	if err != nil {
-		log.Printf("Error getting rows affected: %v", err)
-		return err
+		log.Printf("Error deleting bill: %v", err)
+		return nil, err
 	}
 
-	if rowsAffected == 0 {
-		return NewNotFoundError("bill not found or already deleted")
+	if len(rows) == 0 {
+		return nil, NewNotFoundError("bill not found or already deleted")
 	}
 
-	return nil
+	return append(rows, tags...), nil
 }
 
 // Custom error types for better error handling
@@ -3,50 +3,76 @@ package main
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
+	"backend/common/account"
+	"backend/common/auth"
+	"backend/common/currency"
+	"backend/common/journal"
+	"backend/common/ledger"
+	"backend/common/money"
+	"backend/common/trash"
+
 	_ "github.com/mattn/go-sqlite3"
 )
 
-var db *sql.DB
+var (
+	db         *sql.DB
+	sessions   *auth.Manager
+	balances   *ledger.Ledger
+	currencies *currency.Store
+	accounts   *account.Store
+	audit      *journal.Journal
+	bin        *trash.Store
+)
 
 // Data structures
 type Bill struct {
-	ID             int     `json:"id"`
-	UserID         string  `json:"user_id"`
-	Name           string  `json:"name"`
-	Amount         float64 `json:"amount"`
-	DueDate        string  `json:"due_date"`
-	StartDate      string  `json:"start_date"`
-	PaymentDay     int     `json:"payment_day"`
-	DurationMonths int     `json:"duration_months"`
-	Regularity     string  `json:"regularity"`
-	Paid           bool    `json:"paid"`
-	Overdue        bool    `json:"overdue"`
-	OverdueDays    int     `json:"overdue_days"`
-	Recurring      bool    `json:"recurring"`
-	Category       string  `json:"category"`
-	Icon           string  `json:"icon"`
-	PaymentMethod  string  `json:"payment_method"`
-	CreatedAt      string  `json:"created_at"`
-	UpdatedAt      string  `json:"updated_at"`
+	ID             int         `json:"id"`
+	UserID         string      `json:"user_id"`
+	Name           string      `json:"name"`
+	Amount         money.Money `json:"amount"`
+	DueDate        string      `json:"due_date"`
+	StartDate      string      `json:"start_date"`
+	PaymentDay     int         `json:"payment_day"`
+	DurationMonths int         `json:"duration_months"`
+	Regularity     string      `json:"regularity"`
+	Paid           bool        `json:"paid"`
+	Overdue        bool        `json:"overdue"`
+	OverdueDays    int         `json:"overdue_days"`
+	Recurring      bool        `json:"recurring"`
+	Category       string      `json:"category"`
+	Icon           string      `json:"icon"`
+	PaymentMethod  string      `json:"payment_method"`
+	AccountID      int64       `json:"account_id"`
+	Currency       string      `json:"currency"`
+	BaseAmount     money.Money `json:"base_amount"` // importe en la moneda base al cambio de start_date
+	// StatementAccountID es la tarjeta cuyo extracto paga la factura
+	StatementAccountID int64  `json:"statement_account_id,omitempty"`
+	CreatedAt          string `json:"created_at"`
+	UpdatedAt          string `json:"updated_at"`
 }
 
 type UpdateBillRequest struct {
-	UserID         string  `json:"user_id"`
-	BillID         int     `json:"bill_id"`
-	Name           string  `json:"name,omitempty"`
-	Amount         float64 `json:"amount,omitempty"`
-	StartDate      string  `json:"start_date,omitempty"`
-	PaymentDay     int     `json:"payment_day,omitempty"`
-	DurationMonths int     `json:"duration_months,omitempty"`
-	Regularity     string  `json:"regularity,omitempty"`
-	Category       string  `json:"category,omitempty"`
-	Icon           string  `json:"icon,omitempty"`
-	PaymentMethod  string  `json:"payment_method,omitempty"`
+	UserID         string      `json:"user_id"`
+	BillID         int         `json:"bill_id"`
+	Name           string      `json:"name,omitempty"`
+	Amount         money.Money `json:"amount,omitempty"`
+	StartDate      string      `json:"start_date,omitempty"`
+	PaymentDay     int         `json:"payment_day,omitempty"`
+	DurationMonths int         `json:"duration_months,omitempty"`
+	Regularity     string      `json:"regularity,omitempty"`
+	Category       string      `json:"category,omitempty"`
+	Icon           string      `json:"icon,omitempty"`
+	PaymentMethod  string      `json:"payment_method,omitempty"`
+	AccountID      int64       `json:"account_id,omitempty"`
+	Currency       string      `json:"currency,omitempty"`
+	BaseAmount     money.Money `json:"-"` // lo calcula handleUpdateBill si cambia
 }
 
 type ApiResponse struct {
@@ -84,14 +110,57 @@ func corsMiddleware(next http.HandlerFunc) http.HandlerFunc {
 }
 
 func main() {
+	// Every request must carry an access token issued by signin, google_auth or apple-auth
+	var err error
+	sessions, err = auth.NewManagerFromEnv(db)
+	if err != nil {
+		log.Fatalf("Failed to initialize sessions: %v", err)
+	}
+
+	// Amounts are stored as cents; tables created with REAL amounts are converted
+	if err := money.Migrate(db); err != nil {
+		log.Fatalf("Failed to migrate amounts to cents: %v", err)
+	}
+
+	// The period balance tables belong to the shared ledger
+	balances, err = ledger.New(db)
+	if err != nil {
+		log.Fatalf("Failed to initialize balance ledger: %v", err)
+	}
+
+	// Bills in other currencies are converted to the user's base currency
+	currencies, err = currency.NewStoreFromEnv(db)
+	if err != nil {
+		log.Fatalf("Failed to initialize currencies: %v", err)
+	}
+
+	// Every bill is charged to one of the user's accounts
+	accounts, err = account.NewStore(db, balances)
+	if err != nil {
+		log.Fatalf("Failed to initialize accounts: %v", err)
+	}
+
+	// Every change to a bill or its payments is recorded in the journal
+	audit, err = journal.New(db)
+	if err != nil {
+		log.Fatalf("Failed to initialize journal: %v", err)
+	}
+
+	// Deleted bills go to the trash, which transaction_delete_service lists,
+	// restores and purges
+	bin, err = trash.NewFromEnv(db, balances)
+	if err != nil {
+		log.Fatalf("Failed to initialize trash: %v", err)
+	}
+
 	// Set up CORS middleware and routes
-	http.HandleFunc("/bills", corsMiddleware(handleFetchBills))
-	http.HandleFunc("/bills/add", corsMiddleware(handleAddBill))
-	http.HandleFunc("/bills/pay", corsMiddleware(handlePayBill))
-	http.HandleFunc("/bills/payment-status", corsMiddleware(handleGetPaymentStatus))
-	http.HandleFunc("/bills/update", corsMiddleware(handleUpdateBill))
-	http.HandleFunc("/bills/delete", corsMiddleware(handleDeleteBill))
-	http.HandleFunc("/bills/upcoming", corsMiddleware(handleGetUpcomingBills))
+	http.HandleFunc("/bills", corsMiddleware(sessions.Require(handleFetchBills)))
+	http.HandleFunc("/bills/add", corsMiddleware(sessions.Require(handleAddBill)))
+	http.HandleFunc("/bills/pay", corsMiddleware(sessions.Require(handlePayBill)))
+	http.HandleFunc("/bills/payment-status", corsMiddleware(sessions.Require(handleGetPaymentStatus)))
+	http.HandleFunc("/bills/update", corsMiddleware(sessions.Require(handleUpdateBill)))
+	http.HandleFunc("/bills/delete", corsMiddleware(sessions.Require(handleDeleteBill)))
+	http.HandleFunc("/bills/upcoming", corsMiddleware(sessions.Require(handleGetUpcomingBills)))
 
 	fmt.Println("Bills Management service started on :8091")
 	log.Fatal(http.ListenAndServe(":8091", nil))
@@ -104,7 +173,7 @@ func createTablesIfNotExist() {
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
 		user_id TEXT NOT NULL,
 		name TEXT NOT NULL,
-		amount REAL NOT NULL,
+		amount INTEGER NOT NULL,
 		due_date TEXT,
 		start_date TEXT NOT NULL,
 		payment_day INTEGER NOT NULL,
@@ -117,6 +186,10 @@ func createTablesIfNotExist() {
 		category TEXT DEFAULT 'general',
 		icon TEXT DEFAULT '💳',
 		payment_method TEXT,
+		account_id INTEGER,
+		currency TEXT,
+		base_amount INTEGER,
+		statement_account_id INTEGER,
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
 	);`
@@ -164,6 +237,11 @@ func handleFetchBills(w http.ResponseWriter, r *http.Request) {
 
 	log.Printf("🔍 handleFetchBills: userID=%s, period=%s, date=%s", userID, period, date)
 
+	// Los extractos de las tarjetas pasan a ser facturas al cerrar el ciclo
+	if err := createStatementBills(userID); err != nil {
+		log.Printf("❌ Error creating statement bills: %v", err)
+	}
+
 	// Si se proporcionan parámetros de período, usar la nueva lógica
 	if period != "" && date != "" {
 		billsWithStatus, err := fetchBillsForPeriod(userID, period, date)
@@ -202,17 +280,19 @@ func handleAddBill(w http.ResponseWriter, r *http.Request) {
 
 	// Parse the request body
 	var addRequest struct {
-		UserID         string  `json:"user_id"`
-		Name           string  `json:"name"`
-		Amount         float64 `json:"amount"`
-		DueDate        string  `json:"due_date"`
-		StartDate      string  `json:"start_date"`
-		PaymentDay     int     `json:"payment_day"`
-		DurationMonths int     `json:"duration_months"`
-		Regularity     string  `json:"regularity"`
-		Category       string  `json:"category"`
-		Icon           string  `json:"icon"`
-		PaymentMethod  string  `json:"payment_method"`
+		UserID         string      `json:"user_id"`
+		Name           string      `json:"name"`
+		Amount         money.Money `json:"amount"`
+		DueDate        string      `json:"due_date"`
+		StartDate      string      `json:"start_date"`
+		PaymentDay     int         `json:"payment_day"`
+		DurationMonths int         `json:"duration_months"`
+		Regularity     string      `json:"regularity"`
+		Category       string      `json:"category"`
+		Icon           string      `json:"icon"`
+		PaymentMethod  string      `json:"payment_method"`
+		AccountID      int64       `json:"account_id"`
+		Currency       string      `json:"currency"`
 	}
 
 	err := json.NewDecoder(r.Body).Decode(&addRequest)
@@ -263,11 +343,36 @@ func handleAddBill(w http.ResponseWriter, r *http.Request) {
 		addRequest.Regularity = "monthly"
 	}
 
+	// Every month of the bill counts in the base currency at the rate of its start date
+	billCurrency, baseAmount, err := currencies.ToBase(addRequest.UserID, addRequest.Amount, addRequest.Currency, addRequest.StartDate)
+	if err != nil {
+		sendConversionError(w, err, "Error adding bill")
+		return
+	}
+
+	// The bill, its payment records and its months in the period balances
+	// are written in one transaction
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		sendErrorResponse(w, "Error adding bill", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// The account decides the payment method
+	billAccount, err := accounts.ResolveTx(tx, addRequest.UserID, addRequest.AccountID, addRequest.PaymentMethod)
+	if err != nil {
+		sendAccountError(w, err, "Error adding bill")
+		return
+	}
+	addRequest.AccountID, addRequest.PaymentMethod = billAccount.ID, billAccount.Method()
+
 	// Insert into database
-	result, err := db.Exec(`
-		INSERT INTO bills (user_id, name, amount, due_date, paid, overdue, overdue_days, recurring, category, icon, start_date, payment_day, duration_months, regularity, payment_method)
-		VALUES (?, ?, ?, ?, 0, 0, 0, 1, ?, ?, ?, ?, ?, ?, ?)
-	`, addRequest.UserID, addRequest.Name, addRequest.Amount, addRequest.DueDate, addRequest.Category, addRequest.Icon, addRequest.StartDate, addRequest.PaymentDay, addRequest.DurationMonths, addRequest.Regularity, addRequest.PaymentMethod)
+	result, err := tx.Exec(`
+		INSERT INTO bills (user_id, name, amount, due_date, paid, overdue, overdue_days, recurring, category, icon, start_date, payment_day, duration_months, regularity, payment_method, account_id, currency, base_amount)
+		VALUES (?, ?, ?, ?, 0, 0, 0, 1, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, addRequest.UserID, addRequest.Name, addRequest.Amount, addRequest.DueDate, addRequest.Category, addRequest.Icon, addRequest.StartDate, addRequest.PaymentDay, addRequest.DurationMonths, addRequest.Regularity, addRequest.PaymentMethod, addRequest.AccountID, billCurrency, baseAmount)
 
 	if err != nil {
 		log.Printf("Error adding bill: %v", err)
@@ -283,22 +388,42 @@ func handleAddBill(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Add the new bill to monthly_cash_bank_balance
-	err = addNewBillToMonthlyBalance(db, addRequest.UserID, addRequest.Amount,
-		addRequest.StartDate, addRequest.DurationMonths, addRequest.PaymentMethod)
+	// Post every month of the new bill to the period balances
+	entries, err := ledger.BillEntries(addRequest.UserID, addRequest.PaymentMethod, addRequest.AccountID, baseAmount,
+		addRequest.StartDate, addRequest.DurationMonths, nil)
+	if err == nil {
+		err = balances.PostTx(tx, entries...)
+	}
 	if err != nil {
-		log.Printf("Error adding bill to monthly balance: %v", err)
-		// Note: We don't return error here as the bill was created successfully
-		// The balance issue can be fixed later with reconciliation
+		log.Printf("Error adding bill to period balances: %v", err)
+		sendErrorResponse(w, "Error adding bill", http.StatusInternalServerError)
+		return
 	}
 
 	// Create bill payment records for tracking individual payments
-	err = createBillPaymentRecords(db, int(billID), addRequest.UserID,
+	err = createBillPaymentRecords(tx, int(billID), addRequest.UserID,
 		addRequest.StartDate, addRequest.DurationMonths, addRequest.PaymentMethod)
 	if err != nil {
 		log.Printf("Error creating bill payment records: %v", err)
-		// Note: We don't return error here as the bill was created successfully
-		// The payment records can be created later manually if needed
+		sendErrorResponse(w, "Error adding bill", http.StatusInternalServerError)
+		return
+	}
+
+	newBill, err := getBillOldData(tx, int(billID), addRequest.UserID)
+	if err == nil {
+		err = audit.RecordTx(tx, addRequest.UserID, journal.Change{Actor: journal.Actor(r), Entity: "bill", EntityID: billID,
+			After: newBill, Posted: entries})
+	}
+	if err != nil {
+		log.Printf("Error recording bill: %v", err)
+		sendErrorResponse(w, "Error adding bill", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error committing bill: %v", err)
+		sendErrorResponse(w, "Error adding bill", http.StatusInternalServerError)
+		return
 	}
 
 	// Return success response with the new bill data
@@ -315,6 +440,9 @@ func handleAddBill(w http.ResponseWriter, r *http.Request) {
 		"category":        addRequest.Category,
 		"icon":            addRequest.Icon,
 		"payment_method":  addRequest.PaymentMethod,
+		"account_id":      addRequest.AccountID,
+		"currency":        billCurrency,
+		"base_amount":     baseAmount,
 		"paid":            false,
 		"overdue":         false,
 		"overdue_days":    0,
@@ -351,7 +479,7 @@ func handlePayBill(w http.ResponseWriter, r *http.Request) {
 
 	// Process the payment
 	response, err := markBillPaid(db, payRequest.BillID, payRequest.UserID,
-		payRequest.YearMonth, payRequest.PaymentDate)
+		payRequest.YearMonth, payRequest.PaymentDate, journal.Actor(r))
 	if err != nil {
 		log.Printf("Error processing bill payment: %v", err)
 		sendErrorResponse(w, fmt.Sprintf("Error processing payment: %v", err), http.StatusInternalServerError)
@@ -417,9 +545,46 @@ func handleUpdateBill(w http.ResponseWriter, r *http.Request) {
 		sendErrorResponse(w, "Bill not found", http.StatusNotFound)
 		return
 	}
+	if oldBillData.StatementAccountID != 0 {
+		sendErrorResponse(w, errStatementBill.Error(), http.StatusBadRequest)
+		return
+	}
 
-	// 2. Actualizar tabla bills con los nuevos datos
-	err = updateBillInDatabase(db, updateRequest)
+	// El importe en la moneda base se recalcula solo si cambian el importe,
+	// la moneda o la fecha de inicio, para que un cambio nuevo no mueva el bill
+	newAmount := getValueOrDefault(updateRequest.Amount, oldBillData.Amount)
+	newCurrency := getStringValueOrDefault(updateRequest.Currency, oldBillData.Currency)
+	newStartDate := getStringValueOrDefault(updateRequest.StartDate, oldBillData.StartDate)
+	newBaseAmount := oldBillData.BaseAmount
+	if newAmount != oldBillData.Amount || newCurrency != oldBillData.Currency || newStartDate != oldBillData.StartDate {
+		newCurrency, newBaseAmount, err = currencies.ToBase(updateRequest.UserID, newAmount, newCurrency, newStartDate)
+		if err != nil {
+			sendConversionError(w, err, "Error updating bill")
+			return
+		}
+		updateRequest.Currency, updateRequest.BaseAmount = newCurrency, newBaseAmount
+	}
+
+	// 2. Actualizar tabla bills, expenses y balances en una sola transacción
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		sendErrorResponse(w, "Error updating bill", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// Cambiar de cuenta puede cambiar el método de pago
+	if updateRequest.AccountID != 0 || (updateRequest.PaymentMethod != "" && updateRequest.PaymentMethod != oldBillData.PaymentMethod) {
+		billAccount, err := accounts.ResolveTx(tx, updateRequest.UserID, updateRequest.AccountID, updateRequest.PaymentMethod)
+		if err != nil {
+			sendAccountError(w, err, "Error updating bill")
+			return
+		}
+		updateRequest.AccountID, updateRequest.PaymentMethod = billAccount.ID, billAccount.Method()
+	}
+
+	err = updateBillInDatabase(tx, updateRequest)
 	if err != nil {
 		log.Printf("Error updating bill in database: %v", err)
 		sendErrorResponse(w, "Error updating bill", http.StatusInternalServerError)
@@ -431,40 +596,47 @@ func handleUpdateBill(w http.ResponseWriter, r *http.Request) {
 		BillID:            updateRequest.BillID,
 		UserID:            updateRequest.UserID,
 		OldAmount:         oldBillData.Amount,
-		NewAmount:         getValueOrDefault(updateRequest.Amount, oldBillData.Amount),
+		NewAmount:         newAmount,
+		OldBaseAmount:     oldBillData.BaseAmount,
+		NewBaseAmount:     newBaseAmount,
+		NewCurrency:       newCurrency,
 		OldDurationMonths: oldBillData.DurationMonths,
 		NewDurationMonths: getIntValueOrDefault(updateRequest.DurationMonths, oldBillData.DurationMonths),
 		OldStartDate:      oldBillData.StartDate,
-		NewStartDate:      getStringValueOrDefault(updateRequest.StartDate, oldBillData.StartDate),
+		NewStartDate:      newStartDate,
 		OldPaymentMethod:  oldBillData.PaymentMethod,
 		NewPaymentMethod:  getStringValueOrDefault(updateRequest.PaymentMethod, oldBillData.PaymentMethod),
+		OldAccountID:      oldBillData.AccountID,
+		NewAccountID:      oldBillData.AccountID,
+	}
+	if updateRequest.AccountID != 0 {
+		updateData.NewAccountID = updateRequest.AccountID
 	}
 
-	// 4. Ejecutar lógica de actualización de importes
-	err = updateBillAmountLogic(db, updateData)
+	// 4. Llevar los cambios a los balances por periodo
+	reversed, posted, err := updateBillBalances(tx, updateData)
 	if err != nil {
-		log.Printf("Error in amount update logic: %v", err)
-		sendErrorResponse(w, "Error updating bill amounts", http.StatusInternalServerError)
+		log.Printf("Error updating bill balances: %v", err)
+		sendErrorResponse(w, "Error updating bill balances", http.StatusInternalServerError)
 		return
 	}
 
-	// 5. Ejecutar lógica de actualización de duración
-	err = updateBillDurationLogic(db, updateData)
+	// 5. Registrar el cambio en el journal
+	newBill, err := getBillOldData(tx, updateRequest.BillID, updateRequest.UserID)
+	if err == nil {
+		err = audit.RecordTx(tx, updateRequest.UserID, journal.Change{Actor: journal.Actor(r), Entity: "bill",
+			EntityID: int64(updateRequest.BillID), Before: oldBillData, After: newBill, Reversed: reversed, Posted: posted})
+	}
 	if err != nil {
-		log.Printf("Error in duration update logic: %v", err)
-		sendErrorResponse(w, "Error updating bill duration", http.StatusInternalServerError)
+		log.Printf("Error recording bill update: %v", err)
+		sendErrorResponse(w, "Error updating bill", http.StatusInternalServerError)
 		return
 	}
 
-	// 6. Recalcular saldos en cascada usando el algoritmo existente
-	startDate := updateData.NewStartDate
-	parsedDate, err := time.Parse("2006-01-02", startDate)
-	if err == nil {
-		firstMonth := parsedDate.Format("2006-01")
-		err = updateCascadeBalances(db, updateData.UserID, firstMonth)
-		if err != nil {
-			log.Printf("Error updating cascade balances: %v", err)
-		}
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error committing bill update: %v", err)
+		sendErrorResponse(w, "Error updating bill", http.StatusInternalServerError)
+		return
 	}
 
 	sendSuccessResponse(w, "Bill updated successfully", map[string]interface{}{
@@ -503,7 +675,8 @@ func fetchBills(userID string) ([]Bill, error) {
 	query := `
 		SELECT id, user_id, name, amount, COALESCE(due_date, start_date), start_date, payment_day, 
 		       duration_months, regularity, paid, overdue, overdue_days, 
-		       recurring, category, icon, COALESCE(payment_method, 'cash'), 
+		       recurring, category, icon, COALESCE(payment_method, 'cash'), COALESCE(account_id, 0),
+		       COALESCE(currency, ''), COALESCE(base_amount, amount), COALESCE(statement_account_id, 0),
 		       COALESCE(created_at, ''), COALESCE(updated_at, '')
 		FROM bills 
 		WHERE user_id = ? 
@@ -523,7 +696,8 @@ func fetchBills(userID string) ([]Bill, error) {
 			&bill.ID, &bill.UserID, &bill.Name, &bill.Amount, &bill.DueDate,
 			&bill.StartDate, &bill.PaymentDay, &bill.DurationMonths, &bill.Regularity,
 			&bill.Paid, &bill.Overdue, &bill.OverdueDays, &bill.Recurring,
-			&bill.Category, &bill.Icon, &bill.PaymentMethod, &bill.CreatedAt, &bill.UpdatedAt,
+			&bill.Category, &bill.Icon, &bill.PaymentMethod, &bill.AccountID, &bill.Currency, &bill.BaseAmount,
+			&bill.StatementAccountID, &bill.CreatedAt, &bill.UpdatedAt,
 		)
 		if err != nil {
 			log.Printf("Error scanning bill: %v", err)
@@ -535,12 +709,19 @@ func fetchBills(userID string) ([]Bill, error) {
 	return bills, nil
 }
 
-// getBillOldData obtiene los datos antiguos de un bill antes de actualizarlo
-func getBillOldData(db *sql.DB, billID int, userID string) (*Bill, error) {
+// rowQuerier es lo que *sql.DB y *sql.Tx tienen en común para leer una fila
+type rowQuerier interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// getBillOldData obtiene los datos de un bill, antes de actualizarlo o,
+// dentro de la transacción, después
+func getBillOldData(db rowQuerier, billID int, userID string) (*Bill, error) {
 	query := `
 		SELECT id, user_id, name, amount, COALESCE(due_date, start_date), start_date, payment_day, 
 		       duration_months, regularity, paid, overdue, overdue_days, 
-		       recurring, category, icon, COALESCE(payment_method, 'cash'), 
+		       recurring, category, icon, COALESCE(payment_method, 'cash'), COALESCE(account_id, 0),
+		       COALESCE(currency, ''), COALESCE(base_amount, amount), COALESCE(statement_account_id, 0),
 		       COALESCE(created_at, ''), COALESCE(updated_at, '')
 		FROM bills 
 		WHERE id = ? AND user_id = ?
@@ -551,7 +732,8 @@ func getBillOldData(db *sql.DB, billID int, userID string) (*Bill, error) {
 		&bill.ID, &bill.UserID, &bill.Name, &bill.Amount, &bill.DueDate,
 		&bill.StartDate, &bill.PaymentDay, &bill.DurationMonths, &bill.Regularity,
 		&bill.Paid, &bill.Overdue, &bill.OverdueDays, &bill.Recurring,
-		&bill.Category, &bill.Icon, &bill.PaymentMethod, &bill.CreatedAt, &bill.UpdatedAt,
+		&bill.Category, &bill.Icon, &bill.PaymentMethod, &bill.AccountID, &bill.Currency, &bill.BaseAmount,
+		&bill.StatementAccountID, &bill.CreatedAt, &bill.UpdatedAt,
 	)
 
 	if err != nil {
@@ -562,7 +744,7 @@ func getBillOldData(db *sql.DB, billID int, userID string) (*Bill, error) {
 }
 
 // updateBillInDatabase actualiza los campos del bill en la base de datos
-func updateBillInDatabase(db *sql.DB, updateRequest UpdateBillRequest) error {
+func updateBillInDatabase(tx *sql.Tx, updateRequest UpdateBillRequest) error {
 	// Construir query dinámicamente según los campos que se proporcionan
 	setParts := []string{}
 	args := []interface{}{}
@@ -603,6 +785,14 @@ func updateBillInDatabase(db *sql.DB, updateRequest UpdateBillRequest) error {
 		setParts = append(setParts, "payment_method = ?")
 		args = append(args, updateRequest.PaymentMethod)
 	}
+	if updateRequest.AccountID > 0 {
+		setParts = append(setParts, "account_id = ?")
+		args = append(args, updateRequest.AccountID)
+	}
+	if updateRequest.BaseAmount > 0 {
+		setParts = append(setParts, "currency = ?", "base_amount = ?")
+		args = append(args, updateRequest.Currency, updateRequest.BaseAmount)
+	}
 
 	if len(setParts) == 0 {
 		return fmt.Errorf("no fields to update")
@@ -612,21 +802,41 @@ func updateBillInDatabase(db *sql.DB, updateRequest UpdateBillRequest) error {
 	setParts = append(setParts, "updated_at = CURRENT_TIMESTAMP")
 
 	// Construir query final
-	query := fmt.Sprintf("UPDATE bills SET %s WHERE id = ? AND user_id = ?",
-		fmt.Sprintf("%s", setParts[0]))
-	for i := 1; i < len(setParts); i++ {
-		query = fmt.Sprintf("%s, %s", query, setParts[i])
-	}
+	query := fmt.Sprintf("UPDATE bills SET %s WHERE id = ? AND user_id = ?", strings.Join(setParts, ", "))
 
 	// Añadir parámetros de WHERE
 	args = append(args, updateRequest.BillID, updateRequest.UserID)
 
-	_, err := db.Exec(query, args...)
+	_, err := tx.Exec(query, args...)
 	return err
 }
 
+// sendConversionError responde a una conversión fallida: una moneda
+// desconocida o sin tipo de cambio es un error del cliente
+func sendConversionError(w http.ResponseWriter, err error, message string) {
+	if errors.Is(err, currency.ErrInvalidCode) || errors.Is(err, currency.ErrNoRate) {
+		sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Printf("Error converting to the base currency: %v", err)
+	sendErrorResponse(w, message, http.StatusInternalServerError)
+}
+
+// sendAccountError responde a una cuenta que no existe o que no se indicó
+func sendAccountError(w http.ResponseWriter, err error, message string) {
+	switch {
+	case errors.Is(err, account.ErrNotFound):
+		sendErrorResponse(w, "Account not found", http.StatusNotFound)
+	case errors.Is(err, account.ErrInvalid):
+		sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+	default:
+		log.Printf("Error resolving account: %v", err)
+		sendErrorResponse(w, message, http.StatusInternalServerError)
+	}
+}
+
 // Funciones auxiliares para manejar valores opcionales
-func getValueOrDefault(value, defaultValue float64) float64 {
+func getValueOrDefault(value, defaultValue money.Money) money.Money {
 	if value > 0 {
 		return value
 	}
@@ -646,96 +856,3 @@ func getStringValueOrDefault(value, defaultValue string) string {
 	}
 	return defaultValue
 }
-
-// updateCascadeBalances recalcula los saldos en cascada desde startMonth
-func updateCascadeBalances(db *sql.DB, userID string, startMonth string) error {
-	// Obtener todos los meses posteriores o iguales a startMonth
-	rows, err := db.Query(`
-		SELECT year_month FROM monthly_cash_bank_balance
-		WHERE user_id = ? AND year_month >= ?
-		ORDER BY year_month
-	`, userID, startMonth)
-	if err != nil {
-		return fmt.Errorf("error fetching months: %v", err)
-	}
-	defer rows.Close()
-
-	var months []string
-	for rows.Next() {
-		var month string
-		if err := rows.Scan(&month); err != nil {
-			return fmt.Errorf("error scanning month: %v", err)
-		}
-		months = append(months, month)
-	}
-
-	for i, month := range months {
-		// Obtener el mes anterior (si existe)
-		var previousMonth string
-		if i > 0 {
-			previousMonth = months[i-1]
-		} else if month != startMonth {
-			row := db.QueryRow(`
-				SELECT year_month FROM monthly_cash_bank_balance
-				WHERE user_id = ? AND year_month < ? ORDER BY year_month DESC LIMIT 1
-			`, userID, month)
-			if err := row.Scan(&previousMonth); err != nil && err != sql.ErrNoRows {
-				return fmt.Errorf("error fetching previous month: %v", err)
-			}
-		}
-
-		// Obtener saldos previos
-		var previousCashAmount, previousBankAmount, totalPreviousBalance float64
-		if previousMonth != "" {
-			err := db.QueryRow(`
-				SELECT cash_amount, bank_amount, total_balance
-				FROM monthly_cash_bank_balance
-				WHERE user_id = ? AND year_month = ?
-			`, userID, previousMonth).Scan(&previousCashAmount, &previousBankAmount, &totalPreviousBalance)
-			if err != nil && err != sql.ErrNoRows {
-				return fmt.Errorf("error fetching previous balances: %v", err)
-			}
-		}
-
-		// Obtener movimientos del mes actual
-		var incomeCash, incomeBank, expenseCash, expenseBank, billCash, billBank float64
-		err := db.QueryRow(`
-			SELECT income_cash_amount, income_bank_amount,
-			       expense_cash_amount, expense_bank_amount,
-			       bill_cash_amount, bill_bank_amount
-			FROM monthly_cash_bank_balance
-			WHERE user_id = ? AND year_month = ?
-		`, userID, month).Scan(&incomeCash, &incomeBank, &expenseCash, &expenseBank, &billCash, &billBank)
-		if err != nil {
-			return fmt.Errorf("error fetching current month data: %v", err)
-		}
-
-		// Calcular saldos del mes actual
-		cashAmount := previousCashAmount + incomeCash - expenseCash - billCash
-		bankAmount := previousBankAmount + incomeBank - expenseBank - billBank
-		balanceCashAmount := cashAmount
-		balanceBankAmount := bankAmount
-		totalBalance := balanceCashAmount + balanceBankAmount
-
-		// Actualizar registro
-		_, err = db.Exec(`
-			UPDATE monthly_cash_bank_balance
-			SET cash_amount = ?,
-			    bank_amount = ?,
-			    balance_cash_amount = ?,
-			    balance_bank_amount = ?,
-			    total_balance = ?,
-			    previous_cash_amount = ?,
-			    previous_bank_amount = ?,
-			    total_previous_balance = ?
-			WHERE user_id = ? AND year_month = ?
-		`, cashAmount, bankAmount, balanceCashAmount, balanceBankAmount,
-			totalBalance, previousCashAmount, previousBankAmount, totalPreviousBalance,
-			userID, month)
-		if err != nil {
-			return fmt.Errorf("error updating balance for month %s: %v", month, err)
-		}
-	}
-
-	return nil
-}
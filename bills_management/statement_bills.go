@@ -0,0 +1,120 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"backend/common/account"
+	"backend/common/journal"
+	"backend/common/ledger"
+	"backend/common/money"
+)
+
+// statementCategory es la categoría de las facturas que pagan el extracto
+// de una tarjeta de crédito
+const statementCategory = "credit_card"
+
+// errStatementBill se devuelve al editar o borrar una factura de extracto:
+// sigue a su tarjeta
+var errStatementBill = NewValidationError("statement bills follow their credit card and cannot be changed or deleted")
+
+// createStatementBills adds, for every credit card of userID with
+// statements, the bill that pays its last closed statement, once per
+// cycle. The bill is paid from the user's bank account and posts nothing
+// to the balances until then: the charges are already there as card debt.
+func createStatementBills(userID string) error {
+	baseCurrency, err := currencies.Base(userID)
+	if err != nil {
+		return err
+	}
+	today := time.Now().Format("2006-01-02")
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	cards, err := accounts.CardsTx(tx, userID)
+	if err != nil {
+		return err
+	}
+	for _, card := range cards {
+		month, err := card.LastClosed(today)
+		if err != nil {
+			return err
+		}
+		statement, err := accounts.StatementTx(tx, card, month)
+		if err != nil {
+			return err
+		}
+		if statement.Balance <= 0 || statement.ClosingDate < card.OpeningDate {
+			continue
+		}
+
+		var exists int
+		err = tx.QueryRow(fmt.Sprintf(`SELECT COUNT(*) FROM bills WHERE user_id = ? AND %s = ? AND due_date = ?`, account.StatementColumn),
+			userID, card.ID, statement.DueDate).Scan(&exists)
+		if err != nil {
+			return fmt.Errorf("error checking statement bill: %v", err)
+		}
+		if exists > 0 {
+			continue
+		}
+
+		if err := addStatementBill(tx, card, statement, baseCurrency); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// addStatementBill inserts the bill of one statement, due once on its due
+// date
+func addStatementBill(tx *sql.Tx, card account.Account, statement account.Statement, baseCurrency string) error {
+	payer, err := accounts.ResolveTx(tx, card.UserID, 0, ledger.Bank)
+	if err != nil {
+		return err
+	}
+	due, err := time.Parse("2006-01-02", statement.DueDate)
+	if err != nil {
+		return fmt.Errorf("invalid statement due date: %v", err)
+	}
+
+	result, err := tx.Exec(fmt.Sprintf(`
+		INSERT INTO bills (user_id, name, amount, due_date, paid, overdue, overdue_days, recurring, category, icon, start_date, payment_day, duration_months, regularity, payment_method, account_id, currency, base_amount, %s)
+		VALUES (?, ?, ?, ?, 0, 0, 0, 0, ?, '💳', ?, ?, 1, 'monthly', ?, ?, ?, ?, ?)
+	`, account.StatementColumn), card.UserID, fmt.Sprintf("Extracto %s %s", card.Name, statement.Month), statement.Balance,
+		statement.DueDate, statementCategory, statement.DueDate, due.Day(), payer.Method(), payer.ID,
+		baseCurrency, statement.Balance, card.ID)
+	if err != nil {
+		return fmt.Errorf("error adding statement bill: %v", err)
+	}
+	billID, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("error getting statement bill ID: %v", err)
+	}
+
+	log.Printf("Added statement bill %d for card %d, %s due %s", billID, card.ID, statement.Balance, statement.DueDate)
+	if err := createBillPaymentRecords(tx, int(billID), card.UserID, statement.DueDate, 1, payer.Method()); err != nil {
+		return err
+	}
+
+	bill, err := getBillOldData(tx, int(billID), card.UserID)
+	if err != nil {
+		return fmt.Errorf("error reading statement bill: %v", err)
+	}
+	return audit.RecordTx(tx, card.UserID, journal.Change{Actor: journal.System, Entity: "bill", EntityID: billID, After: bill})
+}
+
+// statementPaymentEntries moves a paid statement from the bank account
+// that pays it to the card, like a transfer: the charges were already
+// spent
+func statementPaymentEntries(userID, paymentMethod string, accountID, cardID int64, amount money.Money, date string) []ledger.Entry {
+	return []ledger.Entry{
+		{UserID: userID, Kind: ledger.Adjustment, Method: paymentMethod, Account: accountID, Amount: -amount, Date: date},
+		{UserID: userID, Kind: ledger.Adjustment, Method: ledger.Credit, Account: cardID, Amount: amount, Date: date},
+	}
+}
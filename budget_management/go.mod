@@ -2,4 +2,9 @@ module backend/budget_management
 
 go 1.21
 
-require github.com/mattn/go-sqlite3 v1.14.27
+require (
+	backend/common v0.0.0
+	github.com/mattn/go-sqlite3 v1.14.27
+)
+
+replace backend/common => ../common
@@ -10,31 +10,35 @@ import (
 	"path/filepath"
 	"time"
 
+	"backend/common/auth"
+	"backend/common/journal"
+	"backend/common/money"
+
 	_ "github.com/mattn/go-sqlite3"
 )
 
 // Definición de estructuras de datos
 type BudgetData struct {
-	UserID          string  `json:"user_id"`
-	Period          string  `json:"period"`
-	Date            string  `json:"date"`
-	TotalAmount     float64 `json:"total_amount"`
-	RemainingAmount float64 `json:"remaining_amount"`
-	SpentAmount     float64 `json:"spent_amount"`
-	UpcomingAmount  float64 `json:"upcoming_amount"`
-	FromPrevious    float64 `json:"from_previous"`
-	Percent         float64 `json:"percent"`
-	TotalIncome     float64 `json:"total_income"`
+	UserID          string      `json:"user_id"`
+	Period          string      `json:"period"`
+	Date            string      `json:"date"`
+	TotalAmount     money.Money `json:"total_amount"`
+	RemainingAmount money.Money `json:"remaining_amount"`
+	SpentAmount     money.Money `json:"spent_amount"`
+	UpcomingAmount  money.Money `json:"upcoming_amount"`
+	FromPrevious    money.Money `json:"from_previous"`
+	Percent         float64     `json:"percent"`
+	TotalIncome     money.Money `json:"total_income"`
 }
 
 type BudgetUpdateRequest struct {
-	UserID         string  `json:"user_id"`
-	Period         string  `json:"period"`
-	TotalAmount    float64 `json:"total_amount"`
-	SpentAmount    float64 `json:"spent_amount"`
-	UpcomingAmount float64 `json:"upcoming_amount"`
-	FromPrevious   float64 `json:"from_previous"`
-	TotalIncome    float64 `json:"total_income"`
+	UserID         string      `json:"user_id"`
+	Period         string      `json:"period"`
+	TotalAmount    money.Money `json:"total_amount"`
+	SpentAmount    money.Money `json:"spent_amount"`
+	UpcomingAmount money.Money `json:"upcoming_amount"`
+	FromPrevious   money.Money `json:"from_previous"`
+	TotalIncome    money.Money `json:"total_income"`
 }
 
 type ApiResponse struct {
@@ -44,7 +48,9 @@ type ApiResponse struct {
 }
 
 var (
-	db *sql.DB
+	db       *sql.DB
+	sessions *auth.Manager
+	audit    *journal.Journal
 )
 
 func init() {
@@ -85,13 +91,13 @@ func createTablesIfNotExist() {
 			user_id TEXT NOT NULL,
 			period TEXT NOT NULL,
 			date TEXT NOT NULL,
-			total_amount REAL NOT NULL,
-			remaining_amount REAL NOT NULL,
-			spent_amount REAL NOT NULL,
-			upcoming_amount REAL NOT NULL,
-			from_previous REAL NOT NULL,
+			total_amount INTEGER NOT NULL,
+			remaining_amount INTEGER NOT NULL,
+			spent_amount INTEGER NOT NULL,
+			upcoming_amount INTEGER NOT NULL,
+			from_previous INTEGER NOT NULL,
 			percent REAL NOT NULL,
-			total_income REAL NOT NULL DEFAULT 0,
+			total_income INTEGER NOT NULL DEFAULT 0,
 			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 		)
@@ -110,7 +116,7 @@ func createTablesIfNotExist() {
 		log.Printf("Error checking for total_income column: %v", err)
 	} else if exists == 0 {
 		// Add the column if it doesn't exist
-		_, err = db.Exec(`ALTER TABLE budget ADD COLUMN total_income REAL NOT NULL DEFAULT 0`)
+		_, err = db.Exec(`ALTER TABLE budget ADD COLUMN total_income INTEGER NOT NULL DEFAULT 0`)
 		if err != nil {
 			log.Printf("Error adding total_income column: %v", err)
 		} else {
@@ -120,9 +126,27 @@ func createTablesIfNotExist() {
 }
 
 func main() {
+	// Every request must carry an access token issued by signin, google_auth or apple-auth
+	var err error
+	sessions, err = auth.NewManagerFromEnv(db)
+	if err != nil {
+		log.Fatalf("Failed to initialize sessions: %v", err)
+	}
+
+	// Amounts are stored as cents; tables created with REAL amounts are converted
+	if err := money.Migrate(db); err != nil {
+		log.Fatalf("Failed to migrate amounts to cents: %v", err)
+	}
+
+	// Every change to a budget is recorded in the journal
+	audit, err = journal.New(db)
+	if err != nil {
+		log.Fatalf("Failed to initialize journal: %v", err)
+	}
+
 	// Set up CORS middleware and routes
-	http.HandleFunc("/budget/fetch", corsMiddleware(handleFetchBudget))
-	http.HandleFunc("/budget/update", corsMiddleware(handleUpdateBudget))
+	http.HandleFunc("/budget/fetch", corsMiddleware(sessions.Require(handleFetchBudget)))
+	http.HandleFunc("/budget/update", corsMiddleware(sessions.Require(handleUpdateBudget)))
 
 	port := 8088
 	log.Printf("Budget Management service started on :%d", port)
@@ -209,7 +233,7 @@ func handleUpdateBudget(w http.ResponseWriter, r *http.Request) {
 	var percent float64
 	totalAvailable := updateRequest.FromPrevious + updateRequest.TotalIncome
 	if totalAvailable > 0 {
-		percent = ((updateRequest.SpentAmount + updateRequest.UpcomingAmount) / totalAvailable) * 100
+		percent = ((updateRequest.SpentAmount + updateRequest.UpcomingAmount).Float64() / totalAvailable.Float64()) * 100
 	}
 
 	// Insert or update the budget
@@ -226,7 +250,7 @@ func handleUpdateBudget(w http.ResponseWriter, r *http.Request) {
 		TotalIncome:     updateRequest.TotalIncome,
 	}
 
-	err = updateBudgetData(budget)
+	err = updateBudgetData(budget, journal.Actor(r))
 	if err != nil {
 		log.Printf("Error updating budget data: %v", err)
 		sendErrorResponse(w, "Error updating budget data", http.StatusInternalServerError)
@@ -282,7 +306,7 @@ func fetchBudgetData(userID, period string) (BudgetData, error) {
 			budget.RemainingAmount = previousAmount
 
 			// Log the inheritance
-			log.Printf("Inheriting %f from previous period %s for user %s in period %s",
+			log.Printf("Inheriting %s from previous period %s for user %s in period %s",
 				previousAmount, previousPeriod, userID, period)
 		}
 
@@ -295,7 +319,7 @@ func fetchBudgetData(userID, period string) (BudgetData, error) {
 }
 
 // Get data from previous time periods to inherit the remaining amount
-func getPreviousPeriodData(userID, currentPeriod string) (string, float64) {
+func getPreviousPeriodData(userID, currentPeriod string) (string, money.Money) {
 	// Define the previous period based on the current period
 	var previousPeriod string
 	var queryDateCondition string
@@ -367,7 +391,7 @@ func getPreviousPeriodData(userID, currentPeriod string) (string, float64) {
 		LIMIT 1
 	`, queryDateCondition)
 
-	var remainingAmount float64
+	var remainingAmount money.Money
 	err := db.QueryRow(query, userID, previousPeriod).Scan(&remainingAmount)
 
 	if err != nil {
@@ -380,21 +404,36 @@ func getPreviousPeriodData(userID, currentPeriod string) (string, float64) {
 	return previousPeriod, remainingAmount
 }
 
-func updateBudgetData(budget BudgetData) error {
+// updateBudgetData stores budget and records the change in the journal on
+// behalf of actor, in one transaction
+func updateBudgetData(budget BudgetData, actor string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	// Check if a budget entry already exists for this user and period
-	var count int
-	err := db.QueryRow(`
-		SELECT COUNT(*) 
+	var id int64
+	before := BudgetData{UserID: budget.UserID, Period: budget.Period}
+	err = tx.QueryRow(`
+		SELECT id, date, total_amount, remaining_amount, spent_amount, upcoming_amount,
+		       from_previous, percent, total_income
 		FROM budget 
 		WHERE user_id = ? AND period = ?
-	`, budget.UserID, budget.Period).Scan(&count)
-	if err != nil {
+		LIMIT 1
+	`, budget.UserID, budget.Period).Scan(&id, &before.Date, &before.TotalAmount, &before.RemainingAmount,
+		&before.SpentAmount, &before.UpcomingAmount, &before.FromPrevious, &before.Percent, &before.TotalIncome)
+	if err != nil && err != sql.ErrNoRows {
 		return err
 	}
 
-	if count > 0 {
+	change := journal.Change{Actor: actor, Entity: "budget", EntityID: id, After: budget}
+	if err == nil {
+		change.Before = before
+
 		// Update existing budget entry
-		_, err = db.Exec(`
+		_, err = tx.Exec(`
 			UPDATE budget
 			SET total_amount = ?,
 				remaining_amount = ?,
@@ -420,7 +459,8 @@ func updateBudgetData(budget BudgetData) error {
 		)
 	} else {
 		// Insert new budget entry
-		_, err = db.Exec(`
+		var result sql.Result
+		result, err = tx.Exec(`
 			INSERT INTO budget (
 				user_id, period, date, total_amount, remaining_amount, 
 				spent_amount, upcoming_amount, from_previous, percent, total_income
@@ -437,9 +477,18 @@ func updateBudgetData(budget BudgetData) error {
 			budget.Percent,
 			budget.TotalIncome,
 		)
+		if err == nil {
+			change.EntityID, err = result.LastInsertId()
+		}
+	}
+	if err != nil {
+		return err
 	}
 
-	return err
+	if err := audit.RecordTx(tx, budget.UserID, change); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 func sendSuccessResponse(w http.ResponseWriter, message string, data interface{}) {
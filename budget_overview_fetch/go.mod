@@ -2,4 +2,9 @@ module budget_overview_fetch
 
 go 1.21
 
-require github.com/mattn/go-sqlite3 v1.14.22
+require (
+	backend/common v0.0.0
+	github.com/mattn/go-sqlite3 v1.14.27
+)
+
+replace backend/common => ../common
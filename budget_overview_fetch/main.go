@@ -3,6 +3,7 @@ package main
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -12,31 +13,41 @@ import (
 	"strings"
 	"time"
 
+	"backend/common/auth"
+	"backend/common/currency"
+	"backend/common/journal"
+	"backend/common/listing"
+	"backend/common/money"
+	"backend/common/search"
+	"backend/common/tag"
+
 	_ "github.com/mattn/go-sqlite3"
 )
 
 // BudgetOverview represents the complete budget overview response
 type BudgetOverview struct {
-	RemainingAmount      float64              `json:"remaining_amount"`
+	RemainingAmount      money.Money          `json:"remaining_amount"`
 	ExpensePercent       float64              `json:"expense_percent"`
-	SpentAmount          float64              `json:"spent_amount"`
-	UpcomingAmount       float64              `json:"upcoming_amount"`
-	TotalAmount          float64              `json:"total_amount"`
-	TotalBalance         float64              `json:"total_balance"`
-	CombinedExpense      float64              `json:"combined_expense"`
-	TotalIncome          float64              `json:"total_income"`
-	DailyRate            float64              `json:"daily_rate"`
+	SpentAmount          money.Money          `json:"spent_amount"`
+	UpcomingAmount       money.Money          `json:"upcoming_amount"`
+	TotalAmount          money.Money          `json:"total_amount"`
+	TotalBalance         money.Money          `json:"total_balance"`
+	CombinedExpense      money.Money          `json:"combined_expense"`
+	TotalIncome          money.Money          `json:"total_income"`
+	DailyRate            money.Money          `json:"daily_rate"`
 	HighSpending         bool                 `json:"high_spending"`
 	IsNegativeBalance    bool                 `json:"is_negative_balance"`
 	MoneyFlow            MoneyFlow            `json:"money_flow"`
 	CashBankDistribution CashBankDistribution `json:"cash_bank_distribution"`
 	SavingsData          SavingsData          `json:"savings_data"`
-	AvailableBalance     float64              `json:"available_balance"`
+	AvailableBalance     money.Money          `json:"available_balance"`
+	CardDebt             money.Money          `json:"card_debt"` // Owed on credit cards at the end of the period
+	Currency             string               `json:"currency"`  // Base currency of every amount above
 }
 
 // MoneyFlow represents money flow from previous period
 type MoneyFlow struct {
-	FromPrevious float64 `json:"from_previous"`
+	FromPrevious money.Money `json:"from_previous"`
 }
 
 // BudgetOverviewRequest represents the request structure
@@ -57,57 +68,67 @@ type ApiResponse struct {
 
 // BalanceData represents the balance data from database
 type BalanceData struct {
-	IncomeBankAmount     float64 `json:"income_bank_amount"`
-	IncomeCashAmount     float64 `json:"income_cash_amount"`
-	ExpenseBankAmount    float64 `json:"expense_bank_amount"`
-	ExpenseCashAmount    float64 `json:"expense_cash_amount"`
-	BillBankAmount       float64 `json:"bill_bank_amount"`
-	BillCashAmount       float64 `json:"bill_cash_amount"`
-	BankAmount           float64 `json:"bank_amount"`
-	PreviousBankAmount   float64 `json:"previous_bank_amount"`
-	CashAmount           float64 `json:"cash_amount"`
-	PreviousCashAmount   float64 `json:"previous_cash_amount"`
-	BalanceCashAmount    float64 `json:"balance_cash_amount"`
-	BalanceBankAmount    float64 `json:"balance_bank_amount"`
-	TotalPreviousBalance float64 `json:"total_previous_balance"`
-	TotalBalance         float64 `json:"total_balance"`
+	IncomeBankAmount     money.Money `json:"income_bank_amount"`
+	IncomeCashAmount     money.Money `json:"income_cash_amount"`
+	ExpenseBankAmount    money.Money `json:"expense_bank_amount"`
+	ExpenseCashAmount    money.Money `json:"expense_cash_amount"`
+	BillBankAmount       money.Money `json:"bill_bank_amount"`
+	BillCashAmount       money.Money `json:"bill_cash_amount"`
+	BankAmount           money.Money `json:"bank_amount"`
+	PreviousBankAmount   money.Money `json:"previous_bank_amount"`
+	CashAmount           money.Money `json:"cash_amount"`
+	PreviousCashAmount   money.Money `json:"previous_cash_amount"`
+	BalanceCashAmount    money.Money `json:"balance_cash_amount"`
+	BalanceBankAmount    money.Money `json:"balance_bank_amount"`
+	TotalPreviousBalance money.Money `json:"total_previous_balance"`
+	TotalBalance         money.Money `json:"total_balance"`
+	IncomeCreditAmount   money.Money `json:"income_credit_amount"`
+	ExpenseCreditAmount  money.Money `json:"expense_credit_amount"`
+	BillCreditAmount     money.Money `json:"bill_credit_amount"`
+	BalanceCreditAmount  money.Money `json:"balance_credit_amount"`
 }
 
 // CashBankDistribution represents the cash and bank distribution
 type CashBankDistribution struct {
-	CashAmount  float64 `json:"cash_amount"`
-	CashPercent float64 `json:"cash_percent"`
-	BankAmount  float64 `json:"bank_amount"`
-	BankPercent float64 `json:"bank_percent"`
-	TotalAmount float64 `json:"total_amount"`
+	CashAmount  money.Money `json:"cash_amount"`
+	CashPercent float64     `json:"cash_percent"`
+	BankAmount  money.Money `json:"bank_amount"`
+	BankPercent float64     `json:"bank_percent"`
+	TotalAmount money.Money `json:"total_amount"`
 }
 
 // SavingsData represents savings information
 type SavingsData struct {
-	Available   float64 `json:"available"`
-	Goal        float64 `json:"goal"`
-	Period      string  `json:"period"` // New field for period type
-	Percent     float64 `json:"percent"`
-	NeedToSave  float64 `json:"need_to_save"`
-	DailyTarget float64 `json:"daily_target"`
+	Available   money.Money `json:"available"`
+	Goal        money.Money `json:"goal"`
+	Period      string      `json:"period"` // New field for period type
+	Percent     float64     `json:"percent"`
+	NeedToSave  money.Money `json:"need_to_save"`
+	DailyTarget money.Money `json:"daily_target"`
 }
 
-// Transaction represents a unified transaction (income, expense, or bill)
+// Transaction represents a unified transaction (income, expense, bill or transfer)
 type Transaction struct {
-	ID            int     `json:"id"`
-	Type          string  `json:"type"` // "income", "expense", "bill"
-	Amount        float64 `json:"amount"`
-	Date          string  `json:"date"`
-	Category      string  `json:"category"`
-	PaymentMethod string  `json:"payment_method"`
-	Description   string  `json:"description,omitempty"`
-	Name          string  `json:"name,omitempty"`         // For bills
-	Paid          *bool   `json:"paid,omitempty"`         // For bills (pointer to handle null)
-	Overdue       *bool   `json:"overdue,omitempty"`      // For bills (pointer to handle null)
-	OverdueDays   *int    `json:"overdue_days,omitempty"` // For bills (pointer to handle null)
-	Recurring     *bool   `json:"recurring,omitempty"`    // For bills (pointer to handle null)
-	Icon          string  `json:"icon,omitempty"`         // For bills
-	PaymentDay    *int    `json:"payment_day,omitempty"`  // For bills (pointer to handle null)
+	ID            int          `json:"id"`
+	Type          string       `json:"type"` // "income", "expense", "bill", "transfer"
+	Amount        money.Money  `json:"amount"`
+	Currency      string       `json:"currency,omitempty"` // Currency of amount
+	BaseAmount    money.Money  `json:"base_amount"`        // Amount in the base currency
+	Date          string       `json:"date"`
+	Category      string       `json:"category"`
+	PaymentMethod string       `json:"payment_method"`
+	Description   string       `json:"description,omitempty"`
+	Name          string       `json:"name,omitempty"`          // For bills
+	Paid          *bool        `json:"paid,omitempty"`          // For bills (pointer to handle null)
+	Overdue       *bool        `json:"overdue,omitempty"`       // For bills (pointer to handle null)
+	OverdueDays   *int         `json:"overdue_days,omitempty"`  // For bills (pointer to handle null)
+	Recurring     *bool        `json:"recurring,omitempty"`     // For bills (pointer to handle null)
+	Icon          string       `json:"icon,omitempty"`          // For bills
+	PaymentDay    *int         `json:"payment_day,omitempty"`   // For bills (pointer to handle null)
+	AccountID     int64        `json:"account_id,omitempty"`    // Account of the transaction; source for transfers
+	ToAccountID   int64        `json:"to_account_id,omitempty"` // For transfers
+	Fee           *money.Money `json:"fee,omitempty"`           // For transfers
+	Tags          []string     `json:"tags,omitempty"`          // Names of the tags of incomes, expenses and bills
 }
 
 // TransactionRequest represents the request structure for transaction queries
@@ -117,10 +138,26 @@ type TransactionRequest struct {
 	Date             string   `json:"date,omitempty"`              // Format depends on period type
 	StartDate        string   `json:"start_date,omitempty"`        // YYYY-MM-DD
 	EndDate          string   `json:"end_date,omitempty"`          // YYYY-MM-DD
-	TransactionTypes []string `json:"transaction_types,omitempty"` // ["income", "expense", "bill"]
+	TransactionTypes []string `json:"transaction_types,omitempty"` // ["income", "expense", "transfer"]
 	PaymentMethods   []string `json:"payment_methods,omitempty"`   // ["cash", "bank"]
 	Limit            int      `json:"limit,omitempty"`             // For pagination (default: 100)
 	Offset           int      `json:"offset,omitempty"`            // For pagination (default: 0)
+	// Filtros, orden y cursor del historial (ver listing.Query)
+	Categories []string     `json:"categories,omitempty"`
+	MinAmount  *money.Money `json:"min_amount,omitempty"`
+	MaxAmount  *money.Money `json:"max_amount,omitempty"`
+	Sort       string       `json:"sort,omitempty"`   // date (por defecto) o amount
+	Order      string       `json:"order,omitempty"`  // desc (por defecto) o asc
+	Cursor     string       `json:"cursor,omitempty"` // next_cursor de la página anterior
+	Tags       []string     `json:"tags,omitempty"`
+	TagMatch   string       `json:"tag_match,omitempty"` // all (por defecto) o any
+}
+
+// listQuery returns the filters, sort and page of the request.
+func (r TransactionRequest) listQuery() listing.Query {
+	return listing.Query{StartDate: r.StartDate, EndDate: r.EndDate, Types: r.TransactionTypes, Categories: r.Categories,
+		PaymentMethods: r.PaymentMethods, MinAmount: r.MinAmount, MaxAmount: r.MaxAmount, Sort: r.Sort, Order: r.Order,
+		Limit: r.Limit, Offset: r.Offset, Cursor: r.Cursor, Tags: r.Tags, TagMatch: r.TagMatch}
 }
 
 // TransactionHistoryResponse represents the response for transaction history
@@ -129,6 +166,8 @@ type TransactionHistoryResponse struct {
 	Total        int           `json:"total"`
 	Limit        int           `json:"limit"`
 	Offset       int           `json:"offset"`
+	NextCursor   string        `json:"next_cursor,omitempty"`
+	HasMore      bool          `json:"has_more"`
 	Period       string        `json:"period,omitempty"`
 	StartDate    string        `json:"start_date,omitempty"`
 	EndDate      string        `json:"end_date,omitempty"`
@@ -145,7 +184,12 @@ type UpcomingBillsResponse struct {
 }
 
 var (
-	db *sql.DB
+	db         *sql.DB
+	sessions   *auth.Manager
+	currencies *currency.Store
+	audit      *journal.Journal
+	index      *search.Index
+	tags       *tag.Store
 )
 
 func init() {
@@ -176,11 +220,52 @@ func init() {
 }
 
 func main() {
+	// Every request must carry an access token issued by signin, google_auth or apple-auth
+	var err error
+	sessions, err = auth.NewManagerFromEnv(db)
+	if err != nil {
+		log.Fatalf("Failed to initialize sessions: %v", err)
+	}
+
+	// Amounts are stored as cents; tables created with REAL amounts are converted
+	if err := money.Migrate(db); err != nil {
+		log.Fatalf("Failed to migrate amounts to cents: %v", err)
+	}
+
+	// The overview is reported in the user's base currency
+	currencies, err = currency.NewStoreFromEnv(db)
+	if err != nil {
+		log.Fatalf("Failed to initialize currencies: %v", err)
+	}
+
+	// The history of changes is read from the journal
+	audit, err = journal.New(db)
+	if err != nil {
+		log.Fatalf("Failed to initialize journal: %v", err)
+	}
+
+	// Descriptions, bill names and categories are searched through an index
+	index, err = search.New(db)
+	if err != nil {
+		log.Fatalf("Failed to initialize search index: %v", err)
+	}
+
+	// Incomes, expenses and bills carry tags besides their category
+	tags, err = tag.New(db)
+	if err != nil {
+		log.Fatalf("Failed to initialize tags: %v", err)
+	}
+
 	// Set up HTTP routes
-	http.HandleFunc("/budget-overview", corsMiddleware(handleBudgetOverview))
-	http.HandleFunc("/transactions/history", corsMiddleware(handleTransactionHistory))
-	http.HandleFunc("/transactions/upcoming-bills", corsMiddleware(handleUpcomingBills))
-	http.HandleFunc("/upcoming-bills", corsMiddleware(handleUpcomingBills))
+	http.HandleFunc("/budget-overview", corsMiddleware(sessions.Require(handleBudgetOverview)))
+	http.HandleFunc("/transactions/history", corsMiddleware(sessions.Require(handleTransactionHistory)))
+	http.HandleFunc("/transactions/search", corsMiddleware(sessions.Require(index.Handler())))
+	http.HandleFunc("/tags", corsMiddleware(sessions.Require(tags.Handler())))
+	http.HandleFunc("/tags/totals", corsMiddleware(sessions.Require(tags.TotalsHandler())))
+	http.HandleFunc("/transactions/tags", corsMiddleware(sessions.Require(tags.TransactionHandler())))
+	http.HandleFunc("/journal", corsMiddleware(sessions.Require(audit.Handler())))
+	http.HandleFunc("/transactions/upcoming-bills", corsMiddleware(sessions.Require(handleUpcomingBills)))
+	http.HandleFunc("/upcoming-bills", corsMiddleware(sessions.Require(handleUpcomingBills)))
 	http.HandleFunc("/health", corsMiddleware(handleHealth))
 	http.HandleFunc("/budget-overview/health", corsMiddleware(handleBudgetOverviewHealth))
 
@@ -287,6 +372,12 @@ func fetchBudgetOverview(request BudgetOverviewRequest) (*BudgetOverview, error)
 	// Calculate budget overview from balance data, passing the date
 	overview := calculateBudgetOverview(balanceData, request.Period, request.Date, request.UserID)
 
+	// The period balances only ever hold base amounts
+	overview.Currency, err = currencies.Base(request.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch base currency: %v", err)
+	}
+
 	return overview, nil
 }
 
@@ -328,7 +419,11 @@ func fetchBalanceData(tableName, userID, condition string) (*BalanceData, error)
 			COALESCE(balance_cash_amount, 0) as balance_cash_amount,
 			COALESCE(balance_bank_amount, 0) as balance_bank_amount,
 			COALESCE(total_previous_balance, 0) as total_previous_balance,
-			COALESCE(total_balance, 0) as total_balance
+			COALESCE(total_balance, 0) as total_balance,
+			COALESCE(income_credit_amount, 0) as income_credit_amount,
+			COALESCE(expense_credit_amount, 0) as expense_credit_amount,
+			COALESCE(bill_credit_amount, 0) as bill_credit_amount,
+			COALESCE(balance_credit_amount, 0) as balance_credit_amount
 		FROM %s 
 		WHERE user_id = ? AND %s
 	`, tableName, condition)
@@ -351,6 +446,10 @@ func fetchBalanceData(tableName, userID, condition string) (*BalanceData, error)
 		&data.BalanceBankAmount,
 		&data.TotalPreviousBalance,
 		&data.TotalBalance,
+		&data.IncomeCreditAmount,
+		&data.ExpenseCreditAmount,
+		&data.BillCreditAmount,
+		&data.BalanceCreditAmount,
 	)
 
 	if err != nil {
@@ -362,7 +461,7 @@ func fetchBalanceData(tableName, userID, condition string) (*BalanceData, error)
 		return nil, err
 	}
 
-	log.Printf("📊 Balance data found: IncomeBank=%.2f, IncomeCash=%.2f, ExpenseBank=%.2f, ExpenseCash=%.2f, BillBank=%.2f, BillCash=%.2f, TotalBalance=%.2f",
+	log.Printf("📊 Balance data found: IncomeBank=%s, IncomeCash=%s, ExpenseBank=%s, ExpenseCash=%s, BillBank=%s, BillCash=%s, TotalBalance=%s",
 		data.IncomeBankAmount, data.IncomeCashAmount, data.ExpenseBankAmount, data.ExpenseCashAmount,
 		data.BillBankAmount, data.BillCashAmount, data.TotalBalance)
 
@@ -422,33 +521,38 @@ func extractPeriodAndDateFromCondition(tableName, condition string) (string, str
 
 // calculateBudgetOverview calculates the budget overview from balance data
 func calculateBudgetOverview(data *BalanceData, period, date, userID string) *BudgetOverview {
-	// Calculate income from separate cash and bank income amounts
-	totalIncome := data.IncomeBankAmount + data.IncomeCashAmount
+	// Calculate income from separate cash, bank and card (refunds) income amounts
+	totalIncome := data.IncomeBankAmount + data.IncomeCashAmount + data.IncomeCreditAmount
 
-	// Calculate spent amount from actual expenses only (not including bills)
-	spentAmount := data.ExpenseBankAmount + data.ExpenseCashAmount
+	// Calculate spent amount from actual expenses only (not including bills).
+	// Card charges are spent even though the statement is not paid yet
+	spentAmount := data.ExpenseBankAmount + data.ExpenseCashAmount + data.ExpenseCreditAmount
 
 	// Calculate combined expense including both expenses and bills
-	combinedExpense := data.ExpenseBankAmount + data.ExpenseCashAmount + data.BillBankAmount + data.BillCashAmount
+	combinedExpense := spentAmount + data.BillBankAmount + data.BillCashAmount + data.BillCreditAmount
 
 	// Calculate available balance
 	availableBalance := totalIncome - combinedExpense
 
 	// Calculate upcoming bills separately for clarity (bills that haven't been paid yet)
-	upcomingAmount := data.BillBankAmount + data.BillCashAmount
+	upcomingAmount := data.BillBankAmount + data.BillCashAmount + data.BillCreditAmount
+
+	// Outstanding card debt, shown apart from the available balance
+	cardDebt := -data.BalanceCreditAmount
 
 	// Log the calculation breakdown for transparency
 	log.Printf("🧮 Budget calculation breakdown for period %s, date %s:", period, date)
-	log.Printf("   💰 Total Income: %.2f (Bank: %.2f + Cash: %.2f)",
+	log.Printf("   💰 Total Income: %s (Bank: %s + Cash: %s)",
 		totalIncome, data.IncomeBankAmount, data.IncomeCashAmount)
-	log.Printf("   💸 Spent Amount (expenses only): %.2f (Bank: %.2f + Cash: %.2f)",
+	log.Printf("   💸 Spent Amount (expenses only): %s (Bank: %s + Cash: %s)",
 		spentAmount, data.ExpenseBankAmount, data.ExpenseCashAmount)
-	log.Printf("   🏷️ Bills Amount: %.2f (Bank: %.2f + Cash: %.2f)",
+	log.Printf("   🏷️ Bills Amount: %s (Bank: %s + Cash: %s)",
 		data.BillBankAmount+data.BillCashAmount, data.BillBankAmount, data.BillCashAmount)
-	log.Printf("   📊 Combined Expense (expenses + bills): %.2f", combinedExpense)
-	log.Printf("   💵 Available Balance: %.2f (Income: %.2f - Combined Expenses: %.2f)",
+	log.Printf("   📊 Combined Expense (expenses + bills): %s", combinedExpense)
+	log.Printf("   💵 Available Balance: %s (Income: %s - Combined Expenses: %s)",
 		availableBalance, totalIncome, combinedExpense)
-	log.Printf("   📋 Upcoming Bills: %.2f", upcomingAmount)
+	log.Printf("   📋 Upcoming Bills: %s", upcomingAmount)
+	log.Printf("   💳 Card Debt: %s", cardDebt)
 
 	// Calculate remaining amount (should show real balance, including negative values)
 	remainingAmount := availableBalance
@@ -456,7 +560,7 @@ func calculateBudgetOverview(data *BalanceData, period, date, userID string) *Bu
 	// Calculate expense percentage
 	var expensePercent float64
 	if totalIncome > 0 {
-		expensePercent = (combinedExpense / totalIncome) * 100
+		expensePercent = (combinedExpense.Float64() / totalIncome.Float64()) * 100
 		if expensePercent > 100 {
 			expensePercent = 100
 		}
@@ -504,6 +608,7 @@ func calculateBudgetOverview(data *BalanceData, period, date, userID string) *Bu
 		CashBankDistribution: cashBankDistribution,
 		SavingsData:          savingsData,
 		AvailableBalance:     availableBalance,
+		CardDebt:             cardDebt,
 	}
 }
 
@@ -517,11 +622,11 @@ func calculateCashBankDistribution(data *BalanceData) CashBankDistribution {
 	var cashPercent, bankPercent float64
 
 	if totalAmount > 0 {
-		cashPercent = (totalCashAmount / totalAmount) * 100
-		bankPercent = (totalBankAmount / totalAmount) * 100
+		cashPercent = (totalCashAmount.Float64() / totalAmount.Float64()) * 100
+		bankPercent = (totalBankAmount.Float64() / totalAmount.Float64()) * 100
 	}
 
-	log.Printf("💳 Cash/Bank Distribution: Cash=%.2f (%.1f%%), Bank=%.2f (%.1f%%), Total=%.2f",
+	log.Printf("💳 Cash/Bank Distribution: Cash=%s (%.1f%%), Bank=%s (%.1f%%), Total=%s",
 		totalCashAmount, cashPercent, totalBankAmount, bankPercent, totalAmount)
 
 	return CashBankDistribution{
@@ -534,7 +639,7 @@ func calculateCashBankDistribution(data *BalanceData) CashBankDistribution {
 }
 
 // calculateDailyRate calculates the daily spending rate based on the period
-func calculateDailyRate(spentAmount float64, period string) float64 {
+func calculateDailyRate(spentAmount money.Money, period string) money.Money {
 	var days float64
 
 	switch period {
@@ -555,7 +660,7 @@ func calculateDailyRate(spentAmount float64, period string) float64 {
 	}
 
 	if days > 0 {
-		return spentAmount / days
+		return money.FromFloat(spentAmount.Float64() / days)
 	}
 
 	return 0
@@ -616,15 +721,19 @@ func sendErrorResponse(w http.ResponseWriter, message string, statusCode int) {
 }
 
 // getSavingsDataFromDB retrieves savings data from the database
-func getSavingsDataFromDB(userID string, remainingAmount float64, period string) SavingsData {
+func getSavingsDataFromDB(userID string, remainingAmount money.Money, period string) SavingsData {
 	// First try to get existing savings goal from database
-	var goal float64
-	var goalPeriod string
+	var goal money.Money
+	var goalPeriod, goalCurrency string
 
-	query := `SELECT goal, period FROM savings WHERE user_id = ? LIMIT 1`
+	query := `SELECT goal, period, COALESCE(currency, '') FROM savings WHERE user_id = ? LIMIT 1`
 	row := db.QueryRow(query, userID)
 
-	err := row.Scan(&goal, &goalPeriod)
+	err := row.Scan(&goal, &goalPeriod, &goalCurrency)
+	if err == nil {
+		// A goal in another currency is compared at today's rate
+		_, goal, err = currencies.ToBase(userID, goal, goalCurrency, time.Now().Format("2006-01-02"))
+	}
 	if err != nil {
 		// No savings goal found in database, return zero values
 		fmt.Printf("No savings goal found for user %s: %v\n", userID, err)
@@ -641,7 +750,7 @@ func getSavingsDataFromDB(userID string, remainingAmount float64, period string)
 	// Calculate percentage of goal achieved
 	var savingsPercent float64
 	if goal > 0 {
-		savingsPercent = (remainingAmount / goal) * 100
+		savingsPercent = (remainingAmount.Float64() / goal.Float64()) * 100
 		if savingsPercent > 100 {
 			savingsPercent = 100
 		}
@@ -654,7 +763,7 @@ func getSavingsDataFromDB(userID string, remainingAmount float64, period string)
 	}
 
 	// Calculate daily target based on period
-	var dailyTarget float64
+	var dailyTarget money.Money
 	var periodDays float64
 
 	switch period {
@@ -675,7 +784,7 @@ func getSavingsDataFromDB(userID string, remainingAmount float64, period string)
 	}
 
 	if periodDays > 0 && needToSave > 0 {
-		dailyTarget = needToSave / periodDays
+		dailyTarget = money.FromFloat(needToSave.Float64() / periodDays)
 	}
 
 	return SavingsData{
@@ -834,17 +943,18 @@ func findLastAvailablePeriod(tableName, userID, originalDate, period string) (*B
 				COALESCE(expense_bank_amount, 0) as expense_bank_amount,
 				COALESCE(expense_cash_amount, 0) as expense_cash_amount,
 				COALESCE(bill_bank_amount, 0) as bill_bank_amount,
-				COALESCE(bill_cash_amount, 0) as bill_cash_amount
+				COALESCE(bill_cash_amount, 0) as bill_cash_amount,
+				COALESCE(balance_credit_amount, 0) as balance_credit_amount
 			FROM %s 
 			WHERE user_id = ? AND %s
 		`, tableName, condition)
 
 		row := db.QueryRow(query, userID)
 
-		var totalPreviousBalance, totalBalance, incomeBankAmount, incomeCashAmount float64
-		var expenseBankAmount, expenseCashAmount, billBankAmount, billCashAmount float64
+		var totalPreviousBalance, totalBalance, incomeBankAmount, incomeCashAmount money.Money
+		var expenseBankAmount, expenseCashAmount, billBankAmount, billCashAmount, creditBalance money.Money
 		err = row.Scan(&totalPreviousBalance, &totalBalance, &incomeBankAmount, &incomeCashAmount,
-			&expenseBankAmount, &expenseCashAmount, &billBankAmount, &billCashAmount)
+			&expenseBankAmount, &expenseCashAmount, &billBankAmount, &billCashAmount, &creditBalance)
 
 		if err == nil {
 			// Found data! Use the total_balance from the last available period
@@ -868,9 +978,10 @@ func findLastAvailablePeriod(tableName, userID, originalDate, period string) (*B
 				BalanceBankAmount:    0,
 				TotalPreviousBalance: inheritedTotalBalance,
 				TotalBalance:         inheritedTotalBalance, // Use the last available total_balance
+				BalanceCreditAmount:  creditBalance,         // Card debt carries over until paid
 			}
 
-			log.Printf("📊 Balance inheritance: Using total_balance %.2f from %s as total_balance for requested period %s (user: %s)",
+			log.Printf("📊 Balance inheritance: Using total_balance %s from %s as total_balance for requested period %s (user: %s)",
 				inheritedTotalBalance, previousDate, originalDate, userID)
 			return data, nil
 		}
@@ -902,31 +1013,30 @@ func handleTransactionHistory(w http.ResponseWriter, r *http.Request) {
 
 	// Handle both GET and POST methods
 	if r.Method == http.MethodGet {
-		// Get parameters from query string
-		request.UserID = r.URL.Query().Get("user_id")
-		request.Period = r.URL.Query().Get("period")
-		request.Date = r.URL.Query().Get("date")
-		request.StartDate = r.URL.Query().Get("start_date")
-		request.EndDate = r.URL.Query().Get("end_date")
-
-		// Parse arrays from comma-separated values
-		if transactionTypes := r.URL.Query().Get("transaction_types"); transactionTypes != "" {
-			request.TransactionTypes = strings.Split(transactionTypes, ",")
-		}
-		if paymentMethods := r.URL.Query().Get("payment_methods"); paymentMethods != "" {
-			request.PaymentMethods = strings.Split(paymentMethods, ",")
-		}
-
-		// Parse integers
-		if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
-			if limit, err := strconv.Atoi(limitStr); err == nil {
-				request.Limit = limit
-			}
+		// Filters, sort and page from the query string; lists are comma-separated
+		q, err := listing.FromValues(r.URL.Query())
+		if err != nil {
+			sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
 		}
-		if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
-			if offset, err := strconv.Atoi(offsetStr); err == nil {
-				request.Offset = offset
-			}
+		request = TransactionRequest{
+			UserID:           r.URL.Query().Get("user_id"),
+			Period:           r.URL.Query().Get("period"),
+			Date:             r.URL.Query().Get("date"),
+			StartDate:        q.StartDate,
+			EndDate:          q.EndDate,
+			TransactionTypes: q.Types,
+			PaymentMethods:   q.PaymentMethods,
+			Limit:            q.Limit,
+			Offset:           q.Offset,
+			Categories:       q.Categories,
+			MinAmount:        q.MinAmount,
+			MaxAmount:        q.MaxAmount,
+			Sort:             q.Sort,
+			Order:            q.Order,
+			Cursor:           q.Cursor,
+			Tags:             q.Tags,
+			TagMatch:         q.TagMatch,
 		}
 	} else {
 		// POST method - decode JSON body
@@ -943,14 +1053,6 @@ func handleTransactionHistory(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Set defaults
-	if request.Limit <= 0 {
-		request.Limit = 100
-	}
-	if request.Limit > 1000 {
-		request.Limit = 1000 // Maximum limit
-	}
-
 	// Calculate date range if period is specified
 	if request.Period != "" && request.StartDate == "" && request.EndDate == "" {
 		startDate, endDate, err := calculatePeriodDateRangeWithBase(request.Period, request.Date)
@@ -965,6 +1067,10 @@ func handleTransactionHistory(w http.ResponseWriter, r *http.Request) {
 
 	// Fetch transaction history
 	response, err := fetchTransactionHistory(request)
+	if errors.Is(err, listing.ErrInvalid) {
+		sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		log.Printf("Error fetching transaction history: %v", err)
 		sendErrorResponse(w, "Failed to fetch transaction history", http.StatusInternalServerError)
@@ -1112,128 +1218,90 @@ func calculatePeriodDateRangeWithBase(period, baseDate string) (string, string,
 	return startDate.Format("2006-01-02"), endDate.Format("2006-01-02"), nil
 }
 
-// fetchTransactionHistory retrieves transaction history from the database
+// fetchTransactionHistory retrieves one page of the transaction history,
+// filtered and sorted as the request asks
 func fetchTransactionHistory(request TransactionRequest) (*TransactionHistoryResponse, error) {
-	var transactions []Transaction
-	var totalCount int
-
-	// Build the WHERE clause for filtering
-	whereConditions := []string{"user_id = ?"}
-	args := []interface{}{request.UserID}
-
-	// Add date range filter
-	if request.StartDate != "" && request.EndDate != "" {
-		whereConditions = append(whereConditions, "date BETWEEN ? AND ?")
-		args = append(args, request.StartDate, request.EndDate)
-	} else if request.StartDate != "" {
-		whereConditions = append(whereConditions, "date >= ?")
-		args = append(args, request.StartDate)
-	} else if request.EndDate != "" {
-		whereConditions = append(whereConditions, "date <= ?")
-		args = append(args, request.EndDate)
-	}
-
-	// Build payment method filter
-	var paymentMethodFilter string
-	if len(request.PaymentMethods) > 0 {
-		placeholders := make([]string, len(request.PaymentMethods))
-		for i, method := range request.PaymentMethods {
-			placeholders[i] = "?"
-			args = append(args, method)
-		}
-		paymentMethodFilter = fmt.Sprintf("payment_method IN (%s)", strings.Join(placeholders, ","))
+	q := request.listQuery()
+	if err := q.Normalize(); err != nil {
+		return nil, err
 	}
 
-	// Build transaction type filter - Only include incomes and expenses, no bills
+	// Only incomes, expenses and transfers, no bills
 	var queries []string
-	includeIncomes := len(request.TransactionTypes) == 0 || contains(request.TransactionTypes, "income")
-	includeExpenses := len(request.TransactionTypes) == 0 || contains(request.TransactionTypes, "expense")
-	// Bills are excluded from transaction history - they are handled separately in upcoming bills
-
-	// Income query
-	if includeIncomes {
-		incomeWhere := strings.Join(whereConditions, " AND ")
-		if paymentMethodFilter != "" {
-			incomeWhere += " AND " + paymentMethodFilter
-		}
+	var args []interface{}
 
-		incomeQuery := fmt.Sprintf(`
+	if q.Has("income") {
+		queries = append(queries, `
 			SELECT 
-				id, 'income' as type, amount, date, category, payment_method, description,
+				id, 'income' as type, amount, COALESCE(currency, '') as currency,
+				COALESCE(base_amount, amount) as base_amount, date, category, payment_method, description,
 				NULL as name, NULL as paid, NULL as overdue, NULL as overdue_days,
-				NULL as recurring, NULL as icon
+				NULL as recurring, NULL as icon,
+				COALESCE(account_id, 0) as account_id, 0 as to_account_id, NULL as fee
 			FROM incomes 
-			WHERE %s`, incomeWhere)
-		queries = append(queries, incomeQuery)
+			WHERE user_id = ?`)
+		args = append(args, request.UserID)
 	}
 
-	// Expense query
-	if includeExpenses {
-		expenseWhere := strings.Join(whereConditions, " AND ")
-		if paymentMethodFilter != "" {
-			expenseWhere += " AND " + paymentMethodFilter
-		}
-
-		expenseQuery := fmt.Sprintf(`
+	if q.Has("expense") {
+		queries = append(queries, `
 			SELECT 
-				id, 'expense' as type, amount, date, category, payment_method, description,
+				id, 'expense' as type, amount, COALESCE(currency, '') as currency,
+				COALESCE(base_amount, amount) as base_amount, date, category, payment_method, description,
 				NULL as name, NULL as paid, NULL as overdue, NULL as overdue_days,
-				NULL as recurring, NULL as icon
+				NULL as recurring, NULL as icon,
+				COALESCE(account_id, 0) as account_id, 0 as to_account_id, NULL as fee
 			FROM expenses 
-			WHERE %s`, expenseWhere)
-		queries = append(queries, expenseQuery)
+			WHERE user_id = ?`)
+		args = append(args, request.UserID)
+	}
+
+	// Transfer query - amounts are already in the base currency. Transfers
+	// have no payment method, so filtering by one leaves them out
+	if q.Has("transfer") {
+		queries = append(queries, `
+			SELECT 
+				id, 'transfer' as type, amount, '' as currency,
+				amount as base_amount, date, '' as category, '' as payment_method, memo as description,
+				NULL as name, NULL as paid, NULL as overdue, NULL as overdue_days,
+				NULL as recurring, NULL as icon,
+				from_account_id as account_id, to_account_id, fee
+			FROM transfers 
+			WHERE user_id = ?`)
+		args = append(args, request.UserID)
 	}
 
 	// Note: Bills are intentionally excluded from transaction history
 	// Bills represent future obligations and appear only in the upcoming bills endpoint
 	// When bills are paid, they create expense records which appear in this history
 
+	response := &TransactionHistoryResponse{
+		Transactions: []Transaction{},
+		Limit:        q.Limit,
+		Offset:       q.Offset,
+		StartDate:    request.StartDate,
+		EndDate:      request.EndDate,
+		Period:       request.Period,
+	}
 	if len(queries) == 0 {
-		return &TransactionHistoryResponse{
-			Transactions: []Transaction{},
-			Total:        0,
-			Limit:        request.Limit,
-			Offset:       request.Offset,
-			StartDate:    request.StartDate,
-			EndDate:      request.EndDate,
-			Period:       request.Period,
-		}, nil
+		return response, nil
 	}
 
 	// Combine queries with UNION ALL
-	unionQuery := strings.Join(queries, " UNION ALL ")
-	finalQuery := fmt.Sprintf(`
-		SELECT * FROM (%s) 
-		ORDER BY date DESC 
-		LIMIT ? OFFSET ?`, unionQuery)
-
-	// Add limit and offset to args
-	finalArgs := make([]interface{}, 0)
-	for range queries {
-		finalArgs = append(finalArgs, args...)
-	}
-	finalArgs = append(finalArgs, request.Limit, request.Offset)
-
-	// Execute query
-	rows, err := db.Query(finalQuery, finalArgs...)
-	if err != nil {
-		return nil, fmt.Errorf("failed to query transactions: %v", err)
-	}
-	defer rows.Close()
-
-	// Scan results
-	for rows.Next() {
+	from := "(" + strings.Join(queries, " UNION ALL ") + ")"
+	page, err := q.Run(db, from, args, func(rows *sql.Rows) (listing.Key, error) {
 		var t Transaction
 		var paid, overdue, recurring sql.NullBool
-		var overdueDays sql.NullInt64
+		var overdueDays, fee sql.NullInt64
 		var name, description, icon sql.NullString
 
 		err := rows.Scan(
-			&t.ID, &t.Type, &t.Amount, &t.Date, &t.Category, &t.PaymentMethod,
+			&t.ID, &t.Type, &t.Amount, &t.Currency, &t.BaseAmount, &t.Date, &t.Category, &t.PaymentMethod,
 			&description, &name, &paid, &overdue, &overdueDays, &recurring, &icon,
+			&t.AccountID, &t.ToAccountID, &fee,
 		)
 		if err != nil {
-			return nil, fmt.Errorf("failed to scan transaction: %v", err)
+			return listing.Key{}, fmt.Errorf("failed to scan transaction: %v", err)
 		}
 
 		// Handle nullable fields
@@ -1259,34 +1327,41 @@ func fetchTransactionHistory(request TransactionRequest) (*TransactionHistoryRes
 			days := int(overdueDays.Int64)
 			t.OverdueDays = &days
 		}
+		if fee.Valid {
+			transferFee := money.Money(fee.Int64)
+			t.Fee = &transferFee
+		}
 
-		transactions = append(transactions, t)
+		response.Transactions = append(response.Transactions, t)
+		return listing.Key{Type: t.Type, ID: int64(t.ID), Date: t.Date, Amount: t.BaseAmount}, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query transactions: %w", err)
 	}
+	response.Total, response.NextCursor, response.HasMore = page.Total, page.NextCursor, page.HasMore
 
-	// Get total count (without limit/offset)
-	countQuery := fmt.Sprintf("SELECT COUNT(*) FROM (%s)", unionQuery)
-	countArgs := make([]interface{}, 0)
-	for range queries {
-		countArgs = append(countArgs, args...)
+	// Add the tags of the transactions of the page
+	ids := map[string][]int64{}
+	for _, t := range response.Transactions {
+		if t.Type != "transfer" {
+			ids[t.Type] = append(ids[t.Type], int64(t.ID))
+		}
 	}
-
-	err = db.QueryRow(countQuery, countArgs...).Scan(&totalCount)
-	if err != nil {
-		log.Printf("Warning: failed to get total count: %v", err)
-		totalCount = len(transactions)
+	for kind, kindIDs := range ids {
+		names, err := tags.Of(request.UserID, kind, kindIDs)
+		if err != nil {
+			return nil, err
+		}
+		for i, t := range response.Transactions {
+			if t.Type == kind {
+				response.Transactions[i].Tags = names[int64(t.ID)]
+			}
+		}
 	}
 
-	log.Printf("📊 Transaction history retrieved: %d transactions (incomes + expenses only, bills excluded)", totalCount)
-
-	return &TransactionHistoryResponse{
-		Transactions: transactions,
-		Total:        totalCount,
-		Limit:        request.Limit,
-		Offset:       request.Offset,
-		StartDate:    request.StartDate,
-		EndDate:      request.EndDate,
-		Period:       request.Period,
-	}, nil
+	log.Printf("📊 Transaction history retrieved: %d of %d transactions (incomes, expenses and transfers, bills excluded)",
+		len(response.Transactions), page.Total)
+	return response, nil
 }
 
 // fetchUpcomingBills retrieves all bills with payment status for specific month from bill_payments
@@ -1330,7 +1405,7 @@ func fetchUpcomingBills(request TransactionRequest) (*UpcomingBillsResponse, err
 		// For specific month queries, check payment status for that month ONLY from bill_payments
 		query = fmt.Sprintf(`
 			SELECT 
-				b.id, b.name, b.amount, 
+				b.id, b.name, b.amount, COALESCE(b.currency, ''), COALESCE(b.base_amount, b.amount),
 				CASE 
 					WHEN b.recurring = 1 THEN 
 						strftime('%%Y-%%m-%%d', 
@@ -1349,7 +1424,8 @@ func fetchUpcomingBills(request TransactionRequest) (*UpcomingBillsResponse, err
 		whereConditions = append(whereConditions, "b.paid = 0")
 		query = fmt.Sprintf(`
 			SELECT 
-				b.id, b.name, b.amount, b.due_date as calculated_due_date, 
+				b.id, b.name, b.amount, COALESCE(b.currency, ''), COALESCE(b.base_amount, b.amount),
+				b.due_date as calculated_due_date, 
 				0 as month_paid, 
 				b.overdue, b.overdue_days, b.recurring, b.category, b.icon,
 				b.start_date, b.duration_months, b.payment_day
@@ -1382,7 +1458,7 @@ func fetchUpcomingBills(request TransactionRequest) (*UpcomingBillsResponse, err
 		var paymentDay, durationMonths sql.NullInt64
 
 		err := rows.Scan(
-			&t.ID, &t.Name, &t.Amount, &t.Date, &monthPaid, &overdueFlag, &overdueDays,
+			&t.ID, &t.Name, &t.Amount, &t.Currency, &t.BaseAmount, &t.Date, &monthPaid, &overdueFlag, &overdueDays,
 			&recurring, &t.Category, &t.Icon, &startDate, &durationMonths, &paymentDay,
 		)
 		if err != nil {
@@ -1464,8 +1540,8 @@ func contains(slice []string, item string) bool {
 }
 
 // fetchPaidBillsAmount retrieves the total amount of paid bills for a specific period and date
-func fetchPaidBillsAmount(userID, period, date string) (float64, float64, error) {
-	var bankAmount, cashAmount float64
+func fetchPaidBillsAmount(userID, period, date string) (money.Money, money.Money, error) {
+	var bankAmount, cashAmount money.Money
 	var dateCondition string
 
 	// Build date condition based on period type
@@ -1491,8 +1567,8 @@ func fetchPaidBillsAmount(userID, period, date string) (float64, float64, error)
 
 	query := fmt.Sprintf(`
 		SELECT 
-			COALESCE(SUM(CASE WHEN payment_method = 'bank' THEN amount ELSE 0 END), 0) as bank_amount,
-			COALESCE(SUM(CASE WHEN payment_method = 'cash' THEN amount ELSE 0 END), 0) as cash_amount
+			COALESCE(SUM(CASE WHEN payment_method = 'bank' THEN COALESCE(base_amount, amount) ELSE 0 END), 0) as bank_amount,
+			COALESCE(SUM(CASE WHEN payment_method = 'cash' THEN COALESCE(base_amount, amount) ELSE 0 END), 0) as cash_amount
 		FROM bills 
 		WHERE user_id = ? AND paid = 1 AND %s
 	`, dateCondition)
@@ -1507,13 +1583,13 @@ func fetchPaidBillsAmount(userID, period, date string) (float64, float64, error)
 		return 0, 0, fmt.Errorf("failed to fetch paid bills: %v", err)
 	}
 
-	log.Printf("💳 Paid bills for %s %s: Bank=%.2f, Cash=%.2f", period, date, bankAmount, cashAmount)
+	log.Printf("💳 Paid bills for %s %s: Bank=%s, Cash=%s", period, date, bankAmount, cashAmount)
 	return bankAmount, cashAmount, nil
 }
 
 // fetchUnpaidBillsAmount retrieves the total amount of unpaid bills for a specific period and date
-func fetchUnpaidBillsAmount(userID, period, date string) (float64, float64, error) {
-	var bankAmount, cashAmount float64
+func fetchUnpaidBillsAmount(userID, period, date string) (money.Money, money.Money, error) {
+	var bankAmount, cashAmount money.Money
 	var dateCondition string
 
 	// Build date condition based on period type (same logic as paid bills)
@@ -1536,8 +1612,8 @@ func fetchUnpaidBillsAmount(userID, period, date string) (float64, float64, erro
 
 	query := fmt.Sprintf(`
 		SELECT 
-			COALESCE(SUM(CASE WHEN payment_method = 'bank' THEN amount ELSE 0 END), 0) as bank_amount,
-			COALESCE(SUM(CASE WHEN payment_method = 'cash' THEN amount ELSE 0 END), 0) as cash_amount
+			COALESCE(SUM(CASE WHEN payment_method = 'bank' THEN COALESCE(base_amount, amount) ELSE 0 END), 0) as bank_amount,
+			COALESCE(SUM(CASE WHEN payment_method = 'cash' THEN COALESCE(base_amount, amount) ELSE 0 END), 0) as cash_amount
 		FROM bills 
 		WHERE user_id = ? AND paid = 0 AND %s
 	`, dateCondition)
@@ -1552,7 +1628,7 @@ func fetchUnpaidBillsAmount(userID, period, date string) (float64, float64, erro
 		return 0, 0, fmt.Errorf("failed to fetch unpaid bills: %v", err)
 	}
 
-	log.Printf("⏳ Unpaid bills for %s %s: Bank=%.2f, Cash=%.2f", period, date, bankAmount, cashAmount)
+	log.Printf("⏳ Unpaid bills for %s %s: Bank=%s, Cash=%s", period, date, bankAmount, cashAmount)
 	return bankAmount, cashAmount, nil
 }
 
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"backend/common/account"
+	"backend/common/money"
+)
+
+// AccountRequest is the body of /accounts/add and /accounts/update. On
+// update only the fields sent change.
+type AccountRequest struct {
+	UserID         string       `json:"user_id"`
+	AccountID      int64        `json:"account_id,omitempty"`
+	Name           string       `json:"name,omitempty"`
+	Type           string       `json:"type,omitempty"`
+	OpeningBalance *money.Money `json:"opening_balance,omitempty"`
+	OpeningDate    string       `json:"opening_date,omitempty"`
+	CreditLimit    *money.Money `json:"credit_limit,omitempty"`
+	ClosingDay     *int         `json:"closing_day,omitempty"`
+	DueDay         *int         `json:"due_day,omitempty"`
+}
+
+type DeleteAccountRequest struct {
+	UserID    string `json:"user_id"`
+	AccountID int64  `json:"account_id"`
+}
+
+func handleListAccounts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		sendErrorResponse(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+
+	list, err := accounts.List(userID)
+	if err != nil {
+		log.Printf("Error fetching accounts: %v", err)
+		sendErrorResponse(w, "Error fetching accounts", http.StatusInternalServerError)
+		return
+	}
+
+	sendSuccessResponse(w, "Accounts fetched successfully", list)
+}
+
+func handleAddAccount(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var addRequest AccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&addRequest); err != nil {
+		sendErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if addRequest.UserID == "" {
+		sendErrorResponse(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+
+	newAccount := account.Account{
+		UserID:      addRequest.UserID,
+		Name:        addRequest.Name,
+		Type:        addRequest.Type,
+		OpeningDate: addRequest.OpeningDate,
+	}
+	if addRequest.OpeningBalance != nil {
+		newAccount.OpeningBalance = *addRequest.OpeningBalance
+	}
+	addRequest.applyCard(&newAccount)
+
+	created, err := accounts.Create(newAccount)
+	if err != nil {
+		sendAccountError(w, err, "Error adding account")
+		return
+	}
+
+	sendSuccessResponse(w, "Account added successfully", created)
+}
+
+func handleUpdateAccount(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var updateRequest AccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&updateRequest); err != nil {
+		sendErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if updateRequest.UserID == "" {
+		sendErrorResponse(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+	if updateRequest.AccountID <= 0 {
+		sendErrorResponse(w, "Valid account ID is required", http.StatusBadRequest)
+		return
+	}
+
+	current, err := accounts.Get(updateRequest.UserID, updateRequest.AccountID)
+	if err != nil {
+		sendAccountError(w, err, "Error updating account")
+		return
+	}
+
+	// Only the fields sent change
+	if updateRequest.Name != "" {
+		current.Name = updateRequest.Name
+	}
+	if updateRequest.Type != "" {
+		current.Type = updateRequest.Type
+	}
+	if updateRequest.OpeningBalance != nil {
+		current.OpeningBalance = *updateRequest.OpeningBalance
+	}
+	if updateRequest.OpeningDate != "" {
+		current.OpeningDate = updateRequest.OpeningDate
+	}
+	updateRequest.applyCard(&current)
+
+	updated, err := accounts.Update(current)
+	if err != nil {
+		sendAccountError(w, err, "Error updating account")
+		return
+	}
+
+	sendSuccessResponse(w, "Account updated successfully", updated)
+}
+
+// applyCard copies the credit card settings sent into a
+func (req AccountRequest) applyCard(a *account.Account) {
+	if req.CreditLimit != nil {
+		a.CreditLimit = *req.CreditLimit
+	}
+	if req.ClosingDay != nil {
+		a.ClosingDay = *req.ClosingDay
+	}
+	if req.DueDay != nil {
+		a.DueDay = *req.DueDay
+	}
+}
+
+// handleAccountStatement returns what a credit card owes for the cycle
+// closing in month, the last closed one by default
+func handleAccountStatement(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		sendErrorResponse(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+	accountID, err := strconv.ParseInt(r.URL.Query().Get("account_id"), 10, 64)
+	if err != nil || accountID <= 0 {
+		sendErrorResponse(w, "Valid account ID is required", http.StatusBadRequest)
+		return
+	}
+
+	var statement account.Statement
+	err = inTransaction(func(tx *sql.Tx) error {
+		card, err := accounts.GetTx(tx, userID, accountID)
+		if err != nil {
+			return err
+		}
+		month := r.URL.Query().Get("month")
+		if month == "" {
+			if month, err = card.LastClosed(time.Now().Format("2006-01-02")); err != nil {
+				return err
+			}
+		}
+		statement, err = accounts.StatementTx(tx, card, month)
+		return err
+	})
+	if err != nil {
+		sendAccountError(w, err, "Error fetching statement")
+		return
+	}
+
+	sendSuccessResponse(w, "Statement fetched successfully", statement)
+}
+
+func handleDeleteAccount(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var deleteRequest DeleteAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&deleteRequest); err != nil {
+		sendErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if deleteRequest.UserID == "" {
+		sendErrorResponse(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+	if deleteRequest.AccountID <= 0 {
+		sendErrorResponse(w, "Valid account ID is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := accounts.Delete(deleteRequest.UserID, deleteRequest.AccountID); err != nil {
+		sendAccountError(w, err, "Error deleting account")
+		return
+	}
+
+	sendSuccessResponse(w, "Account deleted successfully", map[string]interface{}{
+		"account_id": deleteRequest.AccountID,
+		"user_id":    deleteRequest.UserID,
+	})
+}
+
+// sendAccountError answers an account that does not exist, is not valid
+// or cannot be deleted
+func sendAccountError(w http.ResponseWriter, err error, message string) {
+	switch {
+	case errors.Is(err, account.ErrNotFound):
+		sendErrorResponse(w, "Account not found", http.StatusNotFound)
+	case errors.Is(err, account.ErrInvalid):
+		sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, account.ErrInUse):
+		sendErrorResponse(w, err.Error(), http.StatusConflict)
+	default:
+		log.Printf("%s: %v", message, err)
+		sendErrorResponse(w, message, http.StatusInternalServerError)
+	}
+}
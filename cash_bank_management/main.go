@@ -10,30 +10,46 @@ import (
 	"path/filepath"
 	"time"
 
+	"backend/common/account"
+	"backend/common/auth"
+	"backend/common/journal"
+	"backend/common/ledger"
+	"backend/common/money"
+
 	_ "github.com/mattn/go-sqlite3"
 )
 
 // Definición de estructuras de datos
 type CashBankDistribution struct {
-	UserID       string  `json:"user_id"`
-	Month        string  `json:"month"`
-	CashAmount   float64 `json:"cash_amount"`
-	CashPercent  float64 `json:"cash_percent"`
-	BankAmount   float64 `json:"bank_amount"`
-	BankPercent  float64 `json:"bank_percent"`
-	MonthlyTotal float64 `json:"monthly_total"`
+	UserID       string      `json:"user_id"`
+	Month        string      `json:"month"`
+	CashAmount   money.Money `json:"cash_amount"`
+	CashPercent  float64     `json:"cash_percent"`
+	BankAmount   money.Money `json:"bank_amount"`
+	BankPercent  float64     `json:"bank_percent"`
+	MonthlyTotal money.Money `json:"monthly_total"`
+	// Accounts reparte el dinero entre las cuentas del usuario; solo lo
+	// rellena /cash-bank/distribution
+	Accounts []AccountShare `json:"accounts,omitempty"`
+}
+
+// AccountShare is one account of the distribution with its part of the
+// sum of every account's balance
+type AccountShare struct {
+	account.Account
+	Percent float64 `json:"percent"`
 }
 
 type TransferRequest struct {
-	UserID string  `json:"user_id"`
-	Amount float64 `json:"amount"`
-	Date   string  `json:"date"`
+	UserID string      `json:"user_id"`
+	Amount money.Money `json:"amount"`
+	Date   string      `json:"date"`
 }
 
 type UpdateAmountRequest struct {
-	UserID string  `json:"user_id"`
-	Amount float64 `json:"amount"`
-	Date   string  `json:"date"`
+	UserID string      `json:"user_id"`
+	Amount money.Money `json:"amount"`
+	Date   string      `json:"date"`
 }
 
 type ApiResponse struct {
@@ -43,7 +59,11 @@ type ApiResponse struct {
 }
 
 var (
-	db *sql.DB
+	db       *sql.DB
+	sessions *auth.Manager
+	balances *ledger.Ledger
+	accounts *account.Store
+	audit    *journal.Journal
 )
 
 func init() {
@@ -83,11 +103,11 @@ func createTablesIfNotExist() {
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
 			user_id TEXT NOT NULL,
 			month TEXT NOT NULL,
-			cash_amount REAL NOT NULL,
+			cash_amount INTEGER NOT NULL,
 			cash_percent REAL NOT NULL,
-			bank_amount REAL NOT NULL,
+			bank_amount INTEGER NOT NULL,
 			bank_percent REAL NOT NULL,
-			monthly_total REAL NOT NULL,
+			monthly_total INTEGER NOT NULL,
 			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 		)
@@ -102,7 +122,7 @@ func createTablesIfNotExist() {
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
 			user_id TEXT NOT NULL,
 			transaction_type TEXT NOT NULL,
-			amount REAL NOT NULL,
+			amount INTEGER NOT NULL,
 			date TEXT NOT NULL,
 			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 		)
@@ -111,196 +131,71 @@ func createTablesIfNotExist() {
 		log.Fatalf("Failed to create cash_bank_transactions table: %v", err)
 	}
 
-	// Create monthly_cash_bank_balance table (requerida por fetchCashBankDistribution)
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS monthly_cash_bank_balance (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			user_id TEXT NOT NULL,
-			year_month TEXT NOT NULL,
-			income_bank_amount REAL DEFAULT 0,
-			income_cash_amount REAL DEFAULT 0,
-			expense_bank_amount REAL DEFAULT 0,
-			expense_cash_amount REAL DEFAULT 0,
-			bill_bank_amount REAL DEFAULT 0,
-			bill_cash_amount REAL DEFAULT 0,
-			bank_amount REAL DEFAULT 0,
-			previous_bank_amount REAL DEFAULT 0,
-			cash_amount REAL DEFAULT 0,
-			previous_cash_amount REAL DEFAULT 0,
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			balance_cash_amount REAL DEFAULT 0,
-			balance_bank_amount REAL DEFAULT 0,
-			total_previous_balance REAL DEFAULT 0,
-			total_balance REAL DEFAULT 0,
-			UNIQUE(user_id, year_month)
-		)
-	`)
-	if err != nil {
-		log.Fatalf("Failed to create monthly_cash_bank_balance table: %v", err)
+	// Transfers between accounts record both ends
+	for _, column := range []string{"from_account_id", "to_account_id"} {
+		if err := account.AddColumn(db, "cash_bank_transactions", column); err != nil {
+			log.Fatalf("Failed to add %s to cash_bank_transactions: %v", column, err)
+		}
 	}
 
-	// Create indices for better performance
-	db.Exec("CREATE INDEX IF NOT EXISTS idx_monthly_cash_bank_balance_user ON monthly_cash_bank_balance(user_id)")
-	db.Exec("CREATE INDEX IF NOT EXISTS idx_monthly_cash_bank_balance_month ON monthly_cash_bank_balance(year_month)")
-
-	// Create daily_cash_bank_balance table (requerida por updateAllPeriodTables)
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS daily_cash_bank_balance (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			user_id TEXT NOT NULL,
-			date TEXT NOT NULL,
-			income_bank_amount REAL DEFAULT 0,
-			income_cash_amount REAL DEFAULT 0,
-			expense_bank_amount REAL DEFAULT 0,
-			expense_cash_amount REAL DEFAULT 0,
-			bill_bank_amount REAL DEFAULT 0,
-			bill_cash_amount REAL DEFAULT 0,
-			bank_amount REAL DEFAULT 0,
-			previous_bank_amount REAL DEFAULT 0,
-			cash_amount REAL DEFAULT 0,
-			previous_cash_amount REAL DEFAULT 0,
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			balance_cash_amount REAL DEFAULT 0,
-			balance_bank_amount REAL DEFAULT 0,
-			total_previous_balance REAL DEFAULT 0,
-			total_balance REAL DEFAULT 0,
-			UNIQUE(user_id, date)
-		)
-	`)
-	if err != nil {
-		log.Fatalf("Failed to create daily_cash_bank_balance table: %v", err)
+	// Create transfers table
+	if err := createTransfersTable(); err != nil {
+		log.Fatalf("Failed to create transfers table: %v", err)
 	}
 
-	// Create indices for daily table
-	db.Exec("CREATE INDEX IF NOT EXISTS idx_daily_cash_bank_balance_user ON daily_cash_bank_balance(user_id)")
-	db.Exec("CREATE INDEX IF NOT EXISTS idx_daily_cash_bank_balance_date ON daily_cash_bank_balance(date)")
+	// Amounts are stored as cents; tables created with REAL amounts are converted
+	if err := money.Migrate(db); err != nil {
+		log.Fatalf("Failed to migrate amounts to cents: %v", err)
+	}
 
-	// Create weekly_cash_bank_balance table
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS weekly_cash_bank_balance (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			user_id TEXT NOT NULL,
-			year_week TEXT NOT NULL,
-			income_bank_amount REAL DEFAULT 0,
-			income_cash_amount REAL DEFAULT 0,
-			expense_bank_amount REAL DEFAULT 0,
-			expense_cash_amount REAL DEFAULT 0,
-			bill_bank_amount REAL DEFAULT 0,
-			bill_cash_amount REAL DEFAULT 0,
-			bank_amount REAL DEFAULT 0,
-			previous_bank_amount REAL DEFAULT 0,
-			cash_amount REAL DEFAULT 0,
-			previous_cash_amount REAL DEFAULT 0,
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			balance_cash_amount REAL DEFAULT 0,
-			balance_bank_amount REAL DEFAULT 0,
-			total_previous_balance REAL DEFAULT 0,
-			total_balance REAL DEFAULT 0,
-			UNIQUE(user_id, year_week)
-		)
-	`)
+	// The period balance tables belong to the shared ledger
+	balances, err = ledger.New(db)
 	if err != nil {
-		log.Fatalf("Failed to create weekly_cash_bank_balance table: %v", err)
+		log.Fatalf("Failed to initialize balance ledger: %v", err)
 	}
 
-	// Create quarterly_cash_bank_balance table
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS quarterly_cash_bank_balance (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			user_id TEXT NOT NULL,
-			year_quarter TEXT NOT NULL,
-			income_bank_amount REAL DEFAULT 0,
-			income_cash_amount REAL DEFAULT 0,
-			expense_bank_amount REAL DEFAULT 0,
-			expense_cash_amount REAL DEFAULT 0,
-			bill_bank_amount REAL DEFAULT 0,
-			bill_cash_amount REAL DEFAULT 0,
-			bank_amount REAL DEFAULT 0,
-			previous_bank_amount REAL DEFAULT 0,
-			cash_amount REAL DEFAULT 0,
-			previous_cash_amount REAL DEFAULT 0,
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			balance_cash_amount REAL DEFAULT 0,
-			balance_bank_amount REAL DEFAULT 0,
-			total_previous_balance REAL DEFAULT 0,
-			total_balance REAL DEFAULT 0,
-			UNIQUE(user_id, year_quarter)
-		)
-	`)
+	// Users keep their money in accounts; cash and bank are their two sides
+	accounts, err = account.NewStore(db, balances)
 	if err != nil {
-		log.Fatalf("Failed to create quarterly_cash_bank_balance table: %v", err)
+		log.Fatalf("Failed to initialize accounts: %v", err)
 	}
 
-	// Create semiannual_cash_bank_balance table
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS semiannual_cash_bank_balance (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			user_id TEXT NOT NULL,
-			year_half TEXT NOT NULL,
-			income_bank_amount REAL DEFAULT 0,
-			income_cash_amount REAL DEFAULT 0,
-			expense_bank_amount REAL DEFAULT 0,
-			expense_cash_amount REAL DEFAULT 0,
-			bill_bank_amount REAL DEFAULT 0,
-			bill_cash_amount REAL DEFAULT 0,
-			bank_amount REAL DEFAULT 0,
-			previous_bank_amount REAL DEFAULT 0,
-			cash_amount REAL DEFAULT 0,
-			previous_cash_amount REAL DEFAULT 0,
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			balance_cash_amount REAL DEFAULT 0,
-			balance_bank_amount REAL DEFAULT 0,
-			total_previous_balance REAL DEFAULT 0,
-			total_balance REAL DEFAULT 0,
-			UNIQUE(user_id, year_half)
-		)
-	`)
+	// Every transfer and manual correction is recorded in the journal
+	audit, err = journal.New(db)
 	if err != nil {
-		log.Fatalf("Failed to create semiannual_cash_bank_balance table: %v", err)
+		log.Fatalf("Failed to initialize journal: %v", err)
 	}
 
-	// Create annual_cash_bank_balance table
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS annual_cash_bank_balance (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			user_id TEXT NOT NULL,
-			year TEXT NOT NULL,
-			income_bank_amount REAL DEFAULT 0,
-			income_cash_amount REAL DEFAULT 0,
-			expense_bank_amount REAL DEFAULT 0,
-			expense_cash_amount REAL DEFAULT 0,
-			bill_bank_amount REAL DEFAULT 0,
-			bill_cash_amount REAL DEFAULT 0,
-			bank_amount REAL DEFAULT 0,
-			previous_bank_amount REAL DEFAULT 0,
-			cash_amount REAL DEFAULT 0,
-			previous_cash_amount REAL DEFAULT 0,
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			balance_cash_amount REAL DEFAULT 0,
-			balance_bank_amount REAL DEFAULT 0,
-			total_previous_balance REAL DEFAULT 0,
-			total_balance REAL DEFAULT 0,
-			UNIQUE(user_id, year)
-		)
-	`)
-	if err != nil {
-		log.Fatalf("Failed to create annual_cash_bank_balance table: %v", err)
+	// Transfers recorded before the transfers table become editable
+	if err := migrateLegacyTransfers(); err != nil {
+		log.Fatalf("Failed to migrate transfers: %v", err)
 	}
 }
 
 func main() {
+	// Every request must carry an access token issued by signin, google_auth or apple-auth
+	var err error
+	sessions, err = auth.NewManagerFromEnv(db)
+	if err != nil {
+		log.Fatalf("Failed to initialize sessions: %v", err)
+	}
+
 	// Set up CORS middleware and routes
-	http.HandleFunc("/cash-bank/distribution", corsMiddleware(handleFetchDistribution))
-	http.HandleFunc("/cash-bank/cash/update", corsMiddleware(handleUpdateCash))
-	http.HandleFunc("/cash-bank/bank/update", corsMiddleware(handleUpdateBank))
-	http.HandleFunc("/transfer/cash-to-bank", corsMiddleware(handleCashToBankTransfer))
-	http.HandleFunc("/transfer/bank-to-cash", corsMiddleware(handleBankToCashTransfer))
+	http.HandleFunc("/cash-bank/distribution", corsMiddleware(sessions.Require(handleFetchDistribution)))
+	http.HandleFunc("/cash-bank/cash/update", corsMiddleware(sessions.Require(handleUpdateCash)))
+	http.HandleFunc("/cash-bank/bank/update", corsMiddleware(sessions.Require(handleUpdateBank)))
+	http.HandleFunc("/transfer/cash-to-bank", corsMiddleware(sessions.Require(handleCashToBankTransfer)))
+	http.HandleFunc("/transfer/bank-to-cash", corsMiddleware(sessions.Require(handleBankToCashTransfer)))
+	http.HandleFunc("/transfer", corsMiddleware(sessions.Require(handleAddTransfer)))
+	http.HandleFunc("/transfers", corsMiddleware(sessions.Require(handleFetchTransfers)))
+	http.HandleFunc("/transfers/add", corsMiddleware(sessions.Require(handleAddTransfer)))
+	http.HandleFunc("/transfers/update", corsMiddleware(sessions.Require(handleUpdateTransfer)))
+	http.HandleFunc("/transfers/delete", corsMiddleware(sessions.Require(handleDeleteTransfer)))
+	http.HandleFunc("/accounts", corsMiddleware(sessions.Require(handleListAccounts)))
+	http.HandleFunc("/accounts/add", corsMiddleware(sessions.Require(handleAddAccount)))
+	http.HandleFunc("/accounts/update", corsMiddleware(sessions.Require(handleUpdateAccount)))
+	http.HandleFunc("/accounts/delete", corsMiddleware(sessions.Require(handleDeleteAccount)))
+	http.HandleFunc("/accounts/statement", corsMiddleware(sessions.Require(handleAccountStatement)))
 
 	port := 8090
 	log.Printf("Cash Bank Management service started on :%d", port)
@@ -345,6 +240,12 @@ func handleFetchDistribution(w http.ResponseWriter, r *http.Request) {
 		sendErrorResponse(w, "Error fetching cash bank distribution", http.StatusInternalServerError)
 		return
 	}
+	distribution.Accounts, err = fetchAccountShares(userID)
+	if err != nil {
+		log.Printf("Error fetching account distribution: %v", err)
+		sendErrorResponse(w, "Error fetching cash bank distribution", http.StatusInternalServerError)
+		return
+	}
 
 	// Return cash bank distribution data as JSON
 	sendSuccessResponse(w, "Cash bank distribution fetched successfully", distribution)
@@ -382,6 +283,7 @@ func handleUpdateCash(w http.ResponseWriter, r *http.Request) {
 		sendErrorResponse(w, "Error fetching current distribution", http.StatusInternalServerError)
 		return
 	}
+	current := distribution
 
 	// Update cash amount
 	distribution.CashAmount = updateRequest.Amount
@@ -389,28 +291,21 @@ func handleUpdateCash(w http.ResponseWriter, r *http.Request) {
 
 	// Recalculate percentages
 	if distribution.MonthlyTotal > 0 {
-		distribution.CashPercent = (distribution.CashAmount / distribution.MonthlyTotal) * 100
-		distribution.BankPercent = (distribution.BankAmount / distribution.MonthlyTotal) * 100
+		distribution.CashPercent = (distribution.CashAmount.Float64() / distribution.MonthlyTotal.Float64()) * 100
+		distribution.BankPercent = (distribution.BankAmount.Float64() / distribution.MonthlyTotal.Float64()) * 100
 	} else {
 		distribution.CashPercent = 0
 		distribution.BankPercent = 0
 	}
 
-	// Save the updated distribution
-	err = updateCashBankDistribution(distribution)
+	// Save the updated distribution and its history entry together
+	err = saveDistribution(current, distribution, "cash_update", updateRequest.Amount, updateRequest.Date, journal.Actor(r))
 	if err != nil {
 		log.Printf("Error updating cash amount: %v", err)
 		sendErrorResponse(w, "Error updating cash amount", http.StatusInternalServerError)
 		return
 	}
 
-	// Add transaction to history
-	err = addTransaction(updateRequest.UserID, "cash_update", updateRequest.Amount, updateRequest.Date)
-	if err != nil {
-		log.Printf("Error adding transaction to history: %v", err)
-		// Continue despite the error
-	}
-
 	// Return success response
 	sendSuccessResponse(w, "Cash amount updated successfully", distribution)
 }
@@ -447,6 +342,7 @@ func handleUpdateBank(w http.ResponseWriter, r *http.Request) {
 		sendErrorResponse(w, "Error fetching current distribution", http.StatusInternalServerError)
 		return
 	}
+	current := distribution
 
 	// Update bank amount
 	distribution.BankAmount = updateRequest.Amount
@@ -454,166 +350,31 @@ func handleUpdateBank(w http.ResponseWriter, r *http.Request) {
 
 	// Recalculate percentages
 	if distribution.MonthlyTotal > 0 {
-		distribution.CashPercent = (distribution.CashAmount / distribution.MonthlyTotal) * 100
-		distribution.BankPercent = (distribution.BankAmount / distribution.MonthlyTotal) * 100
+		distribution.CashPercent = (distribution.CashAmount.Float64() / distribution.MonthlyTotal.Float64()) * 100
+		distribution.BankPercent = (distribution.BankAmount.Float64() / distribution.MonthlyTotal.Float64()) * 100
 	} else {
 		distribution.CashPercent = 0
 		distribution.BankPercent = 0
 	}
 
-	// Save the updated distribution
-	err = updateCashBankDistribution(distribution)
+	// Save the updated distribution and its history entry together
+	err = saveDistribution(current, distribution, "bank_update", updateRequest.Amount, updateRequest.Date, journal.Actor(r))
 	if err != nil {
 		log.Printf("Error updating bank amount: %v", err)
 		sendErrorResponse(w, "Error updating bank amount", http.StatusInternalServerError)
 		return
 	}
 
-	// Add transaction to history
-	err = addTransaction(updateRequest.UserID, "bank_update", updateRequest.Amount, updateRequest.Date)
-	if err != nil {
-		log.Printf("Error adding transaction to history: %v", err)
-		// Continue despite the error
-	}
-
 	// Return success response
 	sendSuccessResponse(w, "Bank amount updated successfully", distribution)
 }
 
 func handleCashToBankTransfer(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "POST" {
-		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	// Parse the request body
-	var transferRequest TransferRequest
-	err := json.NewDecoder(r.Body).Decode(&transferRequest)
-	if err != nil {
-		sendErrorResponse(w, "Invalid request body", http.StatusBadRequest)
-		return
-	}
-
-	// Validate the request
-	if transferRequest.UserID == "" {
-		sendErrorResponse(w, "User ID is required", http.StatusBadRequest)
-		return
-	}
-
-	if transferRequest.Amount <= 0 {
-		sendErrorResponse(w, "Amount must be greater than 0", http.StatusBadRequest)
-		return
-	}
-
-	// Get current distribution
-	distribution, err := fetchCashBankDistribution(transferRequest.UserID)
-	if err != nil {
-		log.Printf("Error fetching current distribution: %v", err)
-		sendErrorResponse(w, "Error fetching current distribution", http.StatusInternalServerError)
-		return
-	}
-
-	// Check if there's enough cash to transfer
-	if transferRequest.Amount > distribution.CashAmount {
-		sendErrorResponse(w, "Not enough cash to transfer", http.StatusBadRequest)
-		return
-	}
-
-	// Update amounts
-	distribution.CashAmount -= transferRequest.Amount
-	distribution.BankAmount += transferRequest.Amount
-
-	// Recalculate percentages
-	if distribution.MonthlyTotal > 0 {
-		distribution.CashPercent = (distribution.CashAmount / distribution.MonthlyTotal) * 100
-		distribution.BankPercent = (distribution.BankAmount / distribution.MonthlyTotal) * 100
-	}
-
-	// Save the updated distribution
-	err = updateCashBankDistribution(distribution)
-	if err != nil {
-		log.Printf("Error updating distribution after transfer: %v", err)
-		sendErrorResponse(w, "Error processing transfer", http.StatusInternalServerError)
-		return
-	}
-
-	// Add transaction to history
-	err = addTransaction(transferRequest.UserID, "cash_to_bank", transferRequest.Amount, transferRequest.Date)
-	if err != nil {
-		log.Printf("Error adding transaction to history: %v", err)
-		// Continue despite the error
-	}
-
-	// Return success response
-	sendSuccessResponse(w, "Cash to bank transfer successful", distribution)
+	handleSideTransfer(w, r, ledger.Cash, ledger.Bank, "Not enough cash to transfer", "Cash to bank transfer successful")
 }
 
 func handleBankToCashTransfer(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "POST" {
-		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	// Parse the request body
-	var transferRequest TransferRequest
-	err := json.NewDecoder(r.Body).Decode(&transferRequest)
-	if err != nil {
-		sendErrorResponse(w, "Invalid request body", http.StatusBadRequest)
-		return
-	}
-
-	// Validate the request
-	if transferRequest.UserID == "" {
-		sendErrorResponse(w, "User ID is required", http.StatusBadRequest)
-		return
-	}
-
-	if transferRequest.Amount <= 0 {
-		sendErrorResponse(w, "Amount must be greater than 0", http.StatusBadRequest)
-		return
-	}
-
-	// Get current distribution
-	distribution, err := fetchCashBankDistribution(transferRequest.UserID)
-	if err != nil {
-		log.Printf("Error fetching current distribution: %v", err)
-		sendErrorResponse(w, "Error fetching current distribution", http.StatusInternalServerError)
-		return
-	}
-
-	// Check if there's enough bank balance to transfer
-	if transferRequest.Amount > distribution.BankAmount {
-		sendErrorResponse(w, "Not enough bank balance to transfer", http.StatusBadRequest)
-		return
-	}
-
-	// Update amounts
-	distribution.BankAmount -= transferRequest.Amount
-	distribution.CashAmount += transferRequest.Amount
-
-	// Recalculate percentages
-	if distribution.MonthlyTotal > 0 {
-		distribution.CashPercent = (distribution.CashAmount / distribution.MonthlyTotal) * 100
-		distribution.BankPercent = (distribution.BankAmount / distribution.MonthlyTotal) * 100
-	}
-
-	// Save the updated distribution
-	err = updateCashBankDistribution(distribution)
-	if err != nil {
-		log.Printf("Error updating distribution after transfer: %v", err)
-		sendErrorResponse(w, "Error processing transfer", http.StatusInternalServerError)
-		return
-	}
-
-	// Add transaction to history
-	err = addTransaction(transferRequest.UserID, "bank_to_cash", transferRequest.Amount, transferRequest.Date)
-	if err != nil {
-		log.Printf("Error adding transaction to history: %v", err)
-		// Continue despite the error
-	}
-
-	// Return success response
-	sendSuccessResponse(w, "Bank to cash transfer successful", distribution)
+	handleSideTransfer(w, r, ledger.Bank, ledger.Cash, "Not enough bank balance to transfer", "Bank to cash transfer successful")
 }
 
 func fetchCashBankDistribution(userID string) (CashBankDistribution, error) {
@@ -671,8 +432,8 @@ func fetchCashBankDistribution(userID string) (CashBankDistribution, error) {
 
 	// Calculate percentages
 	if distribution.MonthlyTotal > 0 {
-		distribution.CashPercent = (distribution.CashAmount / distribution.MonthlyTotal) * 100
-		distribution.BankPercent = (distribution.BankAmount / distribution.MonthlyTotal) * 100
+		distribution.CashPercent = (distribution.CashAmount.Float64() / distribution.MonthlyTotal.Float64()) * 100
+		distribution.BankPercent = (distribution.BankAmount.Float64() / distribution.MonthlyTotal.Float64()) * 100
 	} else {
 		distribution.CashPercent = 0
 		distribution.BankPercent = 0
@@ -681,192 +442,152 @@ func fetchCashBankDistribution(userID string) (CashBankDistribution, error) {
 	return distribution, nil
 }
 
-func updateCashBankDistribution(distribution CashBankDistribution) error {
-	// Get current date and time periods
-	now := time.Now()
-	currentDate := now.Format("2006-01-02")
-	currentMonth := now.Format("2006-01")
-	currentWeek := getWeekPeriod(now)
-	currentQuarter := getQuarterPeriod(now)
-	currentSemiannual := getSemiannualPeriod(now)
-	currentYear := now.Format("2006")
-
-	// Update all period tables
-	err := updateAllPeriodTables(distribution, currentDate, currentMonth, currentWeek, currentQuarter, currentSemiannual, currentYear)
+// fetchAccountShares returns every account of userID with its balance
+// today and its percentage of the sum of all of them
+func fetchAccountShares(userID string) ([]AccountShare, error) {
+	list, err := accounts.List(userID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// Also update the legacy cash_bank table for backward compatibility
-	var legacyCount int
-	err2 := db.QueryRow(`
-		SELECT COUNT(*) 
-		FROM cash_bank 
-		WHERE user_id = ?
-	`, distribution.UserID).Scan(&legacyCount)
-
-	if err2 == nil {
-		if legacyCount > 0 {
-			// Update existing cash_bank entry
-			db.Exec(`
-				UPDATE cash_bank
-				SET month = ?,
-					cash_amount = ?,
-					cash_percent = ?,
-					bank_amount = ?,
-					bank_percent = ?,
-					monthly_total = ?,
-					updated_at = CURRENT_TIMESTAMP
-				WHERE user_id = ?
-			`,
-				distribution.Month,
-				distribution.CashAmount,
-				distribution.CashPercent,
-				distribution.BankAmount,
-				distribution.BankPercent,
-				distribution.MonthlyTotal,
-				distribution.UserID,
-			)
-		} else {
-			// Insert new cash_bank entry
-			db.Exec(`
-				INSERT INTO cash_bank (
-					user_id, month, cash_amount, cash_percent, bank_amount, bank_percent, monthly_total
-				) VALUES (?, ?, ?, ?, ?, ?, ?)
-			`,
-				distribution.UserID,
-				distribution.Month,
-				distribution.CashAmount,
-				distribution.CashPercent,
-				distribution.BankAmount,
-				distribution.BankPercent,
-				distribution.MonthlyTotal,
-			)
+	// Card debt is not money the user holds
+	var total money.Money
+	for _, a := range list {
+		if a.Type != account.CreditCard {
+			total += a.Balance
 		}
 	}
-
-	return err
-}
-
-// Helper functions for period calculations
-func getWeekPeriod(t time.Time) string {
-	year, week := t.ISOWeek()
-	return fmt.Sprintf("%d-%02d", year, week)
-}
-
-func getQuarterPeriod(t time.Time) string {
-	quarter := (int(t.Month())-1)/3 + 1
-	return fmt.Sprintf("%d-%d", t.Year(), quarter)
-}
-
-func getSemiannualPeriod(t time.Time) string {
-	semiannual := (int(t.Month())-1)/6 + 1
-	return fmt.Sprintf("%d-%d", t.Year(), semiannual)
+	shares := make([]AccountShare, 0, len(list))
+	for _, a := range list {
+		share := AccountShare{Account: a}
+		if total > 0 && a.Type != account.CreditCard {
+			share.Percent = (a.Balance.Float64() / total.Float64()) * 100
+		}
+		shares = append(shares, share)
+	}
+	return shares, nil
 }
 
-// Update all period tables with the new cash/bank distribution
-func updateAllPeriodTables(distribution CashBankDistribution, currentDate, currentMonth, currentWeek, currentQuarter, currentSemiannual, currentYear string) error {
-	// Update daily_cash_bank_balance
-	err := updatePeriodTable("daily_cash_bank_balance", "date", currentDate, distribution)
+// saveDistribution stores a new distribution and records the operation
+// in cash_bank_transactions and, on behalf of actor, in the journal in
+// one transaction.
+func saveDistribution(current, distribution CashBankDistribution, transactionType string, amount money.Money, date, actor string) error {
+	tx, err := db.Begin()
 	if err != nil {
-		log.Printf("Error updating daily_cash_bank_balance: %v", err)
 		return err
 	}
+	defer tx.Rollback()
 
-	// Update weekly_cash_bank_balance
-	err = updatePeriodTable("weekly_cash_bank_balance", "year_week", currentWeek, distribution)
+	adjustments, err := updateCashBankDistribution(tx, current, distribution, date)
 	if err != nil {
-		log.Printf("Error updating weekly_cash_bank_balance: %v", err)
 		return err
 	}
-
-	// Update monthly_cash_bank_balance
-	err = updatePeriodTable("monthly_cash_bank_balance", "year_month", currentMonth, distribution)
-	if err != nil {
-		log.Printf("Error updating monthly_cash_bank_balance: %v", err)
+	if err := recordTransaction(tx, distribution.UserID, transactionType, amount, date); err != nil {
 		return err
 	}
-
-	// Update quarterly_cash_bank_balance
-	err = updatePeriodTable("quarterly_cash_bank_balance", "year_quarter", currentQuarter, distribution)
+	err = audit.RecordTx(tx, distribution.UserID, journal.Change{Actor: actor, Entity: "cash_bank",
+		Before: current, After: distribution, Posted: adjustments})
 	if err != nil {
-		log.Printf("Error updating quarterly_cash_bank_balance: %v", err)
 		return err
 	}
+	return tx.Commit()
+}
 
-	// Update semiannual_cash_bank_balance
-	err = updatePeriodTable("semiannual_cash_bank_balance", "year_half", currentSemiannual, distribution)
-	if err != nil {
-		log.Printf("Error updating semiannual_cash_bank_balance: %v", err)
-		return err
+// updateCashBankDistribution posts the difference between current and
+// distribution as adjustments dated date (today if empty), so the period
+// balances of that day onwards end at the new amounts. Each side's change
+// goes to the user's first account of that side. It returns the
+// adjustments.
+func updateCashBankDistribution(tx *sql.Tx, current, distribution CashBankDistribution, date string) ([]ledger.Entry, error) {
+	if date == "" {
+		date = time.Now().Format("2006-01-02")
 	}
 
-	// Update annual_cash_bank_balance
-	err = updatePeriodTable("annual_cash_bank_balance", "year", currentYear, distribution)
-	if err != nil {
-		log.Printf("Error updating annual_cash_bank_balance: %v", err)
-		return err
+	deltas := map[string]money.Money{
+		ledger.Cash: distribution.CashAmount - current.CashAmount,
+		ledger.Bank: distribution.BankAmount - current.BankAmount,
+	}
+	var adjustments []ledger.Entry
+	for _, method := range []string{ledger.Cash, ledger.Bank} {
+		if deltas[method] == 0 {
+			continue
+		}
+		a, err := accounts.ResolveTx(tx, distribution.UserID, 0, method)
+		if err != nil {
+			return nil, err
+		}
+		adjustments = append(adjustments, a.Entry(ledger.Adjustment, deltas[method], date))
+	}
+	if err := balances.PostTx(tx, adjustments...); err != nil {
+		log.Printf("Error posting cash/bank adjustment: %v", err)
+		return nil, err
 	}
 
-	return nil
-}
-
-// Generic function to update any period table
-func updatePeriodTable(tableName, periodColumn, periodValue string, distribution CashBankDistribution) error {
-	// Check if entry exists
-	var count int
-	query := fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE user_id = ? AND %s = ?`, tableName, periodColumn)
-	err := db.QueryRow(query, distribution.UserID, periodValue).Scan(&count)
+	// Also update the legacy cash_bank table for backward compatibility
+	var legacyCount int
+	err := tx.QueryRow(`
+		SELECT COUNT(*) 
+		FROM cash_bank 
+		WHERE user_id = ?
+	`, distribution.UserID).Scan(&legacyCount)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if count > 0 {
-		// Update existing entry
-		updateQuery := fmt.Sprintf(`
-			UPDATE %s
-			SET cash_amount = ?,
+	if legacyCount > 0 {
+		// Update existing cash_bank entry
+		_, err = tx.Exec(`
+			UPDATE cash_bank
+			SET month = ?,
+				cash_amount = ?,
+				cash_percent = ?,
 				bank_amount = ?,
-				balance_cash_amount = ?,
-				balance_bank_amount = ?,
-				total_balance = ?,
+				bank_percent = ?,
+				monthly_total = ?,
 				updated_at = CURRENT_TIMESTAMP
-			WHERE user_id = ? AND %s = ?
-		`, tableName, periodColumn)
-
-		_, err = db.Exec(updateQuery,
-			distribution.CashAmount,
-			distribution.BankAmount,
+			WHERE user_id = ?
+		`,
+			distribution.Month,
 			distribution.CashAmount,
+			distribution.CashPercent,
 			distribution.BankAmount,
+			distribution.BankPercent,
 			distribution.MonthlyTotal,
 			distribution.UserID,
-			periodValue,
 		)
 	} else {
-		// Insert new entry
-		insertQuery := fmt.Sprintf(`
-			INSERT INTO %s (
-				user_id, %s, cash_amount, bank_amount, balance_cash_amount, balance_bank_amount, total_balance
+		// Insert new cash_bank entry
+		_, err = tx.Exec(`
+			INSERT INTO cash_bank (
+				user_id, month, cash_amount, cash_percent, bank_amount, bank_percent, monthly_total
 			) VALUES (?, ?, ?, ?, ?, ?, ?)
-		`, tableName, periodColumn)
-
-		_, err = db.Exec(insertQuery,
+		`,
 			distribution.UserID,
-			periodValue,
-			distribution.CashAmount,
-			distribution.BankAmount,
+			distribution.Month,
 			distribution.CashAmount,
+			distribution.CashPercent,
 			distribution.BankAmount,
+			distribution.BankPercent,
 			distribution.MonthlyTotal,
 		)
 	}
 
-	return err
+	return adjustments, err
 }
 
-func addTransaction(userID, transactionType string, amount float64, date string) error {
-	_, err := db.Exec(`
+// execer is what *sql.DB and *sql.Tx have in common for writes
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func addTransaction(userID, transactionType string, amount money.Money, date string) error {
+	return recordTransaction(db, userID, transactionType, amount, date)
+}
+
+// recordTransaction adds an entry to cash_bank_transactions through db or
+// an open transaction
+func recordTransaction(ex execer, userID, transactionType string, amount money.Money, date string) error {
+	_, err := ex.Exec(`
 		INSERT INTO cash_bank_transactions (
 			user_id, transaction_type, amount, date
 		) VALUES (?, ?, ?, ?)
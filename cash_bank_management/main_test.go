@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -11,6 +13,12 @@ import (
 	"testing"
 	"time"
 
+	"backend/common/account"
+	"backend/common/dbtest"
+	"backend/common/journal"
+	"backend/common/ledger"
+	"backend/common/money"
+
 	_ "github.com/mattn/go-sqlite3"
 )
 
@@ -515,3 +523,241 @@ func TestCORSHeaders(t *testing.T) {
 		t.Errorf("Expected status 200 for OPTIONS request, got: %d", rr.Code)
 	}
 }
+
+func TestTransferIsAtomic(t *testing.T) {
+	sharedDB, sharedBalances, sharedAccounts, sharedAudit := db, balances, accounts, audit
+	t.Cleanup(func() { db, balances, accounts, audit = sharedDB, sharedBalances, sharedAccounts, sharedAudit })
+
+	// A fresh database where the user holds 500 in cash this month
+	setup := func(t *testing.T) *sql.DB {
+		db = dbtest.Open(t)
+		createTablesIfNotExist()
+		err := balances.Post(ledger.Entry{UserID: "u1", Kind: ledger.Income, Method: ledger.Cash, Amount: 500, Date: time.Now().Format("2006-01-02")})
+		if err != nil {
+			t.Fatalf("Failed to post opening balance: %v", err)
+		}
+		return db
+	}
+	transfer := func(t *testing.T) error {
+		jsonBody, _ := json.Marshal(TransferRequest{UserID: "u1", Amount: 100, Date: time.Now().Format(time.RFC3339)})
+		rr := httptest.NewRecorder()
+		handleCashToBankTransfer(rr, httptest.NewRequest("POST", "/transfer/cash-to-bank", bytes.NewBuffer(jsonBody)))
+		if rr.Code != http.StatusOK {
+			return fmt.Errorf("status %d: %s", rr.Code, rr.Body.String())
+		}
+		return nil
+	}
+
+	failed := dbtest.Atomic(t, []string{"transfers", "daily_cash_bank_balance", "daily_account_balance", "annual_balance", "journal"}, setup, transfer)
+	for _, point := range []string{"INSERT transfers", "UPDATE daily_account_balance", "UPDATE annual_balance", "INSERT journal"} {
+		found := false
+		for _, f := range failed {
+			found = found || f == point
+		}
+		if !found {
+			t.Errorf("Expected a fault on %s to make the transfer fail", point)
+		}
+	}
+}
+
+// newAccountsDB points the service at a fresh database, restoring the
+// shared one when t ends
+func newAccountsDB(t *testing.T) {
+	sharedDB, sharedBalances, sharedAccounts, sharedAudit := db, balances, accounts, audit
+	t.Cleanup(func() { db, balances, accounts, audit = sharedDB, sharedBalances, sharedAccounts, sharedAudit })
+	db = dbtest.Open(t)
+	createTablesIfNotExist()
+}
+
+// call sends body to handler and decodes the data of the response into data
+func call(t *testing.T, handler http.HandlerFunc, method, target string, body, data interface{}) int {
+	t.Helper()
+	var payload bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&payload).Encode(body)
+	}
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(method, target, &payload))
+	if data != nil && rr.Code == http.StatusOK {
+		response := ApiResponse{Data: data}
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to read response of %s: %v", target, err)
+		}
+	}
+	return rr.Code
+}
+
+func accountBalances(t *testing.T) map[int64]money.Money {
+	t.Helper()
+	var distribution CashBankDistribution
+	if code := call(t, handleFetchDistribution, "GET", "/cash-bank/distribution?user_id=u1", nil, &distribution); code != http.StatusOK {
+		t.Fatalf("Distribution returned %d", code)
+	}
+	got := map[int64]money.Money{}
+	for _, share := range distribution.Accounts {
+		got[share.ID] = share.Balance
+	}
+	return got
+}
+
+func TestTransfers(t *testing.T) {
+	newAccountsDB(t)
+
+	today := time.Now().Format("2006-01-02")
+	savings, err := accounts.Create(account.Account{UserID: "u1", Name: "Ahorro", Type: account.Bank, OpeningBalance: 30000})
+	if err != nil {
+		t.Fatalf("Failed to create account: %v", err)
+	}
+	card, err := accounts.Create(account.Account{UserID: "u1", Name: "Visa", Type: account.CreditCard})
+	if err != nil {
+		t.Fatalf("Failed to create account: %v", err)
+	}
+	list, _ := accounts.List("u1")
+	cash := list[0]
+
+	// Paying the card off from savings, with a fee
+	var payment Transfer
+	code := call(t, handleAddTransfer, "POST", "/transfers/add",
+		Transfer{UserID: "u1", FromAccountID: savings.ID, ToAccountID: card.ID, Amount: 12000, Fee: 100, Memo: "Visa", Date: today}, &payment)
+	if code != http.StatusOK || payment.ID == 0 {
+		t.Fatalf("Adding a transfer returned %d", code)
+	}
+	// A cash advance takes the card below zero
+	var advance Transfer
+	if code := call(t, handleAddTransfer, "POST", "/transfers/add",
+		Transfer{UserID: "u1", FromAccountID: card.ID, ToAccountID: cash.ID, Amount: 20000, Date: today}, &advance); code != http.StatusOK {
+		t.Fatalf("Cash advance returned %d", code)
+	}
+	for _, bad := range []Transfer{
+		{UserID: "u1", FromAccountID: savings.ID, ToAccountID: card.ID, Amount: 50000},
+		{UserID: "u1", FromAccountID: savings.ID, ToAccountID: savings.ID, Amount: 100},
+		{UserID: "u1", FromAccountID: savings.ID, ToAccountID: card.ID, Amount: 100, Fee: -1},
+	} {
+		if code := call(t, handleAddTransfer, "POST", "/transfers/add", bad, nil); code != http.StatusBadRequest {
+			t.Errorf("Adding %+v returned %d, want 400", bad, code)
+		}
+	}
+	if code := call(t, handleAddTransfer, "POST", "/transfers/add", Transfer{UserID: "u1", FromAccountID: savings.ID, ToAccountID: 999, Amount: 100}, nil); code != http.StatusNotFound {
+		t.Errorf("Transfer to a missing account returned %d, want 404", code)
+	}
+
+	want := map[int64]money.Money{cash.ID: 20000, savings.ID: 17900, card.ID: -8000}
+	for id, balance := range accountBalances(t) {
+		if w, ok := want[id]; ok && balance != w {
+			t.Errorf("Account %d balance = %v, want %v", id, balance, w)
+		}
+	}
+
+	// Moving the payment to another day and dropping the fee
+	fee := money.Money(0)
+	var updated Transfer
+	code = call(t, handleUpdateTransfer, "POST", "/transfers/update",
+		UpdateTransferRequest{UserID: "u1", TransferID: payment.ID, Amount: 10000, Fee: &fee}, &updated)
+	if code != http.StatusOK || updated.Amount != 10000 || updated.Fee != 0 || updated.Memo != "Visa" {
+		t.Fatalf("Update returned %d, %+v", code, updated)
+	}
+	if got := accountBalances(t); got[savings.ID] != 20000 || got[card.ID] != -10000 {
+		t.Errorf("Balances after the update = %v", got)
+	}
+	if code := call(t, handleUpdateTransfer, "POST", "/transfers/update",
+		UpdateTransferRequest{UserID: "u1", TransferID: payment.ID, Amount: 40000}, nil); code != http.StatusBadRequest {
+		t.Errorf("Overdrawing savings on update returned %d, want 400", code)
+	}
+
+	var listed []Transfer
+	call(t, handleFetchTransfers, "GET", fmt.Sprintf("/transfers?user_id=u1&account_id=%d", savings.ID), nil, &listed)
+	if len(listed) != 1 || listed[0].ID != payment.ID {
+		t.Errorf("Transfers of savings = %+v", listed)
+	}
+
+	// Accounts with transfers stay until the transfers are gone
+	if err := accounts.Delete("u1", card.ID); !errors.Is(err, account.ErrInUse) {
+		t.Errorf("Deleting an account with transfers: %v, want ErrInUse", err)
+	}
+	for _, id := range []int64{payment.ID, advance.ID} {
+		if code := call(t, handleDeleteTransfer, "POST", "/transfers/delete", DeleteTransferRequest{UserID: "u1", TransferID: id}, nil); code != http.StatusOK {
+			t.Fatalf("Delete returned %d", code)
+		}
+	}
+	if code := call(t, handleDeleteTransfer, "POST", "/transfers/delete", DeleteTransferRequest{UserID: "u1", TransferID: payment.ID}, nil); code != http.StatusNotFound {
+		t.Errorf("Deleting twice returned %d, want 404", code)
+	}
+	if got := accountBalances(t); got[savings.ID] != 30000 || got[card.ID] != 0 || got[cash.ID] != 0 {
+		t.Errorf("Balances after deleting every transfer = %v", got)
+	}
+	if err := accounts.Delete("u1", card.ID); err != nil {
+		t.Errorf("Deleting the card after its transfers: %v", err)
+	}
+}
+
+func TestCreditCardAccounts(t *testing.T) {
+	newAccountsDB(t)
+
+	limit, closing, due := money.Money(10000), 20, 5
+	var card account.Account
+	code := call(t, handleAddAccount, "POST", "/accounts/add", AccountRequest{UserID: "u1", Name: "Visa", Type: account.CreditCard,
+		CreditLimit: &limit, ClosingDay: &closing, DueDay: &due, OpeningDate: "2025-01-01"}, &card)
+	if code != http.StatusOK || card.CreditLimit != limit || card.ClosingDay != 20 || card.DueDay != 5 {
+		t.Fatalf("Adding a card returned %d, %+v", code, card)
+	}
+	due = 40
+	if code := call(t, handleUpdateAccount, "POST", "/accounts/update", AccountRequest{UserID: "u1", AccountID: card.ID, DueDay: &due}, nil); code != http.StatusBadRequest {
+		t.Errorf("Due day 40 returned %d, want 400", code)
+	}
+
+	// A cash advance cannot go past the limit
+	list, _ := accounts.List("u1")
+	cash := list[0]
+	if code := call(t, handleAddTransfer, "POST", "/transfers/add",
+		Transfer{UserID: "u1", FromAccountID: card.ID, ToAccountID: cash.ID, Amount: 15000, Date: "2025-01-10"}, nil); code != http.StatusBadRequest {
+		t.Errorf("Advance over the limit returned %d, want 400", code)
+	}
+	if code := call(t, handleAddTransfer, "POST", "/transfers/add",
+		Transfer{UserID: "u1", FromAccountID: card.ID, ToAccountID: cash.ID, Amount: 6000, Date: "2025-01-10"}, nil); code != http.StatusOK {
+		t.Fatalf("Advance returned %d", code)
+	}
+
+	var statement account.Statement
+	code = call(t, handleAccountStatement, "GET", fmt.Sprintf("/accounts/statement?user_id=u1&account_id=%d&month=2025-01", card.ID), nil, &statement)
+	if code != http.StatusOK || statement.Balance != 6000 || statement.Start != "2024-12-21" || statement.DueDate != "2025-02-05" {
+		t.Errorf("Statement returned %d, %+v", code, statement)
+	}
+	if code := call(t, handleAccountStatement, "GET", fmt.Sprintf("/accounts/statement?user_id=u1&account_id=%d", cash.ID), nil, nil); code != http.StatusBadRequest {
+		t.Errorf("Statement of a cash account returned %d, want 400", code)
+	}
+}
+
+func TestLegacyTransfersBecomeTransfers(t *testing.T) {
+	newAccountsDB(t)
+
+	// A cash to bank transfer made before the transfers table existed
+	entries := []ledger.Entry{
+		{UserID: "u1", Kind: ledger.Income, Method: ledger.Cash, Amount: 5000, Date: "2024-03-01"},
+		{UserID: "u1", Kind: ledger.Adjustment, Method: ledger.Cash, Amount: -2000, Date: "2024-03-02"},
+		{UserID: "u1", Kind: ledger.Adjustment, Method: ledger.Bank, Amount: 2000, Date: "2024-03-02"},
+	}
+	if err := balances.Post(entries...); err != nil {
+		t.Fatal(err)
+	}
+	if err := recordTransaction(db, "u1", "cash_to_bank", 2000, "2024-03-02T10:00:00Z"); err != nil {
+		t.Fatal(err)
+	}
+	createTablesIfNotExist()
+
+	transfers, err := fetchTransfers(TransferFilter{UserID: "u1"})
+	if err != nil || len(transfers) != 1 {
+		t.Fatalf("Transfers = %+v, %v", transfers, err)
+	}
+	list, _ := accounts.List("u1")
+	if tr := transfers[0]; tr.FromAccountID != list[0].ID || tr.ToAccountID != list[1].ID || tr.Date != "2024-03-02" {
+		t.Errorf("Migrated transfer = %+v", tr)
+	}
+
+	// It can be deleted like any other
+	if err := deleteTransfer("u1", transfers[0].ID, journal.System); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if got := accountBalances(t); got[list[0].ID] != 5000 || got[list[1].ID] != 0 {
+		t.Errorf("Balances after deleting the migrated transfer = %v", got)
+	}
+}
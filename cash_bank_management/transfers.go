@@ -0,0 +1,603 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/common/account"
+	"backend/common/journal"
+	"backend/common/ledger"
+	"backend/common/money"
+)
+
+// Transfer moves money from one account of the user to another. The
+// amount leaves the source and enters the destination as two adjustments;
+// the fee, if any, is an expense of the source account. Amounts are in the
+// user's base currency, like the balances.
+type Transfer struct {
+	ID            int64       `json:"id"`
+	UserID        string      `json:"user_id"`
+	FromAccountID int64       `json:"from_account_id"`
+	ToAccountID   int64       `json:"to_account_id"`
+	Amount        money.Money `json:"amount"`
+	Fee           money.Money `json:"fee"`
+	Memo          string      `json:"memo"`
+	Date          string      `json:"date"`
+	CreatedAt     string      `json:"created_at,omitempty"`
+	UpdatedAt     string      `json:"updated_at,omitempty"`
+}
+
+// UpdateTransferRequest is the body of /transfers/update. Only the fields
+// sent change; fee and memo can be set back to zero or empty.
+type UpdateTransferRequest struct {
+	UserID        string       `json:"user_id"`
+	TransferID    int64        `json:"transfer_id"`
+	FromAccountID int64        `json:"from_account_id,omitempty"`
+	ToAccountID   int64        `json:"to_account_id,omitempty"`
+	Amount        money.Money  `json:"amount,omitempty"`
+	Fee           *money.Money `json:"fee,omitempty"`
+	Memo          *string      `json:"memo,omitempty"`
+	Date          string       `json:"date,omitempty"`
+}
+
+type DeleteTransferRequest struct {
+	UserID     string `json:"user_id"`
+	TransferID int64  `json:"transfer_id"`
+}
+
+// TransferFilter narrows the transfers listed by /transfers
+type TransferFilter struct {
+	UserID    string
+	AccountID int64 // either end of the transfer
+	StartDate string
+	EndDate   string
+}
+
+var (
+	errTransferNotFound = errors.New("transfer not found")
+	errInvalidTransfer  = errors.New("invalid transfer")
+	// errNotEnoughBalance is returned when a transfer would leave the
+	// source account below zero. Credit cards can go below zero.
+	errNotEnoughBalance = errors.New("not enough balance")
+)
+
+// legacyTransferTypes are the cash_bank_transactions rows that were
+// transfers before the transfers table existed
+var legacyTransferTypes = []string{"cash_to_bank", "bank_to_cash", "transfer"}
+
+func createTransfersTable() error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS transfers (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id TEXT NOT NULL,
+			from_account_id INTEGER NOT NULL,
+			to_account_id INTEGER NOT NULL,
+			amount INTEGER NOT NULL,
+			fee INTEGER NOT NULL DEFAULT 0,
+			memo TEXT NOT NULL DEFAULT '',
+			date TEXT NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_transfers_user_date ON transfers(user_id, date)`)
+	return err
+}
+
+// migrateLegacyTransfers moves the transfers recorded in
+// cash_bank_transactions to the transfers table, so they can be edited
+// and deleted. Cash to bank transfers go between the user's first cash and
+// bank accounts, which took over the history from before accounts.
+func migrateLegacyTransfers() error {
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(legacyTransferTypes)), ", ")
+	types := make([]interface{}, len(legacyTransferTypes))
+	for i, t := range legacyTransferTypes {
+		types[i] = t
+	}
+
+	rows, err := db.Query(fmt.Sprintf(`SELECT DISTINCT user_id FROM cash_bank_transactions WHERE transaction_type IN (%s)`, placeholders), types...)
+	if err != nil {
+		return fmt.Errorf("error reading legacy transfers: %v", err)
+	}
+	var users []string
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			rows.Close()
+			return fmt.Errorf("error reading legacy transfers: %v", err)
+		}
+		users = append(users, userID)
+	}
+	rows.Close()
+
+	for _, userID := range users {
+		err := inTransaction(func(tx *sql.Tx) error {
+			cash, err := accounts.ResolveTx(tx, userID, 0, ledger.Cash)
+			if err != nil {
+				return err
+			}
+			bank, err := accounts.ResolveTx(tx, userID, 0, ledger.Bank)
+			if err != nil {
+				return err
+			}
+
+			args := append([]interface{}{cash.ID, bank.ID, bank.ID, cash.ID, userID}, types...)
+			_, err = tx.Exec(fmt.Sprintf(`
+				INSERT INTO transfers (user_id, from_account_id, to_account_id, amount, date, created_at)
+				SELECT user_id,
+					CASE transaction_type WHEN 'cash_to_bank' THEN ? WHEN 'bank_to_cash' THEN ? ELSE from_account_id END,
+					CASE transaction_type WHEN 'cash_to_bank' THEN ? WHEN 'bank_to_cash' THEN ? ELSE to_account_id END,
+					amount, COALESCE(NULLIF(substr(date, 1, 10), ''), substr(created_at, 1, 10)), created_at
+				FROM cash_bank_transactions
+				WHERE user_id = ? AND transaction_type IN (%s)
+				ORDER BY id`, placeholders), args...)
+			if err != nil {
+				return fmt.Errorf("error copying legacy transfers: %v", err)
+			}
+
+			_, err = tx.Exec(fmt.Sprintf(`DELETE FROM cash_bank_transactions WHERE user_id = ? AND transaction_type IN (%s)`, placeholders),
+				append([]interface{}{userID}, types...)...)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("error migrating transfers of user %s: %v", userID, err)
+		}
+	}
+	return nil
+}
+
+func handleFetchTransfers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := TransferFilter{
+		UserID:    query.Get("user_id"),
+		StartDate: query.Get("start_date"),
+		EndDate:   query.Get("end_date"),
+	}
+	if filter.UserID == "" {
+		sendErrorResponse(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+	if accountID := query.Get("account_id"); accountID != "" {
+		id, err := strconv.ParseInt(accountID, 10, 64)
+		if err != nil {
+			sendErrorResponse(w, "Invalid account ID", http.StatusBadRequest)
+			return
+		}
+		filter.AccountID = id
+	}
+
+	transfers, err := fetchTransfers(filter)
+	if err != nil {
+		log.Printf("Error fetching transfers: %v", err)
+		sendErrorResponse(w, "Error fetching transfers", http.StatusInternalServerError)
+		return
+	}
+
+	sendSuccessResponse(w, "Transfers fetched successfully", transfers)
+}
+
+func handleAddTransfer(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var transfer Transfer
+	if err := json.NewDecoder(r.Body).Decode(&transfer); err != nil {
+		sendErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if transfer.UserID == "" {
+		sendErrorResponse(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+	if transfer.FromAccountID <= 0 || transfer.ToAccountID <= 0 {
+		sendErrorResponse(w, "Source and destination accounts are required", http.StatusBadRequest)
+		return
+	}
+
+	created, err := createTransfer(transfer, "", "", journal.Actor(r))
+	if err != nil {
+		sendTransferError(w, err, "Error adding transfer", "Not enough balance in the source account")
+		return
+	}
+
+	sendSuccessResponse(w, "Transfer added successfully", created)
+}
+
+func handleUpdateTransfer(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var updateRequest UpdateTransferRequest
+	if err := json.NewDecoder(r.Body).Decode(&updateRequest); err != nil {
+		sendErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if updateRequest.UserID == "" {
+		sendErrorResponse(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+	if updateRequest.TransferID <= 0 {
+		sendErrorResponse(w, "Valid transfer ID is required", http.StatusBadRequest)
+		return
+	}
+
+	updated, err := updateTransfer(updateRequest, journal.Actor(r))
+	if err != nil {
+		sendTransferError(w, err, "Error updating transfer", "Not enough balance in the source account")
+		return
+	}
+
+	sendSuccessResponse(w, "Transfer updated successfully", updated)
+}
+
+func handleDeleteTransfer(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var deleteRequest DeleteTransferRequest
+	if err := json.NewDecoder(r.Body).Decode(&deleteRequest); err != nil {
+		sendErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if deleteRequest.UserID == "" {
+		sendErrorResponse(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+	if deleteRequest.TransferID <= 0 {
+		sendErrorResponse(w, "Valid transfer ID is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := deleteTransfer(deleteRequest.UserID, deleteRequest.TransferID, journal.Actor(r)); err != nil {
+		sendTransferError(w, err, "Error deleting transfer", "")
+		return
+	}
+
+	sendSuccessResponse(w, "Transfer deleted successfully", map[string]interface{}{
+		"transfer_id": deleteRequest.TransferID,
+		"user_id":     deleteRequest.UserID,
+	})
+}
+
+// handleSideTransfer serves the legacy /transfer/cash-to-bank and
+// /transfer/bank-to-cash endpoints: a transfer between the user's first
+// account of each side, answered with the new distribution
+func handleSideTransfer(w http.ResponseWriter, r *http.Request, fromMethod, toMethod, notEnoughMessage, successMessage string) {
+	if r.Method != "POST" {
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Parse the request body
+	var transferRequest TransferRequest
+	if err := json.NewDecoder(r.Body).Decode(&transferRequest); err != nil {
+		sendErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Validate the request
+	if transferRequest.UserID == "" {
+		sendErrorResponse(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+
+	transfer := Transfer{UserID: transferRequest.UserID, Amount: transferRequest.Amount, Date: transferRequest.Date}
+	if _, err := createTransfer(transfer, fromMethod, toMethod, journal.Actor(r)); err != nil {
+		sendTransferError(w, err, "Error processing transfer", notEnoughMessage)
+		return
+	}
+
+	distribution, err := fetchCashBankDistribution(transferRequest.UserID)
+	if err != nil {
+		log.Printf("Error fetching distribution after transfer: %v", err)
+		sendErrorResponse(w, "Error fetching current distribution", http.StatusInternalServerError)
+		return
+	}
+	sendSuccessResponse(w, successMessage, distribution)
+}
+
+// validate checks the fields of a transfer and normalizes its date
+func (t *Transfer) validate() error {
+	if t.Amount <= 0 {
+		return fmt.Errorf("%w: amount must be greater than 0", errInvalidTransfer)
+	}
+	if t.Fee < 0 {
+		return fmt.Errorf("%w: fee must be greater than or equal to 0", errInvalidTransfer)
+	}
+	if t.FromAccountID == t.ToAccountID {
+		return fmt.Errorf("%w: source and destination accounts must be different", errInvalidTransfer)
+	}
+	t.Memo = strings.TrimSpace(t.Memo)
+	if t.Date == "" {
+		t.Date = time.Now().Format("2006-01-02")
+	}
+	// Las apps antiguas envían la fecha con hora
+	if len(t.Date) > 10 {
+		t.Date = t.Date[:10]
+	}
+	if _, err := time.Parse("2006-01-02", t.Date); err != nil {
+		return fmt.Errorf("%w: date must be YYYY-MM-DD", errInvalidTransfer)
+	}
+	return nil
+}
+
+// createTransfer stores a transfer, posts it to the balances and records
+// it in the journal on behalf of actor in one transaction. An account id
+// left at zero is the user's first account of fromMethod or toMethod
+// ("cash" or "bank").
+func createTransfer(t Transfer, fromMethod, toMethod, actor string) (Transfer, error) {
+	err := inTransaction(func(tx *sql.Tx) error {
+		from, err := accounts.ResolveTx(tx, t.UserID, t.FromAccountID, fromMethod)
+		if err != nil {
+			return err
+		}
+		to, err := accounts.ResolveTx(tx, t.UserID, t.ToAccountID, toMethod)
+		if err != nil {
+			return err
+		}
+		t.FromAccountID, t.ToAccountID = from.ID, to.ID
+		if err := t.validate(); err != nil {
+			return err
+		}
+
+		result, err := tx.Exec(`
+			INSERT INTO transfers (user_id, from_account_id, to_account_id, amount, fee, memo, date)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+		`, t.UserID, t.FromAccountID, t.ToAccountID, t.Amount, t.Fee, t.Memo, t.Date)
+		if err != nil {
+			return fmt.Errorf("error inserting transfer: %v", err)
+		}
+		if t.ID, err = result.LastInsertId(); err != nil {
+			return err
+		}
+
+		entries := transferEntries(t, from, to)
+		if err := balances.PostTx(tx, entries...); err != nil {
+			return fmt.Errorf("error posting transfer: %v", err)
+		}
+		err = audit.RecordTx(tx, t.UserID, journal.Change{Actor: actor, Entity: "transfer", EntityID: t.ID, After: t, Posted: entries})
+		if err != nil {
+			return err
+		}
+		return checkBalance(tx, from, t.Date)
+	})
+	return t, err
+}
+
+// updateTransfer replaces a transfer and what it posted to the balances
+// in one transaction, recorded in the journal on behalf of actor
+func updateTransfer(updateRequest UpdateTransferRequest, actor string) (Transfer, error) {
+	var t Transfer
+	err := inTransaction(func(tx *sql.Tx) error {
+		current, err := getTransferTx(tx, updateRequest.UserID, updateRequest.TransferID)
+		if err != nil {
+			return err
+		}
+		before, err := transferEntriesTx(tx, current)
+		if err != nil {
+			return err
+		}
+
+		t = current
+		if updateRequest.FromAccountID != 0 {
+			t.FromAccountID = updateRequest.FromAccountID
+		}
+		if updateRequest.ToAccountID != 0 {
+			t.ToAccountID = updateRequest.ToAccountID
+		}
+		if updateRequest.Amount != 0 {
+			t.Amount = updateRequest.Amount
+		}
+		if updateRequest.Fee != nil {
+			t.Fee = *updateRequest.Fee
+		}
+		if updateRequest.Memo != nil {
+			t.Memo = *updateRequest.Memo
+		}
+		if updateRequest.Date != "" {
+			t.Date = updateRequest.Date
+		}
+		if err := t.validate(); err != nil {
+			return err
+		}
+		after, err := transferEntriesTx(tx, t)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`
+			UPDATE transfers
+			SET from_account_id = ?, to_account_id = ?, amount = ?, fee = ?, memo = ?, date = ?, updated_at = CURRENT_TIMESTAMP
+			WHERE id = ? AND user_id = ?
+		`, t.FromAccountID, t.ToAccountID, t.Amount, t.Fee, t.Memo, t.Date, t.ID, t.UserID)
+		if err != nil {
+			return fmt.Errorf("error updating transfer: %v", err)
+		}
+
+		if err := balances.ReplaceTx(tx, before, after); err != nil {
+			return fmt.Errorf("error replacing transfer in period balances: %v", err)
+		}
+		err = audit.RecordTx(tx, t.UserID, journal.Change{Actor: actor, Entity: "transfer", EntityID: t.ID,
+			Before: current, After: t, Reversed: before, Posted: after})
+		if err != nil {
+			return err
+		}
+		from, err := accounts.GetTx(tx, t.UserID, t.FromAccountID)
+		if err != nil {
+			return err
+		}
+		return checkBalance(tx, from, t.Date)
+	})
+	return t, err
+}
+
+// deleteTransfer removes a transfer and takes it out of the balances in
+// one transaction, recorded in the journal on behalf of actor
+func deleteTransfer(userID string, id int64, actor string) error {
+	return inTransaction(func(tx *sql.Tx) error {
+		t, err := getTransferTx(tx, userID, id)
+		if err != nil {
+			return err
+		}
+		entries, err := transferEntriesTx(tx, t)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(`DELETE FROM transfers WHERE id = ? AND user_id = ?`, id, userID); err != nil {
+			return fmt.Errorf("error deleting transfer: %v", err)
+		}
+		if err := balances.ReverseTx(tx, entries...); err != nil {
+			return fmt.Errorf("error reversing transfer in period balances: %v", err)
+		}
+		return audit.RecordTx(tx, userID, journal.Change{Actor: actor, Entity: "transfer", EntityID: id, Before: t, Reversed: entries})
+	})
+}
+
+// transferEntries returns what a transfer posts to the period balances
+func transferEntries(t Transfer, from, to account.Account) []ledger.Entry {
+	entries := []ledger.Entry{
+		from.Entry(ledger.Adjustment, -t.Amount, t.Date),
+		to.Entry(ledger.Adjustment, t.Amount, t.Date),
+	}
+	if t.Fee > 0 {
+		entries = append(entries, from.Entry(ledger.Expense, t.Fee, t.Date))
+	}
+	return entries
+}
+
+func transferEntriesTx(tx *sql.Tx, t Transfer) ([]ledger.Entry, error) {
+	from, err := accounts.GetTx(tx, t.UserID, t.FromAccountID)
+	if err != nil {
+		return nil, err
+	}
+	to, err := accounts.GetTx(tx, t.UserID, t.ToAccountID)
+	if err != nil {
+		return nil, err
+	}
+	return transferEntries(t, from, to), nil
+}
+
+// checkBalance fails with errNotEnoughBalance if a is below zero at the
+// end of date. Credit cards are allowed to, up to their credit limit.
+func checkBalance(tx *sql.Tx, a account.Account, date string) error {
+	if a.Type == account.CreditCard {
+		return accounts.CheckLimitTx(tx, a, date)
+	}
+	balance, err := balances.BalanceTx(tx, a.UserID, a.ID, date)
+	if err != nil {
+		return err
+	}
+	if balance < 0 {
+		return errNotEnoughBalance
+	}
+	return nil
+}
+
+const selectTransfer = `
+	SELECT id, user_id, from_account_id, to_account_id, amount, fee, memo, date,
+	       COALESCE(created_at, ''), COALESCE(updated_at, '')
+	FROM transfers`
+
+func scanTransfer(row interface{ Scan(...interface{}) error }) (Transfer, error) {
+	var t Transfer
+	err := row.Scan(&t.ID, &t.UserID, &t.FromAccountID, &t.ToAccountID, &t.Amount, &t.Fee, &t.Memo, &t.Date, &t.CreatedAt, &t.UpdatedAt)
+	return t, err
+}
+
+func getTransferTx(tx *sql.Tx, userID string, id int64) (Transfer, error) {
+	t, err := scanTransfer(tx.QueryRow(selectTransfer+` WHERE id = ? AND user_id = ?`, id, userID))
+	if err == sql.ErrNoRows {
+		return t, errTransferNotFound
+	}
+	if err != nil {
+		return t, fmt.Errorf("error reading transfer: %v", err)
+	}
+	return t, nil
+}
+
+// fetchTransfers returns the transfers matching filter, newest first
+func fetchTransfers(filter TransferFilter) ([]Transfer, error) {
+	conditions := []string{"user_id = ?"}
+	args := []interface{}{filter.UserID}
+	if filter.AccountID != 0 {
+		conditions = append(conditions, "(from_account_id = ? OR to_account_id = ?)")
+		args = append(args, filter.AccountID, filter.AccountID)
+	}
+	if filter.StartDate != "" {
+		conditions = append(conditions, "date >= ?")
+		args = append(args, filter.StartDate)
+	}
+	if filter.EndDate != "" {
+		conditions = append(conditions, "date <= ?")
+		args = append(args, filter.EndDate)
+	}
+
+	rows, err := db.Query(selectTransfer+` WHERE `+strings.Join(conditions, " AND ")+` ORDER BY date DESC, id DESC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transfers := []Transfer{}
+	for rows.Next() {
+		t, err := scanTransfer(rows)
+		if err != nil {
+			return nil, err
+		}
+		transfers = append(transfers, t)
+	}
+	return transfers, rows.Err()
+}
+
+// inTransaction runs fn in a transaction committed only if fn succeeds
+func inTransaction(fn func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// sendTransferError answers the errors of creating, changing or deleting
+// a transfer
+func sendTransferError(w http.ResponseWriter, err error, message, notEnoughMessage string) {
+	switch {
+	case errors.Is(err, errTransferNotFound):
+		sendErrorResponse(w, "Transfer not found", http.StatusNotFound)
+	case errors.Is(err, errInvalidTransfer):
+		sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, errNotEnoughBalance):
+		sendErrorResponse(w, notEnoughMessage, http.StatusBadRequest)
+	case errors.Is(err, account.ErrOverLimit):
+		sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+	default:
+		sendAccountError(w, err, message)
+	}
+}
@@ -12,6 +12,8 @@ import (
 	"strings"
 	"unicode/utf8"
 
+	"backend/common/auth"
+
 	_ "github.com/mattn/go-sqlite3"
 )
 
@@ -53,7 +55,8 @@ type ApiResponse struct {
 }
 
 var (
-	db *sql.DB
+	db       *sql.DB
+	sessions *auth.Manager
 )
 
 func init() {
@@ -132,12 +135,19 @@ func openDatabaseConnection() (*sql.DB, error) {
 }
 
 func main() {
+	// Every request must carry an access token issued by signin, google_auth or apple-auth
+	var err error
+	sessions, err = auth.NewManagerFromEnv(db)
+	if err != nil {
+		log.Fatalf("Failed to initialize sessions: %v", err)
+	}
+
 	// Set up CORS middleware and routes
-	http.HandleFunc("/categories", corsMiddleware(handleFetchCategories))
-	http.HandleFunc("/categories/add", corsMiddleware(handleAddCategory))
-	http.HandleFunc("/categories/update", corsMiddleware(handleUpdateCategory))
-	http.HandleFunc("/categories/delete", corsMiddleware(handleDeleteCategory))
-	http.HandleFunc("/categories/fix-emojis", corsMiddleware(handleFixEmojis))
+	http.HandleFunc("/categories", corsMiddleware(sessions.Require(handleFetchCategories)))
+	http.HandleFunc("/categories/add", corsMiddleware(sessions.Require(handleAddCategory)))
+	http.HandleFunc("/categories/update", corsMiddleware(sessions.Require(handleUpdateCategory)))
+	http.HandleFunc("/categories/delete", corsMiddleware(sessions.Require(handleDeleteCategory)))
+	http.HandleFunc("/categories/fix-emojis", corsMiddleware(sessions.Require(handleFixEmojis)))
 
 	port := 8096 // Puerto para el servicio de categorías
 	log.Printf("Categories Management service started on :%d", port)
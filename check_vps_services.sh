@@ -159,6 +159,6 @@ echo -e "${WHITE}bash test_production_endpoints.sh${NC}"
 
 echo -e "\n${CYAN}5. VERIFICAR NUEVO SERVICIO USER LOCALE:${NC}"
 echo -e "${WHITE}ssh $VPS_USER@$VPS_IP 'curl http://localhost:8099/health'${NC}"
-echo -e "${WHITE}ssh $VPS_USER@$VPS_IP 'curl http://localhost:8099/user_locale/get?user_id=2'${NC}"
+echo -e "${WHITE}ssh $VPS_USER@$VPS_IP 'curl -H \"Authorization: Bearer \$TOKEN\" http://localhost:8099/user_locale/get'${NC}"
 
 echo -e "\n${GREEN}📋 EJECUTAR ESTOS COMANDOS EN SECUENCIA PARA SOLUCIONAR${NC}" 
\ No newline at end of file
@@ -0,0 +1,698 @@
+// Package account keeps the accounts users hold their money in: bank
+// accounts, credit cards, wallets and cash, each with a name and an
+// opening balance. Incomes, expenses and bills reference the account they
+// were paid from or into through account_id.
+//
+// The period tables still split totals into cash and bank: cash accounts
+// post to the cash side, credit cards to the credit side, where charges
+// are debt until the statement is paid, and every other type to the bank
+// side, while the ledger keeps each account's own balance next to them.
+// Requests that only send a payment_method of "cash", "bank" or
+// "credit_card", as the apps did before accounts existed, go to the
+// user's first account of that type.
+//
+// Users start with an "Efectivo" (cash) and a "Banco" (bank) account the
+// first time any account is needed. They take over the cash and bank
+// history recorded before accounts existed, and the rows of that time are
+// assigned to them when the store is created.
+package account
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"backend/common/ledger"
+	"backend/common/money"
+)
+
+// Account types.
+const (
+	Cash       = "cash"
+	Bank       = "bank"
+	CreditCard = "credit_card"
+	Wallet     = "wallet"
+)
+
+// Types are the valid account types.
+var Types = []string{Cash, Bank, CreditCard, Wallet}
+
+var (
+	ErrNotFound = errors.New("account not found")
+	ErrInvalid  = errors.New("invalid account")
+	// ErrInUse is returned when deleting an account that transactions
+	// reference, that still holds money or that is the user's last one.
+	ErrInUse = errors.New("account in use")
+	// ErrOverLimit is returned by CheckLimitTx for a credit card charged
+	// beyond its credit limit.
+	ErrOverLimit = errors.New("credit limit exceeded")
+)
+
+// Tables are the transaction tables with an account_id column.
+var Tables = []string{"incomes", "expenses", "bills"}
+
+// TransfersTable holds the transfers between accounts, which reference
+// them through from_account_id and to_account_id.
+const TransfersTable = "transfers"
+
+// StatementColumn is the column of bills that pay a credit card statement
+// naming the card.
+const StatementColumn = "statement_account_id"
+
+// defaults are the accounts every user starts with, in creation order.
+var defaults = []Account{
+	{Name: "Efectivo", Type: Cash},
+	{Name: "Banco", Type: Bank},
+}
+
+// Account is one place a user keeps money in.
+type Account struct {
+	ID             int64       `json:"id"`
+	UserID         string      `json:"user_id"`
+	Name           string      `json:"name"`
+	Type           string      `json:"type"`
+	OpeningBalance money.Money `json:"opening_balance"`
+	OpeningDate    string      `json:"opening_date"`
+	// Credit cards only. A zero limit is no limit; a zero closing day
+	// means the card has no statements.
+	CreditLimit money.Money `json:"credit_limit,omitempty"`
+	ClosingDay  int         `json:"closing_day,omitempty"`
+	DueDay      int         `json:"due_day,omitempty"`
+	// Balance is filled in by List: the balance at the end of today. A
+	// credit card's is negative while it owes money.
+	Balance money.Money `json:"balance"`
+	// Available is filled in by List for credit cards with a limit: what
+	// can still be charged.
+	Available *money.Money `json:"available,omitempty"`
+	CreatedAt string       `json:"created_at,omitempty"`
+}
+
+// Method returns the side of the totals the account posts to.
+func (a Account) Method() string {
+	switch a.Type {
+	case Cash:
+		return ledger.Cash
+	case CreditCard:
+		return ledger.Credit
+	default:
+		return ledger.Bank
+	}
+}
+
+// Entry returns a ledger entry of kind for amount on date in the account.
+func (a Account) Entry(kind ledger.Kind, amount money.Money, date string) ledger.Entry {
+	return ledger.Entry{UserID: a.UserID, Kind: kind, Method: a.Method(), Account: a.ID, Amount: amount, Date: date}
+}
+
+// opening is the adjustment that puts the opening balance in the account.
+func (a Account) opening() ledger.Entry {
+	return a.Entry(ledger.Adjustment, a.OpeningBalance, a.OpeningDate)
+}
+
+func (a *Account) validate() error {
+	a.Name = strings.TrimSpace(a.Name)
+	if a.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalid)
+	}
+	valid := false
+	for _, t := range Types {
+		valid = valid || a.Type == t
+	}
+	if !valid {
+		return fmt.Errorf("%w: type must be one of %s", ErrInvalid, strings.Join(Types, ", "))
+	}
+	if a.OpeningDate == "" {
+		a.OpeningDate = time.Now().Format("2006-01-02")
+	}
+	if _, err := time.Parse("2006-01-02", a.OpeningDate); err != nil {
+		return fmt.Errorf("%w: opening date must be YYYY-MM-DD", ErrInvalid)
+	}
+
+	if a.Type != CreditCard {
+		if a.CreditLimit != 0 || a.ClosingDay != 0 || a.DueDay != 0 {
+			return fmt.Errorf("%w: only credit cards have a credit limit, closing day or due day", ErrInvalid)
+		}
+		return nil
+	}
+	if a.CreditLimit < 0 {
+		return fmt.Errorf("%w: credit limit cannot be negative", ErrInvalid)
+	}
+	if a.ClosingDay < 0 || a.ClosingDay > 31 || a.DueDay < 0 || a.DueDay > 31 {
+		return fmt.Errorf("%w: closing and due days must be between 1 and 31", ErrInvalid)
+	}
+	if (a.ClosingDay == 0) != (a.DueDay == 0) {
+		return fmt.Errorf("%w: closing day and due day go together", ErrInvalid)
+	}
+	return nil
+}
+
+// Store reads and writes accounts and posts their opening balances.
+type Store struct {
+	db     *sql.DB
+	ledger *ledger.Ledger
+	// tables are the Tables present in the database
+	tables []string
+	// transfers is set when the TransfersTable exists
+	transfers bool
+	// statements is set when bills have a StatementColumn
+	statements bool
+}
+
+// NewStore creates the accounts table, adds account_id to the transaction
+// tables that exist and gives every user with rows from before accounts
+// existed their default accounts.
+func NewStore(db *sql.DB, l *ledger.Ledger) (*Store, error) {
+	s := &Store{db: db, ledger: l}
+	if err := s.createTables(); err != nil {
+		return nil, err
+	}
+	if err := s.migrate(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Store) createTables() error {
+	_, err := s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS accounts (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id TEXT NOT NULL,
+			name TEXT NOT NULL,
+			type TEXT NOT NULL,
+			opening_balance INTEGER NOT NULL DEFAULT 0,
+			opening_date TEXT NOT NULL,
+			credit_limit INTEGER NOT NULL DEFAULT 0,
+			closing_day INTEGER NOT NULL DEFAULT 0,
+			due_day INTEGER NOT NULL DEFAULT 0,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating accounts table: %v", err)
+	}
+	if _, err := s.db.Exec(`CREATE INDEX IF NOT EXISTS idx_accounts_user ON accounts(user_id)`); err != nil {
+		return fmt.Errorf("error creating index on accounts: %v", err)
+	}
+	columns, err := tableColumns(s.db, "accounts")
+	if err != nil {
+		return err
+	}
+	cards := !columns["closing_day"]
+
+	for _, table := range Tables {
+		columns, err := tableColumns(s.db, table)
+		if err != nil {
+			return err
+		}
+		if len(columns) == 0 {
+			continue
+		}
+		if err := AddColumn(s.db, table, "account_id"); err != nil {
+			return err
+		}
+		s.tables = append(s.tables, table)
+		if table == "bills" {
+			if err := AddColumn(s.db, table, StatementColumn); err != nil {
+				return err
+			}
+			s.statements = true
+		}
+	}
+
+	columns, err = tableColumns(s.db, TransfersTable)
+	if err != nil {
+		return err
+	}
+	s.transfers = len(columns) > 0
+
+	if cards {
+		return s.addCardColumns()
+	}
+	return nil
+}
+
+// addCardColumns adds the credit card settings to an accounts table from
+// before cards had them. Cards posted to the bank side until then; their
+// history and rows move to the credit side.
+func (s *Store) addCardColumns() error {
+	return s.run(func(tx *sql.Tx) error {
+		for _, column := range []string{"credit_limit", "closing_day", "due_day"} {
+			if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE accounts ADD COLUMN %s INTEGER NOT NULL DEFAULT 0", column)); err != nil {
+				return fmt.Errorf("error adding %s to accounts: %v", column, err)
+			}
+		}
+
+		rows, err := tx.Query(`SELECT id, user_id FROM accounts WHERE type = ?`, CreditCard)
+		if err != nil {
+			return fmt.Errorf("error reading credit cards: %v", err)
+		}
+		var cards []Account
+		for rows.Next() {
+			a := Account{Type: CreditCard}
+			if err := rows.Scan(&a.ID, &a.UserID); err != nil {
+				rows.Close()
+				return fmt.Errorf("error reading credit cards: %v", err)
+			}
+			cards = append(cards, a)
+		}
+		rows.Close()
+
+		for _, a := range cards {
+			log.Printf("Moving credit card %d of user %s to the credit side", a.ID, a.UserID)
+			if err := s.ledger.MoveAccountTx(tx, a.UserID, a.ID, ledger.Bank, ledger.Credit); err != nil {
+				return err
+			}
+			for _, table := range s.tables {
+				_, err := tx.Exec(fmt.Sprintf(`UPDATE %s SET payment_method = ? WHERE user_id = ? AND account_id = ?`, table),
+					a.Method(), a.UserID, a.ID)
+				if err != nil {
+					return fmt.Errorf("error moving %s of account %d: %v", table, a.ID, err)
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// AddColumn adds an INTEGER account reference column to table, if it
+// exists and lacks it.
+func AddColumn(db *sql.DB, table, column string) error {
+	columns, err := tableColumns(db, table)
+	if err != nil || len(columns) == 0 || columns[column] {
+		return err
+	}
+	if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s INTEGER", table, column)); err != nil {
+		return fmt.Errorf("error adding %s to %s: %v", column, table, err)
+	}
+	return nil
+}
+
+// tableColumns returns the columns of table, none if it does not exist.
+func tableColumns(db *sql.DB, table string) (map[string]bool, error) {
+	rows, err := db.Query(`SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return nil, fmt.Errorf("error reading columns of %s: %v", table, err)
+	}
+	defer rows.Close()
+
+	columns := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("error reading columns of %s: %v", table, err)
+		}
+		columns[name] = true
+	}
+	return columns, rows.Err()
+}
+
+// migrate assigns every row without an account to an account of its
+// payment method, creating the user's default accounts first.
+func (s *Store) migrate() error {
+	if len(s.tables) == 0 {
+		return nil
+	}
+
+	var selects []string
+	for _, table := range s.tables {
+		selects = append(selects, fmt.Sprintf("SELECT user_id FROM %s WHERE account_id IS NULL", table))
+	}
+	rows, err := s.db.Query(strings.Join(selects, " UNION "))
+	if err != nil {
+		return fmt.Errorf("error reading rows without an account: %v", err)
+	}
+	var users []string
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			rows.Close()
+			return fmt.Errorf("error reading rows without an account: %v", err)
+		}
+		users = append(users, userID)
+	}
+	rows.Close()
+
+	for _, userID := range users {
+		log.Printf("Assigning accounts to the transactions of user %s", userID)
+		err := s.run(func(tx *sql.Tx) error {
+			if err := s.ensureDefaults(tx, userID); err != nil {
+				return err
+			}
+			// Users who already had accounts keep the rows written by an
+			// older service in their first account of the method
+			for _, method := range []string{ledger.Cash, ledger.Bank} {
+				a, err := s.ResolveTx(tx, userID, 0, method)
+				if err != nil {
+					return err
+				}
+				if err := assignRows(tx, s.tables, a); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// assignRows sets account_id on the rows of a's user and method that have
+// none. Rows without a payment method were cash.
+func assignRows(tx *sql.Tx, tables []string, a Account) error {
+	for _, table := range tables {
+		_, err := tx.Exec(fmt.Sprintf(`
+			UPDATE %s SET account_id = ?
+			WHERE user_id = ? AND account_id IS NULL AND COALESCE(payment_method, 'cash') = ?`, table),
+			a.ID, a.UserID, a.Method())
+		if err != nil {
+			return fmt.Errorf("error assigning %s to account %d: %v", table, a.ID, err)
+		}
+	}
+	return nil
+}
+
+// ensureDefaults creates the default accounts of a user who has none,
+// handing them the cash and bank history and rows recorded so far.
+func (s *Store) ensureDefaults(tx *sql.Tx, userID string) error {
+	var count int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM accounts WHERE user_id = ?`, userID).Scan(&count); err != nil {
+		return fmt.Errorf("error counting accounts: %v", err)
+	}
+	if count > 0 {
+		return nil
+	}
+
+	for _, a := range defaults {
+		a.UserID = userID
+		if err := s.insert(tx, &a); err != nil {
+			return err
+		}
+		if err := s.ledger.SeedAccountTx(tx, userID, a.Method(), a.ID); err != nil {
+			return err
+		}
+		if err := assignRows(tx, s.tables, a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insert validates a and stores it, setting its ID. The opening balance
+// is not posted.
+func (s *Store) insert(tx *sql.Tx, a *Account) error {
+	if err := a.validate(); err != nil {
+		return err
+	}
+	result, err := tx.Exec(`
+		INSERT INTO accounts (user_id, name, type, opening_balance, opening_date, credit_limit, closing_day, due_day)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`, a.UserID, a.Name, a.Type, a.OpeningBalance, a.OpeningDate, a.CreditLimit, a.ClosingDay, a.DueDay)
+	if err != nil {
+		return fmt.Errorf("error creating account: %v", err)
+	}
+	a.ID, err = result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("error creating account: %v", err)
+	}
+	return nil
+}
+
+// run calls fn in a transaction of its own.
+func (s *Store) run(fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting account transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// querier is what *sql.DB and *sql.Tx have in common for reads.
+type querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+const selectAccount = `SELECT id, user_id, name, type, opening_balance, opening_date, credit_limit, closing_day, due_day,
+	COALESCE(created_at, '') FROM accounts`
+
+func scanAccount(row interface{ Scan(...interface{}) error }) (Account, error) {
+	var a Account
+	err := row.Scan(&a.ID, &a.UserID, &a.Name, &a.Type, &a.OpeningBalance, &a.OpeningDate,
+		&a.CreditLimit, &a.ClosingDay, &a.DueDay, &a.CreatedAt)
+	return a, err
+}
+
+func list(q querier, userID string) ([]Account, error) {
+	rows, err := q.Query(selectAccount+` WHERE user_id = ? ORDER BY id`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error reading accounts: %v", err)
+	}
+	defer rows.Close()
+
+	var accounts []Account
+	for rows.Next() {
+		a, err := scanAccount(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error reading accounts: %v", err)
+		}
+		accounts = append(accounts, a)
+	}
+	return accounts, rows.Err()
+}
+
+// List returns the accounts of userID with their balance today, creating
+// the default ones if the user has none yet.
+func (s *Store) List(userID string) ([]Account, error) {
+	if err := s.run(func(tx *sql.Tx) error { return s.ensureDefaults(tx, userID) }); err != nil {
+		return nil, err
+	}
+	accounts, err := list(s.db, userID)
+	if err != nil {
+		return nil, err
+	}
+	balances, err := s.ledger.Balances(userID, time.Now().Format("2006-01-02"))
+	if err != nil {
+		return nil, err
+	}
+	for i := range accounts {
+		accounts[i].Balance = balances[accounts[i].ID]
+		if accounts[i].Type == CreditCard && accounts[i].CreditLimit > 0 {
+			available := accounts[i].CreditLimit + accounts[i].Balance
+			accounts[i].Available = &available
+		}
+	}
+	return accounts, nil
+}
+
+// ListTx returns the accounts of userID inside the caller's transaction,
+// without balances and without creating the default ones.
+func (s *Store) ListTx(tx *sql.Tx, userID string) ([]Account, error) {
+	return list(tx, userID)
+}
+
+// Get returns account id of userID.
+func (s *Store) Get(userID string, id int64) (Account, error) {
+	var a Account
+	err := s.run(func(tx *sql.Tx) error {
+		var err error
+		a, err = s.GetTx(tx, userID, id)
+		return err
+	})
+	return a, err
+}
+
+// GetTx returns account id of userID. It fails with ErrNotFound for
+// accounts of other users.
+func (s *Store) GetTx(tx *sql.Tx, userID string, id int64) (Account, error) {
+	a, err := scanAccount(tx.QueryRow(selectAccount+` WHERE id = ? AND user_id = ?`, id, userID))
+	if err == sql.ErrNoRows {
+		return Account{}, fmt.Errorf("%w: %d", ErrNotFound, id)
+	}
+	if err != nil {
+		return Account{}, fmt.Errorf("error reading account: %v", err)
+	}
+	return a, nil
+}
+
+// CardsTx returns the credit cards of userID that have statements.
+func (s *Store) CardsTx(tx *sql.Tx, userID string) ([]Account, error) {
+	rows, err := tx.Query(selectAccount+` WHERE user_id = ? AND type = ? AND closing_day > 0 ORDER BY id`, userID, CreditCard)
+	if err != nil {
+		return nil, fmt.Errorf("error reading credit cards: %v", err)
+	}
+	defer rows.Close()
+
+	var cards []Account
+	for rows.Next() {
+		a, err := scanAccount(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error reading credit cards: %v", err)
+		}
+		cards = append(cards, a)
+	}
+	return cards, rows.Err()
+}
+
+// ResolveTx returns the account a transaction of userID goes to: account
+// id if set, otherwise the user's first account of type method ("cash",
+// "bank" or "credit_card"). Missing cash and bank accounts are created;
+// cards have to be added first.
+func (s *Store) ResolveTx(tx *sql.Tx, userID string, id int64, method string) (Account, error) {
+	if id != 0 {
+		return s.GetTx(tx, userID, id)
+	}
+	if method != ledger.Cash && method != ledger.Bank && method != ledger.Credit {
+		return Account{}, fmt.Errorf("%w: an account or a payment method (cash, bank or credit_card) is required", ErrInvalid)
+	}
+	if err := s.ensureDefaults(tx, userID); err != nil {
+		return Account{}, err
+	}
+
+	a, err := scanAccount(tx.QueryRow(selectAccount+` WHERE user_id = ? AND type = ? ORDER BY id LIMIT 1`, userID, method))
+	if err == nil {
+		return a, nil
+	}
+	if err != sql.ErrNoRows {
+		return Account{}, fmt.Errorf("error reading account: %v", err)
+	}
+	if method == ledger.Credit {
+		return Account{}, fmt.Errorf("%w: add a credit card account first", ErrInvalid)
+	}
+	for _, d := range defaults {
+		if d.Type == method {
+			a = d
+		}
+	}
+	a.UserID = userID
+	if err := s.insert(tx, &a); err != nil {
+		return Account{}, err
+	}
+	return a, nil
+}
+
+// Create stores a new account for a.UserID and posts its opening balance.
+func (s *Store) Create(a Account) (Account, error) {
+	err := s.run(func(tx *sql.Tx) error {
+		if err := s.ensureDefaults(tx, a.UserID); err != nil {
+			return err
+		}
+		if err := s.insert(tx, &a); err != nil {
+			return err
+		}
+		if a.OpeningBalance != 0 {
+			return s.ledger.PostTx(tx, a.opening())
+		}
+		return nil
+	})
+	return a, err
+}
+
+// Update changes the name, type, opening balance and card settings of an
+// account. The type cannot move an account between the cash, bank and
+// credit sides.
+func (s *Store) Update(a Account) (Account, error) {
+	err := s.run(func(tx *sql.Tx) error {
+		current, err := s.GetTx(tx, a.UserID, a.ID)
+		if err != nil {
+			return err
+		}
+		if err := a.validate(); err != nil {
+			return err
+		}
+		if a.Method() != current.Method() {
+			return fmt.Errorf("%w: cash and credit card accounts cannot change to another type or back", ErrInvalid)
+		}
+
+		_, err = tx.Exec(`
+			UPDATE accounts SET name = ?, type = ?, opening_balance = ?, opening_date = ?,
+			       credit_limit = ?, closing_day = ?, due_day = ?, updated_at = CURRENT_TIMESTAMP
+			WHERE id = ? AND user_id = ?`, a.Name, a.Type, a.OpeningBalance, a.OpeningDate,
+			a.CreditLimit, a.ClosingDay, a.DueDay, a.ID, a.UserID)
+		if err != nil {
+			return fmt.Errorf("error updating account: %v", err)
+		}
+		a.CreatedAt = current.CreatedAt
+
+		if before, after := current.opening(), a.opening(); before != after {
+			return s.ledger.AmendTx(tx, before, after)
+		}
+		return nil
+	})
+	return a, err
+}
+
+// Delete removes an account that nothing references and whose only
+// movement is its opening balance. The user's last account stays.
+func (s *Store) Delete(userID string, id int64) error {
+	return s.run(func(tx *sql.Tx) error {
+		a, err := s.GetTx(tx, userID, id)
+		if err != nil {
+			return err
+		}
+
+		for _, table := range s.tables {
+			var count int
+			err := tx.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE user_id = ? AND account_id = ?", table), userID, id).Scan(&count)
+			if err != nil {
+				return fmt.Errorf("error counting %s: %v", table, err)
+			}
+			if count > 0 {
+				return fmt.Errorf("%w: it has %s", ErrInUse, table)
+			}
+		}
+		if s.transfers {
+			var count int
+			err := tx.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE user_id = ? AND (from_account_id = ? OR to_account_id = ?)", TransfersTable),
+				userID, id, id).Scan(&count)
+			if err != nil {
+				return fmt.Errorf("error counting transfers: %v", err)
+			}
+			if count > 0 {
+				return fmt.Errorf("%w: it has transfers", ErrInUse)
+			}
+		}
+		if s.statements {
+			var count int
+			err := tx.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM bills WHERE user_id = ? AND %s = ?", StatementColumn), userID, id).Scan(&count)
+			if err != nil {
+				return fmt.Errorf("error counting statements: %v", err)
+			}
+			if count > 0 {
+				return fmt.Errorf("%w: it has statement bills", ErrInUse)
+			}
+		}
+		var others int
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM accounts WHERE user_id = ? AND id != ?`, userID, id).Scan(&others); err != nil {
+			return fmt.Errorf("error counting accounts: %v", err)
+		}
+		if others == 0 {
+			return fmt.Errorf("%w: it is the last account", ErrInUse)
+		}
+
+		if a.OpeningBalance != 0 {
+			if err := s.ledger.ReverseTx(tx, a.opening()); err != nil {
+				return err
+			}
+		}
+		if err := s.ledger.DropAccountTx(tx, userID, id); err != nil {
+			if errors.Is(err, ledger.ErrAccountNotEmpty) {
+				return fmt.Errorf("%w: it has transfers or balance changes", ErrInUse)
+			}
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM accounts WHERE id = ? AND user_id = ?`, id, userID); err != nil {
+			return fmt.Errorf("error deleting account: %v", err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,321 @@
+package account
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	"backend/common/dbtest"
+	"backend/common/ledger"
+	"backend/common/money"
+)
+
+func newTestStore(t *testing.T, db *sql.DB) (*Store, *ledger.Ledger) {
+	t.Helper()
+
+	l, err := ledger.New(db)
+	if err != nil {
+		t.Fatalf("Failed to create ledger: %v", err)
+	}
+	s, err := NewStore(db, l)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	return s, l
+}
+
+func balance(t *testing.T, s *Store, userID string, id int64) money.Money {
+	t.Helper()
+	accounts, err := s.List(userID)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	for _, a := range accounts {
+		if a.ID == id {
+			return a.Balance
+		}
+	}
+	t.Fatalf("Account %d not listed", id)
+	return 0
+}
+
+func TestNewStoreMigratesLegacyRows(t *testing.T) {
+	db := dbtest.Open(t)
+	_, err := db.Exec(`CREATE TABLE incomes (id INTEGER PRIMARY KEY, user_id TEXT, amount INTEGER, date TEXT, payment_method TEXT);
+		INSERT INTO incomes (user_id, amount, date, payment_method) VALUES ('u1', 1000, '2024-01-10', 'bank'), ('u1', 200, '2024-01-11', 'cash')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := ledger.New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The period tables already hold the incomes, without accounts
+	l.Post(
+		ledger.Entry{UserID: "u1", Kind: ledger.Income, Method: ledger.Bank, Amount: 1000, Date: "2024-01-10"},
+		ledger.Entry{UserID: "u1", Kind: ledger.Income, Method: ledger.Cash, Amount: 200, Date: "2024-01-11"},
+	)
+
+	s, err := NewStore(db, l)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	accounts, err := s.List("u1")
+	if err != nil || len(accounts) != 2 {
+		t.Fatalf("List = %+v, %v; want the two defaults", accounts, err)
+	}
+	cash, bank := accounts[0], accounts[1]
+	if cash.Type != Cash || cash.Balance != 200 || bank.Type != Bank || bank.Balance != 1000 {
+		t.Errorf("Defaults = %+v", accounts)
+	}
+
+	var unassigned int
+	db.QueryRow(`SELECT COUNT(*) FROM incomes WHERE account_id IS NULL`).Scan(&unassigned)
+	var bankRow int64
+	db.QueryRow(`SELECT account_id FROM incomes WHERE payment_method = 'bank'`).Scan(&bankRow)
+	if unassigned != 0 || bankRow != bank.ID {
+		t.Errorf("Rows left without account: %d, bank income in %d", unassigned, bankRow)
+	}
+
+	// Creating the store again changes nothing
+	if _, err := NewStore(db, l); err != nil {
+		t.Fatalf("NewStore again: %v", err)
+	}
+	if again, _ := s.List("u1"); len(again) != 2 {
+		t.Errorf("Accounts after a restart = %+v", again)
+	}
+}
+
+func TestCreateUpdateDelete(t *testing.T) {
+	s, l := newTestStore(t, dbtest.Open(t))
+
+	card, err := s.Create(Account{UserID: "u1", Name: " Visa ", Type: CreditCard, OpeningBalance: -25000, OpeningDate: "2024-01-01"})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if card.Name != "Visa" || card.Method() != ledger.Credit {
+		t.Errorf("Created %+v", card)
+	}
+	if got := balance(t, s, "u1", card.ID); got != -25000 {
+		t.Errorf("Opening balance = %v, want -250", got)
+	}
+	// The defaults were created first so they can take over old history
+	if accounts, _ := s.List("u1"); len(accounts) != 3 {
+		t.Errorf("Accounts = %+v", accounts)
+	}
+
+	for _, bad := range []Account{
+		{UserID: "u1", Name: "", Type: Bank},
+		{UserID: "u1", Name: "Piggy", Type: "piggy"},
+		{UserID: "u1", Name: "Late", Type: Bank, OpeningDate: "2024-02-30"},
+	} {
+		if _, err := s.Create(bad); !errors.Is(err, ErrInvalid) {
+			t.Errorf("Create(%+v) error = %v, want ErrInvalid", bad, err)
+		}
+	}
+
+	card.OpeningBalance = -10000
+	card.CreditLimit, card.ClosingDay, card.DueDay = 50000, 25, 10
+	if _, err := s.Update(card); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if got := balance(t, s, "u1", card.ID); got != -10000 {
+		t.Errorf("Balance after update = %v, want -100", got)
+	}
+	for _, other := range []string{Cash, Wallet} {
+		changed := card
+		changed.Type, changed.CreditLimit, changed.ClosingDay, changed.DueDay = other, 0, 0, 0
+		if _, err := s.Update(changed); !errors.Is(err, ErrInvalid) {
+			t.Errorf("Update to %s error = %v, want ErrInvalid", other, err)
+		}
+	}
+	if _, err := s.Update(Account{UserID: "u2", ID: card.ID, Name: "Mine", Type: Bank}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Update of another user's account error = %v, want ErrNotFound", err)
+	}
+
+	// Money moved into the account keeps it
+	move := card.Entry(ledger.Adjustment, 500, "2024-03-01")
+	l.Post(move)
+	if err := s.Delete("u1", card.ID); !errors.Is(err, ErrInUse) {
+		t.Errorf("Delete with movements error = %v, want ErrInUse", err)
+	}
+	l.Reverse(move)
+	if err := s.Delete("u1", card.ID); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	var total money.Money
+	s.db.QueryRow(`SELECT total_balance FROM annual_cash_bank_balance WHERE user_id = 'u1' AND year = '2024'`).Scan(&total)
+	if total != 0 {
+		t.Errorf("Total after deleting the account = %v, want 0", total)
+	}
+
+	accounts, _ := s.List("u1")
+	if err := s.Delete("u1", accounts[0].ID); err != nil {
+		t.Fatalf("Delete of an empty default: %v", err)
+	}
+	if err := s.Delete("u1", accounts[1].ID); !errors.Is(err, ErrInUse) {
+		t.Errorf("Delete of the last account error = %v, want ErrInUse", err)
+	}
+}
+
+func TestResolveTx(t *testing.T) {
+	db := dbtest.Open(t)
+	s, _ := newTestStore(t, db)
+
+	resolve := func(userID string, id int64, method string) (Account, error) {
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tx.Commit()
+		return s.ResolveTx(tx, userID, id, method)
+	}
+
+	cash, err := resolve("u1", 0, "cash")
+	if err != nil || cash.Name != "Efectivo" {
+		t.Fatalf("Resolve(cash) = %+v, %v", cash, err)
+	}
+	bank, err := resolve("u1", 0, "bank")
+	if err != nil || bank.Name != "Banco" {
+		t.Fatalf("Resolve(bank) = %+v, %v", bank, err)
+	}
+	if got, err := resolve("u1", bank.ID, ""); err != nil || got.ID != bank.ID {
+		t.Errorf("Resolve by id = %+v, %v", got, err)
+	}
+	if _, err := resolve("u2", bank.ID, ""); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Resolve of another user's account error = %v, want ErrNotFound", err)
+	}
+	if _, err := resolve("u1", 0, "card"); !errors.Is(err, ErrInvalid) {
+		t.Errorf("Resolve(card) error = %v, want ErrInvalid", err)
+	}
+
+	// A missing default comes back for legacy requests
+	if err := s.Delete("u1", bank.ID); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	again, err := resolve("u1", 0, "bank")
+	if err != nil || again.ID == bank.ID || again.Type != Bank {
+		t.Errorf("Resolve(bank) after deleting it = %+v, %v", again, err)
+	}
+}
+
+func TestCreditCards(t *testing.T) {
+	db := dbtest.Open(t)
+	s, l := newTestStore(t, db)
+
+	for _, bad := range []Account{
+		{UserID: "u1", Name: "Banco 2", Type: Bank, CreditLimit: 1000},
+		{UserID: "u1", Name: "Visa", Type: CreditCard, CreditLimit: -1},
+		{UserID: "u1", Name: "Visa", Type: CreditCard, ClosingDay: 32, DueDay: 5},
+		{UserID: "u1", Name: "Visa", Type: CreditCard, ClosingDay: 25},
+	} {
+		if _, err := s.Create(bad); !errors.Is(err, ErrInvalid) {
+			t.Errorf("Create(%+v) error = %v, want ErrInvalid", bad, err)
+		}
+	}
+
+	card, err := s.Create(Account{UserID: "u1", Name: "Visa", Type: CreditCard, CreditLimit: 50000, ClosingDay: 31, DueDay: 10})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	// The cycle closing in February ends on its last day and is due in March
+	start, closing, due, err := card.Cycle("2025-02")
+	if err != nil || start.Format("2006-01-02") != "2025-02-01" || closing.Format("2006-01-02") != "2025-02-28" ||
+		due.Format("2006-01-02") != "2025-03-10" {
+		t.Errorf("Cycle(2025-02) = %v, %v, %v, %v", start, closing, due, err)
+	}
+	if month, err := card.LastClosed("2025-03-15"); err != nil || month != "2025-02" {
+		t.Errorf("LastClosed(2025-03-15) = %q, %v; want 2025-02", month, err)
+	}
+	if month, _ := card.LastClosed("2025-03-31"); month != "2025-03" {
+		t.Errorf("LastClosed(2025-03-31) = %q, want 2025-03", month)
+	}
+
+	l.Post(
+		card.Entry(ledger.Expense, 30000, "2025-02-10"),
+		card.Entry(ledger.Expense, 5000, "2025-03-02"),
+	)
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	statement, err := s.StatementTx(tx, card, "2025-02")
+	if err != nil || statement.Balance != 30000 || statement.DueDate != "2025-03-10" {
+		t.Errorf("StatementTx(2025-02) = %+v, %v", statement, err)
+	}
+	if err := s.CheckLimitTx(tx, card, "2025-03-02"); err != nil {
+		t.Errorf("CheckLimitTx under the limit = %v", err)
+	}
+	l.PostTx(tx, card.Entry(ledger.Expense, 20000, "2025-03-05"))
+	if err := s.CheckLimitTx(tx, card, "2025-03-05"); !errors.Is(err, ErrOverLimit) {
+		t.Errorf("CheckLimitTx over the limit = %v, want ErrOverLimit", err)
+	}
+	tx.Rollback()
+
+	// A backdated charge is under the limit on its own day but not on later ones
+	tx, _ = db.Begin()
+	l.PostTx(tx, card.Entry(ledger.Expense, 16000, "2025-02-20"))
+	if err := s.CheckLimitTx(tx, card, "2025-02-20"); !errors.Is(err, ErrOverLimit) {
+		t.Errorf("CheckLimitTx over the limit on a later day = %v, want ErrOverLimit", err)
+	}
+	tx.Rollback()
+
+	// Charges are debt, not money taken out of the bank
+	accounts, _ := s.List("u1")
+	listed := accounts[len(accounts)-1]
+	if listed.Balance != -35000 || listed.Available == nil || *listed.Available != 15000 {
+		t.Errorf("Listed card = %+v", listed)
+	}
+	var total, debt money.Money
+	db.QueryRow(`SELECT total_balance, balance_credit_amount FROM annual_balance WHERE user_id = 'u1' AND year = '2025'`).Scan(&total, &debt)
+	if total != 0 || debt != -35000 {
+		t.Errorf("Total %v, debt %v; want 0, -350", total, debt)
+	}
+
+	tx, _ = db.Begin()
+	defer tx.Commit()
+	if got, err := s.ResolveTx(tx, "u1", 0, ledger.Credit); err != nil || got.ID != card.ID {
+		t.Errorf("Resolve(credit_card) = %+v, %v", got, err)
+	}
+	if _, err := s.ResolveTx(tx, "u2", 0, ledger.Credit); !errors.Is(err, ErrInvalid) {
+		t.Errorf("Resolve(credit_card) without cards error = %v, want ErrInvalid", err)
+	}
+}
+
+func TestNewStoreMovesCardsToTheCreditSide(t *testing.T) {
+	db := dbtest.Open(t)
+	_, err := db.Exec(`CREATE TABLE accounts (id INTEGER PRIMARY KEY AUTOINCREMENT, user_id TEXT NOT NULL, name TEXT NOT NULL,
+			type TEXT NOT NULL, opening_balance INTEGER NOT NULL DEFAULT 0, opening_date TEXT NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP);
+		INSERT INTO accounts (user_id, name, type, opening_date) VALUES ('u1', 'Banco', 'bank', '2024-01-01'), ('u1', 'Visa', 'credit_card', '2024-01-01');
+		CREATE TABLE expenses (id INTEGER PRIMARY KEY, user_id TEXT, amount INTEGER, date TEXT, payment_method TEXT, account_id INTEGER);
+		INSERT INTO expenses (user_id, amount, date, payment_method, account_id) VALUES ('u1', 4000, '2024-02-01', 'bank', 2)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := ledger.New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Cards used to post to the bank side
+	l.Post(ledger.Entry{UserID: "u1", Kind: ledger.Expense, Method: ledger.Bank, Account: 2, Amount: 4000, Date: "2024-02-01"})
+
+	s, err := NewStore(db, l)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	var bank, debt money.Money
+	db.QueryRow(`SELECT balance_bank_amount, balance_credit_amount FROM annual_cash_bank_balance WHERE user_id = 'u1' AND year = '2024'`).Scan(&bank, &debt)
+	if bank != 0 || debt != -4000 {
+		t.Errorf("Bank %v, debt %v; want 0, -40", bank, debt)
+	}
+	var method string
+	db.QueryRow(`SELECT payment_method FROM expenses`).Scan(&method)
+	if method != ledger.Credit {
+		t.Errorf("Expense payment method = %q, want %q", method, ledger.Credit)
+	}
+	if got := balance(t, s, "u1", 2); got != -4000 {
+		t.Errorf("Card balance = %v, want -40", got)
+	}
+}
@@ -0,0 +1,107 @@
+package account
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"backend/common/money"
+)
+
+// Statement is what a credit card owes at the close of one billing cycle.
+type Statement struct {
+	AccountID int64 `json:"account_id"`
+	// Month is the YYYY-MM the cycle closes in.
+	Month       string `json:"month"`
+	Start       string `json:"start"`
+	ClosingDate string `json:"closing_date"`
+	DueDate     string `json:"due_date"`
+	// Balance is the debt at the end of the closing date, payments made
+	// until then included.
+	Balance money.Money `json:"balance"`
+}
+
+// dayIn returns day of the month of t, or its last day in shorter months.
+func dayIn(t time.Time, day int) time.Time {
+	first := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	if last := first.AddDate(0, 1, -1).Day(); day > last {
+		day = last
+	}
+	return first.AddDate(0, 0, day-1)
+}
+
+// Cycle returns the dates of the card's cycle that closes in month
+// (YYYY-MM). The statement is due on the next due day after closing.
+func (a Account) Cycle(month string) (start, closing, due time.Time, err error) {
+	if a.Type != CreditCard || a.ClosingDay == 0 {
+		return start, closing, due, fmt.Errorf("%w: account %d has no statements", ErrInvalid, a.ID)
+	}
+	m, err := time.Parse("2006-01", month)
+	if err != nil {
+		return start, closing, due, fmt.Errorf("%w: month must be YYYY-MM", ErrInvalid)
+	}
+
+	closing = dayIn(m, a.ClosingDay)
+	start = dayIn(m.AddDate(0, -1, 0), a.ClosingDay).AddDate(0, 0, 1)
+	due = dayIn(m, a.DueDay)
+	if !due.After(closing) {
+		due = dayIn(m.AddDate(0, 1, 0), a.DueDay)
+	}
+	return start, closing, due, nil
+}
+
+// LastClosed returns the month of the latest cycle closed on or before
+// date (YYYY-MM-DD).
+func (a Account) LastClosed(date string) (string, error) {
+	d, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return "", fmt.Errorf("%w: date must be YYYY-MM-DD", ErrInvalid)
+	}
+	month := d.Format("2006-01")
+	_, closing, _, err := a.Cycle(month)
+	if err != nil {
+		return "", err
+	}
+	if closing.After(d) {
+		month = time.Date(d.Year(), d.Month()-1, 1, 0, 0, 0, 0, time.UTC).Format("2006-01")
+	}
+	return month, nil
+}
+
+// StatementTx returns the statement of card a for the cycle closing in
+// month.
+func (s *Store) StatementTx(tx *sql.Tx, a Account, month string) (Statement, error) {
+	start, closing, due, err := a.Cycle(month)
+	if err != nil {
+		return Statement{}, err
+	}
+	balance, err := s.ledger.BalanceTx(tx, a.UserID, a.ID, closing.Format("2006-01-02"))
+	if err != nil {
+		return Statement{}, err
+	}
+	return Statement{
+		AccountID:   a.ID,
+		Month:       month,
+		Start:       start.Format("2006-01-02"),
+		ClosingDate: closing.Format("2006-01-02"),
+		DueDate:     due.Format("2006-01-02"),
+		Balance:     -balance,
+	}, nil
+}
+
+// CheckLimitTx fails with ErrOverLimit if a is a credit card with a limit
+// that owes more than it at the end of date or of any later day, after
+// the caller's movements.
+func (s *Store) CheckLimitTx(tx *sql.Tx, a Account, date string) error {
+	if a.Type != CreditCard || a.CreditLimit == 0 {
+		return nil
+	}
+	balance, err := s.ledger.LowestBalanceTx(tx, a.UserID, a.ID, date)
+	if err != nil {
+		return err
+	}
+	if -balance > a.CreditLimit {
+		return fmt.Errorf("%w: %s owes %s of %s", ErrOverLimit, a.Name, -balance, a.CreditLimit)
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"backend/common/config"
+)
+
+// RequireAdmin lets through only requests that carry the ADMIN_TOKEN
+// shared secret as their bearer token. Admin endpoints are for operators,
+// not users, so they do not use sessions. While ADMIN_TOKEN is unset they
+// answer 404, as if they did not exist.
+func RequireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		secret := config.String("ADMIN_TOKEN", "")
+		if secret == "" {
+			http.NotFound(w, r)
+			return
+		}
+
+		token := BearerToken(r)
+		if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(secret)) != 1 {
+			writeUnauthorized(w, "Invalid admin token")
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,219 @@
+package auth
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+func newTestManager(t *testing.T) *Manager {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	m, err := NewManager(db, testSecret, time.Minute, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	return m
+}
+
+func TestIssueAndAuthenticate(t *testing.T) {
+	m := newTestManager(t)
+
+	pair, err := m.IssueTokens(42, "test-agent", "127.0.0.1")
+	if err != nil {
+		t.Fatalf("IssueTokens failed: %v", err)
+	}
+
+	claims, err := m.Authenticate(pair.AccessToken)
+	if err != nil {
+		t.Fatalf("Authenticate failed: %v", err)
+	}
+	if claims.Subject != "42" {
+		t.Errorf("Expected subject 42, got %s", claims.Subject)
+	}
+
+	tampered := pair.AccessToken[:len(pair.AccessToken)-2] + "xx"
+	if _, err := m.Authenticate(tampered); err != ErrInvalidToken {
+		t.Errorf("Expected ErrInvalidToken for tampered token, got %v", err)
+	}
+
+	m.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	if _, err := m.Authenticate(pair.AccessToken); err != ErrExpiredToken {
+		t.Errorf("Expected ErrExpiredToken, got %v", err)
+	}
+}
+
+func TestRefreshRotatesToken(t *testing.T) {
+	m := newTestManager(t)
+
+	pair, err := m.IssueTokens(7, "", "")
+	if err != nil {
+		t.Fatalf("IssueTokens failed: %v", err)
+	}
+
+	refreshed, err := m.Refresh(pair.RefreshToken)
+	if err != nil {
+		t.Fatalf("Refresh failed: %v", err)
+	}
+	if refreshed.RefreshToken == pair.RefreshToken {
+		t.Errorf("Expected refresh token to rotate")
+	}
+
+	if _, err := m.Refresh(pair.RefreshToken); err != ErrInvalidToken {
+		t.Errorf("Expected reused refresh token to be rejected, got %v", err)
+	}
+}
+
+func TestRevokeEndsSessions(t *testing.T) {
+	m := newTestManager(t)
+
+	first, _ := m.IssueTokens(5, "", "")
+	second, _ := m.IssueTokens(5, "", "")
+
+	claims, err := m.Authenticate(first.AccessToken)
+	if err != nil {
+		t.Fatalf("Authenticate failed: %v", err)
+	}
+	if err := m.RevokeSession(claims.SessionID); err != nil {
+		t.Fatalf("RevokeSession failed: %v", err)
+	}
+	if _, err := m.Authenticate(first.AccessToken); err != ErrRevoked {
+		t.Errorf("Expected ErrRevoked after logout, got %v", err)
+	}
+	if _, err := m.Authenticate(second.AccessToken); err != nil {
+		t.Errorf("Expected other session to stay active, got %v", err)
+	}
+
+	if err := m.RevokeAllSessions(5); err != nil {
+		t.Fatalf("RevokeAllSessions failed: %v", err)
+	}
+	if _, err := m.Refresh(second.RefreshToken); err != ErrRevoked {
+		t.Errorf("Expected ErrRevoked on refresh, got %v", err)
+	}
+}
+
+func TestRequireBindsAuthenticatedUser(t *testing.T) {
+	m := newTestManager(t)
+	pair, _ := m.IssueTokens(9, "", "")
+
+	var gotBody map[string]interface{}
+	var gotQuery, gotContext string
+	handler := m.Require(func(w http.ResponseWriter, r *http.Request) {
+		gotQuery = r.URL.Query().Get("user_id")
+		gotContext = UserID(r)
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &gotBody)
+	})
+
+	// No token
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest("GET", "/expenses?user_id=1", nil))
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without token, got %d", rr.Code)
+	}
+
+	// Query string user_id is replaced
+	req := httptest.NewRequest("GET", "/expenses?user_id=1", nil)
+	req.Header.Set("Authorization", "Bearer "+pair.AccessToken)
+	handler(httptest.NewRecorder(), req)
+	if gotQuery != "9" || gotContext != "9" {
+		t.Errorf("Expected user 9 in query and context, got %q and %q", gotQuery, gotContext)
+	}
+
+	// JSON body user_id is replaced, keeping its type
+	req = httptest.NewRequest("POST", "/profile/update", bytes.NewBufferString(`{"user_id": 1, "name": "x"}`))
+	req.Header.Set("Authorization", "Bearer "+pair.AccessToken)
+	handler(httptest.NewRecorder(), req)
+	if gotBody["user_id"] != float64(9) || gotBody["name"] != "x" {
+		t.Errorf("Expected numeric user_id 9 in body, got %v", gotBody)
+	}
+
+	req = httptest.NewRequest("POST", "/expenses/add", bytes.NewBufferString(`{"amount": 10}`))
+	req.Header.Set("Authorization", "Bearer "+pair.AccessToken)
+	handler(httptest.NewRecorder(), req)
+	if gotBody["user_id"] != "9" {
+		t.Errorf("Expected string user_id 9 in body, got %v", gotBody["user_id"])
+	}
+}
+
+func TestRequireAdmin(t *testing.T) {
+	called := false
+	handler := RequireAdmin(func(w http.ResponseWriter, r *http.Request) { called = true })
+	request := func(token string) int {
+		req := httptest.NewRequest("POST", "/admin/exchange-rates", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		return rr.Code
+	}
+
+	t.Setenv("ADMIN_TOKEN", "")
+	if code := request("anything"); code != http.StatusNotFound || called {
+		t.Errorf("Expected 404 while ADMIN_TOKEN is unset, got %d", code)
+	}
+
+	t.Setenv("ADMIN_TOKEN", "s3cret")
+	if code := request(""); code != http.StatusUnauthorized || called {
+		t.Errorf("Expected 401 without token, got %d", code)
+	}
+	if code := request("wrong"); code != http.StatusUnauthorized || called {
+		t.Errorf("Expected 401 with a wrong token, got %d", code)
+	}
+	if request("s3cret"); !called {
+		t.Errorf("Expected the admin token to be let through")
+	}
+}
+
+func TestClientIPTrustsOnlyConfiguredProxies(t *testing.T) {
+	request := func(remote, realIP, forwarded string) string {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = remote
+		if realIP != "" {
+			req.Header.Set("X-Real-IP", realIP)
+		}
+		if forwarded != "" {
+			req.Header.Set("X-Forwarded-For", forwarded)
+		}
+		return ClientIP(req)
+	}
+
+	// By default only nginx on localhost is trusted
+	t.Setenv("TRUSTED_PROXIES", "")
+	if ip := request("[::1]:5000", "203.0.113.7", ""); ip != "203.0.113.7" {
+		t.Errorf("Expected X-Real-IP from localhost, got %s", ip)
+	}
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.1")
+	if ip := request("127.0.0.1:5000", "203.0.113.7", ""); ip != "127.0.0.1" {
+		t.Errorf("Expected headers from a peer left out of TRUSTED_PROXIES to be ignored, got %s", ip)
+	}
+
+	t.Setenv("TRUSTED_PROXIES", "127.0.0.1, 10.0.0.0/8")
+	if ip := request("198.51.100.2:5000", "203.0.113.7", "203.0.113.8"); ip != "198.51.100.2" {
+		t.Errorf("Expected headers from an untrusted peer to be ignored, got %s", ip)
+	}
+	if ip := request("127.0.0.1:5000", "203.0.113.7", ""); ip != "203.0.113.7" {
+		t.Errorf("Expected X-Real-IP from nginx, got %s", ip)
+	}
+	// A spoofed first hop is skipped in favour of the address our proxies saw
+	if ip := request("127.0.0.1:5000", "", "1.2.3.4, 203.0.113.9, 10.0.0.5"); ip != "203.0.113.9" {
+		t.Errorf("Expected the last untrusted hop, got %s", ip)
+	}
+}
@@ -0,0 +1,219 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"backend/common/config"
+)
+
+type contextKey struct{}
+
+// maxBoundBody caps how much of a JSON body Require will buffer. Profile
+// updates carry base64 images, hence the generous limit.
+const maxBoundBody = 32 << 20
+
+// Require rejects requests without a valid access token. For authenticated
+// requests it stores the user id in the request context and overwrites any
+// "user_id" sent by the client (query string or JSON body) with the
+// authenticated one, so existing handlers keep working but can no longer act
+// on behalf of another user.
+func (m *Manager) Require(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := BearerToken(r)
+		if token == "" {
+			writeUnauthorized(w, "Authorization token is required")
+			return
+		}
+
+		claims, err := m.Authenticate(token)
+		if err != nil {
+			if err != ErrInvalidToken && err != ErrExpiredToken && err != ErrRevoked {
+				log.Printf("Error authenticating request: %v", err)
+			}
+			writeUnauthorized(w, "Invalid or expired token")
+			return
+		}
+
+		bound, err := bindUser(r, claims)
+		if err != nil {
+			log.Printf("Error binding authenticated user to request: %v", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success": false,
+				"message": "Invalid request body",
+			})
+			return
+		}
+
+		next(w, bound)
+	}
+}
+
+// UserID returns the authenticated user id stored by Require, or "" when the
+// request did not go through it.
+func UserID(r *http.Request) string {
+	if claims, ok := r.Context().Value(contextKey{}).(*Claims); ok {
+		return claims.Subject
+	}
+	return ""
+}
+
+// SessionID returns the session of the authenticated request, or "".
+func SessionID(r *http.Request) string {
+	if claims, ok := r.Context().Value(contextKey{}).(*Claims); ok {
+		return claims.SessionID
+	}
+	return ""
+}
+
+// WithUserID returns a copy of r authenticated as userID. It is meant for
+// tests that call handlers directly.
+func WithUserID(r *http.Request, userID string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), contextKey{}, &Claims{Subject: userID}))
+}
+
+// BearerToken extracts the token from an "Authorization: Bearer ..." header.
+func BearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return ""
+}
+
+// ClientIP returns the caller address. X-Real-IP and X-Forwarded-For are
+// only honoured when the request comes from one of TRUSTED_PROXIES (IPs or
+// CIDRs, comma separated; by default our nginx on localhost), since anyone
+// else can set them to dodge per-IP limits.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	proxies := trustedProxies()
+	if !isTrusted(host, proxies) {
+		return host
+	}
+
+	if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); ip != "" {
+		return ip
+	}
+	// Proxies append to X-Forwarded-For, so the caller is the last address
+	// not added by one of ours
+	hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		if !isTrusted(hop, proxies) || i == 0 {
+			return hop
+		}
+	}
+	return host
+}
+
+// trustedProxies parses TRUSTED_PROXIES, skipping invalid entries.
+func trustedProxies() []*net.IPNet {
+	var proxies []*net.IPNet
+	for _, entry := range strings.Split(config.String("TRUSTED_PROXIES", "127.0.0.1,::1"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil {
+				entry = ip.String() + "/128"
+				if ip.To4() != nil {
+					entry = ip.String() + "/32"
+				}
+			}
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			log.Printf("Ignoring invalid TRUSTED_PROXIES entry %q", entry)
+			continue
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies
+}
+
+func isTrusted(addr string, proxies []*net.IPNet) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, network := range proxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func bindUser(r *http.Request, claims *Claims) (*http.Request, error) {
+	r = r.WithContext(context.WithValue(r.Context(), contextKey{}, claims))
+
+	query := r.URL.Query()
+	if query.Has("user_id") || r.Method == http.MethodGet {
+		query.Set("user_id", claims.Subject)
+		r.URL.RawQuery = query.Encode()
+	}
+
+	if r.Body == nil || r.Method == http.MethodGet ||
+		strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+		return r, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBoundBody))
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(trimmed, &fields); err != nil {
+			return nil, err
+		}
+
+		// Keep the JSON type the service expects: a few services model the
+		// user id as a number, most as a string.
+		existing := bytes.TrimSpace(fields["user_id"])
+		if len(existing) > 0 && existing[0] != '"' && existing[0] != 'n' {
+			fields["user_id"] = json.RawMessage(claims.Subject)
+		} else {
+			fields["user_id"] = json.RawMessage(strconv.Quote(claims.Subject))
+		}
+
+		if body, err = json.Marshal(fields); err != nil {
+			return nil, err
+		}
+	}
+
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	return r, nil
+}
+
+func writeUnauthorized(w http.ResponseWriter, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("WWW-Authenticate", `Bearer realm="hero-budget"`)
+	w.WriteHeader(http.StatusUnauthorized)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": false,
+		"message": message,
+	})
+}
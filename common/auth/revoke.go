@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// RevokeSession ends a single session.
+func (m *Manager) RevokeSession(sessionID string) error {
+	_, err := m.db.Exec(`
+		UPDATE auth_sessions SET revoked_at = ?
+		WHERE id = ? AND revoked_at IS NULL`,
+		m.now().Unix(), sessionID,
+	)
+	if err != nil {
+		return fmt.Errorf("error revoking session: %v", err)
+	}
+	return nil
+}
+
+// RevokeRefreshToken ends the session that owns refreshToken, if any.
+func (m *Manager) RevokeRefreshToken(refreshToken string) error {
+	_, err := m.db.Exec(`
+		UPDATE auth_sessions SET revoked_at = ?
+		WHERE refresh_token_hash = ? AND revoked_at IS NULL`,
+		m.now().Unix(), HashToken(refreshToken),
+	)
+	if err != nil {
+		return fmt.Errorf("error revoking session: %v", err)
+	}
+	return nil
+}
+
+// RevokeAllSessions ends every active session of userID.
+func (m *Manager) RevokeAllSessions(userID int) error {
+	return RevokeAllSessions(m.db, userID)
+}
+
+// RevokeAllSessions ends every active session of userID. It only needs the
+// database handle so services that never issue tokens (reset_password,
+// profile_management) can still sign a user out everywhere.
+func RevokeAllSessions(db *sql.DB, userID int) error {
+	_, err := db.Exec(`
+		UPDATE auth_sessions SET revoked_at = ?
+		WHERE user_id = ? AND revoked_at IS NULL`,
+		time.Now().Unix(), userID,
+	)
+	if err != nil {
+		return fmt.Errorf("error revoking sessions: %v", err)
+	}
+	return nil
+}
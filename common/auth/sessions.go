@@ -0,0 +1,207 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"backend/common/config"
+)
+
+// Manager issues, refreshes and revokes sessions stored in auth_sessions.
+type Manager struct {
+	db         *sql.DB
+	secret     []byte
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+	now        func() time.Time
+}
+
+// TokenPair is returned to clients after any successful sign-in or refresh.
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+// NewManager creates a session manager and makes sure its table exists.
+func NewManager(db *sql.DB, secret string, accessTTL, refreshTTL time.Duration) (*Manager, error) {
+	if len(secret) < 32 {
+		return nil, errors.New("auth secret must be at least 32 characters")
+	}
+
+	m := &Manager{
+		db:         db,
+		secret:     []byte(secret),
+		accessTTL:  accessTTL,
+		refreshTTL: refreshTTL,
+		now:        time.Now,
+	}
+	if err := m.createTables(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// NewManagerFromEnv reads AUTH_TOKEN_SECRET, AUTH_ACCESS_TTL and
+// AUTH_REFRESH_TTL from the environment (or ../.env).
+func NewManagerFromEnv(db *sql.DB) (*Manager, error) {
+	secret := config.String("AUTH_TOKEN_SECRET", "")
+	if secret == "" {
+		return nil, errors.New("AUTH_TOKEN_SECRET environment variable is required")
+	}
+
+	return NewManager(
+		db,
+		secret,
+		config.Duration("AUTH_ACCESS_TTL", 15*time.Minute),
+		config.Duration("AUTH_REFRESH_TTL", 30*24*time.Hour),
+	)
+}
+
+func (m *Manager) createTables() error {
+	_, err := m.db.Exec(`
+		CREATE TABLE IF NOT EXISTS auth_sessions (
+			id TEXT PRIMARY KEY,
+			user_id INTEGER NOT NULL,
+			refresh_token_hash TEXT NOT NULL UNIQUE,
+			user_agent TEXT,
+			ip_address TEXT,
+			created_at INTEGER NOT NULL,
+			last_used_at INTEGER NOT NULL,
+			expires_at INTEGER NOT NULL,
+			revoked_at INTEGER
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating auth_sessions table: %v", err)
+	}
+
+	_, err = m.db.Exec(`CREATE INDEX IF NOT EXISTS idx_auth_sessions_user ON auth_sessions(user_id)`)
+	if err != nil {
+		return fmt.Errorf("error creating index on auth_sessions: %v", err)
+	}
+	return nil
+}
+
+// IssueTokens opens a new session for userID and returns its first token pair.
+func (m *Manager) IssueTokens(userID int, userAgent, ipAddress string) (*TokenPair, error) {
+	sessionID, err := RandomToken(16)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, err := RandomToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	now := m.now()
+	_, err = m.db.Exec(`
+		INSERT INTO auth_sessions (
+			id, user_id, refresh_token_hash, user_agent, ip_address,
+			created_at, last_used_at, expires_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		sessionID, userID, HashToken(refreshToken), userAgent, ipAddress,
+		now.Unix(), now.Unix(), now.Add(m.refreshTTL).Unix(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error creating session: %v", err)
+	}
+
+	return m.tokenPair(userID, sessionID, refreshToken, now)
+}
+
+// Refresh rotates the refresh token of an active session and issues a new
+// access token. The presented refresh token stops working immediately.
+func (m *Manager) Refresh(refreshToken string) (*TokenPair, error) {
+	var sessionID string
+	var userID int
+	var expiresAt int64
+	var revokedAt sql.NullInt64
+	err := m.db.QueryRow(`
+		SELECT id, user_id, expires_at, revoked_at
+		FROM auth_sessions WHERE refresh_token_hash = ?`,
+		HashToken(refreshToken),
+	).Scan(&sessionID, &userID, &expiresAt, &revokedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidToken
+	} else if err != nil {
+		return nil, fmt.Errorf("error fetching session: %v", err)
+	}
+
+	now := m.now()
+	if revokedAt.Valid {
+		return nil, ErrRevoked
+	}
+	if now.Unix() >= expiresAt {
+		return nil, ErrExpiredToken
+	}
+
+	newRefreshToken, err := RandomToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	// The old hash is part of the WHERE clause so two concurrent refreshes
+	// with the same token cannot both succeed.
+	result, err := m.db.Exec(`
+		UPDATE auth_sessions
+		SET refresh_token_hash = ?, last_used_at = ?, expires_at = ?
+		WHERE id = ? AND refresh_token_hash = ? AND revoked_at IS NULL`,
+		HashToken(newRefreshToken), now.Unix(), now.Add(m.refreshTTL).Unix(),
+		sessionID, HashToken(refreshToken),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error rotating refresh token: %v", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return nil, ErrInvalidToken
+	}
+
+	return m.tokenPair(userID, sessionID, newRefreshToken, now)
+}
+
+func (m *Manager) tokenPair(userID int, sessionID, refreshToken string, now time.Time) (*TokenPair, error) {
+	accessToken, err := signToken(m.secret, Claims{
+		Subject:   strconv.Itoa(userID),
+		SessionID: sessionID,
+		Type:      accessTokenType,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(m.accessTTL).Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(m.accessTTL.Seconds()),
+	}, nil
+}
+
+// Authenticate validates an access token and checks that its session is
+// still active, so logouts take effect before the token expires.
+func (m *Manager) Authenticate(accessToken string) (*Claims, error) {
+	claims, err := parseToken(m.secret, accessToken, m.now())
+	if err != nil {
+		return nil, err
+	}
+
+	var revokedAt sql.NullInt64
+	err = m.db.QueryRow(`SELECT revoked_at FROM auth_sessions WHERE id = ?`, claims.SessionID).Scan(&revokedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidToken
+	} else if err != nil {
+		return nil, fmt.Errorf("error fetching session: %v", err)
+	}
+	if revokedAt.Valid {
+		return nil, ErrRevoked
+	}
+
+	return claims, nil
+}
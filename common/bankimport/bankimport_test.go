@@ -0,0 +1,190 @@
+package bankimport
+
+import (
+	"database/sql"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"backend/common/account"
+	"backend/common/currency"
+	"backend/common/dbtest"
+	"backend/common/journal"
+	"backend/common/ledger"
+	"backend/common/money"
+)
+
+func TestParseFormats(t *testing.T) {
+	want := []Transaction{
+		{Date: "2025-01-03", Amount: -1250, Description: "Mercadona", Category: ""},
+		{Date: "2025-01-05", Amount: 150000, Description: "Nomina ACME"},
+	}
+
+	csvFile := "Fecha;Concepto;Cargo;Abono\n03/01/2025;Mercadona;12,50;\n05/01/2025;Nomina ACME;;1.500,00\n"
+	got, err := ParseCSV(strings.NewReader(csvFile), Mapping{Date: "fecha", Description: "Concepto", Debit: "Cargo",
+		Credit: "Abono", DateFormat: "02/01/2006", Delimiter: ";", DecimalComma: true})
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseCSV = %+v, %v", got, err)
+	}
+
+	ofxFile := `OFXHEADER:100
+<OFX><BANKMSGSRSV1><STMTTRNRS><STMTRS><BANKTRANLIST>
+<STMTTRN><TRNTYPE>DEBIT<DTPOSTED>20250103120000[-5:EST]<TRNAMT>-12.50<NAME>Mercadona</STMTTRN>
+<STMTTRN><TRNTYPE>CREDIT<DTPOSTED>20250105<TRNAMT>1500.00<NAME>Nomina<MEMO>ACME</STMTTRN>
+</BANKTRANLIST></STMTRS></STMTTRNRS></BANKMSGSRSV1></OFX>`
+	if got, err := ParseOFX(strings.NewReader(ofxFile)); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseOFX = %+v, %v", got, err)
+	}
+
+	qifFile := "!Type:Bank\nD01/03'25\nT-12.50\nPMercadona\n^\nD1/5/2025\nT1,500.00\nPNomina\nMACME\nLSalary\n^\n"
+	want[1].Category = "Salary"
+	if got, err := ParseQIF(strings.NewReader(qifFile), ""); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseQIF = %+v, %v", got, err)
+	}
+
+	if _, err := ParseCSV(strings.NewReader("date,amount\n2025-13-01,5\n"), Mapping{Date: "date", Amount: "amount"}); !errors.Is(err, ErrInvalidFile) {
+		t.Errorf("ParseCSV with a bad date = %v, want ErrInvalidFile", err)
+	}
+}
+
+func newImporter(t *testing.T) *Importer {
+	t.Helper()
+
+	db := dbtest.Open(t)
+	for _, statement := range []string{
+		`CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT)`,
+		`CREATE TABLE incomes (id INTEGER PRIMARY KEY AUTOINCREMENT, user_id TEXT, amount INTEGER, date TEXT, category TEXT, payment_method TEXT, description TEXT)`,
+		`CREATE TABLE expenses (id INTEGER PRIMARY KEY AUTOINCREMENT, user_id TEXT, amount INTEGER, date TEXT, category TEXT, payment_method TEXT, description TEXT)`,
+		`CREATE TABLE categories (id INTEGER PRIMARY KEY AUTOINCREMENT, user_id TEXT, name TEXT, type TEXT, emoji TEXT)`,
+		`INSERT INTO users (id, email) VALUES (1, 'u1@example.com')`,
+		`INSERT INTO categories (user_id, name, type, emoji) VALUES ('1', 'Supermercado', 'expense', ''), ('1', 'Nómina', 'income', '')`,
+		`INSERT INTO expenses (user_id, amount, date, category, payment_method, description) VALUES ('1', 1250, '2025-01-03', 'Supermercado', 'bank', 'Mercadona')`,
+	} {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	im := &Importer{DB: db}
+	var err error
+	if im.Currencies, err = currency.NewStore(db, "EUR"); err != nil {
+		t.Fatal(err)
+	}
+	if im.Balances, err = ledger.New(db); err != nil {
+		t.Fatal(err)
+	}
+	if im.Accounts, err = account.NewStore(db, im.Balances); err != nil {
+		t.Fatal(err)
+	}
+	if im.Journal, err = journal.New(db); err != nil {
+		t.Fatal(err)
+	}
+	return im
+}
+
+func TestPreviewFindsDuplicatesAndCategories(t *testing.T) {
+	im := newImporter(t)
+
+	lines, err := im.Preview("1", []Transaction{
+		{Date: "2025-01-03", Amount: -1250, Description: "MERCADONA "},
+		{Date: "2025-01-03", Amount: -1250, Description: "Mercadona"},
+		{Date: "2025-01-04", Amount: -3000, Description: "Gasolinera Repsol"},
+		{Date: "2025-01-05", Amount: 150000, Description: "Nomina ACME", Category: "nómina"},
+		{Date: "2025-01-06", Amount: -999, Description: "Compra supermercado Dia"},
+		{Date: "2025-01-07", Amount: 0, Description: "Nothing"},
+	}, Options{Rules: []Rule{{Match: "repsol", Category: "Transporte"}}})
+	if err != nil {
+		t.Fatalf("Preview: %v", err)
+	}
+
+	var duplicates, categories []string
+	for _, line := range lines {
+		categories = append(categories, line.Category)
+		if line.Duplicate {
+			duplicates = append(duplicates, line.Description)
+		}
+	}
+	// The expense already stored matches one of the two lines like it
+	if !reflect.DeepEqual(duplicates, []string{"MERCADONA "}) {
+		t.Errorf("Duplicates = %q", duplicates)
+	}
+	if want := []string{"Supermercado", "Supermercado", "Transporte", "Nómina", "Supermercado"}; !reflect.DeepEqual(categories, want) {
+		t.Errorf("Categories = %q, want %q", categories, want)
+	}
+	if lines[3].Kind != Income || lines[2].Kind != Expense || lines[2].Amount != 3000 {
+		t.Errorf("Lines = %+v", lines)
+	}
+}
+
+func TestCommitPostsTheBatchOnce(t *testing.T) {
+	im := newImporter(t)
+	var updates []money.Money
+	im.UpdateBalance = func(tx *sql.Tx, userID string, amount money.Money, method string) error {
+		updates = append(updates, amount)
+		return nil
+	}
+
+	lines := []Line{
+		{Kind: Expense, Date: "2025-01-04", Amount: 3000, Description: "Repsol", Category: "Transporte"},
+		{Kind: Income, Date: "2025-01-05", Amount: 150000, Description: "Nomina", Category: "Nómina"},
+		{Kind: Expense, Date: "2025-02-01", Amount: 999, Description: "Dia", Category: "Supermercado"},
+	}
+	rows, duplicates, err := im.Commit("1", "1", lines, Options{})
+	if err != nil || duplicates != 0 {
+		t.Fatalf("Commit = %d duplicates, %v", duplicates, err)
+	}
+	if len(rows) != 3 || rows[1].PaymentMethod != ledger.Bank || rows[1].Currency != "EUR" {
+		t.Errorf("Rows = %+v", rows)
+	}
+	if !reflect.DeepEqual(updates, []money.Money{146001}) {
+		t.Errorf("UpdateBalance calls = %v, want one of the net amount", updates)
+	}
+
+	var income, expense, total money.Money
+	im.DB.QueryRow(`SELECT income_bank_amount, expense_bank_amount, total_balance FROM monthly_cash_bank_balance
+		WHERE user_id = '1' AND year_month = '2025-01'`).Scan(&income, &expense, &total)
+	if income != 150000 || expense != 3000 || total != 147000 {
+		t.Errorf("January = incomes %v, expenses %v, total %v", income, expense, total)
+	}
+	if records, _ := im.Journal.Records("1", "", 0); len(records) != 3 {
+		t.Errorf("Journal has %d records, want 3", len(records))
+	}
+
+	bad := append(lines, Line{Kind: Expense, Date: "2025-02-30", Amount: 5, Category: "X"})
+	if _, _, err := im.Commit("1", "1", bad, Options{}); !errors.Is(err, ErrInvalidLine) {
+		t.Errorf("Commit with a bad date = %v, want ErrInvalidLine", err)
+	}
+	var count int
+	im.DB.QueryRow(`SELECT COUNT(*) FROM expenses`).Scan(&count)
+	if count != 3 {
+		t.Errorf("A failed import left %d expenses, want 3", count)
+	}
+}
+
+func TestCommitSkipsDuplicatesOfConfirmedLines(t *testing.T) {
+	im := newImporter(t)
+
+	// The preview said nothing was a duplicate, but Mercadona is stored
+	// already and so is Repsol by the time the lines are confirmed
+	im.DB.Exec(`INSERT INTO expenses (user_id, amount, date, category, payment_method, description) VALUES ('1', 3000, '2025-01-04', 'Transporte', 'bank', 'Repsol')`)
+	lines := []Line{
+		{Kind: Expense, Date: "2025-01-03", Amount: 1250, Description: "Mercadona", Category: "Supermercado"},
+		{Kind: Expense, Date: "2025-01-04", Amount: 3000, Description: "REPSOL", Category: "Transporte"},
+		{Kind: Expense, Date: "2025-01-04", Amount: 3000, Description: "Repsol", Category: "Transporte"},
+	}
+	rows, duplicates, err := im.Commit("1", "1", lines, Options{})
+	if err != nil || duplicates != 2 || len(rows) != 1 || rows[0].Description != "Repsol" {
+		t.Fatalf("Commit = %+v, %d duplicates, %v", rows, duplicates, err)
+	}
+
+	rows, duplicates, err = im.Commit("1", "1", lines[:1], Options{IncludeDuplicates: true})
+	if err != nil || duplicates != 1 || len(rows) != 1 {
+		t.Errorf("Commit including duplicates = %+v, %d duplicates, %v", rows, duplicates, err)
+	}
+	var count int
+	im.DB.QueryRow(`SELECT COUNT(*) FROM expenses`).Scan(&count)
+	if count != 4 {
+		t.Errorf("%d expenses, want 4", count)
+	}
+}
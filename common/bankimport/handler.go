@@ -0,0 +1,102 @@
+package bankimport
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"backend/common/account"
+	"backend/common/auth"
+	"backend/common/currency"
+	"backend/common/journal"
+	"backend/common/reply"
+)
+
+// Request is the body of the import endpoint. Content is the exported
+// file in Format; Lines, when set, are the lines of a preview the user
+// confirmed and are imported instead.
+type Request struct {
+	Format  string  `json:"format"`
+	Content string  `json:"content"`
+	Mapping Mapping `json:"mapping"`
+	Options
+	Lines []Line `json:"lines"`
+	// DryRun only previews.
+	DryRun bool `json:"dry_run"`
+}
+
+// Result is what an import reports.
+type Result struct {
+	Lines      []Line `json:"lines,omitempty"`
+	Imported   []Row  `json:"imported,omitempty"`
+	Duplicates int    `json:"duplicates"`
+}
+
+// Handler serves the import endpoint for the authenticated user: a dry
+// run (dry_run in the body or the query) returns the preview, otherwise
+// the lines are imported. Wrap it in auth.Require.
+func (im *Importer) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			reply.JSON(w, http.StatusMethodNotAllowed, false, "Method not allowed", nil)
+			return
+		}
+		userID := auth.UserID(r)
+		if userID == "" {
+			reply.JSON(w, http.StatusBadRequest, false, "User ID is required", nil)
+			return
+		}
+
+		var req Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			reply.JSON(w, http.StatusBadRequest, false, "Invalid request body", nil)
+			return
+		}
+		req.DryRun = req.DryRun || r.URL.Query().Get("dry_run") == "true"
+
+		lines := req.Lines
+		if len(lines) == 0 {
+			transactions, err := Parse(req.Format, strings.NewReader(req.Content), req.Mapping)
+			if err != nil {
+				reply.JSON(w, http.StatusBadRequest, false, err.Error(), nil)
+				return
+			}
+			if lines, err = im.Preview(userID, transactions, req.Options); err != nil {
+				log.Printf("Error previewing import: %v", err)
+				reply.JSON(w, http.StatusInternalServerError, false, "Error previewing import", nil)
+				return
+			}
+		}
+
+		var result Result
+		if req.DryRun {
+			for _, line := range lines {
+				if line.Duplicate {
+					result.Duplicates++
+				}
+			}
+			result.Lines = lines
+			reply.JSON(w, http.StatusOK, true, "Dry run, nothing was imported", result)
+			return
+		}
+
+		// Commit looks for duplicates again, whatever the confirmed lines
+		// say, so a stale preview cannot store the same row twice
+		var err error
+		result.Imported, result.Duplicates, err = im.Commit(userID, journal.Actor(r), lines, req.Options)
+		switch {
+		case errors.Is(err, ErrInvalidLine), errors.Is(err, account.ErrInvalid), errors.Is(err, account.ErrNotFound),
+			errors.Is(err, account.ErrOverLimit), errors.Is(err, currency.ErrInvalidCode), errors.Is(err, currency.ErrNoRate):
+			reply.JSON(w, http.StatusBadRequest, false, err.Error(), nil)
+		case err != nil:
+			log.Printf("Error importing transactions: %v", err)
+			reply.JSON(w, http.StatusInternalServerError, false, "Error importing transactions", nil)
+		case len(result.Imported) == 0:
+			reply.JSON(w, http.StatusOK, true, "Nothing to import", result)
+		default:
+			reply.JSON(w, http.StatusOK, true, "Transactions imported", result)
+		}
+	}
+}
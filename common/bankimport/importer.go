@@ -0,0 +1,377 @@
+package bankimport
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"backend/common/account"
+	"backend/common/currency"
+	"backend/common/journal"
+	"backend/common/ledger"
+	"backend/common/money"
+)
+
+// Kinds of line.
+const (
+	Income  = "income"
+	Expense = "expense"
+)
+
+// DefaultCategory is the category of lines nothing else matched.
+const DefaultCategory = "Other"
+
+// ErrInvalidLine is returned for lines that cannot be imported.
+var ErrInvalidLine = errors.New("invalid import line")
+
+// Rule maps every line whose description contains Match, without case,
+// to Category.
+type Rule struct {
+	Match    string `json:"match"`
+	Category string `json:"category"`
+}
+
+// Options are the choices a user makes for one import.
+type Options struct {
+	// AccountID is the account the lines go through; the user's bank
+	// account when zero.
+	AccountID int64 `json:"account_id"`
+	// Currency is the currency of the file; the user's base when empty.
+	Currency string `json:"currency"`
+	// Rules are tried in order before any other way of picking a category.
+	Rules []Rule `json:"rules"`
+	// IncludeDuplicates imports the lines that already are in the
+	// database too; Commit skips them otherwise.
+	IncludeDuplicates bool `json:"include_duplicates"`
+}
+
+// Line is a transaction ready to import: an income or an expense of a
+// positive amount, with its category.
+type Line struct {
+	Kind        string      `json:"kind"`
+	Date        string      `json:"date"`
+	Amount      money.Money `json:"amount"`
+	Description string      `json:"description"`
+	Category    string      `json:"category"`
+	// Duplicate is set in previews for lines that already are in the
+	// database with the same kind, date, amount and description.
+	Duplicate bool `json:"duplicate"`
+}
+
+// Row is an imported income or expense as stored.
+type Row struct {
+	ID            int64       `json:"id"`
+	UserID        string      `json:"user_id"`
+	Amount        money.Money `json:"amount"`
+	Date          string      `json:"date"`
+	Category      string      `json:"category"`
+	PaymentMethod string      `json:"payment_method"`
+	AccountID     int64       `json:"account_id"`
+	Description   string      `json:"description,omitempty"`
+	Currency      string      `json:"currency"`
+	BaseAmount    money.Money `json:"base_amount"`
+}
+
+// Importer previews and commits imports into the incomes and expenses
+// tables of one database.
+type Importer struct {
+	DB         *sql.DB
+	Balances   *ledger.Ledger
+	Accounts   *account.Store
+	Currencies *currency.Store
+	Journal    *journal.Journal
+	// UpdateBalance, if set, is called once with the net base amount
+	// imported and the account's payment method, inside the import's
+	// transaction, for the running balance a service keeps besides the
+	// ledger.
+	UpdateBalance func(tx *sql.Tx, userID string, amount money.Money, paymentMethod string) error
+}
+
+// Preview turns transactions into lines: each gets its kind, a category
+// and whether it is a duplicate. Nothing is written.
+func (im *Importer) Preview(userID string, transactions []Transaction, opts Options) ([]Line, error) {
+	categories, err := im.categories(userID)
+	if err != nil {
+		return nil, err
+	}
+	past, err := im.pastCategories(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := make([]Line, 0, len(transactions))
+	for _, t := range transactions {
+		if t.Amount == 0 {
+			continue
+		}
+		line := Line{Kind: Income, Date: t.Date, Amount: t.Amount.Abs(), Description: t.Description}
+		if t.Amount < 0 {
+			line.Kind = Expense
+		}
+		line.Category = categorize(line, t.Category, opts.Rules, past, categories)
+		lines = append(lines, line)
+	}
+
+	if _, err := markDuplicates(im.DB, userID, lines); err != nil {
+		return nil, err
+	}
+	return lines, nil
+}
+
+// queryRower is a *sql.DB or a *sql.Tx.
+type queryRower interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// markDuplicates sets Duplicate on the lines already in the database and
+// returns how many there are. A line is a duplicate while the database has
+// more rows like it than earlier lines took.
+func markDuplicates(q queryRower, userID string, lines []Line) (int, error) {
+	existing := map[string]int{}
+	for _, line := range lines {
+		key := duplicateKey(line)
+		if _, ok := existing[key]; ok {
+			continue
+		}
+		var count int
+		err := q.QueryRow(fmt.Sprintf(`
+			SELECT COUNT(*) FROM %s
+			WHERE user_id = ? AND date = ? AND amount = ? AND LOWER(TRIM(COALESCE(description, ''))) = ?`, table(line.Kind)),
+			userID, line.Date, line.Amount, normalize(line.Description)).Scan(&count)
+		if err != nil {
+			return 0, fmt.Errorf("error looking for duplicates: %v", err)
+		}
+		existing[key] = count
+	}
+	duplicates := 0
+	for i := range lines {
+		key := duplicateKey(lines[i])
+		lines[i].Duplicate = existing[key] > 0
+		if lines[i].Duplicate {
+			existing[key]--
+			duplicates++
+		}
+	}
+	return duplicates, nil
+}
+
+// Commit stores lines as incomes and expenses of userID on behalf of
+// actor, in one transaction: the rows, a journal record for each, and one
+// ledger post of all of them, so every period table is updated once.
+// Duplicates are looked for again inside the transaction, whatever the
+// lines say, and skipped unless opts.IncludeDuplicates. It returns the
+// rows imported and how many lines were duplicates.
+func (im *Importer) Commit(userID, actor string, lines []Line, opts Options) ([]Row, int, error) {
+	// Conversions read the user's base currency outside the transaction
+	lines = append([]Line(nil), lines...)
+	rows := make([]Row, len(lines))
+	for i, line := range lines {
+		if err := validate(line); err != nil {
+			return nil, 0, fmt.Errorf("%w: line %d: %v", ErrInvalidLine, i+1, err)
+		}
+		code, base, err := im.Currencies.ToBase(userID, line.Amount, opts.Currency, line.Date)
+		if err != nil {
+			return nil, 0, err
+		}
+		rows[i] = Row{UserID: userID, Amount: line.Amount, Date: line.Date, Category: line.Category,
+			Description: line.Description, Currency: code, BaseAmount: base}
+	}
+
+	tx, err := im.DB.Begin()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tx.Rollback()
+
+	duplicates, err := markDuplicates(tx, userID, lines)
+	if err != nil {
+		return nil, 0, err
+	}
+	if !opts.IncludeDuplicates && duplicates > 0 {
+		kept, keptRows := lines[:0], rows[:0]
+		for i, line := range lines {
+			if !line.Duplicate {
+				kept, keptRows = append(kept, line), append(keptRows, rows[i])
+			}
+		}
+		lines, rows = kept, keptRows
+	}
+	if len(rows) == 0 {
+		return nil, duplicates, nil
+	}
+
+	a, err := im.Accounts.ResolveTx(tx, userID, opts.AccountID, ledger.Bank)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	entries := make([]ledger.Entry, len(rows))
+	net := money.Money(0)
+	for i := range rows {
+		row := &rows[i]
+		row.AccountID, row.PaymentMethod = a.ID, a.Method()
+		result, err := tx.Exec(fmt.Sprintf(`
+			INSERT INTO %s (user_id, amount, date, category, payment_method, account_id, description, currency, base_amount)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`, table(lines[i].Kind)),
+			row.UserID, row.Amount, row.Date, row.Category, row.PaymentMethod, row.AccountID, row.Description,
+			row.Currency, row.BaseAmount)
+		if err != nil {
+			return nil, 0, fmt.Errorf("error importing line %d: %v", i+1, err)
+		}
+		if row.ID, err = result.LastInsertId(); err != nil {
+			return nil, 0, err
+		}
+
+		kind, sign := ledger.Income, money.Money(1)
+		if lines[i].Kind == Expense {
+			kind, sign = ledger.Expense, -1
+		}
+		entries[i] = a.Entry(kind, row.BaseAmount, row.Date)
+		net += sign * row.BaseAmount
+
+		err = im.Journal.RecordTx(tx, userID, journal.Change{Actor: actor, Entity: lines[i].Kind, EntityID: row.ID,
+			After: *row, Posted: []ledger.Entry{entries[i]}})
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	if err := im.Balances.PostTx(tx, entries...); err != nil {
+		return nil, 0, fmt.Errorf("error posting import to period balances: %v", err)
+	}
+	if im.UpdateBalance != nil && net != 0 {
+		if err := im.UpdateBalance(tx, userID, net, a.Method()); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	// A credit card cannot be charged beyond its limit on any imported day
+	checked := map[string]bool{}
+	for _, row := range rows {
+		if a.Type == account.CreditCard && !checked[row.Date] {
+			checked[row.Date] = true
+			if err := im.Accounts.CheckLimitTx(tx, a, row.Date); err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+
+	return rows, duplicates, tx.Commit()
+}
+
+// categories returns the names of the user's categories of each kind, if
+// categories_management keeps them in this database.
+func (im *Importer) categories(userID string) (map[string][]string, error) {
+	names := map[string][]string{}
+	var exists int
+	im.DB.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'categories'`).Scan(&exists)
+	if exists == 0 {
+		return names, nil
+	}
+
+	rows, err := im.DB.Query(`SELECT name, type FROM categories WHERE user_id = ? ORDER BY id`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error reading categories: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, kind string
+		if err := rows.Scan(&name, &kind); err != nil {
+			return nil, fmt.Errorf("error reading categories: %v", err)
+		}
+		kind = strings.ToLower(kind)
+		names[kind] = append(names[kind], name)
+	}
+	return names, rows.Err()
+}
+
+// pastCategories returns the category each description last had, per
+// kind, so an import files transactions where the user filed them before.
+func (im *Importer) pastCategories(userID string) (map[string]string, error) {
+	past := map[string]string{}
+	for _, kind := range []string{Income, Expense} {
+		rows, err := im.DB.Query(fmt.Sprintf(`
+			SELECT LOWER(TRIM(description)), category FROM %s
+			WHERE user_id = ? AND TRIM(COALESCE(description, '')) != '' AND COALESCE(category, '') != ''
+			ORDER BY date, id`, table(kind)), userID)
+		if err != nil {
+			return nil, fmt.Errorf("error reading past categories: %v", err)
+		}
+		for rows.Next() {
+			var description, category string
+			if err := rows.Scan(&description, &category); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("error reading past categories: %v", err)
+			}
+			past[kind+"|"+description] = category
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return past, nil
+}
+
+// categorize picks the category of line: the first rule that matches, the
+// category the file gave it, the one the same description had before, a
+// category of the user named in the description, or DefaultCategory.
+// Names the user already has keep their spelling.
+func categorize(line Line, fromFile string, rules []Rule, past map[string]string, categories map[string][]string) string {
+	description := normalize(line.Description)
+	for _, rule := range rules {
+		if rule.Match != "" && rule.Category != "" && strings.Contains(description, normalize(rule.Match)) {
+			return rule.Category
+		}
+	}
+	if fromFile != "" {
+		for _, name := range categories[line.Kind] {
+			if normalize(name) == normalize(fromFile) {
+				return name
+			}
+		}
+		return fromFile
+	}
+	if category, ok := past[line.Kind+"|"+description]; ok {
+		return category
+	}
+	for _, name := range categories[line.Kind] {
+		if n := normalize(name); n != "" && strings.Contains(description, n) {
+			return name
+		}
+	}
+	return DefaultCategory
+}
+
+func validate(line Line) error {
+	if line.Kind != Income && line.Kind != Expense {
+		return fmt.Errorf("kind must be income or expense")
+	}
+	if line.Amount <= 0 {
+		return fmt.Errorf("amount must be greater than 0")
+	}
+	if _, err := time.Parse("2006-01-02", line.Date); err != nil {
+		return fmt.Errorf("date must be YYYY-MM-DD")
+	}
+	if line.Category == "" {
+		return fmt.Errorf("category is required")
+	}
+	return nil
+}
+
+func table(kind string) string {
+	if kind == Income {
+		return "incomes"
+	}
+	return "expenses"
+}
+
+func duplicateKey(line Line) string {
+	return fmt.Sprintf("%s|%s|%d|%s", line.Kind, line.Date, line.Amount, normalize(line.Description))
+}
+
+func normalize(s string) string {
+	return strings.ToLower(strings.TrimSpace(s))
+}
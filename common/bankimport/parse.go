@@ -0,0 +1,304 @@
+// Package bankimport reads the CSV, OFX and QIF files banks export and
+// turns them into incomes and expenses: a preview marks the lines that
+// are already in the database and picks their categories, and Commit
+// inserts the confirmed lines and posts them to the ledger in one batch.
+package bankimport
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/common/money"
+)
+
+// Formats.
+const (
+	CSV = "csv"
+	OFX = "ofx"
+	QIF = "qif"
+)
+
+// ErrInvalidFile is returned for files that cannot be read in their
+// format, with the line or transaction at fault.
+var ErrInvalidFile = errors.New("invalid import file")
+
+// Transaction is one movement read from a bank export. A positive amount
+// is an income and a negative one an expense.
+type Transaction struct {
+	Date        string      `json:"date"` // YYYY-MM-DD
+	Amount      money.Money `json:"amount"`
+	Description string      `json:"description"`
+	Category    string      `json:"category,omitempty"`
+}
+
+// Mapping says where each field is in a CSV file. Columns are header
+// names, matched without case, or 1-based column numbers. Banks that
+// split amounts use Debit and Credit instead of Amount.
+type Mapping struct {
+	Date        string `json:"date"`
+	Amount      string `json:"amount"`
+	Debit       string `json:"debit"`
+	Credit      string `json:"credit"`
+	Description string `json:"description"`
+	Category    string `json:"category"`
+	// DateFormat is a Go layout, 2006-01-02 by default. It also applies
+	// to QIF dates.
+	DateFormat string `json:"date_format"`
+	// Delimiter is the field separator, a comma by default.
+	Delimiter string `json:"delimiter"`
+	// DecimalComma reads 1.234,56 instead of 1,234.56.
+	DecimalComma bool `json:"decimal_comma"`
+	// NoHeader is set for files whose first line is already a
+	// transaction; columns are then numbers.
+	NoHeader bool `json:"no_header"`
+}
+
+// Parse reads r in format.
+func Parse(format string, r io.Reader, m Mapping) ([]Transaction, error) {
+	switch strings.ToLower(format) {
+	case CSV:
+		return ParseCSV(r, m)
+	case OFX:
+		return ParseOFX(r)
+	case QIF:
+		return ParseQIF(r, m.DateFormat)
+	default:
+		return nil, fmt.Errorf("%w: unknown format %q", ErrInvalidFile, format)
+	}
+}
+
+// ParseCSV reads a CSV export laid out as m says.
+func ParseCSV(r io.Reader, m Mapping) ([]Transaction, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	if m.Delimiter != "" {
+		reader.Comma = []rune(m.Delimiter)[0]
+	}
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidFile, err)
+	}
+
+	var header []string
+	if !m.NoHeader && len(records) > 0 {
+		header, records = records[0], records[1:]
+	}
+	column := func(name string) (int, error) {
+		if name == "" {
+			return -1, nil
+		}
+		if n, err := strconv.Atoi(name); err == nil && n > 0 {
+			return n - 1, nil
+		}
+		for i, h := range header {
+			if strings.EqualFold(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")), name) {
+				return i, nil
+			}
+		}
+		return -1, fmt.Errorf("%w: no column %q", ErrInvalidFile, name)
+	}
+
+	var date, amount, debit, credit, description, category int
+	for _, c := range []struct {
+		index *int
+		name  string
+	}{{&date, m.Date}, {&amount, m.Amount}, {&debit, m.Debit}, {&credit, m.Credit},
+		{&description, m.Description}, {&category, m.Category}} {
+		if *c.index, err = column(c.name); err != nil {
+			return nil, err
+		}
+	}
+	if date < 0 || (amount < 0 && debit < 0 && credit < 0) {
+		return nil, fmt.Errorf("%w: the mapping needs a date and an amount, or debit and credit, column", ErrInvalidFile)
+	}
+
+	layout := m.DateFormat
+	if layout == "" {
+		layout = "2006-01-02"
+	}
+	var transactions []Transaction
+	for i, record := range records {
+		field := func(index int) string {
+			if index < 0 || index >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[index])
+		}
+		if strings.Join(record, "") == "" {
+			continue
+		}
+		line := i + 1
+		if !m.NoHeader {
+			line++
+		}
+
+		t := Transaction{Description: field(description), Category: field(category)}
+		parsed, err := time.Parse(layout, field(date))
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: date %q does not match %s", ErrInvalidFile, line, field(date), layout)
+		}
+		t.Date = parsed.Format("2006-01-02")
+
+		if amount >= 0 {
+			t.Amount, err = parseAmount(field(amount), m.DecimalComma)
+		} else {
+			var in, out money.Money
+			if in, err = parseAmount(field(credit), m.DecimalComma); err == nil {
+				out, err = parseAmount(field(debit), m.DecimalComma)
+			}
+			t.Amount = in - out.Abs()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: %v", ErrInvalidFile, line, err)
+		}
+		transactions = append(transactions, t)
+	}
+	return transactions, nil
+}
+
+var (
+	ofxTransaction = regexp.MustCompile(`(?is)<STMTTRN>(.*?)</STMTTRN>`)
+	ofxTag         = regexp.MustCompile(`<([A-Za-z0-9.]+)>([^<\r\n]*)`)
+)
+
+// ParseOFX reads the STMTTRN blocks of an OFX file, SGML (1.x) or XML
+// (2.x).
+func ParseOFX(r io.Reader) ([]Transaction, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var transactions []Transaction
+	for i, block := range ofxTransaction.FindAllStringSubmatch(string(data), -1) {
+		fields := map[string]string{}
+		for _, match := range ofxTag.FindAllStringSubmatch(block[1], -1) {
+			fields[strings.ToUpper(match[1])] = strings.TrimSpace(match[2])
+		}
+
+		posted := fields["DTPOSTED"]
+		if len(posted) < 8 {
+			return nil, fmt.Errorf("%w: transaction %d has no DTPOSTED", ErrInvalidFile, i+1)
+		}
+		date, err := time.Parse("20060102", posted[:8])
+		if err != nil {
+			return nil, fmt.Errorf("%w: transaction %d: date %q", ErrInvalidFile, i+1, posted)
+		}
+		amount, err := parseAmount(fields["TRNAMT"], false)
+		if err != nil {
+			return nil, fmt.Errorf("%w: transaction %d: %v", ErrInvalidFile, i+1, err)
+		}
+
+		description := fields["NAME"]
+		if memo := fields["MEMO"]; memo != "" && memo != description {
+			description = strings.TrimSpace(description + " " + memo)
+		}
+		transactions = append(transactions, Transaction{Date: date.Format("2006-01-02"), Amount: amount, Description: description})
+	}
+	return transactions, nil
+}
+
+// qifDateLayouts are the date forms QIF exports use, US first. The
+// apostrophe some write before two-digit years is read as a slash.
+var qifDateLayouts = []string{"01/02/2006", "1/2/2006", "01/02/06", "1/2/06", "2006-01-02"}
+
+// ParseQIF reads the transactions of a QIF file. Dates follow layout if
+// given.
+func ParseQIF(r io.Reader, layout string) ([]Transaction, error) {
+	layouts := qifDateLayouts
+	if layout != "" {
+		layouts = []string{layout}
+	}
+
+	var transactions []Transaction
+	var t Transaction
+	var payee, memo string
+	var hasDate, hasAmount bool
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "!") {
+			continue
+		}
+		value := strings.TrimSpace(text[1:])
+		switch text[0] {
+		case 'D':
+			value = strings.ReplaceAll(strings.ReplaceAll(value, "'", "/"), " ", "")
+			var err error
+			for _, l := range layouts {
+				var date time.Time
+				if date, err = time.Parse(l, value); err == nil {
+					t.Date, hasDate = date.Format("2006-01-02"), true
+					break
+				}
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%w: line %d: date %q", ErrInvalidFile, line, value)
+			}
+		case 'T', 'U':
+			amount, err := parseAmount(value, false)
+			if err != nil {
+				return nil, fmt.Errorf("%w: line %d: %v", ErrInvalidFile, line, err)
+			}
+			t.Amount, hasAmount = amount, true
+		case 'P':
+			payee = value
+		case 'M':
+			memo = value
+		case 'L':
+			t.Category = strings.Trim(value, "[]")
+		case '^':
+			if !hasDate || !hasAmount {
+				return nil, fmt.Errorf("%w: line %d: transaction without date or amount", ErrInvalidFile, line)
+			}
+			t.Description = payee
+			if memo != "" && memo != payee {
+				t.Description = strings.TrimSpace(payee + " " + memo)
+			}
+			transactions = append(transactions, t)
+			t, payee, memo, hasDate, hasAmount = Transaction{}, "", "", false, false
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return transactions, nil
+}
+
+// parseAmount reads a bank amount: signs, parentheses for negatives,
+// thousands separators and currency symbols are allowed.
+func parseAmount(s string, decimalComma bool) (money.Money, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	negative := strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")")
+	s = strings.Map(func(r rune) rune {
+		if (r >= '0' && r <= '9') || r == '-' || r == '+' || r == '.' || r == ',' {
+			return r
+		}
+		return -1
+	}, s)
+	if decimalComma {
+		s = strings.ReplaceAll(s, ".", "")
+		s = strings.ReplaceAll(s, ",", ".")
+	} else {
+		s = strings.ReplaceAll(s, ",", "")
+	}
+	amount, err := money.Parse(s)
+	if err != nil {
+		return 0, err
+	}
+	if negative {
+		amount = -amount.Abs()
+	}
+	return amount, nil
+}
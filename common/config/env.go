@@ -0,0 +1,83 @@
+// Package config loads the settings shared by every Hero Budget service.
+//
+// Services are started from their own directory (see restart_services_vps.sh),
+// so the backend-wide .env file lives one level up. Values already present in
+// the process environment always win over the file.
+package config
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var loadOnce sync.Once
+
+// Load reads ../.env once and exports any key that is not already set.
+// A missing file is not an error: the process environment is used as-is.
+func Load() {
+	loadOnce.Do(func() {
+		cwd, err := os.Getwd()
+		if err != nil {
+			return
+		}
+		loadFile(filepath.Join(cwd, "..", ".env"))
+	})
+}
+
+func loadFile(path string) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			continue
+		}
+		key = strings.TrimSpace(strings.TrimPrefix(key, "export "))
+		value = strings.Trim(strings.TrimSpace(value), `"'`)
+		if _, exists := os.LookupEnv(key); !exists {
+			os.Setenv(key, value)
+		}
+	}
+}
+
+// String returns the value of key or defaultValue when it is unset or empty.
+func String(key, defaultValue string) string {
+	Load()
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// Int returns key parsed as an integer or defaultValue when it is unset or invalid.
+func Int(key string, defaultValue int) int {
+	value, err := strconv.Atoi(String(key, ""))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+// Duration returns key parsed with time.ParseDuration ("15m", "720h", ...)
+// or defaultValue when it is unset or invalid.
+func Duration(key string, defaultValue time.Duration) time.Duration {
+	value, err := time.ParseDuration(String(key, ""))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
@@ -0,0 +1,241 @@
+// Package currency lets users record money in several currencies. Every
+// user has a base currency (users.base_currency, DEFAULT_CURRENCY until
+// they choose one). Incomes, expenses, bills and savings keep the amount
+// in the currency it was spent in next to base_amount, the same amount in
+// the base currency at the rate of its date; balances, the ledger and the
+// budget overview only ever add up base amounts.
+//
+// Rates live in exchange_rates, loaded from EXCHANGE_RATES_FILE at start
+// and through the admin endpoint (see Handler).
+package currency
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"backend/common/config"
+	"backend/common/money"
+)
+
+var (
+	ErrInvalidCode = errors.New("invalid currency code")
+	ErrInvalidRate = errors.New("invalid exchange rate")
+	ErrNoRate      = errors.New("no exchange rate")
+	// ErrBaseInUse is returned when changing the base currency of a user
+	// who already recorded money: their base amounts would no longer add up.
+	ErrBaseInUse = errors.New("base currency cannot change once transactions exist")
+)
+
+// Tables are the transaction tables with currency and base_amount columns.
+var Tables = []string{"incomes", "expenses", "bills", "savings"}
+
+// Normalize returns code as an upper case ISO 4217 code.
+func Normalize(code string) (string, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if len(code) != 3 {
+		return "", fmt.Errorf("%w: %q", ErrInvalidCode, code)
+	}
+	for _, c := range code {
+		if c < 'A' || c > 'Z' {
+			return "", fmt.Errorf("%w: %q", ErrInvalidCode, code)
+		}
+	}
+	return code, nil
+}
+
+// Store reads base currencies and exchange rates.
+type Store struct {
+	db *sql.DB
+	// Default is the base currency of users who never chose one and the
+	// currency of every amount recorded before multi-currency existed.
+	Default string
+}
+
+// NewStore creates exchange_rates and adds the currency columns to users
+// and to the transaction tables that exist.
+func NewStore(db *sql.DB, defaultCurrency string) (*Store, error) {
+	code, err := Normalize(defaultCurrency)
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{db: db, Default: code}
+	if err := s.createTables(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// NewStoreFromEnv creates a Store for DEFAULT_CURRENCY (EUR) and loads the
+// rates in EXCHANGE_RATES_FILE, if set.
+func NewStoreFromEnv(db *sql.DB) (*Store, error) {
+	s, err := NewStore(db, config.String("DEFAULT_CURRENCY", "EUR"))
+	if err != nil {
+		return nil, err
+	}
+	if path := config.String("EXCHANGE_RATES_FILE", ""); path != "" {
+		count, err := s.LoadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Loaded %d exchange rates from %s", count, path)
+	}
+	return s, nil
+}
+
+func (s *Store) createTables() error {
+	_, err := s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS exchange_rates (
+			from_currency TEXT NOT NULL,
+			to_currency TEXT NOT NULL,
+			date TEXT NOT NULL,
+			rate REAL NOT NULL,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (from_currency, to_currency, date)
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating exchange_rates table: %v", err)
+	}
+
+	if err := addColumn(s.db, "users", "base_currency", "TEXT"); err != nil {
+		return err
+	}
+	for _, table := range Tables {
+		if err := AddColumns(s.db, table, s.Default); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddColumns adds currency and base_amount to table, if it exists and
+// lacks them. Rows written before multi-currency are in defaultCurrency,
+// which was everybody's base, so base_amount is their amount. Savings
+// have no single amount and get no base_amount.
+func AddColumns(db *sql.DB, table, defaultCurrency string) error {
+	columns, err := tableColumns(db, table)
+	if err != nil || len(columns) == 0 {
+		return err
+	}
+
+	if !columns["currency"] {
+		if err := addColumn(db, table, "currency", "TEXT"); err != nil {
+			return err
+		}
+		if _, err := db.Exec(fmt.Sprintf("UPDATE %s SET currency = ? WHERE currency IS NULL", table), defaultCurrency); err != nil {
+			return fmt.Errorf("error backfilling %s.currency: %v", table, err)
+		}
+	}
+	if columns["amount"] && !columns["base_amount"] {
+		if err := addColumn(db, table, "base_amount", "INTEGER"); err != nil {
+			return err
+		}
+		if _, err := db.Exec(fmt.Sprintf("UPDATE %s SET base_amount = amount WHERE base_amount IS NULL", table)); err != nil {
+			return fmt.Errorf("error backfilling %s.base_amount: %v", table, err)
+		}
+	}
+	return nil
+}
+
+func addColumn(db *sql.DB, table, column, definition string) error {
+	columns, err := tableColumns(db, table)
+	if err != nil || len(columns) == 0 || columns[column] {
+		return err
+	}
+	if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)); err != nil {
+		return fmt.Errorf("error adding %s to %s: %v", column, table, err)
+	}
+	return nil
+}
+
+// tableColumns returns the columns of table, none if it does not exist.
+func tableColumns(db *sql.DB, table string) (map[string]bool, error) {
+	rows, err := db.Query(`SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return nil, fmt.Errorf("error reading columns of %s: %v", table, err)
+	}
+	defer rows.Close()
+
+	columns := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("error reading columns of %s: %v", table, err)
+		}
+		columns[name] = true
+	}
+	return columns, rows.Err()
+}
+
+// Base returns the base currency of userID.
+func (s *Store) Base(userID string) (string, error) {
+	var code sql.NullString
+	err := s.db.QueryRow(`SELECT base_currency FROM users WHERE CAST(id AS TEXT) = ?`, userID).Scan(&code)
+	if err != nil && err != sql.ErrNoRows {
+		return "", fmt.Errorf("error reading base currency: %v", err)
+	}
+	if !code.Valid || code.String == "" {
+		return s.Default, nil
+	}
+	return code.String, nil
+}
+
+// SetBase changes the base currency of userID. It fails with ErrBaseInUse
+// once the user has recorded any money in another base.
+func (s *Store) SetBase(userID, code string) error {
+	code, err := Normalize(code)
+	if err != nil {
+		return err
+	}
+	current, err := s.Base(userID)
+	if err != nil || current == code {
+		return err
+	}
+
+	for _, table := range Tables {
+		columns, err := tableColumns(s.db, table)
+		if err != nil {
+			return err
+		}
+		if len(columns) == 0 {
+			continue
+		}
+		var count int
+		if err := s.db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE user_id = ?", table), userID).Scan(&count); err != nil {
+			return fmt.Errorf("error counting %s: %v", table, err)
+		}
+		if count > 0 {
+			return ErrBaseInUse
+		}
+	}
+
+	if _, err := s.db.Exec(`UPDATE users SET base_currency = ? WHERE CAST(id AS TEXT) = ?`, code, userID); err != nil {
+		return fmt.Errorf("error updating base currency: %v", err)
+	}
+	return nil
+}
+
+// ToBase converts an amount that userID recorded in code (their base when
+// empty) on date to their base currency. It returns the normalized code
+// and the base amount.
+func (s *Store) ToBase(userID string, amount money.Money, code, date string) (string, money.Money, error) {
+	base, err := s.Base(userID)
+	if err != nil {
+		return "", 0, err
+	}
+	if code == "" {
+		return base, amount, nil
+	}
+	code, err = Normalize(code)
+	if err != nil {
+		return "", 0, err
+	}
+	converted, err := s.Convert(amount, code, base, date)
+	if err != nil {
+		return "", 0, err
+	}
+	return code, converted, nil
+}
@@ -0,0 +1,243 @@
+package currency
+
+import (
+	"database/sql"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"backend/common/dbtest"
+	"backend/common/money"
+)
+
+func newTestStore(t *testing.T, db *sql.DB) *Store {
+	t.Helper()
+
+	s, err := NewStore(db, "EUR")
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	return s
+}
+
+func TestNormalize(t *testing.T) {
+	for _, tc := range []struct{ in, out string }{
+		{"usd", "USD"},
+		{" Eur ", "EUR"},
+	} {
+		got, err := Normalize(tc.in)
+		if err != nil || got != tc.out {
+			t.Errorf("Normalize(%q) = %q, %v; want %q", tc.in, got, err, tc.out)
+		}
+	}
+	for _, in := range []string{"", "US", "EURO", "U$D", "12A"} {
+		if _, err := Normalize(in); !errors.Is(err, ErrInvalidCode) {
+			t.Errorf("Normalize(%q) error = %v, want ErrInvalidCode", in, err)
+		}
+	}
+}
+
+func TestRate(t *testing.T) {
+	s := newTestStore(t, dbtest.Open(t))
+	err := s.SetRates(
+		Rate{From: "USD", To: "EUR", Date: "2024-01-01", Rate: 0.9},
+		Rate{From: "USD", To: "EUR", Date: "2024-03-01", Rate: 0.8},
+		Rate{From: "EUR", To: "GBP", Date: "2024-01-01", Rate: 0.5},
+	)
+	if err != nil {
+		t.Fatalf("SetRates: %v", err)
+	}
+
+	for _, tc := range []struct {
+		from, to, date string
+		want           float64
+	}{
+		{"EUR", "EUR", "2024-02-01", 1},
+		{"USD", "EUR", "2024-02-15", 0.9},
+		{"USD", "EUR", "2024-03-01", 0.8},
+		{"USD", "EUR", "2025-01-01", 0.8},
+		// Before the first rate the earliest one is used
+		{"USD", "EUR", "2023-06-01", 0.9},
+		// Inverted pair
+		{"EUR", "USD", "2024-03-10", 1.25},
+		// Crossed through EUR
+		{"USD", "GBP", "2024-02-01", 0.45},
+	} {
+		got, err := s.Rate(tc.from, tc.to, tc.date)
+		if err != nil || math.Abs(got-tc.want) > 1e-9 {
+			t.Errorf("Rate(%s, %s, %s) = %v, %v; want %v", tc.from, tc.to, tc.date, got, err, tc.want)
+		}
+	}
+
+	if _, err := s.Rate("USD", "JPY", "2024-02-01"); !errors.Is(err, ErrNoRate) {
+		t.Errorf("Rate(USD, JPY) error = %v, want ErrNoRate", err)
+	}
+
+	got, err := s.Convert(money.Money(1000), "USD", "EUR", "2024-02-01")
+	if err != nil || got != 900 {
+		t.Errorf("Convert = %v, %v; want 9.00", got, err)
+	}
+}
+
+func TestSetRatesIsAllOrNothing(t *testing.T) {
+	s := newTestStore(t, dbtest.Open(t))
+	err := s.SetRates(
+		Rate{From: "USD", To: "EUR", Date: "2024-01-01", Rate: 0.9},
+		Rate{From: "USD", To: "EUR", Date: "2024-02-30", Rate: 0.8},
+	)
+	if !errors.Is(err, ErrInvalidRate) {
+		t.Fatalf("SetRates error = %v, want ErrInvalidRate", err)
+	}
+	rates, err := s.Rates()
+	if err != nil || len(rates) != 0 {
+		t.Errorf("Rates = %v, %v; want none", rates, err)
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	s := newTestStore(t, dbtest.Open(t))
+	path := filepath.Join(t.TempDir(), "rates.csv")
+	content := "from,to,date,rate\n# ECB reference rates\nusd,eur,2024-01-01,0.9\nGBP,EUR,2024-01-01, 1.15\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	count, err := s.LoadFile(path)
+	if err != nil || count != 2 {
+		t.Fatalf("LoadFile = %d, %v; want 2", count, err)
+	}
+	rate, err := s.Rate("GBP", "EUR", "2024-05-01")
+	if err != nil || rate != 1.15 {
+		t.Errorf("Rate(GBP, EUR) = %v, %v; want 1.15", rate, err)
+	}
+
+	if _, err := ParseCSV(strings.NewReader("USD,EUR,2024-01-01,abc\n")); err == nil || !strings.Contains(err.Error(), "row 1") {
+		t.Errorf("ParseCSV error = %v, want a row 1 error", err)
+	}
+}
+
+func TestAddColumnsBackfillsExistingRows(t *testing.T) {
+	db := dbtest.Open(t)
+	_, err := db.Exec(`CREATE TABLE expenses (id INTEGER PRIMARY KEY, user_id TEXT, amount INTEGER);
+		INSERT INTO expenses (user_id, amount) VALUES ('1', 1250);
+		CREATE TABLE savings (id INTEGER PRIMARY KEY, user_id TEXT, goal INTEGER)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newTestStore(t, db)
+
+	var code string
+	var base money.Money
+	if err := db.QueryRow(`SELECT currency, base_amount FROM expenses`).Scan(&code, &base); err != nil {
+		t.Fatalf("Failed to read expense: %v", err)
+	}
+	if code != "EUR" || base != 1250 {
+		t.Errorf("expense currency = %s, base_amount = %v; want EUR, 12.50", code, base)
+	}
+
+	columns, err := tableColumns(db, "savings")
+	if err != nil || !columns["currency"] || columns["base_amount"] {
+		t.Errorf("savings columns = %v, %v; want currency without base_amount", columns, err)
+	}
+
+	// Running it again leaves the tables alone
+	newTestStore(t, db)
+}
+
+func TestSetBase(t *testing.T) {
+	db := dbtest.Open(t)
+	_, err := db.Exec(`CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT);
+		INSERT INTO users (id, email) VALUES (1, 'a@example.com'), (2, 'b@example.com');
+		CREATE TABLE expenses (id INTEGER PRIMARY KEY, user_id TEXT, amount INTEGER);
+		INSERT INTO expenses (user_id, amount) VALUES ('2', 500)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTestStore(t, db)
+
+	if base, err := s.Base("1"); err != nil || base != "EUR" {
+		t.Fatalf("Base = %q, %v; want the default", base, err)
+	}
+	if err := s.SetBase("1", "usd"); err != nil {
+		t.Fatalf("SetBase: %v", err)
+	}
+	if base, err := s.Base("1"); err != nil || base != "USD" {
+		t.Errorf("Base = %q, %v; want USD", base, err)
+	}
+	if err := s.SetBase("1", "dollars"); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("SetBase(dollars) error = %v, want ErrInvalidCode", err)
+	}
+	if err := s.SetBase("2", "USD"); !errors.Is(err, ErrBaseInUse) {
+		t.Errorf("SetBase with expenses error = %v, want ErrBaseInUse", err)
+	}
+	// Setting the same base again is not a change
+	if err := s.SetBase("2", "EUR"); err != nil {
+		t.Errorf("SetBase(EUR) = %v", err)
+	}
+}
+
+func TestToBase(t *testing.T) {
+	db := dbtest.Open(t)
+	if _, err := db.Exec(`CREATE TABLE users (id INTEGER PRIMARY KEY, base_currency TEXT); INSERT INTO users (id) VALUES (1)`); err != nil {
+		t.Fatal(err)
+	}
+	s := newTestStore(t, db)
+	if err := s.SetRates(Rate{From: "USD", To: "EUR", Date: "2024-01-01", Rate: 0.92}); err != nil {
+		t.Fatal(err)
+	}
+
+	code, base, err := s.ToBase("1", money.Money(10000), "", "2024-02-01")
+	if err != nil || code != "EUR" || base != 10000 {
+		t.Errorf("ToBase in base = %s, %v, %v; want EUR, 100", code, base, err)
+	}
+	code, base, err = s.ToBase("1", money.Money(10000), "usd", "2024-02-01")
+	if err != nil || code != "USD" || base != 9200 {
+		t.Errorf("ToBase in USD = %s, %v, %v; want USD, 92", code, base, err)
+	}
+	if _, _, err := s.ToBase("1", money.Money(10000), "JPY", "2024-02-01"); !errors.Is(err, ErrNoRate) {
+		t.Errorf("ToBase in JPY error = %v, want ErrNoRate", err)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	s := newTestStore(t, dbtest.Open(t))
+	handler := s.Handler()
+
+	post := func(contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/admin/exchange-rates", strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	if rec := post("application/json", `[{"from":"USD","to":"EUR","date":"2024-01-01","rate":0.9}]`); rec.Code != http.StatusOK {
+		t.Errorf("POST JSON = %d %s", rec.Code, rec.Body)
+	}
+	if rec := post("text/csv", "GBP,EUR,2024-01-01,1.15\n"); rec.Code != http.StatusOK {
+		t.Errorf("POST CSV = %d %s", rec.Code, rec.Body)
+	}
+	if rec := post("application/json", `[{"from":"USD","to":"EUR","date":"2024-01-01","rate":-1}]`); rec.Code != http.StatusBadRequest {
+		t.Errorf("POST negative rate = %d, want 400", rec.Code)
+	}
+	if rec := post("application/json", `{"from":"USD"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("POST object = %d, want 400", rec.Code)
+	}
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/admin/exchange-rates", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"from":"GBP"`) || !strings.Contains(rec.Body.String(), `"from":"USD"`) {
+		t.Errorf("GET = %d %s", rec.Code, rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodDelete, "/admin/exchange-rates", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("DELETE = %d, want 405", rec.Code)
+	}
+}
@@ -0,0 +1,55 @@
+package currency
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"backend/common/reply"
+)
+
+// Handler serves the exchange rates admin endpoint. GET lists the rates;
+// POST stores a JSON array of rates or, with a text/csv body, the same
+// from,to,date,rate rows as EXCHANGE_RATES_FILE. Wrap it in
+// auth.RequireAdmin.
+func (s *Store) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			rates, err := s.Rates()
+			if err != nil {
+				log.Printf("Error listing exchange rates: %v", err)
+				reply.JSON(w, http.StatusInternalServerError, false, "Error listing exchange rates", nil)
+				return
+			}
+			reply.JSON(w, http.StatusOK, true, "", rates)
+
+		case http.MethodPost:
+			var rates []Rate
+			var err error
+			if strings.HasPrefix(r.Header.Get("Content-Type"), "text/csv") {
+				rates, err = ParseCSV(r.Body)
+			} else {
+				err = json.NewDecoder(r.Body).Decode(&rates)
+			}
+			if err != nil {
+				reply.JSON(w, http.StatusBadRequest, false, "Invalid exchange rates: "+err.Error(), nil)
+				return
+			}
+			if err := s.SetRates(rates...); errors.Is(err, ErrInvalidRate) || errors.Is(err, ErrInvalidCode) {
+				reply.JSON(w, http.StatusBadRequest, false, err.Error(), nil)
+				return
+			} else if err != nil {
+				log.Printf("Error storing exchange rates: %v", err)
+				reply.JSON(w, http.StatusInternalServerError, false, "Error storing exchange rates", nil)
+				return
+			}
+			reply.JSON(w, http.StatusOK, true, "Exchange rates updated", map[string]int{"stored": len(rates)})
+
+		default:
+			reply.JSON(w, http.StatusMethodNotAllowed, false, "Method not allowed", nil)
+		}
+	}
+}
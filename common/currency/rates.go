@@ -0,0 +1,188 @@
+package currency
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/common/money"
+)
+
+// Rate says that on Date (YYYY-MM-DD) and until the next rate, one From is
+// worth Rate To.
+type Rate struct {
+	From string  `json:"from"`
+	To   string  `json:"to"`
+	Date string  `json:"date"`
+	Rate float64 `json:"rate"`
+}
+
+func (r Rate) validate() (Rate, error) {
+	var err error
+	if r.From, err = Normalize(r.From); err != nil {
+		return r, err
+	}
+	if r.To, err = Normalize(r.To); err != nil {
+		return r, err
+	}
+	if r.From == r.To {
+		return r, fmt.Errorf("%w: %s to itself", ErrInvalidCode, r.From)
+	}
+	if _, err := time.Parse("2006-01-02", r.Date); err != nil {
+		return r, fmt.Errorf("%w: date %q", ErrInvalidRate, r.Date)
+	}
+	if r.Rate <= 0 {
+		return r, fmt.Errorf("%w: %v for %s/%s", ErrInvalidRate, r.Rate, r.From, r.To)
+	}
+	return r, nil
+}
+
+// SetRates stores rates, replacing those of the same pair and date. Either
+// all of them are stored or none.
+func (s *Store) SetRates(rates ...Rate) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	for _, r := range rates {
+		r, err := r.validate()
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`
+			INSERT INTO exchange_rates (from_currency, to_currency, date, rate) VALUES (?, ?, ?, ?)
+			ON CONFLICT(from_currency, to_currency, date) DO UPDATE SET rate = excluded.rate, updated_at = CURRENT_TIMESTAMP`,
+			r.From, r.To, r.Date, r.Rate)
+		if err != nil {
+			return fmt.Errorf("error storing exchange rate: %v", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// Rates lists the stored rates by pair and date.
+func (s *Store) Rates() ([]Rate, error) {
+	rows, err := s.db.Query(`SELECT from_currency, to_currency, date, rate FROM exchange_rates ORDER BY from_currency, to_currency, date`)
+	if err != nil {
+		return nil, fmt.Errorf("error reading exchange rates: %v", err)
+	}
+	defer rows.Close()
+
+	rates := []Rate{}
+	for rows.Next() {
+		var r Rate
+		if err := rows.Scan(&r.From, &r.To, &r.Date, &r.Rate); err != nil {
+			return nil, fmt.Errorf("error reading exchange rates: %v", err)
+		}
+		rates = append(rates, r)
+	}
+	return rates, rows.Err()
+}
+
+// Rate returns how many to one from is worth on date: the latest rate on or
+// before date, or the earliest one for dates before any rate. A pair can
+// be stored either way round, and pairs without a rate of their own are
+// crossed through the default currency.
+func (s *Store) Rate(from, to, date string) (float64, error) {
+	if from == to {
+		return 1, nil
+	}
+	if rate, ok, err := s.pairRate(from, to, date); ok || err != nil {
+		return rate, err
+	}
+	if from != s.Default && to != s.Default {
+		fromDefault, okFrom, err := s.pairRate(from, s.Default, date)
+		if err != nil {
+			return 0, err
+		}
+		defaultTo, okTo, err := s.pairRate(s.Default, to, date)
+		if err != nil {
+			return 0, err
+		}
+		if okFrom && okTo {
+			return fromDefault * defaultTo, nil
+		}
+	}
+	return 0, fmt.Errorf("%w from %s to %s on %s", ErrNoRate, from, to, date)
+}
+
+// pairRate looks the pair up as stored and inverted.
+func (s *Store) pairRate(from, to, date string) (float64, bool, error) {
+	var rate float64
+	var inverse bool
+	err := s.db.QueryRow(`
+		SELECT rate, from_currency = ? AS inverse FROM exchange_rates
+		WHERE (from_currency = ? AND to_currency = ?) OR (from_currency = ? AND to_currency = ?)
+		ORDER BY date > ?, CASE WHEN date <= ? THEN date END DESC, date
+		LIMIT 1`,
+		to, from, to, to, from, date, date).Scan(&rate, &inverse)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("error reading exchange rate: %v", err)
+	}
+	if inverse {
+		rate = 1 / rate
+	}
+	return rate, true, nil
+}
+
+// Convert converts amount from one currency to another at the rate of date.
+func (s *Store) Convert(amount money.Money, from, to, date string) (money.Money, error) {
+	rate, err := s.Rate(from, to, date)
+	if err != nil {
+		return 0, err
+	}
+	return amount.Scale(rate), nil
+}
+
+// LoadFile stores the rates of a CSV file with from,to,date,rate rows (an
+// optional header and # comments are skipped) and returns how many it read.
+func (s *Store) LoadFile(path string) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("error opening exchange rates file: %v", err)
+	}
+	defer file.Close()
+
+	rates, err := ParseCSV(file)
+	if err != nil {
+		return 0, fmt.Errorf("error reading %s: %v", path, err)
+	}
+	return len(rates), s.SetRates(rates...)
+}
+
+// ParseCSV reads from,to,date,rate rows.
+func ParseCSV(r io.Reader) ([]Rate, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = 4
+	reader.TrimLeadingSpace = true
+
+	var rates []Rate
+	for row := 1; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return rates, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if row == 1 && strings.EqualFold(record[0], "from") {
+			continue
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(record[3]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: invalid rate %q", row, record[3])
+		}
+		rates = append(rates, Rate{From: record[0], To: record[1], Date: strings.TrimSpace(record[2]), Rate: value})
+	}
+}
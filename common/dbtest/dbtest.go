@@ -0,0 +1,157 @@
+// Package dbtest helps tests prove that a write path is atomic: it opens
+// throwaway databases, injects faults into chosen tables with triggers and
+// snapshots the whole database so a failed request can be checked to have
+// left nothing behind.
+package dbtest
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Fault is the message of the errors raised by FailOn triggers.
+const Fault = "injected fault"
+
+// Open returns an empty in-memory database closed when the test ends. It
+// has a single connection, so a write path that uses the database handle
+// while its own transaction is open blocks instead of silently escaping
+// the transaction.
+func Open(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// FailOn makes every event (INSERT, UPDATE or DELETE) on table fail with
+// Fault until the returned function is called or the test ends.
+func FailOn(t *testing.T, db *sql.DB, table, event string) func() {
+	t.Helper()
+
+	trigger := fmt.Sprintf("dbtest_fail_%s_%s", strings.ToLower(event), table)
+	_, err := db.Exec(fmt.Sprintf(`CREATE TRIGGER %s BEFORE %s ON %s
+		BEGIN SELECT RAISE(ABORT, '%s'); END`, trigger, event, table, Fault))
+	if err != nil {
+		t.Fatalf("Failed to inject fault on %s %s: %v", event, table, err)
+	}
+
+	restore := func() { db.Exec("DROP TRIGGER IF EXISTS " + trigger) }
+	t.Cleanup(restore)
+	return restore
+}
+
+// Atomic runs write once per fault point, an INSERT, UPDATE or DELETE on
+// one of tables made to fail, each time against the fresh database setup
+// returns. Whenever write fails the database must be exactly as before
+// it ran. It returns the fault points that made write fail, as "EVENT
+// table", and fails the test if none did.
+func Atomic(t *testing.T, tables []string, setup func(t *testing.T) *sql.DB, write func(t *testing.T) error) []string {
+	t.Helper()
+
+	var failed []string
+	for _, table := range tables {
+		for _, event := range []string{"INSERT", "UPDATE", "DELETE"} {
+			point := event + " " + table
+			t.Run(point, func(t *testing.T) {
+				db := setup(t)
+				before := Snapshot(t, db)
+
+				restore := FailOn(t, db, table, event)
+				err := write(t)
+				restore()
+				if err == nil {
+					return
+				}
+				failed = append(failed, point)
+
+				if after := Snapshot(t, db); !reflect.DeepEqual(before, after) {
+					for name := range after {
+						if !reflect.DeepEqual(before[name], after[name]) {
+							t.Errorf("Write failed with %v but left changes in %s", err, name)
+						}
+					}
+				}
+			})
+		}
+	}
+	if len(failed) == 0 {
+		t.Errorf("No injected fault made the write fail")
+	}
+	return failed
+}
+
+// Snapshot returns every row of every table, keyed by table name, in a
+// form that can be compared with reflect.DeepEqual.
+func Snapshot(t *testing.T, db *sql.DB) map[string][]string {
+	t.Helper()
+
+	rows, err := db.Query(`SELECT name FROM sqlite_master
+		WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name`)
+	if err != nil {
+		t.Fatalf("Failed to list tables: %v", err)
+	}
+	var tables []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			t.Fatalf("Failed to list tables: %v", err)
+		}
+		tables = append(tables, name)
+	}
+	rows.Close()
+
+	snapshot := map[string][]string{}
+	for _, table := range tables {
+		snapshot[table] = tableRows(t, db, table)
+	}
+	return snapshot
+}
+
+func tableRows(t *testing.T, db *sql.DB, table string) []string {
+	t.Helper()
+
+	rows, err := db.Query("SELECT * FROM " + table)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", table, err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		t.Fatalf("Failed to read columns of %s: %v", table, err)
+	}
+	var result []string
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			t.Fatalf("Failed to read %s: %v", table, err)
+		}
+		fields := make([]string, len(columns))
+		for i, v := range values {
+			if b, ok := v.([]byte); ok {
+				v = string(b)
+			}
+			fields[i] = fmt.Sprintf("%s=%v", columns[i], v)
+		}
+		result = append(result, strings.Join(fields, " "))
+	}
+	// Row order is not part of the state
+	sort.Strings(result)
+	return result
+}
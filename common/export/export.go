@@ -0,0 +1,264 @@
+// Package export gives users a copy of their data: a ZIP bundle with a
+// CSV and a JSON file per table and a manifest that describes them.
+//
+// Bundles are built in the background (Store.Start) and kept in the
+// exports table until they are downloaded, once, with the token Start
+// returned, or until the link expires. A Restorer recreates the data of
+// a bundle for another user, on this instance or another one.
+package export
+
+import (
+	"archive/zip"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"backend/common/account"
+	"backend/common/money"
+)
+
+// SchemaVersion is the version of the bundle layout, written to the
+// manifest. It changes when tables or columns are added or renamed.
+// Version 2 added tags and transaction_tags.
+const SchemaVersion = 2
+
+// Table is a table of user data and how its rows are selected.
+type Table struct {
+	Name string
+	// Where selects the rows of one user, given as its only argument.
+	Where string
+	// Amounts are the columns that hold cents besides money.Columns and
+	// base_amount.
+	Amounts []string
+	// Links are the columns that hold the id of a row of an earlier
+	// table, keyed by column.
+	Links map[string]string
+	// KindLink is a column that holds the id of a row of the earlier
+	// table its row's kind names.
+	KindLink KindLink
+}
+
+// KindLink is a link whose table depends on another column of the row.
+type KindLink struct {
+	Column string
+	// Kind is the column that picks the table in Tables, by its value.
+	Kind   string
+	Tables map[string]string
+}
+
+// accountLinks are the links of the tables paid from an account.
+var accountLinks = map[string]string{"account_id": "accounts"}
+
+// target returns the table linked from row through the kind link of t.
+func (t Table) target(row map[string]interface{}) string {
+	kind, _ := row[t.KindLink.Kind].(string)
+	return t.KindLink.Tables[kind]
+}
+
+// Tables are the tables a bundle has, in the order they are written and
+// restored: every table comes after the tables it links to. Tables
+// missing from the database are left out.
+var Tables = []Table{
+	{Name: "accounts", Where: "user_id = ?", Amounts: []string{"opening_balance", "credit_limit"}},
+	{Name: "categories", Where: "user_id = ?"},
+	{Name: "bills", Where: "user_id = ?",
+		Links: map[string]string{"account_id": "accounts", account.StatementColumn: "accounts"}},
+	{Name: "bill_payments", Where: "bill_id IN (SELECT id FROM bills WHERE user_id = ?)",
+		Links: map[string]string{"bill_id": "bills"}},
+	{Name: "incomes", Where: "user_id = ?", Links: accountLinks},
+	{Name: "expenses", Where: "user_id = ?",
+		Links: map[string]string{"account_id": "accounts", "bill_id": "bills"}},
+	{Name: "savings", Where: "user_id = ?"},
+	{Name: "budget", Where: "user_id = ?"},
+	{Name: "cash_bank_transactions", Where: "user_id = ?",
+		Links: map[string]string{"from_account_id": "accounts", "to_account_id": "accounts"}},
+	{Name: account.TransfersTable, Where: "user_id = ?", Amounts: []string{"amount", "fee"},
+		Links: map[string]string{"from_account_id": "accounts", "to_account_id": "accounts"}},
+	{Name: "tags", Where: "user_id = ?"},
+	{Name: "transaction_tags", Where: "user_id = ?", Links: map[string]string{"tag_id": "tags"},
+		KindLink: KindLink{Column: "row_id", Kind: "kind",
+			Tables: map[string]string{"income": "incomes", "expense": "expenses", "bill": "bills"}}},
+}
+
+// Manifest is manifest.json, written after the files it describes.
+type Manifest struct {
+	SchemaVersion int       `json:"schema_version"`
+	UserID        string    `json:"user_id"`
+	CreatedAt     time.Time `json:"created_at"`
+	// Amounts says how amount columns are written: "units", e.g. 12.5.
+	Amounts string          `json:"amounts"`
+	Tables  []ManifestTable `json:"tables"`
+}
+
+// ManifestTable describes the files of one table.
+type ManifestTable struct {
+	Name    string   `json:"name"`
+	Rows    int      `json:"rows"`
+	Columns []string `json:"columns"`
+	// AmountColumns are the columns of Columns written in units.
+	AmountColumns []string `json:"amount_columns"`
+	Files         []string `json:"files"`
+}
+
+// Write writes the bundle of userID to w.
+func Write(w io.Writer, db *sql.DB, userID string) error {
+	manifest := Manifest{SchemaVersion: SchemaVersion, UserID: userID, CreatedAt: time.Now().UTC(), Amounts: "units"}
+	archive := zip.NewWriter(w)
+
+	for _, table := range Tables {
+		var exists int
+		err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, table.Name).Scan(&exists)
+		if err != nil {
+			return fmt.Errorf("error looking for %s: %v", table.Name, err)
+		}
+		if exists == 0 {
+			continue
+		}
+
+		columns, rows, err := read(db, table, userID)
+		if err != nil {
+			return err
+		}
+		described := ManifestTable{Name: table.Name, Rows: len(rows), Columns: columns, AmountColumns: []string{},
+			Files: []string{table.Name + ".csv", table.Name + ".json"}}
+		for _, column := range columns {
+			if amounts(table)[column] {
+				described.AmountColumns = append(described.AmountColumns, column)
+			}
+		}
+
+		if err := addCSV(archive, described.Files[0], columns, rows); err != nil {
+			return err
+		}
+		if err := addJSON(archive, described.Files[1], columns, rows); err != nil {
+			return err
+		}
+		manifest.Tables = append(manifest.Tables, described)
+	}
+
+	file, err := archive.Create("manifest.json")
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(manifest); err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+// read returns the columns and rows of table for userID, oldest first,
+// with amounts as money.
+func read(db *sql.DB, table Table, userID string) ([]string, [][]interface{}, error) {
+	rows, err := db.Query(fmt.Sprintf("SELECT * FROM %s WHERE %s ORDER BY rowid", table.Name, table.Where), userID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading %s: %v", table.Name, err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, nil, err
+	}
+	isAmount := amounts(table)
+	var result [][]interface{}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		for i := range values {
+			values[i] = new(interface{})
+		}
+		if err := rows.Scan(values...); err != nil {
+			return nil, nil, fmt.Errorf("error reading %s: %v", table.Name, err)
+		}
+		row := make([]interface{}, len(columns))
+		for i, column := range columns {
+			v := *values[i].(*interface{})
+			switch value := v.(type) {
+			case []byte:
+				v = string(value)
+			case int64:
+				if isAmount[column] {
+					v = money.Money(value)
+				}
+			case float64:
+				// Columns never migrated to cents hold cents as REAL
+				if isAmount[column] {
+					v = money.Money(math.Round(value))
+				}
+			case time.Time:
+				// As CURRENT_TIMESTAMP writes them, so restores store them alike
+				v = value.UTC().Format("2006-01-02 15:04:05")
+			}
+			row[i] = v
+		}
+		result = append(result, row)
+	}
+	return columns, result, rows.Err()
+}
+
+func amounts(table Table) map[string]bool {
+	columns := map[string]bool{"base_amount": true}
+	for _, column := range money.Columns[table.Name] {
+		columns[column] = true
+	}
+	for _, column := range table.Amounts {
+		columns[column] = true
+	}
+	return columns
+}
+
+func addCSV(archive *zip.Writer, name string, columns []string, rows [][]interface{}) error {
+	file, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	writer := csv.NewWriter(file)
+	if err := writer.Write(columns); err != nil {
+		return err
+	}
+	record := make([]string, len(columns))
+	for _, row := range rows {
+		for i, v := range row {
+			switch v := v.(type) {
+			case nil:
+				record[i] = ""
+			case bool:
+				// Booleans are written like SQLite stores them
+				record[i] = "0"
+				if v {
+					record[i] = "1"
+				}
+			default:
+				record[i] = fmt.Sprint(v)
+			}
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func addJSON(archive *zip.Writer, name string, columns []string, rows [][]interface{}) error {
+	file, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	objects := make([]map[string]interface{}, 0, len(rows))
+	for _, row := range rows {
+		object := make(map[string]interface{}, len(columns))
+		for i, column := range columns {
+			object[column] = row[i]
+		}
+		objects = append(objects, object)
+	}
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(objects)
+}
@@ -0,0 +1,350 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"backend/common/account"
+	"backend/common/dbtest"
+	"backend/common/ledger"
+	"backend/common/reconcile"
+)
+
+func TestWriteBundlesOnlyTheUsersData(t *testing.T) {
+	db := dbtest.Open(t)
+	for _, statement := range []string{
+		`CREATE TABLE incomes (id INTEGER PRIMARY KEY AUTOINCREMENT, user_id TEXT, amount INTEGER, date TEXT, category TEXT, description TEXT)`,
+		`CREATE TABLE bills (id INTEGER PRIMARY KEY AUTOINCREMENT, user_id TEXT, name TEXT, amount INTEGER, paid BOOLEAN)`,
+		`CREATE TABLE bill_payments (id INTEGER PRIMARY KEY AUTOINCREMENT, bill_id INTEGER, year_month TEXT, paid BOOLEAN)`,
+		`INSERT INTO incomes (user_id, amount, date, category, description) VALUES
+			('1', 150050, '2025-01-05', 'Salary', 'ACME, "January"'), ('2', 999, '2025-01-06', 'Other', NULL)`,
+		`INSERT INTO bills (user_id, name, amount, paid) VALUES ('1', 'Rent', 50000, 1), ('2', 'Gym', 3000, 0)`,
+		`INSERT INTO bill_payments (bill_id, year_month, paid) VALUES (1, '2025-01', 1), (2, '2025-01', 0)`,
+	} {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var bundle bytes.Buffer
+	if err := Write(&bundle, db, "1"); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	files := unzip(t, bundle.Bytes())
+
+	var manifest Manifest
+	if err := json.Unmarshal(files["manifest.json"], &manifest); err != nil {
+		t.Fatalf("manifest.json: %v", err)
+	}
+	if manifest.SchemaVersion != SchemaVersion || manifest.UserID != "1" || len(manifest.Tables) != 3 {
+		t.Fatalf("Manifest = %+v", manifest)
+	}
+	for _, table := range manifest.Tables {
+		if table.Rows != 1 {
+			t.Errorf("%s has %d rows, want 1", table.Name, table.Rows)
+		}
+		for _, file := range table.Files {
+			if _, ok := files[file]; !ok {
+				t.Errorf("Missing %s", file)
+			}
+		}
+	}
+	if got := manifest.Tables[0].AmountColumns; !reflect.DeepEqual(got, []string{"amount"}) {
+		t.Errorf("Income amount columns = %q", got)
+	}
+
+	records, err := csv.NewReader(bytes.NewReader(files["incomes.csv"])).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"id", "user_id", "amount", "date", "category", "description"},
+		{"1", "1", "1500.50", "2025-01-05", "Salary", `ACME, "January"`},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("incomes.csv = %q, want %q", records, want)
+	}
+
+	var bills []map[string]interface{}
+	if err := json.Unmarshal(files["bills.json"], &bills); err != nil {
+		t.Fatal(err)
+	}
+	if len(bills) != 1 || bills[0]["amount"] != 500.0 || bills[0]["name"] != "Rent" || bills[0]["paid"] != true {
+		t.Errorf("bills.json = %v", bills)
+	}
+	var payments []map[string]interface{}
+	json.Unmarshal(files["bill_payments.json"], &payments)
+	if len(payments) != 1 || payments[0]["bill_id"] != 1.0 {
+		t.Errorf("bill_payments.json = %v", payments)
+	}
+}
+
+func TestDownloadLinksWorkOnce(t *testing.T) {
+	db := dbtest.Open(t)
+	db.Exec(`CREATE TABLE incomes (id INTEGER PRIMARY KEY AUTOINCREMENT, user_id TEXT, amount INTEGER)`)
+	s, err := New(db, time.Hour)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	job, token, err := s.Start("1")
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	s.building.Wait()
+
+	if _, err := s.Status("2", job.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Another user's status = %v, want ErrNotFound", err)
+	}
+	status, err := s.Status("1", job.ID)
+	if err != nil || status.Status != Ready || status.Size == 0 {
+		t.Fatalf("Status = %+v, %v", status, err)
+	}
+
+	userID, bundle, err := s.Download(token)
+	if err != nil || userID != "1" {
+		t.Fatalf("Download = %q, %v", userID, err)
+	}
+	if _, ok := unzip(t, bundle)["incomes.csv"]; !ok {
+		t.Errorf("Downloaded bundle has no incomes.csv")
+	}
+	if _, _, err := s.Download(token); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Second download = %v, want ErrNotFound", err)
+	}
+
+	// Links stop working after their lifetime
+	_, token, _ = s.Start("1")
+	s.building.Wait()
+	now = now.Add(time.Hour)
+	if _, _, err := s.Download(token); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expired download = %v, want ErrNotFound", err)
+	}
+}
+
+func TestInterruptedExportsFail(t *testing.T) {
+	db := dbtest.Open(t)
+	s, err := New(db, time.Hour)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	// An export whose build stopped with the process
+	result, _ := db.Exec(`INSERT INTO exports (user_id, token_hash, status, created_at, expires_at) VALUES ('1', 'x', ?, ?, ?)`,
+		Pending, now.Unix(), now.Add(time.Hour).Unix())
+	id, _ := result.LastInsertId()
+	if _, _, err := s.Start("1"); !errors.Is(err, ErrInProgress) {
+		t.Errorf("Start while building = %v, want ErrInProgress", err)
+	}
+
+	now = now.Add(s.timeout)
+	if job, err := s.Status("1", id); err != nil || job.Status != Failed {
+		t.Errorf("Status after the build timeout = %+v, %v", job, err)
+	}
+	if _, _, err := s.Start("1"); err != nil {
+		t.Errorf("Start after the build timeout = %v", err)
+	}
+	s.building.Wait()
+}
+
+// newRestorer returns a database where u1 has accounts, an income, a bill
+// with one month paid by an expense and a transfer, with its balances.
+func newRestorer(t *testing.T) *Restorer {
+	t.Helper()
+
+	db := dbtest.Open(t)
+	_, err := db.Exec(`
+		CREATE TABLE incomes (id INTEGER PRIMARY KEY, user_id TEXT, amount INTEGER, base_amount INTEGER, date TEXT, payment_method TEXT);
+		CREATE TABLE expenses (id INTEGER PRIMARY KEY, user_id TEXT, amount INTEGER, base_amount INTEGER, date TEXT, payment_method TEXT, bill_id INTEGER);
+		CREATE TABLE bills (id INTEGER PRIMARY KEY, user_id TEXT, amount INTEGER, base_amount INTEGER, start_date TEXT,
+			duration_months INTEGER, payment_method TEXT);
+		CREATE TABLE bill_payments (id INTEGER PRIMARY KEY, bill_id INTEGER, year_month TEXT, paid BOOLEAN, payment_date TEXT);
+		CREATE TABLE transfers (id INTEGER PRIMARY KEY, user_id TEXT, from_account_id INTEGER, to_account_id INTEGER,
+			amount INTEGER, fee INTEGER, date TEXT);
+		CREATE TABLE tags (id INTEGER PRIMARY KEY, user_id TEXT, name TEXT, color TEXT);
+		CREATE TABLE transaction_tags (user_id TEXT, tag_id INTEGER, kind TEXT, row_id INTEGER, PRIMARY KEY (tag_id, kind, row_id))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs := &Restorer{DB: db}
+	if rs.Balances, err = ledger.New(db); err != nil {
+		t.Fatal(err)
+	}
+	if rs.Accounts, err = account.NewStore(db, rs.Balances); err != nil {
+		t.Fatal(err)
+	}
+	rs.Reconciler = reconcile.New(db, rs.Balances, rs.Accounts)
+
+	list, err := rs.Accounts.List("u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cash, bank := list[0], list[1]
+	_, err = db.Exec(`
+		INSERT INTO incomes (user_id, amount, base_amount, date, payment_method, account_id) VALUES ('u1', 1000, 1000, '2025-01-10', 'bank', ?);
+		INSERT INTO bills (id, user_id, amount, base_amount, start_date, duration_months, payment_method, account_id)
+			VALUES (5, 'u1', 50, 50, '2025-02-01', 2, 'bank', ?);
+		INSERT INTO bill_payments (bill_id, year_month, paid, payment_date) VALUES (5, '2025-02', 1, '2025-02-03'), (5, '2025-03', 0, NULL);
+		INSERT INTO expenses (user_id, amount, base_amount, date, payment_method, account_id, bill_id) VALUES ('u1', 50, 50, '2025-02-03', 'bank', ?, 5);
+		INSERT INTO transfers (user_id, from_account_id, to_account_id, amount, fee, date) VALUES ('u1', ?, ?, 100, 2, '2025-01-15');
+		INSERT INTO tags (id, user_id, name) VALUES (3, 'u1', 'Viaje');
+		INSERT INTO transaction_tags (user_id, tag_id, kind, row_id) VALUES ('u1', 3, 'income', 1), ('u1', 3, 'expense', 1)`,
+		bank.ID, bank.ID, bank.ID, bank.ID, cash.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rs.Reconciler.User("u1", false); err != nil {
+		t.Fatal(err)
+	}
+	return rs
+}
+
+func TestRestoreRecreatesTheData(t *testing.T) {
+	rs := newRestorer(t)
+	var bundle bytes.Buffer
+	if err := Write(&bundle, rs.DB, "u1"); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	// u2 got its default accounts on the first request
+	if _, err := rs.Accounts.List("u2"); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := rs.Restore("u2", bundle.Bytes(), false)
+	if err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	want := map[string]int{"accounts": 2, "bills": 1, "bill_payments": 2, "incomes": 1, "expenses": 1, "transfers": 1,
+		"tags": 1, "transaction_tags": 2}
+	if !reflect.DeepEqual(restored.Rows, want) {
+		t.Errorf("Restored rows = %v, want %v", restored.Rows, want)
+	}
+
+	// Links point to u2's own rows
+	var broken int
+	rs.DB.QueryRow(`
+		SELECT (SELECT COUNT(*) FROM expenses e WHERE e.user_id = 'u2' AND e.bill_id NOT IN (SELECT id FROM bills WHERE user_id = 'u2'))
+		     + (SELECT COUNT(*) FROM bill_payments p WHERE p.bill_id NOT IN (SELECT id FROM bills))
+		     + (SELECT COUNT(*) FROM transfers f WHERE f.user_id = 'u2' AND (f.from_account_id NOT IN (SELECT id FROM accounts WHERE user_id = 'u2')
+		                                                               OR f.to_account_id NOT IN (SELECT id FROM accounts WHERE user_id = 'u2')))
+		     + (SELECT COUNT(*) FROM accounts WHERE user_id = 'u2') - 2`).Scan(&broken)
+	if broken != 0 {
+		t.Errorf("%d restored links or accounts are wrong", broken)
+	}
+	if got, want := balances(t, rs.DB, "u2"), balances(t, rs.DB, "u1"); len(want) == 0 || !reflect.DeepEqual(got, want) {
+		t.Errorf("Restored balances = %v, want %v", got, want)
+	}
+
+	if _, err := rs.Restore("u2", bundle.Bytes(), false); !errors.Is(err, ErrNotEmpty) {
+		t.Errorf("Restoring over data = %v, want ErrNotEmpty", err)
+	}
+	if _, err := rs.Restore("u2", bundle.Bytes(), true); err != nil {
+		t.Fatalf("Restore replacing: %v", err)
+	}
+	if got, want := balances(t, rs.DB, "u2"), balances(t, rs.DB, "u1"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Balances after replacing = %v, want %v", got, want)
+	}
+
+	// Tags point to u2's own tag and rows, and replacing left none behind
+	var tagged, linked int
+	rs.DB.QueryRow(`SELECT COUNT(*) FROM transaction_tags WHERE user_id = 'u2'`).Scan(&tagged)
+	rs.DB.QueryRow(`
+		SELECT COUNT(*) FROM transaction_tags tt JOIN tags t ON t.id = tt.tag_id AND t.user_id = 'u2'
+		WHERE tt.user_id = 'u2' AND (tt.kind = 'income' AND tt.row_id IN (SELECT id FROM incomes WHERE user_id = 'u2')
+		                          OR tt.kind = 'expense' AND tt.row_id IN (SELECT id FROM expenses WHERE user_id = 'u2'))`).Scan(&linked)
+	if tagged != 2 || linked != 2 {
+		t.Errorf("u2 has %d transaction tags, %d linked to its rows, want 2", tagged, linked)
+	}
+}
+
+func TestRestoreRejectsBrokenLinks(t *testing.T) {
+	rs := newRestorer(t)
+	manifest := `{"schema_version": 2, "amounts": "units", "tables": [
+		{"name": "bills", "amount_columns": ["amount"]}, {"name": "expenses", "amount_columns": ["amount"]},
+		{"name": "bill_payments"}]}`
+	bills := `[{"id": 1, "user_id": "u1", "amount": 0.5}]`
+	for name, files := range map[string]map[string]string{
+		"missing bill": {
+			"expenses.json": `[{"id": 1, "user_id": "u1", "amount": 0.5, "date": "2025-02-03", "bill_id": 9}]`,
+		},
+		"string id": {
+			"bill_payments.json": `[{"id": 1, "bill_id": "5", "year_month": "2030-01", "paid": 1}]`,
+		},
+		"float id": {
+			"expenses.json": `[{"id": 1, "user_id": "u1", "amount": 0.5, "date": "2025-02-03", "bill_id": 1.5}]`,
+		},
+	} {
+		var bundle bytes.Buffer
+		archive := zip.NewWriter(&bundle)
+		files["manifest.json"] = manifest
+		files["bills.json"] = bills
+		for _, table := range []string{"expenses.json", "bill_payments.json"} {
+			if _, ok := files[table]; !ok {
+				files[table] = `[]`
+			}
+		}
+		for file, content := range files {
+			f, _ := archive.Create(file)
+			f.Write([]byte(content))
+		}
+		archive.Close()
+
+		before := dbtest.Snapshot(t, rs.DB)
+		if _, err := rs.Restore("u2", bundle.Bytes(), false); !errors.Is(err, ErrInvalidBundle) {
+			t.Errorf("%s: Restore = %v, want ErrInvalidBundle", name, err)
+		}
+		if after := dbtest.Snapshot(t, rs.DB); !reflect.DeepEqual(after, before) {
+			t.Errorf("%s: a rejected bundle changed the database", name)
+		}
+	}
+}
+
+// balances returns the monthly cash and bank balances of userID.
+func balances(t *testing.T, db *sql.DB, userID string) [][]int64 {
+	t.Helper()
+
+	rows, err := db.Query(`
+		SELECT income_bank_amount, expense_bank_amount, balance_cash_amount, balance_bank_amount
+		FROM monthly_cash_bank_balance WHERE user_id = ? ORDER BY year_month`, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var result [][]int64
+	for rows.Next() {
+		row := make([]int64, 4)
+		rows.Scan(&row[0], &row[1], &row[2], &row[3])
+		result = append(result, row)
+	}
+	return result
+}
+
+func unzip(t *testing.T, data []byte) map[string][]byte {
+	t.Helper()
+
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("Bundle is not a ZIP file: %v", err)
+	}
+	files := map[string][]byte{}
+	for _, f := range archive.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name], _ = io.ReadAll(r)
+		r.Close()
+	}
+	return files
+}
@@ -0,0 +1,140 @@
+package export
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"backend/common/auth"
+	"backend/common/reply"
+)
+
+// Handler starts an export with POST and reports on one with GET and
+// ?id=, for the authenticated user. Wrap it in auth.Require.
+//
+// The POST answer has the job and download_url, the one-time link under
+// downloadPath that DownloadHandler serves once the status is ready.
+func (s *Store) Handler(downloadPath string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := auth.UserID(r)
+		if userID == "" {
+			reply.JSON(w, http.StatusBadRequest, false, "User ID is required", nil)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodPost:
+			job, token, err := s.Start(userID)
+			if errors.Is(err, ErrInProgress) {
+				reply.JSON(w, http.StatusConflict, false, err.Error(), nil)
+				return
+			}
+			if err != nil {
+				log.Printf("Error starting export: %v", err)
+				reply.JSON(w, http.StatusInternalServerError, false, "Error starting export", nil)
+				return
+			}
+			reply.JSON(w, http.StatusAccepted, true, "Export started", map[string]interface{}{
+				"job":          job,
+				"download_url": downloadPath + "?token=" + url.QueryEscape(token),
+			})
+
+		case http.MethodGet:
+			id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+			if err != nil {
+				reply.JSON(w, http.StatusBadRequest, false, "Export ID is required", nil)
+				return
+			}
+			job, err := s.Status(userID, id)
+			if errors.Is(err, ErrNotFound) {
+				reply.JSON(w, http.StatusNotFound, false, err.Error(), nil)
+				return
+			}
+			if err != nil {
+				log.Printf("Error reading export: %v", err)
+				reply.JSON(w, http.StatusInternalServerError, false, "Error reading export", nil)
+				return
+			}
+			reply.JSON(w, http.StatusOK, true, "", job)
+
+		default:
+			reply.JSON(w, http.StatusMethodNotAllowed, false, "Method not allowed", nil)
+		}
+	}
+}
+
+// DownloadHandler serves the bundle of ?token= as a ZIP file. The token is
+// the credential, so links open without a session, and each works once.
+func (s *Store) DownloadHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			reply.JSON(w, http.StatusMethodNotAllowed, false, "Method not allowed", nil)
+			return
+		}
+
+		userID, bundle, err := s.Download(r.URL.Query().Get("token"))
+		switch {
+		case errors.Is(err, ErrNotReady):
+			reply.JSON(w, http.StatusAccepted, false, err.Error(), nil)
+			return
+		case errors.Is(err, ErrNotFound):
+			reply.JSON(w, http.StatusNotFound, false, "Export not found, already downloaded or expired", nil)
+			return
+		case err != nil:
+			log.Printf("Error downloading export: %v", err)
+			reply.JSON(w, http.StatusInternalServerError, false, "Error downloading export", nil)
+			return
+		}
+
+		name := fmt.Sprintf("herobudget-export-%s-%s.zip", userID, time.Now().UTC().Format("20060102"))
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, name))
+		w.Header().Set("Content-Length", strconv.Itoa(len(bundle)))
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write(bundle)
+	}
+}
+
+// MaxBundleSize is the largest bundle RestoreHandler accepts.
+const MaxBundleSize = 64 << 20
+
+// RestoreHandler restores the bundle in the body of a POST, the ZIP file
+// an export downloaded, as the data of the authenticated user. With
+// ?replace=true the user's data is replaced. Wrap it in auth.Require.
+func (rs *Restorer) RestoreHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			reply.JSON(w, http.StatusMethodNotAllowed, false, "Method not allowed", nil)
+			return
+		}
+		userID := auth.UserID(r)
+		if userID == "" {
+			reply.JSON(w, http.StatusBadRequest, false, "User ID is required", nil)
+			return
+		}
+
+		bundle, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxBundleSize))
+		if err != nil {
+			reply.JSON(w, http.StatusRequestEntityTooLarge, false, "Bundle is too large", nil)
+			return
+		}
+		restored, err := rs.Restore(userID, bundle, r.URL.Query().Get("replace") == "true")
+		switch {
+		case errors.Is(err, ErrInvalidBundle):
+			reply.JSON(w, http.StatusBadRequest, false, err.Error(), nil)
+		case errors.Is(err, ErrNotEmpty):
+			reply.JSON(w, http.StatusConflict, false, err.Error()+"; restore with ?replace=true to replace it", nil)
+		case err != nil:
+			log.Printf("Error restoring data of user %s: %v", userID, err)
+			reply.JSON(w, http.StatusInternalServerError, false, "Error restoring data", nil)
+		default:
+			log.Printf("Restored data of user %s: %v", userID, restored.Rows)
+			reply.JSON(w, http.StatusOK, true, "Data restored", restored)
+		}
+	}
+}
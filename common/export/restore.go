@@ -0,0 +1,372 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"backend/common/account"
+	"backend/common/ledger"
+	"backend/common/money"
+	"backend/common/reconcile"
+)
+
+var (
+	// ErrInvalidBundle is returned for bundles that cannot be restored:
+	// not a ZIP, a missing or unknown manifest, or rows that link to rows
+	// the bundle does not have.
+	ErrInvalidBundle = errors.New("invalid export bundle")
+	// ErrNotEmpty is returned when restoring over a user who already has
+	// data without asking to replace it.
+	ErrNotEmpty = errors.New("the account already has data")
+)
+
+// Restorer recreates users' data from bundles in one database.
+type Restorer struct {
+	DB       *sql.DB
+	Balances *ledger.Ledger
+	Accounts *account.Store
+	// Reconciler rebuilds the period balances once the rows are in.
+	Reconciler *reconcile.Reconciler
+}
+
+// Restored is what a restore wrote.
+type Restored struct {
+	// Rows are the rows inserted, by table.
+	Rows map[string]int `json:"rows"`
+	// Skipped are the tables of the bundle this database does not have.
+	Skipped []string `json:"skipped,omitempty"`
+}
+
+// errUnlinked is returned by insert for rows linked, through a kind link,
+// to a table this database or the bundle does not have. They are skipped.
+var errUnlinked = errors.New("row links to a table that was not restored")
+
+// table is the content of one table in a bundle.
+type table struct {
+	Table
+	amounts map[string]bool
+	rows    []map[string]interface{}
+}
+
+// Restore recreates the data in bundle as data of userID, in one
+// transaction. Rows get new ids and their links are remapped to them.
+// The user must have no data besides accounts, which are replaced when
+// the bundle has its own, unless replace is set: then all of it is
+// deleted first. The period balances are rebuilt at the end.
+func (rs *Restorer) Restore(userID string, bundle []byte, replace bool) (Restored, error) {
+	restored := Restored{Rows: map[string]int{}}
+	tables, err := readBundle(bundle)
+	if err != nil {
+		return restored, err
+	}
+
+	tx, err := rs.DB.Begin()
+	if err != nil {
+		return restored, err
+	}
+	defer tx.Rollback()
+
+	existing := map[string]map[string]bool{}
+	for _, t := range Tables {
+		columns, err := tableColumns(tx, t.Name)
+		if err != nil {
+			return restored, err
+		}
+		if len(columns) > 0 {
+			existing[t.Name] = columns
+		}
+	}
+
+	if err := rs.clear(tx, userID, existing, tables, replace); err != nil {
+		return restored, err
+	}
+
+	// ids maps the ids of the bundle to the new ones, by table
+	ids := map[string]map[int64]int64{}
+	for _, t := range tables {
+		columns, ok := existing[t.Name]
+		if !ok {
+			restored.Skipped = append(restored.Skipped, t.Name)
+			continue
+		}
+		ids[t.Name] = map[int64]int64{}
+		restored.Rows[t.Name] = 0
+		for i, row := range t.rows {
+			id, err := insert(tx, userID, t, columns, row, ids)
+			if err == errUnlinked {
+				continue
+			}
+			if err != nil {
+				return restored, fmt.Errorf("error restoring %s row %d: %w", t.Name, i+1, err)
+			}
+			if old, ok := row["id"].(int64); ok {
+				ids[t.Name][old] = id
+			}
+			restored.Rows[t.Name]++
+		}
+	}
+
+	if _, err := rs.Reconciler.UserTx(tx, userID); err != nil {
+		return restored, fmt.Errorf("error rebuilding balances: %v", err)
+	}
+	return restored, tx.Commit()
+}
+
+// clear deletes what userID has in the tables of the database, with
+// its period balances. Without replace the user may only have accounts,
+// and they go only when the bundle has its own.
+func (rs *Restorer) clear(tx *sql.Tx, userID string, existing map[string]map[string]bool, tables []table, replace bool) error {
+	if !replace {
+		for _, t := range Tables {
+			if _, ok := existing[t.Name]; !ok || t.Name == "accounts" {
+				continue
+			}
+			var count int
+			err := tx.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s", t.Name, t.Where), userID).Scan(&count)
+			if err != nil {
+				return fmt.Errorf("error reading %s: %v", t.Name, err)
+			}
+			if count > 0 {
+				return fmt.Errorf("%w: it has %s", ErrNotEmpty, t.Name)
+			}
+		}
+	}
+
+	dropAccounts := replace
+	for _, t := range tables {
+		dropAccounts = dropAccounts || t.Name == "accounts"
+	}
+	if _, ok := existing["accounts"]; !ok {
+		dropAccounts = false
+	}
+	if dropAccounts {
+		// Without movements, which the final rebuild recreates, the
+		// accounts can be dropped from the period balances
+		if err := rs.Balances.RebuildTx(tx, userID, nil); err != nil {
+			return err
+		}
+		accounts, err := rs.Accounts.ListTx(tx, userID)
+		if err != nil {
+			return err
+		}
+		for _, a := range accounts {
+			if err := rs.Balances.DropAccountTx(tx, userID, a.ID); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Later tables first, so bill_payments still find their bills
+	for i := len(Tables) - 1; i >= 0; i-- {
+		t := Tables[i]
+		if _, ok := existing[t.Name]; !ok || (t.Name == "accounts" && !dropAccounts) {
+			continue
+		}
+		if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE %s", t.Name, t.Where), userID); err != nil {
+			return fmt.Errorf("error deleting %s: %v", t.Name, err)
+		}
+	}
+	return nil
+}
+
+// insert inserts row of t as a row of userID with the columns the
+// database has, and returns its new id.
+func insert(tx *sql.Tx, userID string, t table, columns map[string]bool, row map[string]interface{}, ids map[string]map[int64]int64) (int64, error) {
+	var names, placeholders []string
+	var values []interface{}
+	for column, v := range row {
+		if column == "id" || !columns[column] {
+			continue
+		}
+		switch {
+		case column == "user_id":
+			v = userID
+		case column == t.KindLink.Column:
+			linked, ok := ids[t.target(row)]
+			if !ok {
+				return 0, errUnlinked
+			}
+			old, ok := v.(int64)
+			if !ok {
+				return 0, fmt.Errorf("%w: %s %v is not an id", ErrInvalidBundle, column, v)
+			}
+			if v, ok = linked[old]; !ok {
+				return 0, fmt.Errorf("%w: %s %d is not in %s", ErrInvalidBundle, column, old, t.target(row))
+			}
+		case t.Links[column] != "" && v != nil:
+			linked, ok := ids[t.Links[column]]
+			if !ok {
+				// The database does not have the linked table
+				v = nil
+				break
+			}
+			old, ok := v.(int64)
+			if !ok {
+				return 0, fmt.Errorf("%w: %s %v is not an id", ErrInvalidBundle, column, v)
+			}
+			if old == 0 {
+				break
+			}
+			if v, ok = linked[old]; !ok {
+				return 0, fmt.Errorf("%w: %s %d is not in %s", ErrInvalidBundle, column, old, t.Links[column])
+			}
+		}
+		names = append(names, column)
+		placeholders = append(placeholders, "?")
+		values = append(values, v)
+	}
+
+	result, err := tx.Exec(fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		t.Name, strings.Join(names, ", "), strings.Join(placeholders, ", ")), values...)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// readBundle reads the manifest and the JSON files of bundle, in the order
+// of Tables, with amounts in cents and integers as int64.
+func readBundle(bundle []byte) ([]table, error) {
+	archive, err := zip.NewReader(bytes.NewReader(bundle), int64(len(bundle)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+	}
+	files := map[string]*zip.File{}
+	for _, f := range archive.File {
+		files[f.Name] = f
+	}
+
+	var manifest Manifest
+	if err := readFile(files, "manifest.json", &manifest); err != nil {
+		return nil, err
+	}
+	if manifest.SchemaVersion < 1 || manifest.SchemaVersion > SchemaVersion || manifest.Amounts != "units" {
+		return nil, fmt.Errorf("%w: schema version %d is not supported", ErrInvalidBundle, manifest.SchemaVersion)
+	}
+	described := map[string]ManifestTable{}
+	for _, t := range manifest.Tables {
+		described[t.Name] = t
+	}
+
+	var tables []table
+	for _, known := range Tables {
+		m, ok := described[known.Name]
+		if !ok {
+			continue
+		}
+		t := table{Table: known, amounts: map[string]bool{}}
+		for _, column := range m.AmountColumns {
+			t.amounts[column] = true
+		}
+		var rows []map[string]interface{}
+		if err := readFile(files, known.Name+".json", &rows); err != nil {
+			return nil, err
+		}
+		for i, row := range rows {
+			for column, v := range row {
+				if row[column], err = value(v, t.amounts[column]); err != nil {
+					return nil, fmt.Errorf("%w: %s row %d, %s: %v", ErrInvalidBundle, known.Name, i+1, column, err)
+				}
+			}
+		}
+		t.rows = rows
+		tables = append(tables, t)
+	}
+	return tables, links(tables)
+}
+
+// links checks that every link of the bundle is an id of a row it has.
+func links(tables []table) error {
+	ids := map[string]map[int64]bool{}
+	for _, t := range tables {
+		ids[t.Name] = map[int64]bool{}
+		for i, row := range t.rows {
+			links := t.Links
+			if t.KindLink.Column != "" {
+				links = map[string]string{t.KindLink.Column: t.target(row)}
+				for column, target := range t.Links {
+					links[column] = target
+				}
+			}
+			for column, target := range links {
+				if row[column] == nil {
+					continue
+				}
+				id, ok := row[column].(int64)
+				if !ok {
+					return fmt.Errorf("%w: %s row %d: %s %v is not an id", ErrInvalidBundle, t.Name, i+1, column, row[column])
+				}
+				if linked, ok := ids[target]; ok && id != 0 && !linked[id] {
+					return fmt.Errorf("%w: %s row %d: %s %d is not in %s", ErrInvalidBundle, t.Name, i+1, column, id, target)
+				}
+			}
+			if id, ok := row["id"].(int64); ok {
+				ids[t.Name][id] = true
+			}
+		}
+	}
+	return nil
+}
+
+func readFile(files map[string]*zip.File, name string, v interface{}) error {
+	f, ok := files[name]
+	if !ok {
+		return fmt.Errorf("%w: no %s", ErrInvalidBundle, name)
+	}
+	r, err := f.Open()
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrInvalidBundle, name, err)
+	}
+	defer r.Close()
+	decoder := json.NewDecoder(io.LimitReader(r, maxFileSize))
+	decoder.UseNumber()
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrInvalidBundle, name, err)
+	}
+	return nil
+}
+
+// maxFileSize bounds what one file of a bundle may unpack to.
+const maxFileSize = 256 << 20
+
+// value converts a JSON value of a bundle to what the database stores.
+func value(v interface{}, amount bool) (interface{}, error) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return v, nil
+	}
+	if amount {
+		cents, err := money.Parse(n.String())
+		return int64(cents), err
+	}
+	if i, err := n.Int64(); err == nil {
+		return i, nil
+	}
+	return n.Float64()
+}
+
+func tableColumns(tx *sql.Tx, name string) (map[string]bool, error) {
+	rows, err := tx.Query(fmt.Sprintf("PRAGMA table_info(%s)", name))
+	if err != nil {
+		return nil, fmt.Errorf("error reading columns of %s: %v", name, err)
+	}
+	defer rows.Close()
+
+	columns := map[string]bool{}
+	for rows.Next() {
+		var cid, notNull, pk int
+		var column, kind string
+		var defaultValue interface{}
+		if err := rows.Scan(&cid, &column, &kind, &notNull, &defaultValue, &pk); err != nil {
+			return nil, err
+		}
+		columns[column] = true
+	}
+	return columns, rows.Err()
+}
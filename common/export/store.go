@@ -0,0 +1,214 @@
+package export
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"backend/common/auth"
+	"backend/common/config"
+)
+
+// Export statuses.
+const (
+	Pending = "pending"
+	Ready   = "ready"
+	Failed  = "failed"
+)
+
+var (
+	// ErrNotFound is returned for exports that do not exist, belong to
+	// another user, were already downloaded or expired.
+	ErrNotFound = errors.New("export not found")
+	// ErrNotReady is returned when downloading an export still being built.
+	ErrNotReady = errors.New("export is not ready yet")
+	// ErrInProgress is returned when the user already has an export being
+	// built.
+	ErrInProgress = errors.New("an export is already in progress")
+)
+
+// Job is an export as its owner sees it.
+type Job struct {
+	ID        int64     `json:"id"`
+	Status    string    `json:"status"`
+	Size      int64     `json:"size"`
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Store builds and keeps the exports of one database.
+type Store struct {
+	db       *sql.DB
+	lifetime time.Duration
+	// timeout is how long a bundle may take to build. Exports pending for
+	// longer were interrupted, e.g. by a restart, and count as failed.
+	timeout time.Duration
+	now     func() time.Time
+	// building tracks the bundles being written, for tests.
+	building sync.WaitGroup
+}
+
+// New creates the exports table. Links work for lifetime from the moment
+// the export is asked for. Bundles not built within EXPORT_BUILD_TIMEOUT
+// (10 minutes by default) fail.
+func New(db *sql.DB, lifetime time.Duration) (*Store, error) {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS exports (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id TEXT NOT NULL,
+			token_hash TEXT NOT NULL UNIQUE,
+			status TEXT NOT NULL,
+			bundle BLOB,
+			error TEXT,
+			created_at INTEGER NOT NULL,
+			expires_at INTEGER NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_exports_user ON exports(user_id)`)
+	if err != nil {
+		return nil, fmt.Errorf("error creating exports table: %v", err)
+	}
+	return &Store{db: db, lifetime: lifetime, timeout: config.Duration("EXPORT_BUILD_TIMEOUT", 10*time.Minute), now: time.Now}, nil
+}
+
+// NewFromEnv is New with links that work for EXPORT_LINK_TTL (24 hours by
+// default).
+func NewFromEnv(db *sql.DB) (*Store, error) {
+	return New(db, config.Duration("EXPORT_LINK_TTL", 24*time.Hour))
+}
+
+// Start starts building the bundle of userID in the background. It
+// returns the job and the token that downloads it, once, when ready.
+func (s *Store) Start(userID string) (Job, string, error) {
+	// Expired bundles are dropped here instead of on a timer
+	if _, err := s.db.Exec(`DELETE FROM exports WHERE expires_at <= ?`, s.now().Unix()); err != nil {
+		return Job{}, "", fmt.Errorf("error purging exports: %v", err)
+	}
+	if err := s.failInterrupted(); err != nil {
+		return Job{}, "", err
+	}
+
+	var pending int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM exports WHERE user_id = ? AND status = ?`, userID, Pending).Scan(&pending)
+	if err != nil {
+		return Job{}, "", fmt.Errorf("error reading exports: %v", err)
+	}
+	if pending > 0 {
+		return Job{}, "", ErrInProgress
+	}
+
+	token, err := auth.RandomToken(32)
+	if err != nil {
+		return Job{}, "", err
+	}
+	now := s.now()
+	job := Job{Status: Pending, CreatedAt: now.UTC().Truncate(time.Second), ExpiresAt: now.Add(s.lifetime).UTC().Truncate(time.Second)}
+	result, err := s.db.Exec(`
+		INSERT INTO exports (user_id, token_hash, status, created_at, expires_at) VALUES (?, ?, ?, ?, ?)`,
+		userID, auth.HashToken(token), Pending, job.CreatedAt.Unix(), job.ExpiresAt.Unix())
+	if err != nil {
+		return Job{}, "", fmt.Errorf("error starting export: %v", err)
+	}
+	if job.ID, err = result.LastInsertId(); err != nil {
+		return Job{}, "", err
+	}
+
+	s.building.Add(1)
+	go func() {
+		defer s.building.Done()
+		s.build(job.ID, userID)
+	}()
+	return job, token, nil
+}
+
+// build writes the bundle of export id and stores it, or why it failed.
+func (s *Store) build(id int64, userID string) {
+	var bundle bytes.Buffer
+	if err := Write(&bundle, s.db, userID); err != nil {
+		log.Printf("Error exporting data of user %s: %v", userID, err)
+		if _, err := s.db.Exec(`UPDATE exports SET status = ?, error = ? WHERE id = ?`, Failed, err.Error(), id); err != nil {
+			log.Printf("Error saving failed export %d: %v", id, err)
+		}
+		return
+	}
+	_, err := s.db.Exec(`UPDATE exports SET status = ?, bundle = ? WHERE id = ?`, Ready, bundle.Bytes(), id)
+	if err != nil {
+		log.Printf("Error saving export %d: %v", id, err)
+	}
+}
+
+// failInterrupted marks the exports pending for longer than the build
+// timeout as failed, so they neither block new ones nor look busy forever.
+func (s *Store) failInterrupted() error {
+	_, err := s.db.Exec(`UPDATE exports SET status = ?, error = ? WHERE status = ? AND created_at <= ?`,
+		Failed, "the export was interrupted", Pending, s.now().Add(-s.timeout).Unix())
+	if err != nil {
+		return fmt.Errorf("error failing interrupted exports: %v", err)
+	}
+	return nil
+}
+
+// Status returns export id of userID.
+func (s *Store) Status(userID string, id int64) (Job, error) {
+	if err := s.failInterrupted(); err != nil {
+		return Job{}, err
+	}
+	job := Job{ID: id}
+	var failure sql.NullString
+	var createdAt, expiresAt int64
+	err := s.db.QueryRow(`
+		SELECT status, COALESCE(LENGTH(bundle), 0), error, created_at, expires_at
+		FROM exports WHERE id = ? AND user_id = ? AND expires_at > ?`, id, userID, s.now().Unix()).
+		Scan(&job.Status, &job.Size, &failure, &createdAt, &expiresAt)
+	if err == sql.ErrNoRows {
+		return Job{}, ErrNotFound
+	}
+	if err != nil {
+		return Job{}, fmt.Errorf("error reading export %d: %v", id, err)
+	}
+	job.Error = failure.String
+	job.CreatedAt, job.ExpiresAt = time.Unix(createdAt, 0).UTC(), time.Unix(expiresAt, 0).UTC()
+	return job, nil
+}
+
+// Download returns the bundle token links to and its owner, and deletes
+// it: each link works once. Bundles still being built are kept.
+func (s *Store) Download(token string) (string, []byte, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return "", nil, err
+	}
+	defer tx.Rollback()
+
+	var id int64
+	var userID, status string
+	var bundle []byte
+	err = tx.QueryRow(`SELECT id, user_id, status, bundle FROM exports WHERE token_hash = ? AND expires_at > ?`,
+		auth.HashToken(token), s.now().Unix()).Scan(&id, &userID, &status, &bundle)
+	if err == sql.ErrNoRows {
+		return "", nil, ErrNotFound
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("error reading export: %v", err)
+	}
+	switch status {
+	case Pending:
+		return "", nil, ErrNotReady
+	case Failed:
+		return "", nil, ErrNotFound
+	}
+
+	result, err := tx.Exec(`DELETE FROM exports WHERE id = ?`, id)
+	if err != nil {
+		return "", nil, fmt.Errorf("error deleting export %d: %v", id, err)
+	}
+	// Two downloads at once: only the one that deleted the row gets it
+	if deleted, _ := result.RowsAffected(); deleted == 0 {
+		return "", nil, ErrNotFound
+	}
+	return userID, bundle, tx.Commit()
+}
@@ -0,0 +1,11 @@
+module backend/common
+
+go 1.21
+
+require (
+	github.com/mattn/go-sqlite3 v1.14.27
+	golang.org/x/crypto v0.33.0
+	gopkg.in/gomail.v2 v2.0.0-20160411212932-81ebce5c23df
+)
+
+require gopkg.in/alexcesaro/quotedprintable.v3 v3.0.0-20150716171945-2caba252f4dc // indirect
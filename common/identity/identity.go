@@ -0,0 +1,189 @@
+// Package identity keeps track of the login methods attached to each user
+// in the user_identities table: Google and Apple accounts (keyed by the
+// provider "sub") and the email/password credential.
+//
+// The legacy users.google_id and users.apple_id columns are still read by
+// other services, so Link and Unlink keep them in sync.
+package identity
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	ProviderPassword = "password"
+	ProviderGoogle   = "google"
+	ProviderApple    = "apple"
+)
+
+var (
+	ErrNotFound        = errors.New("identity not found")
+	ErrLinkedElsewhere = errors.New("this login method belongs to another account")
+	ErrAlreadyLinked   = errors.New("a login method of this provider is already linked")
+	ErrLastIdentity    = errors.New("cannot remove the last login method")
+	ErrUnknownProvider = errors.New("unknown login provider")
+)
+
+// Identity is one login method of a user.
+type Identity struct {
+	Provider   string     `json:"provider"`
+	Subject    string     `json:"-"`
+	Email      string     `json:"email,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// Store reads and writes user_identities.
+type Store struct {
+	db  *sql.DB
+	now func() time.Time
+}
+
+// NewStore creates user_identities if needed and backfills it from the
+// login columns of users, so existing accounts keep all their methods.
+func NewStore(db *sql.DB) (*Store, error) {
+	s := &Store{db: db, now: time.Now}
+	if err := s.createTables(); err != nil {
+		return nil, err
+	}
+	if err := s.backfill(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Store) createTables() error {
+	_, err := s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS user_identities (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			provider TEXT NOT NULL,
+			subject TEXT NOT NULL,
+			email TEXT,
+			created_at INTEGER NOT NULL,
+			last_used_at INTEGER,
+			UNIQUE(provider, subject),
+			UNIQUE(user_id, provider)
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating user_identities table: %v", err)
+	}
+	return nil
+}
+
+func (s *Store) backfill() error {
+	columns, err := userColumns(s.db)
+	if err != nil {
+		return err
+	}
+
+	now := s.now().Unix()
+	sources := map[string]string{
+		"google_id": `INSERT OR IGNORE INTO user_identities (user_id, provider, subject, email, created_at)
+			SELECT id, 'google', google_id, email, ? FROM users WHERE google_id IS NOT NULL AND google_id != ''`,
+		"apple_id": `INSERT OR IGNORE INTO user_identities (user_id, provider, subject, email, created_at)
+			SELECT id, 'apple', apple_id, email, ? FROM users WHERE apple_id IS NOT NULL AND apple_id != ''`,
+		"password": `INSERT OR IGNORE INTO user_identities (user_id, provider, subject, email, created_at)
+			SELECT id, 'password', CAST(id AS TEXT), email, ? FROM users WHERE password IS NOT NULL AND password != ''`,
+	}
+	for column, query := range sources {
+		if !columns[column] {
+			continue
+		}
+		if _, err := s.db.Exec(query, now); err != nil {
+			return fmt.Errorf("error backfilling %s identities: %v", column, err)
+		}
+	}
+	return nil
+}
+
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// userColumns lists the columns of users: apple_id and password are added by
+// other services and may be missing in fresh databases.
+func userColumns(q queryer) (map[string]bool, error) {
+	rows, err := q.Query(`SELECT name FROM pragma_table_info('users')`)
+	if err != nil {
+		return nil, fmt.Errorf("error reading users columns: %v", err)
+	}
+	defer rows.Close()
+
+	columns := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("error reading users columns: %v", err)
+		}
+		columns[name] = true
+	}
+	return columns, rows.Err()
+}
+
+// PasswordSubject is the subject of a user's email/password identity.
+func PasswordSubject(userID int) string {
+	return strconv.Itoa(userID)
+}
+
+// Find returns the user that owns provider/subject.
+func (s *Store) Find(provider, subject string) (int, error) {
+	var userID int
+	err := s.db.QueryRow(`
+		SELECT user_id FROM user_identities WHERE provider = ? AND subject = ?`,
+		provider, subject,
+	).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, ErrNotFound
+	} else if err != nil {
+		return 0, fmt.Errorf("error fetching identity: %v", err)
+	}
+	return userID, nil
+}
+
+// Touch records that provider/subject was just used to sign in.
+func (s *Store) Touch(provider, subject string) error {
+	_, err := s.db.Exec(`
+		UPDATE user_identities SET last_used_at = ? WHERE provider = ? AND subject = ?`,
+		s.now().Unix(), provider, subject,
+	)
+	if err != nil {
+		return fmt.Errorf("error updating identity: %v", err)
+	}
+	return nil
+}
+
+// List returns the login methods of userID, oldest first.
+func (s *Store) List(userID int) ([]Identity, error) {
+	rows, err := s.db.Query(`
+		SELECT provider, subject, COALESCE(email, ''), created_at, last_used_at
+		FROM user_identities WHERE user_id = ? ORDER BY created_at, id`,
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error listing identities: %v", err)
+	}
+	defer rows.Close()
+
+	identities := []Identity{}
+	for rows.Next() {
+		var identity Identity
+		var createdAt int64
+		var lastUsedAt sql.NullInt64
+		if err := rows.Scan(&identity.Provider, &identity.Subject, &identity.Email, &createdAt, &lastUsedAt); err != nil {
+			return nil, fmt.Errorf("error reading identity: %v", err)
+		}
+		identity.CreatedAt = time.Unix(createdAt, 0)
+		if lastUsedAt.Valid {
+			used := time.Unix(lastUsedAt.Int64, 0)
+			identity.LastUsedAt = &used
+		}
+		identities = append(identities, identity)
+	}
+	return identities, rows.Err()
+}
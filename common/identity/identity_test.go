@@ -0,0 +1,107 @@
+package identity
+
+import (
+	"database/sql"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func newTestStore(t *testing.T) (*Store, *sql.DB) {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	_, err = db.Exec(`
+		CREATE TABLE users (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			google_id TEXT UNIQUE,
+			email TEXT UNIQUE,
+			password TEXT,
+			updated_at DATETIME
+		);
+		INSERT INTO users (id, google_id, email, password) VALUES
+			(1, 'g-1', 'both@example.com', '$2a$hash'),
+			(2, NULL, 'password@example.com', '$2a$hash'),
+			(3, 'g-3', 'google@example.com', NULL);
+	`)
+	if err != nil {
+		t.Fatalf("Failed to seed users: %v", err)
+	}
+
+	s, err := NewStore(db)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	return s, db
+}
+
+func TestBackfillFromUsers(t *testing.T) {
+	s, _ := newTestStore(t)
+
+	identities, err := s.List(1)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(identities) != 2 {
+		t.Errorf("Expected google and password identities for user 1, got %+v", identities)
+	}
+
+	if userID, err := s.Find(ProviderGoogle, "g-3"); err != nil || userID != 3 {
+		t.Errorf("Expected g-3 to belong to user 3, got %d, %v", userID, err)
+	}
+	if _, err := s.Find(ProviderApple, "a-1"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestLinkRules(t *testing.T) {
+	s, db := newTestStore(t)
+
+	if err := s.Link(2, ProviderGoogle, "g-1", ""); err != ErrLinkedElsewhere {
+		t.Errorf("Expected ErrLinkedElsewhere, got %v", err)
+	}
+	if err := s.Link(1, ProviderGoogle, "g-other", ""); err != ErrAlreadyLinked {
+		t.Errorf("Expected ErrAlreadyLinked, got %v", err)
+	}
+	if err := s.Link(1, ProviderGoogle, "g-1", ""); err != nil {
+		t.Errorf("Expected relinking the same identity to be a no-op, got %v", err)
+	}
+
+	if err := s.Link(2, ProviderGoogle, "g-2", "password@example.com"); err != nil {
+		t.Fatalf("Link failed: %v", err)
+	}
+	var googleID sql.NullString
+	db.QueryRow(`SELECT google_id FROM users WHERE id = 2`).Scan(&googleID)
+	if googleID.String != "g-2" {
+		t.Errorf("Expected users.google_id to be kept in sync, got %q", googleID.String)
+	}
+}
+
+func TestUnlinkKeepsLastMethod(t *testing.T) {
+	s, db := newTestStore(t)
+
+	if err := s.Unlink(3, ProviderGoogle); err != ErrLastIdentity {
+		t.Errorf("Expected ErrLastIdentity, got %v", err)
+	}
+	if err := s.Unlink(3, ProviderPassword); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	if err := s.Unlink(1, ProviderPassword); err != nil {
+		t.Fatalf("Unlink failed: %v", err)
+	}
+	var password sql.NullString
+	db.QueryRow(`SELECT password FROM users WHERE id = 1`).Scan(&password)
+	if password.Valid {
+		t.Errorf("Expected password to be cleared, got %q", password.String)
+	}
+	if err := s.Unlink(1, ProviderGoogle); err != ErrLastIdentity {
+		t.Errorf("Expected ErrLastIdentity after removing the password, got %v", err)
+	}
+}
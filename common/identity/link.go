@@ -0,0 +1,137 @@
+package identity
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// legacyColumns maps providers to the users column older code reads.
+var legacyColumns = map[string]string{
+	ProviderGoogle: "google_id",
+	ProviderApple:  "apple_id",
+}
+
+// Link attaches provider/subject to userID. A subject already owned by
+// another user is never moved: that account has to unlink it first.
+func (s *Store) Link(userID int, provider, subject, email string) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		return s.link(tx, userID, provider, subject, email)
+	})
+}
+
+// LinkTx is Link inside a caller's transaction, for sign-up flows that
+// create the user and its first identity together.
+func (s *Store) LinkTx(tx *sql.Tx, userID int, provider, subject, email string) error {
+	return s.link(tx, userID, provider, subject, email)
+}
+
+func (s *Store) link(tx *sql.Tx, userID int, provider, subject, email string) error {
+	if provider != ProviderPassword && provider != ProviderGoogle && provider != ProviderApple {
+		return ErrUnknownProvider
+	}
+
+	var owner int
+	var ownedSubject string
+	err := tx.QueryRow(`
+		SELECT user_id, subject FROM user_identities
+		WHERE (provider = ? AND subject = ?) OR (provider = ? AND user_id = ?)`,
+		provider, subject, provider, userID,
+	).Scan(&owner, &ownedSubject)
+	switch {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		return fmt.Errorf("error checking identity: %v", err)
+	case owner != userID:
+		return ErrLinkedElsewhere
+	case ownedSubject == subject:
+		return nil // already linked, nothing to do
+	default:
+		return ErrAlreadyLinked
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO user_identities (user_id, provider, subject, email, created_at)
+		VALUES (?, ?, ?, ?, ?)`,
+		userID, provider, subject, email, s.now().Unix(),
+	)
+	if err != nil {
+		return fmt.Errorf("error linking identity: %v", err)
+	}
+
+	if column, ok := legacyColumns[provider]; ok {
+		if err := setLegacyColumn(tx, column, subject, userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Unlink removes the provider login method of userID, refusing to remove
+// the last one. Unlinking the password also clears the stored hash.
+func (s *Store) Unlink(userID int, provider string) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		var count int
+		var linked bool
+		err := tx.QueryRow(`
+			SELECT COUNT(*), COALESCE(SUM(provider = ?), 0) > 0
+			FROM user_identities WHERE user_id = ?`,
+			provider, userID,
+		).Scan(&count, &linked)
+		if err != nil {
+			return fmt.Errorf("error counting identities: %v", err)
+		}
+		if !linked {
+			return ErrNotFound
+		}
+		if count <= 1 {
+			return ErrLastIdentity
+		}
+
+		if _, err := tx.Exec(`DELETE FROM user_identities WHERE user_id = ? AND provider = ?`, userID, provider); err != nil {
+			return fmt.Errorf("error unlinking identity: %v", err)
+		}
+
+		if column, ok := legacyColumns[provider]; ok {
+			return setLegacyColumn(tx, column, nil, userID)
+		}
+		if provider == ProviderPassword {
+			_, err := tx.Exec(`UPDATE users SET password = NULL, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, userID)
+			if err != nil {
+				return fmt.Errorf("error clearing password: %v", err)
+			}
+		}
+		return nil
+	})
+}
+
+func setLegacyColumn(tx *sql.Tx, column string, value interface{}, userID int) error {
+	columns, err := userColumns(tx)
+	if err != nil {
+		return err
+	}
+	if !columns[column] {
+		return nil
+	}
+
+	_, err = tx.Exec(fmt.Sprintf(`UPDATE users SET %s = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, column), value, userID)
+	if err != nil {
+		return fmt.Errorf("error updating users.%s: %v", column, err)
+	}
+	return nil
+}
+
+func (s *Store) withTx(fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package journal
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"backend/common/auth"
+	"backend/common/reply"
+)
+
+// Actor returns who is making the change r asks for: the authenticated
+// user, or System for requests that did not go through auth.Require.
+func Actor(r *http.Request) string {
+	if userID := auth.UserID(r); userID != "" {
+		return userID
+	}
+	return System
+}
+
+// Handler serves the journal of the authenticated user, oldest first,
+// optionally only of entity and entity_id. Wrap it in auth.Require.
+func (j *Journal) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			reply.JSON(w, http.StatusMethodNotAllowed, false, "Method not allowed", nil)
+			return
+		}
+		userID := auth.UserID(r)
+		if userID == "" {
+			reply.JSON(w, http.StatusBadRequest, false, "User ID is required", nil)
+			return
+		}
+
+		var entityID int64
+		if id := r.URL.Query().Get("entity_id"); id != "" {
+			var err error
+			if entityID, err = strconv.ParseInt(id, 10, 64); err != nil {
+				reply.JSON(w, http.StatusBadRequest, false, "Invalid entity_id", nil)
+				return
+			}
+		}
+
+		records, err := j.Records(userID, r.URL.Query().Get("entity"), entityID)
+		if err != nil {
+			log.Printf("Error reading journal: %v", err)
+			reply.JSON(w, http.StatusInternalServerError, false, "Error reading journal", nil)
+			return
+		}
+		if records == nil {
+			records = []Record{}
+		}
+		reply.JSON(w, http.StatusOK, true, "", records)
+	}
+}
@@ -0,0 +1,270 @@
+// Package journal keeps an append-only record of every change to a user's
+// financial data: each create, update and delete of an income, expense,
+// bill, transfer, saving, budget or manual cash and bank correction is
+// recorded with the state of the row before and after it, who made it and
+// when, in the same transaction as the change itself.
+//
+// A record also carries the ledger entries the change reversed and
+// posted, so the period balances can be replayed from the journal alone
+// (see EntriesTx). Triggers reject any UPDATE or DELETE of the journal
+// table.
+package journal
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"backend/common/ledger"
+	"backend/common/money"
+)
+
+// Actions.
+const (
+	Create = "create"
+	Update = "update"
+	Delete = "delete"
+)
+
+// System is the actor of changes no user asked for, such as the statement
+// bills created when a card cycle closes.
+const System = "system"
+
+// ErrInvalidChange is returned for a change without entity or actor, or
+// without a before and after state.
+var ErrInvalidChange = errors.New("invalid journal change")
+
+// Change is one mutation to record.
+type Change struct {
+	// Actor is the user who made the change, or System.
+	Actor    string
+	Entity   string
+	EntityID int64
+	// Before and After are the states of the row, marshalled as JSON. A
+	// nil Before is a create and a nil After a delete.
+	Before, After interface{}
+	// Reversed and Posted are the ledger entries the change took out of
+	// and added to the balances.
+	Reversed, Posted []ledger.Entry
+}
+
+// Record is a change as stored in the journal.
+type Record struct {
+	ID        int64           `json:"id"`
+	UserID    string          `json:"user_id"`
+	Actor     string          `json:"actor"`
+	Entity    string          `json:"entity"`
+	EntityID  int64           `json:"entity_id"`
+	Action    string          `json:"action"`
+	Before    json.RawMessage `json:"before,omitempty"`
+	After     json.RawMessage `json:"after,omitempty"`
+	Reversed  []ledger.Entry  `json:"reversed,omitempty"`
+	Posted    []ledger.Entry  `json:"posted,omitempty"`
+	CreatedAt string          `json:"created_at"`
+}
+
+// Journal appends changes to the journal table of one database.
+type Journal struct {
+	db *sql.DB
+}
+
+// New creates the journal table and the triggers that keep it append-only.
+func New(db *sql.DB) (*Journal, error) {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS journal (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id TEXT NOT NULL,
+			actor TEXT NOT NULL,
+			entity TEXT NOT NULL,
+			entity_id INTEGER NOT NULL,
+			action TEXT NOT NULL,
+			before_state TEXT,
+			after_state TEXT,
+			reversed_entries TEXT,
+			posted_entries TEXT,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS idx_journal_user ON journal(user_id, id);
+		CREATE INDEX IF NOT EXISTS idx_journal_entity ON journal(user_id, entity, entity_id);
+		CREATE TRIGGER IF NOT EXISTS journal_no_update BEFORE UPDATE ON journal
+		BEGIN SELECT RAISE(ABORT, 'journal is append-only'); END;
+		CREATE TRIGGER IF NOT EXISTS journal_no_delete BEFORE DELETE ON journal
+		BEGIN SELECT RAISE(ABORT, 'journal is append-only'); END`)
+	if err != nil {
+		return nil, fmt.Errorf("error creating journal table: %v", err)
+	}
+	return &Journal{db: db}, nil
+}
+
+// RecordTx appends c for userID inside the caller's transaction, so the
+// record commits or rolls back together with the change.
+func (j *Journal) RecordTx(tx *sql.Tx, userID string, c Change) error {
+	if c.Entity == "" || c.Actor == "" || (c.Before == nil && c.After == nil) {
+		return fmt.Errorf("%w: %+v", ErrInvalidChange, c)
+	}
+	action := Update
+	switch {
+	case c.Before == nil:
+		action = Create
+	case c.After == nil:
+		action = Delete
+	}
+
+	var columns [4]interface{}
+	for i, v := range []interface{}{c.Before, c.After, c.Reversed, c.Posted} {
+		if v == nil {
+			continue
+		}
+		if entries, ok := v.([]ledger.Entry); ok && len(entries) == 0 {
+			continue
+		}
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("error encoding %s %d for the journal: %v", c.Entity, c.EntityID, err)
+		}
+		columns[i] = string(data)
+	}
+
+	_, err := tx.Exec(`
+		INSERT INTO journal (user_id, actor, entity, entity_id, action, before_state, after_state, reversed_entries, posted_entries)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		userID, c.Actor, c.Entity, c.EntityID, action, columns[0], columns[1], columns[2], columns[3])
+	if err != nil {
+		return fmt.Errorf("error recording %s %d in the journal: %v", c.Entity, c.EntityID, err)
+	}
+	return nil
+}
+
+// Records returns the journal of userID, oldest first. A non-empty entity
+// keeps only its records, and a non-zero entityID only those of that row.
+func (j *Journal) Records(userID, entity string, entityID int64) ([]Record, error) {
+	query := `
+		SELECT id, user_id, actor, entity, entity_id, action, COALESCE(before_state, ''), COALESCE(after_state, ''),
+		       COALESCE(reversed_entries, ''), COALESCE(posted_entries, ''), created_at
+		FROM journal WHERE user_id = ?`
+	args := []interface{}{userID}
+	if entity != "" {
+		query += " AND entity = ?"
+		args = append(args, entity)
+	}
+	if entityID != 0 {
+		query += " AND entity_id = ?"
+		args = append(args, entityID)
+	}
+	return readRecords(j.db, query+" ORDER BY id", args...)
+}
+
+// EntriesTx replays the journal of userID: every entry posted, and every
+// entry reversed with its amount negated, in the order they happened.
+// Rebuilding the ledger with them (ledger.RebuildTx) gives the balances of
+// the changes recorded since the journal began.
+func (j *Journal) EntriesTx(tx *sql.Tx, userID string) ([]ledger.Entry, error) {
+	records, err := readRecords(tx, `
+		SELECT id, user_id, actor, entity, entity_id, action, '', '',
+		       COALESCE(reversed_entries, ''), COALESCE(posted_entries, ''), created_at
+		FROM journal WHERE user_id = ? ORDER BY id`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []ledger.Entry
+	for _, r := range records {
+		for _, e := range r.Reversed {
+			e.Amount = -e.Amount
+			entries = append(entries, e)
+		}
+		entries = append(entries, r.Posted...)
+	}
+	return entries, nil
+}
+
+// RowTx reads the row of table with id as the state to record for it,
+// keyed by column. Amount columns (money.Columns and base_amount) are
+// read as money, so they are recorded in units like the services' own
+// JSON. It is for callers that change rows of another service's table.
+func RowTx(tx *sql.Tx, table string, id int64) (map[string]interface{}, error) {
+	rows, err := tx.Query(fmt.Sprintf("SELECT * FROM %s WHERE id = ?", table), id)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s %d: %v", table, id, err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("error reading %s %d: %w", table, id, sql.ErrNoRows)
+	}
+	values := make([]interface{}, len(columns))
+	for i := range values {
+		values[i] = new(interface{})
+	}
+	if err := rows.Scan(values...); err != nil {
+		return nil, fmt.Errorf("error reading %s %d: %v", table, id, err)
+	}
+
+	amounts := map[string]bool{"base_amount": true}
+	for _, column := range money.Columns[table] {
+		amounts[column] = true
+	}
+	state := make(map[string]interface{}, len(columns))
+	for i, column := range columns {
+		v := *values[i].(*interface{})
+		if b, ok := v.([]byte); ok {
+			v = string(b)
+		}
+		if amount, ok := v.(int64); ok && amounts[column] {
+			v = money.Money(amount)
+		}
+		state[column] = v
+	}
+	return state, rows.Err()
+}
+
+// querier is what *sql.DB and *sql.Tx have in common for reads.
+type querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func readRecords(q querier, query string, args ...interface{}) ([]Record, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error reading journal: %v", err)
+	}
+	defer rows.Close()
+
+	var records []Record
+	for rows.Next() {
+		var r Record
+		var before, after, reversed, posted string
+		err := rows.Scan(&r.ID, &r.UserID, &r.Actor, &r.Entity, &r.EntityID, &r.Action, &before, &after,
+			&reversed, &posted, &r.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("error reading journal: %v", err)
+		}
+		if before != "" {
+			r.Before = json.RawMessage(before)
+		}
+		if after != "" {
+			r.After = json.RawMessage(after)
+		}
+		for _, column := range []struct {
+			data    string
+			entries *[]ledger.Entry
+		}{{reversed, &r.Reversed}, {posted, &r.Posted}} {
+			if column.data == "" {
+				continue
+			}
+			if err := json.Unmarshal([]byte(column.data), column.entries); err != nil {
+				return nil, fmt.Errorf("error decoding journal record %d: %v", r.ID, err)
+			}
+		}
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
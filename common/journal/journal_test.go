@@ -0,0 +1,128 @@
+package journal
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"backend/common/dbtest"
+	"backend/common/ledger"
+)
+
+type expense struct {
+	Amount int    `json:"amount"`
+	Date   string `json:"date"`
+}
+
+func TestRecordsAreAppendOnly(t *testing.T) {
+	db := dbtest.Open(t)
+	j, err := New(db)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	tx, _ := db.Begin()
+	changes := []Change{
+		{Actor: "u1", Entity: "expense", EntityID: 7, After: expense{300, "2025-01-12"}},
+		{Actor: "u1", Entity: "expense", EntityID: 7, Before: expense{300, "2025-01-12"}, After: expense{400, "2025-01-12"}},
+		{Actor: System, Entity: "expense", EntityID: 7, Before: expense{400, "2025-01-12"}},
+		{Actor: "u1", Entity: "income", EntityID: 3, After: expense{10, "2025-01-01"}},
+	}
+	for _, c := range changes {
+		if err := j.RecordTx(tx, "u1", c); err != nil {
+			t.Fatalf("RecordTx: %v", err)
+		}
+	}
+	if err := j.RecordTx(tx, "u1", Change{Actor: "u1", Entity: "expense"}); !errors.Is(err, ErrInvalidChange) {
+		t.Errorf("RecordTx without states = %v, want ErrInvalidChange", err)
+	}
+	tx.Commit()
+
+	records, err := j.Records("u1", "expense", 7)
+	if err != nil {
+		t.Fatalf("Records: %v", err)
+	}
+	var actions []string
+	for _, r := range records {
+		actions = append(actions, r.Action)
+	}
+	if !reflect.DeepEqual(actions, []string{Create, Update, Delete}) {
+		t.Errorf("Actions = %v", actions)
+	}
+	var after expense
+	json.Unmarshal(records[1].After, &after)
+	if after.Amount != 400 || records[2].After != nil || records[2].Actor != System {
+		t.Errorf("Records = %+v", records)
+	}
+
+	if _, err := db.Exec(`UPDATE journal SET actor = 'u2'`); err == nil {
+		t.Errorf("Journal rows can be updated")
+	}
+	if _, err := db.Exec(`DELETE FROM journal`); err == nil {
+		t.Errorf("Journal rows can be deleted")
+	}
+	if all, _ := j.Records("u1", "", 0); len(all) != 4 {
+		t.Errorf("Journal has %d records, want 4", len(all))
+	}
+}
+
+func TestBalancesReplayFromTheJournal(t *testing.T) {
+	db := dbtest.Open(t)
+	l, err := ledger.New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j, err := New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	income := ledger.Entry{UserID: "u1", Kind: ledger.Income, Method: ledger.Bank, Account: 2, Amount: 1000, Date: "2025-01-10"}
+	expense := ledger.Entry{UserID: "u1", Kind: ledger.Expense, Method: ledger.Cash, Account: 1, Amount: 300, Date: "2025-01-12"}
+	amended := expense
+	amended.Amount, amended.Date = 350, "2025-02-01"
+
+	// Each change posts to the ledger and records what it posted
+	for _, c := range []Change{
+		{Actor: "u1", Entity: "income", EntityID: 1, After: income, Posted: []ledger.Entry{income}},
+		{Actor: "u1", Entity: "expense", EntityID: 1, After: expense, Posted: []ledger.Entry{expense}},
+		{Actor: "u1", Entity: "expense", EntityID: 1, Before: expense, After: amended,
+			Reversed: []ledger.Entry{expense}, Posted: []ledger.Entry{amended}},
+	} {
+		tx, _ := db.Begin()
+		if err := l.ReplaceTx(tx, c.Reversed, c.Posted); err != nil {
+			t.Fatal(err)
+		}
+		if err := j.RecordTx(tx, "u1", c); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tx, _ := db.Begin()
+	defer tx.Rollback()
+	want, err := l.SnapshotTx(tx, "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := j.EntriesTx(tx, "u1")
+	if err != nil {
+		t.Fatalf("EntriesTx: %v", err)
+	}
+	if len(entries) != 4 || entries[2].Amount != -300 {
+		t.Errorf("Entries = %+v", entries)
+	}
+	if err := l.RebuildTx(tx, "u1", entries); err != nil {
+		t.Fatalf("RebuildTx: %v", err)
+	}
+	got, err := l.SnapshotTx(tx, "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Replayed balances differ from the posted ones")
+	}
+}
@@ -0,0 +1,296 @@
+package ledger
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"backend/common/money"
+)
+
+// ErrAccountNotEmpty is returned by DropAccountTx for an account whose
+// movements do not add up to nothing.
+var ErrAccountNotEmpty = errors.New("account still has movements")
+
+// accountFlowColumns are the movements of an account row, one per kind.
+var accountFlowColumns = []string{"income_amount", "expense_amount", "bill_amount", "adjustment_amount"}
+
+// accountKey identifies one account's run of periods in the cascade.
+type accountKey struct {
+	userID  string
+	account int64
+}
+
+// createAccountTable creates the <name>_account_balance table of a period.
+// Every row holds one account's movements in that period and its running
+// balance:
+//
+//	balance = previous_balance + income - expense - bill + adjustment
+func createAccountTable(db *sql.DB, p period) error {
+	columns := []string{
+		"id INTEGER PRIMARY KEY AUTOINCREMENT",
+		"user_id TEXT NOT NULL",
+		"account_id INTEGER NOT NULL",
+		p.column + " TEXT NOT NULL",
+	}
+	for _, c := range append(append([]string{}, accountFlowColumns...), "previous_balance", "balance") {
+		columns = append(columns, c+" INTEGER NOT NULL DEFAULT 0")
+	}
+	columns = append(columns,
+		"created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP",
+		"updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP",
+		fmt.Sprintf("UNIQUE(user_id, account_id, %s)", p.column),
+	)
+
+	table := p.accountTable()
+	_, err := db.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (\n\t%s\n)", table, strings.Join(columns, ",\n\t")))
+	if err != nil {
+		return fmt.Errorf("error creating %s table: %v", table, err)
+	}
+	return nil
+}
+
+// addAccountMovement adds sign*e.Amount to the period key of e's account.
+func addAccountMovement(tx *sql.Tx, p period, e Entry, key string, sign money.Money) error {
+	table := p.accountTable()
+	_, err := tx.Exec(fmt.Sprintf(`INSERT OR IGNORE INTO %s (user_id, account_id, %s) VALUES (?, ?, ?)`, table, p.column),
+		e.UserID, e.Account, key)
+	if err != nil {
+		return fmt.Errorf("error creating %s row: %v", table, err)
+	}
+
+	column := fmt.Sprintf("%s_amount", e.Kind)
+	_, err = tx.Exec(fmt.Sprintf(`
+		UPDATE %s SET %s = %s + ?, updated_at = CURRENT_TIMESTAMP
+		WHERE user_id = ? AND account_id = ? AND %s = ?`, table, column, column, p.column),
+		sign*e.Amount, e.UserID, e.Account, key)
+	if err != nil {
+		return fmt.Errorf("error posting to %s: %v", table, err)
+	}
+	return nil
+}
+
+// cascadeAccount recomputes the running balance of one account from key
+// onwards, like cascade does for the cash and bank totals.
+func cascadeAccount(tx *sql.Tx, userID string, account int64, p period, key string) error {
+	table := p.accountTable()
+
+	var balance money.Money
+	err := tx.QueryRow(fmt.Sprintf(`
+		SELECT balance FROM %s
+		WHERE user_id = ? AND account_id = ? AND %s < ?
+		ORDER BY %s DESC LIMIT 1`, table, p.column, p.column),
+		userID, account, key).Scan(&balance)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("error reading opening balance from %s: %v", table, err)
+	}
+
+	rows, err := tx.Query(fmt.Sprintf(`
+		SELECT %s, income_amount, expense_amount, bill_amount, adjustment_amount
+		FROM %s
+		WHERE user_id = ? AND account_id = ? AND %s >= ?
+		ORDER BY %s`, p.column, table, p.column, p.column),
+		userID, account, key)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %v", table, err)
+	}
+	type accountRow struct {
+		key                               string
+		income, expense, bill, adjustment money.Money
+	}
+	var periodRows []accountRow
+	for rows.Next() {
+		var r accountRow
+		if err := rows.Scan(&r.key, &r.income, &r.expense, &r.bill, &r.adjustment); err != nil {
+			rows.Close()
+			return fmt.Errorf("error reading %s: %v", table, err)
+		}
+		periodRows = append(periodRows, r)
+	}
+	rows.Close()
+
+	for _, r := range periodRows {
+		previous := balance
+		balance = previous + r.income - r.expense - r.bill + r.adjustment
+		_, err := tx.Exec(fmt.Sprintf(`
+			UPDATE %s SET previous_balance = ?, balance = ?, updated_at = CURRENT_TIMESTAMP
+			WHERE user_id = ? AND account_id = ? AND %s = ?`, table, p.column),
+			previous, balance, userID, account, r.key)
+		if err != nil {
+			return fmt.Errorf("error updating %s %s: %v", table, r.key, err)
+		}
+	}
+	return nil
+}
+
+// userAccounts returns the accounts of userID that have period rows.
+func userAccounts(tx *sql.Tx, userID string) ([]int64, error) {
+	rows, err := tx.Query(`SELECT DISTINCT account_id FROM annual_account_balance WHERE user_id = ?`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error reading accounts: %v", err)
+	}
+	defer rows.Close()
+
+	var accounts []int64
+	for rows.Next() {
+		var account int64
+		if err := rows.Scan(&account); err != nil {
+			return nil, fmt.Errorf("error reading accounts: %v", err)
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts, rows.Err()
+}
+
+// SeedAccountTx copies the movements of method already in the cash_bank
+// tables into account, which must have no movements yet. It hands the
+// history recorded before accounts existed to the account that replaces
+// the cash or bank pair.
+func (l *Ledger) SeedAccountTx(tx *sql.Tx, userID, method string, account int64) error {
+	if method != Cash && method != Bank {
+		return fmt.Errorf("%w: unknown payment method %q", ErrInvalidEntry, method)
+	}
+	for _, p := range periods {
+		_, err := tx.Exec(fmt.Sprintf(`
+			INSERT INTO %s (user_id, account_id, %s, income_amount, expense_amount, bill_amount, adjustment_amount)
+			SELECT user_id, ?, %s, income_%s_amount, expense_%s_amount, bill_%s_amount, adjustment_%s_amount
+			FROM %s
+			WHERE user_id = ?`, p.accountTable(), p.column, p.column, method, method, method, method, p.cashBankTable()),
+			account, userID)
+		if err != nil {
+			return fmt.Errorf("error seeding %s: %v", p.accountTable(), err)
+		}
+		if err := cascadeAccount(tx, userID, account, p, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MoveAccountTx moves every movement of account from one side of the
+// totals to another, e.g. credit cards that were created when cards still
+// posted to the bank side.
+func (l *Ledger) MoveAccountTx(tx *sql.Tx, userID string, account int64, from, to string) error {
+	fromSide, err := side(from)
+	if err != nil {
+		return err
+	}
+	toSide, err := side(to)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range periods {
+		var set []string
+		for _, kind := range []Kind{Income, Expense, Bill, Adjustment} {
+			moved := fmt.Sprintf("(SELECT a.%s_amount FROM %s a WHERE a.user_id = %s.user_id AND a.account_id = ? AND a.%s = %s.%s)",
+				kind, p.accountTable(), p.cashBankTable(), p.column, p.cashBankTable(), p.column)
+			set = append(set,
+				fmt.Sprintf("%s_%s_amount = %s_%s_amount - %s", kind, fromSide, kind, fromSide, moved),
+				fmt.Sprintf("%s_%s_amount = %s_%s_amount + %s", kind, toSide, kind, toSide, moved))
+		}
+		args := make([]interface{}, 0, len(set)+2)
+		for range set {
+			args = append(args, account)
+		}
+		args = append(args, userID, userID, account)
+		_, err := tx.Exec(fmt.Sprintf(`
+			UPDATE %s SET %s, updated_at = CURRENT_TIMESTAMP
+			WHERE user_id = ? AND %s IN (SELECT %s FROM %s WHERE user_id = ? AND account_id = ?)`,
+			p.cashBankTable(), strings.Join(set, ", "), p.column, p.column, p.accountTable()), args...)
+		if err != nil {
+			return fmt.Errorf("error moving account %d in %s: %v", account, p.cashBankTable(), err)
+		}
+		if err := cascade(tx, userID, p, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DropAccountTx removes the period rows of account. It fails with
+// ErrAccountNotEmpty unless every movement of the account has been
+// reversed, so the cash and bank totals lose nothing.
+func (l *Ledger) DropAccountTx(tx *sql.Tx, userID string, account int64) error {
+	var moved int
+	err := tx.QueryRow(`
+		SELECT COUNT(*) FROM annual_account_balance
+		WHERE user_id = ? AND account_id = ?
+		  AND (income_amount != 0 OR expense_amount != 0 OR bill_amount != 0 OR adjustment_amount != 0)`,
+		userID, account).Scan(&moved)
+	if err != nil {
+		return fmt.Errorf("error reading account movements: %v", err)
+	}
+	if moved > 0 {
+		return ErrAccountNotEmpty
+	}
+
+	for _, p := range periods {
+		if _, err := tx.Exec(fmt.Sprintf(`DELETE FROM %s WHERE user_id = ? AND account_id = ?`, p.accountTable()), userID, account); err != nil {
+			return fmt.Errorf("error removing %s rows: %v", p.accountTable(), err)
+		}
+	}
+	return nil
+}
+
+// Balances returns the balance of every account of userID at the end of
+// date (YYYY-MM-DD). Accounts without movements up to date are missing.
+func (l *Ledger) Balances(userID, date string) (map[int64]money.Money, error) {
+	rows, err := l.db.Query(`
+		SELECT account_id, balance FROM daily_account_balance d
+		WHERE user_id = ? AND date = (
+			SELECT MAX(date) FROM daily_account_balance
+			WHERE user_id = d.user_id AND account_id = d.account_id AND date <= ?
+		)`, userID, date)
+	if err != nil {
+		return nil, fmt.Errorf("error reading account balances: %v", err)
+	}
+	defer rows.Close()
+
+	balances := map[int64]money.Money{}
+	for rows.Next() {
+		var account int64
+		var balance money.Money
+		if err := rows.Scan(&account, &balance); err != nil {
+			return nil, fmt.Errorf("error reading account balances: %v", err)
+		}
+		balances[account] = balance
+	}
+	return balances, rows.Err()
+}
+
+// BalanceTx returns the balance of account at the end of date inside the
+// caller's transaction, so it sees the transaction's own movements.
+func (l *Ledger) BalanceTx(tx *sql.Tx, userID string, account int64, date string) (money.Money, error) {
+	var balance money.Money
+	err := tx.QueryRow(`
+		SELECT balance FROM daily_account_balance
+		WHERE user_id = ? AND account_id = ? AND date <= ?
+		ORDER BY date DESC LIMIT 1`, userID, account, date).Scan(&balance)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, fmt.Errorf("error reading account balance: %v", err)
+	}
+	return balance, nil
+}
+
+// LowestBalanceTx returns the lowest balance account has at the end of
+// date or of any later day, inside the caller's transaction. A movement
+// backdated to date changes every later balance too.
+func (l *Ledger) LowestBalanceTx(tx *sql.Tx, userID string, account int64, date string) (money.Money, error) {
+	lowest, err := l.BalanceTx(tx, userID, account, date)
+	if err != nil {
+		return 0, err
+	}
+	var later sql.NullInt64
+	err = tx.QueryRow(`
+		SELECT MIN(balance) FROM daily_account_balance
+		WHERE user_id = ? AND account_id = ? AND date > ?`, userID, account, date).Scan(&later)
+	if err != nil {
+		return 0, fmt.Errorf("error reading account balance: %v", err)
+	}
+	if later.Valid && money.Money(later.Int64) < lowest {
+		lowest = money.Money(later.Int64)
+	}
+	return lowest, nil
+}
@@ -0,0 +1,45 @@
+package ledger
+
+import (
+	"fmt"
+	"time"
+
+	"backend/common/money"
+)
+
+// BillDate is the date a bill's month is posted on: its first day. Paying
+// the month reverses the entry at this date and posts an expense on the
+// payment date.
+func BillDate(yearMonth string) string {
+	return yearMonth + "-01"
+}
+
+// BillEntries returns one Bill entry per unpaid month of a bill that
+// starts on start (YYYY-MM-DD) and runs for months months, charged to
+// account. paid holds the YYYY-MM months already paid, which are expenses
+// instead.
+func BillEntries(userID, method string, account int64, amount money.Money, start string, months int, paid map[string]bool) ([]Entry, error) {
+	startDate, err := parseDate(start)
+	if err != nil {
+		return nil, fmt.Errorf("%w: bill start date %q", ErrInvalidEntry, start)
+	}
+	// Anchor on the first of the month so the 31st never skips February
+	first := time.Date(startDate.Year(), startDate.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	var entries []Entry
+	for i := 0; i < months; i++ {
+		yearMonth := first.AddDate(0, i, 0).Format("2006-01")
+		if paid[yearMonth] {
+			continue
+		}
+		entries = append(entries, Entry{
+			UserID:  userID,
+			Kind:    Bill,
+			Method:  method,
+			Account: account,
+			Amount:  amount,
+			Date:    BillDate(yearMonth),
+		})
+	}
+	return entries, nil
+}
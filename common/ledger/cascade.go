@@ -0,0 +1,107 @@
+package ledger
+
+import (
+	"database/sql"
+	"fmt"
+
+	"backend/common/money"
+)
+
+// movements is one cash_bank row's flows.
+type movements struct {
+	key                                              string
+	incomeCash, incomeBank, incomeCredit             money.Money
+	expenseCash, expenseBank, expenseCredit          money.Money
+	billCash, billBank, billCredit                   money.Money
+	adjustmentCash, adjustmentBank, adjustmentCredit money.Money
+}
+
+// cascade recomputes the running balances of every period of userID from
+// key onwards, starting from the closing balance of the period before it,
+// and mirrors each row into the *_balance table. An empty key starts at
+// the first period.
+func cascade(tx *sql.Tx, userID string, p period, key string) error {
+	table := p.cashBankTable()
+
+	var cash, bank, credit money.Money
+	err := tx.QueryRow(fmt.Sprintf(`
+		SELECT balance_cash_amount, balance_bank_amount, balance_credit_amount FROM %s
+		WHERE user_id = ? AND %s < ?
+		ORDER BY %s DESC LIMIT 1`, table, p.column, p.column),
+		userID, key).Scan(&cash, &bank, &credit)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("error reading opening balance from %s: %v", table, err)
+	}
+
+	rows, err := tx.Query(fmt.Sprintf(`
+		SELECT %s, income_cash_amount, income_bank_amount, income_credit_amount,
+		       expense_cash_amount, expense_bank_amount, expense_credit_amount,
+		       bill_cash_amount, bill_bank_amount, bill_credit_amount,
+		       adjustment_cash_amount, adjustment_bank_amount, adjustment_credit_amount
+		FROM %s
+		WHERE user_id = ? AND %s >= ?
+		ORDER BY %s`, p.column, table, p.column, p.column),
+		userID, key)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %v", table, err)
+	}
+	var periodRows []movements
+	for rows.Next() {
+		var m movements
+		err := rows.Scan(&m.key, &m.incomeCash, &m.incomeBank, &m.incomeCredit,
+			&m.expenseCash, &m.expenseBank, &m.expenseCredit,
+			&m.billCash, &m.billBank, &m.billCredit,
+			&m.adjustmentCash, &m.adjustmentBank, &m.adjustmentCredit)
+		if err != nil {
+			rows.Close()
+			return fmt.Errorf("error reading %s: %v", table, err)
+		}
+		periodRows = append(periodRows, m)
+	}
+	rows.Close()
+
+	for _, m := range periodRows {
+		previousCash, previousBank, previousCredit := cash, bank, credit
+		cash = previousCash + m.incomeCash - m.expenseCash - m.billCash + m.adjustmentCash
+		bank = previousBank + m.incomeBank - m.expenseBank - m.billBank + m.adjustmentBank
+		credit = previousCredit + m.incomeCredit - m.expenseCredit - m.billCredit + m.adjustmentCredit
+
+		_, err := tx.Exec(fmt.Sprintf(`
+			UPDATE %s
+			SET previous_cash_amount = ?, previous_bank_amount = ?, total_previous_balance = ?,
+			    cash_amount = ?, bank_amount = ?, balance_cash_amount = ?, balance_bank_amount = ?,
+			    total_balance = ?, previous_credit_amount = ?, credit_amount = ?, balance_credit_amount = ?,
+			    updated_at = CURRENT_TIMESTAMP
+			WHERE user_id = ? AND %s = ?`, table, p.column),
+			previousCash, previousBank, previousCash+previousBank,
+			cash, bank, cash, bank, cash+bank,
+			previousCredit, credit, credit,
+			userID, m.key)
+		if err != nil {
+			return fmt.Errorf("error updating %s %s: %v", table, m.key, err)
+		}
+
+		// Card charges are spending even though the money is still owed
+		if err := ensureRow(tx, p, p.balanceTable(), userID, m.key); err != nil {
+			return err
+		}
+		_, err = tx.Exec(fmt.Sprintf(`
+			UPDATE %s
+			SET income_amount = ?, expense_amount = ?, bills_amount = ?,
+			    previous_cash_amount = ?, previous_bank_amount = ?, previous_balance = ?, total_previous_balance = ?,
+			    cash_amount = ?, bank_amount = ?, balance_cash_amount = ?, balance_bank_amount = ?,
+			    balance = ?, total_balance = ?, previous_credit_amount = ?, credit_amount = ?, balance_credit_amount = ?,
+			    updated_at = CURRENT_TIMESTAMP
+			WHERE user_id = ? AND %s = ?`, p.balanceTable(), p.column),
+			m.incomeCash+m.incomeBank+m.incomeCredit, m.expenseCash+m.expenseBank+m.expenseCredit,
+			m.billCash+m.billBank+m.billCredit,
+			previousCash, previousBank, previousCash+previousBank, previousCash+previousBank,
+			cash, bank, cash, bank,
+			cash+bank, cash+bank, previousCredit, credit, credit,
+			userID, m.key)
+		if err != nil {
+			return fmt.Errorf("error updating %s %s: %v", p.balanceTable(), m.key, err)
+		}
+	}
+	return nil
+}
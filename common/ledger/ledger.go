@@ -0,0 +1,325 @@
+// Package ledger owns the period balance tables (daily_, weekly_,
+// monthly_, quarterly_, semiannual_ and annual_ *_cash_bank_balance and
+// *_balance). Services describe what happened as entries and post, reverse
+// or amend them; the ledger adds the amounts to the period of the entry
+// date in every granularity and cascades the running balances forward, so
+// all tables always agree.
+//
+// A cash_bank row stores the movements of its period per method and
+// derives the rest:
+//
+//	previous_*  = closing balance of the latest earlier period
+//	*_amount    = previous_* + income - expense - bill + adjustment
+//	total_*     = cash + bank
+//
+// Credit cards run a third side, credit, whose balance is the debt owed
+// on the cards (negative) and stays out of total_*: a charge is spent but
+// the money leaves the bank only when the statement is paid, as a
+// transfer from the bank side to the credit side.
+//
+// The *_balance row for the same period mirrors those numbers with the
+// movements of every method added up. Entries that name an account are also kept per account
+// in the *_account_balance tables, each with its own running balance.
+package ledger
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+
+	"backend/common/money"
+)
+
+// Kind is the type of movement an entry records.
+type Kind string
+
+const (
+	Income  Kind = "income"
+	Expense Kind = "expense"
+	// Bill is an unpaid bill for a month. Paying it reverses the bill and
+	// posts an expense.
+	Bill Kind = "bill"
+	// Adjustment moves money in (positive) or out (negative) of one method
+	// without being income or expense: manual corrections and transfers.
+	Adjustment Kind = "adjustment"
+)
+
+// Payment methods.
+const (
+	Cash = "cash"
+	Bank = "bank"
+	// Credit is the side of credit card accounts: debt, not available
+	// money.
+	Credit = "credit_card"
+)
+
+// ErrInvalidEntry is returned for entries with an unknown kind or method
+// or an unreadable date.
+var ErrInvalidEntry = errors.New("invalid ledger entry")
+
+// Entry is one movement of money.
+type Entry struct {
+	UserID string `json:"user_id"`
+	Kind   Kind   `json:"kind"`
+	Method string `json:"method"`
+	// Account is the id of the user's account the money moved in or out
+	// of, in the cash, bank or credit totals of Method. Zero posts to the totals
+	// only.
+	Account int64       `json:"account_id,omitempty"`
+	Amount  money.Money `json:"amount"`
+	// Date is YYYY-MM-DD.
+	Date string `json:"date"`
+}
+
+// Ledger posts entries to the period tables of one database.
+type Ledger struct {
+	db *sql.DB
+}
+
+// New creates a Ledger, creating the period tables or adding the columns
+// older versions lack, converting REAL amounts to cents and moving legacy
+// period keys to the current format.
+func New(db *sql.DB) (*Ledger, error) {
+	if err := createTables(db); err != nil {
+		return nil, err
+	}
+	l := &Ledger{db: db}
+
+	users, err := migrateKeys(db)
+	if err != nil {
+		return nil, err
+	}
+	for _, userID := range users {
+		log.Printf("Recalculating balances of user %s after migrating period keys", userID)
+		if err := l.Recalculate(userID); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// Post adds entries to the balances.
+func (l *Ledger) Post(entries ...Entry) error {
+	return l.run(func(tx *sql.Tx) error { return apply(tx, entries, nil) })
+}
+
+// Reverse takes entries back out of the balances, e.g. when the
+// transaction that produced them is deleted.
+func (l *Ledger) Reverse(entries ...Entry) error {
+	return l.run(func(tx *sql.Tx) error { return apply(tx, nil, entries) })
+}
+
+// Amend replaces before with after in one step, e.g. when a transaction
+// changes amount, date or method.
+func (l *Ledger) Amend(before, after Entry) error {
+	return l.run(func(tx *sql.Tx) error { return apply(tx, []Entry{after}, []Entry{before}) })
+}
+
+// Replace reverses every entry in before and posts every entry in after in
+// one step, for sources that map to several entries such as a bill's
+// months.
+func (l *Ledger) Replace(before, after []Entry) error {
+	return l.run(func(tx *sql.Tx) error { return apply(tx, after, before) })
+}
+
+// PostTx is Post inside the caller's transaction, so the balances commit or
+// roll back together with the rows that produced the entries.
+func (l *Ledger) PostTx(tx *sql.Tx, entries ...Entry) error {
+	return apply(tx, entries, nil)
+}
+
+// ReverseTx is Reverse inside the caller's transaction.
+func (l *Ledger) ReverseTx(tx *sql.Tx, entries ...Entry) error {
+	return apply(tx, nil, entries)
+}
+
+// AmendTx is Amend inside the caller's transaction.
+func (l *Ledger) AmendTx(tx *sql.Tx, before, after Entry) error {
+	return apply(tx, []Entry{after}, []Entry{before})
+}
+
+// ReplaceTx is Replace inside the caller's transaction.
+func (l *Ledger) ReplaceTx(tx *sql.Tx, before, after []Entry) error {
+	return apply(tx, after, before)
+}
+
+// Recalculate cascades every period of userID and of each of their
+// accounts from the beginning. The movements are left untouched.
+func (l *Ledger) Recalculate(userID string) error {
+	return l.run(func(tx *sql.Tx) error {
+		accounts, err := userAccounts(tx, userID)
+		if err != nil {
+			return err
+		}
+		for _, p := range periods {
+			if err := cascade(tx, userID, p, ""); err != nil {
+				return err
+			}
+			for _, account := range accounts {
+				if err := cascadeAccount(tx, userID, account, p, ""); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// run calls fn in a transaction of its own.
+func (l *Ledger) run(fn func(tx *sql.Tx) error) error {
+	tx, err := l.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting ledger transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// apply reverses and posts entries and cascades every period they touched.
+// On error the transaction holds partial changes; the caller rolls it back.
+func apply(tx *sql.Tx, post, reverse []Entry) error {
+	// Earliest key touched per user, account and period; the cascade
+	// starts there
+	from := map[string][]string{}
+	accountFrom := map[accountKey][]string{}
+	earliest := func(current, keys []string) []string {
+		if current == nil {
+			return append([]string{}, keys...)
+		}
+		for i, key := range keys {
+			if key < current[i] {
+				current[i] = key
+			}
+		}
+		return current
+	}
+	record := func(entries []Entry, sign money.Money) error {
+		for _, e := range entries {
+			keys, err := addMovement(tx, e, sign)
+			if err != nil {
+				return err
+			}
+			from[e.UserID] = earliest(from[e.UserID], keys)
+			if e.Account != 0 {
+				k := accountKey{e.UserID, e.Account}
+				accountFrom[k] = earliest(accountFrom[k], keys)
+			}
+		}
+		return nil
+	}
+	if err := record(reverse, -1); err != nil {
+		return err
+	}
+	if err := record(post, 1); err != nil {
+		return err
+	}
+
+	for userID, keys := range from {
+		for i, p := range periods {
+			if err := cascade(tx, userID, p, keys[i]); err != nil {
+				return err
+			}
+		}
+	}
+	for k, keys := range accountFrom {
+		for i, p := range periods {
+			if err := cascadeAccount(tx, k.userID, k.account, p, keys[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// side returns the column infix of a payment method.
+func side(method string) (string, error) {
+	switch method {
+	case Cash, Bank:
+		return method, nil
+	case Credit:
+		return "credit", nil
+	default:
+		return "", fmt.Errorf("%w: unknown payment method %q", ErrInvalidEntry, method)
+	}
+}
+
+// flowColumn returns the movement column an entry is added to.
+func flowColumn(e Entry) (string, error) {
+	method, err := side(e.Method)
+	if err != nil {
+		return "", err
+	}
+	switch e.Kind {
+	case Income, Expense, Bill, Adjustment:
+		return fmt.Sprintf("%s_%s_amount", e.Kind, method), nil
+	default:
+		return "", fmt.Errorf("%w: unknown kind %q", ErrInvalidEntry, e.Kind)
+	}
+}
+
+// addMovement adds sign*e.Amount to the period of e.Date in every
+// cash_bank table, and account table if e names an account, and returns
+// the keys it touched, one per period.
+func addMovement(tx *sql.Tx, e Entry, sign money.Money) ([]string, error) {
+	column, err := flowColumn(e)
+	if err != nil {
+		return nil, err
+	}
+	date, err := parseDate(e.Date)
+	if err != nil {
+		return nil, fmt.Errorf("%w: date %q", ErrInvalidEntry, e.Date)
+	}
+
+	keys := make([]string, len(periods))
+	for i, p := range periods {
+		keys[i] = p.key(date)
+		if err := ensureRow(tx, p, p.cashBankTable(), e.UserID, keys[i]); err != nil {
+			return nil, err
+		}
+		_, err := tx.Exec(fmt.Sprintf(`
+			UPDATE %s SET %s = %s + ?, updated_at = CURRENT_TIMESTAMP
+			WHERE user_id = ? AND %s = ?`, p.cashBankTable(), column, column, p.column),
+			sign*e.Amount, e.UserID, keys[i])
+		if err != nil {
+			return nil, fmt.Errorf("error posting to %s: %v", p.cashBankTable(), err)
+		}
+		if e.Account != 0 {
+			if err := addAccountMovement(tx, p, e, keys[i], sign); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return keys, nil
+}
+
+// ensureRow inserts an empty row for the period unless one exists. Some of
+// the older tables have no unique constraint, so it checks first instead
+// of relying on INSERT OR IGNORE.
+func ensureRow(tx *sql.Tx, p period, table, userID, key string) error {
+	var exists int
+	err := tx.QueryRow(fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE user_id = ? AND %s = ?`, table, p.column),
+		userID, key).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %v", table, err)
+	}
+	if exists > 0 {
+		return nil
+	}
+
+	if p.name == "weekly" {
+		start, end := weekBounds(key)
+		_, err = tx.Exec(fmt.Sprintf(`INSERT INTO %s (user_id, %s, start_date, end_date) VALUES (?, ?, ?, ?)`, table, p.column),
+			userID, key, start, end)
+	} else {
+		_, err = tx.Exec(fmt.Sprintf(`INSERT INTO %s (user_id, %s) VALUES (?, ?)`, table, p.column), userID, key)
+	}
+	if err != nil {
+		return fmt.Errorf("error creating %s row: %v", table, err)
+	}
+	return nil
+}
@@ -0,0 +1,413 @@
+package ledger
+
+import (
+	"database/sql"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"backend/common/dbtest"
+	"backend/common/money"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func newTestLedger(t *testing.T) *Ledger {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	l, err := New(db)
+	if err != nil {
+		t.Fatalf("Failed to create ledger: %v", err)
+	}
+	return l
+}
+
+type row struct {
+	previousCash, previousBank, cash, bank, total money.Money
+}
+
+func readRow(t *testing.T, l *Ledger, table, column, key string) row {
+	t.Helper()
+	var r row
+	err := l.db.QueryRow(`SELECT previous_cash_amount, previous_bank_amount, balance_cash_amount, balance_bank_amount, total_balance
+		FROM `+table+` WHERE user_id = 'u1' AND `+column+` = ?`, key).
+		Scan(&r.previousCash, &r.previousBank, &r.cash, &r.bank, &r.total)
+	if err != nil {
+		t.Fatalf("Failed to read %s %s: %v", table, key, err)
+	}
+	return r
+}
+
+func TestPostCascadesEveryPeriod(t *testing.T) {
+	l := newTestLedger(t)
+
+	err := l.Post(
+		Entry{UserID: "u1", Kind: Income, Method: Bank, Amount: 1000, Date: "2025-01-10"},
+		Entry{UserID: "u1", Kind: Expense, Method: Cash, Amount: 50, Date: "2025-03-02"},
+	)
+	if err != nil {
+		t.Fatalf("Post failed: %v", err)
+	}
+	// An earlier entry must shift every later period
+	if err := l.Post(Entry{UserID: "u1", Kind: Income, Method: Cash, Amount: 200, Date: "2024-12-31"}); err != nil {
+		t.Fatalf("Post failed: %v", err)
+	}
+
+	want := map[string]struct {
+		column, key string
+		row         row
+	}{
+		"daily_cash_bank_balance":     {"date", "2025-03-02", row{200, 1000, 150, 1000, 1150}},
+		"weekly_cash_bank_balance":    {"year_week", "2025-09", row{200, 1000, 150, 1000, 1150}},
+		"monthly_cash_bank_balance":   {"year_month", "2025-01", row{200, 0, 200, 1000, 1200}},
+		"quarterly_cash_bank_balance": {"year_quarter", "2025-Q1", row{200, 0, 150, 1000, 1150}},
+		"annual_cash_bank_balance":    {"year", "2025", row{200, 0, 150, 1000, 1150}},
+		"semiannual_balance":          {"year_half", "2025-H1", row{200, 0, 150, 1000, 1150}},
+	}
+	for table, w := range want {
+		if got := readRow(t, l, table, w.column, w.key); got != w.row {
+			t.Errorf("%s %s = %+v, want %+v", table, w.key, got, w.row)
+		}
+	}
+}
+
+func TestReverseAndAmendUndoPosts(t *testing.T) {
+	l := newTestLedger(t)
+
+	income := Entry{UserID: "u1", Kind: Income, Method: Bank, Amount: 500, Date: "2025-02-01"}
+	expense := Entry{UserID: "u1", Kind: Expense, Method: Bank, Amount: 100, Date: "2025-02-15"}
+	if err := l.Post(income, expense); err != nil {
+		t.Fatalf("Post failed: %v", err)
+	}
+
+	moved := expense
+	moved.Date, moved.Method, moved.Amount = "2025-04-03", Cash, 80
+	if err := l.Amend(expense, moved); err != nil {
+		t.Fatalf("Amend failed: %v", err)
+	}
+	if got := readRow(t, l, "monthly_cash_bank_balance", "year_month", "2025-02"); got != (row{0, 0, 0, 500, 500}) {
+		t.Errorf("February after amend = %+v", got)
+	}
+	if got := readRow(t, l, "monthly_cash_bank_balance", "year_month", "2025-04"); got != (row{0, 500, -80, 500, 420}) {
+		t.Errorf("April after amend = %+v", got)
+	}
+
+	if err := l.Reverse(income, moved); err != nil {
+		t.Fatalf("Reverse failed: %v", err)
+	}
+	if got := readRow(t, l, "annual_balance", "year", "2025"); got != (row{}) {
+		t.Errorf("2025 after reversing everything = %+v", got)
+	}
+}
+
+func TestBillsAndAdjustments(t *testing.T) {
+	l := newTestLedger(t)
+
+	bill := Entry{UserID: "u1", Kind: Bill, Method: Bank, Amount: 300, Date: "2025-05-01"}
+	err := l.Post(
+		Entry{UserID: "u1", Kind: Adjustment, Method: Cash, Amount: -40, Date: "2025-05-03"},
+		Entry{UserID: "u1", Kind: Adjustment, Method: Bank, Amount: 40, Date: "2025-05-03"},
+		bill,
+	)
+	if err != nil {
+		t.Fatalf("Post failed: %v", err)
+	}
+
+	// Paying the bill turns it into an expense without moving the balance
+	paid := bill
+	paid.Kind, paid.Date = Expense, "2025-05-20"
+	if err := l.Amend(bill, paid); err != nil {
+		t.Fatalf("Amend failed: %v", err)
+	}
+
+	var bills, expenses, balance float64
+	l.db.QueryRow(`SELECT bills_amount, expense_amount, balance FROM monthly_balance WHERE user_id = 'u1' AND year_month = '2025-05'`).
+		Scan(&bills, &expenses, &balance)
+	if bills != 0 || expenses != 300 || balance != -300 {
+		t.Errorf("monthly_balance = bills %v, expenses %v, balance %v", bills, expenses, balance)
+	}
+	if got := readRow(t, l, "daily_cash_bank_balance", "date", "2025-05-20"); got != (row{-40, 40, -40, -260, -300}) {
+		t.Errorf("Payment day = %+v", got)
+	}
+}
+
+func TestAccountsKeepTheirOwnBalances(t *testing.T) {
+	l := newTestLedger(t)
+
+	// Cash posted before accounts existed is handed to account 1
+	if err := l.Post(Entry{UserID: "u1", Kind: Income, Method: Cash, Amount: 100, Date: "2025-01-05"}); err != nil {
+		t.Fatalf("Post failed: %v", err)
+	}
+	tx, _ := l.db.Begin()
+	if err := l.SeedAccountTx(tx, "u1", Cash, 1); err != nil {
+		t.Fatalf("SeedAccountTx failed: %v", err)
+	}
+	tx.Commit()
+
+	err := l.Post(
+		Entry{UserID: "u1", Kind: Income, Method: Bank, Account: 2, Amount: 500, Date: "2025-01-10"},
+		Entry{UserID: "u1", Kind: Expense, Method: Bank, Account: 3, Amount: 80, Date: "2025-02-01"},
+		Entry{UserID: "u1", Kind: Adjustment, Method: Cash, Account: 1, Amount: -30, Date: "2025-02-03"},
+		Entry{UserID: "u1", Kind: Adjustment, Method: Bank, Account: 2, Amount: 30, Date: "2025-02-03"},
+	)
+	if err != nil {
+		t.Fatalf("Post failed: %v", err)
+	}
+
+	got, err := l.Balances("u1", "2025-02-28")
+	want := map[int64]money.Money{1: 70, 2: 530, 3: -80}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Balances = %v, %v; want %v", got, err, want)
+	}
+	if got, _ := l.Balances("u1", "2025-01-31"); !reflect.DeepEqual(got, map[int64]money.Money{1: 100, 2: 500}) {
+		t.Errorf("Balances in January = %v", got)
+	}
+	// The totals still add every account of the method up
+	if got := readRow(t, l, "monthly_cash_bank_balance", "year_month", "2025-02"); got != (row{100, 500, 70, 450, 520}) {
+		t.Errorf("February = %+v", got)
+	}
+
+	tx, _ = l.db.Begin()
+	defer tx.Rollback()
+	if err := l.DropAccountTx(tx, "u1", 2); !errors.Is(err, ErrAccountNotEmpty) {
+		t.Errorf("DropAccountTx with movements = %v, want ErrAccountNotEmpty", err)
+	}
+	l.ReverseTx(tx, Entry{UserID: "u1", Kind: Expense, Method: Bank, Account: 3, Amount: 80, Date: "2025-02-01"})
+	if err := l.DropAccountTx(tx, "u1", 3); err != nil {
+		t.Errorf("DropAccountTx of an emptied account = %v", err)
+	}
+}
+
+func TestCreditCardsAreDebt(t *testing.T) {
+	l := newTestLedger(t)
+
+	err := l.Post(
+		Entry{UserID: "u1", Kind: Income, Method: Bank, Account: 1, Amount: 1000, Date: "2025-01-02"},
+		Entry{UserID: "u1", Kind: Expense, Method: Credit, Account: 2, Amount: 300, Date: "2025-01-10"},
+	)
+	if err != nil {
+		t.Fatalf("Post failed: %v", err)
+	}
+
+	// The charge is spent but the bank still has the money
+	var expense, total, debt money.Money
+	readSummary := func(key string) {
+		t.Helper()
+		err := l.db.QueryRow(`SELECT expense_amount, total_balance, balance_credit_amount FROM monthly_balance
+			WHERE user_id = 'u1' AND year_month = ?`, key).Scan(&expense, &total, &debt)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", key, err)
+		}
+	}
+	readSummary("2025-01")
+	if expense != 300 || total != 1000 || debt != -300 {
+		t.Errorf("January expense %s, total %s, debt %s; want 3.00, 10.00, -3.00", expense, total, debt)
+	}
+
+	// Paying the statement moves the money from the bank to the card
+	err = l.Post(
+		Entry{UserID: "u1", Kind: Adjustment, Method: Bank, Account: 1, Amount: -300, Date: "2025-02-05"},
+		Entry{UserID: "u1", Kind: Adjustment, Method: Credit, Account: 2, Amount: 300, Date: "2025-02-05"},
+	)
+	if err != nil {
+		t.Fatalf("Post failed: %v", err)
+	}
+	readSummary("2025-02")
+	if expense != 0 || total != 700 || debt != 0 {
+		t.Errorf("February expense %s, total %s, debt %s; want 0, 7.00, 0", expense, total, debt)
+	}
+
+	// A card that posted to the bank side moves to the credit side
+	if err := l.Post(Entry{UserID: "u1", Kind: Expense, Method: Bank, Account: 3, Amount: 50, Date: "2025-01-20"}); err != nil {
+		t.Fatalf("Post failed: %v", err)
+	}
+	tx, _ := l.db.Begin()
+	if err := l.MoveAccountTx(tx, "u1", 3, Bank, Credit); err != nil {
+		t.Fatalf("MoveAccountTx failed: %v", err)
+	}
+	tx.Commit()
+	readSummary("2025-02")
+	if total != 700 || debt != -50 {
+		t.Errorf("After moving, total %s, debt %s; want 7.00, -0.50", total, debt)
+	}
+	if got := readRow(t, l, "daily_cash_bank_balance", "date", "2025-01-20"); got.bank != 1000 {
+		t.Errorf("Bank after moving = %s, want 10.00", got.bank)
+	}
+}
+
+func TestInvalidEntryChangesNothing(t *testing.T) {
+	l := newTestLedger(t)
+
+	err := l.Post(
+		Entry{UserID: "u1", Kind: Income, Method: Bank, Amount: 10, Date: "2025-01-01"},
+		Entry{UserID: "u1", Kind: Income, Method: "card", Amount: 10, Date: "2025-01-01"},
+	)
+	if !errors.Is(err, ErrInvalidEntry) {
+		t.Fatalf("Expected ErrInvalidEntry, got %v", err)
+	}
+
+	var count int
+	l.db.QueryRow(`SELECT COUNT(*) FROM daily_cash_bank_balance`).Scan(&count)
+	if count != 0 {
+		t.Errorf("Expected the failed post to be rolled back, found %d rows", count)
+	}
+}
+
+func TestFaultInCascadeChangesNothing(t *testing.T) {
+	l := newTestLedger(t)
+	if err := l.Post(Entry{UserID: "u1", Kind: Income, Method: Cash, Amount: 100, Date: "2025-01-05"}); err != nil {
+		t.Fatalf("Post failed: %v", err)
+	}
+	before := dbtest.Snapshot(t, l.db)
+
+	// The annual mirror is the last table the cascade writes
+	dbtest.FailOn(t, l.db, "annual_balance", "UPDATE")
+	err := l.Post(Entry{UserID: "u1", Kind: Expense, Method: Cash, Amount: 30, Date: "2025-02-01"})
+	if err == nil || !strings.Contains(err.Error(), dbtest.Fault) {
+		t.Fatalf("Expected the injected fault, got %v", err)
+	}
+	if after := dbtest.Snapshot(t, l.db); !reflect.DeepEqual(before, after) {
+		t.Errorf("Failed post left partial state behind")
+	}
+}
+
+func TestPostTxFollowsCallerTransaction(t *testing.T) {
+	l := newTestLedger(t)
+	l.db.Exec(`CREATE TABLE movements (id INTEGER PRIMARY KEY, amount REAL)`)
+	before := dbtest.Snapshot(t, l.db)
+
+	entry := Entry{UserID: "u1", Kind: Income, Method: Bank, Amount: 40, Date: "2025-03-03"}
+	tx, err := l.db.Begin()
+	if err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	tx.Exec(`INSERT INTO movements (amount) VALUES (40)`)
+	if err := l.PostTx(tx, entry); err != nil {
+		t.Fatalf("PostTx failed: %v", err)
+	}
+	tx.Rollback()
+
+	if after := dbtest.Snapshot(t, l.db); !reflect.DeepEqual(before, after) {
+		t.Fatalf("Rolled back transaction left ledger rows behind")
+	}
+
+	tx, _ = l.db.Begin()
+	if err := l.PostTx(tx, entry); err != nil {
+		t.Fatalf("PostTx failed: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if got := readRow(t, l, "annual_cash_bank_balance", "year", "2025"); got != (row{0, 0, 0, 40, 40}) {
+		t.Errorf("2025 after commit = %+v", got)
+	}
+}
+
+func TestNewMigratesLegacyKeys(t *testing.T) {
+	l := newTestLedger(t)
+
+	l.db.Exec(`INSERT INTO weekly_balance (user_id, year_week, start_date, end_date, expense_amount) VALUES ('u1', '2025-W03', '', '', 10)`)
+	l.db.Exec(`INSERT INTO quarterly_cash_bank_balance (user_id, year_quarter, income_cash_amount) VALUES ('u1', '2025-2', 70)`)
+
+	if _, err := New(l.db); err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	var weeks int
+	l.db.QueryRow(`SELECT COUNT(*) FROM weekly_balance WHERE year_week = '2025-03'`).Scan(&weeks)
+	if weeks != 1 {
+		t.Errorf("Expected the legacy week to be renamed, found %d rows", weeks)
+	}
+	if got := readRow(t, l, "quarterly_cash_bank_balance", "year_quarter", "2025-Q2"); got != (row{0, 0, 70, 0, 70}) {
+		t.Errorf("Migrated quarter = %+v", got)
+	}
+}
+
+func TestNewMergesCollidingLegacyKeys(t *testing.T) {
+	l := newTestLedger(t)
+
+	// Expenses wrote the week as 2025-W03 while incomes already used 2025-03
+	l.db.Exec(`INSERT INTO weekly_cash_bank_balance (user_id, year_week, expense_cash_amount) VALUES ('u1', '2025-W03', 10)`)
+	l.db.Exec(`INSERT INTO weekly_cash_bank_balance (user_id, year_week, income_cash_amount, income_bank_amount) VALUES ('u1', '2025-03', 50, 20)`)
+	l.db.Exec(`INSERT INTO weekly_balance (user_id, year_week, start_date, end_date, expense_amount) VALUES ('u1', '2025-W03', '', '', 10)`)
+	l.db.Exec(`INSERT INTO weekly_balance (user_id, year_week, start_date, end_date, income_amount) VALUES ('u1', '2025-03', '', '', 70)`)
+
+	if _, err := New(l.db); err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	var legacy int
+	l.db.QueryRow(`SELECT (SELECT COUNT(*) FROM weekly_cash_bank_balance WHERE year_week = '2025-W03') +
+		(SELECT COUNT(*) FROM weekly_balance WHERE year_week = '2025-W03')`).Scan(&legacy)
+	if legacy != 0 {
+		t.Errorf("%d legacy rows left", legacy)
+	}
+	if got := readRow(t, l, "weekly_cash_bank_balance", "year_week", "2025-03"); got != (row{0, 0, 40, 20, 60}) {
+		t.Errorf("Merged week = %+v", got)
+	}
+	var income, expense, balance money.Money
+	l.db.QueryRow(`SELECT income_amount, expense_amount, balance FROM weekly_balance WHERE user_id = 'u1' AND year_week = '2025-03'`).
+		Scan(&income, &expense, &balance)
+	if income != 70 || expense != 10 || balance != 60 {
+		t.Errorf("Merged week summary = %v, %v, %v", income, expense, balance)
+	}
+}
+
+func TestNewConvertsRealAmountsToCents(t *testing.T) {
+	db := dbtest.Open(t)
+	_, err := db.Exec(`CREATE TABLE monthly_cash_bank_balance (
+		id INTEGER PRIMARY KEY AUTOINCREMENT, user_id TEXT NOT NULL, year_month TEXT NOT NULL,
+		income_cash_amount REAL NOT NULL DEFAULT 0, balance_cash_amount REAL NOT NULL DEFAULT 0, total_balance REAL NOT NULL DEFAULT 0,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP)`)
+	if err != nil {
+		t.Fatalf("Failed to create legacy table: %v", err)
+	}
+	db.Exec(`INSERT INTO monthly_cash_bank_balance (user_id, year_month, income_cash_amount, balance_cash_amount, total_balance)
+		VALUES ('u1', '2025-01', 0.1, 0.1, 0.1)`)
+
+	l, err := New(db)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if err := l.Post(Entry{UserID: "u1", Kind: Income, Method: Cash, Amount: money.FromFloat(0.2), Date: "2025-01-20"}); err != nil {
+		t.Fatalf("Post failed: %v", err)
+	}
+
+	var kind string
+	db.QueryRow(`SELECT type FROM pragma_table_info('monthly_cash_bank_balance') WHERE name = 'total_balance'`).Scan(&kind)
+	if kind != "INTEGER" {
+		t.Errorf("total_balance is %s, want INTEGER", kind)
+	}
+	if got := readRow(t, l, "monthly_cash_bank_balance", "year_month", "2025-01"); got != (row{0, 0, 30, 0, 30}) {
+		t.Errorf("January = %+v, want 0.30 in cash", got)
+	}
+}
+
+func TestWeekBounds(t *testing.T) {
+	for key, want := range map[string][2]string{
+		"2025-01": {"2024-12-30", "2025-01-05"},
+		"2026-53": {"2026-12-28", "2027-01-03"},
+	} {
+		if start, end := weekBounds(key); start != want[0] || end != want[1] {
+			t.Errorf("weekBounds(%s) = %s..%s, want %s..%s", key, start, end, want[0], want[1])
+		}
+	}
+}
+
+func TestBillEntriesSkipPaidMonths(t *testing.T) {
+	entries, err := BillEntries("u1", Cash, 0, 40, "2025-01-31", 3, map[string]bool{"2025-02": true})
+	if err != nil {
+		t.Fatalf("BillEntries failed: %v", err)
+	}
+	if len(entries) != 2 || entries[0].Date != "2025-01-01" || entries[1].Date != "2025-03-01" {
+		t.Errorf("Unexpected bill entries: %+v", entries)
+	}
+}
@@ -0,0 +1,63 @@
+package ledger
+
+import (
+	"fmt"
+	"time"
+)
+
+// period describes one granularity of the balance tables. Keys sort
+// chronologically as plain strings, which the cascade relies on.
+type period struct {
+	name   string
+	column string
+	key    func(t time.Time) string
+}
+
+// periods lists every granularity, finest first. Each one has a
+// <name>_cash_bank_balance table, a <name>_balance mirror and a
+// <name>_account_balance table with one row per account.
+var periods = []period{
+	{"daily", "date", func(t time.Time) string { return t.Format("2006-01-02") }},
+	{"weekly", "year_week", weekKey},
+	{"monthly", "year_month", func(t time.Time) string { return t.Format("2006-01") }},
+	{"quarterly", "year_quarter", func(t time.Time) string {
+		return fmt.Sprintf("%d-Q%d", t.Year(), (int(t.Month())-1)/3+1)
+	}},
+	{"semiannual", "year_half", func(t time.Time) string {
+		return fmt.Sprintf("%d-H%d", t.Year(), (int(t.Month())-1)/6+1)
+	}},
+	{"annual", "year", func(t time.Time) string { return t.Format("2006") }},
+}
+
+func (p period) cashBankTable() string { return p.name + "_cash_bank_balance" }
+func (p period) balanceTable() string  { return p.name + "_balance" }
+func (p period) accountTable() string  { return p.name + "_account_balance" }
+
+// weekKey is the ISO week, e.g. 2025-07. It is the format budget_overview
+// reads.
+func weekKey(t time.Time) string {
+	year, week := t.ISOWeek()
+	return fmt.Sprintf("%d-%02d", year, week)
+}
+
+// weekBounds returns the Monday and Sunday of an ISO week key, which the
+// weekly tables store in start_date and end_date.
+func weekBounds(key string) (string, string) {
+	var year, week int
+	if _, err := fmt.Sscanf(key, "%d-%d", &year, &week); err != nil {
+		return "", ""
+	}
+	// January 4th always falls in ISO week 1
+	jan4 := time.Date(year, time.January, 4, 0, 0, 0, 0, time.UTC)
+	monday := jan4.AddDate(0, 0, -((int(jan4.Weekday())+6)%7)+(week-1)*7)
+	return monday.Format("2006-01-02"), monday.AddDate(0, 0, 6).Format("2006-01-02")
+}
+
+// parseDate accepts YYYY-MM-DD, optionally followed by a time part as
+// stored by some of the older rows.
+func parseDate(s string) (time.Time, error) {
+	if len(s) > 10 {
+		s = s[:10]
+	}
+	return time.Parse("2006-01-02", s)
+}
@@ -0,0 +1,156 @@
+package ledger
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"backend/common/money"
+)
+
+// Cell is one amount of a user's period row: a column of the row of Table
+// at Key, for Account in the *_account_balance tables.
+type Cell struct {
+	Table   string
+	Key     string
+	Account int64
+	Column  string
+}
+
+// Snapshot holds every amount of a user's period rows.
+type Snapshot map[Cell]money.Money
+
+// SnapshotTx reads every period row of userID inside the caller's
+// transaction.
+func (l *Ledger) SnapshotTx(tx *sql.Tx, userID string) (Snapshot, error) {
+	snapshot := Snapshot{}
+	for _, p := range periods {
+		cashBank := append(append([]string{}, flowColumns...), balanceColumns...)
+		if err := readCells(tx, snapshot, p, p.cashBankTable(), cashBank, userID); err != nil {
+			return nil, err
+		}
+		balance := append(append([]string{}, summaryColumns...), balanceColumns...)
+		if err := readCells(tx, snapshot, p, p.balanceTable(), balance, userID); err != nil {
+			return nil, err
+		}
+		account := append(append([]string{}, accountFlowColumns...), "previous_balance", "balance")
+		if err := readCells(tx, snapshot, p, p.accountTable(), account, userID); err != nil {
+			return nil, err
+		}
+	}
+	return snapshot, nil
+}
+
+func readCells(tx *sql.Tx, snapshot Snapshot, p period, table string, columns []string, userID string) error {
+	accountColumn := "0"
+	if table == p.accountTable() {
+		accountColumn = "account_id"
+	}
+	rows, err := tx.Query(fmt.Sprintf(`SELECT %s, %s, %s FROM %s WHERE user_id = ?`,
+		p.column, accountColumn, strings.Join(columns, ", "), table), userID)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %v", table, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key string
+		var account int64
+		amounts := make([]money.Money, len(columns))
+		dest := []interface{}{&key, &account}
+		for i := range amounts {
+			dest = append(dest, &amounts[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return fmt.Errorf("error reading %s: %v", table, err)
+		}
+		for i, column := range columns {
+			snapshot[Cell{Table: table, Key: key, Account: account, Column: column}] = amounts[i]
+		}
+	}
+	return rows.Err()
+}
+
+// AdjustmentsTx returns the adjustments of every account of userID per
+// day, as posted so far: account id, then date, then amount.
+func (l *Ledger) AdjustmentsTx(tx *sql.Tx, userID string) (map[int64]map[string]money.Money, error) {
+	rows, err := tx.Query(`
+		SELECT account_id, date, adjustment_amount FROM daily_account_balance
+		WHERE user_id = ? AND adjustment_amount != 0`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error reading account adjustments: %v", err)
+	}
+	defer rows.Close()
+
+	adjustments := map[int64]map[string]money.Money{}
+	for rows.Next() {
+		var account int64
+		var date string
+		var amount money.Money
+		if err := rows.Scan(&account, &date, &amount); err != nil {
+			return nil, fmt.Errorf("error reading account adjustments: %v", err)
+		}
+		if adjustments[account] == nil {
+			adjustments[account] = map[string]money.Money{}
+		}
+		adjustments[account][date] = amount
+	}
+	return adjustments, rows.Err()
+}
+
+// RebuildTx replaces every movement of userID with entries and cascades
+// all periods from the beginning, inside the caller's transaction. Period
+// rows are kept, so periods without movements still carry their running
+// balances; *_balance rows without a cash_bank row, which nothing
+// updates, are removed.
+func (l *Ledger) RebuildTx(tx *sql.Tx, userID string, entries []Entry) error {
+	zero := func(columns []string) string {
+		set := make([]string, len(columns))
+		for i, c := range columns {
+			set[i] = c + " = 0"
+		}
+		return strings.Join(set, ", ")
+	}
+	for _, p := range periods {
+		_, err := tx.Exec(fmt.Sprintf(`UPDATE %s SET %s, updated_at = CURRENT_TIMESTAMP WHERE user_id = ?`,
+			p.cashBankTable(), zero(flowColumns)), userID)
+		if err != nil {
+			return fmt.Errorf("error clearing %s: %v", p.cashBankTable(), err)
+		}
+		_, err = tx.Exec(fmt.Sprintf(`UPDATE %s SET %s, updated_at = CURRENT_TIMESTAMP WHERE user_id = ?`,
+			p.accountTable(), zero(accountFlowColumns)), userID)
+		if err != nil {
+			return fmt.Errorf("error clearing %s: %v", p.accountTable(), err)
+		}
+		_, err = tx.Exec(fmt.Sprintf(`DELETE FROM %s WHERE user_id = ? AND %s NOT IN (SELECT %s FROM %s WHERE user_id = ?)`,
+			p.balanceTable(), p.column, p.column, p.cashBankTable()), userID, userID)
+		if err != nil {
+			return fmt.Errorf("error removing orphan rows of %s: %v", p.balanceTable(), err)
+		}
+	}
+
+	for _, e := range entries {
+		if e.UserID != userID {
+			return fmt.Errorf("%w: entry of user %s in the rebuild of user %s", ErrInvalidEntry, e.UserID, userID)
+		}
+		if _, err := addMovement(tx, e, 1); err != nil {
+			return err
+		}
+	}
+
+	accounts, err := userAccounts(tx, userID)
+	if err != nil {
+		return err
+	}
+	for _, p := range periods {
+		if err := cascade(tx, userID, p, ""); err != nil {
+			return err
+		}
+		for _, account := range accounts {
+			if err := cascadeAccount(tx, userID, account, p, ""); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+package ledger
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"backend/common/money"
+)
+
+// flowColumns are the per-method movements posted by entries. Every other
+// amount in a cash_bank row is derived from them by the cascade.
+var flowColumns = []string{
+	"income_cash_amount", "income_bank_amount",
+	"expense_cash_amount", "expense_bank_amount",
+	"bill_cash_amount", "bill_bank_amount",
+	"adjustment_cash_amount", "adjustment_bank_amount",
+	"income_credit_amount", "expense_credit_amount", "bill_credit_amount", "adjustment_credit_amount",
+}
+
+// balanceColumns are the derived running balances, present in both table
+// families.
+var balanceColumns = []string{
+	"cash_amount", "bank_amount",
+	"previous_cash_amount", "previous_bank_amount",
+	"balance_cash_amount", "balance_bank_amount",
+	"total_previous_balance", "total_balance",
+	"credit_amount", "previous_credit_amount", "balance_credit_amount",
+}
+
+// summaryColumns only exist in the *_balance tables, which aggregate both
+// methods.
+var summaryColumns = []string{
+	"income_amount", "expense_amount", "bills_amount", "balance", "previous_balance",
+}
+
+// createTables creates the period tables, adds any column an older
+// service-created version is missing and converts REAL amounts to cents.
+func createTables(db *sql.DB) error {
+	for _, p := range periods {
+		if err := createTable(db, p, p.cashBankTable(), flowColumns); err != nil {
+			return err
+		}
+		if err := createTable(db, p, p.balanceTable(), summaryColumns); err != nil {
+			return err
+		}
+		cashBank := append(append([]string{}, flowColumns...), balanceColumns...)
+		if err := money.Convert(db, p.cashBankTable(), cashBank...); err != nil {
+			return err
+		}
+		balance := append(append([]string{}, summaryColumns...), balanceColumns...)
+		if err := money.Convert(db, p.balanceTable(), balance...); err != nil {
+			return err
+		}
+		if err := createAccountTable(db, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func createTable(db *sql.DB, p period, table string, amounts []string) error {
+	columns := []string{
+		"id INTEGER PRIMARY KEY AUTOINCREMENT",
+		"user_id TEXT NOT NULL",
+		p.column + " TEXT NOT NULL",
+	}
+	extra := map[string]string{}
+	if p.name == "weekly" {
+		extra["start_date"] = "TEXT NOT NULL DEFAULT ''"
+		extra["end_date"] = "TEXT NOT NULL DEFAULT ''"
+		columns = append(columns, "start_date "+extra["start_date"], "end_date "+extra["end_date"])
+	}
+	for _, c := range append(append([]string{}, amounts...), balanceColumns...) {
+		extra[c] = "INTEGER NOT NULL DEFAULT 0"
+		columns = append(columns, c+" "+extra[c])
+	}
+	columns = append(columns,
+		"created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP",
+		"updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP",
+		fmt.Sprintf("UNIQUE(user_id, %s)", p.column),
+	)
+
+	_, err := db.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (\n\t%s\n)", table, strings.Join(columns, ",\n\t")))
+	if err != nil {
+		return fmt.Errorf("error creating %s table: %v", table, err)
+	}
+
+	existing, err := tableColumns(db, table)
+	if err != nil {
+		return err
+	}
+	for column, definition := range extra {
+		if existing[column] {
+			continue
+		}
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)); err != nil {
+			return fmt.Errorf("error adding %s to %s: %v", column, table, err)
+		}
+	}
+
+	_, err = db.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS idx_%s_user_period ON %s(user_id, %s)", table, table, p.column))
+	if err != nil {
+		return fmt.Errorf("error creating index on %s: %v", table, err)
+	}
+	return nil
+}
+
+func tableColumns(db *sql.DB, table string) (map[string]bool, error) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return nil, fmt.Errorf("error reading columns of %s: %v", table, err)
+	}
+	defer rows.Close()
+
+	columns := map[string]bool{}
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, kind string
+		var def sql.NullString
+		if err := rows.Scan(&cid, &name, &kind, &notNull, &def, &pk); err != nil {
+			return nil, fmt.Errorf("error reading columns of %s: %v", table, err)
+		}
+		columns[name] = true
+	}
+	return columns, rows.Err()
+}
+
+// legacyKeys are the period formats written by the per-service code this
+// package replaced: 2025-W07 for weeks, 2025-1 for quarters and halves.
+var legacyKeys = map[string]struct{ like, from, to string }{
+	"weekly":     {"____-W__", "-W", "-"},
+	"quarterly":  {"____-_", "-", "-Q"},
+	"semiannual": {"____-_", "-", "-H"},
+}
+
+// migrateKeys rewrites legacy period keys into the current format, in one
+// transaction, and returns the users whose rows changed so their balances
+// can be cascaded again. Services used to write the same period in both
+// formats, e.g. expenses in 2025-W07 and incomes in 2025-07, so a legacy
+// cash_bank row whose new key already exists has its flows added to that
+// row before it is deleted. The *_balance rows are derived from cash_bank
+// by the cascade, so colliding ones are just deleted.
+func migrateKeys(db *sql.DB) ([]string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("error starting key migration: %v", err)
+	}
+	defer tx.Rollback()
+
+	users := map[string]bool{}
+	for _, p := range periods {
+		legacy, ok := legacyKeys[p.name]
+		if !ok {
+			continue
+		}
+		for _, table := range []string{p.cashBankTable(), p.balanceTable()} {
+			where := fmt.Sprintf("%s LIKE '%s'", p.column, legacy.like)
+			rows, err := tx.Query(fmt.Sprintf("SELECT DISTINCT user_id FROM %s WHERE %s", table, where))
+			if err != nil {
+				return nil, fmt.Errorf("error reading legacy keys of %s: %v", table, err)
+			}
+			for rows.Next() {
+				var userID string
+				if err := rows.Scan(&userID); err != nil {
+					rows.Close()
+					return nil, fmt.Errorf("error reading legacy keys of %s: %v", table, err)
+				}
+				users[userID] = true
+			}
+			rows.Close()
+
+			// legacyRow matches the legacy row of the row being updated
+			migrated := fmt.Sprintf("replace(legacy.%s, '%s', '%s')", p.column, legacy.from, legacy.to)
+			legacyRow := fmt.Sprintf("FROM %s AS legacy WHERE legacy.user_id = %s.user_id AND legacy.%s LIKE '%s' AND %s = %s.%s",
+				table, table, p.column, legacy.like, migrated, table, p.column)
+			if table == p.cashBankTable() {
+				sums := make([]string, len(flowColumns))
+				for i, c := range flowColumns {
+					sums[i] = fmt.Sprintf("%s = %s + (SELECT legacy.%s %s)", c, c, c, legacyRow)
+				}
+				_, err = tx.Exec(fmt.Sprintf("UPDATE %s SET %s WHERE EXISTS (SELECT 1 %s)", table, strings.Join(sums, ", "), legacyRow))
+				if err != nil {
+					return nil, fmt.Errorf("error merging legacy keys of %s: %v", table, err)
+				}
+			}
+			// Delete the legacy rows that were merged, then rename the rest
+			_, err = tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE %s AND EXISTS (SELECT 1 FROM %s AS current WHERE current.user_id = %s.user_id AND current.%s = replace(%s.%s, '%s', '%s'))",
+				table, where, table, table, p.column, table, p.column, legacy.from, legacy.to))
+			if err != nil {
+				return nil, fmt.Errorf("error removing legacy keys of %s: %v", table, err)
+			}
+			_, err = tx.Exec(fmt.Sprintf("UPDATE %s SET %s = replace(%s, '%s', '%s') WHERE %s",
+				table, p.column, p.column, legacy.from, legacy.to, where))
+			if err != nil {
+				return nil, fmt.Errorf("error migrating keys of %s: %v", table, err)
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing key migration: %v", err)
+	}
+
+	var changed []string
+	for userID := range users {
+		changed = append(changed, userID)
+	}
+	return changed, nil
+}
@@ -0,0 +1,371 @@
+// Package listing pages, filters and sorts the lists of transactions the
+// services return.
+//
+// A list is a query whose rows have the columns id, type, date,
+// category, payment_method and base_amount; Query.Run filters it, counts
+// every row that matches and returns one page in the order asked for.
+// Pages are continued with the opaque cursor of the previous one, which
+// holds the sort value, type and id of its last row, so rows added or
+// deleted meanwhile do not shift the next page the way offsets do.
+//
+// Tag filters read the tags and transaction_tags tables of package tag.
+package listing
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/common/money"
+)
+
+// ErrInvalid is returned for queries with a bad filter, sort or cursor.
+var ErrInvalid = errors.New("invalid list query")
+
+// Page sizes.
+const (
+	DefaultLimit = 100
+	MaxLimit     = 1000
+)
+
+// Sort fields and orders, and how tag filters match.
+const (
+	ByDate     = "date"
+	ByAmount   = "amount"
+	Ascending  = "asc"
+	Descending = "desc"
+	AllTags    = "all"
+	AnyTag     = "any"
+)
+
+// Query is what a client asks of a list. Every filter is optional.
+type Query struct {
+	StartDate string `json:"start_date,omitempty"` // YYYY-MM-DD, inclusive
+	EndDate   string `json:"end_date,omitempty"`   // YYYY-MM-DD, inclusive
+	// Types are income, expense or transfer.
+	Types          []string `json:"transaction_types,omitempty"`
+	Categories     []string `json:"categories,omitempty"`
+	PaymentMethods []string `json:"payment_methods,omitempty"`
+	// MinAmount and MaxAmount bound the amount in the base currency.
+	MinAmount *money.Money `json:"min_amount,omitempty"`
+	MaxAmount *money.Money `json:"max_amount,omitempty"`
+	// Tags are names of tags, which rows must all have, or any of them
+	// with TagMatch any.
+	Tags     []string `json:"tags,omitempty"`
+	TagMatch string   `json:"tag_match,omitempty"`
+	// Sort is date (the default) or amount and Order desc (the default)
+	// or asc. Ties are broken by type and id.
+	Sort  string `json:"sort,omitempty"`
+	Order string `json:"order,omitempty"`
+	Limit int    `json:"limit,omitempty"`
+	// Cursor continues the list after the page that returned it. Offset
+	// is kept for older clients and ignored with a cursor.
+	Cursor string `json:"cursor,omitempty"`
+	Offset int    `json:"offset,omitempty"`
+}
+
+// Page is how a page relates to the whole list.
+type Page struct {
+	// Total is the number of rows that match the filters, in every page.
+	Total      int    `json:"total"`
+	Limit      int    `json:"limit"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	HasMore    bool   `json:"has_more"`
+}
+
+// Key identifies a row of a list and its place in it.
+type Key struct {
+	Type   string
+	ID     int64
+	Date   string
+	Amount money.Money
+}
+
+// cursor is the content of Query.Cursor.
+type cursor struct {
+	Sort  string `json:"s"`
+	Order string `json:"o"`
+	Date  string `json:"d,omitempty"`
+	// Amount is in cents
+	Amount int64  `json:"a,omitempty"`
+	Type   string `json:"t"`
+	ID     int64  `json:"i"`
+}
+
+// FromValues reads a query from URL parameters with the names of the
+// JSON fields. Lists are comma-separated.
+func FromValues(values url.Values) (Query, error) {
+	q := Query{
+		StartDate: values.Get("start_date"),
+		EndDate:   values.Get("end_date"),
+		Sort:      values.Get("sort"),
+		Order:     values.Get("order"),
+		Cursor:    values.Get("cursor"),
+		TagMatch:  values.Get("tag_match"),
+	}
+	for _, list := range []struct {
+		name   string
+		values *[]string
+	}{{"transaction_types", &q.Types}, {"categories", &q.Categories}, {"payment_methods", &q.PaymentMethods}, {"tags", &q.Tags}} {
+		if v := values.Get(list.name); v != "" {
+			*list.values = strings.Split(v, ",")
+		}
+	}
+	for _, amount := range []struct {
+		name  string
+		value **money.Money
+	}{{"min_amount", &q.MinAmount}, {"max_amount", &q.MaxAmount}} {
+		if v := values.Get(amount.name); v != "" {
+			parsed, err := money.Parse(v)
+			if err != nil {
+				return q, fmt.Errorf("%w: %s: %v", ErrInvalid, amount.name, err)
+			}
+			*amount.value = &parsed
+		}
+	}
+	for _, number := range []struct {
+		name  string
+		value *int
+	}{{"limit", &q.Limit}, {"offset", &q.Offset}} {
+		if v := values.Get(number.name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return q, fmt.Errorf("%w: %s must be a number", ErrInvalid, number.name)
+			}
+			*number.value = n
+		}
+	}
+	return q, nil
+}
+
+// Normalize fills in the defaults of q and checks it.
+func (q *Query) Normalize() error {
+	if q.Limit <= 0 {
+		q.Limit = DefaultLimit
+	}
+	if q.Limit > MaxLimit {
+		q.Limit = MaxLimit
+	}
+	if q.Offset < 0 {
+		q.Offset = 0
+	}
+	if q.Sort == "" {
+		q.Sort = ByDate
+	}
+	if q.Order == "" {
+		q.Order = Descending
+	}
+	if q.TagMatch == "" {
+		q.TagMatch = AllTags
+	}
+	q.Sort, q.Order, q.TagMatch = strings.ToLower(q.Sort), strings.ToLower(q.Order), strings.ToLower(q.TagMatch)
+	if q.Sort != ByDate && q.Sort != ByAmount {
+		return fmt.Errorf("%w: sort must be date or amount", ErrInvalid)
+	}
+	if q.Order != Ascending && q.Order != Descending {
+		return fmt.Errorf("%w: order must be asc or desc", ErrInvalid)
+	}
+	if q.TagMatch != AllTags && q.TagMatch != AnyTag {
+		return fmt.Errorf("%w: tag_match must be all or any", ErrInvalid)
+	}
+	for _, date := range []string{q.StartDate, q.EndDate} {
+		if _, err := time.Parse("2006-01-02", date); date != "" && err != nil {
+			return fmt.Errorf("%w: dates must be YYYY-MM-DD", ErrInvalid)
+		}
+	}
+	if q.MinAmount != nil && q.MaxAmount != nil && *q.MinAmount > *q.MaxAmount {
+		return fmt.Errorf("%w: min_amount is greater than max_amount", ErrInvalid)
+	}
+	_, err := q.cursor()
+	return err
+}
+
+// Has reports whether the Types filter lets rows of type through.
+func (q Query) Has(kind string) bool {
+	if len(q.Types) == 0 {
+		return true
+	}
+	for _, t := range q.Types {
+		if strings.EqualFold(strings.TrimSpace(t), kind) {
+			return true
+		}
+	}
+	return false
+}
+
+// querier is what *sql.DB and *sql.Tx have in common for reads.
+type querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// Run lists from, a table or a subquery in parentheses taking args. scan
+// reads each row of the page, in the order of the columns of from, and
+// returns its key. q must be normalized.
+func (q Query) Run(db querier, from string, args []interface{}, scan func(rows *sql.Rows) (Key, error)) (Page, error) {
+	page := Page{Limit: q.Limit}
+	where, whereArgs := q.where()
+	filterArgs := append(append([]interface{}{}, args...), whereArgs...)
+
+	err := db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s AS listed WHERE %s", from, where), filterArgs...).Scan(&page.Total)
+	if err != nil {
+		return page, fmt.Errorf("error counting list: %v", err)
+	}
+
+	c, err := q.cursor()
+	if err != nil {
+		return page, err
+	}
+	column, compare, direction := "date", "<", "DESC"
+	if q.Sort == ByAmount {
+		column = "base_amount"
+	}
+	if q.Order == Ascending {
+		compare, direction = ">", "ASC"
+	}
+	pageArgs := filterArgs
+	if c != nil {
+		where += fmt.Sprintf(" AND (%s, type, id) %s (?, ?, ?)", column, compare)
+		var value interface{} = c.Date
+		if q.Sort == ByAmount {
+			value = c.Amount
+		}
+		pageArgs = append(pageArgs, value, c.Type, c.ID)
+	}
+	// One row more than the page tells whether there is another one
+	statement := fmt.Sprintf("SELECT * FROM %s AS listed WHERE %s ORDER BY %s %s, type %s, id %s LIMIT ?",
+		from, where, column, direction, direction, direction)
+	pageArgs = append(pageArgs, q.Limit+1)
+	if c == nil && q.Offset > 0 {
+		statement += " OFFSET ?"
+		pageArgs = append(pageArgs, q.Offset)
+	}
+
+	rows, err := db.Query(statement, pageArgs...)
+	if err != nil {
+		return page, fmt.Errorf("error listing: %v", err)
+	}
+	defer rows.Close()
+	var last Key
+	for n := 0; rows.Next(); n++ {
+		if n == q.Limit {
+			page.HasMore = true
+			break
+		}
+		if last, err = scan(rows); err != nil {
+			return page, err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return page, err
+	}
+	if page.HasMore {
+		page.NextCursor = q.next(last)
+	}
+	return page, nil
+}
+
+// where returns the conditions of the filters of q on the columns of a
+// list.
+func (q Query) where() (string, []interface{}) {
+	conditions := []string{"1 = 1"}
+	var args []interface{}
+	if q.StartDate != "" {
+		conditions = append(conditions, "date >= ?")
+		args = append(args, q.StartDate)
+	}
+	if q.EndDate != "" {
+		conditions = append(conditions, "date <= ?")
+		args = append(args, q.EndDate)
+	}
+	for _, list := range []struct {
+		column string
+		values []string
+	}{{"type", q.Types}, {"category", q.Categories}, {"payment_method", q.PaymentMethods}} {
+		if len(list.values) == 0 {
+			continue
+		}
+		placeholders := make([]string, len(list.values))
+		for i, v := range list.values {
+			placeholders[i] = "?"
+			args = append(args, strings.ToLower(strings.TrimSpace(v)))
+		}
+		conditions = append(conditions, fmt.Sprintf("LOWER(%s) IN (%s)", list.column, strings.Join(placeholders, ", ")))
+	}
+	if tags := distinct(q.Tags); len(tags) > 0 {
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(tags)), ", ")
+		// Tag names are unique per user without regard to case
+		tagged := fmt.Sprintf(`SELECT COUNT(DISTINCT t.id) FROM transaction_tags tt JOIN tags t ON t.id = tt.tag_id
+			WHERE tt.kind = listed.type AND tt.row_id = listed.id AND t.name IN (%s)`, placeholders)
+		for _, t := range tags {
+			args = append(args, t)
+		}
+		if q.TagMatch == AnyTag {
+			conditions = append(conditions, fmt.Sprintf("(%s) > 0", tagged))
+		} else {
+			conditions = append(conditions, fmt.Sprintf("(%s) = ?", tagged))
+			args = append(args, len(tags))
+		}
+	}
+	if q.MinAmount != nil {
+		conditions = append(conditions, "base_amount >= ?")
+		args = append(args, *q.MinAmount)
+	}
+	if q.MaxAmount != nil {
+		conditions = append(conditions, "base_amount <= ?")
+		args = append(args, *q.MaxAmount)
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+// distinct returns values trimmed, without repeats in any case.
+func distinct(values []string) []string {
+	var out []string
+	seen := map[string]bool{}
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if v != "" && !seen[strings.ToLower(v)] {
+			seen[strings.ToLower(v)] = true
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// cursor decodes q.Cursor, nil if there is none. A cursor only continues
+// a list in the sort and order it was made for.
+func (q Query) cursor() (*cursor, error) {
+	if q.Cursor == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalid)
+	}
+	var c cursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalid)
+	}
+	if c.Sort != q.Sort || c.Order != q.Order {
+		return nil, fmt.Errorf("%w: the cursor is for another sort", ErrInvalid)
+	}
+	return &c, nil
+}
+
+// next returns the cursor of the page after the one that ends at last.
+func (q Query) next(last Key) string {
+	c := cursor{Sort: q.Sort, Order: q.Order, Type: last.Type, ID: last.ID}
+	if q.Sort == ByAmount {
+		c.Amount = int64(last.Amount)
+	} else {
+		c.Date = last.Date
+	}
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
@@ -0,0 +1,128 @@
+package listing
+
+import (
+	"database/sql"
+	"errors"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"backend/common/dbtest"
+	"backend/common/money"
+)
+
+const transactions = `(
+	SELECT id, 'income' AS type, date, category, payment_method, base_amount FROM incomes WHERE user_id = ?
+	UNION ALL
+	SELECT id, 'expense' AS type, date, category, payment_method, base_amount FROM expenses WHERE user_id = ?)`
+
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db := dbtest.Open(t)
+	_, err := db.Exec(`
+		CREATE TABLE incomes (id INTEGER PRIMARY KEY, user_id TEXT, date TEXT, category TEXT, payment_method TEXT, base_amount INTEGER);
+		CREATE TABLE expenses (id INTEGER PRIMARY KEY, user_id TEXT, date TEXT, category TEXT, payment_method TEXT, base_amount INTEGER);
+		INSERT INTO incomes (id, user_id, date, category, payment_method, base_amount) VALUES
+			(1, 'u1', '2025-01-01', 'Salary', 'bank', 200000),
+			(2, 'u1', '2025-01-15', 'Gifts', 'cash', 5000),
+			(3, 'u2', '2025-01-15', 'Salary', 'bank', 100);
+		INSERT INTO expenses (id, user_id, date, category, payment_method, base_amount) VALUES
+			(1, 'u1', '2025-01-15', 'Food', 'cash', 1250),
+			(2, 'u1', '2025-01-20', 'Food', 'bank', 3000),
+			(3, 'u1', '2025-02-01', 'Rent', 'bank', 80000)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// list walks every page of q and returns the rows as type and id.
+func list(t *testing.T, db *sql.DB, q Query) ([]string, []Page) {
+	t.Helper()
+
+	if err := q.Normalize(); err != nil {
+		t.Fatalf("Normalize: %v", err)
+	}
+	var keys []string
+	var pages []Page
+	for {
+		page, err := q.Run(db, transactions, []interface{}{"u1", "u1"}, func(rows *sql.Rows) (Key, error) {
+			var k Key
+			var category, method string
+			err := rows.Scan(&k.ID, &k.Type, &k.Date, &category, &method, &k.Amount)
+			keys = append(keys, k.Type[:1]+string(rune('0'+k.ID)))
+			return k, err
+		})
+		if err != nil {
+			t.Fatalf("Run: %v", err)
+		}
+		pages = append(pages, page)
+		if !page.HasMore {
+			return keys, pages
+		}
+		q.Cursor = page.NextCursor
+	}
+}
+
+func TestCursorsWalkTheWholeList(t *testing.T) {
+	db := newTestDB(t)
+
+	// Two rows of 2025-01-15 are ordered by type and id
+	keys, pages := list(t, db, Query{Limit: 2})
+	if want := []string{"e3", "e2", "i2", "e1", "i1"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("By date = %v, want %v", keys, want)
+	}
+	if len(pages) != 3 || pages[0].Total != 5 || pages[2].Total != 5 || pages[2].NextCursor != "" {
+		t.Errorf("Pages = %+v", pages)
+	}
+
+	keys, _ = list(t, db, Query{Sort: ByAmount, Order: Ascending, Limit: 1})
+	if want := []string{"e1", "e2", "i2", "e3", "i1"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("By amount = %v, want %v", keys, want)
+	}
+
+	// A row added before the cursor does not shift the next page
+	_, first := list(t, db, Query{Limit: 2})
+	db.Exec(`INSERT INTO expenses (id, user_id, date, category, payment_method, base_amount) VALUES (4, 'u1', '2025-03-01', 'Food', 'cash', 1)`)
+	keys, _ = list(t, db, Query{Limit: 2, Cursor: first[0].NextCursor})
+	if want := []string{"i2", "e1", "i1"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("After an insert = %v, want %v", keys, want)
+	}
+}
+
+func TestFilters(t *testing.T) {
+	db := newTestDB(t)
+
+	q, err := FromValues(url.Values{
+		"start_date":      {"2025-01-10"},
+		"end_date":        {"2025-01-31"},
+		"categories":      {"food,gifts"},
+		"payment_methods": {"cash"},
+		"min_amount":      {"12.50"},
+	})
+	if err != nil {
+		t.Fatalf("FromValues: %v", err)
+	}
+	keys, pages := list(t, db, q)
+	if want := []string{"i2", "e1"}; !reflect.DeepEqual(keys, want) || pages[0].Total != 2 {
+		t.Errorf("Filtered = %v (total %d), want %v", keys, pages[0].Total, want)
+	}
+
+	max := money.Money(5000)
+	keys, _ = list(t, db, Query{Types: []string{"expense"}, MaxAmount: &max})
+	if want := []string{"e2", "e1"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("Expenses up to 50 = %v, want %v", keys, want)
+	}
+
+	for _, bad := range []Query{
+		{Sort: "category"},
+		{StartDate: "01/01/2025"},
+		{Cursor: "not a cursor"},
+		{Sort: ByAmount, Cursor: (Query{Sort: ByDate, Order: Descending}).next(Key{Type: "income", ID: 1})},
+	} {
+		if err := bad.Normalize(); !errors.Is(err, ErrInvalid) {
+			t.Errorf("Normalize(%+v) = %v, want ErrInvalid", bad, err)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+// Package mail delivers the transactional emails of the auth services
+// (verification codes, password resets) through a pluggable transport:
+// SMTP in production, .eml files in a directory for local development and
+// an in-memory mailbox for tests. Services do not call a transport directly;
+// they enqueue messages in the Outbox, which retries failed deliveries.
+package mail
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"backend/common/config"
+
+	"gopkg.in/gomail.v2"
+)
+
+// ErrNotConfigured is returned by FromEnv when the selected transport lacks
+// its settings (an SMTP host, a directory).
+var ErrNotConfigured = errors.New("mail transport not configured")
+
+// Message is an HTML email. It is stored as JSON in the outbox, so it only
+// holds plain data.
+type Message struct {
+	From    string   `json:"from"`
+	To      []string `json:"to"`
+	Subject string   `json:"subject"`
+	HTML    string   `json:"html"`
+	// Embeds are inline images referenced from HTML as cid:<Name>.
+	Embeds []Embed `json:"embeds,omitempty"`
+}
+
+// Embed is an inline attachment.
+type Embed struct {
+	Name string `json:"name"`
+	Data []byte `json:"data"`
+}
+
+// Mailer is a mail transport.
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// render builds the MIME message with gomail.
+func render(msg Message) *gomail.Message {
+	m := gomail.NewMessage()
+	m.SetHeader("From", msg.From)
+	m.SetHeader("To", msg.To...)
+	m.SetHeader("Subject", msg.Subject)
+	m.SetBody("text/html", msg.HTML)
+	for _, embed := range msg.Embeds {
+		data := embed.Data
+		m.Embed(embed.Name, gomail.SetCopyFunc(func(w io.Writer) error {
+			_, err := w.Write(data)
+			return err
+		}))
+	}
+	return m
+}
+
+// SMTPConfig holds the settings of an SMTP relay.
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+}
+
+// SMTP sends through an SMTP relay.
+type SMTP struct {
+	dialer *gomail.Dialer
+}
+
+// NewSMTP returns a transport for the relay in cfg.
+func NewSMTP(cfg SMTPConfig) *SMTP {
+	return &SMTP{dialer: gomail.NewDialer(cfg.Host, cfg.Port, cfg.Username, cfg.Password)}
+}
+
+func (s *SMTP) Send(msg Message) error {
+	if err := s.dialer.DialAndSend(render(msg)); err != nil {
+		return fmt.Errorf("error sending mail via SMTP: %v", err)
+	}
+	return nil
+}
+
+// Dir writes every message as an .eml file into a directory, which any
+// mail client can open. Nothing leaves the machine.
+type Dir struct {
+	path string
+	now  func() time.Time
+}
+
+// NewDir returns a transport writing into path, creating it if needed.
+func NewDir(path string) (*Dir, error) {
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		return nil, fmt.Errorf("error creating mail directory: %v", err)
+	}
+	return &Dir{path: path, now: time.Now}, nil
+}
+
+func (d *Dir) Send(msg Message) error {
+	var buf bytes.Buffer
+	if _, err := render(msg).WriteTo(&buf); err != nil {
+		return fmt.Errorf("error rendering mail: %v", err)
+	}
+
+	recipient := strings.NewReplacer("@", "_at_", "/", "_", "\\", "_").Replace(strings.Join(msg.To, ","))
+	name := fmt.Sprintf("%d-%s.eml", d.now().UnixNano(), recipient)
+	if err := os.WriteFile(filepath.Join(d.path, name), buf.Bytes(), 0o600); err != nil {
+		return fmt.Errorf("error writing mail file: %v", err)
+	}
+	return nil
+}
+
+// Memory keeps sent messages in memory. SetError makes it fail, to simulate
+// an outage.
+type Memory struct {
+	mu   sync.Mutex
+	sent []Message
+	err  error
+}
+
+// NewMemory returns an empty in-memory mailbox.
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+func (m *Memory) Send(msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return m.err
+	}
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+// SetError makes every following Send return err; nil restores delivery.
+func (m *Memory) SetError(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.err = err
+}
+
+// Sent returns a copy of the delivered messages, oldest first.
+func (m *Memory) Sent() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Message(nil), m.sent...)
+}
+
+// FromEnv picks the transport named by MAIL_TRANSPORT: "smtp" (the
+// default) with the given settings, "dir" writing into MAIL_DIR, or
+// "memory".
+func FromEnv(smtp SMTPConfig) (Mailer, error) {
+	switch transport := strings.ToLower(config.String("MAIL_TRANSPORT", "smtp")); transport {
+	case "smtp":
+		if smtp.Host == "" {
+			return nil, ErrNotConfigured
+		}
+		return NewSMTP(smtp), nil
+	case "dir":
+		path := config.String("MAIL_DIR", "")
+		if path == "" {
+			return nil, ErrNotConfigured
+		}
+		return NewDir(path)
+	case "memory":
+		return NewMemory(), nil
+	default:
+		return nil, fmt.Errorf("unknown MAIL_TRANSPORT %q", transport)
+	}
+}
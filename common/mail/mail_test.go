@@ -0,0 +1,203 @@
+package mail
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func newTestOutbox(t *testing.T, mailer Mailer) (*Outbox, *time.Time) {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	o, err := NewOutbox(db, mailer)
+	if err != nil {
+		t.Fatalf("Failed to create outbox: %v", err)
+	}
+	o.maxAttempts = 3
+	o.retryBase = time.Minute
+	o.retryMax = 10 * time.Minute
+
+	now := time.Unix(1_700_000_000, 0)
+	o.now = func() time.Time { return now }
+	return o, &now
+}
+
+func testMessage() Message {
+	return Message{
+		From:    "noreply@example.com",
+		To:      []string{"user@example.com"},
+		Subject: "Verify your email",
+		HTML:    "<p>Your code is 123456</p>",
+		Embeds:  []Embed{{Name: "icon.png", Data: []byte("png")}},
+	}
+}
+
+func TestOutboxDeliversQueuedMail(t *testing.T) {
+	mailbox := NewMemory()
+	o, _ := newTestOutbox(t, mailbox)
+
+	if _, err := o.Enqueue(testMessage()); err != nil {
+		t.Fatalf("Enqueue failed: %v", err)
+	}
+	if sent, err := o.Flush(); err != nil || sent != 1 {
+		t.Fatalf("Expected 1 mail sent, got %d (%v)", sent, err)
+	}
+
+	got := mailbox.Sent()
+	if len(got) != 1 || got[0].Subject != "Verify your email" || string(got[0].Embeds[0].Data) != "png" {
+		t.Fatalf("Unexpected delivered mail: %+v", got)
+	}
+
+	// Sent mail is never delivered twice, and its body is not kept
+	if sent, _ := o.Flush(); sent != 0 {
+		t.Errorf("Expected nothing left to send, got %d", sent)
+	}
+	var payload string
+	o.db.QueryRow(`SELECT payload FROM mail_outbox`).Scan(&payload)
+	if payload != "" {
+		t.Errorf("Expected the payload to be cleared after delivery, got %q", payload)
+	}
+}
+
+func TestOutboxRetriesWithBackoff(t *testing.T) {
+	mailbox := NewMemory()
+	mailbox.SetError(errors.New("connection refused"))
+	o, now := newTestOutbox(t, mailbox)
+
+	id, err := o.Enqueue(testMessage())
+	if err != nil {
+		t.Fatalf("Enqueue failed: %v", err)
+	}
+	if sent, err := o.Flush(); err != nil || sent != 0 {
+		t.Fatalf("Expected failed delivery, got %d (%v)", sent, err)
+	}
+
+	// Not due again until the first backoff has passed
+	mailbox.SetError(nil)
+	*now = now.Add(30 * time.Second)
+	if sent, _ := o.Flush(); sent != 0 {
+		t.Fatalf("Expected retry to wait for the backoff, got %d sent", sent)
+	}
+
+	*now = now.Add(31 * time.Second)
+	if sent, _ := o.Flush(); sent != 1 {
+		t.Fatalf("Expected retry to deliver, got %d sent", sent)
+	}
+
+	var attempts int
+	var lastError sql.NullString
+	o.db.QueryRow(`SELECT attempts, last_error FROM mail_outbox WHERE id = ?`, id).Scan(&attempts, &lastError)
+	if attempts != 2 || lastError.Valid {
+		t.Errorf("Expected 2 attempts and no error, got %d %v", attempts, lastError)
+	}
+}
+
+func TestOutboxGivesUpAfterMaxAttempts(t *testing.T) {
+	mailbox := NewMemory()
+	mailbox.SetError(errors.New("mailbox unavailable"))
+	o, now := newTestOutbox(t, mailbox)
+
+	id, _ := o.Enqueue(testMessage())
+	for i := 0; i < 5; i++ {
+		o.Flush()
+		*now = now.Add(time.Hour)
+	}
+
+	var attempts int
+	var failedAt sql.NullInt64
+	var payload string
+	o.db.QueryRow(`SELECT attempts, failed_at, payload FROM mail_outbox WHERE id = ?`, id).Scan(&attempts, &failedAt, &payload)
+	if attempts != 3 || !failedAt.Valid {
+		t.Errorf("Expected mail to fail after 3 attempts, got %d attempts (failed_at %v)", attempts, failedAt)
+	}
+	if payload != "" {
+		t.Errorf("Expected the payload to be cleared after giving up, got %q", payload)
+	}
+}
+
+func TestOutboxPurgesOldRows(t *testing.T) {
+	mailbox := NewMemory()
+	o, now := newTestOutbox(t, mailbox)
+	o.retention = 24 * time.Hour
+
+	o.Enqueue(testMessage())
+	o.Flush()
+	*now = now.Add(time.Hour)
+	mailbox.SetError(errors.New("connection refused"))
+	o.Enqueue(testMessage())
+	o.Flush()
+
+	// Neither the recent delivery nor the pending retry is purged
+	*now = now.Add(23*time.Hour - time.Minute)
+	if purged, err := o.Purge(); err != nil || purged != 0 {
+		t.Fatalf("Expected nothing purged yet, got %d (%v)", purged, err)
+	}
+	*now = now.Add(2 * time.Minute)
+	if purged, err := o.Purge(); err != nil || purged != 1 {
+		t.Fatalf("Expected the sent mail purged, got %d (%v)", purged, err)
+	}
+
+	var left int
+	o.db.QueryRow(`SELECT COUNT(*) FROM mail_outbox WHERE sent_at IS NULL`).Scan(&left)
+	if left != 1 {
+		t.Errorf("Expected the pending mail to be kept, got %d rows", left)
+	}
+}
+
+func TestOutboxBackoffIsCapped(t *testing.T) {
+	o, _ := newTestOutbox(t, NewMemory())
+
+	for attempts, want := range map[int]time.Duration{1: time.Minute, 2: 2 * time.Minute, 4: 8 * time.Minute, 9: 10 * time.Minute} {
+		if got := o.backoff(attempts); got != want {
+			t.Errorf("backoff(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+}
+
+func TestDirWritesEmlFiles(t *testing.T) {
+	dir, err := NewDir(filepath.Join(t.TempDir(), "mail"))
+	if err != nil {
+		t.Fatalf("NewDir failed: %v", err)
+	}
+	if err := dir.Send(testMessage()); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir.path, "*.eml"))
+	if len(files) != 1 {
+		t.Fatalf("Expected one .eml file, got %v", files)
+	}
+	data, _ := os.ReadFile(files[0])
+	for _, want := range []string{"To: user@example.com", "Subject: Verify your email", "Content-ID: <icon.png>"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("Expected %q in .eml file:\n%s", want, data)
+		}
+	}
+}
+
+func TestFromEnvRequiresSMTPHost(t *testing.T) {
+	t.Setenv("MAIL_TRANSPORT", "smtp")
+	if _, err := FromEnv(SMTPConfig{}); !errors.Is(err, ErrNotConfigured) {
+		t.Errorf("Expected ErrNotConfigured, got %v", err)
+	}
+
+	t.Setenv("MAIL_TRANSPORT", "memory")
+	if m, err := FromEnv(SMTPConfig{}); err != nil {
+		t.Errorf("Expected memory transport, got %v", err)
+	} else if _, ok := m.(*Memory); !ok {
+		t.Errorf("Expected *Memory, got %T", m)
+	}
+}
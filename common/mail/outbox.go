@@ -0,0 +1,244 @@
+package mail
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"backend/common/config"
+)
+
+// claimLease is how long a delivery attempt owns a row. Another instance
+// (or this one after a crash) may pick the row up again once it expires.
+const claimLease = 5 * time.Minute
+
+// Outbox stores messages in mail_outbox and delivers them in the
+// background, retrying with exponential backoff, so a mail outage never
+// fails the request that produced the email. The message body, which may
+// hold reset tokens or verification codes, is cleared once the row is sent
+// or given up, and the row itself is purged after retention.
+type Outbox struct {
+	db          *sql.DB
+	mailer      Mailer
+	maxAttempts int
+	retryBase   time.Duration
+	retryMax    time.Duration
+	retention   time.Duration
+	now         func() time.Time
+	wake        chan struct{}
+}
+
+// NewOutbox creates an Outbox delivering through mailer and makes sure
+// mail_outbox exists. Retries follow MAIL_MAX_ATTEMPTS, MAIL_RETRY_BASE and
+// MAIL_RETRY_MAX, and finished rows are kept for MAIL_RETENTION.
+func NewOutbox(db *sql.DB, mailer Mailer) (*Outbox, error) {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS mail_outbox (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			recipient TEXT NOT NULL,
+			subject TEXT NOT NULL,
+			payload TEXT NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			last_error TEXT,
+			next_attempt_at INTEGER NOT NULL,
+			created_at INTEGER NOT NULL,
+			sent_at INTEGER,
+			failed_at INTEGER
+		)
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("error creating mail_outbox table: %v", err)
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_mail_outbox_pending ON mail_outbox(sent_at, failed_at, next_attempt_at)`)
+	if err != nil {
+		return nil, fmt.Errorf("error creating index on mail_outbox: %v", err)
+	}
+
+	return &Outbox{
+		db:          db,
+		mailer:      mailer,
+		maxAttempts: config.Int("MAIL_MAX_ATTEMPTS", 8),
+		retryBase:   config.Duration("MAIL_RETRY_BASE", 30*time.Second),
+		retryMax:    config.Duration("MAIL_RETRY_MAX", time.Hour),
+		retention:   config.Duration("MAIL_RETENTION", 7*24*time.Hour),
+		now:         time.Now,
+		wake:        make(chan struct{}, 1),
+	}, nil
+}
+
+// Enqueue stores msg for delivery and wakes the background sender.
+func (o *Outbox) Enqueue(msg Message) (int64, error) {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return 0, fmt.Errorf("error encoding mail: %v", err)
+	}
+
+	now := o.now().Unix()
+	result, err := o.db.Exec(`
+		INSERT INTO mail_outbox (recipient, subject, payload, next_attempt_at, created_at)
+		VALUES (?, ?, ?, ?, ?)`,
+		strings.Join(msg.To, ","), msg.Subject, string(payload), now, now,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("error queueing mail: %v", err)
+	}
+
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+	return result.LastInsertId()
+}
+
+// Flush tries every message that is due and returns how many were sent.
+// Failed deliveries are rescheduled, or given up after maxAttempts.
+func (o *Outbox) Flush() (int, error) {
+	now := o.now()
+	rows, err := o.db.Query(`
+		SELECT id FROM mail_outbox
+		WHERE sent_at IS NULL AND failed_at IS NULL AND next_attempt_at <= ?
+		ORDER BY id`, now.Unix())
+	if err != nil {
+		return 0, fmt.Errorf("error reading mail_outbox: %v", err)
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("error reading mail_outbox: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	sent := 0
+	for _, id := range ids {
+		ok, err := o.deliver(id, now)
+		if err != nil {
+			return sent, err
+		}
+		if ok {
+			sent++
+		}
+	}
+	return sent, nil
+}
+
+// deliver claims one row and sends it. A row claimed by someone else is
+// skipped.
+func (o *Outbox) deliver(id int64, now time.Time) (bool, error) {
+	claim, err := o.db.Exec(`
+		UPDATE mail_outbox SET next_attempt_at = ?
+		WHERE id = ? AND sent_at IS NULL AND failed_at IS NULL AND next_attempt_at <= ?`,
+		now.Add(claimLease).Unix(), id, now.Unix(),
+	)
+	if err != nil {
+		return false, fmt.Errorf("error claiming mail: %v", err)
+	}
+	if claimed, err := claim.RowsAffected(); err != nil || claimed == 0 {
+		return false, err
+	}
+
+	var payload string
+	var attempts int
+	err = o.db.QueryRow(`SELECT payload, attempts FROM mail_outbox WHERE id = ?`, id).Scan(&payload, &attempts)
+	if err != nil {
+		return false, fmt.Errorf("error reading mail: %v", err)
+	}
+
+	var msg Message
+	sendErr := json.Unmarshal([]byte(payload), &msg)
+	if sendErr == nil {
+		sendErr = o.mailer.Send(msg)
+	}
+	attempts++
+
+	if sendErr == nil {
+		_, err = o.db.Exec(`UPDATE mail_outbox SET attempts = ?, last_error = NULL, payload = '', sent_at = ? WHERE id = ?`,
+			attempts, o.now().Unix(), id)
+		if err != nil {
+			return false, fmt.Errorf("error marking mail as sent: %v", err)
+		}
+		return true, nil
+	}
+
+	log.Printf("Mail %d delivery attempt %d failed: %v", id, attempts, sendErr)
+	if attempts >= o.maxAttempts {
+		_, err = o.db.Exec(`UPDATE mail_outbox SET attempts = ?, last_error = ?, payload = '', failed_at = ? WHERE id = ?`,
+			attempts, sendErr.Error(), o.now().Unix(), id)
+	} else {
+		_, err = o.db.Exec(`UPDATE mail_outbox SET attempts = ?, last_error = ?, next_attempt_at = ? WHERE id = ?`,
+			attempts, sendErr.Error(), now.Add(o.backoff(attempts)).Unix(), id)
+	}
+	if err != nil {
+		return false, fmt.Errorf("error rescheduling mail: %v", err)
+	}
+	return false, nil
+}
+
+// backoff doubles retryBase for every failed attempt, up to retryMax.
+func (o *Outbox) backoff(attempts int) time.Duration {
+	wait := o.retryBase
+	for i := 1; i < attempts && wait < o.retryMax; i++ {
+		wait *= 2
+	}
+	if wait > o.retryMax {
+		wait = o.retryMax
+	}
+	return wait
+}
+
+// Purge deletes the rows sent or given up more than retention ago and
+// returns how many were deleted.
+func (o *Outbox) Purge() (int64, error) {
+	before := o.now().Add(-o.retention).Unix()
+	result, err := o.db.Exec(`
+		DELETE FROM mail_outbox
+		WHERE (sent_at IS NOT NULL AND sent_at < ?) OR (failed_at IS NOT NULL AND failed_at < ?)`,
+		before, before,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("error purging mail_outbox: %v", err)
+	}
+	return result.RowsAffected()
+}
+
+// Start flushes the outbox every interval, and right after each Enqueue,
+// until the process exits. Old rows are purged on every tick.
+func (o *Outbox) Start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if _, err := o.Flush(); err != nil {
+				log.Printf("Error flushing mail outbox: %v", err)
+			}
+			if _, err := o.Purge(); err != nil {
+				log.Printf("Error purging mail outbox: %v", err)
+			}
+			select {
+			case <-ticker.C:
+			case <-o.wake:
+			}
+		}
+	}()
+}
+
+// StartFromEnv builds the transport chosen by FromEnv, wraps it in an
+// Outbox and starts flushing it every MAIL_FLUSH_INTERVAL.
+func StartFromEnv(db *sql.DB, smtp SMTPConfig) (*Outbox, error) {
+	mailer, err := FromEnv(smtp)
+	if err != nil {
+		return nil, err
+	}
+	o, err := NewOutbox(db, mailer)
+	if err != nil {
+		return nil, err
+	}
+	o.Start(config.Duration("MAIL_FLUSH_INTERVAL", 30*time.Second))
+	return o, nil
+}
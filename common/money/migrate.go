@@ -0,0 +1,112 @@
+package money
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Columns are the amount columns of the service tables. Percentages stay
+// REAL. The ledger's period tables are converted by the ledger itself.
+var Columns = map[string][]string{
+	"expenses":               {"amount"},
+	"incomes":                {"amount"},
+	"bills":                  {"amount"},
+	"balances":               {"cash_balance", "bank_balance"},
+	"cash_bank":              {"cash_amount", "bank_amount", "monthly_total"},
+	"cash_bank_transactions": {"amount"},
+	"budget":                 {"total_amount", "remaining_amount", "spent_amount", "upcoming_amount", "from_previous", "total_income"},
+	"savings":                {"available", "goal"},
+	"finance_metrics":        {"income", "expenses", "bills"},
+}
+
+// Migrate converts every table in Columns that exists in db. See Convert.
+func Migrate(db *sql.DB) error {
+	for table, columns := range Columns {
+		if err := Convert(db, table, columns...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Convert turns the REAL columns of table that hold units into INTEGER
+// columns that hold cents, rounding each value to the cent. Columns that
+// are already INTEGER, or missing, are left alone, so it is safe to run on
+// every start. All columns of the table convert in one transaction.
+//
+// SQLite cannot change the type of a column, so each one is rebuilt: the
+// cents go into a new column, the old one is dropped and the new one takes
+// its name.
+func Convert(db *sql.DB, table string, columns ...string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting migration of %s: %v", table, err)
+	}
+	defer tx.Rollback()
+
+	existing, err := columnTypes(tx, table)
+	if err != nil {
+		return err
+	}
+	converted := 0
+	for _, column := range columns {
+		info, ok := existing[column]
+		if !ok || !isReal(info.kind) {
+			continue
+		}
+
+		definition := "INTEGER"
+		if info.notNull {
+			definition += " NOT NULL DEFAULT 0"
+		}
+		cents := column + "_cents"
+		steps := []string{
+			fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, cents, definition),
+			fmt.Sprintf("UPDATE %s SET %s = CAST(ROUND(%s * 100) AS INTEGER)", table, cents, column),
+			fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", table, column),
+			fmt.Sprintf("ALTER TABLE %s RENAME COLUMN %s TO %s", table, cents, column),
+		}
+		for _, step := range steps {
+			if _, err := tx.Exec(step); err != nil {
+				return fmt.Errorf("error converting %s.%s to cents: %v", table, column, err)
+			}
+		}
+		converted++
+	}
+	if converted == 0 {
+		return nil
+	}
+	return tx.Commit()
+}
+
+type columnInfo struct {
+	kind    string
+	notNull bool
+}
+
+func columnTypes(tx *sql.Tx, table string) (map[string]columnInfo, error) {
+	rows, err := tx.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return nil, fmt.Errorf("error reading columns of %s: %v", table, err)
+	}
+	defer rows.Close()
+
+	columns := map[string]columnInfo{}
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, kind string
+		var def sql.NullString
+		if err := rows.Scan(&cid, &name, &kind, &notNull, &def, &pk); err != nil {
+			return nil, fmt.Errorf("error reading columns of %s: %v", table, err)
+		}
+		columns[name] = columnInfo{kind: kind, notNull: notNull == 1}
+	}
+	return columns, rows.Err()
+}
+
+// isReal reports whether a declared type has REAL affinity.
+func isReal(kind string) bool {
+	kind = strings.ToUpper(kind)
+	return strings.Contains(kind, "REAL") || strings.Contains(kind, "FLOA") || strings.Contains(kind, "DOUB")
+}
@@ -0,0 +1,159 @@
+// Package money stores amounts as integer cents. Floating point amounts
+// drift when the ledger adds them up across months of periods; cents add
+// up exactly. In JSON an amount is still a plain number of units (12.5,
+// not 1250), so clients see the same payloads as before, while the
+// database holds INTEGER cents (see Migrate).
+package money
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Money is an amount in cents.
+type Money int64
+
+// ErrInvalid is returned for amounts that are not a decimal number.
+var ErrInvalid = errors.New("invalid amount")
+
+// FromFloat converts units to cents, rounding half away from zero.
+func FromFloat(units float64) Money {
+	return Money(math.Round(units * 100))
+}
+
+// Parse reads a decimal number of units such as "12", "-0.5" or "1234.56".
+// Digits past the cent are rounded half away from zero, exactly, without
+// going through float64.
+func Parse(s string) (Money, error) {
+	s = strings.TrimSpace(s)
+	if strings.ContainsAny(s, "eE") {
+		units, err := strconv.ParseFloat(s, 64)
+		if err != nil || math.IsInf(units, 0) || math.IsNaN(units) {
+			return 0, fmt.Errorf("%w: %q", ErrInvalid, s)
+		}
+		return FromFloat(units), nil
+	}
+
+	negative := strings.HasPrefix(s, "-")
+	digits := strings.TrimPrefix(strings.TrimPrefix(s, "-"), "+")
+	whole, fraction, _ := strings.Cut(digits, ".")
+	if whole == "" && fraction == "" || !onlyDigits(whole) || !onlyDigits(fraction) {
+		return 0, fmt.Errorf("%w: %q", ErrInvalid, s)
+	}
+
+	roundUp := len(fraction) > 2 && fraction[2] >= '5'
+	fraction = (fraction + "00")[:2]
+	cents, err := strconv.ParseInt(whole+fraction, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q", ErrInvalid, s)
+	}
+	if roundUp {
+		cents++
+	}
+	if negative {
+		cents = -cents
+	}
+	return Money(cents), nil
+}
+
+func onlyDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Float64 returns the amount in units, for ratios and percentages.
+func (m Money) Float64() float64 {
+	return float64(m) / 100
+}
+
+// Abs returns the amount without its sign.
+func (m Money) Abs() Money {
+	if m < 0 {
+		return -m
+	}
+	return m
+}
+
+// Percent returns percent per cent of m, rounded to the cent.
+func (m Money) Percent(percent float64) Money {
+	return Money(math.Round(float64(m) * percent / 100))
+}
+
+// Scale returns m multiplied by factor, e.g. an exchange rate, rounded to
+// the cent.
+func (m Money) Scale(factor float64) Money {
+	return Money(math.Round(float64(m) * factor))
+}
+
+// String formats the amount in units with two decimals, e.g. "-12.30".
+func (m Money) String() string {
+	sign, cents := "", int64(m)
+	if cents < 0 {
+		sign, cents = "-", -cents
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, cents/100, cents%100)
+}
+
+// MarshalJSON writes the amount as a number of units with no trailing
+// zeros, the way the float64 amounts it replaces were written.
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(strings.TrimSuffix(strings.TrimRight(m.String(), "0"), ".")), nil
+}
+
+// UnmarshalJSON reads a number of units. Quoted numbers are accepted too
+// and null leaves the amount unchanged.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	parsed, err := Parse(strings.Trim(s, `"`))
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// Scan reads cents from the database. REAL values are cents too: SUM()
+// over a column that was never migrated, or arithmetic in SQL, may return
+// them.
+func (m *Money) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*m = 0
+	case int64:
+		*m = Money(v)
+	case float64:
+		*m = Money(math.Round(v))
+	case []byte:
+		return m.scanText(string(v))
+	case string:
+		return m.scanText(v)
+	default:
+		return fmt.Errorf("%w: cannot scan %T", ErrInvalid, src)
+	}
+	return nil
+}
+
+func (m *Money) scanText(s string) error {
+	cents, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return fmt.Errorf("%w: %q", ErrInvalid, s)
+	}
+	*m = Money(math.Round(cents))
+	return nil
+}
+
+// Value writes the amount as INTEGER cents.
+func (m Money) Value() (driver.Value, error) {
+	return int64(m), nil
+}
@@ -0,0 +1,126 @@
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"backend/common/dbtest"
+)
+
+func TestParse(t *testing.T) {
+	cases := map[string]Money{
+		"12":      1200,
+		"12.5":    1250,
+		"-0.5":    -50,
+		".25":     25,
+		"1.005":   101,
+		"-1.005":  -101,
+		"1.004":   100,
+		"0.1":     10,
+		"1e2":     10000,
+		" 7.07 ":  707,
+		"+3.10":   310,
+		"9999.99": 999999,
+	}
+	for input, want := range cases {
+		got, err := Parse(input)
+		if err != nil || got != want {
+			t.Errorf("Parse(%q) = %d, %v; want %d", input, got, err, want)
+		}
+	}
+
+	for _, input := range []string{"", "-", ".", "12,5", "1.2.3", "abc", "1e999"} {
+		if _, err := Parse(input); !errors.Is(err, ErrInvalid) {
+			t.Errorf("Parse(%q) error = %v, want ErrInvalid", input, err)
+		}
+	}
+}
+
+func TestJSONMatchesFloatEncoding(t *testing.T) {
+	for _, units := range []float64{0, 12, 12.5, 0.1, -3.07, 1234567.89} {
+		want, _ := json.Marshal(units)
+		got, err := json.Marshal(FromFloat(units))
+		if err != nil || string(got) != string(want) {
+			t.Errorf("Marshal(%v) = %s, %v; want %s", units, got, err, want)
+		}
+	}
+
+	var request struct {
+		Amount Money `json:"amount"`
+		Quoted Money `json:"quoted"`
+	}
+	if err := json.Unmarshal([]byte(`{"amount": 19.99, "quoted": "5.5"}`), &request); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if request.Amount != 1999 || request.Quoted != 550 {
+		t.Errorf("Unmarshal = %d, %d", request.Amount, request.Quoted)
+	}
+	if err := json.Unmarshal([]byte(`{"amount": "ten"}`), &request); err == nil {
+		t.Errorf("Expected an error for a non-numeric amount")
+	}
+}
+
+func TestSumIsExact(t *testing.T) {
+	var cents Money
+	for i := 0; i < 1000; i++ {
+		cents += FromFloat(0.1)
+	}
+	if cents != 10000 {
+		t.Errorf("1000 x 0.10 = %s", cents)
+	}
+}
+
+func TestConvert(t *testing.T) {
+	db := dbtest.Open(t)
+	_, err := db.Exec(`CREATE TABLE expenses (id INTEGER PRIMARY KEY, user_id TEXT, amount REAL NOT NULL, note TEXT)`)
+	if err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	_, err = db.Exec(`INSERT INTO expenses (user_id, amount, note) VALUES ('u1', 12.5, 'a'), ('u1', 0.1 + 0.2, 'b'), ('u2', -3.005, 'c')`)
+	if err != nil {
+		t.Fatalf("Failed to seed table: %v", err)
+	}
+
+	for run := 0; run < 2; run++ {
+		if err := Convert(db, "expenses", "amount", "missing"); err != nil {
+			t.Fatalf("Convert run %d failed: %v", run, err)
+		}
+	}
+
+	var kind string
+	db.QueryRow(`SELECT type FROM pragma_table_info('expenses') WHERE name = 'amount'`).Scan(&kind)
+	if kind != "INTEGER" {
+		t.Errorf("amount is %s, want INTEGER", kind)
+	}
+
+	rows, err := db.Query(`SELECT amount, note FROM expenses ORDER BY id`)
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	defer rows.Close()
+	var got []Money
+	for rows.Next() {
+		var amount Money
+		var note string
+		if err := rows.Scan(&amount, &note); err != nil {
+			t.Fatalf("Scan failed: %v", err)
+		}
+		got = append(got, amount)
+	}
+	want := []Money{1250, 30, -301}
+	if len(got) != len(want) {
+		t.Fatalf("Got %d rows, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Row %d = %d cents, want %d", i, got[i], want[i])
+		}
+	}
+
+	var total Money
+	db.QueryRow(`SELECT SUM(amount) FROM expenses WHERE user_id = 'u1'`).Scan(&total)
+	if total != 1280 {
+		t.Errorf("SUM = %s, want 12.80", total)
+	}
+}
@@ -0,0 +1,137 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type stubProvider struct {
+	key     *rsa.PrivateKey
+	kid     string
+	fetches int
+	server  *httptest.Server
+}
+
+func newStubProvider(t *testing.T) *stubProvider {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	p := &stubProvider{key: key, kid: "test-key"}
+	p.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p.fetches++
+		w.Header().Set("Cache-Control", "public, max-age=600")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": p.kid,
+				"use": "sig",
+				"alg": "RS256",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	}))
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+func (p *stubProvider) sign(t *testing.T, kid string, claims map[string]interface{}) string {
+	t.Helper()
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func validClaims() map[string]interface{} {
+	return map[string]interface{}{
+		"iss":            "https://accounts.google.com",
+		"aud":            "client-123",
+		"sub":            "1100220033",
+		"email":          "user@example.com",
+		"email_verified": true,
+		"iat":            time.Now().Unix(),
+		"exp":            time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+func TestVerifyValidToken(t *testing.T) {
+	p := newStubProvider(t)
+	v := NewGoogleVerifier(p.server.URL, []string{"other-client", "client-123"})
+
+	claims, err := v.Verify(context.Background(), p.sign(t, p.kid, validClaims()))
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if claims.Subject != "1100220033" || claims.Email != "user@example.com" || !claims.EmailVerified {
+		t.Errorf("Unexpected claims %+v", claims)
+	}
+
+	// Keys are cached between tokens
+	v.Verify(context.Background(), p.sign(t, p.kid, validClaims()))
+	if p.fetches != 1 {
+		t.Errorf("Expected JWKS to be fetched once, got %d", p.fetches)
+	}
+}
+
+func TestVerifyRejectsBadTokens(t *testing.T) {
+	p := newStubProvider(t)
+	v := NewGoogleVerifier(p.server.URL, []string{"client-123"})
+
+	cases := map[string]func(map[string]interface{}){
+		"wrong audience": func(c map[string]interface{}) { c["aud"] = "someone-else" },
+		"wrong issuer":   func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" },
+		"expired":        func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Hour).Unix() },
+		"no subject":     func(c map[string]interface{}) { delete(c, "sub") },
+	}
+	for name, mutate := range cases {
+		claims := validClaims()
+		mutate(claims)
+		if _, err := v.Verify(context.Background(), p.sign(t, p.kid, claims)); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: expected ErrInvalidToken, got %v", name, err)
+		}
+	}
+
+	// Signed by a key that is not in the set
+	other := newStubProvider(t)
+	if _, err := v.Verify(context.Background(), other.sign(t, p.kid, validClaims())); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected foreign signature to be rejected, got %v", err)
+	}
+
+	// Unknown key id
+	if _, err := v.Verify(context.Background(), p.sign(t, "rotated", validClaims())); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected unknown kid to be rejected, got %v", err)
+	}
+}
+
+func TestAppleVerifierNeedsClientIDs(t *testing.T) {
+	t.Setenv("APPLE_CLIENT_IDS", "")
+	if AppleVerifierFromEnv().Configured() {
+		t.Error("Apple verifier without client ids reports itself configured")
+	}
+	t.Setenv("APPLE_CLIENT_IDS", "com.herobudget.app, com.herobudget.web")
+	if !AppleVerifierFromEnv().Configured() {
+		t.Error("Apple verifier with client ids reports itself unconfigured")
+	}
+}
@@ -0,0 +1,57 @@
+package oidc
+
+import (
+	"strings"
+
+	"backend/common/config"
+)
+
+// GoogleJWKSURL is where Google publishes the keys that sign its ID tokens.
+const GoogleJWKSURL = "https://www.googleapis.com/oauth2/v3/certs"
+
+// AppleJWKSURL is where Apple publishes the keys that sign its identity tokens.
+const AppleJWKSURL = "https://appleid.apple.com/auth/keys"
+
+// GoogleIssuers are the two iss values Google uses in ID tokens.
+var GoogleIssuers = []string{"accounts.google.com", "https://accounts.google.com"}
+
+// AppleIssuers is the iss value of Apple identity tokens.
+var AppleIssuers = []string{"https://appleid.apple.com"}
+
+// NewGoogleVerifier returns a Verifier for Google ID tokens addressed to any
+// of clientIDs (web, Android and iOS clients have different ids). jwksSource
+// defaults to GoogleJWKSURL.
+func NewGoogleVerifier(jwksSource string, clientIDs []string) *Verifier {
+	if jwksSource == "" {
+		jwksSource = GoogleJWKSURL
+	}
+	return NewVerifier(NewKeySet(jwksSource), GoogleIssuers, clientIDs)
+}
+
+// GoogleVerifierFromEnv accepts tokens for GOOGLE_CLIENT_ID and
+// GOOGLE_ALLOWED_CLIENT_IDS, with keys from GOOGLE_JWKS_URL.
+func GoogleVerifierFromEnv() *Verifier {
+	clientIDs := append([]string{config.String("GOOGLE_CLIENT_ID", "")}, splitList(config.String("GOOGLE_ALLOWED_CLIENT_IDS", ""))...)
+	return NewGoogleVerifier(config.String("GOOGLE_JWKS_URL", GoogleJWKSURL), clientIDs)
+}
+
+// AppleVerifierFromEnv accepts identity tokens for the bundle and service
+// ids in APPLE_CLIENT_IDS, with keys from APPLE_JWKS_URL. With no client ids
+// configured every token is rejected.
+func AppleVerifierFromEnv() *Verifier {
+	return NewVerifier(
+		NewKeySet(config.String("APPLE_JWKS_URL", AppleJWKSURL)),
+		AppleIssuers,
+		splitList(config.String("APPLE_CLIENT_IDS", "")),
+	)
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
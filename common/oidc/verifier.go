@@ -0,0 +1,177 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var ErrInvalidToken = errors.New("invalid ID token")
+
+// clockSkew tolerates small differences between our clock and the
+// provider's when checking exp and iat.
+const clockSkew = time.Minute
+
+// Claims holds the standard ID token claims we use.
+type Claims struct {
+	Issuer        string   `json:"iss"`
+	Subject       string   `json:"sub"`
+	Audience      audience `json:"aud"`
+	ExpiresAt     int64    `json:"exp"`
+	IssuedAt      int64    `json:"iat"`
+	Email         string   `json:"email"`
+	EmailVerified boolish  `json:"email_verified"`
+	Name          string   `json:"name"`
+	GivenName     string   `json:"given_name"`
+	FamilyName    string   `json:"family_name"`
+	Picture       string   `json:"picture"`
+	Locale        string   `json:"locale"`
+}
+
+// Verifier checks ID tokens of one provider.
+type Verifier struct {
+	keys      *KeySet
+	issuers   []string
+	audiences []string
+	now       func() time.Time
+}
+
+// NewVerifier returns a Verifier accepting tokens signed by keys, issued by
+// one of issuers and addressed to one of audiences (our OAuth client ids).
+func NewVerifier(keys *KeySet, issuers, audiences []string) *Verifier {
+	return &Verifier{keys: keys, issuers: issuers, audiences: audiences, now: time.Now}
+}
+
+// Configured reports whether v accepts tokens for any client id. A
+// verifier without client ids rejects every token.
+func (v *Verifier) Configured() bool {
+	return len(v.audiences) > 0
+}
+
+// Verify checks the signature, issuer, audience and lifetime of token and
+// returns its claims. Callers still decide what to do with email_verified.
+func (v *Verifier) Verify(ctx context.Context, token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil || header.Alg != "RS256" {
+		return nil, ErrInvalidToken
+	}
+
+	key, err := v.keys.Key(ctx, header.Kid)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return nil, fmt.Errorf("%w: bad signature", ErrInvalidToken)
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if err := v.checkClaims(&claims); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	return &claims, nil
+}
+
+func (v *Verifier) checkClaims(claims *Claims) error {
+	if !contains(v.issuers, claims.Issuer) {
+		return fmt.Errorf("unexpected issuer %q", claims.Issuer)
+	}
+
+	matched := false
+	for _, aud := range claims.Audience {
+		if contains(v.audiences, aud) {
+			matched = true
+			break
+		}
+	}
+	if !matched {
+		return errors.New("token was issued for another client")
+	}
+
+	now := v.now()
+	if claims.ExpiresAt == 0 || now.Add(-clockSkew).Unix() >= claims.ExpiresAt {
+		return errors.New("token expired")
+	}
+	if claims.IssuedAt > now.Add(clockSkew).Unix() {
+		return errors.New("token issued in the future")
+	}
+	if claims.Subject == "" {
+		return errors.New("missing subject")
+	}
+	return nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	raw, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate != "" && candidate == value {
+			return true
+		}
+	}
+	return false
+}
+
+// audience accepts "aud" as a single string or an array.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+// boolish accepts email_verified as a boolean or as "true"/"false", which
+// some Google endpoints still send.
+type boolish bool
+
+func (b *boolish) UnmarshalJSON(data []byte) error {
+	var value bool
+	if err := json.Unmarshal(data, &value); err == nil {
+		*b = boolish(value)
+		return nil
+	}
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return err
+	}
+	*b = boolish(text == "true")
+	return nil
+}
@@ -0,0 +1,63 @@
+// Package password hashes and verifies user passwords for signup, signin,
+// reset_password and profile_management.
+//
+// Passwords are stored as bcrypt hashes. Accounts created before hashing was
+// introduced still hold the plaintext value; Verify accepts those and asks
+// the caller to rehash, so they are migrated on the next successful sign-in.
+package password
+
+import (
+	"crypto/subtle"
+	"strings"
+
+	"backend/common/config"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// cost returns the bcrypt work factor, configurable through PASSWORD_HASH_COST
+// so it can be raised as hardware gets faster.
+func cost() int {
+	c := config.Int("PASSWORD_HASH_COST", 12)
+	if c < bcrypt.MinCost || c > bcrypt.MaxCost {
+		return bcrypt.DefaultCost
+	}
+	return c
+}
+
+// Hash returns the bcrypt hash to store in users.password.
+func Hash(plain string) (string, error) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(plain), cost())
+	if err != nil {
+		return "", err
+	}
+	return string(hashed), nil
+}
+
+// IsHashed reports whether stored is a bcrypt hash rather than a legacy
+// plaintext password.
+func IsHashed(stored string) bool {
+	return strings.HasPrefix(stored, "$2a$") ||
+		strings.HasPrefix(stored, "$2b$") ||
+		strings.HasPrefix(stored, "$2y$")
+}
+
+// Verify compares plain with the stored password. needsRehash is true when
+// the password matched but is stored in plaintext or with an outdated cost;
+// the caller should then save Hash(plain).
+func Verify(stored, plain string) (ok bool, needsRehash bool) {
+	if stored == "" || plain == "" {
+		return false, false
+	}
+
+	if !IsHashed(stored) {
+		ok = subtle.ConstantTimeCompare([]byte(stored), []byte(plain)) == 1
+		return ok, ok
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(stored), []byte(plain)) != nil {
+		return false, false
+	}
+	storedCost, err := bcrypt.Cost([]byte(stored))
+	return true, err == nil && storedCost < cost()
+}
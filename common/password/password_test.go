@@ -0,0 +1,52 @@
+package password
+
+import (
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	// Keep the tests fast; production uses the default cost of 12
+	os.Setenv("PASSWORD_HASH_COST", "4")
+	os.Exit(m.Run())
+}
+
+func TestHashAndVerify(t *testing.T) {
+	hashed, err := Hash("correct horse")
+	if err != nil {
+		t.Fatalf("Hash failed: %v", err)
+	}
+	if !IsHashed(hashed) {
+		t.Fatalf("Expected a bcrypt hash, got %q", hashed)
+	}
+
+	if ok, rehash := Verify(hashed, "correct horse"); !ok || rehash {
+		t.Errorf("Expected match without rehash, got ok=%v rehash=%v", ok, rehash)
+	}
+	if ok, _ := Verify(hashed, "wrong horse"); ok {
+		t.Errorf("Expected wrong password to be rejected")
+	}
+}
+
+func TestVerifyLegacyPlaintext(t *testing.T) {
+	if ok, rehash := Verify("legacy-secret", "legacy-secret"); !ok || !rehash {
+		t.Errorf("Expected legacy match to request rehash, got ok=%v rehash=%v", ok, rehash)
+	}
+	if ok, rehash := Verify("legacy-secret", "other"); ok || rehash {
+		t.Errorf("Expected legacy mismatch to fail, got ok=%v rehash=%v", ok, rehash)
+	}
+	if ok, _ := Verify("", ""); ok {
+		t.Errorf("Expected empty stored password to never match")
+	}
+}
+
+func TestVerifyOutdatedCost(t *testing.T) {
+	hashed, _ := Hash("pa55word")
+
+	os.Setenv("PASSWORD_HASH_COST", "5")
+	defer os.Setenv("PASSWORD_HASH_COST", "4")
+
+	if ok, rehash := Verify(hashed, "pa55word"); !ok || !rehash {
+		t.Errorf("Expected outdated cost to request rehash, got ok=%v rehash=%v", ok, rehash)
+	}
+}
@@ -0,0 +1,70 @@
+package reconcile
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"backend/common/reply"
+)
+
+// Handler serves the reconciliation admin endpoint. GET reports what
+// would change for every user, or for user_id, without applying it; POST
+// applies it, unless dry_run=true. Wrap it in auth.RequireAdmin.
+func (r *Reconciler) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		var dryRun bool
+		switch req.Method {
+		case http.MethodGet:
+			dryRun = true
+		case http.MethodPost:
+			dryRun = req.URL.Query().Get("dry_run") == "true"
+		default:
+			reply.JSON(w, http.StatusMethodNotAllowed, false, "Method not allowed", nil)
+			return
+		}
+
+		reports, err := r.Run(req.URL.Query().Get("user_id"), dryRun)
+		if err != nil {
+			log.Printf("Error reconciling balances: %v", err)
+			reply.JSON(w, http.StatusInternalServerError, false, "Error reconciling balances", reports)
+			return
+		}
+		message := "Balances reconciled"
+		if dryRun {
+			message = "Dry run, nothing was changed"
+		}
+		reply.JSON(w, http.StatusOK, true, message, reports)
+	}
+}
+
+// Command runs a reconciliation from the command line arguments args
+// (-user, -dry-run) and prints every diff to out, one per line.
+func (r *Reconciler) Command(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("reconcile", flag.ContinueOnError)
+	flags.SetOutput(out)
+	userID := flags.String("user", "", "reconcile only this user")
+	dryRun := flags.Bool("dry-run", false, "report the diffs without applying them")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	reports, err := r.Run(*userID, *dryRun)
+	for _, report := range reports {
+		for _, d := range report.Diffs {
+			account := ""
+			if d.AccountID != 0 {
+				account = fmt.Sprintf(" account %d", d.AccountID)
+			}
+			fmt.Fprintf(out, "user %s: %s %s%s %s: %s -> %s\n", report.UserID, d.Table, d.Key, account, d.Column, d.Before, d.After)
+		}
+		state := "applied"
+		if !report.Applied {
+			state = "not applied"
+		}
+		fmt.Fprintf(out, "user %s: %d diffs, %s\n", report.UserID, len(report.Diffs), state)
+	}
+	return err
+}
@@ -0,0 +1,244 @@
+// Package reconcile rebuilds the balances derived from a user's
+// transactions when they have drifted from them. The period tables of the
+// ledger are rebuilt from the source rows (incomes, expenses, unpaid bill
+// months, paid card statements, transfers and account opening balances)
+// and every Step registered by a service rebuilds its own tables after
+// them, all in one transaction per user.
+//
+// Manual cash and bank corrections store the amount the user set, not
+// the adjustment they posted, so the ledger is their only record: the
+// adjustments of each account and day that no source row explains are
+// kept as they are.
+//
+// Every change is reported as a Diff. A dry run rolls the transaction
+// back, so it reports what would change and leaves the database as it was.
+package reconcile
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"backend/common/account"
+	"backend/common/ledger"
+	"backend/common/money"
+)
+
+// Diff is one amount that the reconciliation changed or would change.
+type Diff struct {
+	Table string `json:"table"`
+	// Key is the period of the row, e.g. 2025-03 in the monthly tables.
+	Key       string      `json:"key"`
+	AccountID int64       `json:"account_id,omitempty"`
+	Column    string      `json:"column"`
+	Before    money.Money `json:"before"`
+	After     money.Money `json:"after"`
+}
+
+// Report is the outcome of reconciling one user.
+type Report struct {
+	UserID string `json:"user_id"`
+	Diffs  []Diff `json:"diffs"`
+	// Applied is false in dry runs.
+	Applied bool `json:"applied"`
+}
+
+// Step rebuilds more derived tables of userID inside tx, once the ledger
+// has been rebuilt, and returns what it changed.
+type Step func(tx *sql.Tx, userID string) ([]Diff, error)
+
+// Reconciler rebuilds the derived tables of one database.
+type Reconciler struct {
+	db       *sql.DB
+	ledger   *ledger.Ledger
+	accounts *account.Store
+	steps    []Step
+}
+
+// New returns a Reconciler that runs steps after the ledger and the
+// legacy cash_bank totals.
+func New(db *sql.DB, l *ledger.Ledger, accounts *account.Store, steps ...Step) *Reconciler {
+	r := &Reconciler{db: db, ledger: l, accounts: accounts}
+	r.steps = append([]Step{r.legacyTotals}, steps...)
+	return r
+}
+
+// Run reconciles userID, or every user if userID is empty, each in a
+// transaction of its own. With every user, only the reports with diffs
+// are returned.
+func (r *Reconciler) Run(userID string, dryRun bool) ([]Report, error) {
+	if userID != "" {
+		report, err := r.User(userID, dryRun)
+		if err != nil {
+			return nil, err
+		}
+		return []Report{report}, nil
+	}
+
+	users, err := r.Users()
+	if err != nil {
+		return nil, err
+	}
+	var reports []Report
+	for _, id := range users {
+		report, err := r.User(id, dryRun)
+		if err != nil {
+			return reports, fmt.Errorf("error reconciling user %s: %w", id, err)
+		}
+		if len(report.Diffs) > 0 {
+			reports = append(reports, report)
+		}
+	}
+	return reports, nil
+}
+
+// User reconciles the derived tables of userID. In a dry run nothing is
+// written.
+func (r *Reconciler) User(userID string, dryRun bool) (Report, error) {
+	report := Report{UserID: userID}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return report, fmt.Errorf("error starting reconciliation: %v", err)
+	}
+	defer tx.Rollback()
+
+	if report.Diffs, err = r.UserTx(tx, userID); err != nil {
+		return report, err
+	}
+
+	if dryRun || len(report.Diffs) == 0 {
+		return report, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return report, fmt.Errorf("error committing reconciliation: %v", err)
+	}
+	report.Applied = true
+	log.Printf("Reconciled user %s: %d amounts changed", userID, len(report.Diffs))
+	return report, nil
+}
+
+// UserTx reconciles the derived tables of userID inside the caller's
+// transaction, e.g. after rows were written without posting them, and
+// returns what changed.
+func (r *Reconciler) UserTx(tx *sql.Tx, userID string) ([]Diff, error) {
+	before, err := r.ledger.SnapshotTx(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := r.entriesTx(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.ledger.RebuildTx(tx, userID, entries); err != nil {
+		return nil, err
+	}
+	after, err := r.ledger.SnapshotTx(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	diffs := compare(before, after)
+
+	for _, step := range r.steps {
+		more, err := step(tx, userID)
+		if err != nil {
+			return nil, err
+		}
+		diffs = append(diffs, more...)
+	}
+	return diffs, nil
+}
+
+// compare returns the cells whose amount differs between two snapshots,
+// sorted by table, key, account and column. A missing cell is zero.
+func compare(before, after ledger.Snapshot) []Diff {
+	cells := map[ledger.Cell]bool{}
+	for c := range before {
+		cells[c] = true
+	}
+	for c := range after {
+		cells[c] = true
+	}
+
+	var diffs []Diff
+	for c := range cells {
+		if before[c] == after[c] {
+			continue
+		}
+		diffs = append(diffs, Diff{Table: c.Table, Key: c.Key, AccountID: c.Account, Column: c.Column,
+			Before: before[c], After: after[c]})
+	}
+	sort.Slice(diffs, func(i, j int) bool {
+		a, b := diffs[i], diffs[j]
+		if a.Table != b.Table {
+			return a.Table < b.Table
+		}
+		if a.Key != b.Key {
+			return a.Key < b.Key
+		}
+		if a.AccountID != b.AccountID {
+			return a.AccountID < b.AccountID
+		}
+		return a.Column < b.Column
+	})
+	return diffs
+}
+
+// legacyTotals rewrites the user's row of the legacy cash_bank table, still
+// read by the dashboard, with the cash and bank balances of the current
+// month, as cash_bank_management does after a manual correction.
+func (r *Reconciler) legacyTotals(tx *sql.Tx, userID string) ([]Diff, error) {
+	columns, err := tableColumns(tx, "cash_bank")
+	if err != nil || len(columns) == 0 {
+		return nil, err
+	}
+	month := time.Now().Format("2006-01")
+
+	var cash, bank money.Money
+	err = tx.QueryRow(`
+		SELECT balance_cash_amount, balance_bank_amount FROM monthly_cash_bank_balance
+		WHERE user_id = ? AND year_month <= ?
+		ORDER BY year_month DESC LIMIT 1`, userID, month).Scan(&cash, &bank)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("error reading current balances: %v", err)
+	}
+
+	var oldCash, oldBank, oldTotal money.Money
+	err = tx.QueryRow(`SELECT cash_amount, bank_amount, monthly_total FROM cash_bank WHERE user_id = ? ORDER BY created_at DESC LIMIT 1`,
+		userID).Scan(&oldCash, &oldBank, &oldTotal)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading cash_bank: %v", err)
+	}
+
+	total := cash + bank
+	var cashPercent, bankPercent float64
+	if total > 0 {
+		cashPercent = (cash.Float64() / total.Float64()) * 100
+		bankPercent = (bank.Float64() / total.Float64()) * 100
+	}
+	_, err = tx.Exec(`
+		UPDATE cash_bank
+		SET month = ?, cash_amount = ?, cash_percent = ?, bank_amount = ?, bank_percent = ?, monthly_total = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE user_id = ?`, month, cash, cashPercent, bank, bankPercent, total, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error updating cash_bank: %v", err)
+	}
+
+	var diffs []Diff
+	for _, d := range []Diff{
+		{Column: "cash_amount", Before: oldCash, After: cash},
+		{Column: "bank_amount", Before: oldBank, After: bank},
+		{Column: "monthly_total", Before: oldTotal, After: total},
+	} {
+		if d.Before != d.After {
+			d.Table, d.Key = "cash_bank", month
+			diffs = append(diffs, d)
+		}
+	}
+	return diffs, nil
+}
@@ -0,0 +1,165 @@
+package reconcile
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+
+	"backend/common/account"
+	"backend/common/dbtest"
+	"backend/common/ledger"
+	"backend/common/money"
+)
+
+// newTestReconciler returns a database where u1's transactions and
+// balances agree: an income, an expense, a bill with one month paid, a
+// transfer with a fee and a manual correction of cash.
+func newTestReconciler(t *testing.T, steps ...Step) (*sql.DB, *ledger.Ledger, *Reconciler) {
+	t.Helper()
+
+	db := dbtest.Open(t)
+	_, err := db.Exec(`
+		CREATE TABLE incomes (id INTEGER PRIMARY KEY, user_id TEXT, amount INTEGER, base_amount INTEGER, date TEXT, payment_method TEXT);
+		CREATE TABLE expenses (id INTEGER PRIMARY KEY, user_id TEXT, amount INTEGER, base_amount INTEGER, date TEXT, payment_method TEXT, bill_id INTEGER);
+		CREATE TABLE bills (id INTEGER PRIMARY KEY, user_id TEXT, amount INTEGER, base_amount INTEGER, start_date TEXT,
+			duration_months INTEGER, payment_method TEXT);
+		CREATE TABLE bill_payments (id INTEGER PRIMARY KEY, bill_id INTEGER, year_month TEXT, paid BOOLEAN, payment_date TEXT);
+		CREATE TABLE transfers (id INTEGER PRIMARY KEY, user_id TEXT, from_account_id INTEGER, to_account_id INTEGER,
+			amount INTEGER, fee INTEGER, date TEXT);
+		CREATE TABLE cash_bank (id INTEGER PRIMARY KEY, user_id TEXT, month TEXT, cash_amount INTEGER, cash_percent REAL,
+			bank_amount INTEGER, bank_percent REAL, monthly_total INTEGER, created_at TIMESTAMP, updated_at TIMESTAMP)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := ledger.New(db)
+	if err != nil {
+		t.Fatalf("Failed to create ledger: %v", err)
+	}
+	accounts, err := account.NewStore(db, l)
+	if err != nil {
+		t.Fatalf("Failed to create accounts: %v", err)
+	}
+	list, err := accounts.List("u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cash, bank := list[0], list[1]
+
+	_, err = db.Exec(`
+		INSERT INTO incomes (user_id, amount, base_amount, date, payment_method, account_id) VALUES ('u1', 1000, 1000, '2025-01-10', 'bank', ?);
+		INSERT INTO expenses (user_id, amount, base_amount, date, payment_method, account_id) VALUES ('u1', 300, 300, '2025-01-12', 'cash', ?);
+		INSERT INTO bills (id, user_id, amount, base_amount, start_date, duration_months, payment_method, account_id)
+			VALUES (1, 'u1', 50, 50, '2025-02-01', 2, 'bank', ?);
+		INSERT INTO bill_payments (bill_id, year_month, paid, payment_date) VALUES (1, '2025-02', 1, '2025-02-03'), (1, '2025-03', 0, NULL);
+		INSERT INTO expenses (user_id, amount, base_amount, date, payment_method, account_id, bill_id) VALUES ('u1', 50, 50, '2025-02-03', 'bank', ?, 1);
+		INSERT INTO transfers (user_id, from_account_id, to_account_id, amount, fee, date) VALUES ('u1', ?, ?, 100, 2, '2025-01-15');
+		INSERT INTO cash_bank (user_id, month, cash_amount, cash_percent, bank_amount, bank_percent, monthly_total)
+			VALUES ('u1', '2025-01', 1, 0, 1, 0, 2)`,
+		bank.ID, cash.ID, bank.ID, bank.ID, bank.ID, cash.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = l.Post(
+		bank.Entry(ledger.Income, 1000, "2025-01-10"),
+		cash.Entry(ledger.Expense, 300, "2025-01-12"),
+		bank.Entry(ledger.Bill, 50, "2025-03-01"),
+		bank.Entry(ledger.Expense, 50, "2025-02-03"),
+		bank.Entry(ledger.Adjustment, -100, "2025-01-15"),
+		cash.Entry(ledger.Adjustment, 100, "2025-01-15"),
+		bank.Entry(ledger.Expense, 2, "2025-01-15"),
+		cash.Entry(ledger.Adjustment, 500, "2025-01-20"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, l, New(db, l, accounts, steps...)
+}
+
+func TestBalancesThatAgreeAreLeftAlone(t *testing.T) {
+	db, _, r := newTestReconciler(t)
+	// Only the legacy cash_bank row is out of date
+	db.Exec(`UPDATE cash_bank SET cash_amount = 300, bank_amount = 798, monthly_total = 1098`)
+
+	report, err := r.User("u1", false)
+	if err != nil {
+		t.Fatalf("User: %v", err)
+	}
+	if len(report.Diffs) != 0 || report.Applied {
+		t.Errorf("Report = %+v, want no diffs", report)
+	}
+}
+
+func TestReconcileRepairsDrift(t *testing.T) {
+	var stepped []string
+	step := func(tx *sql.Tx, userID string) ([]Diff, error) {
+		stepped = append(stepped, userID)
+		return nil, nil
+	}
+	db, l, r := newTestReconciler(t, step)
+	_, err := db.Exec(`
+		UPDATE monthly_cash_bank_balance SET income_bank_amount = 0 WHERE year_month = '2025-01';
+		UPDATE monthly_balance SET total_balance = 12345 WHERE year_month = '2025-02';
+		DELETE FROM daily_account_balance WHERE date = '2025-01-12';
+		INSERT INTO monthly_balance (user_id, year_month, income_amount) VALUES ('u1', '2024-06', 999)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A dry run reports the drift and changes nothing
+	before := dbtest.Snapshot(t, db)
+	reports, err := r.Run("", true)
+	if err != nil {
+		t.Fatalf("Dry run: %v", err)
+	}
+	if len(reports) != 1 || reports[0].UserID != "u1" || reports[0].Applied {
+		t.Fatalf("Dry run reports = %+v", reports)
+	}
+	want := Diff{Table: "monthly_cash_bank_balance", Key: "2025-01", Column: "income_bank_amount", Before: 0, After: 1000}
+	found := false
+	for _, d := range reports[0].Diffs {
+		found = found || d == want
+	}
+	if !found {
+		t.Errorf("Dry run diffs %+v lack %+v", reports[0].Diffs, want)
+	}
+	if after := dbtest.Snapshot(t, db); !reflect.DeepEqual(before, after) {
+		t.Errorf("Dry run changed the database")
+	}
+
+	report, err := r.User("u1", false)
+	if err != nil || !report.Applied || !reflect.DeepEqual(report.Diffs, reports[0].Diffs) {
+		t.Fatalf("User = %+v, %v; want the dry run's diffs applied", report, err)
+	}
+	if !reflect.DeepEqual(stepped, []string{"u1", "u1"}) {
+		t.Errorf("Step ran for %v", stepped)
+	}
+
+	// The manual correction of cash is kept
+	balances, err := l.Balances("u1", "2025-12-31")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balances[1] != 300 || balances[2] != 798 {
+		t.Errorf("Cash %v, bank %v; want 3.00, 7.98", balances[1], balances[2])
+	}
+	var total money.Money
+	db.QueryRow(`SELECT total_balance FROM monthly_balance WHERE user_id = 'u1' AND year_month = '2025-02'`).Scan(&total)
+	if total != 1148 {
+		t.Errorf("February total = %v, want 11.48", total)
+	}
+	var orphans int
+	db.QueryRow(`SELECT COUNT(*) FROM monthly_balance WHERE year_month = '2024-06'`).Scan(&orphans)
+	if orphans != 0 {
+		t.Errorf("Orphan monthly_balance row was kept")
+	}
+	var cash, bank money.Money
+	db.QueryRow(`SELECT cash_amount, bank_amount FROM cash_bank WHERE user_id = 'u1'`).Scan(&cash, &bank)
+	if cash != 300 || bank != 798 {
+		t.Errorf("cash_bank = %v, %v; want 3.00, 7.98", cash, bank)
+	}
+
+	again, err := r.User("u1", false)
+	if err != nil || len(again.Diffs) != 0 {
+		t.Errorf("Second run = %+v, %v; want no diffs", again, err)
+	}
+}
@@ -0,0 +1,387 @@
+package reconcile
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"backend/common/account"
+	"backend/common/ledger"
+	"backend/common/money"
+)
+
+// sourceTables are the tables whose users are reconciled by Run.
+var sourceTables = []string{"incomes", "expenses", "bills", account.TransfersTable, "accounts", "monthly_cash_bank_balance"}
+
+// Users returns every user with transactions, accounts or balances.
+func (r *Reconciler) Users() ([]string, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var selects []string
+	for _, table := range sourceTables {
+		columns, err := tableColumns(tx, table)
+		if err != nil {
+			return nil, err
+		}
+		if columns["user_id"] {
+			selects = append(selects, fmt.Sprintf("SELECT user_id FROM %s", table))
+		}
+	}
+	if len(selects) == 0 {
+		return nil, nil
+	}
+
+	rows, err := tx.Query(strings.Join(selects, " UNION ") + " ORDER BY user_id")
+	if err != nil {
+		return nil, fmt.Errorf("error reading users: %v", err)
+	}
+	defer rows.Close()
+
+	var users []string
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			return nil, fmt.Errorf("error reading users: %v", err)
+		}
+		users = append(users, userID)
+	}
+	return users, rows.Err()
+}
+
+// sources reads the source rows of one user and turns them into the
+// entries the services posted for them.
+type sources struct {
+	r        *Reconciler
+	tx       *sql.Tx
+	userID   string
+	accounts map[int64]account.Account
+	entries  []ledger.Entry
+}
+
+// entriesTx returns every entry the source rows of userID account for,
+// plus the adjustments they do not explain.
+func (r *Reconciler) entriesTx(tx *sql.Tx, userID string) ([]ledger.Entry, error) {
+	s := &sources{r: r, tx: tx, userID: userID, accounts: map[int64]account.Account{}}
+
+	list, err := r.accounts.ListTx(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range list {
+		s.accounts[a.ID] = a
+		if a.OpeningBalance != 0 {
+			s.entries = append(s.entries, a.Entry(ledger.Adjustment, a.OpeningBalance, a.OpeningDate))
+		}
+	}
+
+	for _, read := range []func() error{
+		func() error { return s.transactions("incomes", ledger.Income) },
+		func() error { return s.transactions("expenses", ledger.Expense) },
+		s.bills,
+		s.transfers,
+	} {
+		if err := read(); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.manualAdjustments(); err != nil {
+		return nil, err
+	}
+	return s.entries, nil
+}
+
+// account returns the account a row goes to, as the services resolve it:
+// id if set, otherwise the user's first account of method.
+func (s *sources) account(id int64, method string) (account.Account, error) {
+	if a, ok := s.accounts[id]; ok {
+		return a, nil
+	}
+	a, err := s.r.accounts.ResolveTx(s.tx, s.userID, id, method)
+	if err != nil {
+		return account.Account{}, err
+	}
+	s.accounts[a.ID] = a
+	return a, nil
+}
+
+// amountColumn is the base amount of a row, or its amount in tables that
+// predate currencies.
+func amountColumn(columns map[string]bool) string {
+	if columns["base_amount"] {
+		return "COALESCE(base_amount, amount)"
+	}
+	return "amount"
+}
+
+// accountColumn is the account of a row, zero in tables that predate
+// accounts.
+func accountColumn(columns map[string]bool, column string) string {
+	if columns[column] {
+		return fmt.Sprintf("COALESCE(%s, 0)", column)
+	}
+	return "0"
+}
+
+// transactions adds one entry of kind per row of an incomes or expenses
+// table.
+func (s *sources) transactions(table string, kind ledger.Kind) error {
+	columns, err := tableColumns(s.tx, table)
+	if err != nil || len(columns) == 0 {
+		return err
+	}
+	rows, err := s.tx.Query(fmt.Sprintf(`
+		SELECT %s, date, COALESCE(payment_method, 'cash'), %s FROM %s WHERE user_id = ?`,
+		amountColumn(columns), accountColumn(columns, "account_id"), table), s.userID)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %v", table, err)
+	}
+	type row struct {
+		amount       money.Money
+		date, method string
+		accountID    int64
+	}
+	var read []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.amount, &r.date, &r.method, &r.accountID); err != nil {
+			rows.Close()
+			return fmt.Errorf("error reading %s: %v", table, err)
+		}
+		read = append(read, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error reading %s: %v", table, err)
+	}
+
+	for _, r := range read {
+		a, err := s.account(r.accountID, r.method)
+		if err != nil {
+			return fmt.Errorf("error resolving the account of %s: %w", table, err)
+		}
+		s.entries = append(s.entries, a.Entry(kind, r.amount, r.date))
+	}
+	return nil
+}
+
+// bills adds the unpaid months of every bill. Paid months are expenses,
+// read with the expenses, except those of card statements: paying one
+// moves the money from the bank account to the card.
+func (s *sources) bills() error {
+	columns, err := tableColumns(s.tx, "bills")
+	if err != nil || len(columns) == 0 {
+		return err
+	}
+	rows, err := s.tx.Query(fmt.Sprintf(`
+		SELECT id, %s, start_date, duration_months, COALESCE(payment_method, 'cash'), %s, %s
+		FROM bills WHERE user_id = ?`,
+		amountColumn(columns), accountColumn(columns, "account_id"), accountColumn(columns, account.StatementColumn)), s.userID)
+	if err != nil {
+		return fmt.Errorf("error reading bills: %v", err)
+	}
+	type bill struct {
+		id                   int64
+		amount               money.Money
+		start, method        string
+		months               int
+		accountID, statement int64
+	}
+	var bills []bill
+	for rows.Next() {
+		var b bill
+		if err := rows.Scan(&b.id, &b.amount, &b.start, &b.months, &b.method, &b.accountID, &b.statement); err != nil {
+			rows.Close()
+			return fmt.Errorf("error reading bills: %v", err)
+		}
+		bills = append(bills, b)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error reading bills: %v", err)
+	}
+
+	paid, err := s.paidMonths()
+	if err != nil {
+		return err
+	}
+	for _, b := range bills {
+		a, err := s.account(b.accountID, b.method)
+		if err != nil {
+			return fmt.Errorf("error resolving the account of bill %d: %w", b.id, err)
+		}
+
+		if b.statement != 0 {
+			card, err := s.account(b.statement, ledger.Credit)
+			if err != nil {
+				return fmt.Errorf("error resolving the card of bill %d: %w", b.id, err)
+			}
+			for month, date := range paid[b.id] {
+				if date == "" {
+					date = ledger.BillDate(month)
+				}
+				s.entries = append(s.entries,
+					a.Entry(ledger.Adjustment, -b.amount, date),
+					card.Entry(ledger.Adjustment, b.amount, date))
+			}
+			continue
+		}
+
+		months := map[string]bool{}
+		for month := range paid[b.id] {
+			months[month] = true
+		}
+		entries, err := ledger.BillEntries(s.userID, a.Method(), a.ID, b.amount, b.start, b.months, months)
+		if err != nil {
+			return fmt.Errorf("error reading bill %d: %w", b.id, err)
+		}
+		s.entries = append(s.entries, entries...)
+	}
+	return nil
+}
+
+// paidMonths returns the paid months of the user's bills, with their
+// payment date: bill id, then YYYY-MM, then date.
+func (s *sources) paidMonths() (map[int64]map[string]string, error) {
+	columns, err := tableColumns(s.tx, "bill_payments")
+	if err != nil || len(columns) == 0 {
+		return nil, err
+	}
+	rows, err := s.tx.Query(`
+		SELECT bp.bill_id, bp.year_month, COALESCE(bp.payment_date, '')
+		FROM bill_payments bp JOIN bills b ON b.id = bp.bill_id
+		WHERE b.user_id = ? AND bp.paid = 1`, s.userID)
+	if err != nil {
+		return nil, fmt.Errorf("error reading bill payments: %v", err)
+	}
+	defer rows.Close()
+
+	paid := map[int64]map[string]string{}
+	for rows.Next() {
+		var billID int64
+		var month, date string
+		if err := rows.Scan(&billID, &month, &date); err != nil {
+			return nil, fmt.Errorf("error reading bill payments: %v", err)
+		}
+		if paid[billID] == nil {
+			paid[billID] = map[string]string{}
+		}
+		paid[billID][month] = date
+	}
+	return paid, rows.Err()
+}
+
+// transfers adds the two ends and the fee of every transfer.
+func (s *sources) transfers() error {
+	columns, err := tableColumns(s.tx, account.TransfersTable)
+	if err != nil || len(columns) == 0 {
+		return err
+	}
+	rows, err := s.tx.Query(`SELECT id, from_account_id, to_account_id, amount, fee, date FROM transfers WHERE user_id = ?`, s.userID)
+	if err != nil {
+		return fmt.Errorf("error reading transfers: %v", err)
+	}
+	type transfer struct {
+		id, from, to int64
+		amount, fee  money.Money
+		date         string
+	}
+	var transfers []transfer
+	for rows.Next() {
+		var t transfer
+		if err := rows.Scan(&t.id, &t.from, &t.to, &t.amount, &t.fee, &t.date); err != nil {
+			rows.Close()
+			return fmt.Errorf("error reading transfers: %v", err)
+		}
+		transfers = append(transfers, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error reading transfers: %v", err)
+	}
+
+	for _, t := range transfers {
+		from, err := s.account(t.from, "")
+		if err != nil {
+			return fmt.Errorf("error resolving the accounts of transfer %d: %w", t.id, err)
+		}
+		to, err := s.account(t.to, "")
+		if err != nil {
+			return fmt.Errorf("error resolving the accounts of transfer %d: %w", t.id, err)
+		}
+		s.entries = append(s.entries,
+			from.Entry(ledger.Adjustment, -t.amount, t.date),
+			to.Entry(ledger.Adjustment, t.amount, t.date))
+		if t.fee > 0 {
+			s.entries = append(s.entries, from.Entry(ledger.Expense, t.fee, t.date))
+		}
+	}
+	return nil
+}
+
+// manualAdjustments keeps the adjustments posted to each account and day
+// that the entries read so far do not explain: the manual cash and bank
+// corrections.
+func (s *sources) manualAdjustments() error {
+	posted, err := s.r.ledger.AdjustmentsTx(s.tx, s.userID)
+	if err != nil {
+		return err
+	}
+	for _, e := range s.entries {
+		if e.Kind != ledger.Adjustment {
+			continue
+		}
+		date := e.Date
+		if len(date) > 10 {
+			date = date[:10]
+		}
+		if posted[e.Account] == nil {
+			posted[e.Account] = map[string]money.Money{}
+		}
+		posted[e.Account][date] -= e.Amount
+	}
+
+	for id, days := range posted {
+		for date, amount := range days {
+			if amount == 0 {
+				continue
+			}
+			a, ok := s.accounts[id]
+			if !ok {
+				var err error
+				if a, err = s.account(id, ""); errors.Is(err, account.ErrNotFound) {
+					log.Printf("Dropping adjustment of %s on %s of deleted account %d of user %s", amount, date, id, s.userID)
+					continue
+				} else if err != nil {
+					return err
+				}
+			}
+			s.entries = append(s.entries, a.Entry(ledger.Adjustment, amount, date))
+		}
+	}
+	return nil
+}
+
+// tableColumns returns the columns of table, none if it does not exist.
+func tableColumns(tx *sql.Tx, table string) (map[string]bool, error) {
+	rows, err := tx.Query(`SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return nil, fmt.Errorf("error reading columns of %s: %v", table, err)
+	}
+	defer rows.Close()
+
+	columns := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("error reading columns of %s: %v", table, err)
+		}
+		columns[name] = true
+	}
+	return columns, rows.Err()
+}
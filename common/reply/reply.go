@@ -0,0 +1,23 @@
+// Package reply writes the JSON answers of the handlers in common, in the
+// envelope the app reads: {"success", "message", "data"}, without the
+// message or the data when there are none.
+package reply
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// JSON answers with status and the envelope of success, message and data.
+func JSON(w http.ResponseWriter, status int, success bool, message string, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	response := map[string]interface{}{"success": success}
+	if message != "" {
+		response["message"] = message
+	}
+	if data != nil {
+		response["data"] = data
+	}
+	json.NewEncoder(w).Encode(response)
+}
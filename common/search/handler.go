@@ -0,0 +1,47 @@
+package search
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"backend/common/auth"
+	"backend/common/listing"
+	"backend/common/reply"
+)
+
+// Handler searches the data of the authenticated user for ?q=, with the
+// filters, sort and page of listing in the other parameters. Wrap it in
+// auth.Require.
+func (ix *Index) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			reply.JSON(w, http.StatusMethodNotAllowed, false, "Method not allowed", nil)
+			return
+		}
+		userID := auth.UserID(r)
+		if userID == "" {
+			reply.JSON(w, http.StatusBadRequest, false, "User ID is required", nil)
+			return
+		}
+
+		q, err := listing.FromValues(r.URL.Query())
+		if err == nil {
+			err = q.Normalize()
+		}
+		if err != nil {
+			reply.JSON(w, http.StatusBadRequest, false, err.Error(), nil)
+			return
+		}
+		results, err := ix.Search(userID, r.URL.Query().Get("q"), q)
+		switch {
+		case errors.Is(err, ErrEmptyQuery):
+			reply.JSON(w, http.StatusBadRequest, false, err.Error(), nil)
+		case err != nil:
+			log.Printf("Error searching: %v", err)
+			reply.JSON(w, http.StatusInternalServerError, false, "Error searching", nil)
+		default:
+			reply.JSON(w, http.StatusOK, true, "", results)
+		}
+	}
+}
@@ -0,0 +1,480 @@
+// Package search finds users' transactions, bills and categories by the
+// words in their descriptions, names and categories.
+//
+// The search_documents table holds one document per row of expenses,
+// incomes, bills and categories. Triggers on those tables keep it up to
+// date on every insert, update and delete, whichever service writes, so
+// deletions to the trash, restores and imports are indexed like any other
+// write. The triggers only write to plain tables, so services built
+// without FTS5 can write too.
+//
+// When the driver is built with FTS5 (go build -tags sqlite_fts5) the
+// documents are also in the FTS5 table search_index, which catches up
+// with the rows the triggers list in search_pending before every search.
+// Otherwise the matching is done here. Both ignore case and accents:
+// "cafe" finds "Café" and "camion" finds "camión". Every word of a search
+// must start a word of the document, so "gasol" finds "Gasolina".
+package search
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"backend/common/listing"
+	"backend/common/money"
+)
+
+// ErrEmptyQuery is returned for searches without any word.
+var ErrEmptyQuery = errors.New("search query is empty")
+
+// source is a table whose rows are indexed.
+type source struct {
+	table string
+	kind  string
+	// code tells the rows of each table apart in the index, whose rowid
+	// is the row id times len(sources) plus the code.
+	code int
+	// document is the SQL expression of the indexed text, on NEW or OLD.
+	document string
+	// list selects the rows found as a listing, nil for rows that are not
+	// transactions.
+	list *string
+}
+
+var (
+	transactionList = `SELECT id, '%s' AS type, date, category, payment_method, COALESCE(base_amount, amount) AS base_amount,
+			amount, currency, description, NULL AS name
+		FROM %s`
+	billList = `SELECT id, 'bill' AS type, due_date AS date, category, payment_method, COALESCE(base_amount, amount) AS base_amount,
+			amount, currency, NULL AS description, name
+		FROM bills`
+	expenseList = fmt.Sprintf(transactionList, "expense", "expenses")
+	incomeList  = fmt.Sprintf(transactionList, "income", "incomes")
+)
+
+var sources = []source{
+	{"expenses", "expense", 0, "COALESCE(%[1]s.description, '') || ' ' || COALESCE(%[1]s.category, '')", &expenseList},
+	{"incomes", "income", 1, "COALESCE(%[1]s.description, '') || ' ' || COALESCE(%[1]s.category, '')", &incomeList},
+	{"bills", "bill", 2, "COALESCE(%[1]s.name, '') || ' ' || COALESCE(%[1]s.category, '')", &billList},
+	{"categories", "category", 3, "COALESCE(%[1]s.name, '')", nil},
+}
+
+// Result is a transaction or bill found, with amounts in units in JSON.
+type Result struct {
+	Type          string      `json:"type"` // expense, income or bill
+	ID            int64       `json:"id"`
+	Date          string      `json:"date"` // the due date of bills
+	Category      string      `json:"category"`
+	PaymentMethod string      `json:"payment_method,omitempty"`
+	Amount        money.Money `json:"amount"`
+	Currency      string      `json:"currency,omitempty"`
+	BaseAmount    money.Money `json:"base_amount"`
+	Description   string      `json:"description,omitempty"`
+	Name          string      `json:"name,omitempty"` // of bills
+}
+
+// Category is a category found.
+type Category struct {
+	ID    int64  `json:"id"`
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Emoji string `json:"emoji,omitempty"`
+}
+
+// Results are what a search found.
+type Results struct {
+	Results    []Result     `json:"results"`
+	Categories []Category   `json:"categories"`
+	Page       listing.Page `json:"page"`
+}
+
+// Index searches the rows of one database.
+type Index struct {
+	db  *sql.DB
+	fts bool
+	// sources are the indexed tables the database has.
+	sources []source
+}
+
+// New creates the index tables and the triggers of the indexed tables the
+// database has. The documents of a table are only written again when its
+// triggers are missing or out of date, so a start does not hold the write
+// lock of the database while everything is indexed.
+func New(db *sql.DB) (*Index, error) {
+	ix := &Index{db: db}
+	if err := ix.createTables(); err != nil {
+		return nil, err
+	}
+
+	for _, s := range sources {
+		var exists int
+		err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", s.table).Scan(&exists)
+		if err != nil {
+			return nil, err
+		}
+		if exists == 0 {
+			continue
+		}
+		if err := s.index(db); err != nil {
+			return nil, err
+		}
+		ix.sources = append(ix.sources, s)
+	}
+	if err := ix.sync(); err != nil {
+		return nil, err
+	}
+	log.Printf("Search index ready (fts5: %v)", ix.fts)
+	return ix, nil
+}
+
+// createTables creates search_documents and search_pending, and
+// search_index if the driver has FTS5. It replaces the layout of older
+// versions, where the triggers wrote to search_index, and fails when
+// search_index is an FTS5 table the driver cannot open.
+func (ix *Index) createTables() error {
+	if err := ix.db.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&ix.fts); err != nil {
+		return fmt.Errorf("error reading SQLite options: %v", err)
+	}
+
+	layout := map[string]string{}
+	rows, err := ix.db.Query("SELECT name, sql FROM sqlite_master WHERE name IN ('search_index', 'search_documents')")
+	if err != nil {
+		return fmt.Errorf("error reading search index: %v", err)
+	}
+	for rows.Next() {
+		var name string
+		var definition sql.NullString
+		if err := rows.Scan(&name, &definition); err != nil {
+			rows.Close()
+			return fmt.Errorf("error reading search index: %v", err)
+		}
+		layout[name] = strings.ToLower(definition.String)
+	}
+	rows.Close()
+
+	index, hasIndex := layout["search_index"]
+	isFTS := strings.Contains(index, "fts5")
+	if isFTS && !ix.fts {
+		return errors.New("the search index is an FTS5 table: build with -tags sqlite_fts5")
+	}
+
+	tx, err := ix.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, ok := layout["search_documents"]; !ok || (hasIndex && !isFTS) {
+		// The triggers of older versions write to search_index: they are
+		// replaced, and the documents written again, by index
+		triggers, err := searchTriggers(tx)
+		if err != nil {
+			return err
+		}
+		for _, name := range triggers {
+			if _, err := tx.Exec("DROP TRIGGER " + name); err != nil {
+				return fmt.Errorf("error replacing search index: %v", err)
+			}
+		}
+		if _, err := tx.Exec("DROP TABLE IF EXISTS search_index"); err != nil {
+			return fmt.Errorf("error replacing search index: %v", err)
+		}
+	}
+
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS search_documents (
+			rowid INTEGER PRIMARY KEY,
+			user_id TEXT NOT NULL,
+			kind TEXT NOT NULL,
+			row_id INTEGER NOT NULL,
+			document TEXT NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_search_documents_user ON search_documents(user_id, kind)`,
+		`CREATE TABLE IF NOT EXISTS search_pending (rowid INTEGER PRIMARY KEY)`,
+	}
+	if ix.fts {
+		statements = append(statements, `CREATE VIRTUAL TABLE IF NOT EXISTS search_index USING fts5(
+			user_id UNINDEXED, kind UNINDEXED, row_id UNINDEXED, document,
+			tokenize = 'unicode61 remove_diacritics 2')`)
+		if !isFTS {
+			// A new FTS5 table starts with every document
+			statements = append(statements, `INSERT OR IGNORE INTO search_pending (rowid) SELECT rowid FROM search_documents`)
+		}
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return fmt.Errorf("error creating search index: %v", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// searchTriggers returns the names of the triggers that index rows.
+func searchTriggers(tx *sql.Tx) ([]string, error) {
+	rows, err := tx.Query("SELECT name FROM sqlite_master WHERE type = 'trigger' AND name LIKE 'search!_%' ESCAPE '!'")
+	if err != nil {
+		return nil, fmt.Errorf("error reading search triggers: %v", err)
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// rowid is the SQL expression of the index rowid of the row with id.
+func (s source) rowid(id string) string {
+	return fmt.Sprintf("%s * %d + %d", id, len(sources), s.code)
+}
+
+// triggers returns the statements that create the triggers indexing the
+// rows of s, by trigger name.
+func (s source) triggers() map[string]string {
+	insert := fmt.Sprintf("INSERT INTO search_documents (rowid, user_id, kind, row_id, document) VALUES (%[1]s, NEW.user_id, '%[2]s', NEW.id, %[3]s); "+
+		"INSERT OR IGNORE INTO search_pending (rowid) VALUES (%[1]s);",
+		s.rowid("NEW.id"), s.kind, fmt.Sprintf(s.document, "NEW"))
+	remove := fmt.Sprintf("DELETE FROM search_documents WHERE rowid = %[1]s; INSERT OR IGNORE INTO search_pending (rowid) VALUES (%[1]s);",
+		s.rowid("OLD.id"))
+
+	triggers := map[string]string{}
+	for event, body := range map[string]string{"INSERT": insert, "UPDATE": remove + " " + insert, "DELETE": remove} {
+		name := fmt.Sprintf("search_%s_%s", s.table, strings.ToLower(event))
+		triggers[name] = fmt.Sprintf("CREATE TRIGGER %s AFTER %s ON %s BEGIN %s END", name, event, s.table, body)
+	}
+	return triggers
+}
+
+// index (re)creates the triggers of s and writes its documents again,
+// unless the triggers are already the ones it would create.
+func (s source) index(db *sql.DB) error {
+	triggers := s.triggers()
+	current := 0
+	for name, statement := range triggers {
+		var existing string
+		err := db.QueryRow("SELECT sql FROM sqlite_master WHERE type = 'trigger' AND name = ?", name).Scan(&existing)
+		if err != nil && err != sql.ErrNoRows {
+			return fmt.Errorf("error reading search triggers: %v", err)
+		}
+		if existing == statement {
+			current++
+		}
+	}
+	if current == len(triggers) {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for name, statement := range triggers {
+		if _, err := tx.Exec("DROP TRIGGER IF EXISTS " + name); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(statement); err != nil {
+			return fmt.Errorf("error creating trigger on %s: %v", s.table, err)
+		}
+	}
+	// The documents replaced and the new ones are both pending
+	for _, statement := range []string{
+		"INSERT OR IGNORE INTO search_pending (rowid) SELECT rowid FROM search_documents WHERE kind = ?",
+		"DELETE FROM search_documents WHERE kind = ?",
+		fmt.Sprintf("INSERT INTO search_documents (rowid, user_id, kind, row_id, document) SELECT %s, user_id, ?, id, %s FROM %s",
+			s.rowid("id"), fmt.Sprintf(s.document, s.table), s.table),
+		"INSERT OR IGNORE INTO search_pending (rowid) SELECT rowid FROM search_documents WHERE kind = ?",
+	} {
+		if _, err := tx.Exec(statement, s.kind); err != nil {
+			return fmt.Errorf("error indexing %s: %v", s.table, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// sync copies the pending documents to the FTS5 table, in one
+// transaction, and empties search_pending. Without FTS5 it only empties
+// it.
+func (ix *Index) sync() error {
+	var pending bool
+	if err := ix.db.QueryRow("SELECT EXISTS(SELECT 1 FROM search_pending)").Scan(&pending); err != nil {
+		return fmt.Errorf("error reading search index: %v", err)
+	}
+	if !pending {
+		return nil
+	}
+
+	tx, err := ix.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	statements := []string{"DELETE FROM search_pending"}
+	if ix.fts {
+		statements = append([]string{
+			"DELETE FROM search_index WHERE rowid IN (SELECT rowid FROM search_pending)",
+			`INSERT INTO search_index (rowid, user_id, kind, row_id, document)
+				SELECT rowid, user_id, kind, row_id, document FROM search_documents
+				WHERE rowid IN (SELECT rowid FROM search_pending)`,
+		}, statements...)
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return fmt.Errorf("error updating search index: %v", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// Match returns the ids of the rows of userID that match text, by kind.
+func (ix *Index) Match(userID, text string) (map[string][]int64, error) {
+	terms := words(text)
+	if len(terms) == 0 {
+		return nil, ErrEmptyQuery
+	}
+	if err := ix.sync(); err != nil {
+		return nil, err
+	}
+
+	var rows *sql.Rows
+	var err error
+	if ix.fts {
+		quoted := make([]string, len(terms))
+		for i, t := range terms {
+			quoted[i] = `"` + t + `"*`
+		}
+		rows, err = ix.db.Query("SELECT kind, row_id, '' FROM search_index WHERE search_index MATCH ? AND user_id = ?",
+			strings.Join(quoted, " "), userID)
+	} else {
+		rows, err = ix.db.Query("SELECT kind, row_id, document FROM search_documents WHERE user_id = ?", userID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error searching: %v", err)
+	}
+	defer rows.Close()
+
+	matches := map[string][]int64{}
+	for rows.Next() {
+		var kind, document string
+		var id int64
+		if err := rows.Scan(&kind, &id, &document); err != nil {
+			return nil, err
+		}
+		if ix.fts || matchesAll(words(document), terms) {
+			matches[kind] = append(matches[kind], id)
+		}
+	}
+	return matches, rows.Err()
+}
+
+// Search returns the transactions and bills of userID that match text,
+// as a page of q, and the categories whose name matches. q must be
+// normalized.
+func (ix *Index) Search(userID, text string, q listing.Query) (Results, error) {
+	results := Results{Results: []Result{}, Categories: []Category{}, Page: listing.Page{Limit: q.Limit}}
+	matches, err := ix.Match(userID, text)
+	if err != nil {
+		return results, err
+	}
+
+	var lists []string
+	var args []interface{}
+	for _, s := range ix.sources {
+		if s.list == nil || len(matches[s.kind]) == 0 {
+			continue
+		}
+		lists = append(lists, fmt.Sprintf("%s WHERE user_id = ? AND id IN (%s)", *s.list, idList(matches[s.kind])))
+		args = append(args, userID)
+	}
+	if len(lists) > 0 {
+		from := "(" + strings.Join(lists, " UNION ALL ") + ")"
+		results.Page, err = q.Run(ix.db, from, args, func(rows *sql.Rows) (listing.Key, error) {
+			var r Result
+			var category, method, currency, description, name sql.NullString
+			err := rows.Scan(&r.ID, &r.Type, &r.Date, &category, &method, &r.BaseAmount, &r.Amount, &currency, &description, &name)
+			r.Category, r.PaymentMethod, r.Currency = category.String, method.String, currency.String
+			r.Description, r.Name = description.String, name.String
+			results.Results = append(results.Results, r)
+			return listing.Key{Type: r.Type, ID: r.ID, Date: r.Date, Amount: r.BaseAmount}, err
+		})
+		if err != nil {
+			return results, err
+		}
+	}
+
+	if ids := matches["category"]; len(ids) > 0 {
+		rows, err := ix.db.Query(fmt.Sprintf("SELECT id, name, type, COALESCE(emoji, '') FROM categories WHERE user_id = ? AND id IN (%s) ORDER BY name",
+			idList(ids)), userID)
+		if err != nil {
+			return results, fmt.Errorf("error reading categories: %v", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var c Category
+			if err := rows.Scan(&c.ID, &c.Name, &c.Type, &c.Emoji); err != nil {
+				return results, err
+			}
+			results.Categories = append(results.Categories, c)
+		}
+		return results, rows.Err()
+	}
+	return results, nil
+}
+
+// idList writes ids as an SQL list. They come from the index, so there
+// is no need for placeholders, and there may be more than SQLite takes.
+func idList(ids []int64) string {
+	list := make([]string, len(ids))
+	for i, id := range ids {
+		list[i] = strconv.FormatInt(id, 10)
+	}
+	return strings.Join(list, ", ")
+}
+
+// matchesAll reports whether every term starts one of words.
+func matchesAll(words, terms []string) bool {
+	for _, t := range terms {
+		found := false
+		for _, w := range words {
+			if strings.HasPrefix(w, t) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// words splits text into lowercase words without accents, as the FTS5
+// tokenizer does.
+func words(text string) []string {
+	return strings.FieldsFunc(fold(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// fold lowercases text and takes the accents off its Latin letters.
+func fold(text string) string {
+	return accents.Replace(strings.ToLower(text))
+}
+
+var accents = strings.NewReplacer(
+	"á", "a", "à", "a", "â", "a", "ä", "a", "ã", "a", "å", "a",
+	"é", "e", "è", "e", "ê", "e", "ë", "e",
+	"í", "i", "ì", "i", "î", "i", "ï", "i",
+	"ó", "o", "ò", "o", "ô", "o", "ö", "o", "õ", "o",
+	"ú", "u", "ù", "u", "û", "u", "ü", "u",
+	"ñ", "n", "ç", "c", "ý", "y", "ÿ", "y",
+)
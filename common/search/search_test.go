@@ -0,0 +1,182 @@
+package search
+
+import (
+	"database/sql"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+
+	"backend/common/dbtest"
+	"backend/common/listing"
+)
+
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db := dbtest.Open(t)
+	_, err := db.Exec(`
+		CREATE TABLE expenses (id INTEGER PRIMARY KEY, user_id TEXT, amount INTEGER, date TEXT, category TEXT,
+			payment_method TEXT, description TEXT, currency TEXT, base_amount INTEGER);
+		CREATE TABLE incomes (id INTEGER PRIMARY KEY, user_id TEXT, amount INTEGER, date TEXT, category TEXT,
+			payment_method TEXT, description TEXT, currency TEXT, base_amount INTEGER);
+		CREATE TABLE bills (id INTEGER PRIMARY KEY, user_id TEXT, name TEXT, amount INTEGER, due_date TEXT, category TEXT,
+			payment_method TEXT, currency TEXT, base_amount INTEGER);
+		CREATE TABLE categories (id INTEGER PRIMARY KEY, user_id TEXT, name TEXT, type TEXT, emoji TEXT);
+		INSERT INTO expenses (id, user_id, amount, date, category, payment_method, description, base_amount) VALUES
+			(1, 'u1', 1299, '2025-01-05', 'Ocio', 'bank', 'Netflix enero', 1299),
+			(2, 'u1', 4500, '2025-01-07', 'Gasolina', 'cash', 'Repsol', 4500),
+			(3, 'u2', 1299, '2025-01-05', 'Ocio', 'bank', 'Netflix', 1299);
+		INSERT INTO bills (id, user_id, name, amount, due_date, category, base_amount) VALUES
+			(1, 'u1', 'NETFLIX', 1299, '2025-02-05', 'Ocio', 1299);
+		INSERT INTO categories (id, user_id, name, type, emoji) VALUES (1, 'u1', 'Gasolina', 'expense', '⛽')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// search returns what ix finds for text as type and id.
+func search(t *testing.T, ix *Index, text string) []string {
+	t.Helper()
+
+	q := listing.Query{}
+	if err := q.Normalize(); err != nil {
+		t.Fatal(err)
+	}
+	results, err := ix.Search("u1", text, q)
+	if err != nil {
+		t.Fatalf("Search(%q): %v", text, err)
+	}
+	var found []string
+	for _, r := range results.Results {
+		found = append(found, r.Type+":"+r.Date)
+	}
+	for _, c := range results.Categories {
+		found = append(found, "category:"+c.Name)
+	}
+	sort.Strings(found)
+	return found
+}
+
+func TestSearchFindsTheRowsOfTheUser(t *testing.T) {
+	db := newTestDB(t)
+	ix, err := New(db)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	if got, want := search(t, ix, "netflix"), []string{"bill:2025-02-05", "expense:2025-01-05"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("netflix = %v, want %v", got, want)
+	}
+	// Categories are searched in the transactions too, by the start of a word
+	if got, want := search(t, ix, "gasol"), []string{"category:Gasolina", "expense:2025-01-07"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("gasol = %v, want %v", got, want)
+	}
+	if got := search(t, ix, "netflix repsol"); got != nil {
+		t.Errorf("Every word must match, got %v", got)
+	}
+	if _, err := ix.Search("u1", " ¿? ", listing.Query{Limit: 1}); !errors.Is(err, ErrEmptyQuery) {
+		t.Errorf("Empty search = %v, want ErrEmptyQuery", err)
+	}
+}
+
+func TestWritesAreIndexedWithoutAccents(t *testing.T) {
+	db := newTestDB(t)
+	ix, err := New(db)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	db.Exec(`INSERT INTO incomes (id, user_id, amount, date, category, description, base_amount) VALUES (1, 'u1', 100, '2025-01-09', 'Regalos', 'Cumpleaños', 100)`)
+	db.Exec(`INSERT INTO expenses (id, user_id, amount, date, category, description, base_amount) VALUES (4, 'u1', 250, '2025-01-10', 'Comida', 'Café', 250)`)
+	if got, want := search(t, ix, "cafe"), []string{"expense:2025-01-10"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("cafe = %v, want %v", got, want)
+	}
+	if got, want := search(t, ix, "CUMPLEAÑOS"), []string{"income:2025-01-09"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("CUMPLEAÑOS = %v, want %v", got, want)
+	}
+
+	db.Exec(`UPDATE expenses SET description = 'Té' WHERE id = 4`)
+	db.Exec(`DELETE FROM bills WHERE id = 1`)
+	if got := search(t, ix, "cafe"); got != nil {
+		t.Errorf("cafe after the update = %v", got)
+	}
+	if got, want := search(t, ix, "te"), []string{"expense:2025-01-10"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("te = %v, want %v", got, want)
+	}
+	if got, want := search(t, ix, "netflix"), []string{"expense:2025-01-05"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("netflix after deleting the bill = %v, want %v", got, want)
+	}
+
+	// A new index starts from the rows as they are
+	if ix, err = New(db); err != nil {
+		t.Fatalf("New again: %v", err)
+	}
+	if got, want := search(t, ix, "te"), []string{"expense:2025-01-10"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("te after New = %v, want %v", got, want)
+	}
+}
+
+func TestNewOnlyIndexesTablesWithOutdatedTriggers(t *testing.T) {
+	db := newTestDB(t)
+	if _, err := New(db); err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	// Up to date triggers: the documents are left as they are. Marking
+	// them pending copies the change to an FTS5 index too
+	db.Exec(`UPDATE search_documents SET document = 'Tampered' WHERE kind = 'expense' AND row_id = 2`)
+	db.Exec(`INSERT OR IGNORE INTO search_pending (rowid) SELECT rowid FROM search_documents`)
+	ix, err := New(db)
+	if err != nil {
+		t.Fatalf("New again: %v", err)
+	}
+	if got, want := search(t, ix, "tampered"), []string{"expense:2025-01-07"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("tampered = %v, want %v", got, want)
+	}
+
+	// A missing trigger gets its table indexed again
+	db.Exec(`DROP TRIGGER search_expenses_update`)
+	if ix, err = New(db); err != nil {
+		t.Fatalf("New without a trigger: %v", err)
+	}
+	if got := search(t, ix, "tampered"); got != nil {
+		t.Errorf("tampered after reindexing = %v", got)
+	}
+	if got, want := search(t, ix, "repsol"), []string{"expense:2025-01-07"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("repsol after reindexing = %v, want %v", got, want)
+	}
+}
+
+func TestNewReplacesTriggersWritingToTheIndex(t *testing.T) {
+	db := newTestDB(t)
+	_, err := db.Exec(`
+		CREATE TABLE search_index (rowid INTEGER PRIMARY KEY, user_id TEXT NOT NULL, kind TEXT NOT NULL,
+			row_id INTEGER NOT NULL, document TEXT NOT NULL);
+		CREATE TRIGGER search_expenses_insert AFTER INSERT ON expenses BEGIN
+			INSERT INTO search_index (rowid, user_id, kind, row_id, document) VALUES (NEW.id * 4, NEW.user_id, 'expense', NEW.id, NEW.description);
+		END`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ix, err := New(db)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	var stale int
+	db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND sql LIKE '%search_index%'`).Scan(&stale)
+	if stale != 0 {
+		t.Errorf("%d triggers still write to search_index", stale)
+	}
+	if _, err := db.Exec(`INSERT INTO expenses (id, user_id, amount, date, category, description, base_amount) VALUES (4, 'u1', 250, '2025-01-10', 'Comida', 'Café', 250)`); err != nil {
+		t.Fatalf("Insert after New: %v", err)
+	}
+	if got, want := search(t, ix, "netflix"), []string{"bill:2025-02-05", "expense:2025-01-05"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("netflix = %v, want %v", got, want)
+	}
+	if got, want := search(t, ix, "cafe"), []string{"expense:2025-01-10"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("cafe = %v, want %v", got, want)
+	}
+}
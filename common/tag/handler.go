@@ -0,0 +1,137 @@
+package tag
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"backend/common/auth"
+	"backend/common/reply"
+)
+
+// Handler manages the tags of the authenticated user: GET lists them,
+// POST creates one from {"name", "color"}, PUT updates one from {"id",
+// "name", "color"} and DELETE with ?id= deletes one. Wrap it in
+// auth.Require.
+func (s *Store) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := auth.UserID(r)
+		if userID == "" {
+			reply.JSON(w, http.StatusBadRequest, false, "User ID is required", nil)
+			return
+		}
+
+		var body struct {
+			ID    int64  `json:"id"`
+			Name  string `json:"name"`
+			Color string `json:"color"`
+		}
+		if r.Method == http.MethodPost || r.Method == http.MethodPut {
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				reply.JSON(w, http.StatusBadRequest, false, "Invalid request body", nil)
+				return
+			}
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			tags, err := s.List(userID)
+			respond(w, "", tags, err)
+		case http.MethodPost:
+			t, err := s.Create(userID, body.Name, body.Color)
+			respond(w, "Tag created", t, err)
+		case http.MethodPut:
+			t, err := s.Update(userID, body.ID, body.Name, body.Color)
+			respond(w, "Tag updated", t, err)
+		case http.MethodDelete:
+			id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+			if err != nil {
+				reply.JSON(w, http.StatusBadRequest, false, "Tag ID is required", nil)
+				return
+			}
+			respond(w, "Tag deleted", nil, s.Delete(userID, id))
+		default:
+			reply.JSON(w, http.StatusMethodNotAllowed, false, "Method not allowed", nil)
+		}
+	}
+}
+
+// TransactionHandler reads with GET and ?type=&id= and replaces with PUT
+// and {"type", "id", "tags"} the tags of a transaction of the
+// authenticated user, by name. Wrap it in auth.Require.
+func (s *Store) TransactionHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := auth.UserID(r)
+		if userID == "" {
+			reply.JSON(w, http.StatusBadRequest, false, "User ID is required", nil)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			kind := r.URL.Query().Get("type")
+			id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+			if err != nil || kind == "" {
+				reply.JSON(w, http.StatusBadRequest, false, "Transaction type and ID are required", nil)
+				return
+			}
+			names, err := s.Of(userID, kind, []int64{id})
+			tags := names[id]
+			if tags == nil {
+				tags = []string{}
+			}
+			respond(w, "", tags, err)
+		case http.MethodPut:
+			var body struct {
+				Type string   `json:"type"`
+				ID   int64    `json:"id"`
+				Tags []string `json:"tags"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				reply.JSON(w, http.StatusBadRequest, false, "Invalid request body", nil)
+				return
+			}
+			tags, err := s.Set(userID, body.Type, body.ID, body.Tags)
+			respond(w, "Tags updated", tags, err)
+		default:
+			reply.JSON(w, http.StatusMethodNotAllowed, false, "Method not allowed", nil)
+		}
+	}
+}
+
+// TotalsHandler reports the totals of every tag of the authenticated user
+// between ?start_date= and ?end_date=. Wrap it in auth.Require.
+func (s *Store) TotalsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			reply.JSON(w, http.StatusMethodNotAllowed, false, "Method not allowed", nil)
+			return
+		}
+		userID := auth.UserID(r)
+		if userID == "" {
+			reply.JSON(w, http.StatusBadRequest, false, "User ID is required", nil)
+			return
+		}
+		totals, err := s.Totals(userID, r.URL.Query().Get("start_date"), r.URL.Query().Get("end_date"))
+		respond(w, "", totals, err)
+	}
+}
+
+// respond answers with data or with the status of err.
+func respond(w http.ResponseWriter, message string, data interface{}, err error) {
+	switch {
+	case errors.Is(err, ErrInvalid):
+		reply.JSON(w, http.StatusBadRequest, false, err.Error(), nil)
+	case errors.Is(err, ErrNotFound):
+		reply.JSON(w, http.StatusNotFound, false, "Tag or transaction not found", nil)
+	case errors.Is(err, ErrDuplicate):
+		reply.JSON(w, http.StatusConflict, false, err.Error(), nil)
+	case err != nil:
+		log.Printf("Error managing tags: %v", err)
+		reply.JSON(w, http.StatusInternalServerError, false, "Error managing tags", nil)
+	default:
+		reply.JSON(w, http.StatusOK, true, message, data)
+	}
+}
@@ -0,0 +1,418 @@
+// Package tag lets users label incomes, expenses and bills with tags of
+// their own, any number per transaction, next to their single category.
+//
+// Tags live in the tags table and their use in transaction_tags, by the
+// type and id of the transaction. Triggers drop the uses of transactions
+// that are deleted; deletions to the trash take them first (TakeTx) so a
+// restore brings them back. Lists filter by tags through listing.Query.
+package tag
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"backend/common/money"
+	"backend/common/trash"
+)
+
+var (
+	// ErrNotFound is returned for tags and transactions that do not exist
+	// or belong to another user.
+	ErrNotFound = errors.New("not found")
+	// ErrInvalid is returned for tags without a name or with a name too
+	// long, and for unknown transaction types.
+	ErrInvalid = errors.New("invalid tag")
+	// ErrDuplicate is returned when the user already has a tag with the
+	// name, in any case.
+	ErrDuplicate = errors.New("a tag with that name already exists")
+)
+
+// MaxNameLength is the longest tag name, in characters.
+const MaxNameLength = 50
+
+// tables are the tables of the transactions that can be tagged, by type.
+var tables = map[string]string{"income": "incomes", "expense": "expenses", "bill": "bills"}
+
+// kinds are the types of transactions in a fixed order.
+var kinds = []string{"income", "expense", "bill"}
+
+// Tag is a tag of a user.
+type Tag struct {
+	ID    int64  `json:"id"`
+	Name  string `json:"name"`
+	Color string `json:"color,omitempty"`
+	// Uses is the number of transactions tagged with it.
+	Uses int `json:"uses"`
+}
+
+// Total is what the transactions of a tag add up to, in the base
+// currency, with amounts in units in JSON.
+type Total struct {
+	Tag
+	Incomes  money.Money `json:"incomes"`
+	Expenses money.Money `json:"expenses"`
+	Bills    money.Money `json:"bills"`
+	// Count is the number of transactions in the period.
+	Count int `json:"count"`
+}
+
+// Store keeps the tags of one database.
+type Store struct {
+	db *sql.DB
+	// kinds are the types of transactions whose table the database has.
+	kinds map[string]bool
+}
+
+// New creates the tag tables and the triggers on the transaction tables
+// the database has.
+func New(db *sql.DB) (*Store, error) {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS tags (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id TEXT NOT NULL,
+			name TEXT NOT NULL COLLATE NOCASE,
+			color TEXT,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (user_id, name)
+		);
+		CREATE TABLE IF NOT EXISTS transaction_tags (
+			user_id TEXT NOT NULL,
+			tag_id INTEGER NOT NULL,
+			kind TEXT NOT NULL,
+			row_id INTEGER NOT NULL,
+			PRIMARY KEY (tag_id, kind, row_id)
+		);
+		CREATE INDEX IF NOT EXISTS idx_transaction_tags_row ON transaction_tags(kind, row_id);
+		CREATE INDEX IF NOT EXISTS idx_transaction_tags_user ON transaction_tags(user_id)`)
+	if err != nil {
+		return nil, fmt.Errorf("error creating tag tables: %v", err)
+	}
+
+	s := &Store{db: db, kinds: map[string]bool{}}
+	for _, kind := range kinds {
+		var exists int
+		err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", tables[kind]).Scan(&exists)
+		if err != nil {
+			return nil, err
+		}
+		if exists == 0 {
+			continue
+		}
+		_, err = db.Exec(fmt.Sprintf(`CREATE TRIGGER IF NOT EXISTS tags_%[1]s_delete AFTER DELETE ON %[1]s
+			BEGIN DELETE FROM transaction_tags WHERE kind = '%[2]s' AND row_id = OLD.id; END`, tables[kind], kind))
+		if err != nil {
+			return nil, fmt.Errorf("error creating trigger on %s: %v", tables[kind], err)
+		}
+		s.kinds[kind] = true
+	}
+	return s, nil
+}
+
+// List returns the tags of userID by name, with their uses.
+func (s *Store) List(userID string) ([]Tag, error) {
+	rows, err := s.db.Query(`
+		SELECT t.id, t.name, COALESCE(t.color, ''), COUNT(tt.tag_id)
+		FROM tags t LEFT JOIN transaction_tags tt ON tt.tag_id = t.id
+		WHERE t.user_id = ?
+		GROUP BY t.id
+		ORDER BY t.name`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error reading tags: %v", err)
+	}
+	defer rows.Close()
+
+	tags := []Tag{}
+	for rows.Next() {
+		var t Tag
+		if err := rows.Scan(&t.ID, &t.Name, &t.Color, &t.Uses); err != nil {
+			return nil, err
+		}
+		tags = append(tags, t)
+	}
+	return tags, rows.Err()
+}
+
+// Create adds a tag for userID.
+func (s *Store) Create(userID, name, color string) (Tag, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return Tag{}, err
+	}
+	defer tx.Rollback()
+	t, err := createTx(tx, userID, name, color)
+	if err != nil {
+		return Tag{}, err
+	}
+	return t, tx.Commit()
+}
+
+func createTx(tx *sql.Tx, userID, name, color string) (Tag, error) {
+	name, err := cleanName(name)
+	if err != nil {
+		return Tag{}, err
+	}
+	if err := unique(tx, userID, name, 0); err != nil {
+		return Tag{}, err
+	}
+	result, err := tx.Exec(`INSERT INTO tags (user_id, name, color) VALUES (?, ?, ?)`, userID, name, nullable(color))
+	if err != nil {
+		return Tag{}, fmt.Errorf("error creating tag: %v", err)
+	}
+	id, err := result.LastInsertId()
+	return Tag{ID: id, Name: name, Color: color}, err
+}
+
+// Update renames and recolors tag id of userID.
+func (s *Store) Update(userID string, id int64, name, color string) (Tag, error) {
+	name, err := cleanName(name)
+	if err != nil {
+		return Tag{}, err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return Tag{}, err
+	}
+	defer tx.Rollback()
+
+	if err := unique(tx, userID, name, id); err != nil {
+		return Tag{}, err
+	}
+	result, err := tx.Exec(`UPDATE tags SET name = ?, color = ? WHERE id = ? AND user_id = ?`, name, nullable(color), id, userID)
+	if err != nil {
+		return Tag{}, fmt.Errorf("error updating tag: %v", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return Tag{}, ErrNotFound
+	}
+	t := Tag{ID: id, Name: name, Color: color}
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM transaction_tags WHERE tag_id = ?`, id).Scan(&t.Uses); err != nil {
+		return Tag{}, err
+	}
+	return t, tx.Commit()
+}
+
+// Delete deletes tag id of userID and takes it off its transactions.
+func (s *Store) Delete(userID string, id int64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`DELETE FROM tags WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return fmt.Errorf("error deleting tag: %v", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	if _, err := tx.Exec(`DELETE FROM transaction_tags WHERE tag_id = ?`, id); err != nil {
+		return fmt.Errorf("error untagging transactions: %v", err)
+	}
+	return tx.Commit()
+}
+
+// Set replaces the tags of transaction kind id of userID with names,
+// creating the tags the user does not have yet, and returns them.
+func (s *Store) Set(userID, kind string, id int64, names []string) ([]Tag, error) {
+	if !s.kinds[kind] {
+		return nil, fmt.Errorf("%w: transaction type must be income, expense or bill", ErrInvalid)
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var exists int
+	err = tx.QueryRow(fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE id = ? AND user_id = ?`, tables[kind]), id, userID).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if exists == 0 {
+		return nil, ErrNotFound
+	}
+	if _, err := tx.Exec(`DELETE FROM transaction_tags WHERE kind = ? AND row_id = ?`, kind, id); err != nil {
+		return nil, fmt.Errorf("error untagging transaction: %v", err)
+	}
+
+	tags := []Tag{}
+	seen := map[string]bool{}
+	for _, name := range names {
+		name, err := cleanName(name)
+		if err != nil {
+			return nil, err
+		}
+		if seen[strings.ToLower(name)] {
+			continue
+		}
+		seen[strings.ToLower(name)] = true
+
+		var t Tag
+		err = tx.QueryRow(`SELECT id, name, COALESCE(color, '') FROM tags WHERE user_id = ? AND name = ?`, userID, name).
+			Scan(&t.ID, &t.Name, &t.Color)
+		if err == sql.ErrNoRows {
+			t, err = createTx(tx, userID, name, "")
+		}
+		if err != nil {
+			return nil, err
+		}
+		_, err = tx.Exec(`INSERT INTO transaction_tags (user_id, tag_id, kind, row_id) VALUES (?, ?, ?, ?)`, userID, t.ID, kind, id)
+		if err != nil {
+			return nil, fmt.Errorf("error tagging transaction: %v", err)
+		}
+		tags = append(tags, t)
+	}
+	return tags, tx.Commit()
+}
+
+// Of returns the names of the tags of the transactions kind ids of
+// userID, by id.
+func (s *Store) Of(userID, kind string, ids []int64) (map[int64][]string, error) {
+	names := map[int64][]string{}
+	if len(ids) == 0 {
+		return names, nil
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+	args := []interface{}{userID, kind}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	rows, err := s.db.Query(fmt.Sprintf(`
+		SELECT tt.row_id, t.name FROM transaction_tags tt JOIN tags t ON t.id = tt.tag_id
+		WHERE tt.user_id = ? AND tt.kind = ? AND tt.row_id IN (%s)
+		ORDER BY t.name`, placeholders), args...)
+	if err != nil {
+		return nil, fmt.Errorf("error reading tags: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, err
+		}
+		names[id] = append(names[id], name)
+	}
+	return names, rows.Err()
+}
+
+// Totals adds up the transactions of each tag of userID dated between
+// start and end, both YYYY-MM-DD and inclusive, or without a bound if
+// empty. Bills count at their due date.
+func (s *Store) Totals(userID, start, end string) ([]Total, error) {
+	for _, date := range []string{start, end} {
+		if _, err := time.Parse("2006-01-02", date); date != "" && err != nil {
+			return nil, fmt.Errorf("%w: dates must be YYYY-MM-DD", ErrInvalid)
+		}
+	}
+
+	tags, err := s.List(userID)
+	if err != nil {
+		return nil, err
+	}
+	totals := make([]Total, len(tags))
+	byID := map[int64]*Total{}
+	for i, t := range tags {
+		totals[i] = Total{Tag: t}
+		byID[t.ID] = &totals[i]
+	}
+
+	var selects []string
+	var args []interface{}
+	for _, kind := range kinds {
+		if !s.kinds[kind] {
+			continue
+		}
+		date := "date"
+		if kind == "bill" {
+			date = "due_date"
+		}
+		selects = append(selects, fmt.Sprintf(`SELECT id, '%s' AS kind, %s AS date, COALESCE(base_amount, amount) AS base_amount
+			FROM %s WHERE user_id = ?`, kind, date, tables[kind]))
+		args = append(args, userID)
+	}
+	if len(selects) == 0 {
+		return totals, nil
+	}
+	args = append(args, userID, start, start, end, end)
+	rows, err := s.db.Query(fmt.Sprintf(`
+		SELECT tt.tag_id, tt.kind, COUNT(*), COALESCE(SUM(x.base_amount), 0)
+		FROM transaction_tags tt JOIN (%s) x ON x.kind = tt.kind AND x.id = tt.row_id
+		WHERE tt.user_id = ? AND (? = '' OR x.date >= ?) AND (? = '' OR x.date <= ?)
+		GROUP BY tt.tag_id, tt.kind`, strings.Join(selects, " UNION ALL ")), args...)
+	if err != nil {
+		return nil, fmt.Errorf("error adding up tags: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var kind string
+		var count int
+		var sum money.Money
+		if err := rows.Scan(&id, &kind, &count, &sum); err != nil {
+			return nil, err
+		}
+		t, ok := byID[id]
+		if !ok {
+			continue
+		}
+		t.Count += count
+		switch kind {
+		case "income":
+			t.Incomes += sum
+		case "expense":
+			t.Expenses += sum
+		case "bill":
+			t.Bills += sum
+		}
+	}
+	return totals, rows.Err()
+}
+
+// TakeTx deletes the tags of transaction kind id and returns them for the
+// trash, with the transaction, so a restore tags it again. It does
+// nothing in databases without tags.
+func TakeTx(tx *sql.Tx, kind string, id int64) ([]trash.Row, error) {
+	var exists int
+	err := tx.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'transaction_tags'").Scan(&exists)
+	if err != nil || exists == 0 {
+		return nil, err
+	}
+	return trash.TakeTx(tx, "transaction_tags", "kind = ? AND row_id = ?", kind, id)
+}
+
+// unique checks that userID has no tag named name other than id.
+func unique(tx *sql.Tx, userID, name string, id int64) error {
+	var count int
+	err := tx.QueryRow(`SELECT COUNT(*) FROM tags WHERE user_id = ? AND name = ? AND id != ?`, userID, name, id).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("%w: %s", ErrDuplicate, name)
+	}
+	return nil
+}
+
+func cleanName(name string) (string, error) {
+	name = strings.Join(strings.Fields(name), " ")
+	if name == "" {
+		return "", fmt.Errorf("%w: the name is required", ErrInvalid)
+	}
+	if utf8.RuneCountInString(name) > MaxNameLength {
+		return "", fmt.Errorf("%w: the name is longer than %d characters", ErrInvalid, MaxNameLength)
+	}
+	return name, nil
+}
+
+func nullable(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
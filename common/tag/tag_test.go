@@ -0,0 +1,156 @@
+package tag
+
+import (
+	"database/sql"
+	"errors"
+	"reflect"
+	"testing"
+
+	"backend/common/dbtest"
+	"backend/common/listing"
+)
+
+func newTestStore(t *testing.T) (*sql.DB, *Store) {
+	t.Helper()
+
+	db := dbtest.Open(t)
+	_, err := db.Exec(`
+		CREATE TABLE expenses (id INTEGER PRIMARY KEY, user_id TEXT, date TEXT, category TEXT, payment_method TEXT,
+			amount INTEGER, base_amount INTEGER);
+		CREATE TABLE incomes (id INTEGER PRIMARY KEY, user_id TEXT, date TEXT, category TEXT, payment_method TEXT,
+			amount INTEGER, base_amount INTEGER);
+		INSERT INTO expenses (id, user_id, date, category, payment_method, amount, base_amount) VALUES
+			(1, 'u1', '2025-07-01', 'Travel', 'bank', 30000, 30000),
+			(2, 'u1', '2025-07-02', 'Food', 'cash', 2000, 2000),
+			(3, 'u1', '2025-08-01', 'Food', 'cash', 1000, 1000),
+			(4, 'u2', '2025-07-01', 'Food', 'cash', 500, 500);
+		INSERT INTO incomes (id, user_id, date, category, payment_method, amount, base_amount) VALUES
+			(1, 'u1', '2025-07-20', 'Refunds', 'bank', 30000, 30000)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(db)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return db, s
+}
+
+func TestTagsAreManagedPerUser(t *testing.T) {
+	_, s := newTestStore(t)
+
+	vacation, err := s.Create("u1", "  Vacation ", "#00aaff")
+	if err != nil || vacation.Name != "Vacation" {
+		t.Fatalf("Create = %+v, %v", vacation, err)
+	}
+	if _, err := s.Create("u1", "VACATION", ""); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("Create a duplicate = %v, want ErrDuplicate", err)
+	}
+	if _, err := s.Create("u2", "vacation", ""); err != nil {
+		t.Errorf("Another user's tag = %v", err)
+	}
+	if _, err := s.Create("u1", " ", ""); !errors.Is(err, ErrInvalid) {
+		t.Errorf("Create without a name = %v, want ErrInvalid", err)
+	}
+
+	// Tagging creates the tags that are missing
+	tags, err := s.Set("u1", "expense", 1, []string{"vacation", "Reimbursable", "reimbursable"})
+	if err != nil || len(tags) != 2 || tags[0].ID != vacation.ID {
+		t.Fatalf("Set = %+v, %v", tags, err)
+	}
+	if _, err := s.Set("u1", "expense", 4, []string{"vacation"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Tagging another user's expense = %v, want ErrNotFound", err)
+	}
+	if _, err := s.Set("u1", "saving", 1, []string{"vacation"}); !errors.Is(err, ErrInvalid) {
+		t.Errorf("Tagging a saving = %v, want ErrInvalid", err)
+	}
+
+	if _, err := s.Update("u1", vacation.ID, "Holidays", ""); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	names, err := s.Of("u1", "expense", []int64{1, 2})
+	if want := map[int64][]string{1: {"Holidays", "Reimbursable"}}; err != nil || !reflect.DeepEqual(names, want) {
+		t.Errorf("Of = %v, %v, want %v", names, err, want)
+	}
+
+	if err := s.Delete("u1", vacation.ID); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := s.Delete("u2", tags[1].ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Deleting another user's tag = %v, want ErrNotFound", err)
+	}
+	list, _ := s.List("u1")
+	if len(list) != 1 || list[0].Name != "Reimbursable" || list[0].Uses != 1 {
+		t.Errorf("List = %+v", list)
+	}
+}
+
+func TestTotalsAndFilters(t *testing.T) {
+	db, s := newTestStore(t)
+	for _, set := range []struct {
+		kind string
+		id   int64
+		tags []string
+	}{
+		{"expense", 1, []string{"vacation", "reimbursable"}},
+		{"expense", 2, []string{"vacation"}},
+		{"expense", 3, []string{"vacation"}},
+		{"income", 1, []string{"reimbursable"}},
+	} {
+		if _, err := s.Set("u1", set.kind, set.id, set.tags); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+	}
+
+	totals, err := s.Totals("u1", "2025-07-01", "2025-07-31")
+	if err != nil {
+		t.Fatalf("Totals: %v", err)
+	}
+	got := map[string][3]int64{}
+	for _, total := range totals {
+		got[total.Name] = [3]int64{int64(total.Expenses), int64(total.Incomes), int64(total.Count)}
+	}
+	if want := map[string][3]int64{"reimbursable": {30000, 30000, 2}, "vacation": {32000, 0, 2}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Totals = %v, want %v", got, want)
+	}
+
+	list := func(q listing.Query) []int64 {
+		t.Helper()
+		if err := q.Normalize(); err != nil {
+			t.Fatal(err)
+		}
+		var ids []int64
+		from := `(SELECT id, 'expense' AS type, date, category, payment_method, base_amount FROM expenses WHERE user_id = ?)`
+		_, err := q.Run(db, from, []interface{}{"u1"}, func(rows *sql.Rows) (listing.Key, error) {
+			var k listing.Key
+			var category, method string
+			err := rows.Scan(&k.ID, &k.Type, &k.Date, &category, &method, &k.Amount)
+			ids = append(ids, k.ID)
+			return k, err
+		})
+		if err != nil {
+			t.Fatalf("Run: %v", err)
+		}
+		return ids
+	}
+	if got, want := list(listing.Query{Tags: []string{"Vacation", "reimbursable"}}), []int64{1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("All tags = %v, want %v", got, want)
+	}
+	if got, want := list(listing.Query{Tags: []string{"reimbursable", "vacation"}, TagMatch: listing.AnyTag}), []int64{3, 2, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Any tag = %v, want %v", got, want)
+	}
+
+	// Deletions to the trash take the tags with them, others just drop them
+	tx, _ := db.Begin()
+	rows, err := TakeTx(tx, "expense", 1)
+	if err != nil || len(rows) != 2 {
+		t.Fatalf("TakeTx = %d rows, %v", len(rows), err)
+	}
+	tx.Commit()
+	db.Exec(`DELETE FROM expenses WHERE id = 2`)
+	var left int
+	db.QueryRow(`SELECT COUNT(*) FROM transaction_tags WHERE kind = 'expense'`).Scan(&left)
+	if left != 1 {
+		t.Errorf("%d expense tags left, want 1", left)
+	}
+}
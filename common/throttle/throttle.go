@@ -0,0 +1,197 @@
+// Package throttle protects the public authentication endpoints against
+// brute force and enumeration. Every attempt is written to the
+// login_attempts table, which doubles as the audit trail, and limits are
+// computed from it so they hold across restarts and service instances.
+package throttle
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"backend/common/config"
+)
+
+// Policy holds the limits applied by a Guard.
+type Policy struct {
+	// MaxFailures is the number of failed sign-ins an account (or IP, see
+	// MaxFailuresPerIP) may accumulate inside Window before being locked.
+	MaxFailures      int
+	MaxFailuresPerIP int
+	// BaseLockout is the first lockout; it doubles with every further
+	// failure up to MaxLockout.
+	BaseLockout time.Duration
+	MaxLockout  time.Duration
+	Window      time.Duration
+	// MaxRequestsPerIP caps lookups such as check-email inside Window.
+	MaxRequestsPerIP int
+}
+
+// PolicyFromEnv returns the default policy with LOGIN_* overrides applied.
+func PolicyFromEnv() Policy {
+	return Policy{
+		MaxFailures:      config.Int("LOGIN_MAX_FAILURES", 5),
+		MaxFailuresPerIP: config.Int("LOGIN_MAX_FAILURES_PER_IP", 20),
+		BaseLockout:      config.Duration("LOGIN_LOCKOUT_BASE", time.Minute),
+		MaxLockout:       config.Duration("LOGIN_LOCKOUT_MAX", time.Hour),
+		Window:           config.Duration("LOGIN_FAILURE_WINDOW", 15*time.Minute),
+		MaxRequestsPerIP: config.Int("LOGIN_MAX_LOOKUPS_PER_IP", 10),
+	}
+}
+
+// Guard checks and records attempts against one database.
+type Guard struct {
+	db     *sql.DB
+	policy Policy
+	now    func() time.Time
+}
+
+// NewGuard creates a Guard and makes sure login_attempts exists.
+func NewGuard(db *sql.DB, policy Policy) (*Guard, error) {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS login_attempts (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			endpoint TEXT NOT NULL,
+			email TEXT,
+			ip_address TEXT,
+			success INTEGER NOT NULL DEFAULT 0,
+			reason TEXT,
+			created_at INTEGER NOT NULL
+		)
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("error creating login_attempts table: %v", err)
+	}
+
+	for _, index := range []string{
+		`CREATE INDEX IF NOT EXISTS idx_login_attempts_email ON login_attempts(email, created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_login_attempts_ip ON login_attempts(ip_address, created_at)`,
+	} {
+		if _, err := db.Exec(index); err != nil {
+			return nil, fmt.Errorf("error creating index on login_attempts: %v", err)
+		}
+	}
+
+	return &Guard{db: db, policy: policy, now: time.Now}, nil
+}
+
+// Normalize lower-cases and trims an email so limits cannot be bypassed by
+// changing its case.
+func Normalize(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// Record stores an attempt in the audit table.
+func (g *Guard) Record(endpoint, email, ip string, success bool, reason string) error {
+	_, err := g.db.Exec(`
+		INSERT INTO login_attempts (endpoint, email, ip_address, success, reason, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		endpoint, Normalize(email), ip, success, reason, g.now().Unix(),
+	)
+	if err != nil {
+		return fmt.Errorf("error recording login attempt: %v", err)
+	}
+	return nil
+}
+
+// LoginRetryAfter returns how long the caller must wait before trying to
+// sign in to email from ip again, or 0 when the attempt may proceed.
+func (g *Guard) LoginRetryAfter(endpoint, email, ip string) (time.Duration, error) {
+	accountWait, err := g.backoff(endpoint, "email", Normalize(email), g.policy.MaxFailures, true)
+	if err != nil {
+		return 0, err
+	}
+	// A success does not clear the IP: an attacker could sign in to their
+	// own account between guesses
+	ipWait, err := g.backoff(endpoint, "ip_address", ip, g.policy.MaxFailuresPerIP, false)
+	if err != nil {
+		return 0, err
+	}
+	if ipWait > accountWait {
+		return ipWait, nil
+	}
+	return accountWait, nil
+}
+
+// backoff counts failures for column=value inside the window, only those
+// after the last success when successes reset it, and turns them into an
+// exponential lockout.
+func (g *Guard) backoff(endpoint, column, value string, threshold int, resets bool) (time.Duration, error) {
+	if value == "" || threshold <= 0 {
+		return 0, nil
+	}
+
+	now := g.now()
+	since := now.Add(-g.policy.Window).Unix()
+
+	lastSuccess := "0"
+	if resets {
+		lastSuccess = fmt.Sprintf(`COALESCE((
+			SELECT MAX(created_at) FROM login_attempts
+			WHERE endpoint = ? AND %s = ? AND success = 1
+		), 0)`, column)
+	}
+	args := []interface{}{endpoint, value, since}
+	if resets {
+		args = append(args, endpoint, value)
+	}
+
+	var failures int
+	var lastFailure sql.NullInt64
+	err := g.db.QueryRow(fmt.Sprintf(`
+		SELECT COUNT(*), MAX(created_at) FROM login_attempts
+		WHERE endpoint = ? AND %s = ? AND success = 0 AND created_at > MAX(?, %s)`, column, lastSuccess),
+		args...,
+	).Scan(&failures, &lastFailure)
+	if err != nil {
+		return 0, fmt.Errorf("error counting login failures: %v", err)
+	}
+	if failures < threshold || !lastFailure.Valid {
+		return 0, nil
+	}
+
+	lockout := time.Duration(float64(g.policy.BaseLockout) * math.Pow(2, float64(failures-threshold)))
+	if lockout > g.policy.MaxLockout || lockout <= 0 {
+		lockout = g.policy.MaxLockout
+	}
+
+	until := time.Unix(lastFailure.Int64, 0).Add(lockout)
+	if !now.Before(until) {
+		return 0, nil
+	}
+	return until.Sub(now), nil
+}
+
+// RequestRetryAfter records a lookup on endpoint (check-email and similar)
+// and returns how long ip must wait when it exceeded its budget.
+func (g *Guard) RequestRetryAfter(endpoint, email, ip string) (time.Duration, error) {
+	now := g.now()
+	windowStart := now.Add(-g.policy.Window).Unix()
+
+	var count int
+	var oldest sql.NullInt64
+	err := g.db.QueryRow(`
+		SELECT COUNT(*), MIN(created_at) FROM login_attempts
+		WHERE endpoint = ? AND ip_address = ? AND created_at > ?`,
+		endpoint, ip, windowStart,
+	).Scan(&count, &oldest)
+	if err != nil {
+		return 0, fmt.Errorf("error counting requests: %v", err)
+	}
+
+	if count >= g.policy.MaxRequestsPerIP && oldest.Valid {
+		if err := g.Record(endpoint, email, ip, false, "rate_limited"); err != nil {
+			return 0, err
+		}
+		return time.Unix(oldest.Int64, 0).Add(g.policy.Window).Sub(now), nil
+	}
+
+	return 0, g.Record(endpoint, email, ip, true, "")
+}
+
+// RetryAfterSeconds formats a wait for the Retry-After header.
+func RetryAfterSeconds(wait time.Duration) string {
+	return fmt.Sprintf("%d", int(math.Ceil(wait.Seconds())))
+}
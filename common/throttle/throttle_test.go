@@ -0,0 +1,119 @@
+package throttle
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func newTestGuard(t *testing.T) (*Guard, *time.Time) {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	g, err := NewGuard(db, Policy{
+		MaxFailures:      3,
+		MaxFailuresPerIP: 10,
+		BaseLockout:      time.Minute,
+		MaxLockout:       10 * time.Minute,
+		Window:           time.Hour,
+		MaxRequestsPerIP: 2,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create guard: %v", err)
+	}
+
+	now := time.Unix(1_700_000_000, 0)
+	g.now = func() time.Time { return now }
+	return g, &now
+}
+
+func TestAccountLockoutBacksOffExponentially(t *testing.T) {
+	g, now := newTestGuard(t)
+
+	for i := 0; i < 3; i++ {
+		g.Record("signin", "User@Example.com", "10.0.0.1", false, "bad_password")
+	}
+
+	wait, err := g.LoginRetryAfter("signin", "user@example.com", "10.0.0.2")
+	if err != nil {
+		t.Fatalf("LoginRetryAfter failed: %v", err)
+	}
+	if wait != time.Minute {
+		t.Errorf("Expected 1m lockout after 3 failures, got %v", wait)
+	}
+
+	*now = now.Add(2 * time.Minute)
+	if wait, _ := g.LoginRetryAfter("signin", "user@example.com", ""); wait != 0 {
+		t.Errorf("Expected lockout to expire, got %v", wait)
+	}
+
+	g.Record("signin", "user@example.com", "10.0.0.1", false, "bad_password")
+	if wait, _ := g.LoginRetryAfter("signin", "user@example.com", ""); wait != 2*time.Minute {
+		t.Errorf("Expected lockout to double to 2m, got %v", wait)
+	}
+}
+
+func TestSuccessResetsFailures(t *testing.T) {
+	g, now := newTestGuard(t)
+
+	for i := 0; i < 3; i++ {
+		g.Record("signin", "a@b.c", "", false, "bad_password")
+	}
+	*now = now.Add(5 * time.Minute)
+	g.Record("signin", "a@b.c", "", true, "")
+	*now = now.Add(time.Second)
+	g.Record("signin", "a@b.c", "", false, "bad_password")
+
+	if wait, _ := g.LoginRetryAfter("signin", "a@b.c", ""); wait != 0 {
+		t.Errorf("Expected failures before a success to be ignored, got %v", wait)
+	}
+}
+
+func TestSuccessDoesNotResetTheIP(t *testing.T) {
+	g, now := newTestGuard(t)
+
+	// Guesses at other accounts with a sign-in to the attacker's own one in between
+	for i := 0; i < 10; i++ {
+		g.Record("signin", "victim@b.c", "10.0.0.1", false, "bad_password")
+		*now = now.Add(time.Second)
+		g.Record("signin", "attacker@b.c", "10.0.0.1", true, "")
+	}
+
+	if wait, _ := g.LoginRetryAfter("signin", "another@b.c", "10.0.0.1"); wait != time.Minute-time.Second {
+		t.Errorf("Expected the IP to stay locked after a success, got %v", wait)
+	}
+	if wait, _ := g.LoginRetryAfter("signin", "attacker@b.c", "10.0.0.2"); wait != 0 {
+		t.Errorf("Expected the account of the success to be clear, got %v", wait)
+	}
+}
+
+func TestRequestBudgetPerIP(t *testing.T) {
+	g, now := newTestGuard(t)
+
+	for i := 0; i < 2; i++ {
+		if wait, _ := g.RequestRetryAfter("signup/check-email", "x@y.z", "10.0.0.1"); wait != 0 {
+			t.Fatalf("Expected request %d to be allowed, got wait %v", i+1, wait)
+		}
+	}
+
+	wait, _ := g.RequestRetryAfter("signup/check-email", "x@y.z", "10.0.0.1")
+	if wait != time.Hour {
+		t.Errorf("Expected third request to wait for the window, got %v", wait)
+	}
+	if wait, _ := g.RequestRetryAfter("signup/check-email", "x@y.z", "10.0.0.2"); wait != 0 {
+		t.Errorf("Expected a different IP to be allowed, got %v", wait)
+	}
+
+	*now = now.Add(time.Hour + time.Second)
+	if wait, _ := g.RequestRetryAfter("signup/check-email", "x@y.z", "10.0.0.1"); wait != 0 {
+		t.Errorf("Expected budget to reset after the window, got %v", wait)
+	}
+}
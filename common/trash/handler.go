@@ -0,0 +1,36 @@
+package trash
+
+import (
+	"log"
+	"net/http"
+
+	"backend/common/auth"
+	"backend/common/reply"
+)
+
+// Handler lists the trash of the authenticated user, last deleted first.
+// Wrap it in auth.Require.
+func (s *Store) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			reply.JSON(w, http.StatusMethodNotAllowed, false, "Method not allowed", nil)
+			return
+		}
+		userID := auth.UserID(r)
+		if userID == "" {
+			reply.JSON(w, http.StatusBadRequest, false, "User ID is required", nil)
+			return
+		}
+
+		items, err := s.List(userID)
+		if err != nil {
+			log.Printf("Error reading trash: %v", err)
+			reply.JSON(w, http.StatusInternalServerError, false, "Error reading trash", nil)
+			return
+		}
+		if items == nil {
+			items = []Item{}
+		}
+		reply.JSON(w, http.StatusOK, true, "", items)
+	}
+}
@@ -0,0 +1,425 @@
+// Package trash keeps deleted transactions for a while so users can
+// restore the ones they deleted by mistake.
+//
+// A deletion takes its rows out of their tables (TakeTx) and puts them,
+// the rows it changed as they were before, and the ledger entries it
+// reversed and posted into the trash table (PutTx), all in the caller's
+// transaction. The rest of the services never see trashed rows. RestoreTx
+// puts everything back and applies the entries the other way round, so
+// the balances end up exactly as before the deletion. Items older than
+// the retention are purged for good.
+package trash
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"backend/common/config"
+	"backend/common/ledger"
+	"backend/common/money"
+)
+
+// ErrNotFound is returned for items that do not exist, belong to another
+// user or were purged.
+var ErrNotFound = errors.New("trash item not found")
+
+// ErrConflict is returned when a row the deletion changed is gone or was
+// changed again since, so restoring the item would overwrite newer data.
+var ErrConflict = errors.New("trash item conflicts with later changes")
+
+// restorable are the only columns a restore writes back into the rows a
+// deletion changed. Deleting a bill payment just clears these paid flags,
+// so a restore expects them still cleared and the rest of the row intact.
+var restorable = map[string][]string{
+	"bills":         {"paid"},
+	"bill_payments": {"paid"},
+}
+
+// Row is a database row as stored, keyed by column.
+type Row struct {
+	Table  string                 `json:"table"`
+	Values map[string]interface{} `json:"values"`
+}
+
+// Item is one deletion in the trash.
+type Item struct {
+	ID       int64  `json:"id"`
+	UserID   string `json:"user_id"`
+	Entity   string `json:"entity"`
+	EntityID int64  `json:"entity_id"`
+	// State is the deleted transaction, its first row, with amounts in
+	// units like the services' own JSON.
+	State map[string]interface{} `json:"state"`
+	// Rows are the rows the deletion removed, the transaction first, and
+	// Changed the rows it updated, as they were before.
+	Rows    []Row `json:"-"`
+	Changed []Row `json:"-"`
+	// Reversed and Posted are the ledger entries the deletion took out of
+	// and added to the balances.
+	Reversed  []ledger.Entry `json:"-"`
+	Posted    []ledger.Entry `json:"-"`
+	DeletedAt time.Time      `json:"deleted_at"`
+	ExpiresAt time.Time      `json:"expires_at"`
+}
+
+// Store keeps the trash of one database.
+type Store struct {
+	db        *sql.DB
+	balances  *ledger.Ledger
+	retention time.Duration
+	now       func() time.Time
+}
+
+// New creates the trash table. Items are kept for retention and restored
+// into balances.
+func New(db *sql.DB, balances *ledger.Ledger, retention time.Duration) (*Store, error) {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS trash (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id TEXT NOT NULL,
+			entity TEXT NOT NULL,
+			entity_id INTEGER NOT NULL,
+			deleted_rows TEXT NOT NULL,
+			changed_rows TEXT,
+			reversed_entries TEXT,
+			posted_entries TEXT,
+			deleted_at INTEGER NOT NULL,
+			expires_at INTEGER NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_trash_user ON trash(user_id, deleted_at);
+		CREATE INDEX IF NOT EXISTS idx_trash_expires ON trash(expires_at)`)
+	if err != nil {
+		return nil, fmt.Errorf("error creating trash table: %v", err)
+	}
+	return &Store{db: db, balances: balances, retention: retention, now: time.Now}, nil
+}
+
+// NewFromEnv is New keeping items for TRASH_RETENTION (30 days by
+// default).
+func NewFromEnv(db *sql.DB, balances *ledger.Ledger) (*Store, error) {
+	return New(db, balances, config.Duration("TRASH_RETENTION", 30*24*time.Hour))
+}
+
+// TakeTx deletes the rows of table matching where and returns them as they
+// were.
+func TakeTx(tx *sql.Tx, table, where string, args ...interface{}) ([]Row, error) {
+	rows, err := ReadTx(tx, table, where, args...)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE %s", table, where), args...); err != nil {
+		return nil, fmt.Errorf("error deleting from %s: %v", table, err)
+	}
+	return rows, nil
+}
+
+// ReadTx returns the rows of table matching where, e.g. before a deletion
+// updates them.
+func ReadTx(tx *sql.Tx, table, where string, args ...interface{}) ([]Row, error) {
+	rows, err := tx.Query(fmt.Sprintf("SELECT * FROM %s WHERE %s", table, where), args...)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", table, err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	var result []Row
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		for i := range values {
+			values[i] = new(interface{})
+		}
+		if err := rows.Scan(values...); err != nil {
+			return nil, fmt.Errorf("error reading %s: %v", table, err)
+		}
+		row := Row{Table: table, Values: make(map[string]interface{}, len(columns))}
+		for i, column := range columns {
+			v := *values[i].(*interface{})
+			if b, ok := v.([]byte); ok {
+				v = string(b)
+			}
+			row.Values[column] = v
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+// PutTx stores item in the trash inside the caller's transaction and
+// returns its id. Item needs its UserID, Entity, EntityID and Rows.
+func (s *Store) PutTx(tx *sql.Tx, item Item) (int64, error) {
+	if item.UserID == "" || item.Entity == "" || len(item.Rows) == 0 {
+		return 0, fmt.Errorf("incomplete trash item for %s %d", item.Entity, item.EntityID)
+	}
+
+	var columns [4]interface{}
+	for i, v := range []interface{}{item.Rows, item.Changed, item.Reversed, item.Posted} {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return 0, fmt.Errorf("error encoding %s %d for the trash: %v", item.Entity, item.EntityID, err)
+		}
+		columns[i] = string(data)
+	}
+
+	now := s.now()
+	result, err := tx.Exec(`
+		INSERT INTO trash (user_id, entity, entity_id, deleted_rows, changed_rows, reversed_entries, posted_entries, deleted_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		item.UserID, item.Entity, item.EntityID, columns[0], columns[1], columns[2], columns[3],
+		now.Unix(), now.Add(s.retention).Unix())
+	if err != nil {
+		return 0, fmt.Errorf("error moving %s %d to the trash: %v", item.Entity, item.EntityID, err)
+	}
+	return result.LastInsertId()
+}
+
+// List returns the trash of userID, last deleted first.
+func (s *Store) List(userID string) ([]Item, error) {
+	rows, err := s.db.Query(`
+		SELECT id, user_id, entity, entity_id, deleted_rows, COALESCE(changed_rows, 'null'), COALESCE(reversed_entries, 'null'),
+		       COALESCE(posted_entries, 'null'), deleted_at, expires_at
+		FROM trash WHERE user_id = ? AND expires_at > ? ORDER BY deleted_at DESC, id DESC`, userID, s.now().Unix())
+	if err != nil {
+		return nil, fmt.Errorf("error reading trash: %v", err)
+	}
+	defer rows.Close()
+
+	var items []Item
+	for rows.Next() {
+		item, err := scanItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// RestoreTx takes item id of userID out of the trash inside the caller's
+// transaction: its rows are inserted again with their ids, the rows it
+// changed get their restorable columns back and the ledger entries are
+// applied the other way round. It returns the restored item, or
+// ErrConflict if a changed row is gone or was changed again.
+func (s *Store) RestoreTx(tx *sql.Tx, userID string, id int64) (Item, error) {
+	item, err := scanItem(tx.QueryRow(`
+		SELECT id, user_id, entity, entity_id, deleted_rows, COALESCE(changed_rows, 'null'), COALESCE(reversed_entries, 'null'),
+		       COALESCE(posted_entries, 'null'), deleted_at, expires_at
+		FROM trash WHERE id = ? AND user_id = ? AND expires_at > ?`, id, userID, s.now().Unix()))
+	if err == sql.ErrNoRows {
+		return Item{}, ErrNotFound
+	}
+	if err != nil {
+		return Item{}, err
+	}
+
+	for _, row := range item.Rows {
+		if err := insertRow(tx, row); err != nil {
+			return Item{}, err
+		}
+	}
+	for _, row := range item.Changed {
+		if err := restoreRow(tx, row); err != nil {
+			return Item{}, err
+		}
+	}
+	if err := s.balances.ReplaceTx(tx, item.Posted, item.Reversed); err != nil {
+		return Item{}, fmt.Errorf("error restoring %s %d in period balances: %v", item.Entity, item.EntityID, err)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM trash WHERE id = ?`, id); err != nil {
+		return Item{}, fmt.Errorf("error taking %s %d out of the trash: %v", item.Entity, item.EntityID, err)
+	}
+	return item, nil
+}
+
+// Purge deletes for good the items kept longer than the retention and
+// returns how many.
+func (s *Store) Purge() (int64, error) {
+	result, err := s.db.Exec(`DELETE FROM trash WHERE expires_at <= ?`, s.now().Unix())
+	if err != nil {
+		return 0, fmt.Errorf("error purging trash: %v", err)
+	}
+	return result.RowsAffected()
+}
+
+// Start purges the trash every interval until the process exits.
+func (s *Store) Start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if purged, err := s.Purge(); err != nil {
+				log.Printf("Error purging trash: %v", err)
+			} else if purged > 0 {
+				log.Printf("Purged %d items from the trash", purged)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+func insertRow(tx *sql.Tx, row Row) error {
+	columns, values := split(row)
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+	_, err := tx.Exec(fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", row.Table, strings.Join(columns, ", "), placeholders), values...)
+	if err != nil {
+		return fmt.Errorf("error restoring %s %v: %v", row.Table, row.Values["id"], err)
+	}
+	return nil
+}
+
+// restoreRow writes the restorable columns of row back, once it checked
+// the row is still as the deletion left it.
+func restoreRow(tx *sql.Tx, row Row) error {
+	columns, ok := restorable[row.Table]
+	if !ok {
+		return fmt.Errorf("changes to %s cannot be restored", row.Table)
+	}
+	current, err := ReadTx(tx, row.Table, "id = ?", row.Values["id"])
+	if err != nil {
+		return err
+	}
+	if len(current) == 0 {
+		return fmt.Errorf("%w: %s %v no longer exists", ErrConflict, row.Table, row.Values["id"])
+	}
+	// Compare both as they come back from the trash
+	data, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+	if err := decodeRows(string(data), &current); err != nil {
+		return err
+	}
+
+	cleared := map[string]bool{}
+	for _, column := range columns {
+		cleared[column] = true
+	}
+	for column, v := range current[0].Values {
+		if cleared[column] && !isZero(v) || !cleared[column] && v != row.Values[column] {
+			return fmt.Errorf("%w: %s %v was changed after the deletion", ErrConflict, row.Table, row.Values["id"])
+		}
+	}
+
+	values := make([]interface{}, len(columns))
+	set := make([]string, len(columns))
+	for i, column := range columns {
+		set[i] = column + " = ?"
+		values[i] = row.Values[column]
+	}
+	_, err = tx.Exec(fmt.Sprintf("UPDATE %s SET %s WHERE id = ?", row.Table, strings.Join(set, ", ")),
+		append(values, row.Values["id"])...)
+	if err != nil {
+		return fmt.Errorf("error restoring %s %v: %v", row.Table, row.Values["id"], err)
+	}
+	return nil
+}
+
+// isZero reports whether v is a cleared flag: 0, false or NULL.
+func isZero(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return true
+	case bool:
+		return !v
+	case int64:
+		return v == 0
+	case float64:
+		return v == 0
+	}
+	return false
+}
+
+func split(row Row) ([]string, []interface{}) {
+	var columns []string
+	var values []interface{}
+	for column, v := range row.Values {
+		columns = append(columns, column)
+		values = append(values, v)
+	}
+	return columns, values
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanItem(row scanner) (Item, error) {
+	var item Item
+	var rows, changed, reversed, posted string
+	var deletedAt, expiresAt int64
+	err := row.Scan(&item.ID, &item.UserID, &item.Entity, &item.EntityID, &rows, &changed, &reversed, &posted,
+		&deletedAt, &expiresAt)
+	if err != nil {
+		return item, err
+	}
+	item.DeletedAt, item.ExpiresAt = time.Unix(deletedAt, 0).UTC(), time.Unix(expiresAt, 0).UTC()
+
+	if err := decodeRows(rows, &item.Rows); err != nil {
+		return item, fmt.Errorf("error decoding trash item %d: %v", item.ID, err)
+	}
+	if err := decodeRows(changed, &item.Changed); err != nil {
+		return item, fmt.Errorf("error decoding trash item %d: %v", item.ID, err)
+	}
+	for _, column := range []struct {
+		data    string
+		entries *[]ledger.Entry
+	}{{reversed, &item.Reversed}, {posted, &item.Posted}} {
+		if err := json.Unmarshal([]byte(column.data), column.entries); err != nil {
+			return item, fmt.Errorf("error decoding trash item %d: %v", item.ID, err)
+		}
+	}
+	item.State = state(item.Rows[0])
+	return item, nil
+}
+
+// decodeRows reads rows back with their integers as int64, so amounts in
+// cents and ids are inserted again exactly as they were.
+func decodeRows(data string, rows *[]Row) error {
+	decoder := json.NewDecoder(strings.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(rows); err != nil {
+		return err
+	}
+	for _, row := range *rows {
+		for column, v := range row.Values {
+			n, ok := v.(json.Number)
+			if !ok {
+				continue
+			}
+			if i, err := n.Int64(); err == nil {
+				row.Values[column] = i
+			} else if f, err := n.Float64(); err == nil {
+				row.Values[column] = f
+			} else {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// state is row with its amount columns, money.Columns and base_amount, as
+// money, so they encode in units.
+func state(row Row) map[string]interface{} {
+	amounts := map[string]bool{"base_amount": true}
+	for _, column := range money.Columns[row.Table] {
+		amounts[column] = true
+	}
+	values := make(map[string]interface{}, len(row.Values))
+	for column, v := range row.Values {
+		if amount, ok := v.(int64); ok && amounts[column] {
+			v = money.Money(amount)
+		}
+		values[column] = v
+	}
+	return values
+}
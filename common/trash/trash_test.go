@@ -0,0 +1,163 @@
+package trash
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"backend/common/dbtest"
+	"backend/common/ledger"
+	"backend/common/money"
+)
+
+// deleteBillPayment sets up a bill paid on February 1st and deletes the
+// paying expense the way transaction_delete_service does: the expense goes
+// to the trash and the bill and its month are marked unpaid. It returns
+// the database as it was before the deletion and the trash item id.
+func deleteBillPayment(t *testing.T) (*Store, map[string][]string, int64) {
+	t.Helper()
+
+	db := dbtest.Open(t)
+	balances, err := ledger.New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(db, balances, 24*time.Hour)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	for _, statement := range []string{
+		`CREATE TABLE bills (id INTEGER PRIMARY KEY AUTOINCREMENT, user_id TEXT, name TEXT, amount INTEGER, percent REAL, paid BOOLEAN)`,
+		`CREATE TABLE bill_payments (id INTEGER PRIMARY KEY AUTOINCREMENT, bill_id INTEGER, year_month TEXT, paid BOOLEAN)`,
+		`CREATE TABLE expenses (id INTEGER PRIMARY KEY AUTOINCREMENT, user_id TEXT, amount INTEGER, bill_id INTEGER)`,
+		`INSERT INTO bills (user_id, name, amount, percent, paid) VALUES ('u1', 'Rent', 50025, 12.5, 1)`,
+		`INSERT INTO bill_payments (bill_id, year_month, paid) VALUES (1, '2025-01', 0), (1, '2025-02', 1)`,
+		`INSERT INTO expenses (user_id, amount, bill_id) VALUES ('u1', 50025, 1)`,
+	} {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+	payment := ledger.Entry{UserID: "u1", Kind: ledger.Expense, Method: ledger.Bank, Amount: 50025, Date: "2025-02-01"}
+	if err := balances.Post(payment); err != nil {
+		t.Fatal(err)
+	}
+	before := dbtest.Snapshot(t, db)
+
+	tx, _ := db.Begin()
+	rows, err := TakeTx(tx, "expenses", "id = ? AND user_id = ?", 1, "u1")
+	if err != nil {
+		t.Fatalf("TakeTx: %v", err)
+	}
+	changed, err := ReadTx(tx, "bill_payments", "bill_id = ? AND year_month = ?", 1, "2025-02")
+	if err != nil {
+		t.Fatalf("ReadTx: %v", err)
+	}
+	bill, err := ReadTx(tx, "bills", "id = ?", 1)
+	if err != nil {
+		t.Fatalf("ReadTx: %v", err)
+	}
+	tx.Exec(`UPDATE bill_payments SET paid = 0 WHERE bill_id = 1`)
+	tx.Exec(`UPDATE bills SET paid = 0 WHERE id = 1`)
+	unpaid := payment
+	unpaid.Kind = ledger.Bill
+	balances.ReplaceTx(tx, []ledger.Entry{payment}, []ledger.Entry{unpaid})
+	id, err := s.PutTx(tx, Item{UserID: "u1", Entity: "expense", EntityID: 1, Rows: rows,
+		Changed: append(changed, bill...), Reversed: []ledger.Entry{payment}, Posted: []ledger.Entry{unpaid}})
+	if err != nil {
+		t.Fatalf("PutTx: %v", err)
+	}
+	tx.Commit()
+	delete(before, "trash")
+	return s, before, id
+}
+
+func TestRestoreUndoesTheDeletion(t *testing.T) {
+	s, before, id := deleteBillPayment(t)
+
+	items, err := s.List("u1")
+	if err != nil || len(items) != 1 {
+		t.Fatalf("List = %+v, %v", items, err)
+	}
+	if items[0].State["amount"] != money.Money(50025) || items[0].State["bill_id"] != int64(1) {
+		t.Errorf("State = %v", items[0].State)
+	}
+	if others, _ := s.List("u2"); len(others) != 0 {
+		t.Errorf("Another user sees %d items", len(others))
+	}
+
+	tx, _ := s.db.Begin()
+	if _, err := s.RestoreTx(tx, "u2", id); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Restoring another user's item = %v, want ErrNotFound", err)
+	}
+	if _, err := s.RestoreTx(tx, "u1", id); err != nil {
+		t.Fatalf("RestoreTx: %v", err)
+	}
+	tx.Commit()
+
+	after := dbtest.Snapshot(t, s.db)
+	delete(after, "trash")
+	if !reflect.DeepEqual(after, before) {
+		t.Errorf("Restored database differs:\n%v\nwant\n%v", after, before)
+	}
+	if items, _ := s.List("u1"); len(items) != 0 {
+		t.Errorf("Restored item is still in the trash")
+	}
+}
+
+func TestRestoreConflictsWithLaterChanges(t *testing.T) {
+	for name, change := range map[string]string{
+		"bill edited":  `UPDATE bills SET amount = 60000`,
+		"bill paid":    `UPDATE bill_payments SET paid = 1 WHERE year_month = '2025-02'`,
+		"bill deleted": `DELETE FROM bills`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			s, _, id := deleteBillPayment(t)
+			s.db.Exec(change)
+
+			tx, _ := s.db.Begin()
+			defer tx.Rollback()
+			if _, err := s.RestoreTx(tx, "u1", id); !errors.Is(err, ErrConflict) {
+				t.Errorf("RestoreTx = %v, want ErrConflict", err)
+			}
+		})
+	}
+}
+
+func TestPurgeDropsExpiredItems(t *testing.T) {
+	db := dbtest.Open(t)
+	balances, _ := ledger.New(db)
+	s, err := New(db, balances, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	tx, _ := db.Begin()
+	row := Row{Table: "incomes", Values: map[string]interface{}{"id": int64(4), "amount": int64(100)}}
+	id, err := s.PutTx(tx, Item{UserID: "u1", Entity: "income", EntityID: 4, Rows: []Row{row}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.Commit()
+
+	now = now.Add(23 * time.Hour)
+	if purged, _ := s.Purge(); purged != 0 {
+		t.Errorf("Purged %d items before the retention", purged)
+	}
+	now = now.Add(time.Hour)
+	if items, _ := s.List("u1"); len(items) != 0 {
+		t.Errorf("Expired items are listed")
+	}
+	if purged, err := s.Purge(); purged != 1 || err != nil {
+		t.Errorf("Purge = %d, %v, want 1", purged, err)
+	}
+
+	tx, _ = db.Begin()
+	defer tx.Rollback()
+	if _, err := s.RestoreTx(tx, "u1", id); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Restoring a purged item = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,91 @@
+package twofactor
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"backend/common/auth"
+)
+
+var (
+	ErrInvalidChallenge = errors.New("invalid or expired two-factor challenge")
+	ErrTooManyAttempts  = errors.New("too many two-factor attempts")
+)
+
+const (
+	challengeTTL         = 5 * time.Minute
+	maxChallengeAttempts = 5
+)
+
+// CreateChallenge returns an opaque token that lets the holder finish the
+// sign-in of userID with a second factor within the next few minutes.
+func (s *Store) CreateChallenge(userID int) (string, error) {
+	token, err := auth.RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	now := s.now()
+	_, err = s.db.Exec(`
+		INSERT INTO auth_2fa_challenges (id, user_id, attempts, created_at, expires_at)
+		VALUES (?, ?, 0, ?, ?)`,
+		auth.HashToken(token), userID, now.Unix(), now.Add(challengeTTL).Unix(),
+	)
+	if err != nil {
+		return "", fmt.Errorf("error creating two-factor challenge: %v", err)
+	}
+	return token, nil
+}
+
+// CompleteChallenge checks code against the user behind token and returns
+// that user once. Each challenge allows a few wrong codes before it is
+// burned, so the password step has to be repeated. The user id is also
+// returned with ErrInvalidCode so callers can count the failure against the
+// account.
+func (s *Store) CompleteChallenge(token, code string) (int, error) {
+	id := auth.HashToken(token)
+
+	var userID, attempts int
+	var expiresAt int64
+	var usedAt sql.NullInt64
+	err := s.db.QueryRow(`
+		SELECT user_id, attempts, expires_at, used_at FROM auth_2fa_challenges WHERE id = ?`,
+		id,
+	).Scan(&userID, &attempts, &expiresAt, &usedAt)
+	if err == sql.ErrNoRows {
+		return 0, ErrInvalidChallenge
+	} else if err != nil {
+		return 0, fmt.Errorf("error fetching two-factor challenge: %v", err)
+	}
+
+	if usedAt.Valid || s.now().Unix() >= expiresAt {
+		return 0, ErrInvalidChallenge
+	}
+	if attempts >= maxChallengeAttempts {
+		return 0, ErrTooManyAttempts
+	}
+
+	if err := s.Verify(userID, code); err != nil {
+		if err == ErrInvalidCode {
+			if _, dbErr := s.db.Exec(`UPDATE auth_2fa_challenges SET attempts = attempts + 1 WHERE id = ?`, id); dbErr != nil {
+				return 0, fmt.Errorf("error recording two-factor attempt: %v", dbErr)
+			}
+			return userID, err
+		}
+		return 0, err
+	}
+
+	result, err := s.db.Exec(`
+		UPDATE auth_2fa_challenges SET used_at = ? WHERE id = ? AND used_at IS NULL`,
+		s.now().Unix(), id,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("error completing two-factor challenge: %v", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return 0, ErrInvalidChallenge
+	}
+	return userID, nil
+}
@@ -0,0 +1,342 @@
+package twofactor
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"backend/common/auth"
+	"backend/common/config"
+)
+
+var (
+	ErrAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrNotEnabled     = errors.New("two-factor authentication is not enabled")
+	ErrNotPending     = errors.New("two-factor setup has not been started")
+	ErrInvalidCode    = errors.New("invalid two-factor code")
+)
+
+const recoveryCodeCount = 10
+
+// Store keeps TOTP secrets, recovery codes and sign-in challenges.
+type Store struct {
+	db     *sql.DB
+	issuer string
+	now    func() time.Time
+}
+
+// Status summarizes the second factor of a user.
+type Status struct {
+	Enabled                bool `json:"enabled"`
+	RecoveryCodesRemaining int  `json:"recovery_codes_remaining"`
+}
+
+// NewStore creates a Store and makes sure its tables exist. The issuer shown
+// in authenticator apps comes from TOTP_ISSUER.
+func NewStore(db *sql.DB) (*Store, error) {
+	s := &Store{
+		db:     db,
+		issuer: config.String("TOTP_ISSUER", "Hero Budget"),
+		now:    time.Now,
+	}
+	if err := s.createTables(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Store) createTables() error {
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS user_totp (
+			user_id INTEGER PRIMARY KEY,
+			secret TEXT NOT NULL,
+			enabled INTEGER NOT NULL DEFAULT 0,
+			last_used_step INTEGER NOT NULL DEFAULT 0,
+			created_at INTEGER NOT NULL,
+			confirmed_at INTEGER
+		)`,
+		`CREATE TABLE IF NOT EXISTS user_recovery_codes (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			code_hash TEXT NOT NULL,
+			created_at INTEGER NOT NULL,
+			used_at INTEGER
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_user_recovery_codes_user ON user_recovery_codes(user_id)`,
+		`CREATE TABLE IF NOT EXISTS auth_2fa_challenges (
+			id TEXT PRIMARY KEY,
+			user_id INTEGER NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			created_at INTEGER NOT NULL,
+			expires_at INTEGER NOT NULL,
+			used_at INTEGER
+		)`,
+	}
+	for _, statement := range statements {
+		if _, err := s.db.Exec(statement); err != nil {
+			return fmt.Errorf("error creating two-factor tables: %v", err)
+		}
+	}
+	return nil
+}
+
+// Enabled reports whether userID has a confirmed second factor.
+func (s *Store) Enabled(userID int) (bool, error) {
+	var enabled bool
+	err := s.db.QueryRow(`SELECT enabled FROM user_totp WHERE user_id = ?`, userID).Scan(&enabled)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("error fetching two-factor status: %v", err)
+	}
+	return enabled, nil
+}
+
+// Status returns whether 2FA is on and how many recovery codes are left.
+func (s *Store) Status(userID int) (*Status, error) {
+	enabled, err := s.Enabled(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &Status{Enabled: enabled}
+	err = s.db.QueryRow(`
+		SELECT COUNT(*) FROM user_recovery_codes WHERE user_id = ? AND used_at IS NULL`,
+		userID,
+	).Scan(&status.RecoveryCodesRemaining)
+	if err != nil {
+		return nil, fmt.Errorf("error counting recovery codes: %v", err)
+	}
+	return status, nil
+}
+
+// Begin stores a new pending secret for userID and returns it with its
+// provisioning URI. Calling it again before Confirm replaces the secret.
+func (s *Store) Begin(userID int, account string) (secret, uri string, err error) {
+	enabled, err := s.Enabled(userID)
+	if err != nil {
+		return "", "", err
+	}
+	if enabled {
+		return "", "", ErrAlreadyEnabled
+	}
+
+	secret, err = GenerateSecret()
+	if err != nil {
+		return "", "", err
+	}
+
+	_, err = s.db.Exec(`
+		INSERT INTO user_totp (user_id, secret, enabled, last_used_step, created_at)
+		VALUES (?, ?, 0, 0, ?)
+		ON CONFLICT(user_id) DO UPDATE SET
+			secret = excluded.secret, last_used_step = 0, created_at = excluded.created_at`,
+		userID, secret, s.now().Unix(),
+	)
+	if err != nil {
+		return "", "", fmt.Errorf("error storing TOTP secret: %v", err)
+	}
+
+	return secret, ProvisioningURI(s.issuer, account, secret), nil
+}
+
+// Confirm enables the pending secret once the user proves their app
+// produces valid codes, and returns the first set of recovery codes.
+func (s *Store) Confirm(userID int, code string) ([]string, error) {
+	var secret string
+	var enabled bool
+	err := s.db.QueryRow(`SELECT secret, enabled FROM user_totp WHERE user_id = ?`, userID).Scan(&secret, &enabled)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotPending
+	} else if err != nil {
+		return nil, fmt.Errorf("error fetching TOTP secret: %v", err)
+	}
+	if enabled {
+		return nil, ErrAlreadyEnabled
+	}
+
+	if err := s.useTOTP(userID, secret, normalizeCode(code)); err != nil {
+		return nil, err
+	}
+
+	// 2FA is never on without its recovery codes
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`UPDATE user_totp SET enabled = 1, confirmed_at = ? WHERE user_id = ? AND enabled = 0`, s.now().Unix(), userID)
+	if err != nil {
+		return nil, fmt.Errorf("error enabling two-factor authentication: %v", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return nil, ErrAlreadyEnabled
+	}
+	codes, err := replaceRecoveryCodesTx(tx, userID, s.now())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error enabling two-factor authentication: %v", err)
+	}
+	return codes, nil
+}
+
+// Disable turns 2FA off after checking a current TOTP or recovery code.
+func (s *Store) Disable(userID int, code string) error {
+	if err := s.Verify(userID, code); err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM user_recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return fmt.Errorf("error deleting recovery codes: %v", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM user_totp WHERE user_id = ?`, userID); err != nil {
+		return fmt.Errorf("error disabling two-factor authentication: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error disabling two-factor authentication: %v", err)
+	}
+	return nil
+}
+
+// RegenerateRecoveryCodes invalidates all existing recovery codes and
+// returns a new set, after checking a current TOTP code.
+func (s *Store) RegenerateRecoveryCodes(userID int, code string) ([]string, error) {
+	code = normalizeCode(code)
+	if !isTOTPCode(code) {
+		return nil, ErrInvalidCode
+	}
+	if err := s.Verify(userID, code); err != nil {
+		return nil, err
+	}
+	return s.replaceRecoveryCodes(userID)
+}
+
+// Verify checks a TOTP code or consumes a recovery code of userID. Each TOTP
+// code is accepted only once.
+func (s *Store) Verify(userID int, code string) error {
+	var secret string
+	var enabled bool
+	err := s.db.QueryRow(`SELECT secret, enabled FROM user_totp WHERE user_id = ?`, userID).Scan(&secret, &enabled)
+	if err == sql.ErrNoRows || (err == nil && !enabled) {
+		return ErrNotEnabled
+	} else if err != nil {
+		return fmt.Errorf("error fetching TOTP secret: %v", err)
+	}
+
+	code = normalizeCode(code)
+	if isTOTPCode(code) {
+		return s.useTOTP(userID, secret, code)
+	}
+	return s.useRecoveryCode(userID, code)
+}
+
+func (s *Store) useTOTP(userID int, secret, code string) error {
+	step, err := matchStep(secret, code, s.now())
+	if err != nil {
+		return err
+	}
+	if step == 0 {
+		return ErrInvalidCode
+	}
+
+	// Guarded update: a code (or an older one) that was already used fails
+	result, err := s.db.Exec(`
+		UPDATE user_totp SET last_used_step = ? WHERE user_id = ? AND last_used_step < ?`,
+		step, userID, step,
+	)
+	if err != nil {
+		return fmt.Errorf("error recording TOTP use: %v", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return ErrInvalidCode
+	}
+	return nil
+}
+
+func (s *Store) useRecoveryCode(userID int, code string) error {
+	result, err := s.db.Exec(`
+		UPDATE user_recovery_codes SET used_at = ?
+		WHERE user_id = ? AND code_hash = ? AND used_at IS NULL`,
+		s.now().Unix(), userID, auth.HashToken(code),
+	)
+	if err != nil {
+		return fmt.Errorf("error consuming recovery code: %v", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return ErrInvalidCode
+	}
+	return nil
+}
+
+// replaceRecoveryCodes stores the hashes of a fresh set of codes and returns
+// the plain codes, which are shown to the user only this once.
+func (s *Store) replaceRecoveryCodes(userID int) ([]string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	codes, err := replaceRecoveryCodesTx(tx, userID, s.now())
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing recovery codes: %v", err)
+	}
+	return codes, nil
+}
+
+// replaceRecoveryCodesTx is replaceRecoveryCodes inside tx.
+func replaceRecoveryCodesTx(tx *sql.Tx, userID int, now time.Time) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+	}
+
+	if _, err := tx.Exec(`DELETE FROM user_recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return nil, fmt.Errorf("error deleting recovery codes: %v", err)
+	}
+	for _, code := range codes {
+		_, err := tx.Exec(`
+			INSERT INTO user_recovery_codes (user_id, code_hash, created_at) VALUES (?, ?, ?)`,
+			userID, auth.HashToken(normalizeCode(code)), now.Unix(),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error storing recovery code: %v", err)
+		}
+	}
+	return codes, nil
+}
+
+// newRecoveryCode returns a code like "k3m9q-x7t2w".
+func newRecoveryCode() (string, error) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		return "", err
+	}
+	code := strings.ToLower(secret[:10])
+	return code[:5] + "-" + code[5:], nil
+}
+
+// normalizeCode strips the separators users type or paste along with codes.
+func normalizeCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
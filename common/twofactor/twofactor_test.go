@@ -0,0 +1,123 @@
+package twofactor
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func newTestStore(t *testing.T) (*Store, *time.Time) {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	s, err := NewStore(db)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+
+	now := time.Unix(1_700_000_000, 0)
+	s.now = func() time.Time { return now }
+	return s, &now
+}
+
+func TestCodeMatchesRFC6238(t *testing.T) {
+	// RFC 6238 appendix B, SHA1 secret "12345678901234567890"
+	secret := secretEncoding.EncodeToString([]byte("12345678901234567890"))
+	code, err := Code(secret, time.Unix(59, 0))
+	if err != nil {
+		t.Fatalf("Code failed: %v", err)
+	}
+	if code != "287082" {
+		t.Errorf("Expected 287082, got %s", code)
+	}
+}
+
+func TestEnrollAndVerify(t *testing.T) {
+	s, now := newTestStore(t)
+
+	secret, uri, err := s.Begin(1, "user@example.com")
+	if err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	if uri == "" {
+		t.Errorf("Expected a provisioning URI")
+	}
+
+	if _, err := s.Confirm(1, "000000"); err != ErrInvalidCode {
+		t.Errorf("Expected ErrInvalidCode for wrong code, got %v", err)
+	}
+
+	code, _ := Code(secret, *now)
+	recovery, err := s.Confirm(1, code)
+	if err != nil {
+		t.Fatalf("Confirm failed: %v", err)
+	}
+	if len(recovery) != recoveryCodeCount {
+		t.Errorf("Expected %d recovery codes, got %d", recoveryCodeCount, len(recovery))
+	}
+
+	// The code used to confirm cannot be replayed
+	if err := s.Verify(1, code); err != ErrInvalidCode {
+		t.Errorf("Expected replayed code to fail, got %v", err)
+	}
+
+	*now = now.Add(30 * time.Second)
+	next, _ := Code(secret, *now)
+	if err := s.Verify(1, next); err != nil {
+		t.Errorf("Expected next code to verify, got %v", err)
+	}
+
+	// Recovery codes work once, with or without the dash
+	if err := s.Verify(1, recovery[0]); err != nil {
+		t.Errorf("Expected recovery code to verify, got %v", err)
+	}
+	if err := s.Verify(1, recovery[0]); err != ErrInvalidCode {
+		t.Errorf("Expected used recovery code to fail, got %v", err)
+	}
+
+	status, _ := s.Status(1)
+	if !status.Enabled || status.RecoveryCodesRemaining != recoveryCodeCount-1 {
+		t.Errorf("Unexpected status %+v", status)
+	}
+}
+
+func TestChallengeAttemptsAreLimited(t *testing.T) {
+	s, now := newTestStore(t)
+
+	secret, _, _ := s.Begin(2, "a@b.c")
+	code, _ := Code(secret, *now)
+	s.Confirm(2, code)
+
+	token, err := s.CreateChallenge(2)
+	if err != nil {
+		t.Fatalf("CreateChallenge failed: %v", err)
+	}
+	for i := 0; i < maxChallengeAttempts; i++ {
+		if _, err := s.CompleteChallenge(token, "000000"); err != ErrInvalidCode {
+			t.Fatalf("Expected ErrInvalidCode, got %v", err)
+		}
+	}
+
+	*now = now.Add(30 * time.Second)
+	code, _ = Code(secret, *now)
+	if _, err := s.CompleteChallenge(token, code); err != ErrTooManyAttempts {
+		t.Errorf("Expected ErrTooManyAttempts, got %v", err)
+	}
+
+	token, _ = s.CreateChallenge(2)
+	userID, err := s.CompleteChallenge(token, code)
+	if err != nil || userID != 2 {
+		t.Fatalf("Expected challenge to complete for user 2, got %d, %v", userID, err)
+	}
+	if _, err := s.CompleteChallenge(token, code); err != ErrInvalidChallenge {
+		t.Errorf("Expected used challenge to be rejected, got %v", err)
+	}
+}
@@ -25,7 +25,7 @@ compile_service() {
         
         # Compilar el servicio
         echo "   - Compilando binario..."
-        go build -o "$service_name" main.go
+        go build -tags sqlite_fts5 -o "$service_name" .
         
         if [ $? -eq 0 ]; then
             echo "   ✅ $service_name compilado exitosamente"
@@ -10,6 +10,10 @@ import (
 	"path/filepath"
 	"time"
 
+	"backend/common/auth"
+	"backend/common/currency"
+	"backend/common/money"
+
 	_ "github.com/mattn/go-sqlite3"
 )
 
@@ -25,62 +29,63 @@ type DashboardData struct {
 }
 
 type BudgetOverview struct {
-	MoneyFlow       MoneyFlow `json:"money_flow"`
-	RemainingAmount float64   `json:"remaining_amount"`
-	TotalAmount     float64   `json:"total_amount"`
-	SpentAmount     float64   `json:"spent_amount"`
-	UpcomingAmount  float64   `json:"upcoming_amount"`
-	CombinedExpense float64   `json:"combined_expense"`
-	ExpensePercent  float64   `json:"expense_percent"`
-	DailyRate       float64   `json:"daily_rate"`
-	HighSpending    bool      `json:"high_spending"`
-	TotalIncome     float64   `json:"total_income"`
+	MoneyFlow       MoneyFlow   `json:"money_flow"`
+	RemainingAmount money.Money `json:"remaining_amount"`
+	TotalAmount     money.Money `json:"total_amount"`
+	SpentAmount     money.Money `json:"spent_amount"`
+	UpcomingAmount  money.Money `json:"upcoming_amount"`
+	CombinedExpense money.Money `json:"combined_expense"`
+	ExpensePercent  float64     `json:"expense_percent"`
+	DailyRate       money.Money `json:"daily_rate"`
+	HighSpending    bool        `json:"high_spending"`
+	TotalIncome     money.Money `json:"total_income"`
 }
 
 type MoneyFlow struct {
-	Percent      float64 `json:"percent"`
-	FromPrevious float64 `json:"from_previous"`
+	Percent      float64     `json:"percent"`
+	FromPrevious money.Money `json:"from_previous"`
 }
 
 type SavingsOverview struct {
-	Percent     float64 `json:"percent"`
-	Available   float64 `json:"available"`
-	Goal        float64 `json:"goal"`
-	Period      string  `json:"period"`
-	NeedToSave  float64 `json:"need_to_save"`
-	DailyTarget float64 `json:"daily_target"`
+	Percent     float64     `json:"percent"`
+	Available   money.Money `json:"available"`
+	Goal        money.Money `json:"goal"`
+	Period      string      `json:"period"`
+	NeedToSave  money.Money `json:"need_to_save"`
+	DailyTarget money.Money `json:"daily_target"`
 }
 
 type CashBank struct {
-	Month        string  `json:"month"`
-	CashAmount   float64 `json:"cash_amount"`
-	CashPercent  float64 `json:"cash_percent"`
-	BankAmount   float64 `json:"bank_amount"`
-	BankPercent  float64 `json:"bank_percent"`
-	MonthlyTotal float64 `json:"monthly_total"`
+	Month        string      `json:"month"`
+	CashAmount   money.Money `json:"cash_amount"`
+	CashPercent  float64     `json:"cash_percent"`
+	BankAmount   money.Money `json:"bank_amount"`
+	BankPercent  float64     `json:"bank_percent"`
+	MonthlyTotal money.Money `json:"monthly_total"`
 }
 
 type FinanceMetrics struct {
-	Income   float64 `json:"income"`
-	Expenses float64 `json:"expenses"`
-	Bills    float64 `json:"bills"`
+	Income   money.Money `json:"income"`
+	Expenses money.Money `json:"expenses"`
+	Bills    money.Money `json:"bills"`
 }
 
 type Bill struct {
-	ID          int     `json:"id"`
-	Name        string  `json:"name"`
-	Amount      float64 `json:"amount"`
-	DueDate     string  `json:"due_date"`
-	Paid        bool    `json:"paid"`
-	Overdue     bool    `json:"overdue"`
-	OverdueDays int     `json:"overdue_days"`
-	Recurring   bool    `json:"recurring"`
-	Category    string  `json:"category"`
-	Icon        string  `json:"icon"`
+	ID          int         `json:"id"`
+	Name        string      `json:"name"`
+	Amount      money.Money `json:"amount"`
+	DueDate     string      `json:"due_date"`
+	Paid        bool        `json:"paid"`
+	Overdue     bool        `json:"overdue"`
+	OverdueDays int         `json:"overdue_days"`
+	Recurring   bool        `json:"recurring"`
+	Category    string      `json:"category"`
+	Icon        string      `json:"icon"`
 }
 
 var (
-	db *sql.DB
+	db       *sql.DB
+	sessions *auth.Manager
 )
 
 func init() {
@@ -121,11 +126,11 @@ func createTablesIfNotExist() {
 			user_id TEXT NOT NULL,
 			period TEXT NOT NULL,
 			date TEXT NOT NULL,
-			total_amount REAL NOT NULL,
-			remaining_amount REAL NOT NULL,
-			spent_amount REAL NOT NULL,
-			upcoming_amount REAL NOT NULL,
-			from_previous REAL NOT NULL,
+			total_amount INTEGER NOT NULL,
+			remaining_amount INTEGER NOT NULL,
+			spent_amount INTEGER NOT NULL,
+			upcoming_amount INTEGER NOT NULL,
+			from_previous INTEGER NOT NULL,
 			percent REAL NOT NULL,
 			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
@@ -140,8 +145,8 @@ func createTablesIfNotExist() {
 		CREATE TABLE IF NOT EXISTS savings (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
 			user_id TEXT NOT NULL,
-			available REAL NOT NULL,
-			goal REAL NOT NULL,
+			available INTEGER NOT NULL,
+			goal INTEGER NOT NULL,
 			period TEXT NOT NULL DEFAULT 'monthly',
 			percent REAL NOT NULL,
 			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
@@ -167,11 +172,11 @@ func createTablesIfNotExist() {
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
 			user_id TEXT NOT NULL,
 			month TEXT NOT NULL,
-			cash_amount REAL NOT NULL,
+			cash_amount INTEGER NOT NULL,
 			cash_percent REAL NOT NULL,
-			bank_amount REAL NOT NULL,
+			bank_amount INTEGER NOT NULL,
 			bank_percent REAL NOT NULL,
-			monthly_total REAL NOT NULL,
+			monthly_total INTEGER NOT NULL,
 			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 		)
@@ -186,9 +191,9 @@ func createTablesIfNotExist() {
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
 			user_id TEXT NOT NULL,
 			period TEXT NOT NULL,
-			income REAL NOT NULL,
-			expenses REAL NOT NULL,
-			bills REAL NOT NULL,
+			income INTEGER NOT NULL,
+			expenses INTEGER NOT NULL,
+			bills INTEGER NOT NULL,
 			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 		)
@@ -203,7 +208,7 @@ func createTablesIfNotExist() {
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
 			user_id TEXT NOT NULL,
 			name TEXT NOT NULL,
-			amount REAL NOT NULL,
+			amount INTEGER NOT NULL,
 			due_date TEXT NOT NULL,
 			paid BOOLEAN NOT NULL,
 			overdue BOOLEAN NOT NULL,
@@ -219,6 +224,12 @@ func createTablesIfNotExist() {
 		log.Fatalf("Failed to create bills table: %v", err)
 	}
 
+	// Amounts are stored as cents; tables created with REAL amounts are
+	// converted before the mock rows, which are written in cents, go in
+	if err := money.Migrate(db); err != nil {
+		log.Fatalf("Failed to migrate amounts to cents: %v", err)
+	}
+
 	// Insert mock data for testing
 	insertMockDataIfEmpty()
 }
@@ -242,7 +253,7 @@ func insertMockData() {
 	// Insert mock budget data
 	_, err := db.Exec(`
 		INSERT INTO budget (user_id, period, date, total_amount, remaining_amount, spent_amount, upcoming_amount, from_previous, percent)
-		VALUES ('1', 'monthly', '2025-05-01', 975.00, 875.00, 0.00, 100.00, 975.00, 10.0)
+		VALUES ('1', 'monthly', '2025-05-01', 97500, 87500, 0, 10000, 97500, 10.0)
 	`)
 	if err != nil {
 		log.Printf("Error inserting mock budget data: %v", err)
@@ -251,7 +262,7 @@ func insertMockData() {
 	// Insert mock savings data
 	_, err = db.Exec(`
 		INSERT INTO savings (user_id, available, goal, percent)
-		VALUES ('1', 875.00, 1000.00, 88.0)
+		VALUES ('1', 87500, 100000, 88.0)
 	`)
 	if err != nil {
 		log.Printf("Error inserting mock savings data: %v", err)
@@ -260,7 +271,7 @@ func insertMockData() {
 	// Insert mock cash_bank data
 	_, err = db.Exec(`
 		INSERT INTO cash_bank (user_id, month, cash_amount, cash_percent, bank_amount, bank_percent, monthly_total)
-		VALUES ('1', 'mayo de 2025', 200.00, 100.0, 0.00, 0.0, 200.00)
+		VALUES ('1', 'mayo de 2025', 20000, 100.0, 0, 0.0, 20000)
 	`)
 	if err != nil {
 		log.Printf("Error inserting mock cash_bank data: %v", err)
@@ -269,7 +280,7 @@ func insertMockData() {
 	// Insert mock finance_metrics data
 	_, err = db.Exec(`
 		INSERT INTO finance_metrics (user_id, period, income, expenses, bills)
-		VALUES ('1', 'monthly', 0.00, 0.00, 100.00)
+		VALUES ('1', 'monthly', 0, 0, 10000)
 	`)
 	if err != nil {
 		log.Printf("Error inserting mock finance_metrics data: %v", err)
@@ -278,7 +289,7 @@ func insertMockData() {
 	// Insert mock bills data
 	_, err = db.Exec(`
 		INSERT INTO bills (user_id, name, amount, due_date, paid, overdue, overdue_days, recurring, category, icon)
-		VALUES ('1', 'Cash', 100.00, '2025-05-28', false, true, 8751, true, 'Rent', '🏠')
+		VALUES ('1', 'Cash', 10000, '2025-05-28', false, true, 8751, true, 'Rent', '🏠')
 	`)
 	if err != nil {
 		log.Printf("Error inserting mock bills data: %v", err)
@@ -286,8 +297,20 @@ func insertMockData() {
 }
 
 func main() {
+	// Every request must carry an access token issued by signin, google_auth or apple-auth
+	var err error
+	sessions, err = auth.NewManagerFromEnv(db)
+	if err != nil {
+		log.Fatalf("Failed to initialize sessions: %v", err)
+	}
+
+	// Totals add up base amounts; this adds them to tables that predate currencies
+	if _, err := currency.NewStoreFromEnv(db); err != nil {
+		log.Fatalf("Failed to initialize currencies: %v", err)
+	}
+
 	// Set up CORS middleware
-	http.HandleFunc("/dashboard/data", corsMiddleware(handleFetchDashboardData))
+	http.HandleFunc("/dashboard/data", corsMiddleware(sessions.Require(handleFetchDashboardData)))
 
 	port := 8087
 	log.Printf("Dashboard Data service started on :%d", port)
@@ -458,9 +481,9 @@ func fetchBudgetOverview(userID, period string) (BudgetOverview, error) {
 	}
 
 	// Get total income for the period
-	var totalIncome float64
+	var totalIncome money.Money
 	err = db.QueryRow(`
-		SELECT COALESCE(SUM(amount), 0)
+		SELECT COALESCE(SUM(COALESCE(base_amount, amount)), 0)
 		FROM incomes
 		WHERE user_id = ? AND date BETWEEN ? AND ?
 	`, userID, startDate, endDate).Scan(&totalIncome)
@@ -475,7 +498,7 @@ func fetchBudgetOverview(userID, period string) (BudgetOverview, error) {
 	// Calculate combined expense and expense percent
 	budgetOverview.CombinedExpense = budgetOverview.SpentAmount + budgetOverview.UpcomingAmount
 	if budgetOverview.TotalAmount > 0 {
-		budgetOverview.ExpensePercent = (budgetOverview.CombinedExpense / budgetOverview.TotalAmount) * 100
+		budgetOverview.ExpensePercent = (budgetOverview.CombinedExpense.Float64() / budgetOverview.TotalAmount.Float64()) * 100
 	}
 
 	// Calculate daily rate
@@ -499,7 +522,7 @@ func fetchBudgetOverview(userID, period string) (BudgetOverview, error) {
 	}
 
 	if daysInPeriod > 0 {
-		budgetOverview.DailyRate = budgetOverview.CombinedExpense / float64(daysInPeriod)
+		budgetOverview.DailyRate = money.FromFloat(budgetOverview.CombinedExpense.Float64() / float64(daysInPeriod))
 	}
 
 	// Determine high spending warning
@@ -547,7 +570,7 @@ func fetchSavingsOverview(userID string) (SavingsOverview, error) {
 	}
 
 	// Assuming goal needs to be achieved within a month (30 days)
-	savingsOverview.DailyTarget = savingsOverview.NeedToSave / 30
+	savingsOverview.DailyTarget = money.FromFloat(savingsOverview.NeedToSave.Float64() / 30)
 
 	return savingsOverview, nil
 }
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"backend/common/account"
+	"backend/common/currency"
+	"backend/common/dbtest"
+	"backend/common/journal"
+	"backend/common/ledger"
+	"backend/common/listing"
+)
+
+// writeTables are every table an expense write touches
+var writeTables = []string{
+	"expenses", "balances", "cash_bank", "cash_bank_transactions", "journal",
+	"daily_cash_bank_balance", "monthly_cash_bank_balance", "monthly_balance", "annual_balance",
+}
+
+// newTestDB points the service at an empty database with one expense of
+// 100 cents in cash already added
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db = dbtest.Open(t)
+	createTablesIfNotExist()
+	var err error
+	balances, err = ledger.New(db)
+	if err != nil {
+		t.Fatalf("Failed to create ledger: %v", err)
+	}
+	if _, err := db.Exec(`CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT)`); err != nil {
+		t.Fatalf("Failed to create users: %v", err)
+	}
+	currencies, err = currency.NewStore(db, "EUR")
+	if err != nil {
+		t.Fatalf("Failed to create currency store: %v", err)
+	}
+	accounts, err = account.NewStore(db, balances)
+	if err != nil {
+		t.Fatalf("Failed to create account store: %v", err)
+	}
+	audit, err = journal.New(db)
+	if err != nil {
+		t.Fatalf("Failed to create journal: %v", err)
+	}
+
+	err = call(handleAddExpense, Expense{UserID: "u1", Amount: 100, Date: "2025-01-10", Category: "food", PaymentMethod: "cash"})
+	if err != nil {
+		t.Fatalf("Failed to add expense: %v", err)
+	}
+	return db
+}
+
+// call runs handler with body and returns an error unless it answered 200
+func call(handler http.HandlerFunc, body interface{}) error {
+	payload, _ := json.Marshal(body)
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest("POST", "/", bytes.NewReader(payload)))
+	if rr.Code != http.StatusOK {
+		return fmt.Errorf("status %d: %s", rr.Code, rr.Body.String())
+	}
+	return nil
+}
+
+func TestAddExpenseIsAtomic(t *testing.T) {
+	failed := dbtest.Atomic(t, writeTables, newTestDB, func(t *testing.T) error {
+		return call(handleAddExpense, Expense{UserID: "u1", Amount: 40, Date: "2025-02-03", Category: "food", PaymentMethod: "bank"})
+	})
+	assertFailedOn(t, failed, "INSERT expenses", "UPDATE balances", "INSERT cash_bank_transactions", "UPDATE annual_balance", "INSERT journal")
+}
+
+func TestUpdateExpenseIsAtomic(t *testing.T) {
+	failed := dbtest.Atomic(t, writeTables, newTestDB, func(t *testing.T) error {
+		return call(handleUpdateExpense, UpdateExpenseRequest{UserID: "u1", ExpenseID: 1, Amount: 60, Date: "2025-03-01"})
+	})
+	assertFailedOn(t, failed, "UPDATE expenses", "UPDATE balances", "UPDATE annual_balance", "INSERT journal")
+}
+
+func TestDeleteExpenseIsAtomic(t *testing.T) {
+	failed := dbtest.Atomic(t, writeTables, newTestDB, func(t *testing.T) error {
+		return call(handleDeleteExpense, DeleteExpenseRequest{UserID: "u1", ExpenseID: 1})
+	})
+	assertFailedOn(t, failed, "DELETE expenses", "UPDATE balances", "UPDATE annual_balance", "INSERT journal")
+}
+
+func TestExpenseWritesReachEveryTable(t *testing.T) {
+	newTestDB(t)
+
+	var expenses int
+	var cash, total float64
+	db.QueryRow(`SELECT COUNT(*) FROM expenses`).Scan(&expenses)
+	db.QueryRow(`SELECT cash_balance FROM balances WHERE user_id = 'u1'`).Scan(&cash)
+	db.QueryRow(`SELECT total_balance FROM monthly_cash_bank_balance WHERE user_id = 'u1' AND year_month = '2025-01'`).Scan(&total)
+	if expenses != 1 || cash != -100 || total != -100 {
+		t.Errorf("After adding: expenses %d, cash balance %v, January total %v", expenses, cash, total)
+	}
+}
+
+func TestExpenseChangesAreJournaled(t *testing.T) {
+	newTestDB(t)
+	if err := call(handleUpdateExpense, UpdateExpenseRequest{UserID: "u1", ExpenseID: 1, Amount: 60}); err != nil {
+		t.Fatal(err)
+	}
+	if err := call(handleDeleteExpense, DeleteExpenseRequest{UserID: "u1", ExpenseID: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := audit.Records("u1", "expense", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || records[0].Action != journal.Create || records[1].Action != journal.Update || records[2].Action != journal.Delete {
+		t.Fatalf("Records = %+v", records)
+	}
+	var before, after Expense
+	json.Unmarshal(records[1].Before, &before)
+	json.Unmarshal(records[1].After, &after)
+	if before.Amount != 100 || after.Amount != 60 || records[1].Reversed[0].Amount != 100 || records[1].Posted[0].Amount != 60 {
+		t.Errorf("Update record = %+v", records[1])
+	}
+	if records[2].After != nil || records[2].Reversed[0].Amount != 60 {
+		t.Errorf("Delete record = %+v", records[2])
+	}
+}
+
+func TestAmountsAreCentsInTheDatabase(t *testing.T) {
+	newTestDB(t)
+	for i := 0; i < 3; i++ {
+		body := `{"user_id": "u1", "amount": 0.1, "date": "2025-01-11", "category": "food", "payment_method": "cash"}`
+		rr := httptest.NewRecorder()
+		handleAddExpense(rr, httptest.NewRequest("POST", "/", strings.NewReader(body)))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Add failed: %d %s", rr.Code, rr.Body.String())
+		}
+	}
+
+	var stored int64
+	var total float64
+	db.QueryRow(`SELECT SUM(amount) FROM expenses WHERE date = '2025-01-11'`).Scan(&stored)
+	db.QueryRow(`SELECT total_balance FROM monthly_cash_bank_balance WHERE user_id = 'u1' AND year_month = '2025-01'`).Scan(&total)
+	if stored != 30 || total != -130 {
+		t.Errorf("Stored %d cents, January total %v cents", stored, total)
+	}
+
+	rr := httptest.NewRecorder()
+	handleFetchExpenses(rr, httptest.NewRequest("GET", "/expenses?user_id=u1", nil))
+	if !strings.Contains(rr.Body.String(), `"amount":0.1,`) {
+		t.Errorf("Expected amounts in units, got %s", rr.Body.String())
+	}
+}
+
+func TestForeignExpensesPostTheirBaseAmount(t *testing.T) {
+	newTestDB(t)
+	if err := currencies.SetRates(currency.Rate{From: "USD", To: "EUR", Date: "2025-01-01", Rate: 0.9}); err != nil {
+		t.Fatal(err)
+	}
+
+	err := call(handleAddExpense, Expense{UserID: "u1", Amount: 2000, Date: "2025-01-12", Category: "travel", PaymentMethod: "cash", Currency: "usd"})
+	if err != nil {
+		t.Fatalf("Failed to add expense: %v", err)
+	}
+	var code string
+	var amount, base, total int64
+	db.QueryRow(`SELECT currency, amount, base_amount FROM expenses WHERE id = 2`).Scan(&code, &amount, &base)
+	db.QueryRow(`SELECT total_balance FROM monthly_cash_bank_balance WHERE user_id = 'u1' AND year_month = '2025-01'`).Scan(&total)
+	if code != "USD" || amount != 2000 || base != 1800 || total != -1900 {
+		t.Errorf("Stored %s %d as %d, January total %d", code, amount, base, total)
+	}
+
+	// A rate published later does not move the expense unless it changes
+	if err := currencies.SetRates(currency.Rate{From: "USD", To: "EUR", Date: "2025-01-12", Rate: 0.5}); err != nil {
+		t.Fatal(err)
+	}
+	if err := call(handleUpdateExpense, UpdateExpenseRequest{UserID: "u1", ExpenseID: 2, Category: "flights"}); err != nil {
+		t.Fatalf("Failed to update expense: %v", err)
+	}
+	db.QueryRow(`SELECT base_amount FROM expenses WHERE id = 2`).Scan(&base)
+	if base != 1800 {
+		t.Errorf("Base amount moved to %d after a category change", base)
+	}
+
+	if err := call(handleDeleteExpense, DeleteExpenseRequest{UserID: "u1", ExpenseID: 2}); err != nil {
+		t.Fatalf("Failed to delete expense: %v", err)
+	}
+	db.QueryRow(`SELECT total_balance FROM monthly_cash_bank_balance WHERE user_id = 'u1' AND year_month = '2025-01'`).Scan(&total)
+	if total != -100 {
+		t.Errorf("January total %d after deleting the foreign expense", total)
+	}
+
+	err = call(handleAddExpense, Expense{UserID: "u1", Amount: 500, Date: "2025-01-12", Category: "food", PaymentMethod: "cash", Currency: "JPY"})
+	if err == nil || !strings.Contains(err.Error(), "status 400") {
+		t.Errorf("Expense without a rate: %v", err)
+	}
+}
+
+func TestExpensesAreChargedToTheirAccount(t *testing.T) {
+	newTestDB(t)
+	card, err := accounts.Create(account.Account{UserID: "u1", Name: "Visa", Type: account.CreditCard, OpeningDate: "2025-01-01"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cards := func() (balance, bank int64) {
+		db.QueryRow(`SELECT balance FROM monthly_account_balance WHERE account_id = ? AND year_month = '2025-01'`, card.ID).Scan(&balance)
+		db.QueryRow(`SELECT balance_bank_amount FROM monthly_cash_bank_balance WHERE user_id = 'u1' AND year_month = '2025-01'`).Scan(&bank)
+		return balance, bank
+	}
+
+	if err := call(handleAddExpense, Expense{UserID: "u1", Amount: 40, Date: "2025-01-15", Category: "fuel", AccountID: card.ID}); err != nil {
+		t.Fatalf("Failed to add expense: %v", err)
+	}
+	// Card charges are debt, the bank keeps its money until the statement is paid
+	if balance, bank := cards(); balance != -40 || bank != 0 {
+		t.Errorf("Card balance %d, bank total %d; want -40, 0", balance, bank)
+	}
+
+	// Moving the cash expense to the card takes it out of cash
+	if err := call(handleUpdateExpense, UpdateExpenseRequest{UserID: "u1", ExpenseID: 1, AccountID: card.ID}); err != nil {
+		t.Fatalf("Failed to update expense: %v", err)
+	}
+	var cash, debt int64
+	db.QueryRow(`SELECT balance_cash_amount, balance_credit_amount FROM monthly_cash_bank_balance
+		WHERE user_id = 'u1' AND year_month = '2025-01'`).Scan(&cash, &debt)
+	if balance, bank := cards(); balance != -140 || bank != 0 || cash != 0 || debt != -140 {
+		t.Errorf("After moving: card %d, bank %d, cash %d, debt %d", balance, bank, cash, debt)
+	}
+
+	if err := call(handleUpdateExpense, UpdateExpenseRequest{UserID: "u1", ExpenseID: 1, AccountID: 999}); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("Moving to a missing account: %v, want 404", err)
+	}
+}
+
+func TestCardsCannotBeChargedBeyondTheirLimit(t *testing.T) {
+	newTestDB(t)
+	card, err := accounts.Create(account.Account{UserID: "u1", Name: "Visa", Type: account.CreditCard,
+		CreditLimit: 500, ClosingDay: 25, DueDay: 5, OpeningDate: "2025-01-01"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := call(handleAddExpense, Expense{UserID: "u1", Amount: 400, Date: "2025-01-15", Category: "fuel", AccountID: card.ID}); err != nil {
+		t.Fatalf("Failed to add expense: %v", err)
+	}
+	err = call(handleAddExpense, Expense{UserID: "u1", Amount: 200, Date: "2025-01-16", Category: "fuel", AccountID: card.ID})
+	if err == nil || !strings.Contains(err.Error(), "400") {
+		t.Errorf("Charge over the limit: %v, want 400", err)
+	}
+	// Moving the cash expense onto the card still fits
+	if err := call(handleUpdateExpense, UpdateExpenseRequest{UserID: "u1", ExpenseID: 1, AccountID: card.ID}); err != nil {
+		t.Errorf("Failed to move expense to the card: %v", err)
+	}
+	var charges int
+	db.QueryRow(`SELECT COUNT(*) FROM expenses WHERE account_id = ?`, card.ID).Scan(&charges)
+	if charges != 2 {
+		t.Errorf("Card has %d expenses, want 2", charges)
+	}
+}
+
+// assertFailedOn checks that each fault point made the write fail, i.e.
+// that the write really goes through it
+func assertFailedOn(t *testing.T, failed []string, points ...string) {
+	t.Helper()
+
+	hit := map[string]bool{}
+	for _, point := range failed {
+		hit[point] = true
+	}
+	for _, point := range points {
+		if !hit[point] {
+			t.Errorf("Expected a fault on %s to make the write fail", point)
+		}
+	}
+}
+
+func TestExpenseListsArePaged(t *testing.T) {
+	newTestDB(t)
+	for _, e := range []Expense{
+		{UserID: "u1", Amount: 2500, Date: "2025-01-12", Category: "rent", PaymentMethod: "bank"},
+		{UserID: "u1", Amount: 700, Date: "2025-01-11", Category: "food", PaymentMethod: "cash"},
+	} {
+		if err := call(handleAddExpense, e); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+
+	var got []string
+	cursor := ""
+	for pages := 0; pages < 5; pages++ {
+		rr := httptest.NewRecorder()
+		handleFetchExpenses(rr, httptest.NewRequest("GET", "/expenses?user_id=u1&categories=food&limit=1&cursor="+cursor, nil))
+		var response struct {
+			Data []Expense    `json:"data"`
+			Page listing.Page `json:"page"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil || rr.Code != http.StatusOK {
+			t.Fatalf("Fetch failed: %d %s", rr.Code, rr.Body.String())
+		}
+		if response.Page.Total != 2 || len(response.Data) != 1 {
+			t.Fatalf("Page = %+v with %d expenses, want 1 of 2", response.Page, len(response.Data))
+		}
+		got = append(got, response.Data[0].Date)
+		if cursor = response.Page.NextCursor; cursor == "" {
+			break
+		}
+	}
+	if want := "2025-01-11 2025-01-10"; strings.Join(got, " ") != want {
+		t.Errorf("Pages = %v, want %v", got, want)
+	}
+
+	rr := httptest.NewRecorder()
+	handleFetchExpenses(rr, httptest.NewRequest("GET", "/expenses?user_id=u1&sort=category", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Sort by category = %d, want 400", rr.Code)
+	}
+}
@@ -10,11 +10,14 @@ import (
 	"path/filepath"
 	"time"
 
+	"backend/common/auth"
+
 	_ "github.com/mattn/go-sqlite3"
 )
 
 var (
-	db *sql.DB
+	db       *sql.DB
+	sessions *auth.Manager
 )
 
 type User struct {
@@ -75,9 +78,16 @@ func init() {
 }
 
 func main() {
+	// Every request must carry an access token issued by signin, google_auth or apple-auth
+	var err error
+	sessions, err = auth.NewManagerFromEnv(db)
+	if err != nil {
+		log.Fatalf("Failed to initialize sessions: %v", err)
+	}
+
 	// Set up CORS middleware
-	http.HandleFunc("/user/info", corsMiddleware(handleGetUserInfo))
-	http.HandleFunc("/user/update", corsMiddleware(handleUpdateUser))
+	http.HandleFunc("/user/info", corsMiddleware(sessions.Require(handleGetUserInfo)))
+	http.HandleFunc("/user/update", corsMiddleware(sessions.Require(handleUpdateUser)))
 	http.HandleFunc("/health", corsMiddleware(handleHealth))
 
 	port := 8085
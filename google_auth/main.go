@@ -3,22 +3,30 @@ package main
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
+	"backend/common/auth"
+	"backend/common/identity"
+	"backend/common/oidc"
+
 	"github.com/joho/godotenv"
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
-	"google.golang.org/api/idtoken"
 )
 
 var (
 	googleOauthConfig *oauth2.Config
+	googleVerifier    *oidc.Verifier
+	identities        *identity.Store
 	db                *sql.DB
+	sessions          *auth.Manager
 )
 
 type User struct {
@@ -35,6 +43,13 @@ type User struct {
 	UpdatedAt     time.Time `json:"updated_at"`
 }
 
+// GoogleAuthResponse keeps the user fields at the top level, as before,
+// and adds the session tokens next to them.
+type GoogleAuthResponse struct {
+	User
+	Session *auth.TokenPair `json:"session"`
+}
+
 type ApiResponse struct {
 	Success bool        `json:"success"`
 	Message string      `json:"message,omitempty"`
@@ -70,6 +85,11 @@ func init() {
 		log.Fatal("GOOGLE_CLIENT_SECRET environment variable is required")
 	}
 
+	// ID tokens from the mobile apps are addressed to their own client ids,
+	// listed in GOOGLE_ALLOWED_CLIENT_IDS. GOOGLE_JWKS_URL can point to a
+	// local key set (URL or file) for tests.
+	googleVerifier = oidc.GoogleVerifierFromEnv()
+
 	var err error
 	db, err = sql.Open("sqlite3", "./users.db")
 	if err != nil {
@@ -106,8 +126,20 @@ func getEnvOrDefault(key, defaultValue string) string {
 }
 
 func main() {
+	// Sessions are shared with signin and apple-auth through auth_sessions
+	var err error
+	sessions, err = auth.NewManagerFromEnv(db)
+	if err != nil {
+		log.Fatalf("Failed to initialize sessions: %v", err)
+	}
+
+	identities, err = identity.NewStore(db)
+	if err != nil {
+		log.Fatalf("Failed to initialize identities: %v", err)
+	}
+
 	http.HandleFunc("/auth/google", handleGoogleAuth)
-	http.HandleFunc("/update/locale", handleUpdateLocale)
+	http.HandleFunc("/update/locale", sessions.Require(handleUpdateLocale))
 	http.HandleFunc("/health", handleHealth)
 
 	// Registro de rutas y puertos
@@ -131,31 +163,37 @@ func handleGoogleAuth(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Verify the ID token
-	payload, err := idtoken.Validate(r.Context(), data.IDToken, googleOauthConfig.ClientID)
+	// Verify the ID token: signature, issuer, audience and expiry. Nothing
+	// else in the request is trusted to identify the user.
+	claims, err := googleVerifier.Verify(r.Context(), data.IDToken)
 	if err != nil {
 		log.Printf("Failed to verify ID token: %v", err)
 		http.Error(w, "Invalid token", http.StatusUnauthorized)
 		return
 	}
+	if !claims.EmailVerified {
+		log.Printf("Rejected Google sign-in for %s: email not verified", claims.Subject)
+		http.Error(w, "Google account email is not verified", http.StatusForbidden)
+		return
+	}
 
-	// Extract user information from the verified payload
+	// Extract user information from the verified claims
 	user := User{
-		GoogleID:      payload.Subject,
-		Email:         payload.Claims["email"].(string),
-		Name:          payload.Claims["name"].(string),
-		GivenName:     payload.Claims["given_name"].(string),
-		FamilyName:    payload.Claims["family_name"].(string),
-		Picture:       payload.Claims["picture"].(string),
-		VerifiedEmail: payload.Claims["email_verified"].(bool),
+		GoogleID:      claims.Subject,
+		Email:         claims.Email,
+		Name:          claims.Name,
+		GivenName:     claims.GivenName,
+		FamilyName:    claims.FamilyName,
+		Picture:       claims.Picture,
+		VerifiedEmail: true,
 	}
 
 	// Use device locale if provided, otherwise use Google's locale if available
 	if data.DeviceLocale != "" {
 		user.Locale = data.DeviceLocale
 		log.Printf("Using device locale for user %s: %s", user.Email, user.Locale)
-	} else if locale, ok := payload.Claims["locale"].(string); ok {
-		user.Locale = locale
+	} else if claims.Locale != "" {
+		user.Locale = claims.Locale
 		log.Printf("Using Google-provided locale for user %s: %s", user.Email, user.Locale)
 	} else {
 		// Default locale if none is available
@@ -163,76 +201,112 @@ func handleGoogleAuth(w http.ResponseWriter, r *http.Request) {
 		log.Printf("No locale available, defaulting to en-US for user %s", user.Email)
 	}
 
-	// Debug: Verify the locale is set correctly before database operations
-	log.Printf("Final locale value before DB operations: '%s'", user.Locale)
-
-	// Check if user exists in DB
-	var existingUser User
-	err = db.QueryRow(`
-		SELECT id, email, name, given_name, family_name, picture, locale, verified_email, created_at, updated_at 
-		FROM users WHERE google_id = ?`, user.GoogleID).Scan(
-		&existingUser.ID,
-		&existingUser.Email,
-		&existingUser.Name,
-		&existingUser.GivenName,
-		&existingUser.FamilyName,
-		&existingUser.Picture,
-		&existingUser.Locale,
-		&existingUser.VerifiedEmail,
-		&existingUser.CreatedAt,
-		&existingUser.UpdatedAt,
-	)
-
-	if err == sql.ErrNoRows {
-		// Create new user
-		log.Printf("Creating new user with locale: '%s'", user.Locale)
-		result, err := db.Exec(`
-			INSERT INTO users (
-				google_id, email, name, given_name, family_name, 
-				picture, locale, verified_email
-			) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
-			user.GoogleID, user.Email, user.Name, user.GivenName,
-			user.FamilyName, user.Picture, user.Locale, user.VerifiedEmail,
-		)
-		if err != nil {
-			log.Printf("Failed to create user: %v", err)
-			http.Error(w, "Failed to create user", http.StatusInternalServerError)
+	if err := upsertGoogleUser(&user); err != nil {
+		if isUniqueEmailError(err) {
+			// Never attach a Google identity to an existing account just
+			// because the emails match; the owner has to link it while
+			// signed in.
+			log.Printf("Google sign-in for %s conflicts with an existing account", user.Email)
+			http.Error(w, "An account with this email already exists. Sign in with your password first.", http.StatusConflict)
 			return
 		}
-
-		userID, _ := result.LastInsertId()
-		user.ID = int(userID)
-		log.Printf("Created new user with ID: %d, locale: '%s'", user.ID, user.Locale)
-	} else if err != nil {
-		log.Printf("Database error: %v", err)
+		log.Printf("Failed to save Google user: %v", err)
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
-	} else {
-		// Update existing user
-		log.Printf("Updating existing user with locale: '%s'", user.Locale)
-		_, err = db.Exec(`
-			UPDATE users SET 
-				email = ?, name = ?, given_name = ?, family_name = ?,
-				picture = ?, locale = ?, verified_email = ?, updated_at = CURRENT_TIMESTAMP
-			WHERE google_id = ?`,
-			user.Email, user.Name, user.GivenName, user.FamilyName,
-			user.Picture, user.Locale, user.VerifiedEmail, user.GoogleID,
-		)
-		if err != nil {
-			log.Printf("Failed to update user: %v", err)
-			http.Error(w, "Failed to update user", http.StatusInternalServerError)
-			return
-		}
-		user.ID = existingUser.ID
-		user.CreatedAt = existingUser.CreatedAt
-		log.Printf("Updated user ID: %d, changed locale from '%s' to '%s'", user.ID, existingUser.Locale, user.Locale)
 	}
 
-	// Verify the user's locale one final time before sending response
-	log.Printf("User locale in final response: '%s'", user.Locale)
+	// Open a session for the user
+	tokens, err := sessions.IssueTokens(user.ID, r.UserAgent(), auth.ClientIP(r))
+	if err != nil {
+		log.Printf("Failed to issue session tokens: %v", err)
+		http.Error(w, "Could not start session", http.StatusInternalServerError)
+		return
+	}
+
+	// Return user information along with the session tokens
+	json.NewEncoder(w).Encode(GoogleAuthResponse{User: user, Session: tokens})
+}
+
+// upsertGoogleUser creates or updates the user owning the Google identity
+// user.GoogleID (the token "sub") and fills in its id and creation date.
+func upsertGoogleUser(user *User) error {
+	userID, err := identities.Find(identity.ProviderGoogle, user.GoogleID)
+	if err == identity.ErrNotFound {
+		return createGoogleUser(user)
+	} else if err != nil {
+		return err
+	}
+
+	var previousLocale sql.NullString
+	err = db.QueryRow(`SELECT id, locale, created_at FROM users WHERE id = ?`, userID).Scan(
+		&user.ID,
+		&previousLocale,
+		&user.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	// Update existing user
+	_, err = db.Exec(`
+		UPDATE users SET
+			email = ?, name = ?, given_name = ?, family_name = ?,
+			picture = ?, locale = ?, verified_email = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?`,
+		user.Email, user.Name, user.GivenName, user.FamilyName,
+		user.Picture, user.Locale, user.VerifiedEmail, user.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	if err := identities.Touch(identity.ProviderGoogle, user.GoogleID); err != nil {
+		log.Printf("Failed to update identity: %v", err)
+	}
+	log.Printf("Updated user ID: %d, changed locale from '%s' to '%s'", user.ID, previousLocale.String, user.Locale)
+	return nil
+}
+
+// createGoogleUser inserts the user and its Google identity together.
+func createGoogleUser(user *User) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		INSERT INTO users (
+			google_id, email, name, given_name, family_name,
+			picture, locale, verified_email
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		user.GoogleID, user.Email, user.Name, user.GivenName,
+		user.FamilyName, user.Picture, user.Locale, user.VerifiedEmail,
+	)
+	if err != nil {
+		return err
+	}
+
+	userID, _ := result.LastInsertId()
+	user.ID = int(userID)
+	user.CreatedAt = time.Now()
+
+	if err := identities.LinkTx(tx, user.ID, identity.ProviderGoogle, user.GoogleID, user.Email); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	log.Printf("Created new user with ID: %d, locale: '%s'", user.ID, user.Locale)
+	return nil
+}
 
-	// Return user information
-	json.NewEncoder(w).Encode(user)
+func isUniqueEmailError(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) &&
+		sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique &&
+		strings.Contains(sqliteErr.Error(), "users.email")
 }
 
 func handleHealth(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"backend/common/account"
+	"backend/common/currency"
+	"backend/common/dbtest"
+	"backend/common/journal"
+	"backend/common/ledger"
+)
+
+// writeTables are every table an income write touches
+var writeTables = []string{
+	"incomes", "cash_bank", "cash_bank_transactions", "journal",
+	"daily_cash_bank_balance", "monthly_cash_bank_balance", "monthly_balance", "annual_balance",
+}
+
+// newTestDB points the service at an empty database with one income of
+// 500 cents to the bank already added
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db = dbtest.Open(t)
+	createTablesIfNotExist()
+	var err error
+	balances, err = ledger.New(db)
+	if err != nil {
+		t.Fatalf("Failed to create ledger: %v", err)
+	}
+	if _, err := db.Exec(`CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT)`); err != nil {
+		t.Fatalf("Failed to create users: %v", err)
+	}
+	currencies, err = currency.NewStore(db, "EUR")
+	if err != nil {
+		t.Fatalf("Failed to create currency store: %v", err)
+	}
+	accounts, err = account.NewStore(db, balances)
+	if err != nil {
+		t.Fatalf("Failed to create account store: %v", err)
+	}
+	audit, err = journal.New(db)
+	if err != nil {
+		t.Fatalf("Failed to create journal: %v", err)
+	}
+
+	err = call(handleAddIncome, AddIncomeRequest{UserID: "u1", Amount: 500, Date: "2025-01-10", Category: "salary", PaymentMethod: "bank"})
+	if err != nil {
+		t.Fatalf("Failed to add income: %v", err)
+	}
+	return db
+}
+
+// call runs handler with body and returns an error unless it answered 200
+func call(handler http.HandlerFunc, body interface{}) error {
+	payload, _ := json.Marshal(body)
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest("POST", "/", bytes.NewReader(payload)))
+	if rr.Code != http.StatusOK {
+		return fmt.Errorf("status %d: %s", rr.Code, rr.Body.String())
+	}
+	return nil
+}
+
+func TestAddIncomeIsAtomic(t *testing.T) {
+	failed := dbtest.Atomic(t, writeTables, newTestDB, func(t *testing.T) error {
+		return call(handleAddIncome, AddIncomeRequest{UserID: "u1", Amount: 80, Date: "2025-02-03", Category: "gift", PaymentMethod: "cash"})
+	})
+	assertFailedOn(t, failed, "INSERT incomes", "UPDATE cash_bank", "INSERT cash_bank_transactions", "UPDATE annual_balance", "INSERT journal")
+}
+
+func TestUpdateIncomeIsAtomic(t *testing.T) {
+	failed := dbtest.Atomic(t, writeTables, newTestDB, func(t *testing.T) error {
+		return call(handleUpdateIncome, UpdateIncomeRequest{UserID: "u1", IncomeID: 1, Amount: 450, PaymentMethod: "cash"})
+	})
+	assertFailedOn(t, failed, "UPDATE incomes", "UPDATE cash_bank", "INSERT cash_bank_transactions", "UPDATE annual_balance", "INSERT journal")
+}
+
+func TestDeleteIncomeIsAtomic(t *testing.T) {
+	failed := dbtest.Atomic(t, writeTables, newTestDB, func(t *testing.T) error {
+		return call(handleDeleteIncome, DeleteIncomeRequest{UserID: "u1", IncomeID: 1})
+	})
+	assertFailedOn(t, failed, "DELETE incomes", "UPDATE cash_bank", "UPDATE annual_balance", "INSERT journal")
+}
+
+func TestIncomeCurrencyChangeIsConvertedAgain(t *testing.T) {
+	newTestDB(t)
+	if err := currencies.SetRates(currency.Rate{From: "GBP", To: "EUR", Date: "2025-01-01", Rate: 1.2}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := call(handleUpdateIncome, UpdateIncomeRequest{UserID: "u1", IncomeID: 1, Currency: "GBP"}); err != nil {
+		t.Fatalf("Failed to update income: %v", err)
+	}
+	var code string
+	var base, total int64
+	db.QueryRow(`SELECT currency, base_amount FROM incomes WHERE id = 1`).Scan(&code, &base)
+	db.QueryRow(`SELECT total_balance FROM monthly_cash_bank_balance WHERE user_id = 'u1' AND year_month = '2025-01'`).Scan(&total)
+	if code != "GBP" || base != 600 || total != 600 {
+		t.Errorf("Income in %s stored as %d, January total %d", code, base, total)
+	}
+}
+
+func TestIncomeGoesToItsAccount(t *testing.T) {
+	newTestDB(t)
+	wallet, err := accounts.Create(account.Account{UserID: "u1", Name: "Revolut", Type: account.Wallet, OpeningDate: "2025-01-01"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := call(handleAddIncome, AddIncomeRequest{UserID: "u1", Amount: 300, Date: "2025-01-12", Category: "gift", AccountID: wallet.ID}); err != nil {
+		t.Fatalf("Failed to add income: %v", err)
+	}
+	balanceOf := func(id int64) int64 {
+		var balance int64
+		db.QueryRow(`SELECT balance FROM monthly_account_balance WHERE account_id = ? AND year_month = '2025-01'`, id).Scan(&balance)
+		return balance
+	}
+	var method string
+	db.QueryRow(`SELECT payment_method FROM incomes WHERE id = 2`).Scan(&method)
+	if method != "bank" || balanceOf(wallet.ID) != 300 {
+		t.Errorf("Income in the wallet has method %q, wallet balance %d", method, balanceOf(wallet.ID))
+	}
+
+	// A legacy payment method moves it to the user's cash account
+	if err := call(handleUpdateIncome, UpdateIncomeRequest{UserID: "u1", IncomeID: 2, PaymentMethod: "cash"}); err != nil {
+		t.Fatalf("Failed to update income: %v", err)
+	}
+	var cash int64
+	db.QueryRow(`SELECT account_id FROM incomes WHERE id = 2`).Scan(&cash)
+	if balanceOf(wallet.ID) != 0 || balanceOf(cash) != 300 {
+		t.Errorf("After moving: wallet %d, cash account %d holds %d", balanceOf(wallet.ID), cash, balanceOf(cash))
+	}
+
+	if err := call(handleAddIncome, AddIncomeRequest{UserID: "u1", Amount: 10, Category: "gift", AccountID: 999}); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("Income to a missing account: %v, want 404", err)
+	}
+	if err := call(handleAddIncome, AddIncomeRequest{UserID: "u1", Amount: 10, Category: "gift", PaymentMethod: "card"}); err == nil || !strings.Contains(err.Error(), "400") {
+		t.Errorf("Income with an unknown payment method: %v, want 400", err)
+	}
+}
+
+// assertFailedOn checks that each fault point made the write fail, i.e.
+// that the write really goes through it
+func assertFailedOn(t *testing.T, failed []string, points ...string) {
+	t.Helper()
+
+	hit := map[string]bool{}
+	for _, point := range failed {
+		hit[point] = true
+	}
+	for _, point := range points {
+		if !hit[point] {
+			t.Errorf("Expected a fault on %s to make the write fail", point)
+		}
+	}
+}
@@ -10,6 +10,13 @@ import (
 	"path/filepath"
 	"time"
 
+	"backend/common/account"
+	"backend/common/auth"
+	"backend/common/currency"
+	"backend/common/ledger"
+	"backend/common/money"
+	"backend/common/reconcile"
+
 	_ "github.com/mattn/go-sqlite3"
 )
 
@@ -26,29 +33,38 @@ type ApiResponse struct {
 }
 
 type BudgetData struct {
-	UserID          string  `json:"user_id"`
-	Period          string  `json:"period"`
-	Date            string  `json:"date"`
-	TotalAmount     float64 `json:"total_amount"`
-	RemainingAmount float64 `json:"remaining_amount"`
-	SpentAmount     float64 `json:"spent_amount"`
-	UpcomingAmount  float64 `json:"upcoming_amount"`
-	FromPrevious    float64 `json:"from_previous"`
-	Percent         float64 `json:"percent"`
-	TotalIncome     float64 `json:"total_income"`
+	UserID          string      `json:"user_id"`
+	Period          string      `json:"period"`
+	Date            string      `json:"date"`
+	TotalAmount     money.Money `json:"total_amount"`
+	RemainingAmount money.Money `json:"remaining_amount"`
+	SpentAmount     money.Money `json:"spent_amount"`
+	UpcomingAmount  money.Money `json:"upcoming_amount"`
+	FromPrevious    money.Money `json:"from_previous"`
+	Percent         float64     `json:"percent"`
+	TotalIncome     money.Money `json:"total_income"`
 }
 
 type Bill struct {
-	Amount    float64 `json:"amount"`
-	DueDate   string  `json:"due_date"`
-	Paid      bool    `json:"paid"`
-	Recurring bool    `json:"recurring"`
+	Amount    money.Money `json:"amount"`
+	DueDate   string      `json:"due_date"`
+	Paid      bool        `json:"paid"`
+	Recurring bool        `json:"recurring"`
 }
 
 var (
-	db *sql.DB
+	db       *sql.DB
+	sessions *auth.Manager
 )
 
+// querier is what *sql.DB and *sql.Tx have in common, so that a sync can
+// run inside a reconciliation.
+type querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
 func init() {
 	var err error
 
@@ -77,9 +93,45 @@ func init() {
 }
 
 func main() {
+	// Amounts are stored as cents; tables created with REAL amounts are converted
+	if err := money.Migrate(db); err != nil {
+		log.Fatalf("Failed to migrate amounts to cents: %v", err)
+	}
+
+	// Totals add up base amounts; this adds them to tables that predate currencies
+	if _, err := currency.NewStoreFromEnv(db); err != nil {
+		log.Fatalf("Failed to initialize currencies: %v", err)
+	}
+
+	// Balances derived from the transactions can be rebuilt when they drift
+	balances, err := ledger.New(db)
+	if err != nil {
+		log.Fatalf("Failed to initialize balance ledger: %v", err)
+	}
+	accounts, err := account.NewStore(db, balances)
+	if err != nil {
+		log.Fatalf("Failed to initialize accounts: %v", err)
+	}
+	reconciler := reconcile.New(db, balances, accounts, reconcileBudget)
+
+	// go run . reconcile [-user ID] [-dry-run] reconciles and exits
+	if len(os.Args) > 1 && os.Args[1] == "reconcile" {
+		if err := reconciler.Command(os.Args[2:], os.Stdout); err != nil {
+			log.Fatalf("Reconciliation failed: %v", err)
+		}
+		return
+	}
+
+	// Every request must carry an access token issued by signin, google_auth or apple-auth
+	sessions, err = auth.NewManagerFromEnv(db)
+	if err != nil {
+		log.Fatalf("Failed to initialize sessions: %v", err)
+	}
+
 	// Set up CORS middleware and routes
-	http.HandleFunc("/money-flow/sync", corsMiddleware(handleSyncMoneyFlow))
-	http.HandleFunc("/money-flow/data", corsMiddleware(handleGetMoneyFlowData))
+	http.HandleFunc("/money-flow/sync", corsMiddleware(sessions.Require(handleSyncMoneyFlow)))
+	http.HandleFunc("/money-flow/data", corsMiddleware(sessions.Require(handleGetMoneyFlowData)))
+	http.HandleFunc("/admin/reconcile", corsMiddleware(auth.RequireAdmin(reconciler.Handler())))
 
 	port := 8097 // Puerto para el servicio de sincronización de money flow
 	log.Printf("Money Flow Sync service started on :%d", port)
@@ -129,7 +181,7 @@ func handleSyncMoneyFlow(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Sync money flow data
-	budget, err := syncMoneyFlow(syncRequest.UserID, syncRequest.Period)
+	budget, err := syncMoneyFlow(db, syncRequest.UserID, syncRequest.Period)
 	if err != nil {
 		log.Printf("Error syncing money flow: %v", err)
 		sendErrorResponse(w, "Error syncing money flow", http.StatusInternalServerError)
@@ -162,7 +214,7 @@ func handleGetMoneyFlowData(w http.ResponseWriter, r *http.Request) {
 	log.Printf("Getting money flow data for user %s with period %s", userID, period)
 
 	// Get money flow data
-	budget, err := syncMoneyFlow(userID, period)
+	budget, err := syncMoneyFlow(db, userID, period)
 	if err != nil {
 		log.Printf("Error getting money flow data: %v", err)
 		sendErrorResponse(w, "Error getting money flow data", http.StatusInternalServerError)
@@ -173,7 +225,7 @@ func handleGetMoneyFlowData(w http.ResponseWriter, r *http.Request) {
 	sendSuccessResponse(w, "Money flow data retrieved successfully", budget)
 }
 
-func syncMoneyFlow(userID, period string) (*BudgetData, error) {
+func syncMoneyFlow(q querier, userID, period string) (*BudgetData, error) {
 	log.Printf("Syncing money flow for user %s with period %s", userID, period)
 
 	// Get date range for the period
@@ -181,30 +233,30 @@ func syncMoneyFlow(userID, period string) (*BudgetData, error) {
 	log.Printf("Date range: %s to %s", startDate, endDate)
 
 	// Get remaining amount from previous period
-	previousPeriod, fromPrevious := getPreviousPeriodData(userID, period)
-	log.Printf("Previous period: %s, fromPrevious: %.2f", previousPeriod, fromPrevious)
+	previousPeriod, fromPrevious := getPreviousPeriodData(q, userID, period)
+	log.Printf("Previous period: %s, fromPrevious: %s", previousPeriod, fromPrevious)
 
 	// Get total income for the period
-	totalIncome, err := getTotalIncomeForPeriod(userID, startDate, endDate)
+	totalIncome, err := getTotalIncomeForPeriod(q, userID, startDate, endDate)
 	if err != nil {
 		return nil, fmt.Errorf("error getting total income: %v", err)
 	}
-	log.Printf("Total income: %.2f", totalIncome)
+	log.Printf("Total income: %s", totalIncome)
 
 	// Get spent amount for the period
-	spentAmount, err := getSpentAmountForPeriod(userID, startDate, endDate)
+	spentAmount, err := getSpentAmountForPeriod(q, userID, startDate, endDate)
 	if err != nil {
 		return nil, fmt.Errorf("error getting spent amount: %v", err)
 	}
-	log.Printf("Spent amount: %.2f", spentAmount)
+	log.Printf("Spent amount: %s", spentAmount)
 
 	// Get upcoming bills amount
-	upcomingAmount, err := getUpcomingBillsAmount(userID, startDate, endDate)
+	upcomingAmount, err := getUpcomingBillsAmount(q, userID, startDate, endDate)
 	if err != nil {
 		log.Printf("Error getting upcoming bills amount: %v", err)
 		return nil, fmt.Errorf("error getting upcoming bills amount: %v", err)
 	}
-	log.Printf("Upcoming amount: %.2f", upcomingAmount)
+	log.Printf("Upcoming amount: %s", upcomingAmount)
 
 	// Calculate total and remaining amounts
 	totalAmount := fromPrevious + totalIncome
@@ -213,10 +265,10 @@ func syncMoneyFlow(userID, period string) (*BudgetData, error) {
 	// Calculate percent
 	var percent float64
 	if totalAmount > 0 {
-		percent = ((spentAmount + upcomingAmount) / totalAmount) * 100
+		percent = ((spentAmount + upcomingAmount).Float64() / totalAmount.Float64()) * 100
 	}
 
-	log.Printf("Total amount: %.2f, Remaining amount: %.2f, Percent: %.2f", totalAmount, remainingAmount, percent)
+	log.Printf("Total amount: %s, Remaining amount: %s, Percent: %.2f", totalAmount, remainingAmount, percent)
 
 	// Create budget data
 	budget := &BudgetData{
@@ -233,13 +285,13 @@ func syncMoneyFlow(userID, period string) (*BudgetData, error) {
 	}
 
 	// Update budget record in database
-	err = updateBudgetData(budget)
+	err = updateBudgetData(q, budget)
 	if err != nil {
 		return nil, fmt.Errorf("error updating budget data: %v", err)
 	}
 
 	// Update finance metrics
-	err = updateFinanceMetrics(userID, period, totalIncome, spentAmount, upcomingAmount)
+	err = updateFinanceMetrics(q, userID, period, totalIncome, spentAmount, upcomingAmount)
 	if err != nil {
 		log.Printf("Warning: error updating finance metrics: %v", err)
 		// Don't fail the entire operation if updating finance metrics fails
@@ -292,7 +344,7 @@ func getDateRangeForPeriod(period string) (string, string) {
 	}
 }
 
-func getPreviousPeriodData(userID, currentPeriod string) (string, float64) {
+func getPreviousPeriodData(q querier, userID, currentPeriod string) (string, money.Money) {
 	// Para el cálculo del flujo de dinero, necesitamos el previous_amount del MES ACTUAL
 	// no del mes anterior. Esto es porque previous_amount ya contiene el balance heredado.
 
@@ -313,8 +365,8 @@ func getPreviousPeriodData(userID, currentPeriod string) (string, float64) {
 			WHERE user_id = ? AND year_month = ?
 		`
 
-		var totalPrevious float64
-		err := db.QueryRow(query, userID, currentYearMonth).Scan(&totalPrevious)
+		var totalPrevious money.Money
+		err := q.QueryRow(query, userID, currentYearMonth).Scan(&totalPrevious)
 
 		if err != nil {
 			if err == sql.ErrNoRows {
@@ -326,7 +378,7 @@ func getPreviousPeriodData(userID, currentPeriod string) (string, float64) {
 			return "monthly", 0
 		}
 
-		log.Printf("📊 Found previous amounts for %s: total_previous=%.2f", currentYearMonth, totalPrevious)
+		log.Printf("📊 Found previous amounts for %s: total_previous=%s", currentYearMonth, totalPrevious)
 		return "monthly", totalPrevious
 
 	case "daily":
@@ -342,8 +394,8 @@ func getPreviousPeriodData(userID, currentPeriod string) (string, float64) {
 			WHERE user_id = ? AND year_month = ?
 		`
 
-		var totalPrevious float64
-		err := db.QueryRow(query, userID, currentYearMonth).Scan(&totalPrevious)
+		var totalPrevious money.Money
+		err := q.QueryRow(query, userID, currentYearMonth).Scan(&totalPrevious)
 
 		if err != nil {
 			if err == sql.ErrNoRows {
@@ -369,8 +421,8 @@ func getPreviousPeriodData(userID, currentPeriod string) (string, float64) {
 			WHERE user_id = ? AND year_month = ?
 		`
 
-		var totalPrevious float64
-		err := db.QueryRow(query, userID, currentYearMonth).Scan(&totalPrevious)
+		var totalPrevious money.Money
+		err := q.QueryRow(query, userID, currentYearMonth).Scan(&totalPrevious)
 
 		if err != nil {
 			if err == sql.ErrNoRows {
@@ -390,15 +442,15 @@ func getPreviousPeriodData(userID, currentPeriod string) (string, float64) {
 	}
 }
 
-func getTotalIncomeForPeriod(userID, startDate, endDate string) (float64, error) {
+func getTotalIncomeForPeriod(q querier, userID, startDate, endDate string) (money.Money, error) {
 	query := `
-		SELECT COALESCE(SUM(amount), 0)
+		SELECT COALESCE(SUM(COALESCE(base_amount, amount)), 0)
 		FROM incomes
 		WHERE user_id = ? AND date BETWEEN ? AND ?
 	`
 
-	var totalIncome float64
-	err := db.QueryRow(query, userID, startDate, endDate).Scan(&totalIncome)
+	var totalIncome money.Money
+	err := q.QueryRow(query, userID, startDate, endDate).Scan(&totalIncome)
 	if err != nil {
 		return 0, err
 	}
@@ -406,15 +458,15 @@ func getTotalIncomeForPeriod(userID, startDate, endDate string) (float64, error)
 	return totalIncome, nil
 }
 
-func getSpentAmountForPeriod(userID, startDate, endDate string) (float64, error) {
+func getSpentAmountForPeriod(q querier, userID, startDate, endDate string) (money.Money, error) {
 	query := `
-		SELECT COALESCE(SUM(amount), 0)
+		SELECT COALESCE(SUM(COALESCE(base_amount, amount)), 0)
 		FROM expenses
 		WHERE user_id = ? AND date BETWEEN ? AND ?
 	`
 
-	var spentAmount float64
-	err := db.QueryRow(query, userID, startDate, endDate).Scan(&spentAmount)
+	var spentAmount money.Money
+	err := q.QueryRow(query, userID, startDate, endDate).Scan(&spentAmount)
 	if err != nil {
 		return 0, err
 	}
@@ -422,7 +474,7 @@ func getSpentAmountForPeriod(userID, startDate, endDate string) (float64, error)
 	return spentAmount, nil
 }
 
-func getUpcomingBillsAmount(userID, startDate, endDate string) (float64, error) {
+func getUpcomingBillsAmount(q querier, userID, startDate, endDate string) (money.Money, error) {
 	// Para calcular las facturas pendientes, necesitamos consultar la tabla bill_payments
 	// y obtener las facturas que NO han sido pagadas en el período actual
 
@@ -437,7 +489,7 @@ func getUpcomingBillsAmount(userID, startDate, endDate string) (float64, error)
 
 	// Consultar bill_payments para obtener facturas NO pagadas del mes actual
 	query := `
-		SELECT COALESCE(SUM(b.amount), 0)
+		SELECT COALESCE(SUM(COALESCE(b.base_amount, b.amount)), 0)
 		FROM bills b
 		INNER JOIN bill_payments bp ON b.id = bp.bill_id
 		WHERE b.user_id = ? 
@@ -445,33 +497,33 @@ func getUpcomingBillsAmount(userID, startDate, endDate string) (float64, error)
 		AND bp.paid = 0
 	`
 
-	var upcomingAmount float64
-	err = db.QueryRow(query, userID, yearMonth).Scan(&upcomingAmount)
+	var upcomingAmount money.Money
+	err = q.QueryRow(query, userID, yearMonth).Scan(&upcomingAmount)
 	if err != nil {
 		log.Printf("Error getting upcoming bills amount from bill_payments: %v", err)
 		// Fallback a la lógica original si falla la nueva consulta
-		return getUpcomingBillsAmountFallback(userID, startDate, endDate)
+		return getUpcomingBillsAmountFallback(q, userID, startDate, endDate)
 	}
 
-	log.Printf("📋 Found upcoming bills for %s: amount=%.2f", yearMonth, upcomingAmount)
+	log.Printf("📋 Found upcoming bills for %s: amount=%s", yearMonth, upcomingAmount)
 	return upcomingAmount, nil
 }
 
 // Función de fallback para mantener compatibilidad con la lógica original
-func getUpcomingBillsAmountFallback(userID, startDate, endDate string) (float64, error) {
+func getUpcomingBillsAmountFallback(q querier, userID, startDate, endDate string) (money.Money, error) {
 	query := `
-		SELECT amount, due_date, paid, recurring
+		SELECT COALESCE(base_amount, amount), due_date, paid, recurring
 		FROM bills
 		WHERE user_id = ? AND due_date BETWEEN ? AND ? AND paid = 0
 	`
 
-	rows, err := db.Query(query, userID, startDate, endDate)
+	rows, err := q.Query(query, userID, startDate, endDate)
 	if err != nil {
 		return 0, err
 	}
 	defer rows.Close()
 
-	var upcomingAmount float64
+	var upcomingAmount money.Money
 	for rows.Next() {
 		var bill Bill
 		err := rows.Scan(&bill.Amount, &bill.DueDate, &bill.Paid, &bill.Recurring)
@@ -490,14 +542,14 @@ func getUpcomingBillsAmountFallback(userID, startDate, endDate string) (float64,
 		return 0, err
 	}
 
-	log.Printf("📋 Fallback upcoming bills: amount=%.2f", upcomingAmount)
+	log.Printf("📋 Fallback upcoming bills: amount=%s", upcomingAmount)
 	return upcomingAmount, nil
 }
 
-func updateBudgetData(budget *BudgetData) error {
+func updateBudgetData(q querier, budget *BudgetData) error {
 	// Check if a budget entry already exists for this user and period
 	var count int
-	err := db.QueryRow(`
+	err := q.QueryRow(`
 		SELECT COUNT(*) 
 		FROM budget 
 		WHERE user_id = ? AND period = ?
@@ -508,7 +560,7 @@ func updateBudgetData(budget *BudgetData) error {
 
 	if count > 0 {
 		// Update existing budget entry
-		_, err = db.Exec(`
+		_, err = q.Exec(`
 			UPDATE budget
 			SET total_amount = ?,
 				remaining_amount = ?,
@@ -534,7 +586,7 @@ func updateBudgetData(budget *BudgetData) error {
 		)
 	} else {
 		// Insert new budget entry
-		_, err = db.Exec(`
+		_, err = q.Exec(`
 			INSERT INTO budget (
 				user_id, period, date, total_amount, remaining_amount, 
 				spent_amount, upcoming_amount, from_previous, percent, total_income
@@ -556,10 +608,10 @@ func updateBudgetData(budget *BudgetData) error {
 	return err
 }
 
-func updateFinanceMetrics(userID, period string, income, expenses, bills float64) error {
+func updateFinanceMetrics(q querier, userID, period string, income, expenses, bills money.Money) error {
 	// Check if a finance metrics entry already exists for this user and period
 	var count int
-	err := db.QueryRow(`
+	err := q.QueryRow(`
 		SELECT COUNT(*) 
 		FROM finance_metrics 
 		WHERE user_id = ? AND period = ?
@@ -570,7 +622,7 @@ func updateFinanceMetrics(userID, period string, income, expenses, bills float64
 
 	if count > 0 {
 		// Update existing entry
-		_, err = db.Exec(`
+		_, err = q.Exec(`
 			UPDATE finance_metrics
 			SET income = ?,
 				expenses = ?,
@@ -586,7 +638,7 @@ func updateFinanceMetrics(userID, period string, income, expenses, bills float64
 		)
 	} else {
 		// Insert new entry
-		_, err = db.Exec(`
+		_, err = q.Exec(`
 			INSERT INTO finance_metrics (
 				user_id, period, income, expenses, bills
 			) VALUES (?, ?, ?, ?, ?)
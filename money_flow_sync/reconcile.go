@@ -0,0 +1,68 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+
+	"backend/common/money"
+	"backend/common/reconcile"
+)
+
+// reconcileBudget recomputes the budget and finance_metrics rows of every
+// period the user has synced, once the ledger has been rebuilt, and
+// reports the amounts that changed.
+func reconcileBudget(tx *sql.Tx, userID string) ([]reconcile.Diff, error) {
+	rows, err := tx.Query(`
+		SELECT period, total_amount, remaining_amount, spent_amount, upcoming_amount, from_previous, total_income
+		FROM budget WHERE user_id = ? ORDER BY period`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error reading budget: %v", err)
+	}
+	var synced []BudgetData
+	for rows.Next() {
+		var b BudgetData
+		if err := rows.Scan(&b.Period, &b.TotalAmount, &b.RemainingAmount, &b.SpentAmount, &b.UpcomingAmount,
+			&b.FromPrevious, &b.TotalIncome); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("error reading budget: %v", err)
+		}
+		synced = append(synced, b)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading budget: %v", err)
+	}
+
+	var diffs []reconcile.Diff
+	for _, old := range synced {
+		var oldMetrics [3]money.Money
+		err := tx.QueryRow(`SELECT income, expenses, bills FROM finance_metrics WHERE user_id = ? AND period = ?`,
+			userID, old.Period).Scan(&oldMetrics[0], &oldMetrics[1], &oldMetrics[2])
+		if err != nil && err != sql.ErrNoRows {
+			return nil, fmt.Errorf("error reading finance metrics: %v", err)
+		}
+
+		budget, err := syncMoneyFlow(tx, userID, old.Period)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, d := range []reconcile.Diff{
+			{Table: "budget", Column: "total_amount", Before: old.TotalAmount, After: budget.TotalAmount},
+			{Table: "budget", Column: "remaining_amount", Before: old.RemainingAmount, After: budget.RemainingAmount},
+			{Table: "budget", Column: "spent_amount", Before: old.SpentAmount, After: budget.SpentAmount},
+			{Table: "budget", Column: "upcoming_amount", Before: old.UpcomingAmount, After: budget.UpcomingAmount},
+			{Table: "budget", Column: "from_previous", Before: old.FromPrevious, After: budget.FromPrevious},
+			{Table: "budget", Column: "total_income", Before: old.TotalIncome, After: budget.TotalIncome},
+			{Table: "finance_metrics", Column: "income", Before: oldMetrics[0], After: budget.TotalIncome},
+			{Table: "finance_metrics", Column: "expenses", Before: oldMetrics[1], After: budget.SpentAmount},
+			{Table: "finance_metrics", Column: "bills", Before: oldMetrics[2], After: budget.UpcomingAmount},
+		} {
+			if d.Before != d.After {
+				d.Key = old.Period
+				diffs = append(diffs, d)
+			}
+		}
+	}
+	return diffs, nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"backend/common/currency"
+)
+
+type BaseCurrencyRequest struct {
+	Currency string `json:"currency"`
+}
+
+type BaseCurrencyResponse struct {
+	Currency string `json:"currency"`
+}
+
+// handleBaseCurrency reads (GET) or changes (POST) the currency the user's
+// balances are kept in. It can only change before any money is recorded.
+func handleBaseCurrency(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := sessionUser(w, r)
+	if !ok {
+		return
+	}
+
+	if r.Method == "POST" {
+		var req BaseCurrencyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if err := currencies.SetBase(strconv.Itoa(userID), req.Currency); err != nil {
+			sendCurrencyError(w, err)
+			return
+		}
+	}
+
+	code, err := currencies.Base(strconv.Itoa(userID))
+	if err != nil {
+		sendCurrencyError(w, err)
+		return
+	}
+	sendJSONResponse(w, http.StatusOK, ApiResponse{Success: true, Data: BaseCurrencyResponse{Currency: code}})
+}
+
+func sendCurrencyError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, currency.ErrInvalidCode):
+		sendJSONResponse(w, http.StatusBadRequest, ApiResponse{Success: false, Message: err.Error()})
+	case errors.Is(err, currency.ErrBaseInUse):
+		sendJSONResponse(w, http.StatusConflict, ApiResponse{Success: false, Message: err.Error()})
+	default:
+		log.Printf("Currency error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+	}
+}
@@ -3,6 +3,11 @@ module backend/profile_management
 go 1.21
 
 require (
+	backend/common v0.0.0
 	github.com/mattn/go-sqlite3 v1.14.27
 	github.com/nfnt/resize v0.0.0-20180221191011-83c6a9932646
 )
+
+require golang.org/x/crypto v0.33.0 // indirect
+
+replace backend/common => ../common
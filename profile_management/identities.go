@@ -0,0 +1,240 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"backend/common/identity"
+	"backend/common/oidc"
+	"backend/common/password"
+)
+
+// LinkIdentityRequest adds a login method. The new credential goes in
+// IDToken (google, apple) or Password (password). The user must also prove
+// who they are again with CurrentPassword or with a fresh ID token of an
+// already linked provider (ReauthProvider + ReauthIDToken).
+type LinkIdentityRequest struct {
+	Provider        string `json:"provider"`
+	IDToken         string `json:"id_token,omitempty"`
+	Password        string `json:"password,omitempty"`
+	CurrentPassword string `json:"current_password,omitempty"`
+	ReauthProvider  string `json:"reauth_provider,omitempty"`
+	ReauthIDToken   string `json:"reauth_id_token,omitempty"`
+}
+
+type UnlinkIdentityRequest struct {
+	Provider string `json:"provider"`
+}
+
+var (
+	errReauthFailed     = errors.New("re-authentication failed")
+	errPasswordRequired = errors.New("password is required")
+)
+
+// handleListIdentities returns the login methods of the signed-in user.
+func handleListIdentities(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := sessionUser(w, r)
+	if !ok {
+		return
+	}
+
+	list, err := identities.List(userID)
+	if err != nil {
+		sendIdentityError(w, err)
+		return
+	}
+	sendJSONResponse(w, http.StatusOK, ApiResponse{Success: true, Data: list})
+}
+
+// handleLinkIdentity attaches a Google, Apple or email/password login
+// method to the signed-in user after re-authenticating them.
+func handleLinkIdentity(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := sessionUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req LinkIdentityRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Guessing the current password here counts against the sign-in lockout
+	if req.CurrentPassword != "" && !checkLoginAllowed(w, r, userID) {
+		return
+	}
+	if err := reauthenticate(r, userID, req); err != nil {
+		sendIdentityError(w, err)
+		return
+	}
+
+	var err error
+	switch req.Provider {
+	case identity.ProviderGoogle, identity.ProviderApple:
+		err = linkSocialIdentity(r, userID, req)
+	case identity.ProviderPassword:
+		err = linkPasswordIdentity(userID, req.Password)
+	default:
+		err = identity.ErrUnknownProvider
+	}
+	if err != nil {
+		sendIdentityError(w, err)
+		return
+	}
+
+	log.Printf("Linked %s login to user ID: %d", req.Provider, userID)
+	sendJSONResponse(w, http.StatusOK, ApiResponse{Success: true, Message: "Login method linked"})
+}
+
+// handleUnlinkIdentity removes a login method; the last one is kept.
+func handleUnlinkIdentity(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := sessionUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req UnlinkIdentityRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Provider == "" {
+		http.Error(w, "Provider is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := identities.Unlink(userID, req.Provider); err != nil {
+		sendIdentityError(w, err)
+		return
+	}
+
+	log.Printf("Unlinked %s login from user ID: %d", req.Provider, userID)
+	sendJSONResponse(w, http.StatusOK, ApiResponse{Success: true, Message: "Login method removed"})
+}
+
+// reauthenticate checks the current password or a fresh ID token of an
+// identity already linked to userID. Password checks are recorded like
+// sign-ins; the caller checks the lockout first.
+func reauthenticate(r *http.Request, userID int, req LinkIdentityRequest) error {
+	if req.CurrentPassword != "" {
+		var stored sql.NullString
+		if err := db.QueryRow("SELECT password FROM users WHERE id = ?", userID).Scan(&stored); err != nil {
+			return err
+		}
+		if matched, _ := password.Verify(stored.String, req.CurrentPassword); !matched || stored.String == "" {
+			recordLoginAttempt(r, userID, false, "bad_password")
+			return errReauthFailed
+		}
+		recordLoginAttempt(r, userID, true, "")
+		return nil
+	}
+
+	if req.ReauthIDToken == "" {
+		return errReauthFailed
+	}
+	verifier := verifierFor(req.ReauthProvider)
+	if verifier == nil {
+		return errReauthFailed
+	}
+	claims, err := verifier.Verify(r.Context(), req.ReauthIDToken)
+	if err != nil {
+		log.Printf("Re-authentication token rejected: %v", err)
+		return errReauthFailed
+	}
+	owner, err := identities.Find(req.ReauthProvider, claims.Subject)
+	if err == identity.ErrNotFound || (err == nil && owner != userID) {
+		return errReauthFailed
+	}
+	return err
+}
+
+func linkSocialIdentity(r *http.Request, userID int, req LinkIdentityRequest) error {
+	claims, err := verifierFor(req.Provider).Verify(r.Context(), req.IDToken)
+	if err != nil {
+		log.Printf("Identity token rejected: %v", err)
+		return oidc.ErrInvalidToken
+	}
+	// Apple omits email_verified for private relay addresses it owns
+	if req.Provider == identity.ProviderGoogle && !claims.EmailVerified {
+		return oidc.ErrInvalidToken
+	}
+	return identities.Link(userID, req.Provider, claims.Subject, claims.Email)
+}
+
+func linkPasswordIdentity(userID int, plain string) error {
+	if plain == "" {
+		return errPasswordRequired
+	}
+	hashed, err := password.Hash(plain)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var email string
+	if err := tx.QueryRow("SELECT email FROM users WHERE id = ?", userID).Scan(&email); err != nil {
+		return err
+	}
+	// LinkTx refuses before the hash is stored if a password already exists
+	if err := identities.LinkTx(tx, userID, identity.ProviderPassword, identity.PasswordSubject(userID), email); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE users SET password = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", hashed, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func verifierFor(provider string) *oidc.Verifier {
+	switch provider {
+	case identity.ProviderGoogle:
+		return googleVerifier
+	case identity.ProviderApple:
+		return appleVerifier
+	}
+	return nil
+}
+
+func sendIdentityError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	message := err.Error()
+	switch {
+	case err == errReauthFailed:
+		status = http.StatusUnauthorized
+		message = "Please confirm your current password or sign in again with a linked account"
+	case errors.Is(err, oidc.ErrInvalidToken):
+		status = http.StatusUnauthorized
+		message = "Invalid identity token"
+	case err == identity.ErrUnknownProvider, err == errPasswordRequired:
+		status = http.StatusBadRequest
+	case err == identity.ErrNotFound:
+		status = http.StatusNotFound
+		message = "Login method not linked"
+	case err == identity.ErrLinkedElsewhere, err == identity.ErrAlreadyLinked, err == identity.ErrLastIdentity:
+		status = http.StatusConflict
+	default:
+		log.Printf("Identity error: %v", err)
+		message = "Database error"
+	}
+	sendJSONResponse(w, status, ApiResponse{Success: false, Message: message})
+}
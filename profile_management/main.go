@@ -17,6 +17,19 @@ import (
 	"strings"
 	"time"
 
+	"backend/common/account"
+	"backend/common/auth"
+	"backend/common/currency"
+	"backend/common/export"
+	"backend/common/identity"
+	"backend/common/ledger"
+	"backend/common/oidc"
+	"backend/common/password"
+	"backend/common/reconcile"
+	"backend/common/tag"
+	"backend/common/throttle"
+	"backend/common/twofactor"
+
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/nfnt/resize"
 )
@@ -64,7 +77,18 @@ type ApiResponse struct {
 }
 
 var (
-	db *sql.DB
+	db        *sql.DB
+	sessions  *auth.Manager
+	twoFactor *twofactor.Store
+	guard     *throttle.Guard
+
+	currencies *currency.Store
+	exports    *export.Store
+	restorer   *export.Restorer
+
+	identities     *identity.Store
+	googleVerifier *oidc.Verifier
+	appleVerifier  *oidc.Verifier
 )
 
 func init() {
@@ -95,15 +119,83 @@ func init() {
 }
 
 func main() {
+	// Every request must carry an access token issued by signin, google_auth or apple-auth
+	var err error
+	sessions, err = auth.NewManagerFromEnv(db)
+	if err != nil {
+		log.Fatalf("Failed to initialize sessions: %v", err)
+	}
+
+	twoFactor, err = twofactor.NewStore(db)
+	if err != nil {
+		log.Fatalf("Failed to initialize two-factor authentication: %v", err)
+	}
+
+	// Codes checked here share the sign-in lockout of the account
+	guard, err = throttle.NewGuard(db, throttle.PolicyFromEnv())
+	if err != nil {
+		log.Fatalf("Failed to initialize login throttling: %v", err)
+	}
+
+	// Linking Google or Apple verifies their ID tokens like google_auth does
+	identities, err = identity.NewStore(db)
+	if err != nil {
+		log.Fatalf("Failed to initialize identities: %v", err)
+	}
+	googleVerifier = oidc.GoogleVerifierFromEnv()
+	appleVerifier = oidc.AppleVerifierFromEnv()
+
+	// Base currencies and the exchange rates every service converts with
+	currencies, err = currency.NewStoreFromEnv(db)
+	if err != nil {
+		log.Fatalf("Failed to initialize currencies: %v", err)
+	}
+
+	// Users can download a copy of their data, e.g. before deleting their account
+	exports, err = export.NewFromEnv(db)
+	if err != nil {
+		log.Fatalf("Failed to initialize exports: %v", err)
+	}
+
+	// Deleting an account deletes its tags, so their tables must exist
+	if _, err := tag.New(db); err != nil {
+		log.Fatalf("Failed to initialize tags: %v", err)
+	}
+
+	// Restoring a bundle rebuilds the balances derived from the restored rows
+	balances, err := ledger.New(db)
+	if err != nil {
+		log.Fatalf("Failed to initialize balance ledger: %v", err)
+	}
+	accounts, err := account.NewStore(db, balances)
+	if err != nil {
+		log.Fatalf("Failed to initialize accounts: %v", err)
+	}
+	restorer = &export.Restorer{DB: db, Balances: balances, Accounts: accounts,
+		Reconciler: reconcile.New(db, balances, accounts)}
+
 	// Set up CORS middleware
-	http.HandleFunc("/profile/update", corsMiddleware(handleProfileUpdate))
-	http.HandleFunc("/profile/update-password", corsMiddleware(handlePasswordUpdate))
+	http.HandleFunc("/profile/update", corsMiddleware(sessions.Require(handleProfileUpdate)))
+	http.HandleFunc("/profile/update-password", corsMiddleware(sessions.Require(handlePasswordUpdate)))
 	http.HandleFunc("/profile/ping", corsMiddleware(handlePing))
-	http.HandleFunc("/profile/test-image-update", corsMiddleware(handleTestImageUpdate))
-	http.HandleFunc("/update/locale", corsMiddleware(handleLocaleUpdate))
-	http.HandleFunc("/profile/delete-account", corsMiddleware(handleDeleteAccount))
-	http.HandleFunc("/user/info", corsMiddleware(handleGetUserInfo))
-	http.HandleFunc("/user/update", corsMiddleware(handleUpdateUser))
+	http.HandleFunc("/profile/test-image-update", corsMiddleware(sessions.Require(handleTestImageUpdate)))
+	http.HandleFunc("/update/locale", corsMiddleware(sessions.Require(handleLocaleUpdate)))
+	http.HandleFunc("/profile/delete-account", corsMiddleware(sessions.Require(handleDeleteAccount)))
+	http.HandleFunc("/profile/export", corsMiddleware(sessions.Require(exports.Handler("/profile/export/download"))))
+	http.HandleFunc("/profile/export/download", corsMiddleware(exports.DownloadHandler()))
+	http.HandleFunc("/profile/import", corsMiddleware(sessions.Require(restorer.RestoreHandler())))
+	http.HandleFunc("/user/info", corsMiddleware(sessions.Require(handleGetUserInfo)))
+	http.HandleFunc("/user/update", corsMiddleware(sessions.Require(handleUpdateUser)))
+	http.HandleFunc("/profile/identities", corsMiddleware(sessions.Require(handleListIdentities)))
+	http.HandleFunc("/profile/identities/link", corsMiddleware(sessions.Require(handleLinkIdentity)))
+	http.HandleFunc("/profile/identities/unlink", corsMiddleware(sessions.Require(handleUnlinkIdentity)))
+	http.HandleFunc("/profile/2fa/status", corsMiddleware(sessions.Require(handleTwoFactorStatus)))
+	http.HandleFunc("/profile/2fa/setup", corsMiddleware(sessions.Require(handleTwoFactorSetup)))
+	http.HandleFunc("/profile/2fa/confirm", corsMiddleware(sessions.Require(handleTwoFactorConfirm)))
+	http.HandleFunc("/profile/2fa/disable", corsMiddleware(sessions.Require(handleTwoFactorDisable)))
+	http.HandleFunc("/profile/2fa/recovery-codes", corsMiddleware(sessions.Require(handleRecoveryCodesRegenerate)))
+	http.HandleFunc("/profile/currency", corsMiddleware(sessions.Require(handleBaseCurrency)))
+	http.HandleFunc("/admin/exchange-rates", corsMiddleware(auth.RequireAdmin(currencies.Handler())))
 
 	port := 8092 // Asignamos el puerto 8092 para el servicio de profile_management
 	log.Printf("Profile Management service started on :%d", port)
@@ -457,8 +549,7 @@ func handlePasswordUpdate(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Verify old password matches
-	// In a real app, you would compare hashed passwords
-	if currentPassword != req.OldPassword {
+	if matched, _ := password.Verify(currentPassword, req.OldPassword); !matched {
 		log.Printf("Incorrect password for user ID: %d", req.UserID)
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(ApiResponse{
@@ -468,9 +559,16 @@ func handlePasswordUpdate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	hashedPassword, err := password.Hash(req.NewPassword)
+	if err != nil {
+		log.Printf("Failed to hash password: %v", err)
+		http.Error(w, "Failed to update password", http.StatusInternalServerError)
+		return
+	}
+
 	// Update password
 	_, err = db.Exec("UPDATE users SET password = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?",
-		req.NewPassword, req.UserID)
+		hashedPassword, req.UserID)
 
 	if err != nil {
 		log.Printf("Failed to update password: %v", err)
@@ -601,11 +699,17 @@ func handleDeleteAccount(w http.ResponseWriter, r *http.Request) {
 		"daily_cash_bank_balance",
 		"weekly_cash_bank_balance",
 		"monthly_cash_bank_balance",
+		"transaction_tags",
+		"tags",
 		"bills",
 		"expenses",
 		"incomes",
 		"savings",
 		"balances",
+		"exports",
+		"user_identities",
+		"user_recovery_codes",
+		"user_totp",
 		"users",
 	}
 
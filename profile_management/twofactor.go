@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"backend/common/auth"
+	"backend/common/twofactor"
+)
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code"`
+}
+
+type TwoFactorSetupResponse struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// handleTwoFactorStatus reports whether 2FA is on and how many recovery
+// codes are left.
+func handleTwoFactorStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := sessionUser(w, r)
+	if !ok {
+		return
+	}
+
+	status, err := twoFactor.Status(userID)
+	if err != nil {
+		sendTwoFactorError(w, r, userID, err)
+		return
+	}
+	sendJSONResponse(w, http.StatusOK, ApiResponse{Success: true, Data: status})
+}
+
+// handleTwoFactorSetup creates a pending secret. 2FA is not enforced until
+// the user confirms a code from their authenticator app.
+func handleTwoFactorSetup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := sessionUser(w, r)
+	if !ok {
+		return
+	}
+
+	var email string
+	if err := db.QueryRow("SELECT email FROM users WHERE id = ?", userID).Scan(&email); err != nil {
+		log.Printf("Error fetching user %d for 2FA setup: %v", userID, err)
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	secret, uri, err := twoFactor.Begin(userID, email)
+	if err != nil {
+		sendTwoFactorError(w, r, userID, err)
+		return
+	}
+
+	log.Printf("2FA setup started for user ID: %d", userID)
+	sendJSONResponse(w, http.StatusOK, ApiResponse{
+		Success: true,
+		Message: "Scan the code with your authenticator app and confirm it",
+		Data:    TwoFactorSetupResponse{Secret: secret, ProvisioningURI: uri},
+	})
+}
+
+// handleTwoFactorConfirm enables 2FA and returns the first recovery codes.
+func handleTwoFactorConfirm(w http.ResponseWriter, r *http.Request) {
+	userID, req, ok := decodeTwoFactorCode(w, r)
+	if !ok {
+		return
+	}
+
+	codes, err := twoFactor.Confirm(userID, req.Code)
+	if err != nil {
+		sendTwoFactorError(w, r, userID, err)
+		return
+	}
+
+	log.Printf("2FA enabled for user ID: %d", userID)
+	sendJSONResponse(w, http.StatusOK, ApiResponse{
+		Success: true,
+		Message: "Two-factor authentication enabled",
+		Data:    RecoveryCodesResponse{RecoveryCodes: codes},
+	})
+}
+
+// handleTwoFactorDisable turns 2FA off; it needs a current TOTP or recovery
+// code so a stolen session alone cannot remove the second factor.
+func handleTwoFactorDisable(w http.ResponseWriter, r *http.Request) {
+	userID, req, ok := decodeTwoFactorCode(w, r)
+	if !ok {
+		return
+	}
+
+	if err := twoFactor.Disable(userID, req.Code); err != nil {
+		sendTwoFactorError(w, r, userID, err)
+		return
+	}
+
+	log.Printf("2FA disabled for user ID: %d", userID)
+	sendJSONResponse(w, http.StatusOK, ApiResponse{
+		Success: true,
+		Message: "Two-factor authentication disabled",
+	})
+}
+
+// handleRecoveryCodesRegenerate replaces every recovery code with a new set.
+func handleRecoveryCodesRegenerate(w http.ResponseWriter, r *http.Request) {
+	userID, req, ok := decodeTwoFactorCode(w, r)
+	if !ok {
+		return
+	}
+
+	codes, err := twoFactor.RegenerateRecoveryCodes(userID, req.Code)
+	if err != nil {
+		sendTwoFactorError(w, r, userID, err)
+		return
+	}
+
+	log.Printf("Recovery codes regenerated for user ID: %d", userID)
+	sendJSONResponse(w, http.StatusOK, ApiResponse{
+		Success: true,
+		Message: "Recovery codes regenerated",
+		Data:    RecoveryCodesResponse{RecoveryCodes: codes},
+	})
+}
+
+func decodeTwoFactorCode(w http.ResponseWriter, r *http.Request) (int, TwoFactorCodeRequest, bool) {
+	var req TwoFactorCodeRequest
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return 0, req, false
+	}
+
+	userID, ok := sessionUser(w, r)
+	if !ok {
+		return 0, req, false
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+		http.Error(w, "Code is required", http.StatusBadRequest)
+		return 0, req, false
+	}
+	if !checkLoginAllowed(w, r, userID) {
+		return 0, req, false
+	}
+	return userID, req, true
+}
+
+// sessionUser returns the authenticated user. Login settings are never
+// changed on behalf of a user_id sent by the client.
+func sessionUser(w http.ResponseWriter, r *http.Request) (int, bool) {
+	userID, err := strconv.Atoi(auth.UserID(r))
+	if err != nil || userID <= 0 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return 0, false
+	}
+	return userID, true
+}
+
+// sendTwoFactorError answers err. Wrong codes of userID are recorded as
+// failed sign-ins, which locks the account out after a few.
+func sendTwoFactorError(w http.ResponseWriter, r *http.Request, userID int, err error) {
+	switch err {
+	case twofactor.ErrInvalidCode:
+		recordLoginAttempt(r, userID, false, "bad_2fa_code")
+		sendJSONResponse(w, http.StatusBadRequest, ApiResponse{Success: false, Message: "Invalid authentication code"})
+	case twofactor.ErrAlreadyEnabled, twofactor.ErrNotEnabled, twofactor.ErrNotPending:
+		sendJSONResponse(w, http.StatusConflict, ApiResponse{Success: false, Message: err.Error()})
+	default:
+		log.Printf("Two-factor error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+	}
+}
+
+func sendJSONResponse(w http.ResponseWriter, status int, response ApiResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
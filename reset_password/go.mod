@@ -3,8 +3,14 @@ module backend/reset_password
 go 1.21
 
 require (
+	backend/common v0.0.0
 	github.com/mattn/go-sqlite3 v1.14.27
-	gopkg.in/gomail.v2 v2.0.0-20160411212932-81ebce5c23df
 )
 
-require gopkg.in/alexcesaro/quotedprintable.v3 v3.0.0-20150716171945-2caba252f4dc // indirect
+require (
+	golang.org/x/crypto v0.33.0 // indirect
+	gopkg.in/alexcesaro/quotedprintable.v3 v3.0.0-20150716171945-2caba252f4dc // indirect
+	gopkg.in/gomail.v2 v2.0.0-20160411212932-81ebce5c23df // indirect
+)
+
+replace backend/common => ../common
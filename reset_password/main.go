@@ -2,11 +2,10 @@ package main
 
 import (
 	"bytes"
-	"crypto/rand"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"os"
@@ -15,12 +14,22 @@ import (
 	"text/template"
 	"time"
 
+	"backend/common/auth"
+	"backend/common/identity"
+	"backend/common/mail"
+	"backend/common/password"
+	"backend/common/throttle"
+
 	_ "github.com/mattn/go-sqlite3"
-	"gopkg.in/gomail.v2"
 )
 
 var (
-	db *sql.DB
+	db         *sql.DB
+	guard      *throttle.Guard
+	identities *identity.Store
+	// outbox is nil when no mail transport is configured
+	outbox *mail.Outbox
+
 	// Email configuration - will be loaded from config.json
 	smtpHost     string
 	smtpPort     int
@@ -109,9 +118,16 @@ type EmailCheckRequest struct {
 }
 
 type EmailCheckResponse struct {
-	Exists bool   `json:"exists"`
-	UserID int    `json:"user_id"`
-	Name   string `json:"name"`
+	Exists bool `json:"exists"`
+}
+
+// smtpSettings returns the SMTP relay from config.json. The placeholder
+// host of the defaults means SMTP is not configured.
+func smtpSettings() mail.SMTPConfig {
+	if smtpHost == "smtp.example.com" {
+		return mail.SMTPConfig{}
+	}
+	return mail.SMTPConfig{Host: smtpHost, Port: smtpPort, Username: smtpUsername, Password: smtpPassword}
 }
 
 func loadConfig() {
@@ -349,10 +365,34 @@ func init() {
 		}
 	}
 
+	if err := createResetTokensTable(); err != nil {
+		log.Fatalf("Failed to prepare reset tokens: %v", err)
+	}
+
 	log.Println("Database connection established successfully")
 }
 
 func main() {
+	// Email lookups are rate limited per IP
+	var err error
+	guard, err = throttle.NewGuard(db, throttle.PolicyFromEnv())
+	if err != nil {
+		log.Fatalf("Failed to initialize request throttling: %v", err)
+	}
+
+	identities, err = identity.NewStore(db)
+	if err != nil {
+		log.Fatalf("Failed to initialize identities: %v", err)
+	}
+
+	// Emails go through the outbox, so a mail outage does not fail the request
+	outbox, err = mail.StartFromEnv(db, smtpSettings())
+	if errors.Is(err, mail.ErrNotConfigured) {
+		log.Println("Mail not configured. Reset emails will not be sent.")
+	} else if err != nil {
+		log.Fatalf("Failed to initialize mail outbox: %v", err)
+	}
+
 	// Setup HTTP handlers
 	http.HandleFunc("/reset-password/request", corsMiddleware(handleResetRequest))
 	http.HandleFunc("/reset-password/validate-token", corsMiddleware(handleValidateToken))
@@ -396,38 +436,22 @@ func handleCheckEmail(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if email exists and get user details
-	var userID int
-	var name string
-
-	err := db.QueryRow("SELECT id, name FROM users WHERE email = ?", req.Email).Scan(&userID, &name)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			// Email doesn't exist
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(EmailCheckResponse{Exists: false, UserID: 0, Name: ""})
-			return
-		}
-		// Database error
-		log.Printf("Database error: %v", err)
-		http.Error(w, "Database error", http.StatusInternalServerError)
+	if wait, err := guard.RequestRetryAfter("reset-password/check-email", req.Email, auth.ClientIP(r)); err != nil {
+		log.Printf("Error checking request rate: %v", err)
+	} else if wait > 0 {
+		w.Header().Set("Retry-After", throttle.RetryAfterSeconds(wait))
+		http.Error(w, "Too many requests", http.StatusTooManyRequests)
 		return
 	}
 
-	// Email exists, return user details
+	// Same answer for every email: whether an account exists is only
+	// revealed to the owner of the mailbox, through the reset email.
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(EmailCheckResponse{Exists: true, UserID: userID, Name: name})
+	json.NewEncoder(w).Encode(EmailCheckResponse{Exists: true})
 }
 
-// Helper function to generate a random reset token
-func generateResetToken() string {
-	b := make([]byte, 32)
-	rand.Read(b)
-	return fmt.Sprintf("%x", b)
-}
-
-// Send reset password email with language support
-func sendResetEmail(toEmail, resetToken, userName string, userID int, language string) error {
+// Queue reset password email with language support
+func queueResetEmail(toEmail, resetToken, userName string, userID int, language string) error {
 	// Validate email before attempting to send
 	if toEmail == "" {
 		return fmt.Errorf("cannot send reset email: email address is empty")
@@ -447,12 +471,12 @@ func sendResetEmail(toEmail, resetToken, userName string, userID int, language s
 	emailTemplate := getEmailTemplate(language)
 
 	// Log the values for debugging
-	log.Printf("Sending reset email - Email: %s, Token: %s, Name: %s, UserID: %d, Language: %s", toEmail, resetToken, userName, userID, language)
+	log.Printf("Queueing reset email - Email: %s, Name: %s, UserID: %d, Language: %s", toEmail, userName, userID, language)
 
 	// Format a deep link URL that will be handled by the app
 	// The format should be: herobudget://reset-password?token=RESET_TOKEN&user_id=USER_ID
 	resetLink := fmt.Sprintf("herobudget://reset-password?token=%s&user_id=%d", resetToken, userID)
-	log.Printf("Generated reset link: %s", resetLink)
+	log.Printf("Generated reset link for user ID: %d", userID)
 
 	// Read the herobudgeticon.png image for embedding
 	imgPath := filepath.Join("..", "..", "assets", "images", "herobudgeticon.png")
@@ -463,17 +487,18 @@ func sendResetEmail(toEmail, resetToken, userName string, userID int, language s
 	}
 
 	// Create email message
-	m := gomail.NewMessage()
-	m.SetHeader("From", fromEmail)
-	m.SetHeader("To", toEmail)
-	m.SetHeader("Subject", emailTemplate.Subject)
+	m := mail.Message{
+		From:    fromEmail,
+		To:      []string{toEmail},
+		Subject: emailTemplate.Subject,
+	}
 
 	// Create HTML with or without image
 	var imageTag string
 	if imgData != nil {
 		// Embed the image and create HTML with the CID
 		imgFilename := filepath.Base(imgPath)
-		m.Embed(imgPath)
+		m.Embeds = append(m.Embeds, mail.Embed{Name: imgFilename, Data: imgData})
 		imageTag = fmt.Sprintf(`<img src="cid:%s" alt="Hero Budget" style="max-width: 150px; margin: 20px 0;">`, imgFilename)
 	} else {
 		imageTag = ""
@@ -540,17 +565,14 @@ func sendResetEmail(toEmail, resetToken, userName string, userID int, language s
 	)
 
 	// Set the email body
-	m.SetBody("text/html", emailBody)
+	m.HTML = emailBody
 
-	// Set up email sending
-	d := gomail.NewDialer(smtpHost, smtpPort, smtpUsername, smtpPassword)
-
-	// Send the email
-	if err := d.DialAndSend(m); err != nil {
-		return fmt.Errorf("failed to send reset email: %v", err)
+	// The outbox delivers in the background and retries if the mail server is down
+	if _, err := outbox.Enqueue(m); err != nil {
+		return fmt.Errorf("failed to queue reset email: %v", err)
 	}
 
-	log.Printf("Reset email sent successfully to %s in language: %s", toEmail, language)
+	log.Printf("Reset email queued for %s in language: %s", toEmail, language)
 	return nil
 }
 
@@ -560,20 +582,8 @@ func handleResetRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Log request headers
 	log.Println("Received password reset request")
 
-	// Read and log the raw request body for debugging
-	bodyBytes, err := io.ReadAll(r.Body)
-	if err != nil {
-		log.Printf("Error reading body: %v", err)
-		http.Error(w, "Error reading request body", http.StatusBadRequest)
-		return
-	}
-
-	// Create a new reader from the bytes for JSON decoding
-	r.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
-
 	var req ResetRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		log.Printf("Invalid request body: %v", err)
@@ -590,68 +600,61 @@ func handleResetRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	log.Printf("Checking if email exists: %s", req.Email)
+	clientIP := auth.ClientIP(r)
+	if wait, err := guard.RequestRetryAfter("reset-password/request", req.Email, clientIP); err != nil {
+		log.Printf("Error checking request rate: %v", err)
+	} else if wait > 0 {
+		w.Header().Set("Retry-After", throttle.RetryAfterSeconds(wait))
+		http.Error(w, "Too many requests", http.StatusTooManyRequests)
+		return
+	}
+
+	// Unknown emails get the same answer as known ones, and problems sending
+	// the email are only logged, so this endpoint cannot be used to find out
+	// who has an account
+	sent := map[string]interface{}{
+		"success": true,
+		"message": "Password reset email sent",
+		"email":   req.Email,
+	}
 
 	// Check if email exists and get user details
 	var userID int
-	var name string
+	var name sql.NullString
 
-	err = db.QueryRow("SELECT id, name FROM users WHERE email = ?", req.Email).Scan(&userID, &name)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			log.Printf("No user found with email: %s", req.Email)
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusNotFound)
-			json.NewEncoder(w).Encode(map[string]string{"error": "User with this email does not exist"})
-			return
-		}
+	err := db.QueryRow("SELECT id, name FROM users WHERE email = ?", req.Email).Scan(&userID, &name)
+	if err == sql.ErrNoRows {
+		log.Printf("Password reset requested for unknown email: %s", req.Email)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(sent)
+		return
+	} else if err != nil {
 		log.Printf("Database error checking email: %v", err)
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
 
-	// Generate reset token
-	resetToken := generateResetToken()
-
-	// Set expiration time (24 hours from now)
-	expiresAt := time.Now().Add(24 * time.Hour)
-
-	// Update user with reset token
-	_, err = db.Exec(
-		"UPDATE users SET reset_token = ?, reset_expires = ? WHERE id = ?",
-		resetToken, expiresAt, userID,
-	)
-
-	if err != nil {
-		log.Printf("Failed to update user with reset token: %v", err)
-		http.Error(w, "Failed to process reset request", http.StatusInternalServerError)
-		return
-	}
-
-	// Send reset email
-	if smtpHost != "smtp.example.com" { // Only send if SMTP is configured
-		err = sendResetEmail(req.Email, resetToken, name, userID, req.Language)
-		if err != nil {
-			log.Printf("Warning: Failed to send reset email: %v", err)
-			http.Error(w, "Failed to send reset email", http.StatusInternalServerError)
-			return
-		} else {
-			log.Printf("Reset email sent to %s", req.Email)
-		}
+	if err := sendResetEmail(r, userID, req.Email, name.String, req.Language, clientIP); err != nil {
+		log.Printf("Error sending reset email to %s: %v", req.Email, err)
 	} else {
-		log.Printf("SMTP not configured. Skipping reset email.")
-		http.Error(w, "SMTP not configured", http.StatusInternalServerError)
-		return
+		log.Printf("Reset email queued for %s", req.Email)
 	}
 
-	// Return success
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"success": true,
-		"message": "Password reset email sent",
-		"email":   req.Email,
-		"user_id": userID,
-	})
+	json.NewEncoder(w).Encode(sent)
+}
+
+// sendResetEmail issues a new reset token for userID, which invalidates any
+// link sent before, and queues the email carrying it.
+func sendResetEmail(r *http.Request, userID int, email, name, language, clientIP string) error {
+	if outbox == nil {
+		return errors.New("mail is not configured")
+	}
+	resetToken, err := issueResetToken(userID, clientIP, r.UserAgent())
+	if err != nil {
+		return fmt.Errorf("failed to issue reset token: %v", err)
+	}
+	return queueResetEmail(email, resetToken, name, userID, language)
 }
 
 func handleValidateToken(w http.ResponseWriter, r *http.Request) {
@@ -671,39 +674,20 @@ func handleValidateToken(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	log.Printf("Validating reset token: %s", req.Token)
+	// Check if token exists, is unused and is not expired
+	userID, err := lookupResetToken(req.Token)
+	if err != nil {
+		sendResetTokenError(w, err)
+		return
+	}
 
-	// Check if token exists and is not expired
-	var userID int
 	var email string
-	var expires time.Time
-
-	err := db.QueryRow(
-		"SELECT id, email, reset_expires FROM users WHERE reset_token = ?",
-		req.Token,
-	).Scan(&userID, &email, &expires)
-
-	if err == sql.ErrNoRows {
-		log.Printf("Invalid or expired token: %s", req.Token)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusNotFound)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid or expired token"})
-		return
-	} else if err != nil {
+	if err := db.QueryRow("SELECT email FROM users WHERE id = ?", userID).Scan(&email); err != nil {
 		log.Printf("Database error: %v", err)
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
 
-	// Check if token is expired
-	if time.Now().After(expires) {
-		log.Printf("Token expired: %s", req.Token)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Reset token has expired"})
-		return
-	}
-
 	// Return success with user info
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -713,6 +697,24 @@ func handleValidateToken(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// sendResetTokenError answers with the errors the app already handles:
+// 404 for unknown tokens and 400 for expired ones.
+func sendResetTokenError(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	switch err {
+	case errResetTokenInvalid:
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid or expired token"})
+	case errResetTokenExpired:
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Reset token has expired"})
+	default:
+		log.Printf("Database error: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+	}
+}
+
 func handleUpdatePassword(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -735,41 +737,28 @@ func handleUpdatePassword(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	log.Printf("Updating password for user ID: %d with token: %s", req.UserID, req.Token)
+	log.Printf("Updating password for user ID: %d", req.UserID)
 
 	// Verify token is valid and get the user
-	var userID int
-	var currentPassword string
-	var expires time.Time
-
-	err := db.QueryRow(
-		"SELECT id, password, reset_expires FROM users WHERE reset_token = ? AND id = ?",
-		req.Token, req.UserID,
-	).Scan(&userID, &currentPassword, &expires)
-
-	if err == sql.ErrNoRows {
-		log.Printf("Invalid token or user ID mismatch: %s, %d", req.Token, req.UserID)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusNotFound)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid token or user ID"})
-		return
-	} else if err != nil {
-		log.Printf("Database error: %v", err)
-		http.Error(w, "Database error", http.StatusInternalServerError)
+	userID, err := lookupResetToken(req.Token)
+	if err == nil && req.UserID != 0 && req.UserID != userID {
+		log.Printf("Reset token does not belong to user ID: %d", req.UserID)
+		err = errResetTokenInvalid
+	}
+	if err != nil {
+		sendResetTokenError(w, err)
 		return
 	}
 
-	// Check if token is expired
-	if time.Now().After(expires) {
-		log.Printf("Token expired: %s", req.Token)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Reset token has expired"})
+	var currentPassword sql.NullString
+	if err := db.QueryRow("SELECT password FROM users WHERE id = ?", userID).Scan(&currentPassword); err != nil {
+		log.Printf("Database error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
 
 	// Check if new password is the same as current password
-	if req.NewPassword == currentPassword {
+	if matched, _ := password.Verify(currentPassword.String, req.NewPassword); matched && currentPassword.String != "" {
 		log.Printf("New password cannot be the same as current password")
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusBadRequest)
@@ -777,18 +766,51 @@ func handleUpdatePassword(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Update the password and clear reset token
-	_, err = db.Exec(
-		"UPDATE users SET password = ?, reset_token = NULL, reset_expires = NULL, updated_at = CURRENT_TIMESTAMP WHERE id = ?",
-		req.NewPassword, userID,
-	)
+	hashedPassword, err := password.Hash(req.NewPassword)
+	if err != nil {
+		log.Printf("Failed to hash password: %v", err)
+		http.Error(w, "Failed to update password", http.StatusInternalServerError)
+		return
+	}
 
+	// Use up the token and update the password together
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Failed to start transaction: %v", err)
+		http.Error(w, "Failed to update password", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if err := consumeResetToken(tx, req.Token, auth.ClientIP(r), r.UserAgent()); err != nil {
+		sendResetTokenError(w, err)
+		return
+	}
+
+	_, err = tx.Exec(
+		"UPDATE users SET password = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?",
+		hashedPassword, userID,
+	)
+	if err == nil {
+		err = tx.Commit()
+	}
 	if err != nil {
 		log.Printf("Failed to update password: %v", err)
 		http.Error(w, "Failed to update password", http.StatusInternalServerError)
 		return
 	}
 
+	// Whoever knew the old password is signed out everywhere
+	if err := auth.RevokeAllSessions(db, userID); err != nil {
+		log.Printf("Failed to revoke sessions for user ID %d: %v", userID, err)
+	}
+
+	// Resetting the password of a Google/Apple-only account adds the
+	// email/password login method to it
+	if err := identities.Link(userID, identity.ProviderPassword, identity.PasswordSubject(userID), ""); err != nil {
+		log.Printf("Failed to record password identity for user ID %d: %v", userID, err)
+	}
+
 	log.Printf("Password updated successfully for user ID: %d", userID)
 
 	// Return success
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"backend/common/auth"
+	"backend/common/dbtest"
+	"backend/common/identity"
+	"backend/common/mail"
+	"backend/common/throttle"
+)
+
+// newTestDB points the service at an empty database with user 1,
+// ana@example.com, and no mail transport
+func newTestDB(t *testing.T) {
+	t.Helper()
+
+	db = dbtest.Open(t)
+	_, err := db.Exec(`CREATE TABLE users (
+		id INTEGER PRIMARY KEY AUTOINCREMENT, email TEXT UNIQUE, password TEXT, name TEXT,
+		reset_token TEXT, reset_expires DATETIME, updated_at DATETIME)`)
+	if err != nil {
+		t.Fatalf("Failed to create users: %v", err)
+	}
+	db.Exec(`INSERT INTO users (id, email, name) VALUES (1, 'ana@example.com', 'Ana')`)
+	if err := createResetTokensTable(); err != nil {
+		t.Fatalf("Failed to create reset tokens: %v", err)
+	}
+	if guard, err = throttle.NewGuard(db, throttle.PolicyFromEnv()); err != nil {
+		t.Fatalf("Failed to create guard: %v", err)
+	}
+	if identities, err = identity.NewStore(db); err != nil {
+		t.Fatalf("Failed to create identities: %v", err)
+	}
+	outbox = nil
+}
+
+// post calls handler with body as JSON and returns the recorded response
+func post(handler http.HandlerFunc, body interface{}) *httptest.ResponseRecorder {
+	payload, _ := json.Marshal(body)
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(payload)))
+	return w
+}
+
+func TestResetTokensAreStoredHashed(t *testing.T) {
+	newTestDB(t)
+
+	token, err := issueResetToken(1, "10.0.0.1", "test")
+	if err != nil {
+		t.Fatalf("issueResetToken failed: %v", err)
+	}
+	var stored string
+	db.QueryRow(`SELECT token_hash FROM password_reset_tokens WHERE user_id = 1`).Scan(&stored)
+	if stored == token || stored != auth.HashToken(token) {
+		t.Errorf("Expected only the hash of the token to be stored, got %q", stored)
+	}
+	if userID, err := lookupResetToken(token); err != nil || userID != 1 {
+		t.Errorf("lookupResetToken = %d, %v", userID, err)
+	}
+}
+
+func TestIssuingAResetTokenInvalidatesThePreviousOne(t *testing.T) {
+	newTestDB(t)
+
+	first, _ := issueResetToken(1, "", "")
+	second, _ := issueResetToken(1, "", "")
+	if _, err := lookupResetToken(first); !errors.Is(err, errResetTokenInvalid) {
+		t.Errorf("First token = %v, want errResetTokenInvalid", err)
+	}
+	if _, err := lookupResetToken(second); err != nil {
+		t.Errorf("Second token = %v", err)
+	}
+}
+
+func TestExpiredResetTokensAreRejected(t *testing.T) {
+	newTestDB(t)
+
+	token, _ := issueResetToken(1, "", "")
+	db.Exec(`UPDATE password_reset_tokens SET expires_at = ?`, time.Now().Add(-time.Second).Unix())
+	if _, err := lookupResetToken(token); !errors.Is(err, errResetTokenExpired) {
+		t.Errorf("lookupResetToken = %v, want errResetTokenExpired", err)
+	}
+	if w := post(handleUpdatePassword, ResetPasswordRequest{Token: token, NewPassword: "N3w-password!"}); w.Code != http.StatusBadRequest {
+		t.Errorf("Reset with an expired token = %d, want 400", w.Code)
+	}
+}
+
+func TestResetTokensAreSingleUseAndRevokeSessions(t *testing.T) {
+	newTestDB(t)
+	sessions, err := auth.NewManager(db, "0123456789abcdef0123456789abcdef", time.Hour, 24*time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create sessions: %v", err)
+	}
+	tokens, err := sessions.IssueTokens(1, "test", "10.0.0.1")
+	if err != nil {
+		t.Fatalf("IssueTokens failed: %v", err)
+	}
+
+	token, _ := issueResetToken(1, "", "")
+	if w := post(handleUpdatePassword, ResetPasswordRequest{Token: token, NewPassword: "N3w-password!"}); w.Code != http.StatusOK {
+		t.Fatalf("Reset = %d: %s", w.Code, w.Body)
+	}
+	if _, err := sessions.Authenticate(tokens.AccessToken); !errors.Is(err, auth.ErrRevoked) {
+		t.Errorf("Session after the reset = %v, want ErrRevoked", err)
+	}
+
+	// The same link cannot set the password again
+	if w := post(handleUpdatePassword, ResetPasswordRequest{Token: token, NewPassword: "An0ther-password!"}); w.Code != http.StatusNotFound {
+		t.Errorf("Second reset with the same token = %d, want 404", w.Code)
+	}
+}
+
+func TestResetRequestsAnswerTheSameForUnknownEmails(t *testing.T) {
+	newTestDB(t)
+
+	// Without mail, a known email still gets the generic answer
+	known := post(handleResetRequest, ResetRequest{Email: "ana@example.com"})
+	unknown := post(handleResetRequest, ResetRequest{Email: "nobody@example.com"})
+	if known.Code != http.StatusOK || unknown.Code != http.StatusOK {
+		t.Fatalf("Expected 200 for both, got %d and %d", known.Code, unknown.Code)
+	}
+
+	var err error
+	if outbox, err = mail.NewOutbox(db, mail.NewMemory()); err != nil {
+		t.Fatalf("Failed to create outbox: %v", err)
+	}
+	if w := post(handleResetRequest, ResetRequest{Email: "ana@example.com"}); w.Code != http.StatusOK {
+		t.Fatalf("Reset request = %d: %s", w.Code, w.Body)
+	}
+	var queued, pending int
+	db.QueryRow(`SELECT COUNT(*) FROM mail_outbox`).Scan(&queued)
+	db.QueryRow(`SELECT COUNT(*) FROM password_reset_tokens WHERE invalidated_at IS NULL`).Scan(&pending)
+	if queued != 1 || pending != 1 {
+		t.Errorf("Expected 1 email and 1 pending token, got %d and %d", queued, pending)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"backend/common/auth"
+	"backend/common/config"
+)
+
+// resetTokenTTL is how long a reset link stays valid (RESET_TOKEN_TTL).
+var resetTokenTTL = config.Duration("RESET_TOKEN_TTL", 24*time.Hour)
+
+var (
+	errResetTokenInvalid = errors.New("invalid reset token")
+	errResetTokenExpired = errors.New("reset token has expired")
+)
+
+// createResetTokensTable creates password_reset_tokens. Only the SHA-256 of
+// each token is stored; the row also keeps who asked for it and who used
+// it, as an audit trail.
+func createResetTokensTable() error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS password_reset_tokens (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			token_hash TEXT NOT NULL UNIQUE,
+			requested_ip TEXT,
+			requested_user_agent TEXT,
+			created_at INTEGER NOT NULL,
+			expires_at INTEGER NOT NULL,
+			used_at INTEGER,
+			used_ip TEXT,
+			used_user_agent TEXT,
+			invalidated_at INTEGER
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating password_reset_tokens table: %v", err)
+	}
+
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_password_reset_tokens_user ON password_reset_tokens(user_id)`)
+	if err != nil {
+		return fmt.Errorf("error creating index on password_reset_tokens: %v", err)
+	}
+
+	// Tokens issued before this table existed were stored in plaintext on
+	// users; they stop working.
+	_, err = db.Exec(`UPDATE users SET reset_token = NULL, reset_expires = NULL WHERE reset_token IS NOT NULL`)
+	if err != nil {
+		return fmt.Errorf("error clearing legacy reset tokens: %v", err)
+	}
+	return nil
+}
+
+// issueResetToken invalidates every pending token of userID and returns a
+// new one valid for resetTokenTTL.
+func issueResetToken(userID int, ipAddress, userAgent string) (string, error) {
+	token, err := auth.RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return "", fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	_, err = tx.Exec(`
+		UPDATE password_reset_tokens SET invalidated_at = ?
+		WHERE user_id = ? AND used_at IS NULL AND invalidated_at IS NULL`,
+		now.Unix(), userID,
+	)
+	if err != nil {
+		return "", fmt.Errorf("error invalidating previous reset tokens: %v", err)
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO password_reset_tokens (
+			user_id, token_hash, requested_ip, requested_user_agent, created_at, expires_at
+		) VALUES (?, ?, ?, ?, ?, ?)`,
+		userID, auth.HashToken(token), ipAddress, userAgent, now.Unix(), now.Add(resetTokenTTL).Unix(),
+	)
+	if err != nil {
+		return "", fmt.Errorf("error storing reset token: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("error committing reset token: %v", err)
+	}
+	return token, nil
+}
+
+// lookupResetToken returns the user of a token that can still be used.
+func lookupResetToken(token string) (int, error) {
+	var userID int
+	var expiresAt int64
+	var usedAt, invalidatedAt sql.NullInt64
+	err := db.QueryRow(`
+		SELECT user_id, expires_at, used_at, invalidated_at
+		FROM password_reset_tokens WHERE token_hash = ?`,
+		auth.HashToken(token),
+	).Scan(&userID, &expiresAt, &usedAt, &invalidatedAt)
+	if err == sql.ErrNoRows {
+		return 0, errResetTokenInvalid
+	} else if err != nil {
+		return 0, fmt.Errorf("error fetching reset token: %v", err)
+	}
+
+	if usedAt.Valid || invalidatedAt.Valid {
+		return 0, errResetTokenInvalid
+	}
+	if time.Now().Unix() >= expiresAt {
+		return 0, errResetTokenExpired
+	}
+	return userID, nil
+}
+
+// consumeResetToken marks token as used inside tx. The conditions are part
+// of the UPDATE so two concurrent resets with the same token cannot both
+// succeed.
+func consumeResetToken(tx *sql.Tx, token, ipAddress, userAgent string) error {
+	now := time.Now().Unix()
+	result, err := tx.Exec(`
+		UPDATE password_reset_tokens
+		SET used_at = ?, used_ip = ?, used_user_agent = ?
+		WHERE token_hash = ? AND used_at IS NULL AND invalidated_at IS NULL AND expires_at > ?`,
+		now, ipAddress, userAgent, auth.HashToken(token), now,
+	)
+	if err != nil {
+		return fmt.Errorf("error consuming reset token: %v", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return errResetTokenInvalid
+	}
+	return nil
+}
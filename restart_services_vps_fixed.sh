@@ -78,7 +78,7 @@ start_service() {
     
     # Compilar y ejecutar en background con CGO habilitado
     echo -e "${YELLOW}    🔨 Compilando y ejecutando $service_name...${NC}"
-    nohup env CGO_ENABLED=1 /usr/local/go/bin/go run main.go > "/tmp/${service_name}.log" 2>&1 &
+    nohup env CGO_ENABLED=1 /usr/local/go/bin/go run -tags sqlite_fts5 main.go > "/tmp/${service_name}.log" 2>&1 &
     local pid=$!
     
     echo -e "${GREEN}  ✅ $service_name iniciado (PID: $pid)${NC}"
@@ -48,7 +48,7 @@ start_service() {
     cd "$service_name" || { echo -e "${RED}❌ Error: Directorio $service_name no encontrado${NC}"; return 1; }
     
     # Compilar y ejecutar en background
-    go run main.go &
+    go run -tags sqlite_fts5 main.go &
     local pid=$!
     
     echo -e "${GREEN}  ✅ $service_name iniciado (PID: $pid)${NC}"
@@ -10,25 +10,32 @@ import (
 	"path/filepath"
 	"time"
 
+	"backend/common/auth"
+	"backend/common/currency"
+	"backend/common/journal"
+	"backend/common/money"
+
 	_ "github.com/mattn/go-sqlite3"
 )
 
 // Definición de estructuras de datos
 type SavingsData struct {
-	UserID      string  `json:"user_id"`
-	Available   float64 `json:"available"`
-	Goal        float64 `json:"goal"`
-	Period      string  `json:"period"` // New field for period type
-	Percent     float64 `json:"percent"`
-	NeedToSave  float64 `json:"need_to_save"`
-	DailyTarget float64 `json:"daily_target"`
+	UserID      string      `json:"user_id"`
+	Available   money.Money `json:"available"`
+	Goal        money.Money `json:"goal"`
+	Period      string      `json:"period"` // New field for period type
+	Percent     float64     `json:"percent"`
+	NeedToSave  money.Money `json:"need_to_save"`
+	DailyTarget money.Money `json:"daily_target"`
+	Currency    string      `json:"currency"` // moneda de available y goal
 }
 
 type SavingsUpdateRequest struct {
-	UserID    string  `json:"user_id"`
-	Available float64 `json:"available,omitempty"`
-	Goal      float64 `json:"goal,omitempty"`
-	Period    string  `json:"period,omitempty"` // New field for period type
+	UserID    string      `json:"user_id"`
+	Available money.Money `json:"available,omitempty"`
+	Goal      money.Money `json:"goal,omitempty"`
+	Period    string      `json:"period,omitempty"` // New field for period type
+	Currency  string      `json:"currency,omitempty"`
 }
 
 type SavingsDeleteRequest struct {
@@ -42,7 +49,10 @@ type ApiResponse struct {
 }
 
 var (
-	db *sql.DB
+	db         *sql.DB
+	sessions   *auth.Manager
+	currencies *currency.Store
+	audit      *journal.Journal
 )
 
 func init() {
@@ -81,10 +91,11 @@ func createTablesIfNotExist() {
 		CREATE TABLE IF NOT EXISTS savings (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
 			user_id TEXT NOT NULL,
-			available REAL NOT NULL,
-			goal REAL NOT NULL,
+			available INTEGER NOT NULL,
+			goal INTEGER NOT NULL,
 			period TEXT NOT NULL DEFAULT 'monthly',
 			percent REAL NOT NULL,
+			currency TEXT,
 			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 		)
@@ -104,10 +115,34 @@ func createTablesIfNotExist() {
 }
 
 func main() {
+	// Every request must carry an access token issued by signin, google_auth or apple-auth
+	var err error
+	sessions, err = auth.NewManagerFromEnv(db)
+	if err != nil {
+		log.Fatalf("Failed to initialize sessions: %v", err)
+	}
+
+	// Amounts are stored as cents; tables created with REAL amounts are converted
+	if err := money.Migrate(db); err != nil {
+		log.Fatalf("Failed to migrate amounts to cents: %v", err)
+	}
+
+	// Goals without a currency are in the user's base currency
+	currencies, err = currency.NewStoreFromEnv(db)
+	if err != nil {
+		log.Fatalf("Failed to initialize currencies: %v", err)
+	}
+
+	// Every change to a savings goal is recorded in the journal
+	audit, err = journal.New(db)
+	if err != nil {
+		log.Fatalf("Failed to initialize journal: %v", err)
+	}
+
 	// Set up CORS middleware and routes
-	http.HandleFunc("/savings/fetch", corsMiddleware(handleFetchSavings))
-	http.HandleFunc("/savings/update", corsMiddleware(handleUpdateSavings))
-	http.HandleFunc("/savings/delete", corsMiddleware(handleDeleteSavings))
+	http.HandleFunc("/savings/fetch", corsMiddleware(sessions.Require(handleFetchSavings)))
+	http.HandleFunc("/savings/update", corsMiddleware(sessions.Require(handleUpdateSavings)))
+	http.HandleFunc("/savings/delete", corsMiddleware(sessions.Require(handleDeleteSavings)))
 	http.HandleFunc("/health", corsMiddleware(handleHealth))
 	http.HandleFunc("/savings/health", corsMiddleware(handleSavingsHealth))
 
@@ -186,6 +221,7 @@ func handleUpdateSavings(w http.ResponseWriter, r *http.Request) {
 		sendErrorResponse(w, "Error fetching current savings data", http.StatusInternalServerError)
 		return
 	}
+	before := currentSavings
 
 	// Update only the fields that were provided
 	if updateRequest.Available > 0 {
@@ -197,10 +233,17 @@ func handleUpdateSavings(w http.ResponseWriter, r *http.Request) {
 	if updateRequest.Period != "" {
 		currentSavings.Period = updateRequest.Period
 	}
+	if updateRequest.Currency != "" {
+		currentSavings.Currency, err = currency.Normalize(updateRequest.Currency)
+		if err != nil {
+			sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
 
 	// Calculate the percentage
 	if currentSavings.Goal > 0 {
-		currentSavings.Percent = (currentSavings.Available / currentSavings.Goal) * 100
+		currentSavings.Percent = (currentSavings.Available.Float64() / currentSavings.Goal.Float64()) * 100
 	} else {
 		currentSavings.Percent = 0
 	}
@@ -211,10 +254,10 @@ func handleUpdateSavings(w http.ResponseWriter, r *http.Request) {
 		currentSavings.NeedToSave = 0
 	}
 	// Assuming goal needs to be achieved within a month (30 days)
-	currentSavings.DailyTarget = currentSavings.NeedToSave / 30
+	currentSavings.DailyTarget = money.FromFloat(currentSavings.NeedToSave.Float64() / 30)
 
 	// Save the updated savings data
-	err = updateSavingsData(currentSavings)
+	err = updateSavingsData(currentSavings, before, journal.Actor(r))
 	if err != nil {
 		log.Printf("Error updating savings data: %v", err)
 		sendErrorResponse(w, "Error updating savings data", http.StatusInternalServerError)
@@ -246,7 +289,7 @@ func handleDeleteSavings(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Delete the savings data
-	err = deleteSavingsData(deleteRequest.UserID)
+	err = deleteSavingsData(deleteRequest.UserID, journal.Actor(r))
 	if err != nil {
 		log.Printf("Error deleting savings data: %v", err)
 		sendErrorResponse(w, "Error deleting savings data", http.StatusInternalServerError)
@@ -262,7 +305,7 @@ func fetchSavingsData(userID string) (SavingsData, error) {
 
 	// Query savings data from database
 	err := db.QueryRow(`
-		SELECT user_id, available, goal, period, percent
+		SELECT user_id, available, goal, period, percent, currency
 		FROM savings
 		WHERE user_id = ?
 		ORDER BY created_at DESC
@@ -273,6 +316,7 @@ func fetchSavingsData(userID string) (SavingsData, error) {
 		&savings.Goal,
 		&savings.Period,
 		&savings.Percent,
+		&savings.Currency,
 	)
 
 	if err == sql.ErrNoRows {
@@ -284,7 +328,8 @@ func fetchSavingsData(userID string) (SavingsData, error) {
 		savings.Percent = 0
 		savings.NeedToSave = 0
 		savings.DailyTarget = 0
-		return savings, nil
+		savings.Currency, err = currencies.Base(userID) // New goals start in the base currency
+		return savings, err
 	} else if err != nil {
 		return savings, err
 	}
@@ -295,31 +340,44 @@ func fetchSavingsData(userID string) (SavingsData, error) {
 		savings.NeedToSave = 0
 	}
 	// Assuming goal needs to be achieved within a month (30 days)
-	savings.DailyTarget = savings.NeedToSave / 30
+	savings.DailyTarget = money.FromFloat(savings.NeedToSave.Float64() / 30)
 
 	return savings, nil
 }
 
-func updateSavingsData(savings SavingsData) error {
+// updateSavingsData stores savings in place of before and records the
+// change in the journal on behalf of actor, in one transaction
+func updateSavingsData(savings, before SavingsData, actor string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	// Check if a savings entry already exists for this user
 	var count int
-	err := db.QueryRow(`
-		SELECT COUNT(*) 
+	var id int64
+	err = tx.QueryRow(`
+		SELECT COUNT(*), COALESCE(MAX(id), 0)
 		FROM savings 
 		WHERE user_id = ?
-	`, savings.UserID).Scan(&count)
+	`, savings.UserID).Scan(&count, &id)
 	if err != nil {
 		return err
 	}
 
+	change := journal.Change{Actor: actor, Entity: "savings", EntityID: id, After: savings}
 	if count > 0 {
+		change.Before = before
+
 		// Update existing savings entry
-		_, err = db.Exec(`
+		_, err = tx.Exec(`
 			UPDATE savings
 			SET available = ?,
 				goal = ?,
 				period = ?,
 				percent = ?,
+				currency = ?,
 				updated_at = CURRENT_TIMESTAMP
 			WHERE user_id = ?
 		`,
@@ -327,29 +385,62 @@ func updateSavingsData(savings SavingsData) error {
 			savings.Goal,
 			savings.Period,
 			savings.Percent,
+			savings.Currency,
 			savings.UserID,
 		)
 	} else {
 		// Insert new savings entry
-		_, err = db.Exec(`
+		var result sql.Result
+		result, err = tx.Exec(`
 			INSERT INTO savings (
-				user_id, available, goal, period, percent
-			) VALUES (?, ?, ?, ?, ?)
+				user_id, available, goal, period, percent, currency
+			) VALUES (?, ?, ?, ?, ?, ?)
 		`,
 			savings.UserID,
 			savings.Available,
 			savings.Goal,
 			savings.Period,
 			savings.Percent,
+			savings.Currency,
 		)
+		if err == nil {
+			change.EntityID, err = result.LastInsertId()
+		}
+	}
+	if err != nil {
+		return err
 	}
 
-	return err
+	if err := audit.RecordTx(tx, savings.UserID, change); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func deleteSavingsData(userID string) error {
+// deleteSavingsData removes the savings goal of userID and records it in
+// the journal on behalf of actor, in one transaction
+func deleteSavingsData(userID, actor string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var id int64
+	before := SavingsData{UserID: userID}
+	err = tx.QueryRow(`
+		SELECT id, available, goal, period, percent, COALESCE(currency, '')
+		FROM savings
+		WHERE user_id = ?
+		ORDER BY created_at DESC
+		LIMIT 1
+	`, userID).Scan(&id, &before.Available, &before.Goal, &before.Period, &before.Percent, &before.Currency)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
 	// Execute delete query
-	result, err := db.Exec(`
+	result, err := tx.Exec(`
 		DELETE FROM savings 
 		WHERE user_id = ?
 	`, userID)
@@ -367,7 +458,10 @@ func deleteSavingsData(userID string) error {
 		return fmt.Errorf("no savings goal found for user %s", userID)
 	}
 
-	return nil
+	if err := audit.RecordTx(tx, userID, journal.Change{Actor: actor, Entity: "savings", EntityID: id, Before: before}); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 func handleHealth(w http.ResponseWriter, r *http.Request) {
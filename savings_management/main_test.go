@@ -8,8 +8,12 @@ import (
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
+	"backend/common/currency"
+	"backend/common/journal"
+
 	_ "github.com/mattn/go-sqlite3"
 )
 
@@ -56,6 +60,20 @@ func setupTestDB() {
 		panic("Failed to create test table: " + err.Error())
 	}
 
+	// Users choose their base currency; savings goals get a currency column
+	_, err = testDB.Exec(`CREATE TABLE IF NOT EXISTS users (id INTEGER PRIMARY KEY, email TEXT)`)
+	if err != nil {
+		panic("Failed to create users table: " + err.Error())
+	}
+	currencies, err = currency.NewStore(testDB, "EUR")
+	if err != nil {
+		panic("Failed to create currency store: " + err.Error())
+	}
+	audit, err = journal.New(testDB)
+	if err != nil {
+		panic("Failed to create journal: " + err.Error())
+	}
+
 	// Replace the global db with our test database
 	db = testDB
 }
@@ -286,7 +304,7 @@ func TestDeleteSavingsData_Success(t *testing.T) {
 	}
 
 	// Call deleteSavingsData
-	err = deleteSavingsData(userID)
+	err = deleteSavingsData(userID, journal.System)
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
 	}
@@ -307,7 +325,7 @@ func TestDeleteSavingsData_UserNotFound(t *testing.T) {
 	clearTestData()
 
 	// Try to delete non-existent user
-	err := deleteSavingsData("non_existent_user")
+	err := deleteSavingsData("non_existent_user", journal.System)
 	if err == nil {
 		t.Errorf("Expected error for non-existent user, got nil")
 	}
@@ -317,3 +335,32 @@ func TestDeleteSavingsData_UserNotFound(t *testing.T) {
 		t.Errorf("Expected error message '%s', got '%s'", expectedError, err.Error())
 	}
 }
+
+func TestHandleUpdateSavings_Currency(t *testing.T) {
+	clearTestData()
+
+	update := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/savings/update", strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		handleUpdateSavings(rr, req)
+		return rr
+	}
+
+	rr := update(`{"user_id": "test_user_fx", "goal": 1000}`)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"currency":"EUR"`) {
+		t.Fatalf("New goal: %d %s", rr.Code, rr.Body.String())
+	}
+
+	rr = update(`{"user_id": "test_user_fx", "currency": "usd"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Currency change: %d %s", rr.Code, rr.Body.String())
+	}
+	savings, err := fetchSavingsData("test_user_fx")
+	if err != nil || savings.Currency != "USD" || savings.Goal != 100000 {
+		t.Errorf("Stored %+v, %v", savings, err)
+	}
+
+	if rr := update(`{"user_id": "test_user_fx", "currency": "dollars"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("Invalid currency: %d", rr.Code)
+	}
+}
@@ -151,7 +151,7 @@ deploy_code() {
             
             echo "Compilando aplicación..."
             if [ -f "main.go" ]; then
-                go build -o main .
+                go build -tags sqlite_fts5 -o main .
             fi
             
             # Compilar microservicios
@@ -160,7 +160,7 @@ deploy_code() {
                 service_name=\$(basename "\$dir")
                 echo "Compilando \$service_name..."
                 cd "\$dir"
-                go build -o "\$service_name" .
+                go build -tags sqlite_fts5 -o "\$service_name" .
                 cd - > /dev/null
             done
         fi
@@ -2,4 +2,11 @@ module backend/signin
 
 go 1.21
 
-require github.com/mattn/go-sqlite3 v1.14.27
+require (
+	backend/common v0.0.0
+	github.com/mattn/go-sqlite3 v1.14.27
+)
+
+require golang.org/x/crypto v0.33.0 // indirect
+
+replace backend/common => ../common
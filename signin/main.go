@@ -9,11 +9,21 @@ import (
 	"path/filepath"
 	"time"
 
+	"backend/common/auth"
+	"backend/common/identity"
+	"backend/common/password"
+	"backend/common/throttle"
+	"backend/common/twofactor"
+
 	_ "github.com/mattn/go-sqlite3"
 )
 
 var (
-	db *sql.DB
+	db         *sql.DB
+	sessions   *auth.Manager
+	guard      *throttle.Guard
+	twoFactor  *twofactor.Store
+	identities *identity.Store
 )
 
 type User struct {
@@ -37,9 +47,14 @@ type SignInRequest struct {
 }
 
 type SignInResponse struct {
-	Success bool        `json:"success"`
-	Message string      `json:"message,omitempty"`
-	User    interface{} `json:"user,omitempty"`
+	Success bool            `json:"success"`
+	Message string          `json:"message,omitempty"`
+	User    interface{}     `json:"user,omitempty"`
+	Session *auth.TokenPair `json:"session,omitempty"`
+	// Set instead of User and Session when the account has 2FA enabled;
+	// the challenge is completed through /signin/2fa.
+	TwoFactorRequired bool   `json:"two_factor_required,omitempty"`
+	ChallengeToken    string `json:"challenge_token,omitempty"`
 }
 
 func init() {
@@ -70,9 +85,35 @@ func init() {
 }
 
 func main() {
+	// Sessions are shared with google_auth and apple-auth through auth_sessions
+	var err error
+	sessions, err = auth.NewManagerFromEnv(db)
+	if err != nil {
+		log.Fatalf("Failed to initialize sessions: %v", err)
+	}
+
+	// Failed sign-ins and email lookups are rate limited per account and IP
+	guard, err = throttle.NewGuard(db, throttle.PolicyFromEnv())
+	if err != nil {
+		log.Fatalf("Failed to initialize login throttling: %v", err)
+	}
+
+	twoFactor, err = twofactor.NewStore(db)
+	if err != nil {
+		log.Fatalf("Failed to initialize two-factor authentication: %v", err)
+	}
+
+	identities, err = identity.NewStore(db)
+	if err != nil {
+		log.Fatalf("Failed to initialize identities: %v", err)
+	}
+
 	// Set up CORS middleware
 	http.HandleFunc("/signin", corsMiddleware(handleSignIn))
 	http.HandleFunc("/signin/check-email", corsMiddleware(handleCheckEmail))
+	http.HandleFunc("/signin/2fa", corsMiddleware(handleTwoFactorSignIn))
+	http.HandleFunc("/auth/refresh", corsMiddleware(handleRefresh))
+	http.HandleFunc("/auth/logout", corsMiddleware(handleLogout))
 
 	log.Println("SignIn service started on :8084")
 	log.Fatal(http.ListenAndServe(":8084", nil))
@@ -116,18 +157,18 @@ func handleCheckEmail(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if email exists
-	var exists bool
-	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE email = ?)", req.Email).Scan(&exists)
-	if err != nil {
-		log.Printf("Database error: %v", err)
-		http.Error(w, "Database error", http.StatusInternalServerError)
+	if wait, err := guard.RequestRetryAfter("signin/check-email", req.Email, auth.ClientIP(r)); err != nil {
+		log.Printf("Error checking request rate: %v", err)
+	} else if wait > 0 {
+		w.Header().Set("Retry-After", throttle.RetryAfterSeconds(wait))
+		http.Error(w, "Too many requests", http.StatusTooManyRequests)
 		return
 	}
 
-	// Return response
+	// Always answer "exists" so this endpoint cannot be used to enumerate
+	// accounts; a wrong email simply fails at the password step.
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(EmailCheckResponse{Exists: exists})
+	json.NewEncoder(w).Encode(EmailCheckResponse{Exists: true})
 }
 
 func handleSignIn(w http.ResponseWriter, r *http.Request) {
@@ -153,42 +194,16 @@ func handleSignIn(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if user exists and password is correct
-	var user User
-	var storedPassword sql.NullString // Use NullString to handle NULL values safely
-	var name sql.NullString
-	var givenName sql.NullString
-	var familyName sql.NullString
-	var picture sql.NullString
-	var locale sql.NullString
-
-	err := db.QueryRow(`
-		SELECT id, email, password, name, given_name, family_name, 
-		picture, locale, verified_email, created_at, updated_at 
-		FROM users 
-		WHERE email = ?
-	`, req.Email).Scan(
-		&user.ID,
-		&user.Email,
-		&storedPassword, // This now handles NULL values properly
-		&name,
-		&givenName,
-		&familyName,
-		&picture,
-		&locale,
-		&user.VerifiedEmail,
-		&user.CreatedAt,
-		&user.UpdatedAt,
-	)
+	clientIP := auth.ClientIP(r)
+	if !checkLoginAllowed(w, req.Email, clientIP) {
+		return
+	}
 
-	// Convert NullString values to regular strings for User struct
-	user.Name = name.String
-	user.GivenName = givenName.String
-	user.FamilyName = familyName.String
-	user.Picture = picture.String
-	user.Locale = locale.String
+	// Check if user exists and password is correct
+	user, storedPassword, err := scanUser(db.QueryRow(userQuery+" WHERE email = ?", req.Email))
 
 	if err == sql.ErrNoRows {
+		recordLoginAttempt(req.Email, clientIP, false, "unknown_email")
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusUnauthorized)
 		json.NewEncoder(w).Encode(SignInResponse{
@@ -211,6 +226,7 @@ func handleSignIn(w http.ResponseWriter, r *http.Request) {
 	// Handle NULL or empty password field
 	if !storedPassword.Valid || storedPassword.String == "" {
 		log.Printf("User %s has no password set (NULL or empty)", req.Email)
+		recordLoginAttempt(req.Email, clientIP, false, "no_password")
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusUnauthorized)
 		json.NewEncoder(w).Encode(SignInResponse{
@@ -220,9 +236,9 @@ func handleSignIn(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// In a production app, you would use a secure password comparison
-	// This is a simple string comparison for demonstration
-	if storedPassword.String != req.Password {
+	matched, needsRehash := password.Verify(storedPassword.String, req.Password)
+	if !matched {
+		recordLoginAttempt(req.Email, clientIP, false, "bad_password")
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusUnauthorized)
 		json.NewEncoder(w).Encode(SignInResponse{
@@ -232,6 +248,27 @@ func handleSignIn(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// With 2FA on, the password alone is not a successful sign-in yet
+	twoFactorEnabled, err := twoFactor.Enabled(user.ID)
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(SignInResponse{
+			Success: false,
+			Message: "Database error occurred",
+		})
+		return
+	}
+	if !twoFactorEnabled {
+		recordLoginAttempt(req.Email, clientIP, true, "")
+	}
+
+	// Migrate legacy plaintext (or outdated) hashes now that we know the password
+	if needsRehash {
+		rehashPassword(user.ID, req.Password)
+	}
+
 	// Update last login time
 	_, err = db.Exec("UPDATE users SET updated_at = CURRENT_TIMESTAMP WHERE id = ?", user.ID)
 	if err != nil {
@@ -251,12 +288,98 @@ func handleSignIn(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if twoFactorEnabled {
+		sendTwoFactorChallenge(w, user)
+		return
+	}
+
+	startSession(w, r, user)
+}
+
+// startSession issues the session tokens of a fully authenticated user and
+// writes the sign-in response.
+func startSession(w http.ResponseWriter, r *http.Request, user User) {
+	tokens, err := sessions.IssueTokens(user.ID, r.UserAgent(), auth.ClientIP(r))
+	if err != nil {
+		log.Printf("Failed to issue session tokens: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(SignInResponse{
+			Success: false,
+			Message: "Could not start session",
+		})
+		return
+	}
+
+	if err := identities.Touch(identity.ProviderPassword, identity.PasswordSubject(user.ID)); err != nil {
+		log.Printf("Failed to update identity: %v", err)
+	}
+
 	// Return user data
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(SignInResponse{
 		Success: true,
 		User:    user,
+		Session: tokens,
 	})
 
 	log.Printf("User %s logged in successfully", user.Email)
 }
+
+// userQuery selects the columns read by scanUser.
+const userQuery = `
+	SELECT id, email, password, name, given_name, family_name,
+	picture, locale, verified_email, created_at, updated_at
+	FROM users`
+
+// scanUser reads a row of userQuery. The stored password is returned apart
+// so it never ends up in the User sent to the client.
+func scanUser(row *sql.Row) (User, sql.NullString, error) {
+	var user User
+	var storedPassword sql.NullString // Use NullString to handle NULL values safely
+	var name sql.NullString
+	var givenName sql.NullString
+	var familyName sql.NullString
+	var picture sql.NullString
+	var locale sql.NullString
+
+	err := row.Scan(
+		&user.ID,
+		&user.Email,
+		&storedPassword,
+		&name,
+		&givenName,
+		&familyName,
+		&picture,
+		&locale,
+		&user.VerifiedEmail,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+	)
+
+	// Convert NullString values to regular strings for User struct
+	user.Name = name.String
+	user.GivenName = givenName.String
+	user.FamilyName = familyName.String
+	user.Picture = picture.String
+	user.Locale = locale.String
+
+	return user, storedPassword, err
+}
+
+// rehashPassword stores a fresh hash of plain for userID. Failures are only
+// logged: the user is already authenticated and will be migrated next time.
+func rehashPassword(userID int, plain string) {
+	hashed, err := password.Hash(plain)
+	if err != nil {
+		log.Printf("Failed to rehash password for user %d: %v", userID, err)
+		return
+	}
+
+	_, err = db.Exec("UPDATE users SET password = ? WHERE id = ?", hashed, userID)
+	if err != nil {
+		log.Printf("Failed to store rehashed password for user %d: %v", userID, err)
+		return
+	}
+	log.Printf("Migrated password hash for user %d", userID)
+}
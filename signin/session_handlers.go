@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"backend/common/auth"
+)
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token,omitempty"`
+	AllDevices   bool   `json:"all_devices,omitempty"`
+}
+
+// handleRefresh exchanges a refresh token for a new token pair. The old
+// refresh token is rotated out and cannot be used again.
+func handleRefresh(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		sendSessionResponse(w, http.StatusBadRequest, SignInResponse{
+			Success: false,
+			Message: "Refresh token is required",
+		})
+		return
+	}
+
+	tokens, err := sessions.Refresh(req.RefreshToken)
+	if err != nil {
+		if err != auth.ErrInvalidToken && err != auth.ErrExpiredToken && err != auth.ErrRevoked {
+			log.Printf("Failed to refresh session: %v", err)
+		}
+		sendSessionResponse(w, http.StatusUnauthorized, SignInResponse{
+			Success: false,
+			Message: "Invalid or expired refresh token",
+		})
+		return
+	}
+
+	sendSessionResponse(w, http.StatusOK, SignInResponse{
+		Success: true,
+		Session: tokens,
+	})
+}
+
+// handleLogout revokes the caller's session. It accepts either the access
+// token in the Authorization header or the refresh token in the body, so a
+// client whose access token already expired can still sign out.
+// With all_devices every session of the user is revoked.
+func handleLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req LogoutRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			sendSessionResponse(w, http.StatusBadRequest, SignInResponse{
+				Success: false,
+				Message: "Invalid request body",
+			})
+			return
+		}
+	}
+
+	var err error
+	if token := auth.BearerToken(r); token != "" {
+		claims, authErr := sessions.Authenticate(token)
+		if authErr != nil {
+			sendSessionResponse(w, http.StatusUnauthorized, SignInResponse{
+				Success: false,
+				Message: "Invalid or expired token",
+			})
+			return
+		}
+
+		if req.AllDevices {
+			var userID int
+			userID, err = strconv.Atoi(claims.Subject)
+			if err == nil {
+				err = sessions.RevokeAllSessions(userID)
+			}
+		} else {
+			err = sessions.RevokeSession(claims.SessionID)
+		}
+	} else if req.RefreshToken != "" {
+		err = sessions.RevokeRefreshToken(req.RefreshToken)
+	} else {
+		sendSessionResponse(w, http.StatusUnauthorized, SignInResponse{
+			Success: false,
+			Message: "Authorization token is required",
+		})
+		return
+	}
+
+	if err != nil {
+		log.Printf("Failed to revoke session: %v", err)
+		sendSessionResponse(w, http.StatusInternalServerError, SignInResponse{
+			Success: false,
+			Message: "Failed to log out",
+		})
+		return
+	}
+
+	sendSessionResponse(w, http.StatusOK, SignInResponse{
+		Success: true,
+		Message: "Logged out successfully",
+	})
+}
+
+func sendSessionResponse(w http.ResponseWriter, statusCode int, response SignInResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"backend/common/throttle"
+)
+
+// checkLoginAllowed answers 429 with a Retry-After header while the account
+// or the caller IP is locked out after too many failed sign-ins.
+func checkLoginAllowed(w http.ResponseWriter, email, clientIP string) bool {
+	wait, err := guard.LoginRetryAfter("signin", email, clientIP)
+	if err != nil {
+		// Fail open: a broken audit table must not lock everybody out
+		log.Printf("Error checking login lockout: %v", err)
+		return true
+	}
+	if wait == 0 {
+		return true
+	}
+
+	log.Printf("Sign-in for %s from %s blocked for %v", throttle.Normalize(email), clientIP, wait)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", throttle.RetryAfterSeconds(wait))
+	w.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(w).Encode(SignInResponse{
+		Success: false,
+		Message: "Too many failed attempts. Please try again later.",
+	})
+	return false
+}
+
+// recordLoginAttempt writes a sign-in attempt to login_attempts. Failures to
+// record are only logged.
+func recordLoginAttempt(email, clientIP string, success bool, reason string) {
+	if err := guard.Record("signin", email, clientIP, success, reason); err != nil {
+		log.Printf("Failed to record login attempt: %v", err)
+	}
+}
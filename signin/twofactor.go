@@ -0,0 +1,111 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"backend/common/auth"
+	"backend/common/twofactor"
+)
+
+type TwoFactorSignInRequest struct {
+	ChallengeToken string `json:"challenge_token"`
+	// Code is a 6-digit TOTP code or one of the recovery codes
+	Code string `json:"code"`
+}
+
+// sendTwoFactorChallenge answers a correct password on an account with 2FA
+// enabled: no session yet, only a short-lived challenge for /signin/2fa.
+func sendTwoFactorChallenge(w http.ResponseWriter, user User) {
+	challenge, err := twoFactor.CreateChallenge(user.ID)
+	if err != nil {
+		log.Printf("Failed to create two-factor challenge: %v", err)
+		sendSessionResponse(w, http.StatusInternalServerError, SignInResponse{
+			Success: false,
+			Message: "Could not start session",
+		})
+		return
+	}
+
+	sendSessionResponse(w, http.StatusOK, SignInResponse{
+		Success:           false,
+		Message:           "Two-factor authentication code required",
+		TwoFactorRequired: true,
+		ChallengeToken:    challenge,
+	})
+}
+
+// handleTwoFactorSignIn completes a sign-in started by handleSignIn with a
+// TOTP or recovery code.
+func handleTwoFactorSignIn(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req TwoFactorSignInRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ChallengeToken == "" || req.Code == "" {
+		sendSessionResponse(w, http.StatusBadRequest, SignInResponse{
+			Success: false,
+			Message: "Challenge token and code are required",
+		})
+		return
+	}
+
+	clientIP := auth.ClientIP(r)
+	if !checkLoginAllowed(w, "", clientIP) {
+		return
+	}
+
+	userID, err := twoFactor.CompleteChallenge(req.ChallengeToken, req.Code)
+	if err != nil {
+		handleTwoFactorFailure(w, userID, clientIP, err)
+		return
+	}
+
+	user, _, err := scanUser(db.QueryRow(userQuery+" WHERE id = ?", userID))
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		sendSessionResponse(w, http.StatusInternalServerError, SignInResponse{
+			Success: false,
+			Message: "Database error occurred",
+		})
+		return
+	}
+
+	recordLoginAttempt(user.Email, clientIP, true, "")
+	startSession(w, r, user)
+}
+
+// handleTwoFactorFailure counts wrong codes as failed sign-ins of the
+// account, so guessing codes across many challenges ends in a lockout of the
+// password step too.
+func handleTwoFactorFailure(w http.ResponseWriter, userID int, clientIP string, err error) {
+	switch err {
+	case twofactor.ErrInvalidCode:
+		var email string
+		if dbErr := db.QueryRow("SELECT email FROM users WHERE id = ?", userID).Scan(&email); dbErr != nil && dbErr != sql.ErrNoRows {
+			log.Printf("Database error: %v", dbErr)
+		}
+		recordLoginAttempt(email, clientIP, false, "bad_2fa_code")
+		sendSessionResponse(w, http.StatusUnauthorized, SignInResponse{
+			Success:           false,
+			Message:           "Invalid authentication code",
+			TwoFactorRequired: true,
+		})
+	case twofactor.ErrInvalidChallenge, twofactor.ErrTooManyAttempts, twofactor.ErrNotEnabled:
+		recordLoginAttempt("", clientIP, false, "bad_2fa_challenge")
+		sendSessionResponse(w, http.StatusUnauthorized, SignInResponse{
+			Success: false,
+			Message: "Sign-in expired. Please sign in again.",
+		})
+	default:
+		log.Printf("Error completing two-factor sign-in: %v", err)
+		sendSessionResponse(w, http.StatusInternalServerError, SignInResponse{
+			Success: false,
+			Message: "Database error occurred",
+		})
+	}
+}
@@ -6,29 +6,38 @@ import (
 	"database/sql"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"image"
 	"image/jpeg"
 	"image/png"
-	"io"
 	"log"
 	"math/big"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
-	"time"
 
 	"text/template"
 
+	"backend/common/auth"
+	"backend/common/identity"
+	"backend/common/mail"
+	"backend/common/password"
+	"backend/common/throttle"
+
 	"github.com/chai2010/webp"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/nfnt/resize"
-	"gopkg.in/gomail.v2"
 )
 
 var (
-	db *sql.DB
+	db         *sql.DB
+	guard      *throttle.Guard
+	identities *identity.Store
+	// outbox is nil when no mail transport is configured
+	outbox *mail.Outbox
+
 	// Email configuration - will be loaded from config.json
 	smtpHost     string
 	smtpPort     int
@@ -79,23 +88,6 @@ type Config struct {
 	} `json:"app"`
 }
 
-type User struct {
-	ID               int       `json:"id"`
-	GoogleID         string    `json:"google_id"`
-	Email            string    `json:"email"`
-	Password         string    `json:"password,omitempty"` // Password is omitempty to not return it to client
-	Name             string    `json:"name"`
-	GivenName        string    `json:"given_name"`
-	FamilyName       string    `json:"family_name"`
-	Picture          string    `json:"picture"`                      // URL for Google users
-	ProfileImageBlob string    `json:"profile_image_blob,omitempty"` // Base64 encoded WebP for manual signup
-	Locale           string    `json:"locale"`
-	VerifiedEmail    bool      `json:"verified_email"`
-	VerificationCode string    `json:"verification_code,omitempty"` // Code for email verification
-	CreatedAt        time.Time `json:"created_at"`
-	UpdatedAt        time.Time `json:"updated_at"`
-}
-
 type SignupRequest struct {
 	Email         string `json:"email"`
 	Password      string `json:"password,omitempty"`
@@ -115,6 +107,15 @@ type EmailCheckResponse struct {
 	Exists bool `json:"exists"`
 }
 
+// smtpSettings returns the SMTP relay from config.json. The placeholder
+// host of the defaults means SMTP is not configured.
+func smtpSettings() mail.SMTPConfig {
+	if smtpHost == "smtp.example.com" {
+		return mail.SMTPConfig{}
+	}
+	return mail.SMTPConfig{Host: smtpHost, Port: smtpPort, Username: smtpUsername, Password: smtpPassword}
+}
+
 func loadConfig() {
 	// Get the current working directory
 	cwd, err := os.Getwd()
@@ -348,6 +349,30 @@ func init() {
 }
 
 func main() {
+	if err := createVerificationTable(); err != nil {
+		log.Fatalf("Failed to prepare verification codes: %v", err)
+	}
+
+	// Email lookups are rate limited per IP
+	var err error
+	guard, err = throttle.NewGuard(db, throttle.PolicyFromEnv())
+	if err != nil {
+		log.Fatalf("Failed to initialize request throttling: %v", err)
+	}
+
+	identities, err = identity.NewStore(db)
+	if err != nil {
+		log.Fatalf("Failed to initialize identities: %v", err)
+	}
+
+	// Emails go through the outbox, so a mail outage does not fail signup
+	outbox, err = mail.StartFromEnv(db, smtpSettings())
+	if errors.Is(err, mail.ErrNotConfigured) {
+		log.Println("Mail not configured. Verification emails will not be sent.")
+	} else if err != nil {
+		log.Fatalf("Failed to initialize mail outbox: %v", err)
+	}
+
 	// Setup HTTP handlers
 	http.HandleFunc("/signup/register", corsMiddleware(handleSignup))
 	http.HandleFunc("/signup/check-email", corsMiddleware(handleCheckEmail))
@@ -392,18 +417,18 @@ func handleCheckEmail(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if email exists
-	var exists bool
-	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE email = ?)", req.Email).Scan(&exists)
-	if err != nil {
-		log.Printf("Database error: %v", err)
-		http.Error(w, "Database error", http.StatusInternalServerError)
+	if wait, err := guard.RequestRetryAfter("signup/check-email", req.Email, auth.ClientIP(r)); err != nil {
+		log.Printf("Error checking request rate: %v", err)
+	} else if wait > 0 {
+		w.Header().Set("Retry-After", throttle.RetryAfterSeconds(wait))
+		http.Error(w, "Too many requests", http.StatusTooManyRequests)
 		return
 	}
 
-	// Return response
+	// The answer no longer depends on the database so this endpoint cannot be
+	// used to enumerate accounts. Duplicates are still rejected on register.
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(EmailCheckResponse{Exists: exists})
+	json.NewEncoder(w).Encode(EmailCheckResponse{Exists: false})
 }
 
 // Helper function to generate a random verification code
@@ -510,8 +535,8 @@ func processImage(base64Image string) (string, error) {
 	return base64.StdEncoding.EncodeToString(webpBuf.Bytes()), nil
 }
 
-// Send verification email with language support
-func sendVerificationEmail(toEmail, verificationCode, userName, language string) error {
+// Queue verification email with language support
+func queueVerificationEmail(toEmail, verificationCode, userName, language string) error {
 	// Validate email before attempting to send
 	if toEmail == "" {
 		return fmt.Errorf("cannot send verification email: email address is empty")
@@ -531,7 +556,7 @@ func sendVerificationEmail(toEmail, verificationCode, userName, language string)
 	emailTemplate := getVerificationEmailTemplate(language)
 
 	// Log the values for debugging
-	log.Printf("Sending verification email with OTP - Email: %s, Code: %s, Name: %s, Language: %s", toEmail, verificationCode, userName, language)
+	log.Printf("Queueing verification email - Email: %s, Name: %s, Language: %s", toEmail, userName, language)
 
 	// Read the herobudgeticon.png image for embedding
 	imgPath := filepath.Join("..", "..", "assets", "images", "herobudgeticon.png")
@@ -542,17 +567,18 @@ func sendVerificationEmail(toEmail, verificationCode, userName, language string)
 	}
 
 	// Create email message
-	m := gomail.NewMessage()
-	m.SetHeader("From", fromEmail)
-	m.SetHeader("To", toEmail)
-	m.SetHeader("Subject", emailTemplate.Subject)
+	m := mail.Message{
+		From:    fromEmail,
+		To:      []string{toEmail},
+		Subject: emailTemplate.Subject,
+	}
 
 	// Create HTML with or without image
 	var imageTag string
 	if imgData != nil {
 		// Embed the image and create HTML with the CID
 		imgFilename := filepath.Base(imgPath)
-		m.Embed(imgPath)
+		m.Embeds = append(m.Embeds, mail.Embed{Name: imgFilename, Data: imgData})
 		imageTag = fmt.Sprintf(`<img src="cid:%s" alt="Hero Budget" style="max-width: 150px; margin: 20px 0;">`, imgFilename)
 	} else {
 		imageTag = ""
@@ -620,17 +646,14 @@ func sendVerificationEmail(toEmail, verificationCode, userName, language string)
 	)
 
 	// Set the email body
-	m.SetBody("text/html", emailBody)
+	m.HTML = emailBody
 
-	// Set up email sending
-	d := gomail.NewDialer(smtpHost, smtpPort, smtpUsername, smtpPassword)
-
-	// Send the email
-	if err := d.DialAndSend(m); err != nil {
-		return fmt.Errorf("failed to send verification email: %v", err)
+	// The outbox delivers in the background and retries if the mail server is down
+	if _, err := outbox.Enqueue(m); err != nil {
+		return fmt.Errorf("failed to queue verification email: %v", err)
 	}
 
-	log.Printf("Verification email with OTP sent successfully to %s in language: %s", toEmail, language)
+	log.Printf("Verification email queued for %s in language: %s", toEmail, language)
 	return nil
 }
 
@@ -643,24 +666,7 @@ func handleSignup(w http.ResponseWriter, r *http.Request) {
 	// Log request headers
 	log.Println("Received signup request")
 
-	// Read and log the raw request body for debugging
-	bodyBytes, err := io.ReadAll(r.Body)
-	if err != nil {
-		log.Printf("Error reading body: %v", err)
-		http.Error(w, "Error reading request body", http.StatusBadRequest)
-		return
-	}
-
-	// Create a new reader from the bytes for JSON decoding
-	r.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
-
-	// Limit what we log to avoid huge outputs while still debugging the issue
-	if len(bodyBytes) > 1000 {
-		log.Printf("Request body (truncated): %s...", bodyBytes[:1000])
-	} else {
-		log.Printf("Request body: %s", bodyBytes)
-	}
-
+	// The raw body is not logged: it contains the plaintext password
 	var req SignupRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		log.Printf("Invalid request body: %v", err)
@@ -677,10 +683,41 @@ func handleSignup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if wait, err := guard.RequestRetryAfter("signup/register", req.Email, auth.ClientIP(r)); err != nil {
+		log.Printf("Error checking request rate: %v", err)
+	} else if wait > 0 {
+		w.Header().Set("Retry-After", throttle.RetryAfterSeconds(wait))
+		http.Error(w, "Too many requests", http.StatusTooManyRequests)
+		return
+	}
+
 	// Log parsed request without sensitive data
 	log.Printf("Parsed request: email=%s, name=%s, given_name=%s, family_name=%s, locale=%s, verified_email=%v, has_picture=%v",
 		req.Email, req.Name, req.GivenName, req.FamilyName, req.Locale, req.VerifiedEmail, req.PictureBase64 != "")
 
+	// Emails that already have an account get the same answer as new ones,
+	// so this endpoint cannot be used to find out who has an account. Their
+	// owner simply gets no code. The password is hashed either way so the
+	// answer takes as long.
+	created := map[string]interface{}{
+		"success": true,
+		"message": "Check your email for the verification code",
+		"email":   req.Email,
+	}
+
+	// Hash the password before storing it; accounts without a password
+	// (created from a social profile) keep the column empty
+	var hashedPassword string
+	var err error
+	if req.Password != "" {
+		hashedPassword, err = password.Hash(req.Password)
+		if err != nil {
+			log.Printf("Failed to hash password: %v", err)
+			http.Error(w, "Failed to create user", http.StatusInternalServerError)
+			return
+		}
+	}
+
 	// Check if email already exists
 	var exists bool
 	err = db.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE email = ?)", req.Email).Scan(&exists)
@@ -693,8 +730,7 @@ func handleSignup(w http.ResponseWriter, r *http.Request) {
 	if exists {
 		log.Printf("User with email %s already exists", req.Email)
 		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusConflict) // 409 Conflict
-		json.NewEncoder(w).Encode(map[string]string{"error": "User with this email already exists"})
+		json.NewEncoder(w).Encode(created)
 		return
 	}
 
@@ -717,7 +753,6 @@ func handleSignup(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Insert new user
-	// In a real app, you would hash the password before storing
 	log.Printf("Inserting new user: email=%s, name=%s, given_name=%s, family_name=%s",
 		req.Email, req.Name, req.GivenName, req.FamilyName)
 
@@ -745,31 +780,48 @@ func handleSignup(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Generate verification code
-	verificationCode := generateVerificationCode()
+	// The user and its email/password identity are created together
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Failed to start transaction: %v", err)
+		http.Error(w, "Failed to create user", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
 
-	result, err := db.Exec(`
+	result, err := tx.Exec(`
 		INSERT INTO users (
 			email, password, name, given_name, family_name, 
-			picture, profile_image_blob, locale, verified_email,
-			verification_code
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		req.Email, req.Password, name, givenName,
+			picture, profile_image_blob, locale, verified_email
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		req.Email, hashedPassword, name, givenName,
 		familyName, req.PictureBase64, processedImageBase64, req.Locale, false, // Set verified_email to false by default
-		verificationCode,
 	)
 	if err != nil {
 		log.Printf("Failed to create user: %v", err)
 		log.Printf("Request data: email=%s, name=%s", req.Email, req.Name)
-		http.Error(w, fmt.Sprintf("Failed to create user: %v", err), http.StatusInternalServerError)
+		http.Error(w, "Failed to create user", http.StatusInternalServerError)
 		return
 	}
 
 	userID, _ := result.LastInsertId()
+	if hashedPassword != "" {
+		err = identities.LinkTx(tx, int(userID), identity.ProviderPassword, identity.PasswordSubject(int(userID)), req.Email)
+		if err != nil {
+			log.Printf("Failed to create password identity: %v", err)
+			http.Error(w, "Failed to create user", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("Failed to commit new user: %v", err)
+		http.Error(w, "Failed to create user", http.StatusInternalServerError)
+		return
+	}
 	log.Printf("User created with ID: %d", userID)
 
 	// Send verification email
-	if smtpHost != "smtp.example.com" { // Only send if SMTP is configured
+	if outbox != nil { // Only send if mail is configured
 		// Log name for debugging
 		log.Printf("User name for email: '%s'", name)
 
@@ -779,44 +831,23 @@ func handleSignup(w http.ResponseWriter, r *http.Request) {
 			userNameForEmail = "there" // Default fallback
 		}
 
-		err = sendVerificationEmail(req.Email, verificationCode, userNameForEmail, req.Locale)
+		verificationCode, err := issueVerificationCode(int(userID), req.Email)
+		if err == nil {
+			err = queueVerificationEmail(req.Email, verificationCode, userNameForEmail, req.Locale)
+		}
 		if err != nil {
 			log.Printf("Warning: Failed to send verification email: %v", err)
 			// Continue even if email sending fails
 		} else {
-			log.Printf("Verification email sent to %s", req.Email)
+			log.Printf("Verification email queued for %s", req.Email)
 		}
 	} else {
-		log.Printf("SMTP not configured. Skipping verification email.")
-	}
-
-	// Fetch the inserted user to return
-	var user User
-	err = db.QueryRow(`
-		SELECT id, email, name, given_name, family_name, picture, profile_image_blob, locale, verified_email, created_at, updated_at 
-		FROM users WHERE id = ?`, userID).Scan(
-		&user.ID,
-		&user.Email,
-		&user.Name,
-		&user.GivenName,
-		&user.FamilyName,
-		&user.Picture,
-		&user.ProfileImageBlob,
-		&user.Locale,
-		&user.VerifiedEmail,
-		&user.CreatedAt,
-		&user.UpdatedAt,
-	)
-	if err != nil {
-		log.Printf("Failed to fetch created user: %v", err)
-		http.Error(w, "Failed to fetch created user", http.StatusInternalServerError)
-		return
+		log.Printf("Mail not configured. Skipping verification email.")
 	}
 
-	// Return the user object (without password and verification code)
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(user)
-	log.Printf("User registration successful for ID: %d", user.ID)
+	json.NewEncoder(w).Encode(created)
+	log.Printf("User registration successful for ID: %d", userID)
 }
 
 // Add a new endpoint to handle email verification
@@ -854,85 +885,29 @@ func handleVerifyEmail(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Log the verification attempt with additional parameters
-	log.Printf("Attempting to verify email - Code: %s, UserID: %s, Email: %s",
-		code, userID, emailParam)
+	// Codes are only unique per user, so the user has to be identified
+	if userID == "" && emailParam == "" {
+		http.Error(w, "Either user_id or email is required", http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("Attempting to verify email - UserID: %s, Email: %s", userID, emailParam)
 
-	// First try to find the user with the verification code
 	var dbUserID int
 	var email string
-	var verificationCode string
 	var verified bool
-
-	err := db.QueryRow(
-		"SELECT id, email, verification_code, verified_email FROM users WHERE verification_code = ?",
-		code,
-	).Scan(&dbUserID, &email, &verificationCode, &verified)
-
-	// If verification code not found but user_id or email is provided,
-	// try to find the user with those parameters
-	if err == sql.ErrNoRows && (userID != "" || emailParam != "") {
-		log.Printf("Verification code not found. Trying to find user with userID or email")
-
-		var query string
-		var queryParams []interface{}
-
-		if userID != "" {
-			query = "SELECT id, email, verification_code, verified_email FROM users WHERE id = ?"
-			queryParams = []interface{}{userID}
-		} else {
-			query = "SELECT id, email, verification_code, verified_email FROM users WHERE email = ?"
-			queryParams = []interface{}{emailParam}
-		}
-
-		err = db.QueryRow(query, queryParams...).Scan(&dbUserID, &email, &verificationCode, &verified)
-
-		if err == sql.ErrNoRows {
-			log.Printf("User not found with userID=%s or email=%s", userID, emailParam)
-			http.Error(w, "User not found", http.StatusNotFound)
-			return
-		} else if err != nil {
-			log.Printf("Database error looking up user: %v", err)
-			http.Error(w, "Database error", http.StatusInternalServerError)
-			return
-		}
-
-		// If we found the user but verification codes don't match
-		if verificationCode != code {
-			log.Printf("Found user but verification code doesn't match. Expected: %s, Got: %s",
-				verificationCode, code)
-
-			// Update verification code in database for next attempt
-			_, err = db.Exec("UPDATE users SET verification_code = ? WHERE id = ?", code, dbUserID)
-			if err != nil {
-				log.Printf("Failed to update verification code: %v", err)
-			} else {
-				log.Printf("Updated verification code for user ID: %d", dbUserID)
-			}
-
-			// If user is already verified, we can still return success
-			if verified {
-				log.Printf("User ID: %d is already verified. Returning success.", dbUserID)
-				w.Header().Set("Content-Type", "application/json")
-				json.NewEncoder(w).Encode(map[string]interface{}{
-					"success": true,
-					"message": "Email already verified",
-					"user_id": dbUserID,
-					"email":   email,
-				})
-				return
-			}
-
-			// Otherwise, return error to trigger resend
-			http.Error(w, "Invalid verification code", http.StatusNotFound)
-			return
-		}
-	} else if err == sql.ErrNoRows {
-		log.Printf("Invalid verification code: %s - User not found", code)
+	var err error
+	if userID != "" {
+		err = db.QueryRow("SELECT id, email, verified_email FROM users WHERE id = ?", userID).Scan(&dbUserID, &email, &verified)
+	} else {
+		err = db.QueryRow("SELECT id, email, verified_email FROM users WHERE email = ?", emailParam).Scan(&dbUserID, &email, &verified)
+	}
+	if err == sql.ErrNoRows {
+		log.Printf("User not found with userID=%s or email=%s", userID, emailParam)
 		http.Error(w, "Invalid verification code", http.StatusNotFound)
 		return
 	} else if err != nil {
-		log.Printf("Database error looking up verification code: %v", err)
+		log.Printf("Database error looking up user: %v", err)
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
@@ -950,11 +925,13 @@ func handleVerifyEmail(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Log that we found the user
-	log.Printf("Found user ID: %d, email: %s for verification code: %s", dbUserID, email, code)
+	if err := checkVerificationCode(dbUserID, code); err != nil {
+		log.Printf("Verification failed for user ID %d: %v", dbUserID, err)
+		sendVerificationError(w, err)
+		return
+	}
 
 	// Update the user's verified_email status
-	// Do NOT clear the verification code so the app can still verify it
 	_, err = db.Exec(
 		"UPDATE users SET verified_email = ? WHERE id = ?",
 		true, dbUserID,
@@ -977,6 +954,21 @@ func handleVerifyEmail(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// sendVerificationError maps verification failures to responses.
+func sendVerificationError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errCodeInvalid):
+		http.Error(w, "Invalid verification code", http.StatusNotFound)
+	case errors.Is(err, errCodeExpired):
+		http.Error(w, "Verification code has expired. Please request a new one.", http.StatusGone)
+	case errors.Is(err, errCodeAttempts):
+		http.Error(w, "Too many attempts. Please request a new code.", http.StatusTooManyRequests)
+	default:
+		log.Printf("Verification error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+	}
+}
+
 // Add a new endpoint to handle resending verification emails
 func handleResendVerification(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
@@ -1002,89 +994,79 @@ func handleResendVerification(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if wait, err := guard.RequestRetryAfter("signup/resend-verification", req.Email, auth.ClientIP(r)); err != nil {
+		log.Printf("Error checking request rate: %v", err)
+	} else if wait > 0 {
+		w.Header().Set("Retry-After", throttle.RetryAfterSeconds(wait))
+		http.Error(w, "Too many requests", http.StatusTooManyRequests)
+		return
+	}
+
 	log.Printf("Resend verification request for user_id=%s, email=%s", req.UserID, req.Email)
 
-	// Look up the user
-	var userID int
-	var email string
-	var name string
-	var verificationCode string
-	var userLocale string
-	var query string
-	var queryParams []interface{}
+	// Unknown and already verified accounts get the same answer as the
+	// others, and problems sending the email are only logged, like on
+	// password reset
+	sent := map[string]interface{}{
+		"success": true,
+		"message": "Verification email sent",
+		"email":   req.Email,
+	}
 
-	if req.UserID != "" {
-		// If we have a user ID, use that for lookup
-		query = "SELECT id, email, name, verification_code, locale FROM users WHERE id = ?"
-		queryParams = []interface{}{req.UserID}
-	} else {
-		// Otherwise use email
-		query = "SELECT id, email, name, verification_code, locale FROM users WHERE email = ?"
-		queryParams = []interface{}{req.Email}
+	if err := resendVerificationEmail(req.UserID, req.Email, req.Locale); err != nil {
+		log.Printf("Verification email not sent again: %v", err)
 	}
 
-	err := db.QueryRow(query, queryParams...).Scan(&userID, &email, &name, &verificationCode, &userLocale)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sent)
+}
+
+// resendVerificationEmail issues a new code for the unverified user with
+// userID, or else email, and queues the email carrying it. A new code
+// replaces the previous one, within the cooldown and quota.
+func resendVerificationEmail(userID, email, locale string) error {
+	if outbox == nil {
+		return errors.New("mail is not configured")
+	}
 
+	var id int
+	var name sql.NullString
+	var userLocale sql.NullString
+	var verified bool
+	var err error
+	query := "SELECT id, email, name, locale, verified_email FROM users"
+	if userID != "" {
+		err = db.QueryRow(query+" WHERE id = ?", userID).Scan(&id, &email, &name, &userLocale, &verified)
+	} else {
+		err = db.QueryRow(query+" WHERE email = ?", email).Scan(&id, &email, &name, &userLocale, &verified)
+	}
 	if err == sql.ErrNoRows {
-		log.Printf("User not found for resend verification")
-		http.Error(w, "User not found", http.StatusNotFound)
-		return
+		return errors.New("user not found")
 	} else if err != nil {
-		log.Printf("Database error: %v", err)
-		http.Error(w, "Database error", http.StatusInternalServerError)
-		return
+		return err
+	}
+	if verified {
+		return fmt.Errorf("%s is already verified", email)
 	}
 
 	// Use the locale from the request if provided, otherwise use the user's stored locale
-	language := req.Locale
+	language := locale
 	if language == "" {
-		language = userLocale
+		language = userLocale.String
 	}
 	if language == "" {
 		language = "en" // Default to English
 	}
 
-	// Check if verification_code is empty - user might already be verified
-	if verificationCode == "" {
-		// Generate a new verification code
-		verificationCode = generateVerificationCode()
-
-		// Update the user with the new verification code
-		_, err = db.Exec(
-			"UPDATE users SET verification_code = ? WHERE id = ?",
-			verificationCode, userID,
-		)
-
-		if err != nil {
-			log.Printf("Failed to update verification code: %v", err)
-			http.Error(w, "Failed to update verification code", http.StatusInternalServerError)
-			return
-		}
+	verificationCode, err := issueVerificationCode(id, email)
+	if err != nil {
+		return fmt.Errorf("%s: %w", email, err)
 	}
-
-	// Send the verification email
-	if smtpHost != "smtp.example.com" { // Only send if SMTP is configured
-		err = sendVerificationEmail(email, verificationCode, name, language)
-		if err != nil {
-			log.Printf("Failed to send verification email: %v", err)
-			http.Error(w, "Failed to send verification email", http.StatusInternalServerError)
-			return
-		}
-		log.Printf("Verification email resent to %s", email)
-	} else {
-		log.Printf("SMTP not configured. Skipping verification email send.")
-		http.Error(w, "SMTP not configured", http.StatusInternalServerError)
-		return
+	if err := queueVerificationEmail(email, verificationCode, name.String, language); err != nil {
+		return err
 	}
-
-	// Return success response
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"success": true,
-		"message": "Verification email sent",
-		"email":   email,
-	})
+	log.Printf("Verification email queued again for %s", email)
+	return nil
 }
 
 // Add new endpoint to check verification status
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"backend/common/identity"
+	"backend/common/mail"
+	"backend/common/throttle"
+)
+
+// newSignupDB is newTestDB with the columns signup writes, throttling and
+// an outbox that keeps emails in memory
+func newSignupDB(t *testing.T) {
+	t.Helper()
+
+	newTestDB(t)
+	for _, column := range []string{"password", "name", "given_name", "family_name", "picture", "profile_image_blob", "locale"} {
+		if _, err := db.Exec(`ALTER TABLE users ADD COLUMN ` + column + ` TEXT`); err != nil {
+			t.Fatalf("Failed to add %s: %v", column, err)
+		}
+	}
+	var err error
+	if guard, err = throttle.NewGuard(db, throttle.PolicyFromEnv()); err != nil {
+		t.Fatalf("Failed to create guard: %v", err)
+	}
+	if identities, err = identity.NewStore(db); err != nil {
+		t.Fatalf("Failed to create identities: %v", err)
+	}
+	if outbox, err = mail.NewOutbox(db, mail.NewMemory()); err != nil {
+		t.Fatalf("Failed to create outbox: %v", err)
+	}
+	t.Cleanup(func() { outbox = nil })
+}
+
+// post calls handler with body as JSON and returns the status and message
+func post(t *testing.T, handler http.HandlerFunc, body interface{}) (int, string) {
+	t.Helper()
+
+	payload, _ := json.Marshal(body)
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(payload)))
+	var response struct {
+		Message string `json:"message"`
+	}
+	json.NewDecoder(w.Body).Decode(&response)
+	return w.Code, response.Message
+}
+
+func TestSignupAnswersTheSameForTakenEmails(t *testing.T) {
+	newSignupDB(t)
+
+	newCode, newMessage := post(t, handleSignup, SignupRequest{Email: "bob@example.com", Password: "S3cret-password!", Name: "Bob"})
+	takenCode, takenMessage := post(t, handleSignup, SignupRequest{Email: "ana@example.com", Password: "S3cret-password!", Name: "Ana"})
+	if newCode != http.StatusOK || takenCode != newCode || takenMessage != newMessage {
+		t.Errorf("New email = %d %q, taken email = %d %q", newCode, newMessage, takenCode, takenMessage)
+	}
+
+	var users, queued int
+	db.QueryRow(`SELECT COUNT(*) FROM users`).Scan(&users)
+	db.QueryRow(`SELECT COUNT(*) FROM mail_outbox`).Scan(&queued)
+	if users != 2 || queued != 1 {
+		t.Errorf("Expected 2 users and 1 email, got %d and %d", users, queued)
+	}
+}
+
+func TestResendAnswersTheSameForUnknownAccounts(t *testing.T) {
+	newSignupDB(t)
+	verificationCooldown = time.Hour
+	db.Exec(`INSERT INTO users (id, email, verified_email) VALUES (2, 'eva@example.com', 1)`)
+
+	knownCode, knownMessage := post(t, handleResendVerification, map[string]string{"email": "ana@example.com"})
+	for _, body := range []map[string]string{
+		{"email": "nobody@example.com"},
+		{"email": "eva@example.com"},
+		{"user_id": "99"},
+		// Within the cooldown
+		{"email": "ana@example.com"},
+	} {
+		if code, message := post(t, handleResendVerification, body); code != knownCode || message != knownMessage {
+			t.Errorf("%v = %d %q, want %d %q", body, code, message, knownCode, knownMessage)
+		}
+	}
+
+	var queued int
+	db.QueryRow(`SELECT COUNT(*) FROM mail_outbox`).Scan(&queued)
+	if knownCode != http.StatusOK || queued != 1 {
+		t.Errorf("Expected 200 and 1 email, got %d and %d", knownCode, queued)
+	}
+}
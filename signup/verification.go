@@ -0,0 +1,218 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"backend/common/config"
+)
+
+var (
+	errCodeInvalid     = errors.New("invalid verification code")
+	errCodeExpired     = errors.New("verification code has expired")
+	errCodeAttempts    = errors.New("too many verification attempts")
+	errResendCooldown  = errors.New("verification email sent recently")
+	errSendQuotaExceed = errors.New("too many verification emails")
+)
+
+// Verification limits, overridable through the environment.
+var (
+	verificationCodeTTL    = config.Duration("VERIFICATION_CODE_TTL", 15*time.Minute)
+	verificationMaxGuesses = config.Int("VERIFICATION_MAX_ATTEMPTS", 5)
+	verificationCooldown   = config.Duration("VERIFICATION_RESEND_COOLDOWN", time.Minute)
+	verificationQuota      = config.Int("VERIFICATION_EMAIL_QUOTA", 5)
+	verificationQuotaSpan  = config.Duration("VERIFICATION_QUOTA_WINDOW", 24*time.Hour)
+	// Six digits are cheap to brute force from a plain hash, so codes are
+	// stored as an HMAC keyed with the server secret.
+	verificationKey = []byte(config.String("AUTH_TOKEN_SECRET", ""))
+)
+
+// createVerificationTable creates email_verification_codes and moves the
+// plaintext codes still pending on users into it. It fails without
+// AUTH_TOKEN_SECRET, since codes hashed with an empty key are as easy to
+// brute force as plain ones.
+func createVerificationTable() error {
+	if len(verificationKey) == 0 {
+		return errors.New("AUTH_TOKEN_SECRET environment variable is required")
+	}
+
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS email_verification_codes (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			email TEXT NOT NULL,
+			code_hash TEXT NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			created_at INTEGER NOT NULL,
+			expires_at INTEGER NOT NULL,
+			consumed_at INTEGER,
+			invalidated_at INTEGER
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating email_verification_codes table: %v", err)
+	}
+	for _, index := range []string{
+		`CREATE INDEX IF NOT EXISTS idx_email_verification_codes_user ON email_verification_codes(user_id, created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_email_verification_codes_email ON email_verification_codes(email, created_at)`,
+	} {
+		if _, err := db.Exec(index); err != nil {
+			return fmt.Errorf("error creating index on email_verification_codes: %v", err)
+		}
+	}
+
+	rows, err := db.Query(`
+		SELECT id, email, verification_code FROM users
+		WHERE verification_code IS NOT NULL AND verification_code != ''`)
+	if err != nil {
+		return fmt.Errorf("error reading legacy verification codes: %v", err)
+	}
+	type legacyCode struct {
+		userID      int
+		email, code string
+	}
+	var legacy []legacyCode
+	for rows.Next() {
+		var c legacyCode
+		if err := rows.Scan(&c.userID, &c.email, &c.code); err != nil {
+			rows.Close()
+			return fmt.Errorf("error reading legacy verification codes: %v", err)
+		}
+		legacy = append(legacy, c)
+	}
+	rows.Close()
+
+	now := time.Now()
+	for _, c := range legacy {
+		_, err := db.Exec(`
+			INSERT INTO email_verification_codes (user_id, email, code_hash, created_at, expires_at)
+			SELECT ?, ?, ?, ?, ? FROM users WHERE id = ? AND NOT verified_email`,
+			c.userID, c.email, hashVerificationCode(c.userID, c.code), now.Unix(), now.Add(verificationCodeTTL).Unix(), c.userID,
+		)
+		if err != nil {
+			return fmt.Errorf("error migrating verification code: %v", err)
+		}
+		if _, err := db.Exec(`UPDATE users SET verification_code = NULL WHERE id = ?`, c.userID); err != nil {
+			return fmt.Errorf("error clearing verification code: %v", err)
+		}
+	}
+	return nil
+}
+
+func hashVerificationCode(userID int, code string) string {
+	mac := hmac.New(sha256.New, verificationKey)
+	mac.Write([]byte(strconv.Itoa(userID) + ":" + code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// issueVerificationCode enforces the resend cooldown and the per-email
+// quota, invalidates any pending code and stores a new one. Only the log
+// learns how long to wait: telling the caller would reveal the account.
+func issueVerificationCode(userID int, email string) (string, error) {
+	now := time.Now()
+
+	var sent int
+	var oldest, latest sql.NullInt64
+	err := db.QueryRow(`
+		SELECT COUNT(*), MIN(created_at), MAX(created_at) FROM email_verification_codes
+		WHERE email = ? AND created_at > ?`,
+		email, now.Add(-verificationQuotaSpan).Unix(),
+	).Scan(&sent, &oldest, &latest)
+	if err != nil {
+		return "", fmt.Errorf("error counting verification emails: %v", err)
+	}
+	if latest.Valid {
+		if next := time.Unix(latest.Int64, 0).Add(verificationCooldown); now.Before(next) {
+			return "", fmt.Errorf("%w, retry in %v", errResendCooldown, next.Sub(now).Round(time.Second))
+		}
+	}
+	if sent >= verificationQuota && oldest.Valid {
+		return "", fmt.Errorf("%w, retry in %v", errSendQuotaExceed, time.Unix(oldest.Int64, 0).Add(verificationQuotaSpan).Sub(now).Round(time.Second))
+	}
+
+	code := generateVerificationCode()
+	tx, err := db.Begin()
+	if err != nil {
+		return "", fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE email_verification_codes SET invalidated_at = ?
+		WHERE user_id = ? AND consumed_at IS NULL AND invalidated_at IS NULL`,
+		now.Unix(), userID,
+	)
+	if err != nil {
+		return "", fmt.Errorf("error invalidating verification codes: %v", err)
+	}
+	_, err = tx.Exec(`
+		INSERT INTO email_verification_codes (user_id, email, code_hash, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?)`,
+		userID, email, hashVerificationCode(userID, code), now.Unix(), now.Add(verificationCodeTTL).Unix(),
+	)
+	if err != nil {
+		return "", fmt.Errorf("error storing verification code: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("error committing verification code: %v", err)
+	}
+	return code, nil
+}
+
+// checkVerificationCode compares code with the pending code of userID and
+// consumes it on success. Every guess counts against the code.
+func checkVerificationCode(userID int, code string) error {
+	var id int
+	var codeHash string
+	var expiresAt int64
+	err := db.QueryRow(`
+		SELECT id, code_hash, expires_at FROM email_verification_codes
+		WHERE user_id = ? AND consumed_at IS NULL AND invalidated_at IS NULL
+		ORDER BY created_at DESC, id DESC LIMIT 1`,
+		userID,
+	).Scan(&id, &codeHash, &expiresAt)
+	if err == sql.ErrNoRows {
+		return errCodeInvalid
+	} else if err != nil {
+		return fmt.Errorf("error fetching verification code: %v", err)
+	}
+
+	if time.Now().Unix() >= expiresAt {
+		return errCodeExpired
+	}
+
+	// The guess is counted before it is compared, in the statement that
+	// checks the limit, so concurrent guesses cannot exceed it
+	result, err := db.Exec(`
+		UPDATE email_verification_codes SET attempts = attempts + 1 WHERE id = ? AND attempts < ?`,
+		id, verificationMaxGuesses,
+	)
+	if err != nil {
+		return fmt.Errorf("error recording verification attempt: %v", err)
+	}
+	if rows, _ := result.RowsAffected(); rows != 1 {
+		return errCodeAttempts
+	}
+
+	if !hmac.Equal([]byte(codeHash), []byte(hashVerificationCode(userID, code))) {
+		return errCodeInvalid
+	}
+
+	result, err = db.Exec(`
+		UPDATE email_verification_codes SET consumed_at = ? WHERE id = ? AND consumed_at IS NULL`,
+		time.Now().Unix(), id,
+	)
+	if err != nil {
+		return fmt.Errorf("error consuming verification code: %v", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return errCodeInvalid
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"backend/common/dbtest"
+)
+
+// newTestDB points the service at an empty database with user 1,
+// ana@example.com, still unverified
+func newTestDB(t *testing.T) {
+	t.Helper()
+
+	db = dbtest.Open(t)
+	_, err := db.Exec(`CREATE TABLE users (
+		id INTEGER PRIMARY KEY AUTOINCREMENT, email TEXT UNIQUE, verified_email BOOLEAN, verification_code TEXT)`)
+	if err != nil {
+		t.Fatalf("Failed to create users: %v", err)
+	}
+	db.Exec(`INSERT INTO users (id, email, verified_email) VALUES (1, 'ana@example.com', 0)`)
+
+	key := verificationKey
+	verificationKey = []byte("0123456789abcdef0123456789abcdef")
+	cooldown := verificationCooldown
+	verificationCooldown = 0
+	t.Cleanup(func() {
+		verificationKey = key
+		verificationCooldown = cooldown
+	})
+	if err := createVerificationTable(); err != nil {
+		t.Fatalf("Failed to create verification codes: %v", err)
+	}
+}
+
+func TestVerificationCodesNeedTheSecret(t *testing.T) {
+	newTestDB(t)
+
+	verificationKey = nil
+	if err := createVerificationTable(); err == nil {
+		t.Error("Expected createVerificationTable to fail without AUTH_TOKEN_SECRET")
+	}
+}
+
+func TestVerificationCodesAreStoredHashed(t *testing.T) {
+	newTestDB(t)
+
+	code, err := issueVerificationCode(1, "ana@example.com")
+	if err != nil {
+		t.Fatalf("issueVerificationCode failed: %v", err)
+	}
+	var stored string
+	db.QueryRow(`SELECT code_hash FROM email_verification_codes WHERE user_id = 1`).Scan(&stored)
+	if stored == code || stored != hashVerificationCode(1, code) {
+		t.Errorf("Expected only the HMAC of the code to be stored, got %q", stored)
+	}
+}
+
+func TestVerificationCodesAreSingleUse(t *testing.T) {
+	newTestDB(t)
+
+	code, _ := issueVerificationCode(1, "ana@example.com")
+	if err := checkVerificationCode(1, code); err != nil {
+		t.Fatalf("checkVerificationCode failed: %v", err)
+	}
+	if err := checkVerificationCode(1, code); !errors.Is(err, errCodeInvalid) {
+		t.Errorf("Second use = %v, want errCodeInvalid", err)
+	}
+}
+
+func TestExpiredVerificationCodesAreRejected(t *testing.T) {
+	newTestDB(t)
+
+	code, _ := issueVerificationCode(1, "ana@example.com")
+	db.Exec(`UPDATE email_verification_codes SET expires_at = ?`, time.Now().Add(-time.Second).Unix())
+	if err := checkVerificationCode(1, code); !errors.Is(err, errCodeExpired) {
+		t.Errorf("checkVerificationCode = %v, want errCodeExpired", err)
+	}
+}
+
+func TestIssuingAVerificationCodeInvalidatesThePreviousOne(t *testing.T) {
+	newTestDB(t)
+
+	first, _ := issueVerificationCode(1, "ana@example.com")
+	second, err := issueVerificationCode(1, "ana@example.com")
+	if err != nil {
+		t.Fatalf("issueVerificationCode failed: %v", err)
+	}
+	if first != second {
+		if err := checkVerificationCode(1, first); !errors.Is(err, errCodeInvalid) {
+			t.Errorf("First code = %v, want errCodeInvalid", err)
+		}
+	}
+	var pending int
+	db.QueryRow(`SELECT COUNT(*) FROM email_verification_codes WHERE invalidated_at IS NULL`).Scan(&pending)
+	if pending != 1 {
+		t.Errorf("Expected 1 pending code, got %d", pending)
+	}
+	if err := checkVerificationCode(1, second); err != nil {
+		t.Errorf("Second code = %v", err)
+	}
+}
+
+func TestVerificationGuessesAreLimited(t *testing.T) {
+	newTestDB(t)
+
+	code, _ := issueVerificationCode(1, "ana@example.com")
+	for i := 0; i < verificationMaxGuesses; i++ {
+		if err := checkVerificationCode(1, "wrong"); !errors.Is(err, errCodeInvalid) {
+			t.Fatalf("Guess %d = %v, want errCodeInvalid", i+1, err)
+		}
+	}
+	if err := checkVerificationCode(1, code); !errors.Is(err, errCodeAttempts) {
+		t.Errorf("Right code after the limit = %v, want errCodeAttempts", err)
+	}
+	var attempts int
+	db.QueryRow(`SELECT attempts FROM email_verification_codes WHERE user_id = 1`).Scan(&attempts)
+	if attempts != verificationMaxGuesses {
+		t.Errorf("Expected %d attempts counted, got %d", verificationMaxGuesses, attempts)
+	}
+}
@@ -81,17 +81,17 @@ echo "📦 Verificando binarios compilados..."
 # Verificar que los binarios existen
 if [ ! -f "main" ]; then
     echo "❌ Binario 'main' no encontrado. Compilando..."
-    go build -o main main.go
+    go build -tags sqlite_fts5 -o main main.go
 fi
 
 if [ ! -f "profile_management/profile_management" ]; then
     echo "❌ Binario 'profile_management' no encontrado. Compilando..."
-    cd profile_management && go build -o profile_management main.go && cd ..
+    cd profile_management && go build -tags sqlite_fts5 -o profile_management main.go && cd ..
 fi
 
 if [ ! -f "budget_overview_fetch/budget_overview_fetch" ]; then
     echo "❌ Binario 'budget_overview_fetch' no encontrado. Compilando..."
-    cd budget_overview_fetch && go build -o budget_overview_fetch main.go && cd ..
+    cd budget_overview_fetch && go build -tags sqlite_fts5 -o budget_overview_fetch main.go && cd ..
 fi
 
 echo ""
@@ -7,6 +7,15 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"time"
+
+	"backend/common/account"
+	"backend/common/auth"
+	"backend/common/currency"
+	"backend/common/journal"
+	"backend/common/ledger"
+	"backend/common/money"
+	"backend/common/trash"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -24,7 +33,13 @@ type ApiResponse struct {
 	Data    interface{} `json:"data,omitempty"`
 }
 
-var db *sql.DB
+var (
+	db       *sql.DB
+	sessions *auth.Manager
+	balances *ledger.Ledger
+	audit    *journal.Journal
+	bin      *trash.Store
+)
 
 func init() {
 	var err error
@@ -54,6 +69,47 @@ func init() {
 }
 
 func main() {
+	// Every request must carry an access token issued by signin, google_auth or apple-auth
+	var err error
+	sessions, err = auth.NewManagerFromEnv(db)
+	if err != nil {
+		log.Fatalf("Failed to initialize sessions: %v", err)
+	}
+
+	// Amounts are stored as cents; tables created with REAL amounts are converted
+	if err := money.Migrate(db); err != nil {
+		log.Fatalf("Failed to migrate amounts to cents: %v", err)
+	}
+
+	// The period balance tables belong to the shared ledger
+	balances, err = ledger.New(db)
+	if err != nil {
+		log.Fatalf("Failed to initialize balance ledger: %v", err)
+	}
+
+	// Transactions are taken out of the balances by their base amount
+	if _, err := currency.NewStoreFromEnv(db); err != nil {
+		log.Fatalf("Failed to initialize currencies: %v", err)
+	}
+
+	// Transactions are taken out of the account they went through
+	if _, err := account.NewStore(db, balances); err != nil {
+		log.Fatalf("Failed to initialize accounts: %v", err)
+	}
+
+	// Every deletion is recorded in the journal
+	audit, err = journal.New(db)
+	if err != nil {
+		log.Fatalf("Failed to initialize journal: %v", err)
+	}
+
+	// Deleted transactions wait in the trash until TRASH_RETENTION runs out
+	bin, err = trash.NewFromEnv(db, balances)
+	if err != nil {
+		log.Fatalf("Failed to initialize trash: %v", err)
+	}
+	bin.Start(time.Hour)
+
 	// CORS middleware function
 	corsMiddleware := func(next http.HandlerFunc) http.HandlerFunc {
 		return func(w http.ResponseWriter, r *http.Request) {
@@ -83,7 +139,11 @@ func main() {
 	}))
 
 	// Delete transaction endpoint
-	http.HandleFunc("/transactions/delete", corsMiddleware(handleDeleteTransaction))
+	http.HandleFunc("/transactions/delete", corsMiddleware(sessions.Require(handleDeleteTransaction)))
+
+	// Trash listing and restore endpoints
+	http.HandleFunc("/transactions/trash", corsMiddleware(sessions.Require(bin.Handler())))
+	http.HandleFunc("/transactions/restore", corsMiddleware(sessions.Require(handleRestoreTransaction)))
 
 	port := "8095" // Unique port for transaction delete service
 	log.Printf("Transaction Delete Service starting on port %s", port)
@@ -144,9 +204,40 @@ func handleDeleteTransaction(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Statement bills follow their credit card: bills_management creates
+	// and pays them
+	if transaction.StatementAccountID != 0 {
+		response := ApiResponse{
+			Success: false,
+			Message: "Statement bills follow their credit card and cannot be deleted",
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	// The transaction, its bill payment status and the period balances
+	// change in one database transaction: either all of them or none
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		response := ApiResponse{
+			Success: false,
+			Message: "Failed to delete transaction",
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+	defer tx.Rollback()
+
 	// Handle special case: expense with bill_id (corresponds to a bill payment)
 	if deleteRequest.TransactionType == "expense" && transaction.BillID != nil {
-		err = handleExpenseWithBillDeletion(*transaction)
+		err = handleExpenseWithBillDeletion(tx, *transaction, journal.Actor(r))
 		if err != nil {
 			log.Printf("Error handling expense with bill deletion: %v", err)
 			response := ApiResponse{
@@ -161,8 +252,7 @@ func handleDeleteTransaction(w http.ResponseWriter, r *http.Request) {
 		}
 	} else {
 		// For regular transactions (income, bills, expenses without bill_id)
-		// Delete the transaction first
-		err = deleteTransaction(deleteRequest.TransactionID, deleteRequest.TransactionType, deleteRequest.UserID)
+		err = deleteRegularTransaction(tx, *transaction, deleteRequest.TransactionType, journal.Actor(r))
 		if err != nil {
 			log.Printf("Error deleting transaction: %v", err)
 			response := ApiResponse{
@@ -175,13 +265,19 @@ func handleDeleteTransaction(w http.ResponseWriter, r *http.Request) {
 			json.NewEncoder(w).Encode(response)
 			return
 		}
+	}
 
-		// Recalculate balances for all time periods
-		err = recalculateAllBalances(deleteRequest.UserID, transaction.Date, transaction.Amount, transaction.PaymentMethod, deleteRequest.TransactionType, transaction.BillID)
-		if err != nil {
-			log.Printf("Error recalculating balances: %v", err)
-			// Don't fail the request if balance recalculation fails, just log it
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error committing transaction deletion: %v", err)
+		response := ApiResponse{
+			Success: false,
+			Message: "Failed to delete transaction",
 		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(response)
+		return
 	}
 
 	response := ApiResponse{
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/common/account"
+	"backend/common/currency"
+	"backend/common/dbtest"
+	"backend/common/journal"
+	"backend/common/ledger"
+	"backend/common/tag"
+	"backend/common/trash"
+)
+
+// writeTables are every table a deletion touches
+var writeTables = []string{
+	"expenses", "incomes", "bills", "bill_payments",
+	"daily_cash_bank_balance", "monthly_cash_bank_balance", "monthly_balance", "annual_balance", "journal", "trash",
+}
+
+// newTestDB points the service at a database with an income, a three
+// month bill whose February is paid, and the expense that paid it, all
+// posted to the ledger the way the other services post them. Amounts are
+// cents.
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db = dbtest.Open(t)
+	schema := []string{
+		`CREATE TABLE incomes (id INTEGER PRIMARY KEY AUTOINCREMENT, user_id TEXT, amount INTEGER, date TEXT, category TEXT, payment_method TEXT)`,
+		`CREATE TABLE expenses (id INTEGER PRIMARY KEY AUTOINCREMENT, user_id TEXT, amount INTEGER, date TEXT, category TEXT, payment_method TEXT, bill_id INTEGER)`,
+		`CREATE TABLE bills (id INTEGER PRIMARY KEY AUTOINCREMENT, user_id TEXT, amount INTEGER, due_date TEXT, start_date TEXT, duration_months INTEGER, payment_method TEXT, paid BOOLEAN DEFAULT 0)`,
+		`CREATE TABLE bill_payments (id INTEGER PRIMARY KEY AUTOINCREMENT, bill_id INTEGER, year_month TEXT, paid BOOLEAN DEFAULT 0, UNIQUE(bill_id, year_month))`,
+		`INSERT INTO incomes (user_id, amount, date, category, payment_method) VALUES ('u1', 1000, '2025-01-05', 'salary', 'bank')`,
+		`INSERT INTO bills (user_id, amount, due_date, start_date, duration_months, payment_method, paid) VALUES ('u1', 50, '2025-01-10', '2025-01-10', 3, 'bank', 0)`,
+		`INSERT INTO bill_payments (bill_id, year_month, paid) VALUES (1, '2025-01', 0), (1, '2025-02', 1), (1, '2025-03', 0)`,
+		`INSERT INTO expenses (user_id, amount, date, category, payment_method, bill_id) VALUES ('u1', 50, '2025-02-12', 'rent', 'bank', 1)`,
+	}
+	for _, statement := range schema {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatalf("Failed to prepare test database: %v", err)
+		}
+	}
+
+	// The rows above predate currencies: they are backfilled in EUR
+	if _, err := currency.NewStore(db, "EUR"); err != nil {
+		t.Fatalf("Failed to add currency columns: %v", err)
+	}
+
+	var err error
+	balances, err = ledger.New(db)
+	if err != nil {
+		t.Fatalf("Failed to create ledger: %v", err)
+	}
+	bills, _ := ledger.BillEntries("u1", ledger.Bank, 0, 50, "2025-01-10", 3, map[string]bool{"2025-02": true})
+	entries := append(bills,
+		ledger.Entry{UserID: "u1", Kind: ledger.Income, Method: ledger.Bank, Amount: 1000, Date: "2025-01-05"},
+		ledger.Entry{UserID: "u1", Kind: ledger.Expense, Method: ledger.Bank, Amount: 50, Date: "2025-02-12"},
+	)
+	if err := balances.Post(entries...); err != nil {
+		t.Fatalf("Failed to post test entries: %v", err)
+	}
+
+	// The rows above predate accounts too: they go to the default bank account
+	if _, err := account.NewStore(db, balances); err != nil {
+		t.Fatalf("Failed to add account columns: %v", err)
+	}
+	audit, err = journal.New(db)
+	if err != nil {
+		t.Fatalf("Failed to create journal: %v", err)
+	}
+	bin, err = trash.New(db, balances, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create trash: %v", err)
+	}
+	return db
+}
+
+func deleteRequest(transactionType string, id int) func(t *testing.T) error {
+	return func(t *testing.T) error {
+		payload, _ := json.Marshal(DeleteTransactionRequest{UserID: "u1", TransactionID: id, TransactionType: transactionType})
+		rr := httptest.NewRecorder()
+		handleDeleteTransaction(rr, httptest.NewRequest("POST", "/transactions/delete", bytes.NewReader(payload)))
+		if rr.Code != http.StatusOK {
+			return fmt.Errorf("status %d: %s", rr.Code, rr.Body.String())
+		}
+		return nil
+	}
+}
+
+func TestDeleteIncomeIsAtomic(t *testing.T) {
+	failed := dbtest.Atomic(t, writeTables, newTestDB, deleteRequest("income", 1))
+	assertFailedOn(t, failed, "DELETE incomes", "UPDATE monthly_cash_bank_balance", "UPDATE annual_balance", "INSERT journal", "INSERT trash")
+}
+
+func TestDeleteBillPaymentIsAtomic(t *testing.T) {
+	failed := dbtest.Atomic(t, writeTables, newTestDB, deleteRequest("expense", 1))
+	assertFailedOn(t, failed, "UPDATE bill_payments", "UPDATE bills", "DELETE expenses", "UPDATE annual_balance", "INSERT journal", "INSERT trash")
+}
+
+func TestDeleteBillIsAtomic(t *testing.T) {
+	failed := dbtest.Atomic(t, writeTables, newTestDB, deleteRequest("bill", 1))
+	assertFailedOn(t, failed, "DELETE bills", "UPDATE annual_balance", "INSERT journal", "INSERT trash")
+}
+
+func TestDeleteBillPaymentReopensMonth(t *testing.T) {
+	newTestDB(t)
+	if err := deleteRequest("expense", 1)(t); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	var billBank, expenseBank, total float64
+	db.QueryRow(`SELECT bill_bank_amount, expense_bank_amount, total_balance FROM monthly_cash_bank_balance
+		WHERE user_id = 'u1' AND year_month = '2025-02'`).Scan(&billBank, &expenseBank, &total)
+	if billBank != 50 || expenseBank != 0 || total != 900 {
+		t.Errorf("February = bills %v, expenses %v, total %v", billBank, expenseBank, total)
+	}
+
+	var paid bool
+	db.QueryRow(`SELECT paid FROM bill_payments WHERE bill_id = 1 AND year_month = '2025-02'`).Scan(&paid)
+	if paid {
+		t.Errorf("Expected February to be unpaid again")
+	}
+}
+
+func TestDeletionsAreJournaled(t *testing.T) {
+	newTestDB(t)
+	if err := deleteRequest("income", 1)(t); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := deleteRequest("expense", 1)(t); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	records, err := audit.Records("u1", "", 0)
+	if err != nil || len(records) != 2 {
+		t.Fatalf("Records = %+v, %v", records, err)
+	}
+	var income struct {
+		Amount   float64 `json:"amount"`
+		Category string  `json:"category"`
+	}
+	json.Unmarshal(records[0].Before, &income)
+	if records[0].Action != journal.Delete || income.Amount != 10 || income.Category != "salary" || len(records[0].Reversed) != 1 {
+		t.Errorf("Income deletion = %+v", records[0])
+	}
+	payment := records[1]
+	if payment.Entity != "expense" || len(payment.Reversed) != 1 || len(payment.Posted) != 1 || payment.Posted[0].Kind != ledger.Bill {
+		t.Errorf("Bill payment deletion = %+v", payment)
+	}
+}
+
+func TestRestoreUndoesTheDeletion(t *testing.T) {
+	for _, transactionType := range []string{"income", "expense", "bill"} {
+		t.Run(transactionType, func(t *testing.T) {
+			newTestDB(t)
+			tags, err := tag.New(db)
+			if err != nil {
+				t.Fatalf("Failed to create tags: %v", err)
+			}
+			if _, err := tags.Set("u1", transactionType, 1, []string{"vacation", "reimbursable"}); err != nil {
+				t.Fatalf("Failed to tag the %s: %v", transactionType, err)
+			}
+			rows, amounts := dbtest.Snapshot(t, db), periodAmounts(t)
+			if err := deleteRequest(transactionType, 1)(t); err != nil {
+				t.Fatalf("Delete failed: %v", err)
+			}
+
+			items, err := bin.List("u1")
+			if err != nil || len(items) != 1 || items[0].Entity != transactionType {
+				t.Fatalf("Trash = %+v, %v", items, err)
+			}
+			payload, _ := json.Marshal(RestoreTransactionRequest{UserID: "u1", TrashID: items[0].ID})
+			rr := httptest.NewRecorder()
+			handleRestoreTransaction(rr, httptest.NewRequest("POST", "/transactions/restore", bytes.NewReader(payload)))
+			if rr.Code != http.StatusOK {
+				t.Fatalf("Restore = %d: %s", rr.Code, rr.Body.String())
+			}
+
+			// The deletion may leave new period rows behind; the rows that were
+			// there must have their amounts back
+			restored := dbtest.Snapshot(t, db)
+			for _, table := range []string{"incomes", "expenses", "bills", "bill_payments", "transaction_tags"} {
+				if !reflect.DeepEqual(restored[table], rows[table]) {
+					t.Errorf("Restored %s = %v, want %v", table, restored[table], rows[table])
+				}
+			}
+			restoredAmounts := periodAmounts(t)
+			for cell, amount := range amounts {
+				if restoredAmounts[cell] != amount {
+					t.Errorf("Restored %v = %s, want %s", cell, restoredAmounts[cell], amount)
+				}
+			}
+
+			rr = httptest.NewRecorder()
+			handleRestoreTransaction(rr, httptest.NewRequest("POST", "/transactions/restore", bytes.NewReader(payload)))
+			if rr.Code != http.StatusNotFound {
+				t.Errorf("Restoring twice = %d, want 404", rr.Code)
+			}
+		})
+	}
+}
+
+// periodAmounts reads every amount of the period rows of u1
+func periodAmounts(t *testing.T) ledger.Snapshot {
+	t.Helper()
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	snapshot, err := balances.SnapshotTx(tx, "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return snapshot
+}
+
+func TestStatementBillsAreNotDeleted(t *testing.T) {
+	newTestDB(t)
+	_, err := db.Exec(`INSERT INTO bills (user_id, amount, due_date, start_date, duration_months, payment_method, statement_account_id)
+		VALUES ('u1', 3000, '2025-02-05', '2025-02-05', 1, 'bank', 7)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := deleteRequest("bill", 2)(t); err == nil || !strings.Contains(err.Error(), "400") {
+		t.Errorf("Deleting a statement bill: %v, want 400", err)
+	}
+	var count int
+	db.QueryRow(`SELECT COUNT(*) FROM bills WHERE id = 2`).Scan(&count)
+	if count != 1 {
+		t.Errorf("Statement bill was deleted")
+	}
+}
+
+func TestDeleteForeignIncomeReversesItsBaseAmount(t *testing.T) {
+	newTestDB(t)
+	_, err := db.Exec(`INSERT INTO incomes (user_id, amount, date, category, payment_method, currency, base_amount)
+		VALUES ('u1', 2000, '2025-03-02', 'refund', 'bank', 'USD', 1800)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	income := ledger.Entry{UserID: "u1", Kind: ledger.Income, Method: ledger.Bank, Amount: 1800, Date: "2025-03-02"}
+	if err := balances.Post(income); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := deleteRequest("income", 2)(t); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	var incomeBank float64
+	db.QueryRow(`SELECT income_bank_amount FROM monthly_cash_bank_balance
+		WHERE user_id = 'u1' AND year_month = '2025-03'`).Scan(&incomeBank)
+	if incomeBank != 0 {
+		t.Errorf("March incomes = %v after deleting the USD income", incomeBank)
+	}
+}
+
+// assertFailedOn checks that each fault point made the write fail, i.e.
+// that the write really goes through it
+func assertFailedOn(t *testing.T, failed []string, points ...string) {
+	t.Helper()
+
+	hit := map[string]bool{}
+	for _, point := range failed {
+		hit[point] = true
+	}
+	for _, point := range points {
+		if !hit[point] {
+			t.Errorf("Expected a fault on %s to make the write fail", point)
+		}
+	}
+}
@@ -1,13 +1,18 @@
 package main
 
 import (
+	"database/sql"
 	"fmt"
 	"log"
 	"time"
+
+	"backend/common/journal"
+	"backend/common/ledger"
+	"backend/common/trash"
 )
 
 // handleExpenseWithBillDeletion handles the special case when deleting an expense that corresponds to a bill payment
-func handleExpenseWithBillDeletion(transaction TransactionDetails) error {
+func handleExpenseWithBillDeletion(tx *sql.Tx, transaction TransactionDetails, actor string) error {
 	log.Printf("Handling expense with bill_id %d deletion for user %s", *transaction.BillID, transaction.UserID)
 
 	// Step 1: Extract month from date (format YYYY-MM-DD to YYYY-MM)
@@ -17,41 +22,69 @@ func handleExpenseWithBillDeletion(transaction TransactionDetails) error {
 	}
 	yearMonth := transactionDate.Format("2006-01")
 
-	log.Printf("Processing expense deletion - Bill ID: %d, Month: %s, Amount: %.2f, Payment Method: %s",
+	before, err := transactionState(tx, transaction.ID, "expense")
+	if err != nil {
+		return err
+	}
+
+	// Keep the bill and its payment as they are, so a restore can pay them again
+	changed, err := trash.ReadTx(tx, "bill_payments", "bill_id = ? AND year_month = ?", *transaction.BillID, yearMonth)
+	if err != nil {
+		return err
+	}
+	bill, err := trash.ReadTx(tx, "bills", "id = ? AND user_id = ?", *transaction.BillID, transaction.UserID)
+	if err != nil {
+		return err
+	}
+	changed = append(changed, bill...)
+
+	log.Printf("Processing expense deletion - Bill ID: %d, Month: %s, Amount: %s, Payment Method: %s",
 		*transaction.BillID, yearMonth, transaction.Amount, transaction.PaymentMethod)
 
 	// Step 2: Update bill_payments table to mark as unpaid
-	err = updateBillPaymentStatus(*transaction.BillID, yearMonth)
+	err = updateBillPaymentStatus(tx, *transaction.BillID, yearMonth)
 	if err != nil {
 		return fmt.Errorf("error updating bill_payments: %v", err)
 	}
 
 	// Step 3: Update bills table to mark as unpaid
-	err = updateBillPaidStatus(*transaction.BillID, transaction.UserID)
+	err = updateBillPaidStatus(tx, *transaction.BillID, transaction.UserID)
 	if err != nil {
 		return fmt.Errorf("error updating bills paid status: %v", err)
 	}
 
-	// Step 4: Update monthly_cash_bank_balance table
-	err = updateMonthlyBalanceForBillDeletion(transaction, yearMonth)
+	// Step 4: Move the payment back to the bill in the period balances
+	payment, billEntry, err := updateMonthlyBalanceForBillDeletion(tx, transaction, yearMonth)
 	if err != nil {
-		return fmt.Errorf("error updating monthly_cash_bank_balance: %v", err)
+		return fmt.Errorf("error updating period balances: %v", err)
 	}
 
-	// Step 5: Delete the expense transaction
-	err = deleteTransaction(transaction.ID, "expense", transaction.UserID)
+	// Step 5: Move the expense transaction to the trash
+	rows, err := deleteTransaction(tx, transaction.ID, "expense", transaction.UserID)
 	if err != nil {
 		return fmt.Errorf("error deleting expense transaction: %v", err)
 	}
 
+	// Step 6: Record the deletion, and the payment turned back into a bill
+	err = audit.RecordTx(tx, transaction.UserID, journal.Change{Actor: actor, Entity: "expense", EntityID: int64(transaction.ID),
+		Before: before, Reversed: []ledger.Entry{payment}, Posted: []ledger.Entry{billEntry}})
+	if err != nil {
+		return err
+	}
+	_, err = bin.PutTx(tx, trash.Item{UserID: transaction.UserID, Entity: "expense", EntityID: int64(transaction.ID),
+		Rows: rows, Changed: changed, Reversed: []ledger.Entry{payment}, Posted: []ledger.Entry{billEntry}})
+	if err != nil {
+		return err
+	}
+
 	log.Printf("Successfully handled expense with bill deletion - ID: %d, Bill ID: %d", transaction.ID, *transaction.BillID)
 	return nil
 }
 
 // updateBillPaymentStatus updates the bill_payments table to mark as unpaid
-func updateBillPaymentStatus(billID int, yearMonth string) error {
+func updateBillPaymentStatus(tx *sql.Tx, billID int, yearMonth string) error {
 	billPaymentQuery := `UPDATE bill_payments SET paid = 0 WHERE bill_id = ? AND year_month = ?`
-	result, err := db.Exec(billPaymentQuery, billID, yearMonth)
+	result, err := tx.Exec(billPaymentQuery, billID, yearMonth)
 	if err != nil {
 		return err
 	}
@@ -71,9 +104,9 @@ func updateBillPaymentStatus(billID int, yearMonth string) error {
 }
 
 // updateBillPaidStatus updates the bills table to mark as unpaid when a payment is deleted
-func updateBillPaidStatus(billID int, userID string) error {
+func updateBillPaidStatus(tx *sql.Tx, billID int, userID string) error {
 	billUpdateQuery := `UPDATE bills SET paid = 0 WHERE id = ? AND user_id = ?`
-	result, err := db.Exec(billUpdateQuery, billID, userID)
+	result, err := tx.Exec(billUpdateQuery, billID, userID)
 	if err != nil {
 		return err
 	}
@@ -92,42 +125,18 @@ func updateBillPaidStatus(billID int, userID string) error {
 	return nil
 }
 
-// updateMonthlyBalanceForBillDeletion updates the monthly_cash_bank_balance table when deleting a bill payment expense
-func updateMonthlyBalanceForBillDeletion(transaction TransactionDetails, yearMonth string) error {
-	// Move amount from expense_amount to bill_amount
-	var expenseColumn, billColumn string
-	if transaction.PaymentMethod == "bank" {
-		expenseColumn = "expense_bank_amount"
-		billColumn = "bill_bank_amount"
-	} else {
-		expenseColumn = "expense_cash_amount"
-		billColumn = "bill_cash_amount"
-	}
-
-	balanceQuery := fmt.Sprintf(`
-		UPDATE monthly_cash_bank_balance 
-		SET %s = %s - ?, 
-		    %s = %s + ?,
-		    updated_at = CURRENT_TIMESTAMP
-		WHERE user_id = ? AND year_month = ?`,
-		expenseColumn, expenseColumn, billColumn, billColumn)
-
-	result, err := db.Exec(balanceQuery, transaction.Amount, transaction.Amount, transaction.UserID, yearMonth)
-	if err != nil {
-		return err
-	}
-
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		return fmt.Errorf("error checking monthly_cash_bank_balance update: %v", err)
-	}
+// updateMonthlyBalanceForBillDeletion turns the payment back into an unpaid
+// bill for its month in the period balances and returns both entries
+func updateMonthlyBalanceForBillDeletion(tx *sql.Tx, transaction TransactionDetails, yearMonth string) (payment, bill ledger.Entry, err error) {
+	payment = transactionEntry(transaction, ledger.Expense)
+	bill = payment
+	bill.Kind = ledger.Bill
+	bill.Date = ledger.BillDate(yearMonth)
 
-	if rowsAffected == 0 {
-		log.Printf("Warning: No monthly_cash_bank_balance record found for user %s and year_month %s", transaction.UserID, yearMonth)
-	} else {
-		log.Printf("Updated monthly_cash_bank_balance: moved %.2f from %s to %s for %s",
-			transaction.Amount, expenseColumn, billColumn, yearMonth)
+	if err := balances.ReplaceTx(tx, []ledger.Entry{payment}, []ledger.Entry{bill}); err != nil {
+		return payment, bill, err
 	}
 
-	return nil
+	log.Printf("Moved %s back from expenses to bills for %s", transaction.Amount, yearMonth)
+	return payment, bill, nil
 }
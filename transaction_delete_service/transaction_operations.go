@@ -1,17 +1,27 @@
 package main
 
 import (
+	"database/sql"
 	"fmt"
 	"strings"
+
+	"backend/common/journal"
+	"backend/common/ledger"
+	"backend/common/money"
+	"backend/common/tag"
+	"backend/common/trash"
 )
 
 type TransactionDetails struct {
-	ID            int     `json:"id"`
-	UserID        string  `json:"user_id"`
-	Amount        float64 `json:"amount"`
-	Date          string  `json:"date"`
-	PaymentMethod string  `json:"payment_method"`
-	BillID        *int    `json:"bill_id,omitempty"`
+	ID            int         `json:"id"`
+	UserID        string      `json:"user_id"`
+	Amount        money.Money `json:"amount"` // en la moneda base
+	Date          string      `json:"date"`
+	PaymentMethod string      `json:"payment_method"`
+	AccountID     int64       `json:"account_id"`
+	BillID        *int        `json:"bill_id,omitempty"`
+	// StatementAccountID es la tarjeta si la factura paga un extracto
+	StatementAccountID int64 `json:"statement_account_id,omitempty"`
 }
 
 func getTransactionDetails(transactionID int, transactionType, userID string) (*TransactionDetails, error) {
@@ -20,28 +30,28 @@ func getTransactionDetails(transactionID int, transactionType, userID string) (*
 
 	switch strings.ToLower(transactionType) {
 	case "expense":
-		query = `SELECT id, user_id, amount, date, payment_method, bill_id FROM expenses WHERE id = ? AND user_id = ?`
+		query = `SELECT id, user_id, COALESCE(base_amount, amount), date, payment_method, COALESCE(account_id, 0), bill_id FROM expenses WHERE id = ? AND user_id = ?`
 		row := db.QueryRow(query, transactionID, userID)
 		err := row.Scan(&transaction.ID, &transaction.UserID, &transaction.Amount,
-			&transaction.Date, &transaction.PaymentMethod, &transaction.BillID)
+			&transaction.Date, &transaction.PaymentMethod, &transaction.AccountID, &transaction.BillID)
 		if err != nil {
 			return nil, err
 		}
 	case "income":
-		query = `SELECT id, user_id, amount, date, payment_method FROM incomes WHERE id = ? AND user_id = ?`
+		query = `SELECT id, user_id, COALESCE(base_amount, amount), date, payment_method, COALESCE(account_id, 0) FROM incomes WHERE id = ? AND user_id = ?`
 		row := db.QueryRow(query, transactionID, userID)
 		err := row.Scan(&transaction.ID, &transaction.UserID, &transaction.Amount,
-			&transaction.Date, &transaction.PaymentMethod)
+			&transaction.Date, &transaction.PaymentMethod, &transaction.AccountID)
 		if err != nil {
 			return nil, err
 		}
 		// Para income, bill_id siempre es NULL
 		transaction.BillID = nil
 	case "bill":
-		query = `SELECT id, user_id, amount, due_date as date, 'bank' as payment_method FROM bills WHERE id = ? AND user_id = ?`
+		query = `SELECT id, user_id, COALESCE(base_amount, amount), due_date as date, 'bank' as payment_method, COALESCE(statement_account_id, 0) FROM bills WHERE id = ? AND user_id = ?`
 		row := db.QueryRow(query, transactionID, userID)
 		err := row.Scan(&transaction.ID, &transaction.UserID, &transaction.Amount,
-			&transaction.Date, &transaction.PaymentMethod)
+			&transaction.Date, &transaction.PaymentMethod, &transaction.StatementAccountID)
 		if err != nil {
 			return nil, err
 		}
@@ -55,33 +65,145 @@ func getTransactionDetails(transactionID int, transactionType, userID string) (*
 	return &transaction, nil
 }
 
-func deleteTransaction(transactionID int, transactionType, userID string) error {
-	var query string
+// deleteTransaction takes the transaction out of its table and returns its
+// row for the trash
+func deleteTransaction(tx *sql.Tx, transactionID int, transactionType, userID string) ([]trash.Row, error) {
+	table, err := transactionTable(transactionType)
+	if err != nil {
+		return nil, err
+	}
+
+	// Its tags go to the trash with it, before the delete drops them
+	tags, err := tag.TakeTx(tx, strings.ToLower(transactionType), int64(transactionID))
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := trash.TakeTx(tx, table, "id = ? AND user_id = ?", transactionID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("no transaction found with ID %d for user %s", transactionID, userID)
+	}
 
+	return append(rows, tags...), nil
+}
+
+func transactionTable(transactionType string) (string, error) {
 	switch strings.ToLower(transactionType) {
 	case "expense":
-		query = `DELETE FROM expenses WHERE id = ? AND user_id = ?`
+		return "expenses", nil
 	case "income":
-		query = `DELETE FROM incomes WHERE id = ? AND user_id = ?`
+		return "incomes", nil
 	case "bill":
-		query = `DELETE FROM bills WHERE id = ? AND user_id = ?`
+		return "bills", nil
 	default:
-		return fmt.Errorf("unsupported transaction type: %s", transactionType)
+		return "", fmt.Errorf("unsupported transaction type: %s", transactionType)
+	}
+}
+
+// deleteRegularTransaction moves an income, a bill or an expense that is
+// not a bill payment to the trash, takes what it posted out of the period
+// balances and records the deletion in the journal on behalf of actor
+func deleteRegularTransaction(tx *sql.Tx, transaction TransactionDetails, transactionType, actor string) error {
+	// Read what the transaction posted before it is gone
+	entries, err := ledgerEntries(tx, transaction, transactionType)
+	if err != nil {
+		return fmt.Errorf("error reading ledger entries of transaction: %v", err)
+	}
+	before, err := transactionState(tx, transaction.ID, transactionType)
+	if err != nil {
+		return err
 	}
 
-	result, err := db.Exec(query, transactionID, userID)
+	rows, err := deleteTransaction(tx, transaction.ID, transactionType, transaction.UserID)
 	if err != nil {
 		return err
 	}
 
-	rowsAffected, err := result.RowsAffected()
+	if err := balances.ReverseTx(tx, entries...); err != nil {
+		return fmt.Errorf("error reversing transaction in period balances: %v", err)
+	}
+
+	entity := strings.ToLower(transactionType)
+	err = audit.RecordTx(tx, transaction.UserID, journal.Change{Actor: actor, Entity: entity,
+		EntityID: int64(transaction.ID), Before: before, Reversed: entries})
 	if err != nil {
 		return err
 	}
 
-	if rowsAffected == 0 {
-		return fmt.Errorf("no transaction found with ID %d for user %s", transactionID, userID)
+	_, err = bin.PutTx(tx, trash.Item{UserID: transaction.UserID, Entity: entity, EntityID: int64(transaction.ID),
+		Rows: rows, Reversed: entries})
+	return err
+}
+
+// transactionState reads the row of a transaction as it is before the
+// deletion, for the journal
+func transactionState(tx *sql.Tx, transactionID int, transactionType string) (map[string]interface{}, error) {
+	table, err := transactionTable(transactionType)
+	if err != nil {
+		return nil, err
+	}
+	return journal.RowTx(tx, table, int64(transactionID))
+}
+
+// ledgerEntries returns what a transaction posted to the period balances,
+// so deleting it reverses exactly that
+func ledgerEntries(tx *sql.Tx, transaction TransactionDetails, transactionType string) ([]ledger.Entry, error) {
+	switch strings.ToLower(transactionType) {
+	case "expense":
+		return []ledger.Entry{transactionEntry(transaction, ledger.Expense)}, nil
+	case "income":
+		return []ledger.Entry{transactionEntry(transaction, ledger.Income)}, nil
+	case "bill":
+		return unpaidBillEntries(tx, transaction.ID, transaction.UserID)
+	default:
+		return nil, fmt.Errorf("unsupported transaction type: %s", transactionType)
+	}
+}
+
+func transactionEntry(transaction TransactionDetails, kind ledger.Kind) ledger.Entry {
+	return ledger.Entry{
+		UserID:  transaction.UserID,
+		Kind:    kind,
+		Method:  transaction.PaymentMethod,
+		Account: transaction.AccountID,
+		Amount:  transaction.Amount,
+		Date:    transaction.Date,
+	}
+}
+
+// unpaidBillEntries returns the months of a bill that are still posted as
+// bills. Paid months are expenses and stay when the bill is deleted.
+func unpaidBillEntries(tx *sql.Tx, billID int, userID string) ([]ledger.Entry, error) {
+	var amount money.Money
+	var startDate, paymentMethod string
+	var durationMonths int
+	var accountID int64
+	err := tx.QueryRow(`
+		SELECT COALESCE(base_amount, amount), start_date, duration_months, COALESCE(payment_method, 'cash'), COALESCE(account_id, 0)
+		FROM bills WHERE id = ? AND user_id = ?`, billID, userID).
+		Scan(&amount, &startDate, &durationMonths, &paymentMethod, &accountID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching bill: %v", err)
+	}
+
+	rows, err := tx.Query(`SELECT year_month FROM bill_payments WHERE bill_id = ? AND paid = 1`, billID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching bill payments: %v", err)
+	}
+	defer rows.Close()
+
+	paid := map[string]bool{}
+	for rows.Next() {
+		var yearMonth string
+		if err := rows.Scan(&yearMonth); err != nil {
+			return nil, fmt.Errorf("error scanning bill payment: %v", err)
+		}
+		paid[yearMonth] = true
 	}
 
-	return nil
+	return ledger.BillEntries(userID, paymentMethod, accountID, amount, startDate, durationMonths, paid)
 }
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"backend/common/journal"
+	"backend/common/trash"
+)
+
+// RestoreTransactionRequest asks to take a deleted transaction out of the trash
+type RestoreTransactionRequest struct {
+	UserID  string `json:"user_id"`
+	TrashID int64  `json:"trash_id"`
+}
+
+// handleRestoreTransaction puts a deleted transaction back, with its bill
+// payment status and its period balances exactly as the deletion found them
+func handleRestoreTransaction(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request RestoreTransactionRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeResponse(w, http.StatusBadRequest, ApiResponse{Success: false, Message: "Invalid request format"})
+		return
+	}
+	if request.UserID == "" || request.TrashID <= 0 {
+		writeResponse(w, http.StatusBadRequest, ApiResponse{Success: false, Message: "Missing required fields: user_id or trash_id"})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		writeResponse(w, http.StatusInternalServerError, ApiResponse{Success: false, Message: "Failed to restore transaction"})
+		return
+	}
+	defer tx.Rollback()
+
+	item, err := bin.RestoreTx(tx, request.UserID, request.TrashID)
+	if errors.Is(err, trash.ErrNotFound) {
+		writeResponse(w, http.StatusNotFound, ApiResponse{Success: false, Message: "Transaction not found in the trash"})
+		return
+	}
+	if errors.Is(err, trash.ErrConflict) {
+		log.Printf("Error restoring transaction: %v", err)
+		writeResponse(w, http.StatusConflict, ApiResponse{Success: false, Message: "The bill this transaction paid was changed or deleted after the deletion"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error restoring transaction: %v", err)
+		writeResponse(w, http.StatusInternalServerError, ApiResponse{Success: false, Message: "Failed to restore transaction"})
+		return
+	}
+
+	// The restore undoes the deletion, so its entries go the other way round
+	after, err := journal.RowTx(tx, item.Rows[0].Table, item.EntityID)
+	if err == nil {
+		err = audit.RecordTx(tx, item.UserID, journal.Change{Actor: journal.Actor(r), Entity: item.Entity, EntityID: item.EntityID,
+			After: after, Reversed: item.Posted, Posted: item.Reversed})
+	}
+	if err != nil {
+		log.Printf("Error recording transaction restore: %v", err)
+		writeResponse(w, http.StatusInternalServerError, ApiResponse{Success: false, Message: "Failed to restore transaction"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error committing transaction restore: %v", err)
+		writeResponse(w, http.StatusInternalServerError, ApiResponse{Success: false, Message: "Failed to restore transaction"})
+		return
+	}
+
+	writeResponse(w, http.StatusOK, ApiResponse{
+		Success: true,
+		Message: "Transaction restored successfully",
+		Data:    map[string]interface{}{"entity": item.Entity, "entity_id": item.EntityID, "state": after},
+	})
+}
+
+func writeResponse(w http.ResponseWriter, status int, response ApiResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
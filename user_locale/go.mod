@@ -0,0 +1,10 @@
+module backend/user_locale
+
+go 1.21
+
+require (
+	backend/common v0.0.0
+	github.com/mattn/go-sqlite3 v1.14.27
+)
+
+replace backend/common => ../common
@@ -9,11 +9,14 @@ import (
 	"os"
 	"path/filepath"
 
+	"backend/common/auth"
+
 	_ "github.com/mattn/go-sqlite3"
 )
 
 var (
-	db *sql.DB
+	db       *sql.DB
+	sessions *auth.Manager
 )
 
 type UserLocaleResponse struct {
@@ -50,8 +53,15 @@ func init() {
 }
 
 func main() {
+	// Every request must carry an access token issued by signin, google_auth or apple-auth
+	var err error
+	sessions, err = auth.NewManagerFromEnv(db)
+	if err != nil {
+		log.Fatalf("Failed to initialize sessions: %v", err)
+	}
+
 	// Set up CORS middleware
-	http.HandleFunc("/user_locale/get", corsMiddleware(handleGetUserLocale))
+	http.HandleFunc("/user_locale/get", corsMiddleware(sessions.Require(handleGetUserLocale)))
 	http.HandleFunc("/health", corsMiddleware(handleHealth))
 
 	port := 8099
@@ -83,9 +93,8 @@ func handleGetUserLocale(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userID := r.URL.Query().Get("user_id")
-	if userID == "" || userID == "null" {
-		log.Printf("Error: User ID is empty or 'null' in request")
+	userID := auth.UserID(r)
+	if userID == "" {
 		http.Error(w, "Valid user ID is required", http.StatusBadRequest)
 		return
 	}